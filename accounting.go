@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/coreos/go-systemd/unit"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+)
+
+// accountingAnnotation turns on accounting by systemd for the container unit, a comma separated list of "cpu", "io"
+// and "ip", or "all". The counters systemd keeps are added to the extended stats of the container, see
+// options.UnitAccounting.
+// IP accounting counts the traffic of the sockets of the unit's processes, which gives per container network byte
+// counts without a CNI metrics plugin.
+const accountingAnnotation = shimName + ".accounting"
+
+// accountingSettings are the unit settings turning on each kind of accounting.
+var accountingSettings = map[string]string{
+	"cpu": "CPUAccounting",
+	"io":  "IOAccounting",
+	"ip":  "IPAccounting",
+}
+
+// parseAccounting parses a list of accounting kinds.
+func parseAccounting(kinds []string) ([]string, error) {
+	set := make(map[string]bool)
+	for _, k := range kinds {
+		k = strings.ToLower(strings.TrimSpace(k))
+		switch {
+		case k == "":
+		case k == "all":
+			for k := range accountingSettings {
+				set[k] = true
+			}
+		case accountingSettings[k] != "":
+			set[k] = true
+		default:
+			return nil, fmt.Errorf("invalid accounting %q: %w", k, errdefs.ErrInvalidArgument)
+		}
+	}
+
+	var out []string
+	for k := range set {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	if set["ip"] && rootless {
+		// IP accounting uses BPF programs the user's systemd instance can't attach.
+		return nil, fmt.Errorf("ip accounting is not supported in rootless mode: %w", errdefs.ErrNotImplemented)
+	}
+	return out, nil
+}
+
+func accountingAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	v := annotations[accountingAnnotation]
+	if v == "" {
+		return nil
+	}
+	kinds, err := parseAccounting(strings.Split(v, ","))
+	if err != nil {
+		return fmt.Errorf("annotation %s: %w", accountingAnnotation, err)
+	}
+	opts.Accounting = kinds
+	return nil
+}
+
+// accountingOptions returns the unit options turning on the accounting of the container unit.
+func (p *initProcess) accountingOptions() []*unit.UnitOption {
+	var opts []*unit.UnitOption
+	for _, k := range p.opts.Accounting {
+		opts = append(opts, unit.NewUnitOption("Service", accountingSettings[k], "yes"))
+	}
+	return opts
+}
+
+func (p *initProcess) accounting(k string) bool {
+	for _, a := range p.opts.Accounting {
+		if a == k {
+			return true
+		}
+	}
+	return false
+}
+
+// unitAccounting returns the counters systemd keeps for the container unit.
+func (p *initProcess) unitAccounting(ctx context.Context) (*options.UnitAccounting, error) {
+	props, err := p.systemd.GetUnitTypePropertiesContext(ctx, p.Name(), "Service")
+	if err != nil {
+		return nil, err
+	}
+	// systemd reports UINT64_MAX for values it doesn't have
+	get := func(name string) uint64 {
+		v, ok := props[name].(uint64)
+		if !ok || v == math.MaxUint64 {
+			return 0
+		}
+		return v
+	}
+
+	var a options.UnitAccounting
+	if p.accounting("cpu") {
+		a.CpuUsageNsec = get("CPUUsageNSec")
+	}
+	if p.accounting("io") {
+		a.IoReadBytes = get("IOReadBytes")
+		a.IoWriteBytes = get("IOWriteBytes")
+		a.IoReadOperations = get("IOReadOperations")
+		a.IoWriteOperations = get("IOWriteOperations")
+	}
+	if p.accounting("ip") {
+		a.IpIngressBytes = get("IPIngressBytes")
+		a.IpEgressBytes = get("IPEgressBytes")
+		a.IpIngressPackets = get("IPIngressPackets")
+		a.IpEgressPackets = get("IPEgressPackets")
+	}
+	return &a, nil
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/coreos/go-systemd/unit"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+	"golang.org/x/sys/unix"
+)
+
+// benchNamespace is the namespace the units created by the benchmark are in.
+const benchNamespace = "shim-bench"
+
+// benchConfig configures benchCreate.
+type benchConfig struct {
+	Count    int
+	Parallel int
+	UnitMode options.UnitMode
+}
+
+// benchCreate measures how fast units are created against the local systemd, concurrently like on a node starting
+// many containers at once.
+// The units go through the same path as container units (process map, unit file, batched daemon-reload, start job)
+// but run /bin/true instead of runc, so the numbers are the shim and systemd overhead of a create.
+// The units are removed again once everything is measured.
+func benchCreate(ctx context.Context, w io.Writer, cfg benchConfig) error {
+	if cfg.Count <= 0 || cfg.Parallel <= 0 {
+		return fmt.Errorf("count and parallel must be positive")
+	}
+
+	conn, err := newSDConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var (
+		reloader  = newReloader(conn)
+		processes = newProcessManager()
+		prefix    = "bench-" + strconv.Itoa(os.Getpid()) + "-"
+
+		mu        sync.Mutex
+		latencies []time.Duration
+		created   []*initProcess
+		errs      int
+	)
+
+	ids := make(chan int)
+	go func() {
+		defer close(ids)
+		for i := 0; i < cfg.Count; i++ {
+			select {
+			case ids <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ids {
+				p := &initProcess{process: &process{
+					ns:       benchNamespace,
+					id:       prefix + strconv.Itoa(i),
+					systemd:  conn,
+					reloader: reloader,
+					opts:     CreateOptions{UnitMode: cfg.UnitMode},
+				}}
+				t := time.Now()
+				err := benchCreateUnit(ctx, processes, p)
+				d := time.Since(t)
+
+				mu.Lock()
+				if err != nil {
+					errs++
+					log.G(ctx).WithError(err).WithField("unit", p.Name()).Warn("Error creating unit")
+				} else {
+					latencies = append(latencies, d)
+				}
+				created = append(created, p)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	benchCleanup(ctx, conn, created)
+
+	if len(latencies) == 0 {
+		return fmt.Errorf("all %d creates failed", errs)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	pct := func(q float64) time.Duration {
+		return latencies[int(q*float64(len(latencies)-1))]
+	}
+
+	fmt.Fprintf(w, "creates:  %d (%d failed), %d parallel, %s units\n", len(latencies), errs, cfg.Parallel, unitModeString(cfg.UnitMode))
+	fmt.Fprintf(w, "elapsed:  %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "rate:     %.1f creates/sec\n", float64(len(latencies))/elapsed.Seconds())
+	fmt.Fprintf(w, "latency:  p50=%s p90=%s p99=%s max=%s\n",
+		pct(0.5).Round(time.Microsecond), pct(0.9).Round(time.Microsecond), pct(0.99).Round(time.Microsecond), latencies[len(latencies)-1].Round(time.Microsecond))
+	return nil
+}
+
+// benchCreateUnit creates and starts the unit for p and waits for the start job to finish.
+func benchCreateUnit(ctx context.Context, processes *processManager, p *initProcess) error {
+	if err := processes.Add(path.Join(p.ns, p.id), p); err != nil {
+		return err
+	}
+
+	name := p.Name()
+	opts := []*unit.UnitOption{
+		p.descriptionOption("containerd-shim-systemd-v1 bench " + p.id),
+		unit.NewUnitOption("Service", "Type", "oneshot"),
+		unit.NewUnitOption("Service", "RemainAfterExit", "yes"),
+		unit.NewUnitOption("Service", "ExecStart", "/bin/true"),
+	}
+	if err := p.installUnit(ctx, name, opts); err != nil {
+		return err
+	}
+
+	ch := make(chan string, 1)
+	if _, err := p.startUnitJob(ctx, name, ch); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case status := <-ch:
+		if status != "done" {
+			return fmt.Errorf("start job finished with %q", status)
+		}
+	}
+	return nil
+}
+
+// benchCleanup stops and removes the units created by the benchmark.
+func benchCleanup(ctx context.Context, conn *sdConn, ls []*initProcess) {
+	// Cleanup has to happen even if the benchmark was interrupted.
+	ctx = log.WithLogger(context.Background(), log.G(ctx))
+
+	for _, p := range ls {
+		name := p.Name()
+		ch := make(chan string, 1)
+		if _, err := conn.StopUnitContext(ctx, name, "replace", ch); err == nil {
+			<-ch
+		}
+		if err := conn.ResetFailedUnitContext(ctx, name); err != nil {
+			log.G(ctx).WithError(err).WithField("unit", name).Debug("Error resetting unit")
+		}
+		if !p.transient() {
+			if err := os.Remove(p.unitFilePath(name)); err != nil && !os.IsNotExist(err) {
+				log.G(ctx).WithError(err).WithField("unit", name).Warn("Error removing unit file")
+			}
+		}
+	}
+	if err := conn.ReloadContext(ctx); err != nil {
+		log.G(ctx).WithError(err).Warn("Error reloading systemd")
+	}
+}
+
+// benchIOConfig configures benchIO.
+type benchIOConfig struct {
+	Size       uint64
+	BufferSize uint64
+}
+
+// benchIO measures the throughput of the tty helper, the relay between the container pty and the stdio fifos.
+// A helper is started like for a container with a terminal and data is pushed through it as fast as it goes, from the
+// pty to the stdout fifo (container output, as read by `ctr task attach` or `docker logs -f`) and from the stdin fifo
+// to the pty, the latter with splice(2) and with the read/write fallback.
+func benchIO(ctx context.Context, w io.Writer, cfg benchIOConfig) error {
+	if cfg.Size == 0 {
+		return fmt.Errorf("size must be positive")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "size:     %d bytes\n", cfg.Size)
+	// The pty side is never spliced (see copy_splice in pty.c), so output only has one mode.
+	for _, run := range []struct {
+		name           string
+		output, splice bool
+	}{
+		{"output", true, true},
+		{"input (splice)", false, true},
+		{"input (read/write)", false, false},
+	} {
+		d, err := benchIOCopy(ctx, exe, run.output, run.splice, cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", run.name, err)
+		}
+		fmt.Fprintf(w, "%-20s %s, %.1f MiB/s\n", run.name+":", d.Round(time.Millisecond), float64(cfg.Size)/(1<<20)/d.Seconds())
+	}
+	return nil
+}
+
+// benchIOCopy relays cfg.Size bytes through a new tty helper, from the pty to stdout if output is set, from stdin
+// to the pty otherwise.
+func benchIOCopy(ctx context.Context, exe string, output, splice bool, cfg benchIOConfig) (time.Duration, error) {
+	ptm, pts, err := openPty()
+	if err != nil {
+		return 0, err
+	}
+	defer ptm.Close()
+	defer pts.Close()
+
+	// Data is passed unchanged, like with a container that set its terminal to raw mode.
+	termios, err := unix.IoctlGetTermios(int(pts.Fd()), unix.TCGETS)
+	if err != nil {
+		return 0, err
+	}
+	termios.Iflag &^= unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ICANON | unix.ISIG | unix.IEXTEN
+	if err := unix.IoctlSetTermios(int(pts.Fd()), unix.TCSETS, termios); err != nil {
+		return 0, err
+	}
+
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer stdinR.Close()
+	defer stdinW.Close()
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer stdoutR.Close()
+	defer stdoutW.Close()
+
+	sockPath := "@" + ttySocketPrefix + "bench-" + strconv.Itoa(os.Getpid())
+	cmd := exec.CommandContext(ctx, exe)
+	cmd.Env = []string{ttyHandshakeEnv + "=1", ttySockPathEnv + "=" + sockPath}
+	if cfg.BufferSize > 0 {
+		cmd.Env = append(cmd.Env, ttyBufferSizeEnv+"="+strconv.FormatUint(cfg.BufferSize, 10))
+	}
+	if !splice {
+		cmd.Env = append(cmd.Env, ttyNoSpliceEnv+"=1")
+	}
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	stdinR.Close()
+	stdoutW.Close()
+
+	// Hand the pty to the helper the way the runtime does.
+	var conn net.Conn
+	for i := 0; ; i++ {
+		conn, err = net.Dial("unix", sockPath)
+		if err == nil {
+			break
+		}
+		if i == 100 {
+			return 0, fmt.Errorf("tty helper did not come up: %w", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, _, err = conn.(*net.UnixConn).WriteMsgUnix([]byte("pty"), unix.UnixRights(int(ptm.Fd())), nil)
+	conn.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		src io.Writer = pts
+		dst io.Reader = stdoutR
+	)
+	if !output {
+		src, dst = stdinW, pts
+	}
+
+	buf := make([]byte, 64*1024)
+	for i := range buf {
+		buf[i] = 'a' + byte(i%26)
+	}
+
+	start := time.Now()
+	go func() {
+		for left := cfg.Size; left > 0; {
+			n := uint64(len(buf))
+			if left < n {
+				n = left
+			}
+			if _, err := src.Write(buf[:n]); err != nil {
+				return
+			}
+			left -= n
+		}
+	}()
+	if _, err := io.CopyN(io.Discard, dst, int64(cfg.Size)); err != nil {
+		return 0, fmt.Errorf("error reading relayed data: %w", err)
+	}
+	return time.Since(start), nil
+}
+
+// openPty opens a new pty pair.
+func openPty() (ptm, pts *os.File, _ error) {
+	ptm, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := unix.IoctlSetPointerInt(int(ptm.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		ptm.Close()
+		return nil, nil, err
+	}
+	n, err := unix.IoctlGetInt(int(ptm.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		ptm.Close()
+		return nil, nil, err
+	}
+	pts, err = os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		ptm.Close()
+		return nil, nil, err
+	}
+	return ptm, pts, nil
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Checkpoint images are OCI images with a single layer holding the checkpoint, as CRI-O and Podman create them, so
+// checkpoints can be pushed to and pulled from registries like any image.
+// The layer has the criu images in checkpoint/, next to what the engine that made it keeps with them (e.g. CRI-O's
+// config.dump and spec.dump). The image is exchanged as an OCI archive: a tar of an OCI image layout.
+
+// criOCheckpointNameAnnotation is set on the manifest of a checkpoint image to the name of the container, CRI-O uses
+// it to tell checkpoint images from others.
+const criOCheckpointNameAnnotation = "io.kubernetes.cri-o.annotations.checkpoint.name"
+
+const (
+	// checkpointImageDir is the directory of the criu images in the layer of a checkpoint image.
+	checkpointImageDir = "checkpoint"
+	// criuInventory is the first image criu writes, it tells a directory of criu images from a checkpoint with them
+	// in checkpointImageDir.
+	criuInventory = "inventory.img"
+	// checkpointImageUnpackDir is where a restore from a checkpoint image unpacks it, in the bundle.
+	checkpointImageUnpackDir = "checkpoint-image"
+
+	mediaTypeDockerLayerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// packCheckpointImage writes the checkpoint in dir to w as an OCI archive.
+// dir is either a directory of criu images, as the runtime checkpoints a container, or a checkpoint with the images in
+// checkpointImageDir. name is the name of the container the checkpoint is of, it is set as annotation of the image.
+// The "parent" link of an image that was built on a pre-dump is left out, the parent has to be passed as the parent
+// checkpoint on restore.
+func packCheckpointImage(ctx context.Context, w io.Writer, dir, name string) error {
+	prefix := ""
+	if _, err := os.Stat(filepath.Join(dir, criuInventory)); err == nil {
+		prefix = checkpointImageDir + "/"
+	}
+
+	layer, err := os.CreateTemp("", "checkpoint-layer-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		layer.Close()
+		os.Remove(layer.Name())
+	}()
+
+	layerDigest := digest.SHA256.Digester()
+	diffID := digest.SHA256.Digester()
+	counter := &countingWriter{w: io.MultiWriter(layer, layerDigest.Hash())}
+	gz := gzip.NewWriter(counter)
+	if err := writeLayerTar(ctx, io.MultiWriter(gz, diffID.Hash()), dir, prefix); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	created := time.Now().UTC()
+	config, err := json.Marshal(ocispec.Image{
+		Created:      &created,
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		RootFS: ocispec.RootFS{
+			Type:    "layers",
+			DiffIDs: []digest.Digest{diffID.Digest()},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	layerDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    layerDigest.Digest(),
+		Size:      counter.n,
+	}
+	configDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageConfig,
+		Digest:    digest.FromBytes(config),
+		Size:      int64(len(config)),
+	}
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{layerDesc},
+		Annotations: map[string]string{
+			criOCheckpointNameAnnotation: name,
+			ocispec.AnnotationCreated:    created.Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	manifestDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifest),
+		Size:      int64(len(manifest)),
+	}
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifestDesc},
+	})
+	if err != nil {
+		return err
+	}
+	layout, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{ocispec.ImageLayoutFile, layout},
+		{blobPath(configDesc.Digest), config},
+		{blobPath(manifestDesc.Digest), manifest},
+	} {
+		if err := writeTarFile(tw, f.name, int64(len(f.data)), bytes.NewReader(f.data)); err != nil {
+			return err
+		}
+	}
+	if _, err := layer.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, blobPath(layerDesc.Digest), layerDesc.Size, layer); err != nil {
+		return err
+	}
+	// The index goes last, so a reader knows about all the blobs once it gets to it.
+	if err := writeTarFile(tw, "index.json", int64(len(index)), bytes.NewReader(index)); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// packCheckpointImageFile packs the checkpoint into the file p, which is only created if this succeeds.
+func packCheckpointImageFile(ctx context.Context, p, dir, name string) (retErr error) {
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		if retErr != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err := packCheckpointImage(ctx, f, dir, name); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// writeLayerTar writes the tree at dir to w as a tar, with the names prefixed by prefix.
+func writeLayerTar(ctx context.Context, w io.Writer, dir, prefix string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			if prefix == "" {
+				return nil
+			}
+			rel = ""
+		}
+
+		var link string
+		switch {
+		case fi.Mode().IsRegular(), fi.IsDir():
+		case fi.Mode()&os.ModeSymlink != 0:
+			if filepath.Base(rel) == "parent" && filepath.Dir(filepath.Join(prefix, rel)) == checkpointImageDir {
+				log.G(ctx).WithField("path", p).Warn("Leaving out the parent of the checkpoint")
+				return nil
+			}
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		default:
+			// criu doesn't leave sockets or fifos in the images.
+			log.G(ctx).WithField("path", p).Debug("Skipping special file in checkpoint")
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uname, hdr.Gname = "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+func blobPath(d digest.Digest) string {
+	return path.Join("blobs", d.Algorithm().String(), d.Encoded())
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// unpackCheckpointImageFile unpacks the checkpoint image in the OCI archive p to dest, which must not exist.
+// It returns the directory of the criu images to restore from.
+func unpackCheckpointImageFile(ctx context.Context, p, dest string) (_ string, retErr error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return "", err
+	}
+	if err := os.Mkdir(dest, 0700); err != nil {
+		return "", err
+	}
+	defer func() {
+		if retErr != nil {
+			os.RemoveAll(dest)
+		}
+	}()
+
+	// The blobs of the archive can be in any order, they are extracted next to dest before the layers are applied.
+	layout, err := os.MkdirTemp(filepath.Dir(dest), ".checkpoint-layout-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(layout)
+	if err := extractTar(tar.NewReader(f), layout, true); err != nil {
+		return "", fmt.Errorf("error reading checkpoint archive: %w", err)
+	}
+
+	var index ocispec.Index
+	if err := readJSONBlob(filepath.Join(layout, "index.json"), "", &index); err != nil {
+		return "", err
+	}
+	var manifestDesc *ocispec.Descriptor
+	for i, d := range index.Manifests {
+		if d.MediaType == ocispec.MediaTypeImageManifest || d.MediaType == "" {
+			manifestDesc = &index.Manifests[i]
+			break
+		}
+	}
+	if manifestDesc == nil {
+		return "", fmt.Errorf("checkpoint archive has no image manifest: %w", errdefs.ErrInvalidArgument)
+	}
+	var manifest ocispec.Manifest
+	if err := readJSONBlob(filepath.Join(layout, blobPath(manifestDesc.Digest)), manifestDesc.Digest, &manifest); err != nil {
+		return "", err
+	}
+	if _, ok := manifest.Annotations[criOCheckpointNameAnnotation]; !ok {
+		log.G(ctx).WithField("path", p).Debug("Image has no checkpoint annotation")
+	}
+
+	for _, l := range manifest.Layers {
+		if err := applyLayer(layout, l, dest); err != nil {
+			return "", fmt.Errorf("error unpacking layer %s: %w", l.Digest, err)
+		}
+	}
+
+	image := filepath.Join(dest, checkpointImageDir)
+	if fi, err := os.Stat(image); err == nil && fi.IsDir() {
+		return image, nil
+	}
+	if _, err := os.Stat(filepath.Join(dest, criuInventory)); err != nil {
+		return "", fmt.Errorf("image is not a checkpoint, it has no criu images: %w", errdefs.ErrInvalidArgument)
+	}
+	return dest, nil
+}
+
+func readJSONBlob(p string, dgst digest.Digest, v interface{}) error {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return fmt.Errorf("invalid checkpoint archive: %v: %w", err, errdefs.ErrInvalidArgument)
+	}
+	if dgst != "" && digest.FromBytes(data) != dgst {
+		return fmt.Errorf("blob %s does not match its digest: %w", dgst, errdefs.ErrInvalidArgument)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// applyLayer extracts the layer blob in layout to dest, the blob is checked against its digest.
+func applyLayer(layout string, desc ocispec.Descriptor, dest string) error {
+	if err := desc.Digest.Validate(); err != nil {
+		return fmt.Errorf("%v: %w", err, errdefs.ErrInvalidArgument)
+	}
+	f, err := os.Open(filepath.Join(layout, blobPath(desc.Digest)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	verifier := desc.Digest.Verifier()
+	var r io.Reader = io.TeeReader(f, verifier)
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageLayer:
+	case ocispec.MediaTypeImageLayerGzip, mediaTypeDockerLayerGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	default:
+		return fmt.Errorf("layer media type %s: %w", desc.MediaType, errdefs.ErrNotImplemented)
+	}
+
+	if err := extractTar(tar.NewReader(r), dest, false); err != nil {
+		return err
+	}
+	// Read what is left after the tar so all of the blob is verified.
+	if _, err := io.Copy(io.Discard, f); err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("layer does not match its digest: %w", errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// extractTar extracts the tar to dest, which must be empty. Entries can't point outside of dest.
+// With filesOnly only regular files are extracted, as needed for an OCI layout.
+func extractTar(tr *tar.Reader, dest string, filesOnly bool) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		target := filepath.Join(dest, filepath.FromSlash(name))
+		if err := checkTarParents(dest, filepath.Dir(target)); err != nil {
+			return err
+		}
+		if filesOnly && hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeLink:
+			src := filepath.Join(dest, filepath.FromSlash(path.Clean("/"+hdr.Linkname)))
+			if err := checkTarParents(dest, filepath.Dir(src)); err != nil {
+				return err
+			}
+			if err := os.Link(src, target); err != nil {
+				return err
+			}
+			continue
+		default:
+			// Checkpoints don't have devices or fifos.
+			continue
+		}
+		os.Chtimes(target, hdr.AccessTime, hdr.ModTime)
+	}
+}
+
+// checkTarParents makes sure that dir, a directory in dest, doesn't go through a symlink extracted before, which could
+// point anywhere.
+func checkTarParents(dest, dir string) error {
+	rel, err := filepath.Rel(dest, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("tar entry %s is outside of the destination: %w", dir, errdefs.ErrInvalidArgument)
+	}
+	p := dest
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("tar entry in %s goes through a symlink: %w", p, errdefs.ErrInvalidArgument)
+		}
+	}
+	return nil
+}
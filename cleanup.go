@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/go-runc"
+	"golang.org/x/sys/unix"
+)
+
+// isBusy returns true if err is an EBUSY error.
+// runc only gives us its error output, so the message is checked as well.
+func isBusy(err error) bool {
+	return errors.Is(err, unix.EBUSY) || strings.Contains(err.Error(), "device or resource busy")
+}
+
+// unmountRetry unmounts everything at target.
+// If the mounts are still busy after retrying they are lazily unmounted so nothing is left behind.
+func unmountRetry(ctx context.Context, target string) error {
+	err := retry(ctx, "unmount", func(context.Context) error {
+		return mount.UnmountAll(target, 0)
+	}, nil)
+	if err == nil || !isBusy(err) {
+		return err
+	}
+
+	log.G(ctx).WithError(err).WithField("target", target).Warn("Mount is still busy, detaching it")
+	return mount.UnmountAll(target, unix.MNT_DETACH)
+}
+
+// deleteContainer deletes the container from runc, it is retried while its cgroup or mounts are busy.
+func (p *initProcess) deleteContainer(ctx context.Context) error {
+	return p.runtimeDelete(ctx)
+}
+
+// runtimeDelete force deletes the container from the runtime.
+func (p *initProcess) runtimeDelete(ctx context.Context) error {
+	return runcCall(ctx, func(ctx context.Context) error {
+		return p.runc.Delete(ctx, p.id, &runc.DeleteOpts{Force: true})
+	})
+}
+
+// cleanupRootfs unmounts the container rootfs.
+// The mounts are normally gone already, either with the unit's private mount namespace or through ExecStopPost, but
+// that is not the case if the unit was killed before it got that far.
+// Everything recorded by mountFS is unmounted, in reverse order.
+func (p *initProcess) cleanupRootfs(ctx context.Context) error {
+	if len(p.Rootfs) == 0 {
+		return nil
+	}
+
+	targets := []string{filepath.Join(p.Bundle, "rootfs")}
+	active, err := readActiveMounts(p.Bundle)
+	if err != nil && !os.IsNotExist(err) {
+		log.G(ctx).WithError(err).Warn("Error reading active mounts")
+	}
+	for i := len(active) - 1; i >= 0; i-- {
+		targets = append(targets, active[i].Target)
+	}
+	for _, t := range targets {
+		if err := unmountRetry(ctx, t); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range []string{p.mountConfigPath(), filepath.Join(p.Bundle, activeMountsName)} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanupFiles removes the files the shim created for the container outside of the bundle and the runc state.
+func (p *initProcess) cleanupFiles(ctx context.Context) {
+	for _, f := range []string{p.pidFile(), p.exitStatePath()} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			log.G(ctx).WithError(err).WithField("path", f).Debug("Error removing container file")
+		}
+	}
+	removeTTYSock(ctx, filepath.Join(p.root, "tty.sock"))
+
+	// runc removes its state on delete, unless the delete failed halfway.
+	if err := os.RemoveAll(filepath.Join(p.runc.Root, p.id)); err != nil {
+		log.G(ctx).WithError(err).Debug("Error removing runc state")
+	}
+	p.cleanupStateDir(ctx)
+}
+
+// removeTTYSock removes the tty socket recorded in the info file written by ttySockPath, and the info file itself.
+func removeTTYSock(ctx context.Context, infoPath string) {
+	b, err := os.ReadFile(infoPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.G(ctx).WithError(err).Debug("Error reading tty socket path")
+		}
+		return
+	}
+	// The socket is in a temp dir of its own, see ttySockPath.
+	if dir := filepath.Dir(string(b)); strings.HasPrefix(filepath.Base(dir), "pty") {
+		if err := os.RemoveAll(dir); err != nil {
+			log.G(ctx).WithError(err).Debug("Error removing tty socket")
+		}
+	}
+	os.Remove(infoPath)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// Annotations to have the shim set up the network of the container with CNI, for containers created with ctr or
+// other clients that don't run CNI themselves. CRI containers are networked by the CRI plugin.
+//
+// cniConfDirAnnotation enables it, the value is the directory with the network configs (.conflist, .conf or .json
+// files), e.g. "/etc/cni/net.d". The network named by cniNetworkAnnotation is used, the first config in the directory
+// in lexical order otherwise. Plugins are looked up in the colon separated cniBinDirAnnotation directories, the
+// container side interface is named after cniIfNameAnnotation.
+//
+// CNI ADD is run once the runtime created the container and its network namespace, a failure fails the create. CNI
+// DEL is run when the container is deleted. What was set up is recorded in the shim's root, networks of containers
+// that are gone when the shim starts, e.g. after it crashed, are torn down then.
+const (
+	cniConfDirAnnotation = shimName + ".cni-conf-dir"
+	cniNetworkAnnotation = shimName + ".cni-network"
+	cniBinDirAnnotation  = shimName + ".cni-bin-dir"
+	cniIfNameAnnotation  = shimName + ".cni-ifname"
+)
+
+const (
+	defaultCNIBinDir = "/opt/cni/bin"
+	defaultCNIIfName = "eth0"
+	// cniPluginTimeout bounds each plugin invocation.
+	cniPluginTimeout = 30 * time.Second
+)
+
+// cniNetwork is a network config, single plugin configs are converted to a list of one.
+type cniNetwork struct {
+	CNIVersion string            `json:"cniVersion"`
+	Name       string            `json:"name"`
+	Plugins    []json.RawMessage `json:"plugins"`
+}
+
+// cniRecord is what is needed to tear down the network of a container.
+// It is written before ADD, so a network is torn down even if the shim went away in the middle of setting it up.
+type cniRecord struct {
+	ContainerID string
+	IfName      string
+	BinDirs     []string
+	Network     *cniNetwork
+	// Result is the result of ADD, passed to DEL as the previous result.
+	Result json.RawMessage `json:",omitempty"`
+}
+
+func cniAnnotations(spec *specs.Spec, opts *CreateOptions) error {
+	a := spec.Annotations
+	opts.CNIConfDir = a[cniConfDirAnnotation]
+	if opts.CNIConfDir == "" {
+		for _, k := range []string{cniNetworkAnnotation, cniBinDirAnnotation, cniIfNameAnnotation} {
+			if a[k] != "" {
+				return fmt.Errorf("annotation %s requires %s: %w", k, cniConfDirAnnotation, errdefs.ErrInvalidArgument)
+			}
+		}
+		return nil
+	}
+
+	if rootless {
+		return fmt.Errorf("annotation %s: CNI is not supported in rootless mode: %w", cniConfDirAnnotation, errdefs.ErrNotImplemented)
+	}
+	if opts.CRI.Type != "" {
+		return fmt.Errorf("annotation %s: CRI containers are networked by the CRI plugin: %w", cniConfDirAnnotation, errdefs.ErrInvalidArgument)
+	}
+	if !newNetworkNamespace(spec) {
+		return fmt.Errorf("annotation %s: the container needs a network namespace of its own: %w", cniConfDirAnnotation, errdefs.ErrInvalidArgument)
+	}
+
+	opts.CNINetwork = a[cniNetworkAnnotation]
+	opts.CNIIfName = a[cniIfNameAnnotation]
+	if opts.CNIIfName == "" {
+		opts.CNIIfName = defaultCNIIfName
+	}
+	opts.CNIBinDirs = []string{defaultCNIBinDir}
+	if v := a[cniBinDirAnnotation]; v != "" {
+		opts.CNIBinDirs = filepath.SplitList(v)
+	}
+
+	// Fail the create now rather than after the container was created.
+	if _, err := loadCNINetwork(opts.CNIConfDir, opts.CNINetwork); err != nil {
+		return fmt.Errorf("annotation %s: %w", cniConfDirAnnotation, err)
+	}
+	return nil
+}
+
+// newNetworkNamespace reports if the runtime creates a network namespace for the container.
+func newNetworkNamespace(spec *specs.Spec) bool {
+	if spec.Linux == nil {
+		return false
+	}
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == specs.NetworkNamespace {
+			return ns.Path == ""
+		}
+	}
+	return false
+}
+
+// loadCNINetwork reads the network config named name in dir, or the first one if name is empty.
+func loadCNINetwork(dir, name string) (*cniNetwork, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CNI config dir: %w", err)
+	}
+	var files []string
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case ".conflist", ".conf", ".json":
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var n cniNetwork
+		if err := json.Unmarshal(data, &n); err != nil {
+			return nil, fmt.Errorf("error parsing CNI config %s: %v: %w", f, err, errdefs.ErrInvalidArgument)
+		}
+		if filepath.Ext(f) != ".conflist" {
+			n.Plugins = []json.RawMessage{data}
+		}
+		if name != "" && n.Name != name {
+			continue
+		}
+		if len(n.Plugins) == 0 {
+			return nil, fmt.Errorf("CNI config %s has no plugins: %w", f, errdefs.ErrInvalidArgument)
+		}
+		return &n, nil
+	}
+	if name != "" {
+		return nil, fmt.Errorf("CNI network %q not found in %s: %w", name, dir, errdefs.ErrNotFound)
+	}
+	return nil, fmt.Errorf("no CNI network config in %s: %w", dir, errdefs.ErrNotFound)
+}
+
+// cniStatePath returns the path of the CNI state of a container without extension.
+// The record is in <path>.json, the network namespace of the container is bind mounted to <path>.netns.
+func (s *Service) cniStatePath(ns, id string) string {
+	return filepath.Join(s.root, "cni", ns, id)
+}
+
+// cniAdd sets up the network of the container.
+// The network namespace is bind mounted so it can still be torn down properly after the container exited.
+func (p *initProcess) cniAdd(ctx context.Context, pid uint32) (retErr error) {
+	if p.opts.CNIConfDir == "" {
+		return nil
+	}
+	ctx, span := StartSpan(ctx, "InitProcess.CNIAdd")
+	defer span.End()
+
+	if pid == 0 {
+		return fmt.Errorf("container has no pid to set up the network for: %w", errdefs.ErrFailedPrecondition)
+	}
+	n, err := loadCNINetwork(p.opts.CNIConfDir, p.opts.CNINetwork)
+	if err != nil {
+		return err
+	}
+	rec := &cniRecord{ContainerID: p.id, IfName: p.opts.CNIIfName, BinDirs: p.opts.CNIBinDirs, Network: n}
+
+	if err := os.MkdirAll(filepath.Dir(p.cniState), 0700); err != nil {
+		return err
+	}
+	if err := writeCNIRecord(p.cniState, rec); err != nil {
+		return err
+	}
+	netns := p.cniState + ".netns"
+	if err := bindNetns(netns, "/proc/"+strconv.Itoa(int(pid))+"/ns/net"); err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			p.cniDel(ctx)
+		}
+	}()
+
+	result, err := cniAddNetwork(ctx, rec, netns)
+	if err != nil {
+		return fmt.Errorf("error setting up CNI network %s: %w", n.Name, err)
+	}
+	rec.Result = result
+	if err := writeCNIRecord(p.cniState, rec); err != nil {
+		return err
+	}
+	log.G(ctx).WithField("network", n.Name).Debug("Set up CNI network")
+	return nil
+}
+
+// cniDel tears down the network of the container.
+// The record is kept if it fails, the shim tries again when it starts, see collectCNI.
+func (p *initProcess) cniDel(ctx context.Context) {
+	if p.opts.CNIConfDir == "" || p.cniState == "" {
+		return
+	}
+	if err := cniTeardown(ctx, p.cniState); err != nil {
+		log.G(ctx).WithError(err).Warn("Error tearing down CNI network")
+	}
+}
+
+// collectCNI tears down the networks of containers that don't exist anymore. This must be called after Recover.
+func (s *Service) collectCNI(ctx context.Context) {
+	records, _ := filepath.Glob(filepath.Join(s.root, "cni", "*", "*.json"))
+	for _, r := range records {
+		state := strings.TrimSuffix(r, ".json")
+		ns, id := filepath.Base(filepath.Dir(state)), filepath.Base(state)
+		if s.processes.Get(ns+"/"+id) != nil {
+			continue
+		}
+		ctx := log.WithLogger(ctx, log.G(ctx).WithField("ns", ns).WithField("id", id))
+		log.G(ctx).Info("Tearing down CNI network of removed container")
+		if err := cniTeardown(ctx, state); err != nil {
+			log.G(ctx).WithError(err).Warn("Error tearing down CNI network")
+		}
+	}
+}
+
+// cniTeardown runs DEL with the record at state and removes the state once it succeeded.
+// The network namespace is only passed when it is still around, plugins clean up what is outside of it without.
+func cniTeardown(ctx context.Context, state string) error {
+	data, err := os.ReadFile(state + ".json")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var rec cniRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return fmt.Errorf("error parsing CNI record: %w", err)
+	}
+
+	netns := state + ".netns"
+	var fs unix.Statfs_t
+	if err := unix.Statfs(netns, &fs); err != nil || fs.Type != unix.NSFS_MAGIC {
+		netns = ""
+	}
+	if err := cniDelNetwork(ctx, &rec, netns); err != nil {
+		return err
+	}
+
+	if err := unix.Unmount(state+".netns", unix.MNT_DETACH); err != nil && err != unix.EINVAL && err != unix.ENOENT {
+		log.G(ctx).WithError(err).Debug("Error unmounting network namespace")
+	}
+	for _, f := range []string{state + ".netns", state + ".json"} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCNIRecord(state string, rec *cniRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	tmp := state + ".json.tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, state+".json"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// bindNetns bind mounts the network namespace at src to target.
+func bindNetns(target, src string) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := unix.Mount(src, target, "", unix.MS_BIND, ""); err != nil {
+		os.Remove(target)
+		return fmt.Errorf("error bind mounting network namespace: %w", err)
+	}
+	return nil
+}
+
+// cniAddNetwork runs ADD for the plugins of the network in order and returns the result of the last one.
+func cniAddNetwork(ctx context.Context, rec *cniRecord, netns string) (json.RawMessage, error) {
+	var result json.RawMessage
+	for _, pl := range rec.Network.Plugins {
+		out, err := invokeCNIPlugin(ctx, "ADD", rec, netns, pl, result)
+		if err != nil {
+			return nil, err
+		}
+		result = out
+	}
+	return result, nil
+}
+
+// cniDelNetwork runs DEL for the plugins of the network in reverse order.
+// All plugins are run, the first error is returned.
+func cniDelNetwork(ctx context.Context, rec *cniRecord, netns string) error {
+	var retErr error
+	for i := len(rec.Network.Plugins) - 1; i >= 0; i-- {
+		if _, err := invokeCNIPlugin(ctx, "DEL", rec, netns, rec.Network.Plugins[i], rec.Result); err != nil && retErr == nil {
+			retErr = err
+		}
+	}
+	return retErr
+}
+
+// invokeCNIPlugin runs a plugin as the CNI spec describes: the command and container in the environment, the plugin
+// config with the network name, version and previous result on stdin, the result on stdout.
+func invokeCNIPlugin(ctx context.Context, command string, rec *cniRecord, netns string, plugin, prevResult json.RawMessage) ([]byte, error) {
+	var conf map[string]interface{}
+	if err := json.Unmarshal(plugin, &conf); err != nil {
+		return nil, fmt.Errorf("invalid CNI plugin config: %w", err)
+	}
+	typ, _ := conf["type"].(string)
+	if typ == "" || filepath.Base(typ) != typ {
+		return nil, fmt.Errorf("invalid CNI plugin type %q: %w", typ, errdefs.ErrInvalidArgument)
+	}
+	conf["name"] = rec.Network.Name
+	conf["cniVersion"] = rec.Network.CNIVersion
+	if len(prevResult) > 0 {
+		conf["prevResult"] = prevResult
+	} else {
+		delete(conf, "prevResult")
+	}
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	var bin string
+	for _, d := range rec.BinDirs {
+		if _, err := os.Stat(filepath.Join(d, typ)); err == nil {
+			bin = filepath.Join(d, typ)
+			break
+		}
+	}
+	if bin == "" {
+		return nil, fmt.Errorf("CNI plugin %s not found in %s: %w", typ, strings.Join(rec.BinDirs, ":"), errdefs.ErrNotFound)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cniPluginTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin)
+	cmd.Env = append(os.Environ(),
+		"CNI_COMMAND="+command,
+		"CNI_CONTAINERID="+rec.ContainerID,
+		"CNI_NETNS="+netns,
+		"CNI_IFNAME="+rec.IfName,
+		"CNI_ARGS=IgnoreUnknown=1",
+		"CNI_PATH="+strings.Join(rec.BinDirs, string(filepath.ListSeparator)),
+	)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// Plugins report errors as JSON on stdout.
+		var perr struct {
+			Code    int    `json:"code"`
+			Msg     string `json:"msg"`
+			Details string `json:"details"`
+		}
+		if json.Unmarshal(stdout.Bytes(), &perr) == nil && perr.Msg != "" {
+			return nil, fmt.Errorf("CNI plugin %s %s: %s (code %d) %s", typ, command, perr.Msg, perr.Code, perr.Details)
+		}
+		return nil, fmt.Errorf("CNI plugin %s %s: %w: %s", typ, command, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	log.G(ctx).WithField("plugin", typ).WithField("command", command).Debug("Ran CNI plugin")
+	return bytes.TrimSpace(stdout.Bytes()), nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/containerd/cgroups"
+	"github.com/containerd/containerd/log"
+	"github.com/coreos/go-systemd/unit"
+)
+
+// The shim config and the drop-ins of the host admin (see overridesDir) can be reloaded without restarting the shim,
+// with SIGHUP or a POST to /reload on the debug address. New containers get the new defaults. The options running
+// containers took from the config because they did not set them (see configDefaults) follow the new config: their unit
+// is rendered again, which applies when the unit is next started. The drop-ins are linked again, and the properties in
+// them that can be changed on a running unit (see runtimeProperties) are applied right away. Nothing is restarted.
+// The timeouts and the retry policy are only read on start.
+
+// runtimeProperties are the properties that can be changed on a running unit with SetUnitProperties.
+var runtimeProperties = map[string]bool{
+	"CPUWeight":                     true,
+	"StartupCPUWeight":              true,
+	"CPUQuota":                      true,
+	"AllowedCPUs":                   true,
+	"AllowedMemoryNodes":            true,
+	"MemoryMin":                     true,
+	"MemoryLow":                     true,
+	"MemoryHigh":                    true,
+	"MemoryMax":                     true,
+	"MemorySwapMax":                 true,
+	"TasksMax":                      true,
+	"IOWeight":                      true,
+	"StartupIOWeight":               true,
+	"CPUAccounting":                 true,
+	"MemoryAccounting":              true,
+	"IOAccounting":                  true,
+	"IPAccounting":                  true,
+	"TasksAccounting":               true,
+	"ManagedOOMMemoryPressure":      true,
+	"ManagedOOMMemoryPressureLimit": true,
+	"ManagedOOMSwap":                true,
+}
+
+// currentConfig returns the shim config in use.
+func (s *Service) currentConfig() *shimConfig {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	return s.config
+}
+
+// handleReload reloads the config on SIGHUP until ctx is done.
+func (s *Service) handleReload(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.G(ctx).Info("Received SIGHUP, reloading config")
+			if err := s.reloadConfig(ctx); err != nil {
+				log.G(ctx).WithError(err).Error("Error reloading config")
+			}
+		}
+	}
+}
+
+// reloadConfig reads the shim config again and brings the units and drop-ins of the running containers up to date.
+// An invalid config is not used, the shim keeps the one it has.
+func (s *Service) reloadConfig(ctx context.Context) error {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	cfg, err := loadShimConfig(s.configFile)
+	if err != nil {
+		metrics.configReloads.Inc(resultLabel(err))
+		return err
+	}
+	s.configMu.Lock()
+	s.config = cfg
+	s.configMu.Unlock()
+
+	var ps []*initProcess
+	s.processes.Each(func(p Process) {
+		if p, ok := p.(*initProcess); ok && !p.ProcessState().Exited() {
+			ps = append(ps, p)
+		}
+	})
+
+	failed := make(map[*initProcess]bool)
+	for _, p := range ps {
+		ctx := log.WithLogger(ctx, log.G(ctx).WithField("id", p.id).WithField("ns", p.ns))
+		if err := p.reloadUnit(ctx, cfg.namespace(p.ns), s.defaultLogMode.String()); err != nil {
+			log.G(ctx).WithError(err).Warn("Error rendering unit")
+			failed[p] = true
+		}
+	}
+	if len(ps) > 0 {
+		if err := s.reloader.Reload(ctx); err != nil {
+			log.G(ctx).WithError(err).Warn("Error reloading systemd")
+		}
+	}
+	for _, p := range ps {
+		ctx := log.WithLogger(ctx, log.G(ctx).WithField("id", p.id).WithField("ns", p.ns))
+		if err := p.applyDropIns(ctx); err != nil {
+			log.G(ctx).WithError(err).Warn("Error applying unit drop-ins")
+			failed[p] = true
+		}
+	}
+
+	if len(failed) > 0 {
+		err = fmt.Errorf("error reloading the units of %d containers", len(failed))
+	}
+	metrics.configReloads.Inc(resultLabel(err))
+	log.G(ctx).WithField("containers", len(ps)).Info("Reloaded config")
+	return err
+}
+
+// applyConfig sets the options the container took from the shim config to the ones of cfg.
+// It returns true if any of them changed.
+func (p *initProcess) applyConfig(cfg namespaceConfig, defaultLogMode string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	opts := p.opts
+	if opts.ConfigDefaults.LogMode {
+		opts.LogMode = ""
+	}
+	if opts.ConfigDefaults.Slice {
+		opts.Slice = ""
+	}
+	cfg.apply(&opts)
+	if opts.LogMode == "" {
+		opts.LogMode = defaultLogMode
+	}
+	if validateTeeStdio(opts.LogMode, p.Stdout, p.Stderr, p.Terminal) != nil {
+		opts.LogMode = p.opts.LogMode
+	}
+
+	if opts.LogMode == p.opts.LogMode && opts.Slice == p.opts.Slice {
+		return false
+	}
+	// The runtime root, binary and cgroup driver are part of the runtime state of the container and don't change.
+	p.opts.LogMode = opts.LogMode
+	p.opts.Slice = opts.Slice
+	return true
+}
+
+// reloadUnit brings the unit of the running container up to date with the shim config and links the drop-ins that
+// apply to it again. The unit is rendered again if the options it took from the config changed, systemd uses it the
+// next time the unit starts. systemd must be reloaded afterwards.
+func (p *initProcess) reloadUnit(ctx context.Context, cfg namespaceConfig, defaultLogMode string) error {
+	if err := p.linkOverrides(ctx); err != nil {
+		return err
+	}
+	if !p.applyConfig(cfg, defaultLogMode) {
+		return nil
+	}
+	if err := p.saveTask(p); err != nil {
+		return err
+	}
+	if len(p.runtimeArgs) == 0 {
+		log.G(ctx).Debug("Unit was installed by an older shim, it is not rendered again")
+		return nil
+	}
+
+	if p.resources == nil {
+		// The resource options are not kept across restarts of the shim.
+		spec, err := p.bundleSpec()
+		if err != nil {
+			return err
+		}
+		if p.resources, err = resourceOptions(spec, cgroups.Mode() == cgroups.Unified); err != nil {
+			return err
+		}
+	}
+	opts, err := p.startOptions(p.runtimeArgs)
+	if err != nil {
+		return err
+	}
+	if p.transient() {
+		props, err := unitProperties(p.Name(), opts)
+		if err != nil {
+			return err
+		}
+		p.unitProps = props
+		return nil
+	}
+	if err := writeUnit(p.Name(), opts); err != nil {
+		return err
+	}
+	log.G(ctx).WithField("log-mode", p.opts.LogMode).WithField("slice", p.opts.Slice).Debug("Rendered unit")
+	return nil
+}
+
+// applyDropIns sets the properties from the drop-ins of the running container that can be changed on a running unit.
+// The properties of the unit itself are not set again, they may have been changed since with Update.
+func (p *initProcess) applyDropIns(ctx context.Context) error {
+	opts, err := readDropIns(dropInDir(p.Name()))
+	if err != nil {
+		return err
+	}
+	// Later drop-ins override earlier ones, an empty value resets the property to what the unit has, which is left as
+	// it is.
+	last := make(map[string]*unit.UnitOption)
+	for _, o := range opts {
+		if o.Section != "Service" || !runtimeProperties[o.Name] {
+			continue
+		}
+		if o.Value == "" {
+			delete(last, o.Name)
+			continue
+		}
+		last[o.Name] = o
+	}
+	if len(last) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(last))
+	for name := range last {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	set := make([]*unit.UnitOption, 0, len(names))
+	for _, name := range names {
+		set = append(set, last[name])
+	}
+
+	props, err := unitProperties(p.Name(), set)
+	if err != nil {
+		return err
+	}
+	if err := p.systemd.SetUnitPropertiesContext(ctx, p.Name(), true, props...); err != nil {
+		return fmt.Errorf("error setting unit properties: %w", err)
+	}
+	log.G(ctx).WithField("properties", names).Debug("Applied unit drop-ins")
+	return nil
+}
+
+// readDropIns returns the options of the drop-ins in dir, in the order systemd applies them.
+func readDropIns(dir string) ([]*unit.UnitOption, error) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.conf"))
+	sort.Strings(files)
+
+	var opts []*unit.UnitOption
+	for _, f := range files {
+		fd, err := os.Open(f)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// A dangling link, the drop-in was removed.
+				continue
+			}
+			return nil, err
+		}
+		o, err := unit.Deserialize(fd)
+		fd.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading drop-in %s: %w", f, err)
+		}
+		opts = append(opts, o...)
+	}
+	return opts, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestApplyConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    CreateOptions
+		stdout  string
+		cfg     namespaceConfig
+		want    CreateOptions
+		changed bool
+	}{
+		{
+			name:    "defaults follow the config",
+			opts:    CreateOptions{LogMode: "STDIO", Slice: "old.slice", ConfigDefaults: configDefaults{LogMode: true, Slice: true}},
+			cfg:     namespaceConfig{LogMode: "journald", Slice: "new.slice"},
+			want:    CreateOptions{LogMode: "JOURNALD", Slice: "new.slice", ConfigDefaults: configDefaults{LogMode: true, Slice: true}},
+			changed: true,
+		},
+		{
+			name:    "back to the shim defaults",
+			opts:    CreateOptions{LogMode: "JOURNALD", Slice: "old.slice", ConfigDefaults: configDefaults{LogMode: true, Slice: true}},
+			want:    CreateOptions{LogMode: "STDIO", ConfigDefaults: configDefaults{LogMode: true, Slice: true}},
+			changed: true,
+		},
+		{
+			name: "set by the container",
+			opts: CreateOptions{LogMode: "STDIO", Slice: "pod.slice"},
+			cfg:  namespaceConfig{LogMode: "journald", Slice: "new.slice"},
+			want: CreateOptions{LogMode: "STDIO", Slice: "pod.slice"},
+		},
+		{
+			name: "unchanged",
+			opts: CreateOptions{LogMode: "JOURNALD", ConfigDefaults: configDefaults{LogMode: true}},
+			cfg:  namespaceConfig{LogMode: "journald"},
+			want: CreateOptions{LogMode: "JOURNALD", ConfigDefaults: configDefaults{LogMode: true}},
+		},
+		{
+			name:   "tee not supported with the stdio",
+			opts:   CreateOptions{LogMode: "STDIO", ConfigDefaults: configDefaults{LogMode: true}},
+			stdout: "binary:///usr/bin/logger",
+			cfg:    namespaceConfig{LogMode: "tee"},
+			want:   CreateOptions{LogMode: "STDIO", ConfigDefaults: configDefaults{LogMode: true}},
+		},
+		{
+			name: "runtime settings are kept",
+			opts: CreateOptions{LogMode: "STDIO", Root: "/run/old", BinaryName: "runc", ConfigDefaults: configDefaults{LogMode: true}},
+			cfg:  namespaceConfig{LogMode: "stdio", RuncRoot: "/run/new", BinaryName: "crun", SystemdCgroup: true},
+			want: CreateOptions{LogMode: "STDIO", Root: "/run/old", BinaryName: "runc", ConfigDefaults: configDefaults{LogMode: true}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &initProcess{process: &process{opts: tc.opts, Stdout: tc.stdout}}
+			if changed := p.applyConfig(tc.cfg, "STDIO"); changed != tc.changed {
+				t.Errorf("changed = %v, want %v", changed, tc.changed)
+			}
+			if p.opts.LogMode != tc.want.LogMode || p.opts.Slice != tc.want.Slice || p.opts.Root != tc.want.Root || p.opts.BinaryName != tc.want.BinaryName || p.opts.SystemdCgroup {
+				t.Errorf("got %+v, want %+v", p.opts, tc.want)
+			}
+		})
+	}
+}
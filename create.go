@@ -48,6 +48,7 @@ func (s *Service) Create(ctx context.Context, r *taskapi.CreateTaskRequest) (_ *
 
 	ctx, span := StartSpan(ctx, "service.Create", trace.WithAttributes(attribute.String(nsAttr, ns), attribute.String(cIDAttr, r.ID)))
 	defer func() {
+		metrics.creates.Inc(ns, resultLabel(retErr))
 		if retErr != nil {
 			retErr = errdefs.ToGRPCf(retErr, "create")
 			span.SetStatus(codes.Error, retErr.Error())
@@ -56,6 +57,17 @@ func (s *Service) Create(ctx context.Context, r *taskapi.CreateTaskRequest) (_ *
 	}()
 
 	ctx = log.WithLogger(ctx, log.G(ctx).WithField("id", r.ID).WithField("ns", ns))
+
+	release, err := s.reserveContainer(ns, r.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := validateStdio(r.Stdout, r.Stderr, r.Terminal); err != nil {
+		return nil, err
+	}
+
 	shimLog := OpenShimLog(ctx, r.Bundle)
 	ctx = WithShimLog(ctx, shimLog)
 
@@ -69,66 +81,271 @@ func (s *Service) Create(ctx context.Context, r *taskapi.CreateTaskRequest) (_ *
 
 		switch vv := v.(type) {
 		case *options.CreateOptions:
-			opts.LogMode = vv.LogMode.String()
+			if vv.LogMode != options.LogMode_DEFAULT {
+				opts.LogMode = vv.LogMode.String()
+			}
 			opts.SdNotifyEnable = vv.SdNotifyEnable
-			// TODO: Add other runc options to our CreateOptions.
-		case *v2runcopts.Options:
+			opts.UnitMode = vv.UnitMode
+			opts.Slice = vv.Slice
+			opts.Watchdog = time.Duration(vv.WatchdogSec) * time.Second
+			opts.KillMode = vv.KillMode
+			opts.KillSignal = int(vv.KillSignal)
+			opts.FinalKillSignal = int(vv.FinalKillSignal)
+			if vv.KillSignalName != "" {
+				if opts.KillSignal, err = parseSignal(vv.KillSignalName); err != nil {
+					return nil, fmt.Errorf("kill_signal_name: %w", err)
+				}
+			}
+			if vv.FinalKillSignalName != "" {
+				if opts.FinalKillSignal, err = parseSignal(vv.FinalKillSignalName); err != nil {
+					return nil, fmt.Errorf("final_kill_signal_name: %w", err)
+				}
+			}
+			opts.TimeoutStop = time.Duration(vv.TimeoutStopSec) * time.Second
+			opts.KillGracePeriod = time.Duration(vv.KillGracePeriodSec) * time.Second
+			opts.User = vv.User
+			opts.Group = vv.Group
+			opts.DynamicUser = vv.DynamicUser
 			opts.NoPivotRoot = vv.NoPivotRoot
-			opts.NoNewKeyring = vv.NoNewKeyring
 			opts.IoUid = vv.IoUid
 			opts.IoGid = vv.IoGid
-			opts.BinaryName = vv.BinaryName
-			opts.Root = vv.Root
 			opts.CriuPath = vv.CriuPath
+			opts.Root = vv.Root
+			opts.BinaryName = vv.BinaryName
 			opts.SystemdCgroup = vv.SystemdCgroup
-			opts.CriuImagePath = vv.CriuImagePath
-			opts.CriuWorkPath = vv.CriuWorkPath
-			opts.ShimCgroup = vv.ShimCgroup
-		case *runctypes.CreateOptions:
-			opts.NoPivotRoot = vv.NoPivotRoot
-			opts.NoNewKeyring = vv.NoNewKeyring
-			opts.IoUid = vv.IoUid
-			opts.IoGid = vv.IoGid
-			opts.CriuImagePath = vv.CriuImagePath
-			opts.CriuWorkPath = vv.CriuWorkPath
-			opts.ExternalUnixSockets = vv.ExternalUnixSockets
-			opts.FileLocks = vv.FileLocks
-			opts.Terminal = vv.Terminal
-			opts.EmptyNamespaces = vv.EmptyNamespaces
-			opts.ShimCgroup = vv.ShimCgroup
+			opts.OOMPolicy = vv.OomPolicy
+			opts.ManagedOOMMemoryPressure = vv.ManagedOomMemoryPressure
+			opts.ManagedOOMMemoryPressureLimit = vv.ManagedOomMemoryPressureLimit
+			opts.ManagedOOMSwap = vv.ManagedOomSwap
+			opts.LogRateLimitInterval = time.Duration(vv.LogRateLimitIntervalSec) * time.Second
+			opts.LogRateLimitBurst = vv.LogRateLimitBurst
+			opts.MaxLogLineSize = int(vv.MaxLogLineSize)
+			opts.LazyPagesServer = vv.LazyPagesServer
+			opts.MountFlags = vv.MountFlags
+			opts.PrivateTmp = vv.PrivateTmp
+			opts.ProtectSystem = vv.ProtectSystem
+			opts.NullIO = vv.NullIo
+			opts.ExtendedStats = vv.ExtendedStats
+			if len(vv.Accounting) > 0 {
+				if opts.Accounting, err = parseAccounting(vv.Accounting); err != nil {
+					return nil, fmt.Errorf("accounting: %w", err)
+				}
+			}
+		case *v2runcopts.Options, *runctypes.CreateOptions:
+			opts.setRuncOptions(vv)
 		}
 		log.G(ctx).WithField("typeurl", r.Options.TypeUrl).Debug("Decoding create options")
 	}
 
+	opts.ConfigDefaults = configDefaults{LogMode: opts.LogMode == "", Slice: opts.Slice == ""}
+	s.currentConfig().namespace(ns).apply(&opts)
+	if s.debug {
+		opts.Debug = true
+	}
+	opts.UnitNameTemplate = s.unitNameTemplate
+
 	if opts.Root == "" {
 		opts.Root = filepath.Join(s.root, "runc")
 	}
+	if !filepath.IsAbs(opts.Root) {
+		return nil, fmt.Errorf("runtime root %q must be an absolute path: %w", opts.Root, errdefs.ErrInvalidArgument)
+	}
 
 	if opts.LogMode == "" {
 		opts.LogMode = s.defaultLogMode.String()
 	}
+	if err := validateTeeStdio(opts.LogMode, r.Stdout, r.Stderr, r.Terminal); err != nil {
+		return nil, err
+	}
+	if err := validateCriuOptions(opts.EmptyNamespaces, opts.CgroupsMode); err != nil {
+		return nil, err
+	}
 
-	var logPath string
-	if s.debug {
-		logPath = filepath.Join(r.Bundle, "init-runc-debug.log")
+	if err := chownStdio(opts.IoUid, opts.IoGid, r.Stdin, r.Stdout, r.Stderr); err != nil {
+		return nil, err
+	}
+
+	opts.ExecTimeout = s.execTimeout
+	opts.StdioNoReader = s.stdioNoReader
+
+	if opts.UnitMode == options.UnitMode_UNIT_MODE_DEFAULT {
+		opts.UnitMode = s.defaultUnitMode
+	}
+
+	// The runtime always logs, see runtimeLog.
+	logPath := filepath.Join(r.Bundle, initRuntimeLog)
+
+	spec, err := readBundleSpec(r.Bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkAppArmorProfile(spec); err != nil {
+		return nil, err
 	}
 
-	specData, err := ioutil.ReadFile(filepath.Join(r.Bundle, "config.json"))
+	private, err := privateMounts(spec, !s.noNewNamespace)
 	if err != nil {
-		return nil, fmt.Errorf("error reading spec: %w", err)
+		return nil, err
+	}
+	noNewNamespace := !private
+
+	if err := criAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if err := metadataAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if err := hookAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
 	}
-	noNewNamespace := s.noNewNamespace
 
-	if !noNewNamespace {
-		// If the container rootfs is set to shared propagation we must not create use a private namespace.
-		// Otherwise this could prevent the container from legitimately propoagating mounts to the host.
-		var spec specs.Spec
-		if err := json.Unmarshal(specData, &spec); err != nil {
-			return nil, fmt.Errorf("error unmarshalling spec: %w", err)
+	if slice := spec.Annotations[sliceAnnotation]; slice != "" {
+		opts.Slice = slice
+		opts.ConfigDefaults.Slice = false
+	} else if slice := s.sandboxSlice(ns, opts); slice != "" {
+		opts.Slice = slice
+		opts.ConfigDefaults.Slice = false
+	} else if slice := podSlice(spec, opts); slice != "" {
+		opts.Slice = slice
+		opts.ConfigDefaults.Slice = false
+	}
+	if opts.Slice != "" {
+		if err := validateSlice(opts.Slice); err != nil {
+			return nil, err
 		}
-		if spec.Linux.RootfsPropagation == "shared" {
-			noNewNamespace = true
+	}
+
+	if err := stopAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if opts.KillGracePeriod == 0 {
+		opts.KillGracePeriod = s.killGracePeriod
+	}
+	if err := oomAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if err := mountNamespaceAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if err := nullIOAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if err := extendedStatsAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if opts.NullIO {
+		if r.Terminal || opts.Terminal {
+			return nil, fmt.Errorf("null io is not supported with a terminal: %w", errdefs.ErrInvalidArgument)
 		}
+		r.Stdin, r.Stdout, r.Stderr = "", "", ""
+	}
+	if err := verifyAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if err := ttySocketAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if err := ioBufferAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if err := logLimitAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if err := lazyPagesAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if err := networkdAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if err := cniAnnotations(spec, &opts); err != nil {
+		return nil, err
+	}
+	if err := accountingAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if err := validateNamespacePaths(spec); err != nil {
+		return nil, err
+	}
+	opts.BindsTo = s.namespaceUnits(spec)
+	if opts.CNIConfDir != "" && r.Checkpoint != "" {
+		return nil, fmt.Errorf("CNI networks are not supported for restored containers: %w", errdefs.ErrNotImplemented)
+	}
+	if r.Checkpoint != "" {
+		if err := s.prepareRestore(ctx, r.Bundle); err != nil {
+			return nil, err
+		}
+	}
+
+	opts.Properties, err = unitPropertyAnnotations(spec.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := userAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+	if err := execAnnotations(spec.Annotations, &opts); err != nil {
+		return nil, err
+	}
+
+	if opts.unitUser() {
+		if opts.UnitMode == options.UnitMode_UNIT_MODE_TRANSIENT {
+			return nil, fmt.Errorf("running the unit as another user is not supported with transient units: %w", errdefs.ErrNotImplemented)
+		}
+		// The unit's own mount namespace is set up as the unit user, which can't mount the rootfs.
+		noNewNamespace = true
+	}
+
+	uidMap, gidMap := userNamespace(spec)
+	rootfs, err := rootfsMounts(usernsRootfs(ctx, r.Rootfs, uidMap, gidMap), spec.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.checkMountNamespace(noNewNamespace && len(rootfs) > 0); err != nil {
+		return nil, err
+	}
+
+	if v := spec.Annotations[watchdogAnnotation]; v != "" {
+		opts.Watchdog, err = parseWatchdog(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Watchdog > 0 {
+		// The watchdog keepalives come in through the notify socket.
+		opts.SdNotifyEnable = true
+	}
+
+	if opts.SdNotifyEnable {
+		if err := setupNotifySocket(r.Bundle, opts.Watchdog); err != nil {
+			return nil, fmt.Errorf("error setting up notify socket: %w", err)
+		}
+	}
+
+	if err := restartAnnotations(spec.Annotations, &opts, r.Terminal || opts.Terminal); err != nil {
+		return nil, err
+	}
+
+	adj, err := s.nriCreate(ctx, ns, r.ID, r.Bundle, spec, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.Linux != nil && spec.Linux.Resources != nil {
+		if err := validateHugepageLimits(spec.Linux.Resources.HugepageLimits); err != nil {
+			return nil, err
+		}
+	}
+
+	resources, err := resourceOptions(spec, cgroups.Mode() == cgroups.Unified)
+	if err != nil {
+		return nil, err
+	}
+
+	rt, err := s.runtime(ctx, opts.BinaryName)
+	if err != nil {
+		return nil, err
 	}
 
 	p := &initProcess{
@@ -141,34 +358,63 @@ func (s *Service) Create(ctx context.Context, r *taskapi.CreateTaskRequest) (_ *
 			Stderr:   r.Stderr,
 			Terminal: r.Terminal,
 			systemd:  s.conn,
+			reloader: s.reloader,
 			runc: &runc.Runc{
-				Debug:         s.debug,
-				Command:       s.runcBin,
+				Debug:         opts.Debug,
+				Command:       rt.Path,
 				SystemdCgroup: opts.SystemdCgroup,
 				PdeathSignal:  syscall.SIGKILL,
+				Rootless:      rt.rootless(opts.unitUser()),
 				Root:          filepath.Join(opts.Root, ns),
 				Log:           logPath,
+				LogFormat:     rt.logFormat(),
+				Criu:          opts.CriuPath,
 			},
+			runtime:    rt,
 			exe:        s.exe,
-			root:       r.Bundle,
+			root:       s.containerStateDir(ns, r.ID),
 			shimCgroup: opts.ShimCgroup,
+			traceID:    traceIDFromContext(ctx),
 		},
 		Bundle:           r.Bundle,
-		Rootfs:           r.Rootfs,
+		Rootfs:           rootfs,
 		noNewNamespace:   noNewNamespace,
+		cniState:         s.cniStatePath(ns, r.ID),
+		resources:        resources,
+		spec:             spec,
 		checkpoint:       r.Checkpoint,
 		parentCheckpoint: r.ParentCheckpoint,
 		sendEvent:        s.send,
-		execs: &processManager{
-			ls: make(map[string]Process),
-		},
-		shimLog: shimLog,
+		saveTask:         s.saveTask,
+		execs:            newProcessManager(),
+		shimLog:          shimLog,
 	}
 	p.process.cond = sync.NewCond(&p.process.mu)
+	span.SetAttributes(attribute.String(unitAttr, p.Name()))
 
 	if err := s.processes.Add(path.Join(ns, r.ID), p); err != nil {
 		return nil, err
 	}
+	// Without the lock the container must not be cleaned up, its runtime state may be someone else's.
+	if err := p.lockRuntimeState(); err != nil {
+		s.processes.Delete(path.Join(ns, r.ID))
+		return nil, err
+	}
+	stopped, err := s.checkUnitName(ctx, p.Name())
+	if err == nil && stopped {
+		// The runtime state is of the container of the leftover unit.
+		if err := p.runtimeDelete(ctx); err != nil {
+			log.G(ctx).WithError(err).Warn("Error removing runtime state of leftover unit")
+		}
+	}
+	if err == nil {
+		err = p.checkRuntimeState()
+	}
+	if err != nil {
+		p.unlockRuntimeState(ctx)
+		s.processes.Delete(path.Join(ns, r.ID))
+		return nil, err
+	}
 
 	defer func() {
 		if retErr != nil {
@@ -176,18 +422,53 @@ func (s *Service) Create(ctx context.Context, r *taskapi.CreateTaskRequest) (_ *
 			log.G(ctx).WithError(retErr).Debug("Set state to failed")
 			s.processes.Delete(path.Join(ns, r.ID))
 			s.units.Delete(p)
+			s.forgetTask(p)
+			// The request may have failed because it timed out.
+			ctx, cancel := detachedContext(ctx, opDelete)
+			defer cancel()
 			if _, err := p.Delete(ctx); err != nil {
 				log.G(ctx).WithError(err).Error("error cleaning up failed process")
 			}
 		}
 	}()
 
+	// The spec in the bundle is only changed once the container id is ours, and it is put back if the create fails.
+	var edits []specEdit
+	if opts.UnitHooks {
+		edits = append(edits, moveHooksToUnit(r.Bundle))
+	}
+	if adj != nil {
+		edits = append(edits, adj.editSpec)
+	}
+	if len(edits) > 0 {
+		orig, err := editBundleSpec(r.Bundle, edits...)
+		if err != nil {
+			os.Remove(filepath.Join(r.Bundle, hooksFileName))
+			return nil, err
+		}
+		defer func() {
+			if retErr != nil {
+				if err := writeBundleSpecData(r.Bundle, orig); err != nil {
+					log.G(ctx).WithError(err).Error("error restoring bundle spec")
+				}
+				os.Remove(filepath.Join(r.Bundle, hooksFileName))
+			}
+		}()
+	}
+
 	pid, err := p.Create(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if err := p.cniAdd(ctx, pid); err != nil {
+		return nil, err
+	}
 	s.units.Add(p)
 
+	if err := s.saveTask(p); err != nil {
+		return nil, err
+	}
+
 	s.send(ctx, ns, &eventsapi.TaskCreate{
 		ContainerID: r.ID,
 		Bundle:      r.Bundle,
@@ -214,6 +495,7 @@ func (s *Service) Exec(ctx context.Context, r *taskapi.ExecProcessRequest) (_ *p
 
 	ctx, span := StartSpan(ctx, "service.Exec", trace.WithAttributes(attribute.String(nsAttr, ns), attribute.String(cIDAttr, r.ID), attribute.String(eIDAttr, r.ExecID)))
 	defer func() {
+		metrics.execs.Inc(ns, resultLabel(retErr))
 		if retErr != nil {
 			retErr = errdefs.ToGRPCf(retErr, "exec")
 			span.SetStatus(codes.Error, retErr.Error())
@@ -228,11 +510,33 @@ func (s *Service) Exec(ctx context.Context, r *taskapi.ExecProcessRequest) (_ *p
 	ctx = WithShimLog(ctx, p.LogWriter())
 	pInit := p.(*initProcess)
 
+	if err := validateStdio(r.Stdout, r.Stderr, r.Terminal); err != nil {
+		return nil, err
+	}
+	if err := validateTeeStdio(pInit.opts.LogMode, r.Stdout, r.Stderr, r.Terminal); err != nil {
+		return nil, err
+	}
+	if r.Spec != nil {
+		var spec specs.Process
+		if err := json.Unmarshal(r.Spec.Value, &spec); err != nil {
+			return nil, fmt.Errorf("error unmarshaling spec: %v: %w", err, errdefs.ErrInvalidArgument)
+		}
+		cspec, err := pInit.bundleSpec()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkExecProcess(cspec.Process, &spec); err != nil {
+			return nil, err
+		}
+	}
+	if err := chownStdio(pInit.opts.IoUid, pInit.opts.IoGid, r.Stdin, r.Stdout, r.Stderr); err != nil {
+		return nil, err
+	}
+
 	if r.Terminal {
 		r.Stderr = ""
 	}
 
-	// TODO: In order to support shim restarts we need to persist this.
 	ep := &execProcess{
 		Spec:   r.Spec,
 		parent: pInit,
@@ -246,23 +550,56 @@ func (s *Service) Exec(ctx context.Context, r *taskapi.ExecProcessRequest) (_ *p
 			Stderr:   r.Stderr,
 			Terminal: r.Terminal,
 			systemd:  s.conn,
+			reloader: s.reloader,
 			exe:      s.exe,
-			opts:     CreateOptions{LogMode: s.defaultLogMode.String()},
+			opts: CreateOptions{
+				LogMode:              detachedLogMode(&pInit.opts, r.Stdin, r.Stdout, r.Stderr, r.Terminal),
+				UnitMode:             pInit.opts.UnitMode,
+				Slice:                pInit.opts.Slice,
+				User:                 pInit.opts.User,
+				Group:                pInit.opts.Group,
+				DynamicUser:          pInit.opts.DynamicUser,
+				ExecCgroup:           pInit.opts.ExecCgroup,
+				Properties:           pInit.opts.ExecProperties,
+				ExecTimeout:          pInit.opts.ExecTimeout,
+				StdioNoReader:        pInit.opts.StdioNoReader,
+				IoUid:                pInit.opts.IoUid,
+				IoGid:                pInit.opts.IoGid,
+				TTYSocket:            pInit.opts.TTYSocket,
+				TTYSocketMode:        pInit.opts.TTYSocketMode,
+				IOBufferSize:         pInit.opts.IOBufferSize,
+				LogRateLimitInterval: pInit.opts.LogRateLimitInterval,
+				LogRateLimitBurst:    pInit.opts.LogRateLimitBurst,
+				MaxLogLineSize:       pInit.opts.MaxLogLineSize,
+				KillSignal:           pInit.opts.KillSignal,
+				KillGracePeriod:      pInit.opts.KillGracePeriod,
+			},
 			runc: &runc.Runc{
-				Debug:         s.debug,
-				Command:       s.runcBin,
+				Debug:         pInit.runc.Debug,
+				Command:       pInit.runc.Command,
 				SystemdCgroup: pInit.runc.SystemdCgroup,
 				PdeathSignal:  syscall.SIGKILL,
+				Rootless:      pInit.runc.Rootless,
 				Root:          pInit.runc.Root,
+				LogFormat:     pInit.runc.LogFormat,
 			},
+			runtime: pInit.runtime,
+			traceID: traceIDFromContext(ctx),
 		}}
 
-	ep.runc.Log = filepath.Join(ep.stateDir(), "runc-debug.log")
+	ep.runc.Log = filepath.Join(ep.stateDir(), execRuntimeLog)
 	ep.process.cond = sync.NewCond(&ep.process.mu)
+	span.SetAttributes(attribute.String(unitAttr, ep.Name()))
 	err = pInit.execs.Add(r.ExecID, ep)
 	if err != nil {
 		return nil, fmt.Errorf("process %s: %w", r.ExecID, err)
 	}
+	// Exec ids are only unique per container, their unit names may not be, e.g. exec "b-c" of container "a" and exec
+	// "c" of container "a-b".
+	if _, err := s.checkUnitName(ctx, ep.Name()); err != nil {
+		pInit.execs.Delete(r.ExecID)
+		return nil, err
+	}
 
 	s.units.Add(ep)
 	if err := ep.Create(ctx); err != nil {
@@ -271,6 +608,10 @@ func (s *Service) Exec(ctx context.Context, r *taskapi.ExecProcessRequest) (_ *p
 		return nil, err
 	}
 
+	if err := s.saveTask(pInit); err != nil {
+		log.G(ctx).WithError(err).Error("Error saving task state")
+	}
+
 	s.send(ctx, ns, &eventsapi.TaskExecAdded{
 		ContainerID: pInit.id,
 		ExecID:      r.ExecID,
@@ -286,37 +627,36 @@ func (p *execProcess) Create(ctx context.Context) error {
 	if err := os.MkdirAll(p.stateDir(), 0700); err != nil {
 		return err
 	}
-
-	v := p.Spec.Value
-	if p.Terminal || p.opts.Terminal {
-		var spec specs.Process
-		if err := json.Unmarshal(p.Spec.Value, &spec); err != nil {
-			return fmt.Errorf("error unmarshaling spec: %w", err)
-		}
-		spec.Terminal = true
-
-		var err error
-		v, err = json.Marshal(spec)
-		if err != nil {
-			return fmt.Errorf("error marshaling spec: %w", err)
-		}
+	// An exec with the same id that was never deleted
+	if err := os.RemoveAll(filepath.Dir(p.exitStatusPath())); err != nil {
+		return err
+	}
+	if err := labelLike(filepath.Dir(p.stateDir()), p.parent.Bundle); err != nil {
+		return err
+	}
+	if err := labelLike(p.stateDir(), p.parent.Bundle); err != nil {
+		return err
 	}
 
+	v, err := p.processSpec()
+	if err != nil {
+		return err
+	}
 	if err := os.WriteFile(p.processFilePath(), v, 0600); err != nil {
 		return err
 	}
+	if err := labelLike(p.processFilePath(), p.parent.Bundle); err != nil {
+		return err
+	}
 
 	opts, err := p.startOptions()
 	if err != nil {
 		return err
 	}
 
-	if err := writeUnit(p.Name(), opts); err != nil {
+	if err := p.installUnit(ctx, p.Name(), opts); err != nil {
 		return err
 	}
-	if err := p.systemd.ReloadContext(ctx); err != nil {
-		log.G(ctx).WithError(err).Warn("failed to reload systemd")
-	}
 	// Make sure we don't have some old state from a past run.
 	if err := p.systemd.ResetFailedUnitContext(ctx, p.Name()); err != nil && !strings.Contains(err.Error(), "not loaded") {
 		log.G(ctx).WithError(err).Warn("Failed to reset systemd unit")
@@ -326,7 +666,7 @@ func (p *execProcess) Create(ctx context.Context) error {
 }
 
 func (p *execProcess) stateDir() string {
-	return filepath.Join(p.parent.Bundle, "execs", p.execID)
+	return filepath.Join(p.parent.root, "execs", p.execID)
 }
 
 func (p *execProcess) processFilePath() string {
@@ -334,7 +674,7 @@ func (p *execProcess) processFilePath() string {
 }
 
 func (p *initProcess) mountConfigPath() string {
-	return filepath.Join(p.Bundle, "mounts.pb")
+	return filepath.Join(p.root, "mounts.pb")
 }
 
 func (p *initProcess) writeMountConfig() error {
@@ -351,28 +691,43 @@ func (p *initProcess) writeMountConfig() error {
 }
 
 func (p *initProcess) createRestore(ctx context.Context) error {
+	if !p.runtime.supportsCheckpoint() {
+		return fmt.Errorf("runtime %s does not support restore: %w", p.runtime.Name, errdefs.ErrNotImplemented)
+	}
 	if p.opts.CriuWorkPath == "" {
 		p.opts.CriuWorkPath = filepath.Join(p.root, "criu-work")
 	}
 	// We seem to be missing Terminal info when doing a restore, so get that from the spec.
-	data, err := os.ReadFile(filepath.Join(p.Bundle, "config.json"))
+	spec, err := p.bundleSpec()
 	if err != nil {
-		return fmt.Errorf("could not read config.json: %w", err)
+		return err
 	}
-	var spec specs.Spec
-	if err := json.Unmarshal(data, &spec); err != nil {
-		return fmt.Errorf("error unmarshalling config.json")
+	p.Terminal = spec.Process != nil && spec.Process.Terminal
+	if fi, err := os.Stat(p.checkpoint); err == nil && fi.Mode().IsRegular() {
+		// A checkpoint image pulled from a registry, see packCheckpointImage.
+		// Kept with the bundle, images can be large.
+		dest := filepath.Join(p.Bundle, checkpointImageUnpackDir)
+		os.RemoveAll(dest)
+		image, err := unpackCheckpointImageFile(ctx, p.checkpoint, dest)
+		if err != nil {
+			return fmt.Errorf("error unpacking checkpoint image %s: %w", p.checkpoint, err)
+		}
+		p.checkpoint = image
+	}
+	if p.parentCheckpoint != "" {
+		if err := linkParentCheckpoint(p.checkpoint, p.parentCheckpoint); err != nil {
+			return fmt.Errorf("error linking parent checkpoint: %w", err)
+		}
 	}
-	p.Terminal = spec.Process.Terminal
 
 	execStart := []string{
 		"restore",
 		"--image-path=" + p.checkpoint,
 		"--work-path=" + p.opts.CriuWorkPath,
 		"--bundle=" + p.Bundle,
-		"--no-pivot=" + strconv.FormatBool(p.opts.NoPivotRoot),
 		"--no-subreaper",
 	}
+	execStart = append(execStart, p.runtime.boolFlag("no-pivot", p.opts.NoPivotRoot)...)
 
 	if p.Terminal || p.opts.Terminal {
 		execStart = append(execStart, "--detach")
@@ -384,17 +739,18 @@ func (p *initProcess) createRestore(ctx context.Context) error {
 		p.opts.ExternalUnixSockets = true
 	}
 	execStart = append(execStart, p.opts.RestoreArgs()...)
+	p.runtimeArgs = execStart
 
 	unitOpts, err := p.startOptions(execStart)
 	if err != nil {
 		return err
 	}
 
-	if err := writeUnit(p.Name(), unitOpts); err != nil {
-		return err
+	if err := p.linkOverrides(ctx); err != nil {
+		return fmt.Errorf("error linking unit drop-ins: %w", err)
 	}
-	if err := p.systemd.ReloadContext(ctx); err != nil {
-		log.G(ctx).WithError(err).Warn("Error reloading systemd")
+	if err := p.installUnit(ctx, p.Name(), unitOpts); err != nil {
+		return err
 	}
 
 	return nil
@@ -408,7 +764,7 @@ func (p *initProcess) Create(ctx context.Context) (_ uint32, retErr error) {
 	defer func() {
 		if retErr != nil {
 			span.SetStatus(codes.Error, retErr.Error())
-			p.runc.Delete(ctx, p.id, &runc.DeleteOpts{Force: true})
+			p.runtimeDelete(ctx)
 			p.mu.Lock()
 			p.deleted = true
 			p.cond.Broadcast()
@@ -417,9 +773,15 @@ func (p *initProcess) Create(ctx context.Context) (_ uint32, retErr error) {
 		span.End()
 	}()
 
+	if err := createStateDir(p.root, p.Bundle); err != nil {
+		return 0, fmt.Errorf("error creating state directory: %w", err)
+	}
 	if err := p.writeMountConfig(); err != nil {
 		return 0, err
 	}
+	if err := p.verifyRootfs(ctx); err != nil {
+		return 0, err
+	}
 
 	if p.checkpoint != "" {
 		return 0, p.createRestore(ctx)
@@ -429,10 +791,10 @@ func (p *initProcess) Create(ctx context.Context) (_ uint32, retErr error) {
 	rcmd := []string{
 		"create",
 		"--bundle=" + p.Bundle,
-		"--no-pivot=" + strconv.FormatBool(p.opts.NoPivotRoot),
-		"--no-new-keyring=" + strconv.FormatBool(p.opts.NoNewKeyring),
 		"--pid-file=" + p.pidFile(),
 	}
+	rcmd = append(rcmd, p.runtime.boolFlag("no-pivot", p.opts.NoPivotRoot)...)
+	rcmd = append(rcmd, p.runtime.boolFlag("no-new-keyring", p.opts.NoNewKeyring)...)
 	if p.Terminal || p.opts.Terminal {
 		s, err := p.ttySockPath()
 		if err != nil {
@@ -440,6 +802,7 @@ func (p *initProcess) Create(ctx context.Context) (_ uint32, retErr error) {
 		}
 		rcmd = append(rcmd, "--console-socket="+s)
 	}
+	p.runtimeArgs = rcmd
 
 	unitOpts, err := p.startOptions(rcmd)
 	if err != nil {
@@ -451,7 +814,7 @@ func (p *initProcess) Create(ctx context.Context) (_ uint32, retErr error) {
 		if err != nil {
 			return 0, err
 		}
-		u, _, err := p.makePty(ctx, sockPath)
+		u, _, err := p.makePty(ctx, sockPath, p)
 		if err != nil {
 			return 0, err
 		}
@@ -463,17 +826,18 @@ func (p *initProcess) Create(ctx context.Context) (_ uint32, retErr error) {
 		}()
 	}
 
-	if err := writeUnit(p.Name(), unitOpts); err != nil {
-		return 0, err
+	if err := p.linkOverrides(ctx); err != nil {
+		return 0, fmt.Errorf("error linking unit drop-ins: %w", err)
 	}
-	if err := p.systemd.ReloadContext(ctx); err != nil {
-		log.G(ctx).WithError(err).Warn("Error reloading systemd")
+	if err := p.installUnit(ctx, p.Name(), unitOpts); err != nil {
+		return 0, err
 	}
 	// Make sure we don't have some old state from a past run.
 	if err := p.systemd.ResetFailedUnitContext(ctx, p.Name()); err != nil && !strings.Contains(err.Error(), "not loaded") {
 		log.G(ctx).WithError(err).Warn("Failed to reset systemd unit")
 	}
 
+	p.startLogRelay(ctx, p.Name())
 	return p.startUnit(ctx)
 }
 
@@ -481,26 +845,48 @@ func (p *initProcess) startUnit(ctx context.Context) (uint32, error) {
 	uName := p.Name()
 
 	do := func() error {
-		ch := make(chan string, 1)
+		var ch chan string
+		startJob := func(ctx context.Context) error {
+			ch = make(chan string, 1)
+			_, err := p.startUnitJob(ctx, uName, ch)
+			return err
+		}
 		p.systemd.ResetFailedUnitContext(ctx, p.Name())
-		if _, err := p.systemd.StartUnitContext(ctx, uName, "replace", ch); err != nil {
-			if err := p.runc.Delete(ctx, p.id, &runc.DeleteOpts{Force: true}); err != nil && !strings.Contains(err.Error(), "not found") {
+		if err := startJob(ctx); err != nil {
+			// Left over state of an earlier run may be in the way, after that the start is retried like any other
+			// operation.
+			if err := p.runtimeDelete(ctx); err != nil && !strings.Contains(err.Error(), "not found") {
 				log.G(ctx).WithError(err).Info("Error deleting container in runc")
 			}
 			if err := p.systemd.ResetFailedUnitContext(ctx, uName); err != nil {
 				log.G(ctx).WithError(err).Info("Error resetting failed unit")
 			}
 
-			ch = make(chan string, 1)
-			if _, err := p.systemd.StartUnitContext(ctx, uName, "replace", ch); err != nil {
+			if err := retry(ctx, "start-unit", startJob, nil); err != nil {
 				return fmt.Errorf("error starting unit: %w", err)
 			}
 		}
 
+		var created <-chan struct{}
+		if p.opts.SdNotifyEnable {
+			cctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			created = p.waitCreated(cctx)
+		}
+
 		select {
 		case <-ctx.Done():
 			p.Kill(ctx, int(syscall.SIGKILL), true)
 			return ctx.Err()
+		case <-created:
+			log.G(ctx).Debug("Container created, readiness is up to the container")
+			if err := p.LoadState(ctx); err != nil {
+				return err
+			}
+
+			if p.ProcessState().Exited() {
+				return fmt.Errorf("container exited immediately, code: %d", p.ProcessState().ExitCode)
+			}
 		case status := <-ch:
 			log.G(ctx).WithField("status", status).Info("Unit Status")
 			if status != "done" {
@@ -568,28 +954,12 @@ func (p *initProcess) startUnit(ctx context.Context) (uint32, error) {
 		}
 
 		// Clean up old state and try again
-		if err2 := p.runc.Delete(ctx, p.id, &runc.DeleteOpts{Force: true}); err2 != nil {
+		if err2 := p.runtimeDelete(ctx); err2 != nil {
 			log.G(ctx).WithError(err2).Info("Error deleting container in runc")
 		}
 		if err := do(); err != nil {
-			ret := err
-			if p.runc.Debug {
-				ret = fmt.Errorf("%w:\n%s", err, p.Name())
-				unitData, err := os.ReadFile("/run/systemd/system/" + uName)
-				if err == nil {
-					ret = fmt.Errorf("%w:\n%s", ret, string(unitData))
-				}
-				logData, err := os.ReadFile(p.runc.Log)
-				if err == nil {
-					ret = fmt.Errorf("%w\n%s", ret, string(logData))
-				}
-
-				ttyData, err := os.ReadFile(filepath.Join(p.root, p.id+"-tty.log"))
-				if err == nil {
-					ret = fmt.Errorf("%w\n%s", ret, string(ttyData))
-				}
-			}
-			if err2 := p.runc.Delete(ctx, p.id, &runc.DeleteOpts{Force: true}); err2 != nil {
+			ret := p.startDiagnostics(ctx, uName, err)
+			if err2 := p.runtimeDelete(ctx); err2 != nil {
 				log.G(ctx).WithError(err2).Debug("Error deleting container in runc")
 			}
 			return 0, ret
@@ -614,7 +984,9 @@ func waitAny(ws *unix.WaitStatus) (int, error) {
 	}
 }
 
-func reap(ctx context.Context, chChld chan os.Signal, wait chan waitStatus, chProc <-chan *os.Process) {
+// reap waits for runc or the container process to exit.
+// ignorePid is a helper we started (the notify proxy) which should not be mistaken for the container.
+func reap(ctx context.Context, chChld chan os.Signal, wait chan waitStatus, chProc <-chan *os.Process, ignorePid int) {
 	// wait for process start
 	var proc *os.Process
 	select {
@@ -631,30 +1003,85 @@ func reap(ctx context.Context, chChld chan os.Signal, wait chan waitStatus, chPr
 			continue
 		}
 
+		if ignorePid > 0 && pid == ignorePid {
+			log.G(ctx).WithField("pid", pid).WithField("code", waitExitCode(ws)).Warn("Notify proxy exited")
+			continue
+		}
+
 		if pid == proc.Pid {
 			// This is the runc process
 			// If runc returns 0 we still need to give some time to see if the container process is stable.
 			// If non-zero then runc exited before bringing up the container.
-			log.G(ctx).WithField("pid", pid).WithField("code", ws.ExitStatus()).Debug("runc exited")
-			if ws.ExitStatus() != 0 {
-				wait <- waitStatus{Status: ws.ExitStatus(), Pid: uint32(pid)}
+			log.G(ctx).WithField("pid", pid).WithField("code", waitExitCode(ws)).Debug("runc exited")
+			if code := waitExitCode(ws); code != 0 {
+				wait <- waitStatus{Status: code, Pid: uint32(pid)}
 			}
 			return
 		}
 
-		wait <- waitStatus{Status: ws.ExitStatus(), Pid: uint32(pid)}
+		wait <- waitStatus{Status: waitExitCode(ws), Pid: uint32(pid)}
 		return
 	}
 }
 
-func createCmd(ctx context.Context, bundle string, cmdLine []string, tty, noReap bool) (retErr error) {
+func createCmd(ctx context.Context, bundle string, cmdLine []string, tty, noReap bool, logMode string) (retErr error) {
 	log.G(ctx).Debugf("%s %s", cmdLine[0], cmdLine[1:])
 
+	// The notify proxy must stay in the unit cgroup, so it is started before we move to the shim cgroup.
+	var proxyPid int
+	notifySock := os.Getenv(notifyProxyEnv)
+	if notifySock != "" {
+		pid, err := startNotifyProxy(ctx, bundle, notifySock)
+		if err != nil {
+			return err
+		}
+		proxyPid = pid
+	}
+
+	// Same for the log tee, which also has to be in the unit cgroup for journald to know where the output comes from.
+	mode := options.LogMode(options.LogMode_value[strings.ToUpper(logMode)])
+	var teeOut, teeErr *os.File
+	if mode == options.LogMode_TEE && !tty {
+		stdout, stderr, err := startLogTee(ctx, bundle)
+		if err != nil {
+			return err
+		}
+		defer stdout.Close()
+		defer stderr.Close()
+		teeOut, teeErr = stdout, stderr
+	}
+
+	// The output goes straight to the fifos in stdio mode, the log tee drops a fifo nobody reads and the output with a
+	// tty is handled by the tty helper.
+	if (mode == options.LogMode_STDIO || mode == options.LogMode_DEFAULT) && !tty {
+		for _, s := range []struct{ env, stream string }{{"STDOUT_FIFO", "stdout"}, {"STDERR_FIFO", "stderr"}} {
+			p := os.Getenv(s.env)
+			path, probe, err := checkStdioOutput(ctx, os.Getenv(stdioNoReaderEnv), s.stream, p)
+			if err != nil {
+				return err
+			}
+			if probe != nil {
+				defer probe.Close()
+			}
+			if path != p {
+				os.Setenv(s.env, path)
+			}
+		}
+	}
+
 	if err := setCgroup(); err != nil {
 		log.G(ctx).WithError(err).Error("Error setting cgroup")
 	}
 
 	cmd := exec.Command(cmdLine[0], cmdLine[1:]...)
+	if notifySock != "" {
+		// runc sets up its own notify socket when NOTIFY_SOCKET is set, which would replace the one from the proxy.
+		for _, e := range os.Environ() {
+			if !strings.HasPrefix(e, "NOTIFY_SOCKET=") {
+				cmd.Env = append(cmd.Env, e)
+			}
+		}
+	}
 
 	// Open all fifos with O_RDWR first so that we don't block trying to open
 	// Then open with the correct permissions which get passed to runc.
@@ -676,7 +1103,67 @@ func createCmd(ctx context.Context, bundle string, cmdLine []string, tty, noReap
 		log.G(ctx).Debug("No stdin pipe")
 	}
 
-	if p := os.Getenv("STDOUT_FIFO"); p != "" {
+	var fifoOutput bool
+	switch mode {
+	case options.LogMode_JOURNALD:
+		// Send the container output straight to the journal.
+		// Separate streams are used so stdout and stderr can be told apart by priority.
+		id := os.Getenv("UNIT_NAME")
+		if f, err := openJournalStream(id, journalPriorityStdout); err == nil {
+			defer f.Close()
+			cmd.Stdout = f
+		} else {
+			log.G(ctx).WithError(err).Warn("Error opening journal stream, using unit stdout")
+			cmd.Stdout = os.Stdout
+		}
+		if f, err := openJournalStream(id, journalPriorityStderr); err == nil {
+			defer f.Close()
+			cmd.Stderr = f
+		} else {
+			log.G(ctx).WithError(err).Warn("Error opening journal stream, using unit stderr")
+			cmd.Stderr = os.Stderr
+		}
+	case options.LogMode_NULL:
+		// Leaving stdout/stderr unset discards the output.
+	case options.LogMode_TEE:
+		if teeOut != nil {
+			cmd.Stdout = teeOut
+			cmd.Stderr = teeErr
+			break
+		}
+		// With a tty the output only goes to the fifo.
+		fallthrough
+	default:
+		// This may also be a binary:// or file:// URI when containerd is configured with a logging driver.
+		// With a tty the output is handled by the tty helper instead.
+		u, err := parseStdioURI(os.Getenv("STDOUT_FIFO"))
+		if err != nil {
+			return err
+		}
+		switch {
+		case u == nil || tty:
+			fifoOutput = true
+		case u.Scheme == stdioBinary:
+			stdout, stderr, err := startBinaryIO(ctx, u, os.Getenv("CONTAINER_NAMESPACE"), os.Getenv("CONTAINER_ID"))
+			if err != nil {
+				return err
+			}
+			defer stdout.Close()
+			defer stderr.Close()
+			cmd.Stdout = stdout
+			cmd.Stderr = stderr
+		case u.Scheme == stdioFile:
+			f, err := openFileIO(u)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			cmd.Stdout = f
+			cmd.Stderr = f
+		}
+	}
+
+	if p := os.Getenv("STDOUT_FIFO"); isFifo(p) && fifoOutput {
 		f, err := os.OpenFile(p, os.O_RDWR, 0)
 		if err != nil {
 			return err
@@ -694,7 +1181,7 @@ func createCmd(ctx context.Context, bundle string, cmdLine []string, tty, noReap
 		log.G(ctx).Debug("No stdout pipe")
 	}
 
-	if p := os.Getenv("STDERR_FIFO"); p != "" {
+	if p := os.Getenv("STDERR_FIFO"); isFifo(p) && fifoOutput {
 		f, err := os.OpenFile(p, os.O_RDWR, 0)
 		if err != nil {
 			// Ignore errors on this if we have a TTY
@@ -725,7 +1212,7 @@ func createCmd(ctx context.Context, bundle string, cmdLine []string, tty, noReap
 	var readPid uint32
 	if !noReap {
 		signal.Notify(chChld, syscall.SIGCHLD)
-		go reap(ctx, chChld, wait, chProc)
+		go reap(ctx, chChld, wait, chProc, proxyPid)
 
 		var i uintptr = 1
 		if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, i, 0, 0, 0); err != nil {
@@ -856,13 +1343,18 @@ func createCmd(ctx context.Context, bundle string, cmdLine []string, tty, noReap
 				// Double check if the process is still running.
 				p, _ := unix.Wait4(pid, &status, unix.WNOHANG, nil)
 				if p == pid {
-					st.ExitCode = uint32(status.ExitStatus())
+					st.ExitCode = uint32(waitExitCode(status))
 					st.ExitedAt = time.Now()
 					st.Status = exitedInit
 					notify = func() { sdNotify(ctx, notifyStatus(exitedInit), notifyErrno(st.ExitCode), notifyMainPID(st.Pid)) }
 				} else {
 					notify = func() {
-						sdNotify(ctx, daemon.SdNotifyReady, notifyMainPID(st.Pid))
+						if notifySock != "" {
+							// Readiness is up to the container, which sends READY=1 through the notify proxy.
+							sdNotify(ctx, notifyStatus("created"), notifyMainPID(st.Pid))
+						} else {
+							sdNotify(ctx, daemon.SdNotifyReady, notifyMainPID(st.Pid))
+						}
 						log.G(ctx).Debug("Process is up!")
 					}
 				}
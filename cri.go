@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/coreos/go-systemd/unit"
+)
+
+// Annotations set by the CRI plugin of containerd on containers created for Kubernetes.
+const (
+	criContainerTypeAnnotation    = "io.kubernetes.cri.container-type"
+	criSandboxIDAnnotation        = "io.kubernetes.cri.sandbox-id"
+	criSandboxNameAnnotation      = "io.kubernetes.cri.sandbox-name"
+	criSandboxNamespaceAnnotation = "io.kubernetes.cri.sandbox-namespace"
+	criSandboxLogDirAnnotation    = "io.kubernetes.cri.sandbox-log-directory"
+	criContainerNameAnnotation    = "io.kubernetes.cri.container-name"
+	criImageNameAnnotation        = "io.kubernetes.cri.image-name"
+
+	criContainerTypeSandbox   = "sandbox"
+	criContainerTypeContainer = "container"
+)
+
+// kubeletRestartCountAnnotation is set by kubelet on the container config.
+// containerd only passes it on to the spec when it is allowed with container_annotations in the runtime config.
+const kubeletRestartCountAnnotation = "io.kubernetes.container.restartCount"
+
+// criLogPathAnnotation sets the file the output of a container logging to the journal is written to in the CRI log
+// format, instead of relaying it to the stdio fifos. CRI containers get the path kubelet expects by default.
+const criLogPathAnnotation = shimName + ".log-path"
+
+const (
+	// criSandboxUnitMod is the suffix of the unit name of pod sandboxes.
+	criSandboxUnitMod = "sandbox"
+	// criLogReopenInterval is how often the CRI log writer checks if the log file was rotated.
+	criLogReopenInterval = time.Second
+)
+
+// criContainer is what the CRI annotations say about a container, it is empty for containers not created by CRI.
+type criContainer struct {
+	// Type is "sandbox" for the pause container of a pod, "container" for the others.
+	Type             string `json:",omitempty"`
+	SandboxID        string `json:",omitempty"`
+	SandboxName      string `json:",omitempty"`
+	SandboxNamespace string `json:",omitempty"`
+	Name             string `json:",omitempty"`
+	// Image is the image the container was created from as kubelet asked for it, e.g. "docker.io/library/nginx:1.21".
+	Image string `json:",omitempty"`
+	// RestartCount is -1 if kubelet's restart count annotation was not passed on to the container.
+	RestartCount int `json:",omitempty"`
+	// LogPath is the file the container output is written to in CRI format when it is logged to the journal.
+	LogPath string `json:",omitempty"`
+}
+
+// criAnnotations sets opts.CRI from the CRI annotations.
+func criAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	c := criContainer{
+		Type:             annotations[criContainerTypeAnnotation],
+		SandboxID:        annotations[criSandboxIDAnnotation],
+		SandboxName:      annotations[criSandboxNameAnnotation],
+		SandboxNamespace: annotations[criSandboxNamespaceAnnotation],
+		Name:             annotations[criContainerNameAnnotation],
+		Image:            annotations[criImageNameAnnotation],
+		RestartCount:     -1,
+	}
+	switch c.Type {
+	case "":
+		return nil
+	case criContainerTypeSandbox, criContainerTypeContainer:
+	default:
+		return fmt.Errorf("annotation %s: invalid value %q: %w", criContainerTypeAnnotation, c.Type, errdefs.ErrInvalidArgument)
+	}
+	if c.Name != "" {
+		if err := validateCRIName(c.Name); err != nil {
+			return fmt.Errorf("annotation %s: %w", criContainerNameAnnotation, err)
+		}
+	}
+
+	if v := annotations[kubeletRestartCountAnnotation]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("annotation %s: invalid restart count %q: %w", kubeletRestartCountAnnotation, v, errdefs.ErrInvalidArgument)
+		}
+		c.RestartCount = n
+	}
+
+	// The log path kubelet passes to CRI isn't in the spec, it is <pod log dir>/<container name>/<restart count>.log.
+	c.LogPath = annotations[criLogPathAnnotation]
+	if dir := annotations[criSandboxLogDirAnnotation]; c.LogPath == "" && dir != "" && c.Type == criContainerTypeContainer && c.Name != "" && c.RestartCount >= 0 {
+		c.LogPath = filepath.Join(dir, c.Name, strconv.Itoa(c.RestartCount)+".log")
+	}
+	if c.LogPath != "" && !filepath.IsAbs(c.LogPath) {
+		return fmt.Errorf("log path %q must be absolute: %w", c.LogPath, errdefs.ErrInvalidArgument)
+	}
+
+	opts.CRI = c
+	return nil
+}
+
+// validateCRIName makes sure the container name can be used in a unit name.
+// Kubernetes container names are DNS labels, anything else is refused rather than escaped.
+func validateCRIName(s string) error {
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return fmt.Errorf("invalid character %q in container name %q: %w", c, s, errdefs.ErrInvalidArgument)
+		}
+	}
+	return nil
+}
+
+func (c criContainer) sandbox() bool {
+	return c.Type == criContainerTypeSandbox
+}
+
+// unitMod returns the suffix of the container's unit name.
+// Containers of a pod get their name and restart count in the unit name so `systemctl list-units` can be read without
+// looking up container ids, the pause container is marked as the sandbox.
+func (c criContainer) unitMod() string {
+	switch {
+	case c.sandbox():
+		return criSandboxUnitMod
+	case c.Type == "" || c.Name == "":
+		return "init"
+	case c.RestartCount >= 0:
+		return c.Name + "-" + strconv.Itoa(c.RestartCount) + "-init"
+	default:
+		return c.Name + "-init"
+	}
+}
+
+// description returns the unit description of a CRI container, or def for other containers.
+func (c criContainer) description(def string) string {
+	if c.Type == "" {
+		return def
+	}
+	pod := c.SandboxNamespace + "/" + c.SandboxName
+	if c.sandbox() {
+		return "containerd pod sandbox " + pod
+	}
+	var details []string
+	if c.Image != "" {
+		details = append(details, "image "+c.Image)
+	}
+	if c.RestartCount >= 0 {
+		details = append(details, "restart "+strconv.Itoa(c.RestartCount))
+	}
+	desc := "containerd container " + pod + "/" + c.Name
+	if len(details) > 0 {
+		desc += " (" + strings.Join(details, ", ") + ")"
+	}
+	return desc
+}
+
+// criOptions returns the unit options for containers in a pod.
+// They are ordered after the sandbox unit, so when the node shuts down they are stopped before the pause container
+// that holds the pod's namespaces.
+func (p *initProcess) criOptions() []*unit.UnitOption {
+	c := p.opts.CRI
+	if c.Type != criContainerTypeContainer || c.SandboxID == "" {
+		return nil
+	}
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Unit", "After", unitName(p.opts.UnitNameTemplate, p.ns, c.SandboxID, criSandboxUnitMod)),
+	}
+}
+
+// criLogWriter writes container output to a file in the CRI log format.
+// kubelet rotates the file by renaming it, the file is reopened when the path no longer points to it.
+type criLogWriter struct {
+	path string
+
+	mu        sync.Mutex
+	f         *os.File
+	lastCheck time.Time
+}
+
+func newCRILogWriter(p string) (*criLogWriter, error) {
+	w := &criLogWriter{path: p}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *criLogWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	if w.f != nil {
+		w.f.Close()
+	}
+	w.f = f
+	w.lastCheck = time.Now()
+	return nil
+}
+
+// reopenIfRotated reopens the file if it was moved away, w.mu must be held.
+func (w *criLogWriter) reopenIfRotated() error {
+	if time.Since(w.lastCheck) < criLogReopenInterval {
+		return nil
+	}
+	w.lastCheck = time.Now()
+	cur, err := w.f.Stat()
+	if err != nil {
+		return w.open()
+	}
+	fi, err := os.Stat(w.path)
+	if err != nil || !os.SameFile(cur, fi) {
+		return w.open()
+	}
+	return nil
+}
+
+// WriteLine writes one line of output from the stream ("stdout" or "stderr").
+func (w *criLogWriter) WriteLine(t time.Time, stream string, msg []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.reopenIfRotated(); err != nil {
+		return err
+	}
+	line := make([]byte, 0, len(msg)+64)
+	line = append(line, t.UTC().Format(time.RFC3339Nano)...)
+	line = append(line, ' ')
+	line = append(line, stream...)
+	line = append(line, ' ')
+	// Journal entries are whole lines, so there are no partial (P) lines.
+	line = append(line, "F "...)
+	line = append(line, msg...)
+	line = append(line, '\n')
+	_, err := w.f.Write(line)
+	return err
+}
+
+func (w *criLogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
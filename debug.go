@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+)
+
+// containerListTimeout bounds getting the cgroups of the containers from systemd for the container list.
+const containerListTimeout = 5 * time.Second
+
+// debugState is the state dump served on the debug address.
+type debugState struct {
+	Version    string
+	Containers []debugContainer
+	// Units are the units we are watching for state changes.
+	Units []string
+}
+
+type debugContainer struct {
+	Namespace string
+	ID        string
+	Unit      string
+	Bundle    string
+	State     pState
+	Deleted   bool
+	Restarts  uint32      `json:",omitempty"`
+	Execs     []debugExec `json:",omitempty"`
+}
+
+type debugExec struct {
+	ID    string
+	Unit  string
+	State pState
+}
+
+// debugState collects the state of all the containers.
+// The process list is copied first so a stuck process doesn't keep the process manager locked.
+func (s *Service) debugState() debugState {
+	var ps []*initProcess
+	s.processes.Each(func(p Process) {
+		if p, ok := p.(*initProcess); ok {
+			ps = append(ps, p)
+		}
+	})
+
+	st := debugState{Version: shimVersion(), Units: s.units.Names()}
+	sort.Strings(st.Units)
+
+	for _, p := range ps {
+		c := debugContainer{
+			Namespace: p.ns,
+			ID:        p.id,
+			Unit:      p.Name(),
+			Bundle:    p.Bundle,
+			State:     p.ProcessState(),
+		}
+		p.mu.Lock()
+		c.Deleted = p.deleted
+		c.Restarts = p.restarts
+		p.mu.Unlock()
+
+		var execs []*execProcess
+		p.execs.Each(func(ep Process) {
+			execs = append(execs, ep.(*execProcess))
+		})
+		for _, ep := range execs {
+			c.Execs = append(c.Execs, debugExec{ID: ep.execID, Unit: ep.Name(), State: ep.ProcessState()})
+		}
+		sort.Slice(c.Execs, func(i, j int) bool { return c.Execs[i].ID < c.Execs[j].ID })
+
+		st.Containers = append(st.Containers, c)
+	}
+	sort.Slice(st.Containers, func(i, j int) bool {
+		if st.Containers[i].Namespace != st.Containers[j].Namespace {
+			return st.Containers[i].Namespace < st.Containers[j].Namespace
+		}
+		return st.Containers[i].ID < st.Containers[j].ID
+	})
+	return st
+}
+
+// containerInfo is an entry of the container list served at /containers, for tools that need to find the units,
+// processes and cgroups of the containers.
+type containerInfo struct {
+	Namespace string `json:"namespace"`
+	ID        string `json:"id"`
+	Unit      string `json:"unit"`
+	Bundle    string `json:"bundle"`
+	Pid       uint32 `json:"pid,omitempty"`
+	// Cgroup is the cgroup of the unit, empty if the unit is not running.
+	Cgroup string `json:"cgroup,omitempty"`
+	// Status is the status reported to containerd, e.g. "running" or "stopped".
+	Status   string     `json:"status"`
+	ExitCode uint32     `json:"exitCode,omitempty"`
+	ExitedAt *time.Time `json:"exitedAt,omitempty"`
+	Restarts uint32     `json:"restarts,omitempty"`
+	Execs    []execInfo `json:"execs,omitempty"`
+}
+
+type execInfo struct {
+	ID   string `json:"id"`
+	Unit string `json:"unit"`
+	Pid  uint32 `json:"pid,omitempty"`
+	// Cgroup is the cgroup the exec process runs in, the container's unless the exec has its own (see ExecCgroup).
+	Cgroup   string     `json:"cgroup,omitempty"`
+	Status   string     `json:"status"`
+	ExitCode uint32     `json:"exitCode,omitempty"`
+	ExitedAt *time.Time `json:"exitedAt,omitempty"`
+}
+
+// containerList lists the containers in the namespace, or all of them if it is empty.
+func (s *Service) containerList(ctx context.Context, ns string) []containerInfo {
+	ctx, cancel := context.WithTimeout(ctx, containerListTimeout)
+	defer cancel()
+
+	var ps []*initProcess
+	s.processes.Each(func(p Process) {
+		if p, ok := p.(*initProcess); ok && (ns == "" || p.ns == ns) {
+			ps = append(ps, p)
+		}
+	})
+
+	ls := make([]containerInfo, 0, len(ps))
+	for _, p := range ps {
+		// State has the paused status, which ProcessState doesn't.
+		resp, _ := p.State(ctx)
+		st := resp.State
+		c := containerInfo{
+			Namespace: p.ns,
+			ID:        p.id,
+			Unit:      p.Name(),
+			Bundle:    p.Bundle,
+			Pid:       st.Pid,
+			Status:    statusName(st),
+			ExitCode:  st.ExitCode,
+			ExitedAt:  exitedAt(st),
+			Restarts:  resp.Restarts,
+		}
+		if !st.Exited() && st.Pid != 0 {
+			c.Cgroup, _ = p.unitCgroup(ctx, p.Name())
+		}
+
+		var execs []*execProcess
+		p.execs.Each(func(ep Process) {
+			execs = append(execs, ep.(*execProcess))
+		})
+		for _, ep := range execs {
+			est := ep.ProcessState()
+			e := execInfo{
+				ID:       ep.execID,
+				Unit:     ep.Name(),
+				Pid:      est.Pid,
+				Status:   statusName(est),
+				ExitCode: est.ExitCode,
+				ExitedAt: exitedAt(est),
+			}
+			if !est.Exited() && est.Pid != 0 {
+				if ep.opts.ExecCgroup {
+					e.Cgroup, _ = ep.unitCgroup(ctx, ep.Name())
+				} else {
+					e.Cgroup = c.Cgroup
+				}
+			}
+			c.Execs = append(c.Execs, e)
+		}
+		sort.Slice(c.Execs, func(i, j int) bool { return c.Execs[i].ID < c.Execs[j].ID })
+
+		ls = append(ls, c)
+	}
+	sort.Slice(ls, func(i, j int) bool {
+		if ls[i].Namespace != ls[j].Namespace {
+			return ls[i].Namespace < ls[j].Namespace
+		}
+		return ls[i].ID < ls[j].ID
+	})
+	return ls
+}
+
+func statusName(st pState) string {
+	if st.Exited() {
+		return "stopped"
+	}
+	return strings.ToLower(toStatus(st.Status).String())
+}
+
+func exitedAt(st pState) *time.Time {
+	if st.ExitedAt.IsZero() || st.ExitedAt.Equal(timeZero) {
+		return nil
+	}
+	t := st.ExitedAt
+	return &t
+}
+
+// validateDebugAddr checks that the debug address is a unix socket.
+// pprof and the state dump expose a lot about the shim and its containers, they are never served on the network.
+func validateDebugAddr(addr string) error {
+	if _, ok := unixAddrPath(addr); !ok || addr == "unix://" {
+		return fmt.Errorf("debug address must be a unix socket path, not %q: %w", addr, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// serveDebug serves pprof, goroutine dumps and a dump of the shim state on addr until ctx is cancelled.
+// This is meant for diagnosing hung requests without restarting the shim, the goroutine dump is at
+// /debug/pprof/goroutine?debug=2 and the state at /debug/state. /containers lists the containers for other tools, a
+// POST to /reload reloads the config, see reloadConfig, and to /sandboxes/freeze or /sandboxes/thaw freezes or thaws a
+// pod, see freezeSandbox.
+func (s *Service) serveDebug(ctx context.Context, addr string) error {
+	if err := validateDebugAddr(addr); err != nil {
+		return err
+	}
+	p, _ := unixAddrPath(addr)
+	l, err := listenUnix(p)
+	if err != nil {
+		return fmt.Errorf("error listening on debug address: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(s.debugState()); err != nil {
+			log.G(ctx).WithError(err).Warn("Error writing debug state")
+		}
+	})
+
+	mux.HandleFunc("/containers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(s.containerList(r.Context(), r.URL.Query().Get("namespace"))); err != nil {
+			log.G(ctx).WithError(err).Warn("Error writing container list")
+		}
+	})
+
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := s.reloadConfig(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	mux.HandleFunc("/sandboxes/", s.handleSandboxFreezer)
+
+	serveHTTP(ctx, l, mux)
+	log.G(ctx).WithField("addr", addr).Info("Serving debug endpoints")
+	return nil
+}
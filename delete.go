@@ -6,8 +6,10 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/log"
@@ -58,6 +60,10 @@ func (s *Service) Delete(ctx context.Context, r *taskapi.DeleteRequest) (_ *task
 		}
 		pInit.execs.Delete(r.ExecID)
 		s.units.Delete(ep)
+
+		if err := s.saveTask(pInit); err != nil {
+			log.G(ctx).WithError(err).Error("Error saving task state")
+		}
 	} else {
 		st, err = p.Delete(ctx)
 		if err != nil {
@@ -69,6 +75,12 @@ func (s *Service) Delete(ctx context.Context, r *taskapi.DeleteRequest) (_ *task
 		})
 		s.processes.Delete(path.Join(ns, r.ID))
 		s.units.Delete(p)
+		s.forgetTask(p.(*initProcess))
+	}
+
+	if !st.ExitedAt.After(timeZero) {
+		// Processes that never ran have no exit time, clients expect one with the exit status.
+		st.ExitedAt = time.Now()
 	}
 
 	return &taskapi.DeleteResponse{
@@ -96,10 +108,13 @@ func (p *initProcess) Delete(ctx context.Context) (retState pState, retErr error
 		span.End()
 	}()
 
+	p.waitKillEscalation(ctx)
 	if !p.ProcessState().Exited() {
 		var st pState
 		if err := getUnitState(ctx, p.systemd, p.Name(), &st); err == nil {
-			if !st.Exited() {
+			// Containers that were created but never started can be deleted like the runc shim allows, the runtime's
+			// init process waiting for the start is killed with the unit below.
+			if !st.Exited() && !p.createdOnly(ctx) {
 				return pState{}, fmt.Errorf("container has not exited: %w, %s", errdefs.ErrFailedPrecondition, p.ProcessState())
 			}
 		}
@@ -127,12 +142,16 @@ func (p *initProcess) Delete(ctx context.Context) (retState pState, retErr error
 
 	p.systemd.KillUnitContext(ctx, p.Name(), int32(syscall.SIGKILL))
 
-	if err := p.runc.Delete(ctx, p.id, &runc.DeleteOpts{Force: true}); err != nil {
+	if err := p.deleteContainer(ctx); err != nil {
 		return pState{}, err
 	}
 
 	var ps pState
 	if p.Pid() > 0 {
+		// The exit may have been recorded before the unit change came in.
+		if err := p.LoadState(ctx); err != nil {
+			log.G(ctx).WithError(err).Debug("Error loading process state")
+		}
 		var err error
 		ps, err = p.waitForExit(ctx)
 		if err != nil {
@@ -141,20 +160,25 @@ func (p *initProcess) Delete(ctx context.Context) (retState pState, retErr error
 	}
 
 	if p.Terminal {
-		p.systemd.KillUnitContext(ctx, unitName(p.ns, p.id, "tty"), 9)
+		p.systemd.KillUnitContext(ctx, p.ttyUnitName(), 9)
 	}
 
-	if err := os.Remove("/run/systemd/system/" + p.Name()); err != nil {
-		return pState{}, err
+	if err := p.cleanupRootfs(ctx); err != nil {
+		return pState{}, fmt.Errorf("error unmounting rootfs: %w", err)
 	}
-	if err := p.systemd.ReloadContext(ctx); err != nil {
-		log.G(ctx).WithError(err).Error("systemd reload failed")
+
+	if err := p.removeUnit(ctx, p.Name()); err != nil {
+		return pState{}, err
 	}
 
 	if err := p.systemd.ResetFailedUnitContext(ctx, p.Name()); err != nil && !strings.Contains(err.Error(), "not loaded") {
 		// Just a debug message since this is just precautionary and the unit may not even be failed.
 		log.G(ctx).WithError(err).Debug("Failed to reset systemd unit")
 	}
+	p.cniDel(ctx)
+	p.cleanupNetworkd(ctx)
+	p.cleanupFiles(ctx)
+	p.unlockRuntimeState(ctx)
 
 	p.mu.Lock()
 	p.deleted = true
@@ -164,6 +188,23 @@ func (p *initProcess) Delete(ctx context.Context) (retState pState, retErr error
 	return ps, nil
 }
 
+// createdOnly returns true if the container was created but not started yet.
+func (p *initProcess) createdOnly(ctx context.Context) bool {
+	if p.Pid() == 0 {
+		return false
+	}
+	var st *runc.Container
+	err := runcCall(ctx, func(ctx context.Context) (err error) {
+		st, err = p.runc.State(ctx, p.id)
+		return err
+	})
+	if err != nil {
+		log.G(ctx).WithError(err).Debug("Error getting runtime state")
+		return false
+	}
+	return st.Status == "created"
+}
+
 // TODO: It seems like the runc shim deletes the init process in this case
 // Here we are cleaning up the exec process, which is different, but seems more correct...
 // That said this may cause some unexpected behavior as related to the runc shim.
@@ -182,6 +223,7 @@ func (p *execProcess) Delete(ctx context.Context) (retState pState, retErr error
 		span.End()
 	}()
 
+	p.waitKillEscalation(ctx)
 	if p.Pid() != 0 && !p.ProcessState().Exited() && !p.parent.ProcessState().Exited() {
 		return pState{}, fmt.Errorf("exec has not exited: %w", errdefs.ErrFailedPrecondition)
 	}
@@ -205,6 +247,10 @@ func (p *execProcess) Delete(ctx context.Context) (retState pState, retErr error
 
 	var ps pState
 	if p.Pid() > 0 {
+		// The exit may have been recorded before the unit change came in.
+		if err := p.LoadState(ctx); err != nil {
+			log.G(ctx).WithError(err).Debug("Error loading process state")
+		}
 		var err error
 		ps, err = p.waitForExit(ctx)
 		if err != nil {
@@ -217,18 +263,18 @@ func (p *execProcess) Delete(ctx context.Context) (retState pState, retErr error
 	p.mu.Unlock()
 
 	p.parent.execs.Delete(p.execID)
-	if err := os.Remove("/run/systemd/system/" + p.Name()); err != nil {
+	if err := p.removeUnit(ctx, p.Name()); err != nil {
 		log.G(ctx).WithError(err).Debug("Failed to remove exec unit")
 	}
-
-	if err := p.systemd.ReloadContext(ctx); err != nil {
-		log.G(ctx).WithError(err).Error("systemd reload failed")
-	}
 	p.systemd.ResetFailedUnitContext(ctx, p.Name())
 
+	removeTTYSock(ctx, filepath.Join(p.stateDir(), "tty.sock"))
 	if err := os.RemoveAll(p.stateDir()); err != nil && !os.IsNotExist(err) {
 		log.G(ctx).WithError(err).Debug("Failed to remove exec state dir")
 	}
+	if err := os.RemoveAll(filepath.Dir(p.exitStatusPath())); err != nil {
+		log.G(ctx).WithError(err).Debug("Failed to remove exec exit status")
+	}
 
 	return ps, nil
 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// The device rules from the spec are mirrored on the unit with DevicePolicy/DeviceAllow.
+// runc still sets up the exact rules on the container's cgroup, the unit gets an allow list that is at least as wide
+// so the access shows up in `systemctl show` and is not lost when systemd re-applies the unit's cgroup settings.
+//
+// systemd only knows allow lists, when the rules don't deny by default nothing is set on the unit.
+// The rules are only set when the unit is created, runc doesn't change device rules on update either.
+
+// runcDefaultDevices are allowed by runc for every container, on top of what DevicePolicy=closed allows.
+var runcDefaultDevices = []deviceAllow{
+	// mknod of any device, the rules decide if it can be used.
+	{Path: "char-*", Permissions: "m"},
+	{Path: "block-*", Permissions: "m"},
+	// /dev/net/tun
+	{Path: "/dev/char/10:200", Permissions: "rwm"},
+}
+
+// deviceAllow is a DeviceAllow entry, this is also how systemd expects it over D-Bus.
+type deviceAllow struct {
+	Path        string
+	Permissions string
+}
+
+// deviceRule is an evaluated device cgroup rule, -1 is a wildcard.
+type deviceRule struct {
+	typ    string
+	major  int64
+	minor  int64
+	access string
+}
+
+// deviceAllowList evaluates the OCI device rules like the kernel does and returns what is allowed.
+// ok is false if the rules allow everything not explicitly denied.
+func deviceAllowList(rules []specs.LinuxDeviceCgroup) (allow []deviceRule, ok bool) {
+	defaultAllow := true
+	for _, r := range rules {
+		rule := deviceRule{typ: r.Type, major: -1, minor: -1, access: r.Access}
+		if rule.typ == "" {
+			rule.typ = "a"
+		}
+		if rule.access == "" {
+			rule.access = "rwm"
+		}
+		if r.Major != nil {
+			rule.major = *r.Major
+		}
+		if r.Minor != nil {
+			rule.minor = *r.Minor
+		}
+
+		if rule.typ == "a" && rule.major == -1 && rule.minor == -1 {
+			defaultAllow = r.Allow
+			allow = nil
+			continue
+		}
+		if defaultAllow {
+			// Denying single devices is for runc to handle.
+			continue
+		}
+
+		if r.Allow {
+			allow = append(allow, rule)
+			continue
+		}
+		// Remove the denied access from matching entries.
+		ls := allow[:0]
+		for _, a := range allow {
+			if a.typ == rule.typ && a.major == rule.major && a.minor == rule.minor {
+				a.access = strings.Map(func(c rune) rune {
+					if strings.ContainsRune(rule.access, c) {
+						return -1
+					}
+					return c
+				}, a.access)
+				if a.access == "" {
+					continue
+				}
+			}
+			ls = append(ls, a)
+		}
+		allow = ls
+	}
+	return allow, !defaultAllow
+}
+
+// deviceAllowEntries converts the device rules and the devices created in the container to DeviceAllow entries.
+// Entries are never narrower than the rule they come from.
+func deviceAllowEntries(rules []deviceRule, devices []specs.LinuxDevice) []deviceAllow {
+	entries := append([]deviceAllow(nil), runcDefaultDevices...)
+
+	for _, r := range rules {
+		types := []string{r.typ}
+		if r.typ == "a" {
+			types = []string{"c", "b"}
+		}
+		for _, t := range types {
+			for _, p := range devicePaths(t, r.major, r.minor) {
+				entries = append(entries, deviceAllow{Path: p, Permissions: r.access})
+			}
+		}
+	}
+
+	// runc allows the devices it creates in the container.
+	for _, d := range devices {
+		switch d.Type {
+		case "c", "u", "b":
+			for _, p := range devicePaths(d.Type, d.Major, d.Minor) {
+				entries = append(entries, deviceAllow{Path: p, Permissions: "rwm"})
+			}
+		}
+	}
+	return entries
+}
+
+// devicePaths returns how systemd refers to a device.
+// Specific devices are addressed by number, "<char|block>-<name>" from /proc/devices is used for all minors of a
+// major. Anything else has to be widened to all devices of that type.
+func devicePaths(typ string, major, minor int64) []string {
+	kind := "char"
+	if typ == "b" {
+		kind = "block"
+	}
+	if major == -1 {
+		return []string{kind + "-*"}
+	}
+	if minor == -1 {
+		// A major can be registered under several names, systemd resolves each of them to the same major.
+		names := deviceMajorNames(kind, major)
+		if len(names) == 0 {
+			return []string{kind + "-*"}
+		}
+		paths := make([]string, 0, len(names))
+		for _, n := range names {
+			paths = append(paths, kind+"-"+n)
+		}
+		return paths
+	}
+	return []string{fmt.Sprintf("/dev/%s/%d:%d", kind, major, minor)}
+}
+
+// deviceMajorNames looks up the driver names of a device major in /proc/devices.
+func deviceMajorNames(kind string, major int64) []string {
+	f, err := os.Open("/proc/devices")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	// The file has a "Character devices:" and a "Block devices:" section.
+	var (
+		section string
+		names   []string
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "Character"):
+			section = "char"
+			continue
+		case strings.HasPrefix(line, "Block"):
+			section = "block"
+			continue
+		}
+		if section != kind {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || strings.Contains(fields[1], "/") {
+			continue
+		}
+		if n, err := strconv.ParseInt(fields[0], 10, 64); err == nil && n == major {
+			names = append(names, fields[1])
+		}
+	}
+	return names
+}
+
+// deviceOptions are the unit options for the device rules in the spec.
+func deviceOptions(spec *specs.Spec) []*unit.UnitOption {
+	if rootless {
+		// The user instance of systemd can't restrict devices.
+		return nil
+	}
+	if spec.Linux == nil || spec.Linux.Resources == nil {
+		return nil
+	}
+	rules, ok := deviceAllowList(spec.Linux.Resources.Devices)
+	if !ok {
+		return nil
+	}
+
+	opts := []*unit.UnitOption{unit.NewUnitOption("Service", "DevicePolicy", "closed")}
+	for _, e := range deviceAllowEntries(rules, spec.Linux.Devices) {
+		opts = append(opts, unit.NewUnitOption("Service", "DeviceAllow", e.Path+" "+e.Permissions))
+	}
+	return opts
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// diagnosticLines is how many lines of the journal and runtime log are included in a startError.
+	diagnosticLines = 20
+	// diagnosticTimeout bounds collecting the diagnostics, the start already failed and the caller is waiting.
+	diagnosticTimeout = 5 * time.Second
+
+	// criuRestoreLog is the log criu writes to the work directory when restoring a checkpoint.
+	criuRestoreLog = "restore.log"
+	// restoreConsoleTimeout is how long the console proxy of a restored container has to get the pty, it is checked
+	// every restoreConsoleInterval.
+	restoreConsoleTimeout  = 10 * time.Second
+	restoreConsoleInterval = 100 * time.Millisecond
+)
+
+// startError is returned when a unit fails to start.
+// It carries what is needed to tell why without going to the node: the unit state from systemd, the last lines the
+// unit logged and the tail of the runtime's log.
+type startError struct {
+	err error
+
+	Unit        string
+	LoadState   string
+	ActiveState string
+	SubState    string
+	Result      string
+	// Journal is the end of what the unit logged in its last run.
+	Journal []string
+	// RuntimeLog is the end of the OCI runtime's log.
+	RuntimeLog []string
+	// CriuLog is the end of the criu log when restoring a checkpoint, see restoreDiagnostics.
+	CriuLog []string
+	// UnitFile is the unit file, only set in debug mode.
+	UnitFile string
+}
+
+func (e *startError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.err.Error())
+	fmt.Fprintf(&b, ": unit %s: load=%s active=%s sub=%s result=%s", e.Unit, e.LoadState, e.ActiveState, e.SubState, e.Result)
+	writeLines := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		b.WriteString("\n" + title + ":")
+		for _, l := range lines {
+			b.WriteString("\n  " + l)
+		}
+	}
+	writeLines("journal", e.Journal)
+	writeLines("runtime log", e.RuntimeLog)
+	writeLines("criu log", e.CriuLog)
+	if e.UnitFile != "" {
+		writeLines("unit file", strings.Split(strings.TrimSpace(e.UnitFile), "\n"))
+	}
+	return b.String()
+}
+
+func (e *startError) Unwrap() error {
+	return e.err
+}
+
+// startDiagnostics wraps err, the reason the unit failed to start, in a startError.
+// The diagnostics are also added to the span in ctx. Anything that can't be collected is left out.
+func (p *process) startDiagnostics(ctx context.Context, name string, err error) error {
+	var se *startError
+	if errors.As(err, &se) {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, diagnosticTimeout)
+	defer cancel()
+
+	se = &startError{err: err, Unit: name}
+	var invocation string
+	if props, err := p.systemd.GetAllPropertiesContext(ctx, name); err == nil {
+		se.LoadState, _ = props["LoadState"].(string)
+		se.ActiveState, _ = props["ActiveState"].(string)
+		se.SubState, _ = props["SubState"].(string)
+		se.Result, _ = props["Result"].(string)
+		if id, _ := props["InvocationID"].([]byte); len(id) > 0 {
+			invocation = hex.EncodeToString(id)
+		}
+	}
+	se.Journal = unitJournal(ctx, name, invocation)
+	se.RuntimeLog = p.runtimeLogTail(diagnosticLines)
+	if p.runc != nil && p.runc.Debug {
+		if data, err := os.ReadFile(p.unitFilePath(name)); err == nil {
+			se.UnitFile = string(data)
+		}
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("unit.load_state", se.LoadState),
+		attribute.String("unit.active_state", se.ActiveState),
+		attribute.String("unit.sub_state", se.SubState),
+		attribute.String("unit.result", se.Result),
+		attribute.StringSlice("unit.journal", se.Journal),
+		attribute.StringSlice("runtime.log", se.RuntimeLog),
+	)
+	return se
+}
+
+// unitJournal returns the last lines the unit logged.
+// With the invocation ID only the last run of the unit is included, units are reused when a container is restarted.
+func unitJournal(ctx context.Context, name, invocation string) []string {
+	journalctl, err := exec.LookPath("journalctl")
+	if err != nil {
+		return nil
+	}
+	args := []string{"--no-pager", "--output=short-iso", "--lines=" + strconv.Itoa(diagnosticLines)}
+	if rootless {
+		args = append(args, "--user")
+	}
+	if invocation != "" {
+		args = append(args, "_SYSTEMD_INVOCATION_ID="+invocation)
+	} else if rootless {
+		args = append(args, "--user-unit="+name)
+	} else {
+		args = append(args, "--unit="+name)
+	}
+	out, err := exec.CommandContext(ctx, journalctl, args...).Output()
+	if err != nil {
+		return nil
+	}
+	return splitLines(out)
+}
+
+// tailFile returns the last n lines of the file.
+func tailFile(p string, n int) []string {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil
+	}
+	lines := splitLines(data)
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+func splitLines(data []byte) []string {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(string(data), "\n")
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/typeurl"
+	ptypes "github.com/gogo/protobuf/types"
+)
+
+const (
+	eventJournalFile = "journal"
+	eventAckedFile   = "acked"
+)
+
+// eventJournal persists events until containerd has received them.
+// Each namespace gets an append-only journal of events with sequence numbers, and a file with the sequence number up to
+// which all events were published. Events that were never published, e.g. because containerd was down, the queue was
+// full or the shim was restarted, are replayed on startup. Events published out of order after one that wasn't may be
+// replayed as well, containerd gets every event at least once.
+type eventJournal struct {
+	root string
+
+	mu  sync.Mutex
+	nss map[string]*nsJournal
+	// replay holds the events which were not published by a previous instance of the shim.
+	replay []eventEnvelope
+}
+
+type nsJournal struct {
+	f   *os.File
+	seq uint64
+	// acked is the sequence number up to which all events are published.
+	acked uint64
+	// published holds the events after acked that are published, acked moves past them once the events before them
+	// are published too.
+	published map[uint64]bool
+}
+
+type journalRecord struct {
+	Seq       uint64
+	Topic     string
+	Timestamp time.Time
+	Event     *ptypes.Any
+}
+
+// newEventJournal loads the existing journals from root.
+func newEventJournal(ctx context.Context, root string) *eventJournal {
+	ctx = withLogSubsystem(ctx, logEvents)
+	j := &eventJournal{root: root, nss: make(map[string]*nsJournal)}
+
+	dirs, err := os.ReadDir(root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.G(ctx).WithError(err).Warn("Error reading event journals")
+		}
+		return j
+	}
+
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		if err := j.load(d.Name()); err != nil {
+			log.G(ctx).WithError(err).WithField("ns", d.Name()).Warn("Error loading event journal")
+		}
+	}
+	if len(j.replay) > 0 {
+		log.G(ctx).WithField("events", len(j.replay)).Info("Found unpublished events")
+	}
+	return j
+}
+
+func (j *eventJournal) load(ns string) error {
+	nj := &nsJournal{published: make(map[uint64]bool)}
+
+	if data, err := os.ReadFile(filepath.Join(j.root, ns, eventAckedFile)); err == nil {
+		nj.acked, _ = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+	nj.seq = nj.acked
+
+	f, err := os.Open(filepath.Join(j.root, ns, eventJournalFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			j.nss[ns] = nj
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// Most likely a partial write from a crash, anything after it can't be trusted.
+			break
+		}
+		if rec.Seq > nj.seq {
+			nj.seq = rec.Seq
+		}
+		if rec.Seq <= nj.acked {
+			continue
+		}
+		e, err := typeurl.UnmarshalAny(rec.Event)
+		if err != nil {
+			continue
+		}
+		j.replay = append(j.replay, eventEnvelope{ns: ns, e: e, seq: rec.Seq})
+	}
+
+	j.nss[ns] = nj
+	return scanner.Err()
+}
+
+// Replay returns the events that still need to be published from a previous run.
+func (j *eventJournal) Replay() []eventEnvelope {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	r := j.replay
+	j.replay = nil
+	return r
+}
+
+// Append writes the event to the journal for the namespace and returns its sequence number.
+func (j *eventJournal) Append(ns string, e interface{}) (uint64, error) {
+	a, err := typeurl.MarshalAny(e)
+	if err != nil {
+		return 0, err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	nj := j.nss[ns]
+	if nj == nil {
+		nj = &nsJournal{published: make(map[uint64]bool)}
+		j.nss[ns] = nj
+	}
+	if nj.f == nil {
+		if err := os.MkdirAll(filepath.Join(j.root, ns), 0700); err != nil {
+			return 0, err
+		}
+		f, err := os.OpenFile(filepath.Join(j.root, ns, eventJournalFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return 0, err
+		}
+		nj.f = f
+	}
+
+	rec := journalRecord{Seq: nj.seq + 1, Topic: GetTopic(e), Timestamp: time.Now(), Event: &ptypes.Any{TypeUrl: a.TypeUrl, Value: a.Value}}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := nj.f.Write(append(data, '\n')); err != nil {
+		return 0, fmt.Errorf("error writing event journal: %w", err)
+	}
+	nj.seq = rec.Seq
+	return rec.Seq, nil
+}
+
+// Ack records that the event with the sequence number has been published.
+// The acked sequence number only moves up to the first event that is not published yet, so an event that was skipped
+// is still replayed. Once everything in the journal is published it is truncated so it doesn't grow forever.
+func (j *eventJournal) Ack(ns string, seq uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	nj := j.nss[ns]
+	if nj == nil || seq <= nj.acked {
+		return nil
+	}
+	nj.published[seq] = true
+	if seq != nj.acked+1 {
+		return nil
+	}
+	for nj.published[nj.acked+1] {
+		delete(nj.published, nj.acked+1)
+		nj.acked++
+	}
+
+	dir := filepath.Join(j.root, ns)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, eventAckedFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(nj.acked, 10)), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, eventAckedFile)); err != nil {
+		return err
+	}
+
+	if nj.acked == nj.seq && nj.f != nil {
+		if err := nj.f.Truncate(0); err != nil {
+			return fmt.Errorf("error truncating event journal: %w", err)
+		}
+	}
+	return nil
+}
+
+// Events reads the events with the sequence numbers back from the journal of the namespace.
+// Events that were published in the meantime are left out.
+func (j *eventJournal) Events(ns string, seqs []uint64) ([]eventEnvelope, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	nj := j.nss[ns]
+	if nj == nil {
+		return nil, nil
+	}
+	want := make(map[uint64]bool, len(seqs))
+	for _, seq := range seqs {
+		if seq > nj.acked && !nj.published[seq] {
+			want[seq] = true
+		}
+	}
+	if len(want) == 0 {
+		return nil, nil
+	}
+
+	f, err := os.Open(filepath.Join(j.root, ns, eventJournalFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []eventEnvelope
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() && len(events) < len(want) {
+		var rec journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			break
+		}
+		if !want[rec.Seq] {
+			continue
+		}
+		e, err := typeurl.UnmarshalAny(rec.Event)
+		if err != nil {
+			continue
+		}
+		events = append(events, eventEnvelope{ns: ns, e: e, seq: rec.Seq})
+	}
+	return events, scanner.Err()
+}
+
+func (j *eventJournal) Close() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, nj := range j.nss {
+		if nj.f != nil {
+			nj.f.Close()
+			nj.f = nil
+		}
+	}
+}
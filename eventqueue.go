@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+)
+
+// Policies for when the event queue is full.
+const (
+	// eventQueueBlock makes the request that sends the event wait until there is room in the queue.
+	eventQueueBlock = "block"
+	// eventQueueDrop doesn't queue the event, it is read back from the event journal once the queue of the namespace
+	// is drained.
+	eventQueueDrop = "drop"
+
+	defaultEventQueueSize    = 1024
+	defaultEventFlushTimeout = 10 * time.Second
+)
+
+func validateEventQueuePolicy(s string) error {
+	switch s {
+	case eventQueueBlock, eventQueueDrop:
+		return nil
+	default:
+		return fmt.Errorf("invalid event queue policy %q: %w", s, errdefs.ErrInvalidArgument)
+	}
+}
+
+// eventQueue queues events for publishing so requests don't wait for containerd to receive them.
+//
+// Each namespace has its own queue and worker, events are published in order within a namespace and a namespace that
+// is slow to publish (e.g. while retrying) doesn't hold up the others. The number of queued events is bounded, what
+// happens when the queue is full is up to the policy.
+type eventQueue struct {
+	policy       string
+	flushTimeout time.Duration
+	// slots limits the number of queued events, each event takes a slot until it is published.
+	slots chan struct{}
+
+	mu sync.Mutex
+	// publish is set once the queue is started, events are only queued before that.
+	publish func(eventEnvelope)
+	// reload reads skipped events back from the event journal.
+	reload func(ns string, seqs []uint64) []eventEnvelope
+	nss    map[string][]queuedEvent
+	// skipped holds the sequence numbers of the events that were not queued because the queue was full.
+	skipped map[string][]uint64
+	workers map[string]bool
+	wg      sync.WaitGroup
+	closed  bool
+	closing chan struct{}
+	// expired is set when the flush timeout passed, anything still queued is left for the next start.
+	expired bool
+}
+
+type queuedEvent struct {
+	eventEnvelope
+	// slot is false for replayed and reloaded events, they were already in the journal and don't count towards the
+	// limit.
+	slot bool
+}
+
+func newEventQueue(size int, policy string, flushTimeout time.Duration) *eventQueue {
+	if size <= 0 {
+		size = defaultEventQueueSize
+	}
+	if policy == "" {
+		policy = eventQueueBlock
+	}
+	return &eventQueue{
+		policy:       policy,
+		flushTimeout: flushTimeout,
+		slots:        make(chan struct{}, size),
+		nss:          make(map[string][]queuedEvent),
+		skipped:      make(map[string][]uint64),
+		workers:      make(map[string]bool),
+		closing:      make(chan struct{}),
+	}
+}
+
+// Push queues the event.
+// With the block policy this waits for room in the queue, also when the request that sends the event is cancelled: a
+// TaskExit must not get lost because the client went away. With the drop policy an event that doesn't fit is read
+// back from the journal later, events that could not be written to the journal are lost.
+func (q *eventQueue) Push(ctx context.Context, e eventEnvelope) {
+	if q.policy == eventQueueDrop {
+		select {
+		case q.slots <- struct{}{}:
+		default:
+			metrics.eventsDropped.Inc(e.ns)
+			l := log.G(ctx).WithField("topic", GetTopic(e.e))
+			if e.seq == 0 {
+				l.Warn("Event queue is full, dropping event")
+				return
+			}
+			l.Warn("Event queue is full, publishing the event from the journal later")
+			q.mu.Lock()
+			q.skipped[e.ns] = append(q.skipped[e.ns], e.seq)
+			q.startWorker(e.ns)
+			q.mu.Unlock()
+			return
+		}
+	} else {
+		select {
+		case q.slots <- struct{}{}:
+		case <-q.closing:
+			return
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		// Stays in the journal for the next start.
+		<-q.slots
+		return
+	}
+	q.nss[e.ns] = append(q.nss[e.ns], queuedEvent{eventEnvelope: e, slot: true})
+	q.startWorker(e.ns)
+}
+
+// Start starts publishing with publish, reload reads the events skipped by the drop policy back from the journal.
+// The replayed events are published before anything that was queued so far.
+func (q *eventQueue) Start(publish func(eventEnvelope), reload func(ns string, seqs []uint64) []eventEnvelope, replay []eventEnvelope) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.publish = publish
+	q.reload = reload
+	byNS := make(map[string][]queuedEvent)
+	for _, e := range replay {
+		byNS[e.ns] = append(byNS[e.ns], queuedEvent{eventEnvelope: e})
+	}
+	for ns, ls := range byNS {
+		q.nss[ns] = append(ls, q.nss[ns]...)
+	}
+	for ns := range q.nss {
+		q.startWorker(ns)
+	}
+	for ns := range q.skipped {
+		q.startWorker(ns)
+	}
+}
+
+// startWorker starts the worker for the namespace if it isn't running, q.mu must be held.
+func (q *eventQueue) startWorker(ns string) {
+	if q.publish == nil || q.workers[ns] || len(q.nss[ns])+len(q.skipped[ns]) == 0 {
+		return
+	}
+	q.workers[ns] = true
+	q.wg.Add(1)
+	go q.run(ns)
+}
+
+// run publishes the events of the namespace until its queue is empty.
+// Skipped events are reloaded from the journal once the queue is drained, at most as many at a time as fit in the
+// queue. They are published after the events that were queued after them.
+func (q *eventQueue) run(ns string) {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		ls := q.nss[ns]
+		if len(ls) == 0 && len(q.skipped[ns]) > 0 && !q.closed {
+			seqs := q.skipped[ns]
+			if len(seqs) > cap(q.slots) {
+				seqs = seqs[:cap(q.slots)]
+			}
+			q.skipped[ns] = q.skipped[ns][len(seqs):]
+			if len(q.skipped[ns]) == 0 {
+				delete(q.skipped, ns)
+			}
+			q.mu.Unlock()
+
+			reloaded := q.reload(ns, seqs)
+
+			q.mu.Lock()
+			for _, e := range reloaded {
+				q.nss[ns] = append(q.nss[ns], queuedEvent{eventEnvelope: e})
+			}
+			ls = q.nss[ns]
+		}
+		if len(ls) == 0 {
+			delete(q.nss, ns)
+			delete(q.workers, ns)
+			q.mu.Unlock()
+			return
+		}
+		e := ls[0]
+		q.nss[ns] = ls[1:]
+		expired := q.expired
+		q.mu.Unlock()
+
+		if !expired {
+			q.publish(e.eventEnvelope)
+		}
+		if e.slot {
+			<-q.slots
+		}
+	}
+}
+
+// Close stops accepting events, the queued events are still published.
+// Events that are not published within the flush timeout stay in the journal and are replayed on the next start.
+func (q *eventQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.closing)
+
+	time.AfterFunc(q.flushTimeout, func() {
+		q.mu.Lock()
+		q.expired = true
+		q.mu.Unlock()
+	})
+}
+
+// Wait waits until the queue is closed and everything is published.
+func (q *eventQueue) Wait() {
+	<-q.closing
+	q.wg.Wait()
+}
+
+// Depth returns the number of queued events per namespace.
+func (q *eventQueue) Depth() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	depth := make(map[string]int, len(q.nss))
+	for ns, ls := range q.nss {
+		depth[ns] = len(ls)
+	}
+	return depth
+}
+
+// writeMetrics writes the queue depth per namespace.
+func (q *eventQueue) writeMetrics(w io.Writer) {
+	depth := q.Depth()
+	nss := make([]string, 0, len(depth))
+	for ns := range depth {
+		nss = append(nss, ns)
+	}
+	sort.Strings(nss)
+
+	name := metricsPrefix + "event_queue_depth"
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, "Number of events waiting to be published to containerd.", name)
+	for _, ns := range nss {
+		fmt.Fprintf(w, "%s%s %d\n", name, formatLabels([]string{"namespace"}, []string{ns}, "", ""), depth[ns])
+	}
+}
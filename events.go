@@ -3,38 +3,104 @@ package main
 import (
 	"context"
 	"io"
+	"time"
 
 	eventsapi "github.com/containerd/containerd/api/events"
 	"github.com/containerd/containerd/events"
+	"github.com/containerd/containerd/log"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/runtime"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	publishRetryMin = 100 * time.Millisecond
+	publishRetryMax = 5 * time.Second
+	// publishTimeout bounds publishing once the shim is shutting down.
+	publishTimeout = 5 * time.Second
+)
+
+// Forward publishes the queued events until the service is closed.
 func (s *Service) Forward(ctx context.Context, publisher events.Publisher) {
-	for e := range s.events {
-		ctx := namespaces.WithNamespace(ctx, e.ns)
-		err := publisher.Publish(ctx, GetTopic(e.e), e.e)
+	ctx = withLogSubsystem(ctx, logEvents)
+	// Events from a previous run go out first so containerd sees them in order.
+	s.queue.Start(func(e eventEnvelope) {
+		s.publish(ctx, publisher, e)
+	}, func(ns string, seqs []uint64) []eventEnvelope {
+		events, err := s.journal.Events(ns, seqs)
 		if err != nil {
-			logrus.WithError(err).Error("post event")
+			log.G(ctx).WithError(err).WithField("ns", ns).Warn("Error reading skipped events from the journal")
 		}
-	}
+		return events
+	}, s.journal.Replay())
+	s.queue.Wait()
+
 	if closer, ok := publisher.(io.Closer); ok {
 		closer.Close()
 	}
 	close(s.waitEvents)
 }
 
+// publish publishes the event, retrying until it succeeds or ctx is cancelled.
+// Once ctx is cancelled there is one more attempt, if that fails the event stays in the journal and is replayed on the
+// next start.
+func (s *Service) publish(ctx context.Context, publisher events.Publisher, e eventEnvelope) {
+	topic := GetTopic(e.e)
+	backoff := publishRetryMin
+	for {
+		pctx := ctx
+		if ctx.Err() != nil {
+			var cancel func()
+			pctx, cancel = context.WithTimeout(context.Background(), publishTimeout)
+			defer cancel()
+		}
+
+		err := publisher.Publish(namespaces.WithNamespace(pctx, e.ns), topic, e.e)
+		if err == nil {
+			if e.seq > 0 {
+				if err := s.journal.Ack(e.ns, e.seq); err != nil {
+					logrus.WithError(err).Warn("Error updating event journal")
+				}
+			}
+			return
+		}
+
+		l := logrus.WithError(err).WithField("topic", topic).WithField("seq", e.seq)
+		if ctx.Err() != nil {
+			l.Error("post event")
+			return
+		}
+		l.Warn("post event, retrying")
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > publishRetryMax {
+			backoff = publishRetryMax
+		}
+	}
+}
+
 type eventEnvelope struct {
 	ns string
 	e  interface{}
+	// seq is the sequence number in the event journal, 0 if the event could not be written to the journal.
+	seq uint64
 }
 
 func (s *Service) send(ctx context.Context, ns string, e interface{}) {
-	select {
-	case <-ctx.Done():
-	case s.events <- eventEnvelope{ns, e}:
+	ctx = withLogSubsystem(ctx, logEvents)
+	// The event is written to the journal before it is queued so it survives containerd being unavailable or the shim
+	// being restarted. If the journal can't be written we still try to deliver it.
+	seq, err := s.journal.Append(ns, e)
+	if err != nil {
+		log.G(ctx).WithError(err).WithField("topic", GetTopic(e)).Warn("Error writing event to journal")
 	}
+
+	s.queue.Push(ctx, eventEnvelope{ns: ns, e: e, seq: seq})
 }
 
 // GetTopic converts an event from an interface type to the specific
@@ -61,6 +127,12 @@ func GetTopic(e interface{}) string {
 		return runtime.TaskResumedEventTopic
 	case *eventsapi.TaskCheckpointed:
 		return runtime.TaskCheckpointedEventTopic
+	case *options.TaskWatchdog:
+		return watchdogEventTopic
+	case *options.TaskRestart:
+		return restartEventTopic
+	case *options.TaskStopped:
+		return stoppedEventTopic
 	default:
 		logrus.Warnf("no topic for type %#v", e)
 	}
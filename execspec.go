@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// processSpec returns the process spec of the exec as it is passed to the runtime.
+// The spec from the client is used as it is unless the shim has to change it.
+func (p *execProcess) processSpec() ([]byte, error) {
+	cspec, err := p.parent.bundleSpec()
+	if err != nil {
+		return nil, err
+	}
+	// runc exec --process takes noNewPrivileges from the process spec as it is, unlike the other security settings it
+	// does not fall back to the container's. An exec of a container with no new privileges must not gain any.
+	noNewPrivileges := cspec.Process != nil && cspec.Process.NoNewPrivileges
+
+	if !p.Terminal && !p.opts.Terminal && !p.parent.opts.ExecInherit && !noNewPrivileges {
+		return p.Spec.Value, nil
+	}
+
+	var spec specs.Process
+	if err := json.Unmarshal(p.Spec.Value, &spec); err != nil {
+		return nil, fmt.Errorf("error unmarshaling spec: %w", err)
+	}
+	if p.Terminal || p.opts.Terminal {
+		spec.Terminal = true
+	}
+	if noNewPrivileges {
+		spec.NoNewPrivileges = true
+	}
+	if p.parent.opts.ExecInherit {
+		if err := p.inheritProcess(&spec, p.Spec.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling spec: %w", err)
+	}
+	return v, nil
+}
+
+// inheritProcess fills in the env, cwd and user the exec process spec leaves out from the process of the container,
+// like runc exec does when it is given a command instead of a process spec. A user is only left out when the spec
+// has no "user" at all, a zero user is root.
+func (p *execProcess) inheritProcess(spec *specs.Process, raw []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return fmt.Errorf("error unmarshaling spec: %w", err)
+	}
+	_, hasUser := fields["user"]
+	if len(spec.Env) > 0 && spec.Cwd != "" && hasUser {
+		return nil
+	}
+
+	cspec, err := readFullBundleSpec(p.parent.Bundle)
+	if err != nil {
+		return err
+	}
+	if cspec.Process == nil {
+		return nil
+	}
+	if len(spec.Env) == 0 {
+		spec.Env = cspec.Process.Env
+	}
+	if spec.Cwd == "" {
+		spec.Cwd = cspec.Process.Cwd
+	}
+	if !hasUser {
+		spec.User = cspec.Process.User
+	}
+	return nil
+}
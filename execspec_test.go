@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	ptypes "github.com/gogo/protobuf/types"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestExecProcessSpec(t *testing.T) {
+	container := &specs.Process{
+		Env:  []string{"PATH=/bin", "HOME=/root"},
+		Cwd:  "/work",
+		User: specs.User{UID: 1000, GID: 1000},
+	}
+
+	for _, tc := range []struct {
+		name            string
+		spec            string
+		terminal        bool
+		inherit         bool
+		noNewPrivileges bool
+		// want is nil when the spec is passed on as it is.
+		want *specs.Process
+	}{
+		{
+			name: "unchanged",
+			spec: `{"args":["sh"],"cwd":"/","noNewPrivileges":false,"unknown":true}`,
+		},
+		{
+			name:     "terminal",
+			spec:     `{"args":["sh"],"cwd":"/"}`,
+			terminal: true,
+			want:     &specs.Process{Args: []string{"sh"}, Cwd: "/", Terminal: true},
+		},
+		{
+			name:            "no new privileges of the container",
+			spec:            `{"args":["sh"],"cwd":"/"}`,
+			noNewPrivileges: true,
+			want:            &specs.Process{Args: []string{"sh"}, Cwd: "/", NoNewPrivileges: true},
+		},
+		{
+			name:            "no new privileges of the exec",
+			spec:            `{"args":["sh"],"cwd":"/","noNewPrivileges":true}`,
+			noNewPrivileges: true,
+			want:            &specs.Process{Args: []string{"sh"}, Cwd: "/", NoNewPrivileges: true},
+		},
+		{
+			name:    "inherit",
+			spec:    `{"args":["sh"]}`,
+			inherit: true,
+			want:    &specs.Process{Args: []string{"sh"}, Env: container.Env, Cwd: container.Cwd, User: container.User},
+		},
+		{
+			name:    "inherit root user",
+			spec:    `{"args":["sh"],"cwd":"/","env":["A=b"],"user":{"uid":0,"gid":0}}`,
+			inherit: true,
+			want:    &specs.Process{Args: []string{"sh"}, Env: []string{"A=b"}, Cwd: "/"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bundle := t.TempDir()
+			cp := *container
+			cp.NoNewPrivileges = tc.noNewPrivileges
+			data, err := json.Marshal(&specs.Spec{Process: &cp})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(bundle, "config.json"), data, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			p := &execProcess{
+				process: &process{Terminal: tc.terminal},
+				Spec:    &ptypes.Any{Value: []byte(tc.spec)},
+				parent:  &initProcess{process: &process{opts: CreateOptions{ExecInherit: tc.inherit}}, Bundle: bundle},
+			}
+			v, err := p.processSpec()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.want == nil {
+				if string(v) != tc.spec {
+					t.Errorf("spec was changed: %s", v)
+				}
+				return
+			}
+			var got specs.Process
+			if err := json.Unmarshal(v, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(&got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, *tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/containerd/cgroups"
+	cgroupsv2 "github.com/containerd/cgroups/v2"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/typeurl"
+	"github.com/coreos/go-systemd/unit"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+	"github.com/gogo/protobuf/proto"
+)
+
+// execCgroupAnnotation selects the cgroup exec processes run in.
+// "container" (the default) keeps them in the container's cgroup where they share its limits. With "unit" they are
+// moved to the cgroup of their exec unit, so they are accounted on their own and can't starve the container.
+const execCgroupAnnotation = shimName + ".exec-cgroup"
+
+// execPropertyAnnotationPrefix is the prefix for annotations that set properties on the exec units of the container,
+// e.g. "systemd.exec-property.TasksMax=32". Setting any implies the "unit" exec cgroup, they would have no effect
+// on the processes otherwise.
+const execPropertyAnnotationPrefix = "systemd.exec-property."
+
+// execTimeoutAnnotation is the maximum lifetime of the exec processes of the container, as a time span accepted by
+// parseUnitDuration. The exec unit is stopped by systemd (RuntimeMaxSec) once it is reached. It takes precedence over
+// the shim's --exec-timeout, "0" disables it.
+const execTimeoutAnnotation = shimName + ".exec-timeout"
+
+// execDetachedLogAnnotation sets where the output of exec processes created without any stdio goes.
+// "null" (the default) discards it like any other process without stdio. With "journald" the output is logged under
+// the exec unit, tagged with CONTAINER_EXEC_ID, so health check style execs need no IO plumbing on the client side and
+// their output can still be looked up later.
+const execDetachedLogAnnotation = shimName + ".exec-detached-log"
+
+// execInheritAnnotation fills in the env, cwd and user exec process specs leave out from the process of the container
+// when set to "true", see inheritProcess.
+const execInheritAnnotation = shimName + ".exec-inherit"
+
+const (
+	// execTimeoutEnv is set in exec units with a timeout so the exit helper can tell a timeout from other failures.
+	execTimeoutEnv = "EXEC_TIMEOUT"
+	// execTimeoutExitCode is the exit code of an exec that was stopped because it ran into the timeout.
+	// This is what timeout(1) uses.
+	execTimeoutExitCode = 124
+	// serviceResultTimeout is the value of $SERVICE_RESULT when systemd stopped the unit because of RuntimeMaxSec.
+	serviceResultTimeout = "timeout"
+)
+
+// execAnnotations applies the exec unit settings from the container annotations to the create options.
+func execAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	switch v := annotations[execCgroupAnnotation]; v {
+	case "", "container":
+	case "unit":
+		opts.ExecCgroup = true
+	default:
+		return fmt.Errorf("annotation %s: invalid value %q: %w", execCgroupAnnotation, v, errdefs.ErrInvalidArgument)
+	}
+
+	switch v := annotations[execDetachedLogAnnotation]; v {
+	case "", "null":
+	case "journald":
+		opts.ExecJournal = true
+	default:
+		return fmt.Errorf("annotation %s: invalid value %q: %w", execDetachedLogAnnotation, v, errdefs.ErrInvalidArgument)
+	}
+
+	if v := annotations[execInheritAnnotation]; v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("annotation %s: invalid value %q: %w", execInheritAnnotation, v, errdefs.ErrInvalidArgument)
+		}
+		opts.ExecInherit = b
+	}
+
+	if v := annotations[execTimeoutAnnotation]; v != "" {
+		usec, err := parseUnitDuration(v)
+		if err != nil {
+			return fmt.Errorf("annotation %s: %w", execTimeoutAnnotation, err)
+		}
+		opts.ExecTimeout = time.Duration(usec) * time.Microsecond
+		if usec == math.MaxUint64 {
+			opts.ExecTimeout = 0
+		}
+	}
+
+	props, err := propertyAnnotations(annotations, execPropertyAnnotationPrefix)
+	if err != nil {
+		return err
+	}
+	if len(props) > 0 {
+		opts.ExecProperties = props
+		opts.ExecCgroup = true
+	}
+	return nil
+}
+
+// detachedLogMode returns the log mode for an exec with the given stdio.
+// Execs without any stdio go to the journal if the container asks for it, others use the container's log mode.
+func detachedLogMode(opts *CreateOptions, stdin, stdout, stderr string, terminal bool) string {
+	if opts.ExecJournal && stdin == "" && stdout == "" && stderr == "" && !terminal {
+		return options.LogMode_JOURNALD.String()
+	}
+	return opts.LogMode
+}
+
+// timeoutOptions are the unit options to stop the exec once it ran for longer than the timeout.
+func (p *execProcess) timeoutOptions() []*unit.UnitOption {
+	if p.opts.ExecTimeout <= 0 {
+		return nil
+	}
+	usec := strconv.FormatInt(p.opts.ExecTimeout.Microseconds(), 10)
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Service", "RuntimeMaxSec", usec+"us"),
+		unit.NewUnitOption("Service", "Environment", execTimeoutEnv+"="+usec),
+	}
+}
+
+// attachToUnit moves the exec process from the container's cgroup to the cgroup of the exec unit.
+// runc always starts it in the container's cgroup. Anything the process forked before it was moved stays there.
+func (p *execProcess) attachToUnit(ctx context.Context, pid uint32) error {
+	if err := callManager(ctx, p.systemd, "AttachProcessesToUnit", p.Name(), "", []uint32{pid}).Err; err != nil {
+		metrics.dbusErrors.Inc("AttachProcessesToUnit")
+		return fmt.Errorf("error moving exec process to its unit: %w", err)
+	}
+	return nil
+}
+
+// unitCgroupStats reads the metrics of a unit's cgroup from cgroupfs.
+func unitCgroupStats(g string) (proto.Message, error) {
+	if cgroups.Mode() == cgroups.Unified {
+		cg, err := cgroupsv2.LoadManager("/sys/fs/cgroup", g)
+		if err != nil {
+			return nil, err
+		}
+		return cg.Stat()
+	}
+	cg, err := cgroups.Load(cgroups.V1, cgroups.StaticPath(g))
+	if err != nil {
+		return nil, err
+	}
+	return cg.Stat(cgroups.IgnoreNotExist)
+}
+
+// execStats collects the metrics of the running execs that have a cgroup of their own.
+func (p *initProcess) execStats(ctx context.Context) *options.ExecStats {
+	var execs []*execProcess
+	p.execs.Each(func(exec Process) {
+		ep := exec.(*execProcess)
+		if ep.opts.ExecCgroup && ep.Pid() != 0 && !ep.ProcessState().Exited() {
+			execs = append(execs, ep)
+		}
+	})
+
+	var st options.ExecStats
+	for _, ep := range execs {
+		m, err := func() (*options.ExecMetrics, error) {
+			g, err := ep.unitCgroup(ctx, ep.Name())
+			if err != nil {
+				return nil, err
+			}
+			stats, err := unitCgroupStats(g)
+			if err != nil {
+				return nil, err
+			}
+			url, err := typeurl.TypeURL(stats)
+			if err != nil {
+				return nil, err
+			}
+			data, err := proto.Marshal(stats)
+			if err != nil {
+				return nil, err
+			}
+			return &options.ExecMetrics{ExecId: ep.execID, TypeUrl: url, Value: data}, nil
+		}()
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("exec", ep.execID).Debug("Error reading exec stats")
+			continue
+		}
+		st.Execs = append(st.Execs, m)
+	}
+	return &st
+}
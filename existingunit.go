@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+)
+
+// Existing unit policies decide what happens when the unit of a new container or exec is already running, e.g. one
+// left behind by a shim that crashed before it could save the container, or one of another shim with the same unit
+// name template.
+// Stopped units with the name are always reused, their unit file is replaced and their failed state reset.
+const (
+	// existingUnitsReject fails the create with AlreadyExists.
+	existingUnitsReject = "reject"
+	// existingUnitsClean stops the unit, and for containers removes their runtime state, before creating the new one.
+	existingUnitsClean = "clean"
+)
+
+func validateExistingUnitsPolicy(s string) error {
+	switch s {
+	case existingUnitsReject, existingUnitsClean:
+		return nil
+	default:
+		return fmt.Errorf("invalid existing units policy %q: %w", s, errdefs.ErrInvalidArgument)
+	}
+}
+
+// checkUnitName makes sure the unit name of a new container or exec is not in use.
+// A unit of one of our own processes is always an error, a running unit we don't know is handled according to the
+// existing units policy. It returns true if a leftover unit was stopped.
+func (s *Service) checkUnitName(ctx context.Context, name string) (bool, error) {
+	if s.units.Get(name) != nil {
+		return false, fmt.Errorf("unit %s is already in use by another process: %w", name, errdefs.ErrAlreadyExists)
+	}
+
+	units, err := s.conn.ListUnitsByNamesContext(ctx, []string{name})
+	if err != nil {
+		return false, fmt.Errorf("error looking up unit %s: %w", name, err)
+	}
+	if len(units) == 0 {
+		return false, nil
+	}
+	switch state := units[0].ActiveState; state {
+	case "", "inactive", "failed":
+		return false, nil
+	default:
+		if s.existingUnits != existingUnitsClean {
+			return false, fmt.Errorf("unit %s already exists and is %s: %w", name, state, errdefs.ErrAlreadyExists)
+		}
+		log.G(ctx).WithField("unit", name).WithField("state", state).Warn("Stopping leftover unit")
+	}
+
+	ch := make(chan string, 1)
+	if _, err := s.conn.StopUnitContext(ctx, name, "replace", ch); err != nil {
+		return false, fmt.Errorf("error stopping leftover unit %s: %w", name, err)
+	}
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case status := <-ch:
+		if status != "done" {
+			return false, fmt.Errorf("error stopping leftover unit %s: %s", name, status)
+		}
+	}
+	return true, nil
+}
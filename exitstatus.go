@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/log"
+)
+
+// exitStatusFileName is the file in the bundle with the exit status of the container, and in execs/<exec id> of the
+// bundle with the one of an exec. It is written by the unit when the process exits, so it is there even if the shim
+// is not running, and it is kept when systemd forgets the unit. It is meant for other tools and as a last resort when
+// recovering, the shim's own exit state has more details.
+const exitStatusFileName = "exit-status"
+
+// exitStatusFileEnv is the path of the exit status file, passed to the exit command of the unit.
+const exitStatusFileEnv = "EXIT_STATUS_FILE"
+
+// exitStatus is the content of the exit status file, the fields are named like the ones of the TaskExit event.
+type exitStatus struct {
+	Pid        uint32    `json:"pid"`
+	ExitStatus uint32    `json:"exit_status"`
+	ExitedAt   time.Time `json:"exited_at"`
+}
+
+func (p *initProcess) exitStatusPath() string {
+	return filepath.Join(p.Bundle, exitStatusFileName)
+}
+
+func (p *execProcess) exitStatusPath() string {
+	return filepath.Join(p.parent.Bundle, "execs", p.execID, exitStatusFileName)
+}
+
+// writeExitStatusFile writes the exit status of an exited process to path.
+// The file is written to a temporary file first so readers never see a partial one.
+func writeExitStatusFile(path string, st pState) error {
+	data, err := json.Marshal(exitStatus{Pid: st.Pid, ExitStatus: st.ExitCode, ExitedAt: st.ExitedAt})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0711); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeExitStatus writes the exit status file for the exit command of a unit, if the unit has one.
+func writeExitStatus(ctx context.Context, st pState) {
+	path := os.Getenv(exitStatusFileEnv)
+	if path == "" {
+		// Units of an older shim
+		return
+	}
+	if err := writeExitStatusFile(path, st); err != nil {
+		log.G(ctx).WithError(err).Warn("Error writing exit status file")
+	}
+}
+
+// readExitStatusFile reads the exit status file at path into an exited process state.
+func readExitStatusFile(path string, st *pState) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var es exitStatus
+	if err := json.Unmarshal(data, &es); err != nil {
+		return err
+	}
+	*st = pState{Pid: es.Pid, ExitCode: es.ExitStatus, ExitedAt: es.ExitedAt, Status: "exited"}
+	return nil
+}
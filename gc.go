@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	runc "github.com/containerd/go-runc"
+)
+
+const (
+	// unitPrefix is the prefix of the units the shim creates with the default template, see unitName.
+	unitPrefix = "io-containerd-systemd-"
+	// orphanStopTimeout bounds how long we wait for an orphaned unit to stop.
+	orphanStopTimeout = 30 * time.Second
+
+	// execGCMinInterval and execGCMaxInterval bound how often exited execs are checked, see collectExecs.
+	execGCMinInterval = 10 * time.Second
+	execGCMaxInterval = 5 * time.Minute
+)
+
+// collectOrphans cleans up container units that no container we know about owns, e.g. after the shim crashed in the
+// middle of a delete or the host lost power. This must be called after Recover.
+//
+// Units whose bundle still exists are left alone since the container may just have failed to be recovered, in which
+// case it is better to leave it for someone to look at.
+func (s *Service) collectOrphans(ctx context.Context) {
+	known := make(map[string]bool)
+	for _, name := range s.units.Names() {
+		known[name] = true
+	}
+	// Units named with the current template, the default one and the ones containers were created with.
+	prefixes := map[string]bool{
+		unitNameTemplatePrefix(s.unitNameTemplate): true,
+		unitPrefix: true,
+	}
+	s.processes.Each(func(p Process) {
+		pInit, ok := p.(*initProcess)
+		if !ok {
+			return
+		}
+		prefixes[unitNameTemplatePrefix(pInit.opts.UnitNameTemplate)] = true
+		known[pInit.ttyUnitName()] = true
+		known[pInit.hookUnitName()] = true
+		pInit.execs.Each(func(ep Process) {
+			known[ep.(*execProcess).ttyUnitName()] = true
+		})
+	})
+
+	candidates := make(map[string]bool)
+	patterns := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		patterns = append(patterns, prefix+"*.service")
+	}
+	units, err := s.conn.ListUnitsByPatternsContext(ctx, nil, patterns)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("Error listing units, skipping orphan collection")
+		return
+	}
+	for _, u := range units {
+		candidates[u.Name] = true
+	}
+	// Unit files of units that were never loaded, or that systemd already forgot about.
+	if entries, err := os.ReadDir(runtimeUnitDir()); err == nil {
+		for _, e := range entries {
+			if !strings.HasSuffix(e.Name(), ".service") {
+				continue
+			}
+			for prefix := range prefixes {
+				if strings.HasPrefix(e.Name(), prefix) {
+					candidates[e.Name()] = true
+					break
+				}
+			}
+		}
+	}
+
+	var removed bool
+	for name := range candidates {
+		if known[name] {
+			continue
+		}
+		if s.collectOrphan(ctx, name) {
+			removed = true
+		}
+	}
+
+	if removed {
+		if err := s.reloader.Reload(ctx); err != nil {
+			log.G(ctx).WithError(err).Warn("Error reloading systemd after removing orphaned units")
+		}
+	}
+}
+
+// collectOrphan stops and removes the unit.
+// Returns true if a unit file was removed.
+func (s *Service) collectOrphan(ctx context.Context, name string) bool {
+	ctx = log.WithLogger(ctx, log.G(ctx).WithField("unit", name))
+
+	opts, unitPath, err := readUnitFile(name)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("Error parsing unit file of possibly orphaned unit")
+		return false
+	}
+
+	owner := unitOwnerFromOptions(opts)
+	ns, id := owner.Namespace, owner.ID
+	// The units of the other namespaces belong to their own shim daemons, units that don't say are left to them too.
+	if s.namespace != "" && ns != s.namespace {
+		return false
+	}
+	var (
+		bundle   string
+		shimExec bool
+	)
+	for _, o := range opts {
+		switch o.Name {
+		case "ExecStart", "ExecStopPost":
+			for _, arg := range strings.Fields(o.Value) {
+				if v := strings.TrimPrefix(arg, "--bundle="); v != arg {
+					bundle = v
+				}
+				if arg == s.exe {
+					shimExec = true
+				}
+			}
+		}
+	}
+	// The prefix of a custom unit name template may be shared with units that are not ours.
+	if !strings.HasPrefix(name, unitPrefix) && id == "" && !shimExec {
+		log.G(ctx).Debug("Unit was not created by the shim, leaving it alone")
+		return false
+	}
+	if bundle != "" {
+		if _, err := os.Stat(filepath.Join(bundle, "config.json")); err == nil {
+			log.G(ctx).WithField("bundle", bundle).Warn("Found unit for a container that was not recovered, leaving it alone")
+			return false
+		}
+	}
+
+	log.G(ctx).Info("Cleaning up orphaned unit")
+
+	sctx, cancel := context.WithTimeout(ctx, orphanStopTimeout)
+	defer cancel()
+	ch := make(chan string, 1)
+	if _, err := s.conn.StopUnitContext(sctx, name, "replace", ch); err != nil {
+		log.G(ctx).WithError(err).Debug("Error stopping orphaned unit")
+	} else {
+		select {
+		case <-sctx.Done():
+			log.G(ctx).Warn("Timeout waiting for orphaned unit to stop")
+		case <-ch:
+		}
+	}
+	if err := s.conn.ResetFailedUnitContext(ctx, name); err != nil && !strings.Contains(err.Error(), "not loaded") {
+		log.G(ctx).WithError(err).Debug("Error resetting orphaned unit")
+	}
+
+	if id != "" && ns != "" && (strings.HasSuffix(name, "-init.service") || strings.HasSuffix(name, "-"+criSandboxUnitMod+".service")) {
+		nsCfg := s.currentConfig().namespace(ns)
+		root := nsCfg.RuncRoot
+		if root == "" {
+			root = filepath.Join(s.root, "runc")
+		}
+		rt := s.restoreRuntime(ctx, nsCfg.BinaryName)
+		r := &runc.Runc{Command: rt.Path, Rootless: rt.rootless(false), Root: filepath.Join(root, ns)}
+		if err := r.Delete(ctx, id, &runc.DeleteOpts{Force: true}); err != nil && !strings.Contains(err.Error(), "not exist") {
+			log.G(ctx).WithError(err).Debug("Error deleting orphaned container in runc")
+		}
+	}
+
+	removeOverrides(ctx, name)
+	if unitPath == "" || strings.HasPrefix(unitPath, transientUnitDir()) {
+		// systemd removes transient units itself once they are stopped.
+		return false
+	}
+	if err := os.Remove(unitPath); err != nil && !os.IsNotExist(err) {
+		log.G(ctx).WithError(err).Warn("Error removing orphaned unit file")
+		return false
+	}
+	return true
+}
+
+// collectExecs deletes exec processes that exited more than retention ago, until ctx is cancelled.
+// Clients are supposed to delete execs once they exited, but not all do (e.g. when they crash), which would keep the
+// exec state and units around for as long as the container lives.
+func (s *Service) collectExecs(ctx context.Context, retention time.Duration) {
+	interval := retention / 4
+	if interval < execGCMinInterval {
+		interval = execGCMinInterval
+	}
+	if interval > execGCMaxInterval {
+		interval = execGCMaxInterval
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		s.collectExitedExecs(ctx, retention)
+	}
+}
+
+func (s *Service) collectExitedExecs(ctx context.Context, retention time.Duration) {
+	expired := make(map[*initProcess][]*execProcess)
+	s.processes.Each(func(p Process) {
+		pInit, ok := p.(*initProcess)
+		if !ok {
+			return
+		}
+		pInit.execs.Each(func(exec Process) {
+			st := exec.ProcessState()
+			if st.Exited() && time.Since(st.ExitedAt) > retention {
+				expired[pInit] = append(expired[pInit], exec.(*execProcess))
+			}
+		})
+	})
+
+	for pInit, execs := range expired {
+		for _, ep := range execs {
+			ctx := log.WithLogger(ctx, log.G(ctx).WithField("id", pInit.id).WithField("ns", pInit.ns).WithField("execID", ep.execID))
+			if _, err := ep.Delete(ctx); err != nil {
+				log.G(ctx).WithError(err).Warn("Error deleting expired exec")
+				continue
+			}
+			pInit.execs.Delete(ep.execID)
+			s.units.Delete(ep)
+			log.G(ctx).Info("Deleted exec that was not deleted by the client")
+		}
+		if err := s.saveTask(pInit); err != nil {
+			log.G(ctx).WithError(err).Error("Error saving task state")
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/coreos/go-systemd/unit"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// hooksAnnotation selects who runs the poststart and poststop OCI hooks of the container.
+// "runtime" (the default) leaves them to the OCI runtime. With "unit" they are taken out of the spec and run by
+// systemd: poststart in a oneshot unit started after the container, poststop when the container unit stops. They are
+// supervised and logged in the journal like the container, and poststop hooks run even if the shim is not.
+const hooksAnnotation = shimName + ".hooks"
+
+const (
+	hooksFileName = "hooks.json"
+
+	hookPoststart = "poststart"
+	hookPoststop  = "poststop"
+)
+
+// unitHooks are the hooks run by systemd, they are kept in the bundle.
+type unitHooks struct {
+	Poststart []specs.Hook `json:"poststart,omitempty"`
+	Poststop  []specs.Hook `json:"poststop,omitempty"`
+}
+
+func hookAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	switch v := annotations[hooksAnnotation]; v {
+	case "", "runtime":
+	case "unit":
+		opts.UnitHooks = true
+	default:
+		return fmt.Errorf("annotation %s: invalid value %q: %w", hooksAnnotation, v, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// moveHooksToUnit returns the spec edit that moves the poststart and poststop hooks from the spec to the hooks file in
+// the bundle.
+// The other hooks stay with the runtime: they run in the middle of `runc create` and `runc start`, while the
+// container's namespaces are being set up, which can't be done from outside the runtime.
+func moveHooksToUnit(bundle string) specEdit {
+	return func(spec map[string]interface{}) error {
+		specHooks, _ := spec["hooks"].(map[string]interface{})
+		moved := make(map[string]interface{})
+		for _, kind := range []string{hookPoststart, hookPoststop} {
+			if h, ok := specHooks[kind]; ok {
+				moved[kind] = h
+				delete(specHooks, kind)
+			}
+		}
+		if len(moved) == 0 {
+			return nil
+		}
+
+		data, err := json.Marshal(moved)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(bundle, hooksFileName), data, 0600); err != nil {
+			return fmt.Errorf("error writing hooks: %w", err)
+		}
+		return nil
+	}
+}
+
+func readUnitHooks(bundle string) (unitHooks, error) {
+	var hooks unitHooks
+	data, err := os.ReadFile(filepath.Join(bundle, hooksFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return hooks, nil
+		}
+		return hooks, err
+	}
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return hooks, fmt.Errorf("error unmarshalling hooks: %w", err)
+	}
+	return hooks, nil
+}
+
+// hookArgs returns the command that runs the hooks of the kind for the container.
+func (p *initProcess) hookArgs(kind string, pid uint32) []string {
+	return []string{p.exe, "--bundle=" + p.Bundle, "--id=" + p.id, "hook", kind, strconv.FormatUint(uint64(pid), 10)}
+}
+
+// hookOptions returns the unit options that run the poststop hooks when the container unit stops.
+func (p *initProcess) hookOptions() []*unit.UnitOption {
+	if !p.opts.UnitHooks {
+		return nil
+	}
+	return []*unit.UnitOption{
+		unit.NewUnitOption("Service", "ExecStopPost", "-"+p.privileged(strings.Join(p.hookArgs(hookPoststop, 0), " "))),
+	}
+}
+
+func (p *initProcess) hookUnitName() string {
+	return unitName(p.opts.UnitNameTemplate, p.ns, p.id, hookPoststart)
+}
+
+// startPoststartHooks starts the unit running the poststart hooks, it doesn't wait for them.
+// Failing hooks only show up as a failed unit and in the journal, like the runtime they don't affect the container.
+func (p *initProcess) startPoststartHooks(ctx context.Context, pid uint32) {
+	if !p.opts.UnitHooks {
+		return
+	}
+	hooks, err := readUnitHooks(p.Bundle)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("Error reading poststart hooks")
+		return
+	}
+	if len(hooks.Poststart) == 0 {
+		return
+	}
+
+	name := p.hookUnitName()
+	properties := []systemd.Property{
+		systemd.PropDescription("containerd poststart hooks for " + p.ns + "/" + p.id),
+		systemd.PropType("oneshot"),
+		systemd.PropExecStart(p.hookArgs(hookPoststart, pid), false),
+		systemd.PropAfter(p.Name()),
+		{Name: "Environment", Value: dbus.MakeVariant([]string{"CONTAINER_ID=" + p.id, "CONTAINER_NAMESPACE=" + p.ns})},
+	}
+	if p.opts.Slice != "" {
+		properties = append(properties, systemd.PropSlice(p.opts.Slice))
+	}
+	// A previous run of the unit may still be loaded if it failed.
+	p.systemd.ResetFailedUnitContext(ctx, name)
+	if _, err := p.systemd.StartTransientUnitContext(ctx, name, "replace", properties, nil); err != nil {
+		log.G(ctx).WithError(err).WithField("unit", name).Warn("Error starting poststart hooks")
+	}
+}
+
+// runHooks runs the hooks of the kind from the bundle in order, with the container state on stdin.
+// This is run by systemd in the hook units, see hooksAnnotation.
+// All hooks are run, an error is returned if any of them failed.
+func runHooks(ctx context.Context, bundle, id, kind string, pid int) error {
+	hooks, err := readUnitHooks(bundle)
+	if err != nil {
+		return err
+	}
+
+	state := specs.State{
+		Version: specs.Version,
+		ID:      id,
+		Pid:     pid,
+		Bundle:  bundle,
+	}
+	var ls []specs.Hook
+	switch kind {
+	case hookPoststart:
+		ls = hooks.Poststart
+		state.Status = specs.StateRunning
+	case hookPoststop:
+		ls = hooks.Poststop
+		state.Status = specs.StateStopped
+	default:
+		return fmt.Errorf("unknown hook kind %q: %w", kind, errdefs.ErrInvalidArgument)
+	}
+	if spec, err := readBundleSpec(bundle); err == nil {
+		state.Annotations = spec.Annotations
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for i, h := range ls {
+		if err := runHook(ctx, h, data); err != nil {
+			failed++
+			log.G(ctx).WithError(err).WithField("hook", h.Path).WithField("index", i).Warnf("Error running %s hook", kind)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d %s hooks failed", failed, len(ls), kind)
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, h specs.Hook, state []byte) error {
+	if h.Timeout != nil && *h.Timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(*h.Timeout)*time.Second)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, h.Path)
+	if len(h.Args) > 0 {
+		cmd.Args = h.Args
+	}
+	cmd.Env = h.Env
+	cmd.Stdin = bytes.NewReader(state)
+	// The output goes to the journal of the unit.
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
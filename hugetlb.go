@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/cgroups"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Huge page limits (spec.Linux.Resources.HugepageLimits) are set up by runc on the container's cgroup when it is
+// created. systemd has no properties for the hugetlb controller, so unlike memory or cpu they can't be put on the unit.
+// runc update doesn't touch them either, on Update the shim writes them to the container's cgroup itself.
+// The usage is read from the cgroup with the rest of the stats.
+
+const hugepagesDir = "/sys/kernel/mm/hugepages"
+
+// hugepageSizes returns the huge page sizes of the host as they are named in the spec and the cgroup files, e.g. "2MB".
+func hugepageSizes() (map[string]bool, error) {
+	entries, err := os.ReadDir(hugepagesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sizes := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if s, ok := hugepageSizeName(e.Name()); ok {
+			sizes[s] = true
+		}
+	}
+	return sizes, nil
+}
+
+// hugepageSizeName converts the name of a directory in /sys/kernel/mm/hugepages ("hugepages-2048kB") to the page size
+// used in the cgroup files ("2MB").
+func hugepageSizeName(dir string) (string, bool) {
+	if !strings.HasPrefix(dir, "hugepages-") || !strings.HasSuffix(dir, "kB") {
+		return "", false
+	}
+	n, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(dir, "hugepages-"), "kB"), 10, 64)
+	if err != nil || n == 0 {
+		return "", false
+	}
+	units := []string{"KB", "MB", "GB", "TB", "PB"}
+	i := 0
+	for n%1024 == 0 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return strconv.FormatUint(n, 10) + units[i], true
+}
+
+// validateHugepageLimits checks that the host has the page sizes the limits are for.
+// runc fails with a missing cgroup file otherwise, which doesn't say what is wrong.
+func validateHugepageLimits(limits []specs.LinuxHugepageLimit) error {
+	if len(limits) == 0 {
+		return nil
+	}
+	sizes, err := hugepageSizes()
+	if err != nil {
+		return fmt.Errorf("error reading huge page sizes: %w", err)
+	}
+	for _, l := range limits {
+		if !sizes[l.Pagesize] {
+			return fmt.Errorf("huge page size %q is not supported by the host: %w", l.Pagesize, errdefs.ErrInvalidArgument)
+		}
+	}
+	return nil
+}
+
+// hugetlbDir returns the hugetlb cgroup directory of the container.
+func (p *initProcess) hugetlbDir(ctx context.Context) (string, error) {
+	if cgroups.Mode() == cgroups.Unified {
+		g, err := p.cgroupV2Path(ctx)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join("/sys/fs/cgroup", g), nil
+	}
+
+	pid := int(p.Pid())
+	if pid == 0 || p.ProcessState().Exited() {
+		return "", fmt.Errorf("container is not running: %w", errdefs.ErrFailedPrecondition)
+	}
+	g, err := cgroups.PidPath(pid)(cgroups.Hugetlb)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join("/sys/fs/cgroup", string(cgroups.Hugetlb), g), nil
+}
+
+// updateHugetlb sets the huge page limits on the container's cgroup.
+// On cgroup v2 the reservation limit is set along with the fault limit when the kernel has it, like runc does.
+func (p *initProcess) updateHugetlb(ctx context.Context, limits []specs.LinuxHugepageLimit) error {
+	if len(limits) == 0 {
+		return nil
+	}
+	dir, err := p.hugetlbDir(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting hugetlb cgroup: %w", err)
+	}
+
+	unified := cgroups.Mode() == cgroups.Unified
+	for _, l := range limits {
+		v := []byte(strconv.FormatUint(l.Limit, 10))
+		files := []string{"hugetlb." + l.Pagesize + ".limit_in_bytes"}
+		if unified {
+			files = []string{"hugetlb." + l.Pagesize + ".max", "hugetlb." + l.Pagesize + ".rsvd.max"}
+		}
+		for i, f := range files {
+			if err := os.WriteFile(filepath.Join(dir, f), v, 0); err != nil {
+				if i > 0 && os.IsNotExist(err) {
+					continue
+				}
+				return fmt.Errorf("error setting huge page limit: %w", err)
+			}
+		}
+	}
+	log.G(ctx).WithField("limits", len(limits)).Debug("Updated huge page limits")
+	return nil
+}
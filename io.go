@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+)
+
+const (
+	stdioBinary = "binary"
+	stdioFile   = "file"
+
+	// binaryIOReadyTimeout is how long we wait for a logging binary to signal it is ready.
+	binaryIOReadyTimeout = 10 * time.Second
+)
+
+// parseStdioURI parses a stdio path from containerd.
+// containerd passes either a plain fifo path or a URI (binary:// or file://) when a logging driver is used.
+// A nil URL is returned for fifos.
+func parseStdioURI(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stdio path %q: %w", s, err)
+	}
+	switch u.Scheme {
+	case "", "fifo":
+		return nil, nil
+	case stdioBinary, stdioFile:
+		return u, nil
+	default:
+		return nil, fmt.Errorf("unsupported stdio scheme %q: %w", u.Scheme, errdefs.ErrNotImplemented)
+	}
+}
+
+// isFifo returns true if the stdio path is a fifo rather than a URI.
+func isFifo(s string) bool {
+	u, err := parseStdioURI(s)
+	return s != "" && err == nil && u == nil
+}
+
+// openFileIO opens the file from a file:// URI for appending.
+func openFileIO(u *url.URL) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(u.Path), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(u.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+}
+
+// startBinaryIO starts the logging binary from a binary:// URI.
+// This follows the same protocol as the runc shim: the binary gets the container stdout and stderr as fd 3 and 4,
+// and closes fd 5 once it is ready to receive data.
+//
+// The returned files are the write ends of the stdout and stderr pipes.
+// The binary is not waited on, it exits once the container closes its end of the pipes.
+func startBinaryIO(ctx context.Context, u *url.URL, ns, id string) (_, _ *os.File, retErr error) {
+	ctx = withLogSubsystem(ctx, logIO)
+	var args []string
+	for k, vs := range u.Query() {
+		args = append(args, k)
+		if len(vs) > 0 && vs[0] != "" {
+			args = append(args, vs[0])
+		}
+	}
+
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		outR.Close()
+		outW.Close()
+		return nil, nil, err
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		outR.Close()
+		outW.Close()
+		errR.Close()
+		errW.Close()
+		return nil, nil, err
+	}
+	defer func() {
+		outR.Close()
+		errR.Close()
+		readyR.Close()
+		readyW.Close()
+		if retErr != nil {
+			outW.Close()
+			errW.Close()
+		}
+	}()
+
+	cmd := exec.Command(u.Path, args...)
+	cmd.Env = append(os.Environ(), "CONTAINER_ID="+id, "CONTAINER_NAMESPACE="+ns)
+	cmd.ExtraFiles = []*os.File{outR, errR, readyW}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("error starting logging binary: %w", err)
+	}
+	go cmd.Wait()
+
+	// Close our copy so we see EOF when the binary closes its end.
+	readyW.Close()
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, readyR)
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Process.Kill()
+			return nil, nil, fmt.Errorf("error waiting for logging binary: %w", err)
+		}
+	case <-time.After(binaryIOReadyTimeout):
+		cmd.Process.Kill()
+		return nil, nil, fmt.Errorf("timed out waiting for logging binary to be ready")
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		return nil, nil, ctx.Err()
+	}
+
+	log.G(ctx).WithField("binary", u.Path).Debug("Logging binary is ready")
+	return outW, errW, nil
+}
+
+// validateStdio checks the stdio paths from a create or exec request.
+func validateStdio(stdout, stderr string, terminal bool) error {
+	for _, s := range []string{stdout, stderr} {
+		u, err := parseStdioURI(s)
+		if err != nil {
+			return err
+		}
+		if u != nil && u.Scheme == stdioBinary && terminal {
+			return fmt.Errorf("binary logging is not supported with a terminal: %w", errdefs.ErrNotImplemented)
+		}
+	}
+	return nil
+}
+
+// chownStdio gives the stdio fifos to uid and gid (the IoUid and IoGid options), so a container in a user namespace
+// can reopen them. Nothing is changed when both are 0.
+func chownStdio(uid, gid uint32, paths ...string) error {
+	if uid == 0 && gid == 0 {
+		return nil
+	}
+	for _, p := range paths {
+		if !isFifo(p) {
+			continue
+		}
+		if err := os.Chown(p, int(uid), int(gid)); err != nil {
+			return fmt.Errorf("error changing owner of stdio %s: %w", p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/coreos/go-systemd/unit"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// Containers that join the namespaces of another container, like the containers of a pod joining the namespaces held
+// by the pause container, can't work without it. The namespaces are checked before the container unit is created, and
+// the unit is bound to the unit of the container holding them, so systemd stops it when that container goes away.
+
+// nsCloneFlags are the namespace types as returned by NS_GET_NSTYPE.
+var nsCloneFlags = map[specs.LinuxNamespaceType]int{
+	specs.PIDNamespace:     unix.CLONE_NEWPID,
+	specs.NetworkNamespace: unix.CLONE_NEWNET,
+	specs.MountNamespace:   unix.CLONE_NEWNS,
+	specs.IPCNamespace:     unix.CLONE_NEWIPC,
+	specs.UTSNamespace:     unix.CLONE_NEWUTS,
+	specs.UserNamespace:    unix.CLONE_NEWUSER,
+	specs.CgroupNamespace:  unix.CLONE_NEWCGROUP,
+}
+
+// procNamespaceNames are the names of the namespaces in /proc/<pid>/ns.
+var procNamespaceNames = map[specs.LinuxNamespaceType]string{
+	specs.PIDNamespace:     "pid",
+	specs.NetworkNamespace: "net",
+	specs.MountNamespace:   "mnt",
+	specs.IPCNamespace:     "ipc",
+	specs.UTSNamespace:     "uts",
+	specs.UserNamespace:    "user",
+	specs.CgroupNamespace:  "cgroup",
+}
+
+// joinedNamespaces returns the namespaces the container joins.
+func joinedNamespaces(spec *specs.Spec) []specs.LinuxNamespace {
+	if spec.Linux == nil {
+		return nil
+	}
+	var joined []specs.LinuxNamespace
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Path != "" {
+			joined = append(joined, ns)
+		}
+	}
+	return joined
+}
+
+// validateNamespacePaths makes sure the namespaces the container joins exist and are of the right type, so joining the
+// namespaces of a container that is gone fails the create instead of the unit start.
+func validateNamespacePaths(spec *specs.Spec) error {
+	for _, ns := range joinedNamespaces(spec) {
+		f, err := os.Open(ns.Path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("%s namespace %s does not exist: %w", ns.Type, ns.Path, errdefs.ErrNotFound)
+			}
+			return fmt.Errorf("error opening %s namespace: %w", ns.Type, err)
+		}
+		err = checkNamespace(f, ns.Type)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s namespace %s: %w", ns.Type, ns.Path, err)
+		}
+	}
+	return nil
+}
+
+func checkNamespace(f *os.File, typ specs.LinuxNamespaceType) error {
+	var st unix.Statfs_t
+	if err := unix.Fstatfs(int(f.Fd()), &st); err != nil {
+		return err
+	}
+	if st.Type != unix.NSFS_MAGIC {
+		return fmt.Errorf("not a namespace: %w", errdefs.ErrInvalidArgument)
+	}
+	want, ok := nsCloneFlags[typ]
+	if !ok {
+		return nil
+	}
+	got, err := unix.IoctlRetInt(int(f.Fd()), unix.NS_GET_NSTYPE)
+	if err != nil {
+		// Before 4.11 the type can't be checked.
+		return nil
+	}
+	if got != want {
+		return fmt.Errorf("wrong namespace type, want %s: %w", typ, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// namespaceUnits returns the units of our containers holding the namespaces the container joins.
+// Namespaces are matched by inode, so both /proc/<pid>/ns paths and bind mounts of a namespace (e.g. a CNI netns) are
+// found. Namespaces of the host are skipped, containers that don't have their own are in them too.
+func (s *Service) namespaceUnits(spec *specs.Spec) []string {
+	joined := make(map[specs.LinuxNamespaceType]unix.Stat_t)
+	for _, ns := range joinedNamespaces(spec) {
+		var st, host unix.Stat_t
+		if err := unix.Stat(ns.Path, &st); err != nil {
+			continue
+		}
+		if err := unix.Stat(filepath.Join("/proc/self/ns", procNamespaceNames[ns.Type]), &host); err == nil && st.Dev == host.Dev && st.Ino == host.Ino {
+			continue
+		}
+		joined[ns.Type] = st
+	}
+	if len(joined) == 0 {
+		return nil
+	}
+
+	var units []string
+	s.processes.Each(func(p Process) {
+		pid := p.Pid()
+		if pid == 0 || p.ProcessState().Exited() {
+			return
+		}
+		for typ, want := range joined {
+			var st unix.Stat_t
+			if err := unix.Stat(filepath.Join("/proc", strconv.Itoa(int(pid)), "ns", procNamespaceNames[typ]), &st); err != nil {
+				continue
+			}
+			if st.Dev == want.Dev && st.Ino == want.Ino {
+				units = append(units, p.Name())
+				return
+			}
+		}
+	})
+	return units
+}
+
+// namespaceOptions binds the unit to the units holding the namespaces it joins.
+func (p *initProcess) namespaceOptions() []*unit.UnitOption {
+	var opts []*unit.UnitOption
+	for _, name := range p.opts.BindsTo {
+		opts = append(opts,
+			unit.NewUnitOption("Unit", "BindsTo", name),
+			unit.NewUnitOption("Unit", "After", name),
+		)
+	}
+	return opts
+}
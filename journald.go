@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/coreos/go-systemd/unit"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+)
+
+const (
+	journalStreamSocket = "/run/systemd/journal/stdout"
+
+	// syslog priorities used to tell stdout and stderr apart in the journal
+	journalPriorityStdout = 6
+	journalPriorityStderr = 3
+
+	// relayGracePeriod is how long we keep relaying journal entries after a process exits.
+	// journald may not have flushed the last lines by the time we see the exit.
+	relayGracePeriod = time.Second
+)
+
+func (p *process) logMode() options.LogMode {
+	return options.LogMode(options.LogMode_value[p.opts.LogMode])
+}
+
+// openJournalStream opens a stream to journald the same way systemd-cat does.
+// Everything written to the returned file is logged with the provided identifier and priority.
+func openJournalStream(identifier string, priority int) (*os.File, error) {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: journalStreamSocket, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.CloseRead(); err != nil {
+		return nil, err
+	}
+
+	// identifier, unit id, priority, level prefix, forward to syslog, forward to kmsg, forward to console
+	header := identifier + "\n\n" + strconv.Itoa(priority) + "\n0\n0\n0\n0\n"
+	if _, err := conn.Write([]byte(header)); err != nil {
+		return nil, fmt.Errorf("error writing journal stream header: %w", err)
+	}
+	return conn.File()
+}
+
+// journalFieldName converts an arbitrary key into a valid journal field name.
+func journalFieldName(s string) string {
+	b := []byte(strings.ToUpper(s))
+	for i, c := range b {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// journalFields returns the extra fields to tag container output with.
+// Annotations from the container spec are added as CONTAINER_LABEL_*.
+func journalFields(ns, id, execID string, annotations map[string]string) map[string]string {
+	fields := map[string]string{
+		"CONTAINER_ID":        id,
+		"CONTAINER_NAMESPACE": ns,
+	}
+	if execID != "" {
+		fields["CONTAINER_EXEC_ID"] = execID
+	}
+	for k, v := range annotations {
+		name := "CONTAINER_LABEL_" + journalFieldName(k)
+		if len(name) > 64 {
+			// journald drops fields with longer names
+			continue
+		}
+		fields[name] = strings.ReplaceAll(v, "\n", " ")
+	}
+	return fields
+}
+
+// logOptions returns the unit options needed for the configured log mode.
+func (p *process) logOptions(fields map[string]string) []*unit.UnitOption {
+	const svc = "Service"
+	var opts []*unit.UnitOption
+	switch p.logMode() {
+	case options.LogMode_JOURNALD:
+		opts = append(opts,
+			unit.NewUnitOption(svc, "StandardOutput", "journal"),
+			unit.NewUnitOption(svc, "StandardError", "journal"),
+		)
+	case options.LogMode_TEE:
+		// The log tee writes to the journal itself, only the fields are needed.
+		if p.opts.MaxLogLineSize > 0 {
+			opts = append(opts, unit.NewUnitOption(svc, "Environment", maxLogLineSizeEnv+"="+strconv.Itoa(p.opts.MaxLogLineSize)))
+		}
+	default:
+		return nil
+	}
+	opts = append(opts, p.logRateLimitOptions()...)
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		opts = append(opts, unit.NewUnitOption(svc, "LogExtraFields", strconv.Quote(k+"="+fields[k])))
+	}
+	return opts
+}
+
+func (p *initProcess) journalFields() map[string]string {
+	return p.withTraceField(journalFields(p.ns, p.id, "", p.annotations()))
+}
+
+func (p *execProcess) journalFields() map[string]string {
+	return p.withTraceField(journalFields(p.ns, p.parent.id, p.execID, p.parent.annotations()))
+}
+
+// withTraceField adds the trace the process was created in to the journal fields.
+func (p *process) withTraceField(fields map[string]string) map[string]string {
+	if p.traceID != "" {
+		fields["TRACE_ID"] = p.traceID
+	}
+	return fields
+}
+
+type journalEntry struct {
+	Message  json.RawMessage `json:"MESSAGE"`
+	Priority string          `json:"PRIORITY"`
+	// Realtime is when the entry was logged, in microseconds since the epoch.
+	Realtime string `json:"__REALTIME_TIMESTAMP"`
+}
+
+func (e *journalEntry) time() time.Time {
+	usec, err := strconv.ParseInt(e.Realtime, 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.UnixMicro(usec)
+}
+
+// message decodes the message, which journalctl encodes as an array of bytes when it is not valid utf-8.
+func (e *journalEntry) message() []byte {
+	var s string
+	if err := json.Unmarshal(e.Message, &s); err == nil {
+		return []byte(s)
+	}
+	var b []byte
+	var ints []int
+	if err := json.Unmarshal(e.Message, &ints); err == nil {
+		b = make([]byte, len(ints))
+		for i, v := range ints {
+			b[i] = byte(v)
+		}
+	}
+	return b
+}
+
+// startLogRelay streams the journal entries for the named identifier back to the stdio fifos.
+// This keeps things like `ctr task attach` working when the container output goes to journald.
+// Containers with a CRI log path get their output written to that file instead, see criLogPathAnnotation.
+// The relay runs until stopLogRelay is called.
+func (p *process) startLogRelay(ctx context.Context, identifier string) {
+	if p.logMode() != options.LogMode_JOURNALD {
+		// The log mode changes with a reload of the shim config, see reloadConfig.
+		p.mu.Lock()
+		if p.stopRelay != nil {
+			p.stopRelay()
+			p.stopRelay = nil
+		}
+		p.mu.Unlock()
+		return
+	}
+	ctx = withLogSubsystem(ctx, logIO)
+
+	var criLog *criLogWriter
+	if p.opts.CRI.LogPath != "" {
+		var err error
+		criLog, err = newCRILogWriter(p.opts.CRI.LogPath)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("path", p.opts.CRI.LogPath).Warn("Error opening CRI log, relaying to stdio")
+		}
+	}
+	if criLog == nil && !isFifo(p.Stdout) && !isFifo(p.Stderr) {
+		return
+	}
+
+	journalctl, err := exec.LookPath("journalctl")
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("Cannot relay journal entries to stdio")
+		if criLog != nil {
+			criLog.Close()
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(log.WithLogger(context.Background(), log.G(ctx)))
+	p.mu.Lock()
+	if p.stopRelay != nil {
+		p.stopRelay()
+	}
+	p.stopRelay = cancel
+	p.mu.Unlock()
+
+	openFifo := func(path string) *os.File {
+		if !isFifo(path) {
+			return nil
+		}
+		// O_RDWR so this does not block if the reader has gone away
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("path", path).Warn("Error opening fifo for journal relay")
+			return nil
+		}
+		return f
+	}
+
+	var stdout, stderr *os.File
+	if criLog == nil {
+		stdout = openFifo(p.Stdout)
+		stderr = openFifo(p.Stderr)
+		if stderr == nil {
+			stderr = stdout
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, journalctl, "--follow", "--output=json", "--all", "--since=@"+strconv.FormatInt(time.Now().Unix(), 10), "SYSLOG_IDENTIFIER="+identifier)
+	journal, err := cmd.StdoutPipe()
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("Error setting up journal relay")
+		cancel()
+		if criLog != nil {
+			criLog.Close()
+		}
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		log.G(ctx).WithError(err).Warn("Error starting journal relay")
+		cancel()
+		if criLog != nil {
+			criLog.Close()
+		}
+		return
+	}
+
+	go func() {
+		defer func() {
+			cmd.Wait()
+			if criLog != nil {
+				criLog.Close()
+			}
+			if stdout != nil {
+				stdout.Close()
+			}
+			if stderr != nil && stderr != stdout {
+				stderr.Close()
+			}
+		}()
+
+		var out, errOut io.Writer
+		if stdout != nil {
+			out = newLineLimitWriter(stdout, p.opts.MaxLogLineSize)
+		}
+		if stderr == stdout {
+			errOut = out
+		} else if stderr != nil {
+			errOut = newLineLimitWriter(stderr, p.opts.MaxLogLineSize)
+		}
+
+		scanner := bufio.NewScanner(journal)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var e journalEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				continue
+			}
+			isStderr := e.Priority == strconv.Itoa(journalPriorityStderr)
+			if criLog != nil {
+				stream := "stdout"
+				if isStderr {
+					stream = "stderr"
+				}
+				msg := e.message()
+				if max := p.opts.MaxLogLineSize; max > 0 && len(msg) > max {
+					msg = msg[:max]
+				}
+				if err := criLog.WriteLine(e.time(), stream, msg); err != nil {
+					log.G(ctx).WithError(err).Debug("Error writing journal entry to CRI log")
+					return
+				}
+				continue
+			}
+			w := out
+			if isStderr {
+				w = errOut
+			}
+			if w == nil {
+				continue
+			}
+			if _, err := w.Write(append(e.message(), '\n')); err != nil {
+				log.G(ctx).WithError(err).Debug("Error writing journal entry to fifo")
+				return
+			}
+		}
+	}()
+}
+
+// stopLogRelay stops the journal relay after giving journald some time to flush the remaining output.
+func (p *process) stopLogRelay() {
+	p.mu.Lock()
+	cancel := p.stopRelay
+	p.stopRelay = nil
+	p.mu.Unlock()
+
+	if cancel != nil {
+		time.AfterFunc(relayGracePeriod, cancel)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/coreos/go-systemd/v22/daemon"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	dbus "github.com/godbus/dbus/v5"
+)
+
+// lazyPagesAnnotation restores the container with lazy pages (post-copy migration), the value is the address
+// (host:port) of the page server on the source of the migration.
+//
+// Only the parts of the checkpoint criu needs to recreate the processes are in the image, the memory pages are fetched
+// from the source as the container touches them. The container is only frozen for as long as that takes, not for the
+// copy of all of its memory.
+// On the source the container is checkpointed with lazy pages and a page server (runc checkpoint --lazy-pages
+// --page-server), which keeps serving the pages until the destination has all of them.
+const lazyPagesAnnotation = shimName + ".lazy-pages"
+
+// Environment of the lazy-pages subcommand.
+const (
+	lazyPagesServerEnv = "LAZY_PAGES_SERVER"
+	lazyPagesImagesEnv = "LAZY_PAGES_IMAGES"
+	lazyPagesWorkEnv   = "LAZY_PAGES_WORK"
+	lazyPagesCriuEnv   = "LAZY_PAGES_CRIU"
+)
+
+func lazyPagesAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	v := annotations[lazyPagesAnnotation]
+	if v == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(v); err != nil {
+		return fmt.Errorf("annotation %s: invalid address %q: %w", lazyPagesAnnotation, v, errdefs.ErrInvalidArgument)
+	}
+	opts.LazyPagesServer = v
+	return nil
+}
+
+func (p *initProcess) lazyPagesUnitName() string {
+	return unitName(p.opts.UnitNameTemplate, p.ns, p.id, "lazy-pages")
+}
+
+// lazyPages reports if the container is restored with lazy pages.
+func (p *initProcess) lazyPages() bool {
+	return p.checkpoint != "" && p.opts.LazyPagesServer != ""
+}
+
+// startLazyPages starts the unit running the criu lazy-pages daemon for the restore and waits for it to be ready.
+// criu restore hands the memory of the container over to the daemon through a socket in the work dir, so both must use
+// the same one. The daemon exits once it got all the pages, the container unit stops it if it is still running when
+// the container exits.
+func (p *initProcess) startLazyPages(ctx context.Context) (retErr error) {
+	ctx, span := StartSpan(ctx, "InitProcess.StartLazyPages")
+	defer span.End()
+
+	if err := os.MkdirAll(p.opts.CriuWorkPath, 0700); err != nil {
+		return err
+	}
+
+	criu := p.runc.Criu
+	if criu == "" {
+		criu = "criu"
+	}
+	env := []string{
+		lazyPagesServerEnv + "=" + p.opts.LazyPagesServer,
+		lazyPagesImagesEnv + "=" + p.checkpoint,
+		lazyPagesWorkEnv + "=" + p.opts.CriuWorkPath,
+		lazyPagesCriuEnv + "=" + criu,
+		"UNIT_NAME=" + p.Name(),
+	}
+	properties := []systemd.Property{
+		systemd.PropDescription("criu lazy-pages for " + p.ns + "/" + p.id),
+		systemd.PropType("notify"),
+		systemd.PropExecStart([]string{p.exe, "--bundle=" + p.Bundle, "lazy-pages"}, false),
+		{Name: "Environment", Value: dbus.MakeVariant(env)},
+	}
+	if p.opts.Slice != "" {
+		properties = append(properties, systemd.PropSlice(p.opts.Slice))
+	}
+
+	name := p.lazyPagesUnitName()
+	defer func() {
+		if retErr != nil {
+			p.systemd.StopUnitContext(ctx, name, "replace", nil)
+		}
+	}()
+
+	// A previous run of the unit may still be loaded if it failed.
+	p.systemd.ResetFailedUnitContext(ctx, name)
+	ch := make(chan string, 1)
+	if _, err := p.systemd.StartTransientUnitContext(ctx, name, "replace", properties, ch); err != nil {
+		return fmt.Errorf("error starting lazy-pages unit: %w", err)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case status := <-ch:
+		if status != "done" {
+			return p.startDiagnostics(ctx, name, fmt.Errorf("error starting lazy-pages unit: %s", status))
+		}
+	}
+	log.G(ctx).WithField("unit", name).Debug("Started lazy-pages daemon")
+	return nil
+}
+
+// lazyPages runs the criu lazy-pages daemon in the lazy-pages unit.
+// systemd is notified once criu reports it is ready to take the memory of the restored container.
+func lazyPages(ctx context.Context) error {
+	host, port, err := net.SplitHostPort(os.Getenv(lazyPagesServerEnv))
+	if err != nil {
+		return fmt.Errorf("invalid page server address: %w", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(os.Getenv(lazyPagesCriuEnv), "lazy-pages",
+		"--page-server",
+		"--address", host,
+		"--port", port,
+		"--images-dir", os.Getenv(lazyPagesImagesEnv),
+		"--work-dir", os.Getenv(lazyPagesWorkEnv),
+		"--status-fd", "3",
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{w}
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return fmt.Errorf("error starting criu lazy-pages: %w", err)
+	}
+	w.Close()
+
+	// criu writes a byte to the status fd when it is ready, it is closed without one if it fails.
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("criu lazy-pages failed: %w", err)
+		}
+		return fmt.Errorf("criu lazy-pages exited before it was ready")
+	}
+	log.G(ctx).WithField("pid", cmd.Process.Pid).Debug("criu lazy-pages is ready")
+	sdNotify(ctx, daemon.SdNotifyReady)
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("criu lazy-pages failed: %w", err)
+	}
+	return nil
+}
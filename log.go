@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/log"
+	"github.com/coreos/go-systemd/v22/journal"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 )
@@ -22,14 +26,219 @@ func setupLogFormat(ctx context.Context, config srvConfig) {
 		config.Debug.Format = log.TextFormat
 	}
 	if config.Debug.Format == log.TextFormat {
-		logrus.SetFormatter(&logrus.TextFormatter{
+		logrus.SetFormatter(&levelFilterFormatter{&logrus.TextFormatter{
 			TimestampFormat: log.RFC3339NanoFixed,
 			FullTimestamp:   true,
-		})
+		}})
 	} else if config.Debug.Format == log.JSONFormat {
-		logrus.SetFormatter(&logrus.JSONFormatter{
+		logrus.SetFormatter(&levelFilterFormatter{&logrus.JSONFormatter{
 			TimestampFormat: log.RFC3339NanoFixed,
-		})
+		}})
+	}
+}
+
+// Backends for the shim's own log, set with --log-backend.
+//   - stderr: the default, where systemd sends it to the journal as plain lines
+//   - journald: native journal entries, the fields of an entry become journal fields (e.g. NAMESPACE, ID, UNIT)
+//   - file: JSON lines appended to --log-file
+//   - fifo: only containerd's log fifo of the container a request is for, containerd logs it with its own log
+const (
+	logBackendStderr   = "stderr"
+	logBackendJournald = "journald"
+	logBackendFile     = "file"
+	logBackendFifo     = "fifo"
+)
+
+func validateLogBackend(backend, file string) error {
+	switch backend {
+	case logBackendStderr, logBackendJournald, logBackendFifo:
+	case logBackendFile:
+		if file == "" {
+			return fmt.Errorf("log backend %s requires --log-file: %w", backend, errdefs.ErrInvalidArgument)
+		}
+	default:
+		return fmt.Errorf("unknown log backend %q: %w", backend, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// setupLogBackend sends the shim's log to the backend, after setupLogFormat.
+func setupLogBackend(backend, file string) error {
+	switch backend {
+	case logBackendJournald:
+		if !journal.Enabled() {
+			return fmt.Errorf("journald is not available: %w", errdefs.ErrFailedPrecondition)
+		}
+		logrus.AddHook(&journalHook{identifier: filepath.Base(os.Args[0])})
+		logrus.SetOutput(io.Discard)
+	case logBackendFile:
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		logrus.SetFormatter(&levelFilterFormatter{&logrus.JSONFormatter{
+			TimestampFormat: log.RFC3339NanoFixed,
+		}})
+		logrus.SetOutput(f)
+	case logBackendFifo:
+		logrus.SetOutput(io.Discard)
+	}
+	return nil
+}
+
+// Subsystems of the shim that can be given their own log level, their log entries have the subsystem as a field.
+const (
+	logSubsystemKey = "subsystem"
+
+	logDBus   = "dbus"
+	logRunc   = "runc"
+	logIO     = "io"
+	logEvents = "events"
+)
+
+// logLevels are the levels of the shim's log, set with --log-level.
+var logLevels = logLevelConfig{Default: logrus.InfoLevel}
+
+type logLevelConfig struct {
+	Default    logrus.Level
+	Subsystems map[string]logrus.Level
+}
+
+// parseLogLevels parses the log levels from "<level>[,<subsystem>=<level>...]", e.g. "info,dbus=debug,events=warn".
+// The default level can be left out.
+func parseLogLevels(s string) (logLevelConfig, error) {
+	c := logLevelConfig{Default: logrus.InfoLevel}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.Index(part, "=")
+		if i < 0 {
+			l, err := logrus.ParseLevel(part)
+			if err != nil {
+				return c, fmt.Errorf("invalid log level %q: %w", part, errdefs.ErrInvalidArgument)
+			}
+			c.Default = l
+			continue
+		}
+		name, level := part[:i], part[i+1:]
+		switch name {
+		case logDBus, logRunc, logIO, logEvents:
+		default:
+			return c, fmt.Errorf("unknown log subsystem %q: %w", name, errdefs.ErrInvalidArgument)
+		}
+		l, err := logrus.ParseLevel(level)
+		if err != nil {
+			return c, fmt.Errorf("invalid log level %q for %s: %w", level, name, errdefs.ErrInvalidArgument)
+		}
+		if c.Subsystems == nil {
+			c.Subsystems = make(map[string]logrus.Level)
+		}
+		c.Subsystems[name] = l
+	}
+	return c, nil
+}
+
+func (c logLevelConfig) level(subsystem string) logrus.Level {
+	if l, ok := c.Subsystems[subsystem]; ok {
+		return l
+	}
+	return c.Default
+}
+
+// max is the most verbose of the levels, which is the level of the logger. Entries are dropped by their subsystem's
+// level when they are written, see levelFilterFormatter.
+func (c logLevelConfig) max() logrus.Level {
+	l := c.Default
+	for _, sl := range c.Subsystems {
+		if sl > l {
+			l = sl
+		}
+	}
+	return l
+}
+
+func (c logLevelConfig) enabled(e *logrus.Entry) bool {
+	s, _ := e.Data[logSubsystemKey].(string)
+	return e.Level <= c.level(s)
+}
+
+// setupLogLevels sets the levels of the shim's log.
+func setupLogLevels(c logLevelConfig) {
+	logLevels = c
+	logrus.SetLevel(c.max())
+	if _, ok := logrus.StandardLogger().Formatter.(*levelFilterFormatter); !ok {
+		logrus.SetFormatter(&levelFilterFormatter{logrus.StandardLogger().Formatter})
+	}
+}
+
+// withLogSubsystem returns ctx with a logger for the subsystem.
+func withLogSubsystem(ctx context.Context, subsystem string) context.Context {
+	return log.WithLogger(ctx, log.G(ctx).WithField(logSubsystemKey, subsystem))
+}
+
+// levelFilterFormatter drops the entries that are below the level of their subsystem.
+type levelFilterFormatter struct {
+	logrus.Formatter
+}
+
+func (f *levelFilterFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	if !logLevels.enabled(e) {
+		return nil, nil
+	}
+	return f.Formatter.Format(e)
+}
+
+// journalHook writes the log entries to the journal with their fields as journal fields.
+type journalHook struct {
+	identifier string
+}
+
+func (h *journalHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *journalHook) Fire(e *logrus.Entry) error {
+	if !logLevels.enabled(e) {
+		return nil
+	}
+	vars := map[string]string{
+		"SYSLOG_IDENTIFIER": h.identifier,
+	}
+	for k, v := range e.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		name := journalFieldName(k)
+		if name == "" || name[0] < 'A' || name[0] > 'Z' {
+			// Fields starting with an underscore are set by journald, names can't start with a digit.
+			name = "F" + name
+		}
+		vars[name] = fmt.Sprint(v)
+	}
+	if e.Caller != nil {
+		vars["CODE_FILE"] = e.Caller.File
+		vars["CODE_LINE"] = fmt.Sprint(e.Caller.Line)
+		vars["CODE_FUNC"] = e.Caller.Function
+	}
+	return journal.Send(e.Message, journalLogPriority(e.Level), vars)
+}
+
+func journalLogPriority(l logrus.Level) journal.Priority {
+	switch l {
+	case logrus.PanicLevel:
+		return journal.PriEmerg
+	case logrus.FatalLevel:
+		return journal.PriCrit
+	case logrus.ErrorLevel:
+		return journal.PriErr
+	case logrus.WarnLevel:
+		return journal.PriWarning
+	case logrus.InfoLevel:
+		return journal.PriInfo
+	default:
+		return journal.PriDebug
 	}
 }
 
@@ -70,6 +279,7 @@ func WithShimLog(ctx context.Context, w io.Writer) context.Context {
 
 	l := logrus.New()
 	l.SetLevel(e.Logger.GetLevel())
+	l.SetFormatter(e.Logger.Formatter)
 	l.SetOutput(io.MultiWriter(e.Logger.Out, w))
 	l.Hooks = e.Logger.Hooks
 	l.SetReportCaller(e.Logger.ReportCaller)
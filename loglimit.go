@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/coreos/go-systemd/unit"
+	"golang.org/x/sys/unix"
+)
+
+// Annotations to limit how much a container can log.
+//
+// logRateLimitIntervalAnnotation and logRateLimitBurstAnnotation set the journal rate limit of the container units
+// (LogRateLimitIntervalSec= and LogRateLimitBurst=), journald drops what the container logs over the burst within the
+// interval. The interval takes a systemd time span, e.g. "30s".
+//
+// maxLogLineSizeAnnotation cuts off lines of output the shim copies to the stdio fifos after the given size, e.g. "16K",
+// so a container writing huge lines can't make containerd (or kubelet reading the CRI log) buffer them.
+const (
+	logRateLimitIntervalAnnotation = shimName + ".log-rate-limit-interval"
+	logRateLimitBurstAnnotation    = shimName + ".log-rate-limit-burst"
+	maxLogLineSizeAnnotation       = shimName + ".max-log-line-size"
+)
+
+func logLimitAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	if v := annotations[logRateLimitIntervalAnnotation]; v != "" {
+		usec, err := parseUnitDuration(v)
+		if err != nil {
+			return fmt.Errorf("annotation %s: %w", logRateLimitIntervalAnnotation, err)
+		}
+		opts.LogRateLimitInterval = time.Duration(usec) * time.Microsecond
+	}
+	if v := annotations[logRateLimitBurstAnnotation]; v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return fmt.Errorf("annotation %s: invalid burst %q: %w", logRateLimitBurstAnnotation, v, errdefs.ErrInvalidArgument)
+		}
+		opts.LogRateLimitBurst = uint32(n)
+	}
+	if v := annotations[maxLogLineSizeAnnotation]; v != "" {
+		n, err := parseByteSize(v)
+		if err != nil || n > 1<<31 {
+			return fmt.Errorf("annotation %s: invalid size %q: %w", maxLogLineSizeAnnotation, v, errdefs.ErrInvalidArgument)
+		}
+		opts.MaxLogLineSize = int(n)
+	}
+	return nil
+}
+
+// logRateLimitOptions returns the unit options for the journal rate limit of the container.
+func (p *process) logRateLimitOptions() []*unit.UnitOption {
+	const svc = "Service"
+
+	var opts []*unit.UnitOption
+	if p.opts.LogRateLimitInterval > 0 {
+		opts = append(opts, unit.NewUnitOption(svc, "LogRateLimitIntervalSec", strconv.FormatInt(p.opts.LogRateLimitInterval.Microseconds(), 10)+"us"))
+	}
+	if p.opts.LogRateLimitBurst > 0 {
+		opts = append(opts, unit.NewUnitOption(svc, "LogRateLimitBurst", strconv.FormatUint(uint64(p.opts.LogRateLimitBurst), 10)))
+	}
+	return opts
+}
+
+// lineLimitWriter cuts off lines written to w after max bytes, the rest of the line up to the newline is dropped.
+// Writes report the full length as written, so callers copying output don't stop on a cut off line.
+type lineLimitWriter struct {
+	w   io.Writer
+	max int
+	// n is the length of the current line so far.
+	n int
+}
+
+// newLineLimitWriter returns w as is if there is no limit.
+func newLineLimitWriter(w io.Writer, max int) io.Writer {
+	if max <= 0 {
+		return w
+	}
+	return &lineLimitWriter{w: w, max: max}
+}
+
+func (l *lineLimitWriter) Write(b []byte) (int, error) {
+	// The kept parts of the lines are written with one writev(2) instead of being copied together first.
+	var out [][]byte
+	for rest := b; len(rest) > 0; {
+		line := rest
+		i := bytes.IndexByte(rest, '\n')
+		if i >= 0 {
+			line = rest[:i+1]
+			rest = rest[i+1:]
+		} else {
+			rest = nil
+		}
+
+		n := len(line)
+		if i >= 0 {
+			n--
+		}
+		if keep := l.max - l.n; keep > 0 {
+			if n <= keep {
+				// Keep the line along with its newline.
+				out = append(out, line)
+			} else {
+				out = append(out, line[:keep])
+				if i >= 0 {
+					out = append(out, newline)
+				}
+			}
+		} else if i >= 0 {
+			out = append(out, newline)
+		}
+		l.n += n
+		if i >= 0 {
+			l.n = 0
+		}
+	}
+
+	if len(out) > 0 {
+		if err := writeVectors(l.w, out); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+var newline = []byte{'\n'}
+
+// maxIovecs is the most buffers a single writev(2) takes (IOV_MAX).
+const maxIovecs = 1024
+
+// writeVectors writes bufs to w, with writev(2) if w is a file.
+func writeVectors(w io.Writer, bufs [][]byte) error {
+	f, ok := w.(*os.File)
+	if !ok {
+		for _, b := range bufs {
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var werr error
+	err = rc.Write(func(fd uintptr) bool {
+		for len(bufs) > 0 {
+			iovs := bufs
+			if len(iovs) > maxIovecs {
+				iovs = iovs[:maxIovecs]
+			}
+			n, err := unix.Writev(int(fd), iovs)
+			switch err {
+			case nil:
+			case unix.EINTR:
+				continue
+			case unix.EAGAIN:
+				// Wait for the file to be writable again.
+				return false
+			default:
+				werr = err
+				return true
+			}
+			// Skip what was written, writes to pipes and sockets may be partial.
+			for n > 0 {
+				if n < len(bufs[0]) {
+					bufs[0] = bufs[0][n:]
+					break
+				}
+				n -= len(bufs[0])
+				bufs = bufs[1:]
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return werr
+}
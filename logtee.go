@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+	"golang.org/x/sys/unix"
+)
+
+// In tee mode (LogMode_TEE) the container output goes to the journal and to the containerd fifos, so clients reading
+// the fifos (ctr task attach, the CRI plugin writing the log file for kubectl logs) keep working while the output is
+// also kept in the journal.
+//
+// Unlike the journald mode, which reads the entries back from the journal (see startLogRelay), the fifos get the
+// output straight from the container: nothing is lost when journald rate limits the container.
+// The container writes to pipes which are copied to both by the log tee, a helper started by the create command that
+// stays with the container.
+
+const logTeeBufferSize = 32 * 1024
+
+// maxLogLineSizeEnv passes the line size limit of the container to the log tee, see maxLogLineSizeAnnotation.
+const maxLogLineSizeEnv = "MAX_LOG_LINE_SIZE"
+
+// validateTeeStdio checks that the stdio can be used with the tee mode.
+// Logging binaries and files from containerd already keep the output on their own.
+// With a terminal the output only goes to the stdio, as with the stdio mode.
+func validateTeeStdio(logMode, stdout, stderr string, terminal bool) error {
+	if terminal || options.LogMode(options.LogMode_value[logMode]) != options.LogMode_TEE {
+		return nil
+	}
+	for _, s := range []string{stdout, stderr} {
+		if u, _ := parseStdioURI(s); u != nil {
+			return fmt.Errorf("log mode tee is not supported with %s:// stdio: %w", u.Scheme, errdefs.ErrNotImplemented)
+		}
+	}
+	return nil
+}
+
+// startLogTee starts the log tee and returns the write ends of the stdout and stderr pipes for the container.
+// Like the notify proxy it must be started before the create command leaves the unit cgroup: it has to stay with the
+// container, and journald tells which unit a stream belongs to by the cgroup of the process writing it.
+func startLogTee(ctx context.Context, bundle string) (_, _ *os.File, retErr error) {
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		outR.Close()
+		outW.Close()
+		return nil, nil, err
+	}
+	defer func() {
+		outR.Close()
+		errR.Close()
+		if retErr != nil {
+			outW.Close()
+			errW.Close()
+		}
+	}()
+
+	cmd := exec.Command("/proc/self/exe", "--bundle="+bundle, "log-tee")
+	cmd.ExtraFiles = []*os.File{outR, errR}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("error starting log tee: %w", err)
+	}
+	log.G(ctx).WithField("pid", cmd.Process.Pid).Debug("Started log tee")
+	return outW, errW, nil
+}
+
+// logTee copies the container stdout and stderr (fd 3 and 4) to the journal and the stdio fifos until the container
+// closed them.
+func logTee(ctx context.Context) error {
+	// Stopping the unit signals everything in it, the output left in the pipes is still copied.
+	signal.Ignore(syscall.SIGTERM, syscall.SIGINT)
+	ctx = withLogSubsystem(ctx, logIO)
+
+	id := os.Getenv("UNIT_NAME")
+	maxLine, _ := strconv.Atoi(os.Getenv(maxLogLineSizeEnv))
+	streams := []struct {
+		pipe     *os.File
+		fifo     string
+		priority int
+	}{
+		{os.NewFile(3, "stdout"), os.Getenv("STDOUT_FIFO"), journalPriorityStdout},
+		{os.NewFile(4, "stderr"), os.Getenv("STDERR_FIFO"), journalPriorityStderr},
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range streams {
+		var journal *os.File
+		if f, err := openJournalStream(id, s.priority); err == nil {
+			defer f.Close()
+			journal = f
+		} else {
+			log.G(ctx).WithError(err).Warn("Error opening journal stream, output only goes to the fifo")
+		}
+
+		var fifo *os.File
+		if isFifo(s.fifo) {
+			f, err := openTeeFifo(s.fifo)
+			if err != nil {
+				log.G(ctx).WithError(err).WithField("path", s.fifo).Warn("Error opening fifo, output only goes to the journal")
+			} else {
+				defer f.Close()
+				fifo = f
+			}
+		}
+
+		wg.Add(1)
+		go func(pipe, journal, fifo *os.File) {
+			defer wg.Done()
+			defer pipe.Close()
+			// Cutting off long lines needs to look at the output, it is copied through user space then.
+			if maxLine <= 0 && teeSplice(ctx, pipe, &journal, &fifo) {
+				return
+			}
+			teeCopy(ctx, pipe, teeWriter(journal, maxLine), teeWriter(fifo, maxLine))
+		}(s.pipe, journal, fifo)
+	}
+	wg.Wait()
+	return nil
+}
+
+// openTeeFifo opens the fifo for writing without waiting for a reader.
+// Once a reader is gone writes fail instead of blocking, see teeCopy.
+func openTeeFifo(p string) (*os.File, error) {
+	rw, err := os.OpenFile(p, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer rw.Close()
+	return os.OpenFile(p, os.O_WRONLY, 0)
+}
+
+// teeWriter returns the writer for the tee destination f, nil if there is none.
+func teeWriter(f *os.File, maxLine int) io.Writer {
+	if f == nil {
+		return nil
+	}
+	return newLineLimitWriter(f, maxLine)
+}
+
+// teeSplice copies the pipe r to the journal and the fifo like teeCopy, without copying the output through user space:
+// tee(2) duplicates what is in r into the fifo, which is a pipe too, and splice(2) then moves it on to the journal
+// stream. With only one destination left the output is spliced to it directly.
+// A destination that fails is dropped and set to nil. teeSplice returns true once r is closed, false if the kernel
+// can't splice between the files before anything was copied or both destinations are gone, the caller goes on with
+// teeCopy for the destinations that are left then.
+func teeSplice(ctx context.Context, r *os.File, journal, fifo **os.File) bool {
+	rfd := int(r.Fd())
+	copied := false
+	for *journal != nil || *fifo != nil {
+		var (
+			n   int64
+			err error
+		)
+		switch {
+		case *fifo != nil && *journal != nil:
+			n, err = teeFd(rfd, int((*fifo).Fd()))
+			if err == nil && n > 0 {
+				// The output is still in r, move it on to the journal.
+				if moved, err := spliceAll(rfd, int((*journal).Fd()), n); err != nil {
+					log.G(ctx).WithError(err).Warn("Error writing to journal, output only goes to the fifo")
+					*journal = nil
+					// The fifo already has the rest of the output.
+					if _, err := io.CopyN(io.Discard, r, n-moved); err != nil {
+						return err == io.EOF
+					}
+				}
+			}
+		case *fifo != nil:
+			n, err = spliceFd(rfd, int((*fifo).Fd()), logTeeBufferSize)
+		default:
+			n, err = spliceFd(rfd, int((*journal).Fd()), logTeeBufferSize)
+		}
+
+		switch {
+		case err == nil && n == 0:
+			return true
+		case err == nil:
+			copied = true
+		case errors.Is(err, syscall.EINVAL) && !copied:
+			log.G(ctx).WithError(err).Debug("splice not supported, copying output through user space")
+			return false
+		case *fifo != nil:
+			// Tee and splice into the fifo fail on the fifo, not the container pipe, which is ours.
+			if !errors.Is(err, syscall.EPIPE) {
+				log.G(ctx).WithError(err).Warn("Error writing to fifo, output only goes to the journal")
+			}
+			*fifo = nil
+		default:
+			log.G(ctx).WithError(err).Warn("Error writing to journal, output only goes to the fifo")
+			*journal = nil
+		}
+	}
+	return false
+}
+
+// teeFd duplicates up to logTeeBufferSize bytes of the pipe r into the pipe w, waiting for output in r.
+func teeFd(r, w int) (int64, error) {
+	for {
+		n, err := unix.Tee(r, w, logTeeBufferSize, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		return n, err
+	}
+}
+
+// spliceFd moves up to n bytes from the pipe r to w, waiting for output in r.
+func spliceFd(r, w int, n int64) (int64, error) {
+	for {
+		m, err := unix.Splice(r, nil, w, nil, int(n), unix.SPLICE_F_MOVE|unix.SPLICE_F_MORE)
+		if err == unix.EINTR {
+			continue
+		}
+		return int64(m), err
+	}
+}
+
+// spliceAll moves exactly n bytes, which must be in the pipe r already, to w.
+func spliceAll(r, w int, n int64) (int64, error) {
+	var moved int64
+	for moved < n {
+		m, err := spliceFd(r, w, n-moved)
+		if err != nil {
+			return moved, err
+		}
+		if m == 0 {
+			return moved, io.ErrUnexpectedEOF
+		}
+		moved += m
+	}
+	return moved, nil
+}
+
+// teeCopy copies r to the journal and the fifo until r is closed, both are optional.
+// A destination is dropped on the first error, the copy goes on for the other one: the journal keeps the output when
+// nobody reads the fifo anymore and the other way around.
+func teeCopy(ctx context.Context, r io.Reader, journal, fifo io.Writer) {
+	buf := make([]byte, logTeeBufferSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if journal != nil {
+				if _, err := journal.Write(buf[:n]); err != nil {
+					log.G(ctx).WithError(err).Warn("Error writing to journal, output only goes to the fifo")
+					journal = nil
+				}
+			}
+			if fifo != nil {
+				if _, err := fifo.Write(buf[:n]); err != nil {
+					if !errors.Is(err, syscall.EPIPE) {
+						log.G(ctx).WithError(err).Warn("Error writing to fifo, output only goes to the journal")
+					}
+					fifo = nil
+				}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.G(ctx).WithError(err).Debug("Error reading container output")
+			}
+			return
+		}
+	}
+}
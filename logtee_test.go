@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestTeeSplice(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fifoR, fifo, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal, journalR := os.NewFile(uintptr(fds[0]), "journal"), os.NewFile(uintptr(fds[1]), "journal-reader")
+	defer journalR.Close()
+	defer fifoR.Close()
+
+	want := strings.Repeat("some container output\n", 10000)
+	go func() {
+		io.WriteString(w, want)
+		w.Close()
+	}()
+
+	fifoOut, journalOut := make(chan string), make(chan string)
+	readAll := func(f *os.File, ch chan string) {
+		b, _ := io.ReadAll(f)
+		ch <- string(b)
+	}
+	go readAll(fifoR, fifoOut)
+	go readAll(journalR, journalOut)
+
+	jf, ff := journal, fifo
+	if !teeSplice(context.Background(), r, &jf, &ff) {
+		t.Skip("splice not supported")
+	}
+	if jf == nil || ff == nil {
+		t.Fatal("destination was dropped")
+	}
+	journal.Close()
+	fifo.Close()
+
+	if got := <-fifoOut; got != want {
+		t.Errorf("fifo got %d bytes, want %d", len(got), len(want))
+	}
+	if got := <-journalOut; got != want {
+		t.Errorf("journal got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestLineLimitWriter(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- b
+	}()
+
+	l := newLineLimitWriter(w, 4)
+	for _, s := range []string{"ab\nabcdef", "gh\nabcd\n", "x"} {
+		if n, err := l.Write([]byte(s)); err != nil || n != len(s) {
+			t.Fatalf("write %q: %d, %v", s, n, err)
+		}
+	}
+	w.Close()
+
+	if got, want := <-out, []byte("ab\nabcd\nabcd\nx"); !bytes.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
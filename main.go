@@ -38,9 +38,9 @@ import (
 	"github.com/containerd/containerd/runtime/v2/shim"
 	taskapi "github.com/containerd/containerd/runtime/v2/task"
 	"github.com/coreos/go-systemd/v22/activation"
-	systemd "github.com/coreos/go-systemd/v22/dbus"
 	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
 	"github.com/gogo/protobuf/proto"
+	"github.com/opencontainers/selinux/go-selinux"
 	"github.com/pelletier/go-toml"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
@@ -85,26 +85,71 @@ func newCtx() (context.Context, context.CancelFunc) {
 }
 
 var (
-	defaultLogMode = strings.ToLower(options.LogMode_name[int32(options.LogMode_STDIO)])
+	defaultLogMode  = strings.ToLower(options.LogMode_name[int32(options.LogMode_STDIO)])
+	defaultUnitMode = unitModeString(options.UnitMode_UNIT_MODE_FILE)
 )
 
+const unitModePrefix = "UNIT_MODE_"
+
+func unitModeString(m options.UnitMode) string {
+	return strings.ToLower(strings.TrimPrefix(m.String(), unitModePrefix))
+}
+
+// parseUnitMode parses the --unit-mode flag, "file" or "transient".
+func parseUnitMode(s string) (options.UnitMode, error) {
+	m, ok := options.UnitMode_value[unitModePrefix+strings.ToUpper(s)]
+	if !ok || options.UnitMode(m) == options.UnitMode_UNIT_MODE_DEFAULT {
+		return 0, fmt.Errorf("invalid unit mode %q: %w", s, errdefs.ErrInvalidArgument)
+	}
+	return options.UnitMode(m), nil
+}
+
 func main() {
 	var (
 		debug          bool
-		socket         = defaultAddress
+		socket         = defaultSocket()
 		address        = defaults.DefaultAddress
 		namespace      string
 		id             string
 		publishBin     string
 		root           string
+		stateRoot      = defaultStateRoot()
 		bundle         string
 		ttrpcAddr      = address + ".ttrpc"
 		logMode        = defaultLogMode
+		unitMode       = defaultUnitMode
 		noNewNamespace bool
+		shutdownPolicy = shutdownPolicyIgnore
+		existingUnits  = existingUnitsReject
+		stdioNoReader  = stdioNoReaderWarn
+		metricsAddr    string
+		debugAddr      string
+		execTimeout    time.Duration
+		execRetention  time.Duration
+		killGrace      time.Duration
+
+		eventQueueSize    = defaultEventQueueSize
+		eventQueuePolicy  = eventQueueBlock
+		eventFlushTimeout = defaultEventFlushTimeout
+
+		configFile    string
+		nriConfigPath = defaultNRIConfig
+		selinuxFlag   bool
+		perNamespace  bool
+		unitNameTmpl  string
+		logLevel      string
+		logBackend    = logBackendStderr
+		logFile       string
 
 		// create cmd
 		mountCfg string
 		tty      bool
+
+		// bench cmd
+		benchCount    = 100
+		benchParallel = 10
+		benchSize     = "1G"
+		benchIOBuffer string
 	)
 
 	rootFlags := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
@@ -117,6 +162,7 @@ func main() {
 	rootFlags.StringVar(&bundle, "bundle", "", "path to the bundle directory")
 	rootFlags.StringVar(&namespace, "namespace", "", "namespace of container")
 	rootFlags.BoolVar(&debug, "debug", debug, "enable debug output in the shim")
+	rootFlags.StringVar(&logLevel, "log-level", logLevel, "log level of the shim, optionally with levels per subsystem (dbus, runc, io, events), e.g. \"info,dbus=debug\"")
 	rootFlags.StringVar(&ttrpcAddr, "ttrpc-address", ttrpcAddr, "address to containerd ttrpc socket")
 
 	if err := rootFlags.Parse(os.Args[1:]); err != nil {
@@ -158,19 +204,81 @@ func main() {
 	containerdConfigPath := filepath.Join(defaults.DefaultConfigDir, "config.toml")
 	commands := map[string]func(context.Context) error{
 		"install": func(ctx context.Context) error {
+			mode, err := parseUnitMode(unitMode)
+			if err != nil {
+				return err
+			}
 			cfg := installConfig{
-				Root:           root,
-				Addr:           address,
-				TTRPCAddr:      ttrpcAddr,
-				Debug:          debug,
-				Socket:         socket,
-				LogMode:        options.LogMode(options.LogMode_value[strings.ToUpper(logMode)]),
-				Trace:          *traceCfg,
-				NoNewNamespace: noNewNamespace,
+				Root:            root,
+				Addr:            address,
+				TTRPCAddr:       ttrpcAddr,
+				Debug:           debug,
+				Socket:          socket,
+				LogMode:         options.LogMode(options.LogMode_value[strings.ToUpper(logMode)]),
+				UnitMode:        mode,
+				Trace:           *traceCfg,
+				NoNewNamespace:  noNewNamespace,
+				ShutdownPolicy:  shutdownPolicy,
+				MetricsAddr:     metricsAddr,
+				DebugAddr:       debugAddr,
+				ExecTimeout:     execTimeout,
+				ExecRetention:   execRetention,
+				KillGracePeriod: killGrace,
+
+				EventQueueSize:    eventQueueSize,
+				EventQueuePolicy:  eventQueuePolicy,
+				EventFlushTimeout: eventFlushTimeout,
+				ConfigFile:        configFile,
+				NRIConfig:         nriConfigPath,
+				SELinux:           selinuxFlag,
+				UnitNameTemplate:  unitNameTmpl,
+				StateRoot:         stateRoot,
+				ExistingUnits:     existingUnits,
+				StdioNoReader:     stdioNoReader,
+				LogLevel:          logLevel,
+				LogBackend:        logBackend,
+				LogFile:           logFile,
+				PerNamespace:      perNamespace,
+			}
+			if debugAddr != "" {
+				if err := validateDebugAddr(debugAddr); err != nil {
+					return err
+				}
+			}
+			if err := validatePerNamespace(cfg); err != nil {
+				return err
+			}
+			if err := validateShutdownPolicy(shutdownPolicy); err != nil {
+				return err
+			}
+			if err := validateExistingUnitsPolicy(existingUnits); err != nil {
+				return err
+			}
+			if err := validateStdioNoReaderPolicy(stdioNoReader); err != nil {
+				return err
+			}
+			if err := validateEventQueuePolicy(eventQueuePolicy); err != nil {
+				return err
+			}
+			if err := validateLogBackend(logBackend, logFile); err != nil {
+				return err
+			}
+			if _, err := loadShimConfig(configFile); err != nil {
+				return err
+			}
+			if _, err := loadNRIConfig(nriConfigPath); err != nil {
+				return err
+			}
+			if err := validateUnitNameTemplate(unitNameTmpl); err != nil {
+				return err
 			}
 			return install(ctx, cfg)
 		},
 		"uninstall": uninstall,
+		"version": func(ctx context.Context) error {
+			fmt.Println(shimVersion())
+			return nil
+		},
 		"delete": func(ctx context.Context) error {
 			var (
 				resp *taskapi.DeleteResponse
@@ -208,6 +316,18 @@ func main() {
 			return nil
 		},
 		"start": func(ctx context.Context) error {
+			// There is only one shim daemon for all containers, or one per namespace, so there is nothing to spawn
+			// here. We just hand containerd the address of the daemon's socket, starting the socket of the namespace
+			// if it is not listening yet.
+			if nsSocket, ok, err := startNamespaceSocket(ctx, socket, namespace); err != nil {
+				return err
+			} else if ok {
+				socket = nsSocket
+			}
+			if _, err := os.Stat(socket); err != nil {
+				return fmt.Errorf("shim daemon socket is not available, has the shim been installed (%s install)?: %w", filepath.Base(os.Args[0]), err)
+			}
+
 			addr := "unix://" + socket
 
 			if err := shim.WriteAddress("address", addr); err != nil {
@@ -228,6 +348,12 @@ func main() {
 			}
 
 			setupLogFormat(ctx, containerdConfig)
+			if err := validateLogBackend(logBackend, logFile); err != nil {
+				return err
+			}
+			if err := setupLogBackend(logBackend, logFile); err != nil {
+				return err
+			}
 
 			log.G(ctx).Infof("Starting with unit name %s", os.Getenv("UNIT_NAME"))
 			done, err := ConfigureTracing(ctx, traceCfg)
@@ -241,11 +367,46 @@ func main() {
 				return err
 			}
 
+			if err := validateShutdownPolicy(shutdownPolicy); err != nil {
+				return err
+			}
+			if err := validateExistingUnitsPolicy(existingUnits); err != nil {
+				return err
+			}
+			if err := validateStdioNoReaderPolicy(stdioNoReader); err != nil {
+				return err
+			}
+			if err := validateEventQueuePolicy(eventQueuePolicy); err != nil {
+				return err
+			}
+			mode, err := parseUnitMode(unitMode)
+			if err != nil {
+				return err
+			}
+
 			opts := Config{
-				Root:           root,
-				Publisher:      publisher,
-				LogMode:        options.LogMode(options.LogMode_value[strings.ToUpper(logMode)]),
-				NoNewNamespace: noNewNamespace,
+				Root:            root,
+				Publisher:       publisher,
+				LogMode:         options.LogMode(options.LogMode_value[strings.ToUpper(logMode)]),
+				UnitMode:        mode,
+				NoNewNamespace:  noNewNamespace,
+				ShutdownPolicy:  shutdownPolicy,
+				MetricsAddr:     metricsAddr,
+				DebugAddr:       debugAddr,
+				ExecTimeout:     execTimeout,
+				ExecRetention:   execRetention,
+				KillGracePeriod: killGrace,
+
+				EventQueueSize:    eventQueueSize,
+				EventQueuePolicy:  eventQueuePolicy,
+				EventFlushTimeout: eventFlushTimeout,
+				ConfigFile:        configFile,
+				NRIConfig:         nriConfigPath,
+				UnitNameTemplate:  unitNameTmpl,
+				StateRoot:         stateRoot,
+				ExistingUnits:     existingUnits,
+				StdioNoReader:     stdioNoReader,
+				Namespace:         namespace,
 			}
 			return serve(ctx, opts)
 		},
@@ -267,13 +428,113 @@ func main() {
 					return err
 				}
 			}
-			return createCmd(ctx, bundle, flags.Args(), tty, mountCfg != "")
+			return createCmd(ctx, bundle, flags.Args(), tty, mountCfg != "", logMode)
+		},
+		"bench": func(ctx context.Context) error {
+			// Flags can come after the kind of benchmark too.
+			kind := flags.Arg(0)
+			if flags.NArg() > 1 {
+				if err := flags.Parse(flags.Args()[1:]); err != nil {
+					return err
+				}
+			}
+			switch kind {
+			case "create":
+				mode, err := parseUnitMode(unitMode)
+				if err != nil {
+					return err
+				}
+				return benchCreate(ctx, os.Stdout, benchConfig{Count: benchCount, Parallel: benchParallel, UnitMode: mode})
+			case "io":
+				size, err := parseByteSize(benchSize)
+				if err != nil {
+					return fmt.Errorf("invalid size: %w", err)
+				}
+				cfg := benchIOConfig{Size: size}
+				if benchIOBuffer != "" {
+					cfg.BufferSize, err = parseByteSize(benchIOBuffer)
+					if err != nil {
+						return fmt.Errorf("invalid buffer size: %w", err)
+					}
+				}
+				return benchIO(ctx, os.Stdout, cfg)
+			default:
+				return errors.New("usage: bench create [--count=<n>] [--parallel=<n>] [--unit-mode=<mode>] | bench io [--size=<bytes>] [--io-buffer-size=<bytes>]")
+			}
+		},
+		"hook": func(ctx context.Context) error {
+			pid, err := strconv.Atoi(flags.Arg(1))
+			if flags.NArg() != 2 || err != nil {
+				return errors.New("usage: hook poststart|poststop <pid>")
+			}
+			return runHooks(ctx, bundle, id, flags.Arg(0), pid)
+		},
+		"restart-prepare": func(ctx context.Context) error {
+			ctx = log.WithLogger(ctx, log.G(ctx).WithField("unit", os.Getenv("UNIT_NAME")))
+			ctx = WithShimLog(ctx, OpenShimLog(ctx, bundle))
+			if flags.NArg() == 0 {
+				return errors.New("usage: restart-prepare <runtime delete command>")
+			}
+			return restartPrepare(ctx, bundle, flags.Args())
+		},
+		"restart-start": func(ctx context.Context) error {
+			ctx = log.WithLogger(ctx, log.G(ctx).WithField("unit", os.Getenv("UNIT_NAME")))
+			ctx = WithShimLog(ctx, OpenShimLog(ctx, bundle))
+			if flags.NArg() == 0 {
+				return errors.New("usage: restart-start <runtime start command>")
+			}
+			return restartStart(ctx, bundle, flags.Args())
+		},
+		"notify-proxy": func(ctx context.Context) error {
+			ctx = log.WithLogger(ctx, log.G(ctx).WithField("unit", os.Getenv("UNIT_NAME")))
+			ctx = WithShimLog(ctx, OpenShimLog(ctx, bundle))
+			return notifyProxy(ctx)
+		},
+		"log-tee": func(ctx context.Context) error {
+			ctx = log.WithLogger(ctx, log.G(ctx).WithField("unit", os.Getenv("UNIT_NAME")))
+			ctx = WithShimLog(ctx, OpenShimLog(ctx, bundle))
+			return logTee(ctx)
+		},
+		"checkpoint-image": func(ctx context.Context) error {
+			switch {
+			case flags.NArg() == 3 && flags.Arg(0) == "pack":
+				return packCheckpointImageFile(ctx, flags.Arg(2), flags.Arg(1), id)
+			case flags.NArg() == 3 && flags.Arg(0) == "unpack":
+				image, err := unpackCheckpointImageFile(ctx, flags.Arg(1), flags.Arg(2))
+				if err != nil {
+					return err
+				}
+				fmt.Println(image)
+				return nil
+			default:
+				return errors.New("usage: checkpoint-image [--id=<container name>] pack <checkpoint dir> <archive> | checkpoint-image unpack <archive> <dir>")
+			}
+		},
+		"unit-container": func(ctx context.Context) error {
+			if flags.NArg() != 1 {
+				return errors.New("usage: unit-container <unit>")
+			}
+			conn, err := connectSystemd(ctx)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			owner, err := lookupUnitOwner(ctx, conn, flags.Arg(0))
+			if err != nil {
+				return err
+			}
+			return json.NewEncoder(os.Stdout).Encode(owner)
+		},
+		"lazy-pages": func(ctx context.Context) error {
+			ctx = log.WithLogger(ctx, log.G(ctx).WithField("unit", os.Getenv("UNIT_NAME")))
+			ctx = WithShimLog(ctx, OpenShimLog(ctx, bundle))
+			return lazyPages(ctx)
 		},
 		"exit": func(ctx context.Context) error {
 			ctx = log.WithLogger(ctx, log.G(ctx).WithField("unit", os.Getenv("UNIT_NAME")))
 			ctx = WithShimLog(ctx, OpenShimLog(ctx, bundle))
 
-			conn, err := systemd.NewSystemdConnectionContext(ctx)
+			conn, err := connectSystemd(ctx)
 			if err != nil {
 				return err
 			}
@@ -290,11 +551,18 @@ func main() {
 				}
 
 				if st.Exited() {
+					writeExitStatus(ctx, st)
 					return nil
 				}
 			}
 
 			code, err := strconv.Atoi(os.Getenv("EXIT_STATUS"))
+			if err != nil && (os.Getenv("EXIT_CODE") == "killed" || os.Getenv("EXIT_CODE") == "dumped") {
+				// systemd sets the signal name for processes killed by a signal
+				if sig, err2 := parseSignal(os.Getenv("EXIT_STATUS")); err2 == nil {
+					code, err = int(execMainExitCode(cldKilled, int32(sig))), nil
+				}
+			}
 			if err != nil {
 				code = 255
 				if os.Getenv("EXIT_STATUS") == "" {
@@ -330,13 +598,17 @@ func main() {
 			}
 
 			st.Status = os.Getenv("EXIT_CODE")
+			st.Result = os.Getenv("SERVICE_RESULT")
 			st.ExitedAt = time.Now()
 			st.ExitCode = uint32(code)
+			if st.Result == serviceResultTimeout && os.Getenv(execTimeoutEnv) != "" {
+				st.ExitCode = execTimeoutExitCode
+			}
 
 			if st.ExitCode == 255 {
 				log.G(ctx).Debug("Falling back to reading exit status from systemd api")
 				var st2 pState
-				if err := getUnitState(ctx, conn, os.Getenv("UNIT_NAME"), &st); err != nil {
+				if err := getUnitState(ctx, conn, os.Getenv("UNIT_NAME"), &st2); err != nil {
 					log.G(ctx).WithError(err).Error("Error reading unit state")
 				}
 				if st2.ExitCode > 0 {
@@ -352,6 +624,7 @@ func main() {
 			if err := os.WriteFile(os.Getenv("EXIT_STATE_PATH"), data, 0600); err != nil {
 				return fmt.Errorf("error writing status: %v", err)
 			}
+			writeExitStatus(ctx, st)
 
 			// Should this wait for the reload job to complete?
 			// e.g. by passing in a channel instead of nil and waiting on the channel
@@ -376,16 +649,42 @@ func main() {
 		os.Exit(1)
 	}
 
-	flags.BoolVar(&debug, "debug", debug, "enable debug output in the shim")
+	flags.BoolVar(&debug, "debug", debug, "enable debug output in the shim, the same as --log-level=debug")
+	flags.StringVar(&logLevel, "log-level", logLevel, "log level of the shim, optionally with levels per subsystem (dbus, runc, io, events), e.g. \"info,dbus=debug\"")
+	flags.StringVar(&logBackend, "log-backend", logBackend, "where the shim logs to (stderr, journald, file or fifo)")
+	flags.StringVar(&logFile, "log-file", logFile, "file the shim logs to as JSON with --log-backend=file")
 	flags.StringVar(&ttrpcAddr, "ttrpc-address", ttrpcAddr, "ttrpc address back to containerd")
-	flags.StringVar(&root, "root", filepath.Join(defaults.DefaultStateDir, shimName), "root to store state in")
+	flags.StringVar(&root, "root", defaultRoot(defaults.DefaultStateDir), "root to store state in")
+	flags.StringVar(&stateRoot, "state-root", stateRoot, "directory to keep the runtime state of containers (pid files, process specs, mount configs) in, best on tmpfs")
 	flags.StringVar(&socket, "socket", socket, "socket path to serve")
 
 	flags.StringVar(&logMode, "log-mode", logMode, "sets the default log mode for containers")
+	flags.StringVar(&unitMode, "unit-mode", unitMode, "sets the default unit mode for containers (file or transient)")
+	flags.StringVar(&debugAddr, "debug-addr", debugAddr, "unix socket path to serve pprof and state dumps on (disabled if empty)")
+	flags.StringVar(&metricsAddr, "metrics-address", metricsAddr, "address to serve prometheus metrics on, a unix socket path or host:port (disabled if empty)")
+	flags.StringVar(&shutdownPolicy, "shutdown-policy", shutdownPolicy, "what to do when containerd asks the shim to shut down (ignore, leave-running or stop)")
+	flags.StringVar(&stdioNoReader, "stdio-no-reader", stdioNoReader, "what to do when the stdout or stderr fifo of a process is missing or not read when its unit starts (fail, warn or null to discard the output)")
+	flags.StringVar(&existingUnits, "existing-units", existingUnits, "what to do when the unit of a new container or exec is already running, e.g. left behind by a crashed shim (reject or clean)")
+	flags.DurationVar(&execTimeout, "exec-timeout", execTimeout, "default maximum lifetime of exec processes, after which they are stopped (0 for no limit)")
+	flags.DurationVar(&execRetention, "exec-retention", execRetention, "how long exited exec processes are kept before they are deleted if the client did not delete them (0 to keep them)")
+	flags.DurationVar(&killGrace, "kill-grace-period", killGrace, "default time processes get to exit after they were sent SIGTERM with the kill api, after which they are sent SIGKILL (0 to leave it to the client)")
+	flags.IntVar(&eventQueueSize, "event-queue-size", eventQueueSize, "maximum number of events waiting to be published to containerd")
+	flags.StringVar(&eventQueuePolicy, "event-queue-policy", eventQueuePolicy, "what to do with new events when the event queue is full (block or drop)")
+	flags.DurationVar(&eventFlushTimeout, "event-flush-timeout", eventFlushTimeout, "how long to keep publishing queued events on exit, the rest is published on the next start")
 
 	flags.StringVar(&mountCfg, "mounts", mountCfg, "mount config for container")
 	flags.BoolVar(&tty, "tty", tty, "stdio is tty")
 
+	flags.IntVar(&benchCount, "count", benchCount, "number of units to create (bench)")
+	flags.IntVar(&benchParallel, "parallel", benchParallel, "number of units to create concurrently (bench)")
+	flags.StringVar(&benchSize, "size", benchSize, "amount of output to relay, e.g. 512M (bench io)")
+	flags.StringVar(&benchIOBuffer, "io-buffer-size", benchIOBuffer, "copy buffer size of the tty helper (bench io)")
+
+	flags.StringVar(&configFile, "config", configFile, "path to the shim config file with per-namespace defaults (TOML, or JSON with a .json extension)")
+	flags.StringVar(&nriConfigPath, "nri-config", nriConfigPath, "path to the NRI plugin config, NRI plugins are not run if it doesn't exist")
+	flags.BoolVar(&selinuxFlag, "selinux-enabled", selinuxFlag, "label the files the shim creates for systemd and the runtime when SELinux is enabled")
+	flags.StringVar(&unitNameTmpl, "unit-name-template", unitNameTmpl, "naming scheme of new container units, with {ns}, {id} and optionally {mod} (default \""+defaultUnitNameTemplate+"\")")
+	flags.BoolVar(&perNamespace, "per-namespace", perNamespace, "install a shim daemon per namespace instead of one for all namespaces (install)")
 	flags.StringVar(&containerdConfigPath, "containerd-config", containerdConfigPath, "path to containerd config")
 
 	if len(os.Args) < 2 {
@@ -402,10 +701,22 @@ func main() {
 		logMode = defaultLogMode
 	}
 
-	if debug {
-		logrus.SetLevel(logrus.DebugLevel)
+	if unitMode == "" {
+		unitMode = defaultUnitMode
 	}
 
+	levels, err := parseLogLevels(logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	if debug && levels.Default < logrus.DebugLevel {
+		levels.Default = logrus.DebugLevel
+	}
+	setupLogLevels(levels)
+
+	selinuxEnabled = selinuxFlag && selinux.GetEnabled()
+
 	action := rootFlags.Arg(0)
 
 	ctx, cancel := newCtx()
@@ -441,20 +752,23 @@ func main() {
 func serve(ctx context.Context, cfg Config) error {
 	log.G(ctx).Info("Starting...")
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/profile", pprof.Profile)
-	go func() {
-		if err := http.ListenAndServe("127.0.0.1:8089", mux); err != nil {
-			log.G(ctx).WithError(err).Fatal("ListenAndServe")
-		}
-	}()
+	// The daemons of the namespaces can't share the port, they have --debug-addr for profiles.
+	if cfg.Namespace == "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/profile", pprof.Profile)
+		go func() {
+			if err := http.ListenAndServe("127.0.0.1:8089", mux); err != nil {
+				log.G(ctx).WithError(err).Fatal("ListenAndServe")
+			}
+		}()
+	}
 
 	shm, err := New(ctx, cfg)
 	if err != nil {
 		return err
 	}
 
-	svc, err := newService(shm)
+	svc, err := newService(shm, shm, cfg.Namespace)
 	if err != nil {
 		return err
 	}
@@ -467,10 +781,38 @@ func serve(ctx context.Context, cfg Config) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	go shm.Forward(ctx, cfg.Publisher)
+	go shm.handleReload(ctx)
+
+	if cfg.MetricsAddr != "" {
+		if err := shm.serveMetrics(ctx, cfg.MetricsAddr); err != nil {
+			return err
+		}
+	}
+	if cfg.DebugAddr != "" {
+		if err := shm.serveDebug(ctx, cfg.DebugAddr); err != nil {
+			return err
+		}
+	}
+	shm.shutdown = cancel
+
+	if err := shm.Recover(ctx); err != nil {
+		return fmt.Errorf("error recovering tasks: %w", err)
+	}
+	shm.collectOrphans(ctx)
+	shm.collectCNI(ctx)
+	shm.collectStateDirs(ctx)
+	if cfg.ExecRetention > 0 {
+		go shm.collectExecs(ctx, cfg.ExecRetention)
+	}
+
 	listeners, err := activation.Listeners()
 	if err != nil {
 		return err
 	}
+	if len(listeners) == 0 {
+		return fmt.Errorf("no sockets were passed in, the shim must be started through its socket unit")
+	}
 	for _, l := range listeners {
 		ul, ok := l.(*net.UnixListener)
 		if !ok {
@@ -484,10 +826,21 @@ func serve(ctx context.Context, cfg Config) error {
 		}(l)
 	}
 
-	go shm.Forward(ctx, cfg.Publisher)
-
 	<-ctx.Done()
+
+	// The context is already cancelled, so use a new one to give requests (like the Shutdown call that got us here) time to finish.
+	sctx, scancel := context.WithTimeout(log.WithLogger(context.Background(), log.G(ctx)), shutdownTimeout)
+	defer scancel()
+	if err := svc.Shutdown(sctx); err != nil {
+		log.G(ctx).WithError(err).Warn("Error shutting down shim api")
+	}
 	svc.Close()
+	shm.drain(sctx)
+	// Close flushes any events that are still queued.
 	shm.Close()
+
+	if shm.ShutdownRequested() {
+		return nil
+	}
 	return ctx.Err()
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	dbus "github.com/godbus/dbus/v5"
+)
+
+// unitMainPID is what systemd knows about the main process of a unit.
+type unitMainPID struct {
+	Pid          uint32
+	ControlGroup string
+}
+
+// setMainPID records the main pid and cgroup systemd reported for the process's unit.
+func (p *process) setMainPID(main unitMainPID) {
+	p.mu.Lock()
+	if p.state.Pid == 0 {
+		p.state.Pid = main.Pid
+	}
+	if main.ControlGroup != "" {
+		p.controlGroup = main.ControlGroup
+	}
+	p.mu.Unlock()
+}
+
+// waitMainPID waits for systemd to report the main pid of the unit.
+//
+// The pid file written by runc is only read by the helper in the unit, in the shim it may not be there yet when the
+// start job completes, or be left over from a previous run. systemd reads it (PIDFile=) or gets it from the helper
+// (MAINPID=) before the unit is active, so MainPID is the source of truth once it is set.
+// Returns an error if the unit stops before it has a main pid.
+func waitMainPID(ctx context.Context, conn *sdConn, name string) (unitMainPID, error) {
+	bus := conn.Bus()
+	path := dbus.ObjectPath(sdUnitPathPrefix + systemd.PathBusEscape(name))
+
+	// Subscribe before reading the properties so a change in between isn't missed.
+	ch := make(chan *dbus.Signal, 16)
+	bus.Signal(ch)
+	defer bus.RemoveSignal(ch)
+
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"),
+	}
+	if err := bus.AddMatchSignalContext(ctx, match...); err != nil {
+		return unitMainPID{}, fmt.Errorf("error watching unit %s: %w", name, err)
+	}
+	defer bus.RemoveMatchSignalContext(context.Background(), match...)
+
+	props, err := conn.GetUnitTypePropertiesContext(ctx, name, "Service")
+	if err != nil {
+		return unitMainPID{}, err
+	}
+	var st unitMainPID
+	st.Pid, _ = props["MainPID"].(uint32)
+	st.ControlGroup, _ = props["ControlGroup"].(string)
+	if st.Pid > 0 {
+		return st, nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return unitMainPID{}, ctx.Err()
+		case <-bus.Context().Done():
+			return unitMainPID{}, fmt.Errorf("connection to systemd lost while waiting for %s", name)
+		case sig := <-ch:
+			if sig.Path != path || sig.Name != "org.freedesktop.DBus.Properties.PropertiesChanged" || len(sig.Body) < 2 {
+				continue
+			}
+			changed, _ := sig.Body[1].(map[string]dbus.Variant)
+			switch iface, _ := sig.Body[0].(string); iface {
+			case sdServiceIface:
+				if v, ok := changed["ControlGroup"]; ok {
+					st.ControlGroup, _ = v.Value().(string)
+				}
+				if v, ok := changed["MainPID"]; ok {
+					st.Pid, _ = v.Value().(uint32)
+				}
+				if st.Pid > 0 {
+					return st, nil
+				}
+			case sdUnitIface:
+				if v, ok := changed["ActiveState"]; ok {
+					if state, _ := v.Value().(string); state == "inactive" || state == "failed" {
+						return unitMainPID{}, fmt.Errorf("unit %s is %s", name, state)
+					}
+				}
+			}
+		}
+	}
+}
@@ -8,17 +8,16 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sync"
 	"time"
 
-	"github.com/containerd/cgroups"
-	cgroupsv2 "github.com/containerd/cgroups/v2"
+	eventsapi "github.com/containerd/containerd/api/events"
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/events"
 	"github.com/containerd/containerd/log"
 	"github.com/containerd/containerd/namespaces"
 	taskapi "github.com/containerd/containerd/runtime/v2/task"
 	"github.com/containerd/typeurl"
-	systemd "github.com/coreos/go-systemd/v22/dbus"
 	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
 	ptypes "github.com/gogo/protobuf/types"
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -38,11 +37,37 @@ type Config struct {
 	Root           string
 	Publisher      events.Publisher
 	LogMode        options.LogMode
+	UnitMode       options.UnitMode
 	NoNewNamespace bool
+	ShutdownPolicy string
+	MetricsAddr    string
+	DebugAddr      string
+	ExecTimeout    time.Duration
+	ExecRetention  time.Duration
+	// KillGracePeriod is the default kill grace period of containers, see killGracePeriodAnnotation.
+	KillGracePeriod time.Duration
+	// EventQueueSize, EventQueuePolicy and EventFlushTimeout configure the event queue, see eventQueue.
+	EventQueueSize    int
+	EventQueuePolicy  string
+	EventFlushTimeout time.Duration
+	// ConfigFile is the path to the shim config file, see shimConfig.
+	ConfigFile string
+	// NRIConfig is the path to the NRI config, see nriConfig.
+	NRIConfig string
+	// UnitNameTemplate is the naming scheme of new container units, see validateUnitNameTemplate.
+	UnitNameTemplate string
+	// StateRoot is the directory the runtime state of new containers is kept in, see containerStateDir.
+	StateRoot string
+	// ExistingUnits is the policy for running units with the name of a new container or exec, see checkUnitName.
+	ExistingUnits string
+	// StdioNoReader is the policy for stdout and stderr fifos nobody reads, see checkStdioOutput.
+	StdioNoReader string
+	// Namespace is the only namespace the shim serves when it runs a daemon per namespace, empty for all namespaces.
+	Namespace string
 }
 
 func New(ctx context.Context, cfg Config) (*Service, error) {
-	conn, err := systemd.NewSystemdConnectionContext(ctx)
+	conn, err := newSDConn(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -52,6 +77,32 @@ func New(ctx context.Context, cfg Config) (*Service, error) {
 		return nil, fmt.Errorf("error looking up runc path: %w", err)
 	}
 
+	config, err := loadShimConfig(cfg.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	nri, err := loadNRIConfig(cfg.NRIConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if opTimeouts, err = config.Timeouts.parse(); err != nil {
+		return nil, err
+	}
+	if opRetries, err = config.Retry.parse(); err != nil {
+		return nil, err
+	}
+
+	bootID, err := readBootID()
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("Error reading boot ID, containers running before a reboot can't be detected")
+	}
+
+	if err := validateUnitNameTemplate(cfg.UnitNameTemplate); err != nil {
+		return nil, err
+	}
+
 	runcRoot := filepath.Join(cfg.Root, "runc")
 	if err := os.MkdirAll(runcRoot, 0710); err != nil {
 		return nil, err
@@ -67,37 +118,98 @@ func New(ctx context.Context, cfg Config) (*Service, error) {
 		"runc.root": runcRoot,
 	})
 
-	debug := logrus.GetLevel() >= logrus.DebugLevel
+	debug := logLevels.level(logRunc) >= logrus.DebugLevel
 	return &Service{
-		conn:           conn,
-		exe:            exe,
-		root:           cfg.Root,
-		noNewNamespace: cfg.NoNewNamespace,
-		publisher:      cfg.Publisher,
-		events:         make(chan eventEnvelope, 128),
-		waitEvents:     make(chan struct{}),
-		defaultLogMode: cfg.LogMode,
-		processes:      &processManager{ls: make(map[string]Process)},
-		units:          newUnitManager(conn),
-		runcBin:        runcPath,
-		debug:          debug,
+		conn:            conn,
+		exe:             exe,
+		root:            cfg.Root,
+		noNewNamespace:  cfg.NoNewNamespace,
+		publisher:       cfg.Publisher,
+		queue:           newEventQueue(cfg.EventQueueSize, cfg.EventQueuePolicy, cfg.EventFlushTimeout),
+		journal:         newEventJournal(ctx, filepath.Join(cfg.Root, "events")),
+		waitEvents:      make(chan struct{}),
+		defaultLogMode:  cfg.LogMode,
+		defaultUnitMode: cfg.UnitMode,
+		shutdownPolicy:  cfg.ShutdownPolicy,
+		execTimeout:     cfg.ExecTimeout,
+		execRetention:   cfg.ExecRetention,
+		killGracePeriod: cfg.KillGracePeriod,
+		processes:       newProcessManager(),
+		sandboxes:       make(map[string]*podSandbox),
+		units:           newUnitManager(conn),
+		reloader:        newReloader(conn),
+		runcBin:         runcPath,
+		debug:           debug,
+		config:          config,
+		configFile:      cfg.ConfigFile,
+		nri:             nri,
+
+		unitNameTemplate: cfg.UnitNameTemplate,
+		stateRoot:        cfg.StateRoot,
+		existingUnits:    cfg.ExistingUnits,
+		stdioNoReader:    cfg.StdioNoReader,
+		namespace:        cfg.Namespace,
+		bootID:           bootID,
 	}, nil
 }
 
 type Service struct {
-	conn           *systemd.Conn
+	conn           *sdConn
 	runcBin        string
 	debug          bool
 	root           string
 	noNewNamespace bool
 	publisher      events.Publisher
-	events         chan eventEnvelope
+	queue          *eventQueue
+	journal        *eventJournal
 	waitEvents     chan struct{}
 
 	processes *processManager
 	units     *unitManager
-
-	defaultLogMode options.LogMode
+	reloader  *reloader
+	runtimes  runtimeCache
+	// creating are the ids of the containers being created.
+	creating idReservations
+	// sandboxes are the pods created through the sandbox API, keyed by namespace and id.
+	sandboxes map[string]*podSandbox
+	sandboxMu sync.Mutex
+
+	defaultLogMode  options.LogMode
+	defaultUnitMode options.UnitMode
+	// config holds the per-namespace defaults from the shim config file, see currentConfig.
+	config   *shimConfig
+	configMu sync.Mutex
+	// configFile is the path of the shim config file, it is read again on reload.
+	configFile string
+	// reloading serializes config reloads.
+	reloading sync.Mutex
+	// nri holds the NRI plugins run before a container unit is created, nil if there are none.
+	nri *nriConfig
+	// unitNameTemplate is the naming scheme of new container units, empty for the default.
+	unitNameTemplate string
+	// stateRoot is the directory the runtime state of new containers is kept in.
+	stateRoot string
+	// existingUnits is the policy for running units with the name of a new container or exec.
+	existingUnits string
+	// stdioNoReader is the policy for stdout and stderr fifos nobody reads.
+	stdioNoReader string
+	// namespace is the only namespace the shim serves, empty if it serves all of them.
+	namespace string
+	// bootID is the boot ID of the host, containers saved with another one were running before a reboot.
+	bootID string
+
+	// execTimeout is the default maximum lifetime of exec processes.
+	execTimeout time.Duration
+	// execRetention is how long exited exec processes are kept around for the client to delete them.
+	execRetention time.Duration
+	// killGracePeriod is the default kill grace period of containers.
+	killGracePeriod time.Duration
+
+	shutdownPolicy string
+	// shutdown stops serving the shim api, it is set by serve.
+	shutdown          func()
+	mu                sync.Mutex
+	shutdownRequested bool
 
 	// exe is used to re-exec the shim binary to start up a pty copier
 	exe string
@@ -108,19 +220,11 @@ func (s *Service) Cleanup(ctx context.Context) (*taskapi.DeleteResponse, error)
 	return &taskapi.DeleteResponse{}, nil
 }
 
-func unitName(ns, id, mod string) string {
-	n := "io-containerd-systemd-" + ns + "-" + id
-	if mod != "" {
-		n += "-" + mod
-	}
-	return n + ".service"
-}
-
 func (s *Service) Close() {
-	s.conn.Unsubscribe()
 	s.conn.Close()
-	close(s.events)
+	s.queue.Close()
 	<-s.waitEvents
+	s.journal.Close()
 }
 
 // Pause the container
@@ -140,6 +244,10 @@ func (s *Service) Pause(ctx context.Context, r *taskapi.PauseRequest) (_ *ptypes
 
 	p := s.processes.Get(path.Join(ns, r.ID))
 	if p == nil {
+		// Pausing a sandbox freezes the whole pod.
+		if _, err := s.getSandbox(ns, r.ID); err == nil {
+			return &ptypes.Empty{}, s.freezeSandbox(ctx, ns, r.ID)
+		}
 		return nil, fmt.Errorf("%w: %s", errdefs.ErrNotFound, r.ID)
 	}
 	ctx = WithShimLog(ctx, p.LogWriter())
@@ -148,6 +256,10 @@ func (s *Service) Pause(ctx context.Context, r *taskapi.PauseRequest) (_ *ptypes
 	if err != nil {
 		return nil, err
 	}
+
+	s.send(ctx, ns, &eventsapi.TaskPaused{
+		ContainerID: r.ID,
+	})
 	return &ptypes.Empty{}, nil
 }
 
@@ -169,6 +281,9 @@ func (s *Service) Resume(ctx context.Context, r *taskapi.ResumeRequest) (_ *ptyp
 
 	p := s.processes.Get(path.Join(ns, r.ID))
 	if p == nil {
+		if _, err := s.getSandbox(ns, r.ID); err == nil {
+			return &ptypes.Empty{}, s.thawSandbox(ctx, ns, r.ID)
+		}
 		return nil, fmt.Errorf("%w: %s", errdefs.ErrNotFound, r.ID)
 	}
 
@@ -177,6 +292,10 @@ func (s *Service) Resume(ctx context.Context, r *taskapi.ResumeRequest) (_ *ptyp
 	if err := p.(*initProcess).Resume(ctx); err != nil {
 		return nil, err
 	}
+
+	s.send(ctx, ns, &eventsapi.TaskResumed{
+		ContainerID: r.ID,
+	})
 	return &ptypes.Empty{}, nil
 }
 
@@ -192,10 +311,12 @@ func (s *Service) Kill(ctx context.Context, r *taskapi.KillRequest) (_ *ptypes.E
 		"namespace":    ns,
 		"container_id": r.ID,
 		"exec_id":      r.ExecID,
+		"signal":       signalName(int(r.Signal)),
 	}))
 
 	ctx, span := StartSpan(ctx, "service.Kill")
 	defer func() {
+		metrics.kills.Inc(ns, resultLabel(retErr))
 		if retErr != nil {
 			retErr = errdefs.ToGRPCf(retErr, "kill")
 			span.SetStatus(codes.Error, retErr.Error())
@@ -204,6 +325,11 @@ func (s *Service) Kill(ctx context.Context, r *taskapi.KillRequest) (_ *ptypes.E
 		span.End()
 	}()
 
+	// systemd refuses signals it doesn't know, the error is clearer before asking it.
+	if !validSignal(int(r.Signal)) {
+		return nil, fmt.Errorf("invalid signal %d: %w", r.Signal, errdefs.ErrInvalidArgument)
+	}
+
 	p := s.processes.Get(path.Join(ns, r.ID))
 	if p == nil {
 		return nil, fmt.Errorf("process %s: %w", r.ID, errdefs.ErrNotFound)
@@ -267,7 +393,7 @@ func (s *Service) Checkpoint(ctx context.Context, r *taskapi.CheckpointTaskReque
 		return nil, errdefs.ToGRPC(err)
 	}
 
-	ctx, span := StartSpan(ctx, "service.Checkpoint")
+	ctx, span := StartSpan(ctx, "service.Checkpoint", trace.WithAttributes(attribute.String(nsAttr, ns), attribute.String(cIDAttr, r.ID)))
 	defer func() {
 		if retErr != nil {
 			retErr = errdefs.ToGRPCf(retErr, "checkpoint")
@@ -284,9 +410,15 @@ func (s *Service) Checkpoint(ctx context.Context, r *taskapi.CheckpointTaskReque
 
 	ctx = WithShimLog(ctx, p.LogWriter())
 
-	if err := p.(*initProcess).Checkpoint(ctx, r.Options); err != nil {
+	image, err := p.(*initProcess).Checkpoint(ctx, r.Path, r.Options)
+	if err != nil {
 		return nil, err
 	}
+
+	s.send(ctx, ns, &eventsapi.TaskCheckpointed{
+		ContainerID: r.ID,
+		Checkpoint:  image,
+	})
 	return &ptypes.Empty{}, nil
 }
 
@@ -311,12 +443,39 @@ func (s *Service) Connect(ctx context.Context, r *taskapi.ConnectRequest) (_ *ta
 		return nil, fmt.Errorf("process %s: %w", r.ID, errdefs.ErrNotFound)
 	}
 
-	return &taskapi.ConnectResponse{TaskPid: p.Pid(), ShimPid: uint32(os.Getpid())}, nil
+	// There is no place for extra data in the response, so the unit is only recorded on the span and in the logs.
+	span.SetAttributes(attribute.String(unitAttr, p.Name()))
+	log.G(ctx).WithField("id", r.ID).WithField("unit", p.Name()).Debug("Connect")
+
+	return &taskapi.ConnectResponse{
+		TaskPid: p.Pid(),
+		ShimPid: uint32(os.Getpid()),
+		Version: shimVersion(),
+	}, nil
 }
 
 // Shutdown is called after the underlying resources of the shim are cleaned up and the Service can be stopped
 func (s *Service) Shutdown(ctx context.Context, r *taskapi.ShutdownRequest) (*ptypes.Empty, error) {
-	// We ignore this call because we don't actually want containerd to shut us down since systemd manages our lifecycle.
+	ctx, span := StartSpan(ctx, "service.Shutdown", trace.WithAttributes(attribute.String(cIDAttr, r.ID), attribute.String("policy", s.shutdownPolicy)))
+	defer span.End()
+
+	// By default we ignore this call because systemd manages our lifecycle.
+	if s.shutdownPolicy == shutdownPolicyIgnore || s.shutdown == nil {
+		return &ptypes.Empty{}, nil
+	}
+
+	// We serve all containers, so only go away once none are left unless told otherwise.
+	if n := s.processes.Len(); n > 0 && !r.Now {
+		log.G(ctx).WithField("tasks", n).Debug("Ignoring shutdown request, tasks still running")
+		return &ptypes.Empty{}, nil
+	}
+
+	log.G(ctx).WithField("policy", s.shutdownPolicy).Info("Shutting down on request")
+	s.mu.Lock()
+	s.shutdownRequested = true
+	s.mu.Unlock()
+	s.shutdown()
+
 	return &ptypes.Empty{}, nil
 }
 
@@ -346,33 +505,9 @@ func (s *Service) Stats(ctx context.Context, r *taskapi.StatsRequest) (_ *taskap
 
 	ctx = WithShimLog(ctx, p.LogWriter())
 
-	pid := p.Pid()
-
-	var stats interface{}
-	if cgroups.Mode() == cgroups.Unified {
-		g, err := cgroupsv2.PidGroupPath(int(pid))
-		if err != nil {
-			return nil, err
-		}
-		cg, err := cgroupsv2.LoadManager("/sys/fs/cgroup", g)
-		if err != nil {
-			return nil, err
-		}
-		m, err := cg.Stat()
-		if err != nil {
-			return nil, err
-		}
-		stats = m
-	} else {
-		cg, err := cgroups.Load(cgroups.V1, cgroups.PidPath(int(pid)))
-		if err != nil {
-			return nil, err
-		}
-		m, err := cg.Stat(cgroups.IgnoreNotExist)
-		if err != nil {
-			return nil, err
-		}
-		stats = m
+	stats, err := p.(*initProcess).Stats(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	data, err := typeurl.MarshalAny(stats)
@@ -407,6 +542,10 @@ func (s *Service) Update(ctx context.Context, r *taskapi.UpdateTaskRequest) (_ *
 
 	ctx = WithShimLog(ctx, p.LogWriter())
 
+	if r.Resources == nil {
+		return nil, fmt.Errorf("no resources provided: %w", errdefs.ErrInvalidArgument)
+	}
+
 	var res specs.LinuxResources
 	if err := json.Unmarshal(r.Resources.Value, &res); err != nil {
 		return nil, err
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/cgroups"
+	"github.com/containerd/containerd/log"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+)
+
+// The shim exposes metrics in the Prometheus text format.
+// We only need a handful of counters and histograms so they are implemented here rather than pulling in the client
+// library.
+
+const metricsPrefix = "containerd_shim_systemd_"
+
+// latencyBuckets are the histogram buckets (in seconds) for systemd operations.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type shimMetrics struct {
+	creates    *counterVec
+	execs      *counterVec
+	kills      *counterVec
+	unitStart  *histogramVec
+	reload     *histogramVec
+	dbusErrors *counterVec
+	reconnects *counterVec
+	timeouts   *counterVec
+	retries    *counterVec
+
+	eventsDropped *counterVec
+	configReloads *counterVec
+}
+
+var metrics = &shimMetrics{
+	creates:    newCounterVec("creates_total", "Number of container creates.", "namespace", "result"),
+	execs:      newCounterVec("execs_total", "Number of exec processes added.", "namespace", "result"),
+	kills:      newCounterVec("kills_total", "Number of kill requests.", "namespace", "result"),
+	unitStart:  newHistogramVec("unit_start_duration_seconds", "Time from queueing a unit start job until it completes.", latencyBuckets, "result"),
+	reload:     newHistogramVec("systemd_reload_duration_seconds", "Time spent in systemd daemon-reload.", latencyBuckets, "result"),
+	dbusErrors: newCounterVec("dbus_errors_total", "Number of failed D-Bus calls to systemd.", "method"),
+	reconnects: newCounterVec("dbus_reconnects_total", "Number of times the connection to systemd was re-established."),
+	timeouts:   newCounterVec("timeouts_total", "Number of operations that ran into their timeout.", "operation"),
+	retries:    newCounterVec("retries_total", "Number of retries of failed operations.", "operation", "reason"),
+
+	eventsDropped: newCounterVec("events_dropped_total", "Number of events dropped because the event queue was full.", "namespace"),
+	configReloads: newCounterVec("config_reloads_total", "Number of reloads of the shim config.", "result"),
+}
+
+// resultLabel is the value for the result label of an operation.
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*labeledValue
+}
+
+type labeledValue struct {
+	labels []string
+	v      float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: metricsPrefix + name, help: help, labels: labels, values: make(map[string]*labeledValue)}
+}
+
+func (c *counterVec) Inc(labels ...string) {
+	key := strings.Join(labels, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v := c.values[key]
+	if v == nil {
+		v = &labeledValue{labels: labels}
+		c.values[key] = v
+	}
+	v.v++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := c.values[k]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, v.labels, "", ""), formatFloat(v.v))
+	}
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: metricsPrefix + name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+}
+
+func (h *histogramVec) Observe(d time.Duration, labels ...string) {
+	key := strings.Join(labels, "\xff")
+	secs := d.Seconds()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v := h.values[key]
+	if v == nil {
+		v = &histogramValue{labels: labels, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+	for i, b := range h.buckets {
+		if secs <= b {
+			v.counts[i]++
+		}
+	}
+	v.count++
+	v.sum += secs
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := h.values[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, v.labels, "le", formatFloat(b)), v.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, v.labels, "le", "+Inf"), v.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, v.labels, "", ""), formatFloat(v.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, v.labels, "", ""), v.count)
+	}
+}
+
+func writeGauge(w io.Writer, name, help string, v float64) {
+	name = metricsPrefix + name
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(v))
+}
+
+// formatLabels formats the label set, extraName/extraValue is appended if set (used for the histogram "le" label).
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, n := range names {
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs = append(pairs, n+`="`+escapeLabel(v)+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// writeMetrics writes all of the shim's metrics in the Prometheus text format.
+func (s *Service) writeMetrics(ctx context.Context, w io.Writer) {
+	metrics.creates.write(w)
+	metrics.execs.write(w)
+	metrics.kills.write(w)
+	metrics.unitStart.write(w)
+	metrics.reload.write(w)
+	metrics.dbusErrors.write(w)
+	metrics.reconnects.write(w)
+	metrics.timeouts.write(w)
+	metrics.retries.write(w)
+	metrics.eventsDropped.write(w)
+	metrics.configReloads.write(w)
+	s.queue.writeMetrics(w)
+
+	var execs int
+	s.processes.Each(func(p Process) {
+		if p, ok := p.(*initProcess); ok {
+			execs += p.execs.Len()
+		}
+	})
+	writeGauge(w, "containers", "Number of containers managed by the shim.", float64(s.processes.Len()))
+	writeGauge(w, "execs", "Number of exec processes managed by the shim.", float64(execs))
+
+	if cgroups.Mode() == cgroups.Unified {
+		s.writePressure(ctx, w)
+	}
+}
+
+// writePressure writes the cgroup pressure (PSI) of each running container.
+func (s *Service) writePressure(ctx context.Context, w io.Writer) {
+	type sample struct {
+		labels []string
+		st     *options.PSIStats
+	}
+	var samples []sample
+	s.processes.Each(func(p Process) {
+		ip, ok := p.(*initProcess)
+		if !ok || ip.Pid() == 0 || ip.ProcessState().Exited() {
+			return
+		}
+		g, err := ip.cgroupV2Path(ctx)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("id", ip.id).Debug("Error getting container cgroup")
+			return
+		}
+		pr, err := cgroupPressure(filepath.Join("/sys/fs/cgroup", g))
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("id", ip.id).Debug("Error reading cgroup pressure")
+			return
+		}
+		for _, r := range []struct {
+			name string
+			data *options.PSIData
+		}{{"cpu", pr.Cpu}, {"memory", pr.Memory}, {"io", pr.Io}} {
+			if r.data == nil {
+				continue
+			}
+			if r.data.Some != nil {
+				samples = append(samples, sample{[]string{ip.ns, ip.id, r.name, "some"}, r.data.Some})
+			}
+			if r.data.Full != nil {
+				samples = append(samples, sample{[]string{ip.ns, ip.id, r.name, "full"}, r.data.Full})
+			}
+		}
+	})
+	if len(samples) == 0 {
+		return
+	}
+
+	labels := []string{"namespace", "id", "resource", "kind"}
+	for _, m := range []struct {
+		name, help, typ string
+		v               func(*options.PSIStats) float64
+	}{
+		{"pressure_avg10", "Percentage of time tasks of the container were stalled on the resource over the last 10 seconds.", "gauge", func(st *options.PSIStats) float64 { return st.Avg10 }},
+		{"pressure_avg60", "Percentage of time tasks of the container were stalled on the resource over the last 60 seconds.", "gauge", func(st *options.PSIStats) float64 { return st.Avg60 }},
+		{"pressure_avg300", "Percentage of time tasks of the container were stalled on the resource over the last 300 seconds.", "gauge", func(st *options.PSIStats) float64 { return st.Avg300 }},
+		{"pressure_stalled_seconds_total", "Total time tasks of the container were stalled on the resource.", "counter", func(st *options.PSIStats) float64 { return float64(st.Total) / 1e6 }},
+	} {
+		name := metricsPrefix + m.name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, m.help, name, m.typ)
+		for _, smp := range samples {
+			fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, smp.labels, "", ""), formatFloat(m.v(smp.st)))
+		}
+	}
+}
+
+// serveMetrics serves the metrics on addr until ctx is cancelled.
+func (s *Service) serveMetrics(ctx context.Context, addr string) error {
+	l, err := listenAddr(addr)
+	if err != nil {
+		return fmt.Errorf("error listening on metrics address: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.writeMetrics(r.Context(), w)
+	})
+
+	serveHTTP(ctx, l, mux)
+	log.G(ctx).WithField("addr", addr).Info("Serving metrics")
+	return nil
+}
+
+// listenAddr listens on addr.
+// Addresses starting with "/" or "unix://" are unix sockets which only the shim's user can connect to, see listenUnix,
+// anything else is a TCP address.
+func listenAddr(addr string) (net.Listener, error) {
+	p, ok := unixAddrPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	return listenUnix(p)
+}
+
+// unixAddrPath returns the socket path of addr if it is a unix socket address.
+func unixAddrPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, "/") && !strings.HasPrefix(addr, "unix://") {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, "unix://"), true
+}
+
+// listenUnix listens on the unix socket p with mode 0600.
+// Changing the mode after listening would leave a moment in which anyone can connect, so the socket is created in a new
+// directory only the shim's user can enter and moved to p once it has its mode.
+func listenUnix(p string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(p), ".listen-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// The listener would remove tmp, which is gone by then, instead of p on close.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	// This replaces a socket left behind by an earlier run.
+	if err := os.Rename(tmp, p); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// serveHTTP serves h on l until ctx is cancelled.
+func serveHTTP(ctx context.Context, l net.Listener, h http.Handler) {
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.G(ctx).WithError(err).WithField("addr", l.Addr()).Error("Error serving http")
+		}
+	}()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "debug.sock")
+	// A socket left behind by an earlier run.
+	if err := os.WriteFile(p, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := listenUnix(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mode %v", fi.Mode())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected only the socket in %s, got %d entries", dir, len(entries))
+	}
+
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := net.Dial("unix", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestValidateDebugAddr(t *testing.T) {
+	for _, addr := range []string{"/run/shim/debug.sock", "unix:///run/shim/debug.sock"} {
+		if err := validateDebugAddr(addr); err != nil {
+			t.Errorf("%s: %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"127.0.0.1:6060", ":6060", "unix://"} {
+		if err := validateDebugAddr(addr); !errdefs.IsInvalidArgument(err) {
+			t.Errorf("%s: expected invalid argument, got %v", addr, err)
+		}
+	}
+}
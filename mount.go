@@ -1,21 +1,309 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/mount"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
 )
 
+// Annotations to tune the overlay rootfs mount.
+const (
+	// overlayVolatileAnnotation mounts the overlay with "volatile", which skips syncing the upper dir.
+	// Data written by the container may be lost if the host crashes, which is fine for most containers since the
+	// container is gone as well in that case.
+	overlayVolatileAnnotation = shimName + ".overlay-volatile"
+	// overlayUserXattrAnnotation mounts the overlay with "userxattr", needed to use overlay in a user namespace.
+	overlayUserXattrAnnotation = shimName + ".overlay-userxattr"
+	// privateMountsAnnotation sets if the rootfs is mounted in a mount namespace of the unit ("true") or in the host's
+	// ("false"), overriding --no-new-namespace and the detection of shared mounts, see privateMounts.
+	privateMountsAnnotation = shimName + ".private-mounts"
+)
+
+// Mount options for idmapped mounts, the value is a list of "<container id>:<host id>:<size>" mappings separated by
+// ";". They are handled by the shim and not passed on to mount(2).
+const (
+	uidMapOption = "uidmap="
+	gidMapOption = "gidmap="
+)
+
+// activeMountsName is the file in the bundle where the mounts of the rootfs are recorded, see cleanupRootfs.
+const activeMountsName = "mounts.active"
+
+// activeMount is a mount made for the container rootfs.
+type activeMount struct {
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	IDMap  bool   `json:"idmap,omitempty"`
+}
+
+// rootfsMounts prepares the rootfs mounts from the create request.
+// Options requested through annotations are added and all mounts are checked against what the kernel supports so we
+// can fail the create instead of failing to start the unit.
+func rootfsMounts(mounts []*types.Mount, annotations map[string]string) ([]*types.Mount, error) {
+	var extra []string
+	for _, a := range []struct {
+		key string
+		opt string
+	}{
+		{overlayVolatileAnnotation, "volatile"},
+		{overlayUserXattrAnnotation, "userxattr"},
+	} {
+		v := annotations[a.key]
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("annotation %s: invalid value %q: %w", a.key, v, errdefs.ErrInvalidArgument)
+		}
+		if b {
+			extra = append(extra, a.opt)
+		}
+	}
+
+	out := make([]*types.Mount, 0, len(mounts))
+	for _, m := range mounts {
+		m := &types.Mount{Type: m.Type, Source: m.Source, Target: m.Target, Options: append([]string(nil), m.Options...)}
+		if m.Type == "overlay" {
+			for _, o := range extra {
+				if !hasOption(m.Options, o) {
+					m.Options = append(m.Options, o)
+				}
+			}
+		}
+		if err := validateMount(m); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// sharedPropagation returns true if the container propagates mounts back to the host, through a shared rootfs or any
+// mount with shared propagation (e.g. Kubernetes' bidirectional mount propagation).
+func sharedPropagation(spec *specs.Spec) bool {
+	if spec.Linux != nil {
+		switch spec.Linux.RootfsPropagation {
+		case "shared", "rshared":
+			return true
+		}
+	}
+	for _, m := range spec.Mounts {
+		if hasOption(m.Options, "shared") || hasOption(m.Options, "rshared") {
+			return true
+		}
+	}
+	return false
+}
+
+// privateMounts returns if the rootfs is mounted in a private mount namespace of the unit, def is the shim's default.
+// Containers with shared mounts are not put in a private namespace by default, the mounts they make would not reach the
+// host.
+func privateMounts(spec *specs.Spec, def bool) (bool, error) {
+	if v := spec.Annotations[privateMountsAnnotation]; v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("annotation %s: invalid value %q: %w", privateMountsAnnotation, v, errdefs.ErrInvalidArgument)
+		}
+		return b, nil
+	}
+	return def && !sharedPropagation(spec), nil
+}
+
+func hasOption(opts []string, o string) bool {
+	for _, v := range opts {
+		if v == o {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMount checks that the kernel supports the mount.
+func validateMount(m *types.Mount) error {
+	if m.Type != "bind" && m.Type != "rbind" {
+		ok, err := filesystemSupported(m.Type)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("filesystem %s is not supported by the kernel: %w", m.Type, errdefs.ErrNotImplemented)
+		}
+	}
+
+	var uidMap, gidMap bool
+	for _, o := range m.Options {
+		switch {
+		case o == chownOption:
+			// Handled by the shim.
+		case strings.HasPrefix(o, uidMapOption):
+			if _, err := parseIDMap(strings.TrimPrefix(o, uidMapOption)); err != nil {
+				return err
+			}
+			uidMap = true
+		case strings.HasPrefix(o, gidMapOption):
+			if _, err := parseIDMap(strings.TrimPrefix(o, gidMapOption)); err != nil {
+				return err
+			}
+			gidMap = true
+		case m.Type == "overlay" && o == "volatile":
+			if err := requireKernel(5, 10, "overlay volatile"); err != nil {
+				return err
+			}
+		case m.Type == "overlay" && o == "userxattr":
+			if err := requireKernel(5, 11, "overlay userxattr"); err != nil {
+				return err
+			}
+		}
+	}
+	if uidMap != gidMap {
+		return fmt.Errorf("idmapped mounts need both uid and gid mappings: %w", errdefs.ErrInvalidArgument)
+	}
+	if uidMap && !hasOption(m.Options, chownOption) {
+		return idmapSupported(m.Type)
+	}
+	return nil
+}
+
+// idmapSupported returns an error if the kernel can't idmap mounts of the filesystem type.
+func idmapSupported(fstype string) error {
+	if fstype == "overlay" {
+		return requireKernel(5, 19, "idmapped overlay mounts")
+	}
+	return requireKernel(5, 12, "idmapped mounts")
+}
+
+var (
+	filesystemsOnce sync.Once
+	filesystems     map[string]bool
+	filesystemsErr  error
+)
+
+// filesystemSupported returns true if the kernel knows about the filesystem type.
+func filesystemSupported(fstype string) (bool, error) {
+	filesystemsOnce.Do(func() {
+		f, err := os.Open("/proc/filesystems")
+		if err != nil {
+			filesystemsErr = fmt.Errorf("error reading supported filesystems: %w", err)
+			return
+		}
+		defer f.Close()
+
+		filesystems = make(map[string]bool)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// Each line is "[nodev]\t<type>"
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 0 {
+				filesystems[fields[len(fields)-1]] = true
+			}
+		}
+		filesystemsErr = scanner.Err()
+	})
+	if filesystemsErr != nil {
+		return false, filesystemsErr
+	}
+	if filesystems[fstype] {
+		return true, nil
+	}
+	// Filesystems in modules that are not loaded yet are not listed, the module is loaded on the first mount.
+	_, err := os.Stat(filepath.Join("/sys/module", fstype))
+	return err == nil, nil
+}
+
+var (
+	kernelOnce  sync.Once
+	kernelMajor int
+	kernelMinor int
+)
+
+// requireKernel returns an error if the running kernel is older than major.minor.
+func requireKernel(major, minor int, feature string) error {
+	kernelOnce.Do(func() {
+		var uts unix.Utsname
+		if err := unix.Uname(&uts); err != nil {
+			return
+		}
+		release := unix.ByteSliceToString(uts.Release[:])
+		fmt.Sscanf(release, "%d.%d", &kernelMajor, &kernelMinor)
+	})
+	if kernelMajor > major || (kernelMajor == major && kernelMinor >= minor) {
+		return nil
+	}
+	return fmt.Errorf("%s require kernel %d.%d or newer, running %d.%d: %w", feature, major, minor, kernelMajor, kernelMinor, errdefs.ErrNotImplemented)
+}
+
+// parseIDMap parses the value of an idmap mount option.
+func parseIDMap(s string) ([]specs.LinuxIDMapping, error) {
+	var ls []specs.LinuxIDMapping
+	for _, m := range strings.Split(s, ";") {
+		var id specs.LinuxIDMapping
+		if n, err := fmt.Sscanf(m, "%d:%d:%d", &id.ContainerID, &id.HostID, &id.Size); err != nil || n != 3 || id.Size == 0 {
+			return nil, fmt.Errorf("invalid id mapping %q: %w", m, errdefs.ErrInvalidArgument)
+		}
+		ls = append(ls, id)
+	}
+	return ls, nil
+}
+
+// splitIDMapOptions removes the idmap options, returning the remaining options and the mappings.
+// chown is set if the ids should be mapped by chowning the files instead of an idmapped mount.
+func splitIDMapOptions(opts []string) (_ []string, uidMap, gidMap []specs.LinuxIDMapping, chown bool, _ error) {
+	var (
+		rest []string
+		err  error
+	)
+	for _, o := range opts {
+		switch {
+		case o == chownOption:
+			chown = true
+		case strings.HasPrefix(o, uidMapOption):
+			uidMap, err = parseIDMap(strings.TrimPrefix(o, uidMapOption))
+		case strings.HasPrefix(o, gidMapOption):
+			gidMap, err = parseIDMap(strings.TrimPrefix(o, gidMapOption))
+		default:
+			rest = append(rest, o)
+		}
+		if err != nil {
+			return nil, nil, nil, false, err
+		}
+	}
+	return rest, uidMap, gidMap, chown, nil
+}
+
 func mountFS(tmounts []*types.Mount, bundle string) (string, error) {
-	var mounts []mount.Mount
+	var (
+		mounts         []mount.Mount
+		uidMap, gidMap []specs.LinuxIDMapping
+		chown          bool
+	)
 	for _, m := range tmounts {
+		opts, uids, gids, c, err := splitIDMapOptions(m.Options)
+		if err != nil {
+			return "", err
+		}
+		if uids != nil {
+			uidMap, gidMap, chown = uids, gids, c
+		}
 		mounts = append(mounts, mount.Mount{
 			Type:    m.Type,
 			Source:  m.Source,
-			Options: m.Options,
+			Options: opts,
 		})
 	}
 
@@ -23,5 +311,117 @@ func mountFS(tmounts []*types.Mount, bundle string) (string, error) {
 	if err := os.Mkdir(rootfs, 0700); err != nil && !os.IsExist(err) {
 		return "", fmt.Errorf("error creating rootfs dir: %w", err)
 	}
-	return rootfs, mount.All(mounts, rootfs)
+
+	var active []activeMount
+	for _, m := range mounts {
+		active = append(active, activeMount{Type: m.Type, Source: m.Source, Target: rootfs})
+	}
+	if uidMap != nil && !chown {
+		active = append(active, activeMount{Type: "idmap", Source: rootfs, Target: rootfs, IDMap: true})
+	}
+	// Recorded before mounting so a partial mount is cleaned up as well.
+	if err := writeActiveMounts(bundle, active); err != nil {
+		return "", err
+	}
+
+	if err := mount.All(mounts, rootfs); err != nil {
+		return "", err
+	}
+	switch {
+	case uidMap == nil:
+	case chown:
+		if err := chownRootfs(rootfs, bundle, uidMap, gidMap); err != nil {
+			mount.UnmountAll(rootfs, 0)
+			return "", err
+		}
+	default:
+		if err := idmapMount(rootfs, uidMap, gidMap); err != nil {
+			mount.UnmountAll(rootfs, 0)
+			return "", fmt.Errorf("error creating idmapped mount: %w", err)
+		}
+	}
+	return rootfs, nil
+}
+
+func writeActiveMounts(bundle string, mounts []activeMount) error {
+	data, err := json.Marshal(mounts)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(bundle, activeMountsName), data, 0600)
+}
+
+func readActiveMounts(bundle string) ([]activeMount, error) {
+	data, err := os.ReadFile(filepath.Join(bundle, activeMountsName))
+	if err != nil {
+		return nil, err
+	}
+	var mounts []activeMount
+	if err := json.Unmarshal(data, &mounts); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// idmapMount stacks an idmapped copy of the mount at target on top of it.
+func idmapMount(target string, uidMap, gidMap []specs.LinuxIDMapping) error {
+	userns, err := usernsFD(uidMap, gidMap)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(userns)
+
+	tree, err := unix.OpenTree(-1, target, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC|unix.AT_RECURSIVE)
+	if err != nil {
+		return fmt.Errorf("open_tree: %w", err)
+	}
+	defer unix.Close(tree)
+
+	attr := &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_IDMAP, Userns_fd: uint64(userns)}
+	if err := unix.MountSetattr(tree, "", unix.AT_EMPTY_PATH|unix.AT_RECURSIVE, attr); err != nil {
+		return fmt.Errorf("mount_setattr: %w", err)
+	}
+	if err := unix.MoveMount(tree, "", -1, target, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+		return fmt.Errorf("move_mount: %w", err)
+	}
+	return nil
+}
+
+// usernsFD returns a file descriptor for a new user namespace with the mappings.
+// The namespace is created for a child process which is traced so it stops before doing anything, we only need it
+// long enough to get a reference to its namespace.
+func usernsFD(uidMap, gidMap []specs.LinuxIDMapping) (int, error) {
+	// Tracing is per thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cmd := exec.Command("/proc/self/exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  unix.CLONE_NEWUSER,
+		UidMappings: sysIDMap(uidMap),
+		GidMappings: sysIDMap(gidMap),
+		Ptrace:      true,
+		Pdeathsig:   syscall.SIGKILL,
+	}
+	if err := cmd.Start(); err != nil {
+		return -1, fmt.Errorf("error creating user namespace: %w", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	fd, err := unix.Open(fmt.Sprintf("/proc/%d/ns/user", cmd.Process.Pid), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("error opening user namespace: %w", err)
+	}
+	return fd, nil
+}
+
+func sysIDMap(ls []specs.LinuxIDMapping) []syscall.SysProcIDMap {
+	out := make([]syscall.SysProcIDMap, 0, len(ls))
+	for _, m := range ls {
+		out = append(out, syscall.SysProcIDMap{ContainerID: int(m.ContainerID), HostID: int(m.HostID), Size: int(m.Size)})
+	}
+	return out
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/coreos/go-systemd/unit"
+	"golang.org/x/sys/unix"
+)
+
+// Annotations for the mount namespace settings of the container unit, see options.CreateOptions.
+// They take precedence over the create options.
+const (
+	mountFlagsAnnotation    = shimName + ".mount-flags"
+	privateTmpAnnotation    = shimName + ".private-tmp"
+	protectSystemAnnotation = shimName + ".protect-system"
+)
+
+// mountPropagationFlags are the values of MountFlags=, as passed to systemd over D-Bus.
+var mountPropagationFlags = map[string]uint64{
+	"shared":  unix.MS_SHARED,
+	"slave":   unix.MS_SLAVE,
+	"private": unix.MS_PRIVATE,
+}
+
+// mountNamespaceAnnotations applies the mount namespace settings from the container annotations to the create options.
+func mountNamespaceAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	if v := annotations[mountFlagsAnnotation]; v != "" {
+		opts.MountFlags = v
+	}
+	if v := annotations[protectSystemAnnotation]; v != "" {
+		opts.ProtectSystem = v
+	}
+	if v := annotations[privateTmpAnnotation]; v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("annotation %s: invalid value %q: %w", privateTmpAnnotation, v, errdefs.ErrInvalidArgument)
+		}
+		opts.PrivateTmp = b
+	}
+	return opts.validateMountNamespace()
+}
+
+func (c *CreateOptions) validateMountNamespace() error {
+	if _, ok := mountPropagationFlags[c.MountFlags]; c.MountFlags != "" && !ok {
+		return fmt.Errorf("invalid mount flags %q: %w", c.MountFlags, errdefs.ErrInvalidArgument)
+	}
+	switch c.ProtectSystem {
+	case "", "no", "yes", "full":
+	case "strict":
+		// The runtime writes to the bundle, its root and the state root, all of which may be anywhere.
+		return fmt.Errorf("protect system %q is not supported: %w", c.ProtectSystem, errdefs.ErrNotImplemented)
+	default:
+		return fmt.Errorf("invalid protect system %q: %w", c.ProtectSystem, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// mountNamespaceSet returns true if any of the mount namespace settings is used.
+func (c *CreateOptions) mountNamespaceSet() bool {
+	return c.MountFlags != "" || c.PrivateTmp || (c.ProtectSystem != "" && c.ProtectSystem != "no")
+}
+
+// checkMountNamespace returns an error if the mount namespace settings are used while the rootfs is mounted in the
+// host's mount namespace by ExecStartPre. The settings would give each command of the unit a mount namespace of its
+// own and the runtime would not see the rootfs.
+func (c *CreateOptions) checkMountNamespace(hostRootfs bool) error {
+	if hostRootfs && c.mountNamespaceSet() {
+		return fmt.Errorf("mount flags, private tmp and protect system need the rootfs in a private mount namespace of the unit: %w", errdefs.ErrFailedPrecondition)
+	}
+	return nil
+}
+
+// mountNamespaceOptions returns the unit options for the mount namespace settings.
+func (p *initProcess) mountNamespaceOptions() []*unit.UnitOption {
+	const svc = "Service"
+
+	var opts []*unit.UnitOption
+	if p.opts.MountFlags != "" {
+		opts = append(opts, unit.NewUnitOption(svc, "MountFlags", p.opts.MountFlags))
+	}
+	if p.opts.PrivateTmp {
+		opts = append(opts, unit.NewUnitOption(svc, "PrivateTmp", "yes"))
+	}
+	if p.opts.ProtectSystem != "" {
+		opts = append(opts, unit.NewUnitOption(svc, "ProtectSystem", p.opts.ProtectSystem))
+	}
+	return opts
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/errdefs"
+	runc "github.com/containerd/go-runc"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// mountOptions returns the rendered unit options that decide the mount namespace of the unit.
+func mountOptions(t *testing.T, p *initProcess) []string {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		t.Skip("systemctl is not installed")
+	}
+	opts, err := p.startOptions([]string{"create"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, o := range opts {
+		switch o.Name {
+		case "MountFlags", "PrivateTmp", "ProtectSystem", "PrivateMounts":
+			out = append(out, o.Name+"="+o.Value)
+		case "ExecStartPre":
+			if strings.Contains(o.Value, " mount ") {
+				out = append(out, o.Name+"=mount")
+			}
+		}
+	}
+	return out
+}
+
+func TestMountNamespaceOptions(t *testing.T) {
+	rootfs := []*types.Mount{{Type: "bind", Source: "/var/lib/rootfs"}}
+	settings := CreateOptions{LogMode: "stdio", MountFlags: "slave", PrivateTmp: true, ProtectSystem: "full"}
+
+	for _, tc := range []struct {
+		name           string
+		opts           CreateOptions
+		rootfs         []*types.Mount
+		noNewNamespace bool
+		want           []string
+	}{
+		{
+			name:   "private mounts",
+			opts:   settings,
+			rootfs: rootfs,
+			want:   []string{"MountFlags=slave", "PrivateTmp=yes", "ProtectSystem=full", "PrivateMounts=yes"},
+		},
+		{
+			name:   "private mounts without settings",
+			opts:   CreateOptions{LogMode: "stdio"},
+			rootfs: rootfs,
+			want:   []string{"PrivateMounts=yes"},
+		},
+		{
+			name:           "host rootfs without settings",
+			opts:           CreateOptions{LogMode: "stdio"},
+			rootfs:         rootfs,
+			noNewNamespace: true,
+			want:           []string{"ExecStartPre=mount"},
+		},
+		{
+			// The rootfs is in the bundle, nothing is mounted for the container.
+			name:           "no rootfs mounts",
+			opts:           settings,
+			noNewNamespace: true,
+			want:           []string{"MountFlags=slave", "PrivateTmp=yes", "ProtectSystem=full"},
+		},
+		{
+			name:   "shared mount flags",
+			opts:   CreateOptions{LogMode: "stdio", MountFlags: "shared", ProtectSystem: "no"},
+			rootfs: rootfs,
+			want:   []string{"MountFlags=shared", "ProtectSystem=no", "PrivateMounts=yes"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &initProcess{
+				process: &process{
+					ns:      "default",
+					id:      "test",
+					root:    t.TempDir(),
+					runc:    &runc.Runc{},
+					runtime: &ociRuntime{Name: "runc"},
+					opts:    tc.opts,
+				},
+				Bundle:         t.TempDir(),
+				Rootfs:         tc.rootfs,
+				noNewNamespace: tc.noNewNamespace,
+			}
+			if got := mountOptions(t, p); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckMountNamespace(t *testing.T) {
+	shared := &specs.Spec{Mounts: []specs.Mount{{Destination: "/data", Type: "bind", Source: "/data", Options: []string{"rbind", "rshared"}}}}
+	sharedPrivate := &specs.Spec{
+		Annotations: map[string]string{privateMountsAnnotation: "true"},
+		Mounts:      shared.Mounts,
+	}
+
+	for _, tc := range []struct {
+		name string
+		spec *specs.Spec
+		// noNewNamespace is the --no-new-namespace flag of the shim.
+		noNewNamespace bool
+		annotations    map[string]string
+		err            error
+	}{
+		{name: "private mounts", spec: &specs.Spec{}, annotations: map[string]string{mountFlagsAnnotation: "private"}},
+		{name: "no settings", spec: shared, noNewNamespace: true},
+		{name: "protect system no", spec: shared, annotations: map[string]string{protectSystemAnnotation: "no"}},
+		{name: "shared mounts", spec: shared, annotations: map[string]string{privateTmpAnnotation: "true"}, err: errdefs.ErrFailedPrecondition},
+		{name: "shared mounts in private namespace", spec: sharedPrivate, annotations: map[string]string{mountFlagsAnnotation: "shared"}},
+		{name: "no new namespace", spec: &specs.Spec{}, noNewNamespace: true, annotations: map[string]string{protectSystemAnnotation: "yes"}, err: errdefs.ErrFailedPrecondition},
+		{name: "invalid mount flags", spec: &specs.Spec{}, annotations: map[string]string{mountFlagsAnnotation: "rshared"}, err: errdefs.ErrInvalidArgument},
+		{name: "invalid private tmp", spec: &specs.Spec{}, annotations: map[string]string{privateTmpAnnotation: "maybe"}, err: errdefs.ErrInvalidArgument},
+		{name: "strict protect system", spec: &specs.Spec{}, annotations: map[string]string{protectSystemAnnotation: "strict"}, err: errdefs.ErrNotImplemented},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// This is the order Create checks them in.
+			err := func() error {
+				private, err := privateMounts(tc.spec, !tc.noNewNamespace)
+				if err != nil {
+					return err
+				}
+				var opts CreateOptions
+				if err := mountNamespaceAnnotations(tc.annotations, &opts); err != nil {
+					return err
+				}
+				return opts.checkMountNamespace(!private)
+			}()
+			if tc.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
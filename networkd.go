@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/coreos/go-systemd/unit"
+	"golang.org/x/sys/unix"
+)
+
+// networkdAnnotation hands the host side of the container's veth interfaces to systemd-networkd, e.g. "true".
+//
+// Once the container is started the shim writes a .network file matching each host interface peered with an interface
+// in the container's network namespace and has networkd reload, so networkd policies (LLDP, traffic control, link
+// settings...) can be applied to container networking. The files are removed when the container is deleted.
+// The network itself is still set up by CNI or whoever created the namespace: by default the files only make networkd
+// manage the links while keeping their configuration.
+//
+// Options of the .network file are set with networkdSettingAnnotationPrefix annotations,
+// e.g. "systemd.network.Network.LLDP=yes" or "systemd.network.Link.MTUBytes=9000".
+const (
+	networkdAnnotation              = shimName + ".networkd"
+	networkdSettingAnnotationPrefix = "systemd.network."
+)
+
+const (
+	networkdDir       = "/run/systemd/network"
+	networkdBusName   = "org.freedesktop.network1"
+	networkdBusPath   = "/org/freedesktop/network1"
+	networkdBusReload = networkdBusName + ".Manager.Reload"
+)
+
+// networkdDefaults are the options of the .network files the settings annotations don't override.
+// Addresses and routes set up by CNI are kept and networkd doesn't add any of its own.
+var networkdDefaults = []*unit.UnitOption{
+	unit.NewUnitOption("Link", "RequiredForOnline", "no"),
+	unit.NewUnitOption("Network", "KeepConfiguration", "yes"),
+	unit.NewUnitOption("Network", "LinkLocalAddressing", "no"),
+	unit.NewUnitOption("Network", "IPv6AcceptRA", "no"),
+}
+
+func networkdAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	if v := annotations[networkdAnnotation]; v != "" {
+		b, err := parseUnitBool(v)
+		if err != nil {
+			return fmt.Errorf("annotation %s: %w", networkdAnnotation, err)
+		}
+		opts.Networkd = b
+	}
+
+	for k, v := range annotations {
+		if !strings.HasPrefix(k, networkdSettingAnnotationPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(k, networkdSettingAnnotationPrefix)
+		i := strings.IndexByte(name, '.')
+		if i <= 0 || i == len(name)-1 || name[:i] == "Match" || strings.ContainsAny(v, "\n\r") {
+			return fmt.Errorf("annotation %s: invalid networkd setting: %w", k, errdefs.ErrInvalidArgument)
+		}
+		if opts.NetworkdSettings == nil {
+			opts.NetworkdSettings = make(map[string]string)
+		}
+		opts.NetworkdSettings[name] = v
+	}
+	if len(opts.NetworkdSettings) > 0 && !opts.Networkd {
+		return fmt.Errorf("networkd settings require annotation %s: %w", networkdAnnotation, errdefs.ErrInvalidArgument)
+	}
+
+	if opts.Networkd && rootless {
+		return fmt.Errorf("annotation %s: networkd is not supported in rootless mode: %w", networkdAnnotation, errdefs.ErrNotImplemented)
+	}
+	return nil
+}
+
+// networkdFilePrefix is the start of the names of the .network files of the container, the host interface follows.
+func (p *initProcess) networkdFilePrefix() string {
+	return "10-" + strings.TrimSuffix(p.Name(), ".service") + "-"
+}
+
+// networkOptions returns the .network file for the host interface.
+func (p *initProcess) networkOptions(ifname string) []*unit.UnitOption {
+	opts := []*unit.UnitOption{
+		unit.NewUnitOption("Match", "Name", ifname),
+	}
+	for _, o := range networkdDefaults {
+		if _, ok := p.opts.NetworkdSettings[o.Section+"."+o.Name]; !ok {
+			opts = append(opts, o)
+		}
+	}
+
+	names := make([]string, 0, len(p.opts.NetworkdSettings))
+	for k := range p.opts.NetworkdSettings {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		i := strings.IndexByte(k, '.')
+		opts = append(opts, unit.NewUnitOption(k[:i], k[i+1:], p.opts.NetworkdSettings[k]))
+	}
+	return opts
+}
+
+// setupNetworkd writes the .network files for the host interfaces of the container and has networkd reload.
+// Containers of a pod share the network namespace of the sandbox, which takes care of it.
+func (p *initProcess) setupNetworkd(ctx context.Context, pid uint32) {
+	if !p.opts.Networkd || p.opts.CRI.Type == criContainerTypeContainer {
+		return
+	}
+	ctx, span := StartSpan(ctx, "InitProcess.SetupNetworkd")
+	defer span.End()
+
+	if err := p.writeNetworkFiles(ctx, pid); err != nil {
+		log.G(ctx).WithError(err).Warn("Error handing container interfaces to networkd")
+	}
+}
+
+func (p *initProcess) writeNetworkFiles(ctx context.Context, pid uint32) error {
+	own, err := os.Readlink("/proc/self/ns/net")
+	if err != nil {
+		return err
+	}
+	nsPath := "/proc/" + strconv.Itoa(int(pid)) + "/ns/net"
+	if ns, err := os.Readlink(nsPath); err != nil {
+		return err
+	} else if ns == own {
+		log.G(ctx).Debug("Container uses the host network, nothing to hand to networkd")
+		return nil
+	}
+
+	peers, err := hostPeers(nsPath)
+	if err != nil {
+		return fmt.Errorf("error listing container interfaces: %w", err)
+	}
+	if err := os.MkdirAll(networkdDir, 0755); err != nil {
+		return err
+	}
+
+	var names []string
+	for _, idx := range peers {
+		iface, err := net.InterfaceByIndex(idx)
+		if err != nil {
+			return err
+		}
+		if err := writeUnitFile(networkdDir, p.networkdFilePrefix()+iface.Name+".network", p.networkOptions(iface.Name)); err != nil {
+			return err
+		}
+		names = append(names, iface.Name)
+	}
+	if len(names) == 0 {
+		log.G(ctx).Debug("Container has no interfaces peered with the host")
+		return nil
+	}
+
+	if err := p.reloadNetworkd(ctx); err != nil {
+		return err
+	}
+	log.G(ctx).WithField("interfaces", names).Debug("Handed container interfaces to networkd")
+	return nil
+}
+
+// cleanupNetworkd removes the .network files of the container.
+func (p *initProcess) cleanupNetworkd(ctx context.Context) {
+	if !p.opts.Networkd {
+		return
+	}
+	files, _ := filepath.Glob(filepath.Join(networkdDir, p.networkdFilePrefix()+"*.network"))
+	if len(files) == 0 {
+		return
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			log.G(ctx).WithError(err).WithField("path", f).Warn("Error removing network file")
+		}
+	}
+	if err := p.reloadNetworkd(ctx); err != nil {
+		log.G(ctx).WithError(err).Warn("Error reloading networkd")
+	}
+}
+
+func (p *initProcess) reloadNetworkd(ctx context.Context) error {
+	ctx, done := withTimeout(ctx, opDBus)
+	if err := done(p.systemd.Bus().Object(networkdBusName, networkdBusPath).CallWithContext(ctx, networkdBusReload, 0).Err); err != nil {
+		return fmt.Errorf("error reloading networkd: %w", err)
+	}
+	return nil
+}
+
+// netLink is a network interface, Peer is the index of the other end of a veth pair in another namespace.
+type netLink struct {
+	Index int
+	Peer  int
+}
+
+// containerLinks returns the interfaces of the network namespace at nsPath.
+func containerLinks(nsPath string) ([]netLink, error) {
+	type result struct {
+		links []netLink
+		err   error
+	}
+	ch := make(chan result, 1)
+	// The goroutine entering the namespace is our own, so a thread that can't get back never runs anything else.
+	go func() {
+		links, err := nsLinks(nsPath)
+		ch <- result{links, err}
+	}()
+	r := <-ch
+	return r.links, r.err
+}
+
+func nsLinks(nsPath string) ([]netLink, error) {
+	// Namespaces are per thread.
+	runtime.LockOSThread()
+	restored := false
+	defer func() {
+		// A thread stuck in the container's namespace goes away with the goroutine instead of being reused.
+		if restored {
+			runtime.UnlockOSThread()
+		}
+	}()
+
+	host, err := unix.Open("/proc/thread-self/ns/net", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		restored = true
+		return nil, err
+	}
+	defer unix.Close(host)
+	target, err := unix.Open(nsPath, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		restored = true
+		return nil, err
+	}
+	defer unix.Close(target)
+
+	if err := unix.Setns(target, unix.CLONE_NEWNET); err != nil {
+		restored = true
+		return nil, fmt.Errorf("error entering network namespace: %w", err)
+	}
+	links, err := netLinks()
+	if unix.Setns(host, unix.CLONE_NEWNET) == nil {
+		restored = true
+	}
+	return links, err
+}
+
+// hostPeers returns the host interfaces that are the other end of the container's veth pairs.
+// An index from the container is only an index in the namespace of the peer, it is checked that the host interface
+// points back at the container interface.
+func hostPeers(nsPath string) ([]int, error) {
+	links, err := containerLinks(nsPath)
+	if err != nil {
+		return nil, err
+	}
+	hostLinks, err := netLinks()
+	if err != nil {
+		return nil, err
+	}
+	peerOf := make(map[int]int, len(hostLinks))
+	for _, l := range hostLinks {
+		peerOf[l.Index] = l.Peer
+	}
+
+	var peers []int
+	for _, l := range links {
+		if l.Peer > 0 && peerOf[l.Peer] == l.Index {
+			peers = append(peers, l.Peer)
+		}
+	}
+	return peers, nil
+}
+
+// netLinks lists the interfaces of the current network namespace.
+func netLinks() ([]netLink, error) {
+	data, err := syscall.NetlinkRIB(syscall.RTM_GETLINK, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []netLink
+	for i := range msgs {
+		if msgs[i].Header.Type != syscall.RTM_NEWLINK || len(msgs[i].Data) < syscall.SizeofIfInfomsg {
+			continue
+		}
+		ifi := (*syscall.IfInfomsg)(unsafe.Pointer(&msgs[i].Data[0]))
+		attrs, err := syscall.ParseNetlinkRouteAttr(&msgs[i])
+		if err != nil {
+			return nil, err
+		}
+		var (
+			peer    uint32
+			otherNS bool
+		)
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case unix.IFLA_LINK:
+				if len(a.Value) >= 4 {
+					peer = *(*uint32)(unsafe.Pointer(&a.Value[0]))
+				}
+			case unix.IFLA_LINK_NETNSID:
+				otherNS = true
+			}
+		}
+		l := netLink{Index: int(ifi.Index)}
+		if otherNS {
+			l.Peer = int(peer)
+		}
+		links = append(links, l)
+	}
+	return links, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// notifyProxyEnv is set on container units with sd_notify enabled.
+	// It holds the host path of the socket the container sends its notifications to.
+	notifyProxyEnv = "NOTIFY_PROXY_SOCKET"
+
+	// containerNotifyDir is where the directory holding the notify socket is mounted in the container.
+	containerNotifyDir = "/run/notify"
+	notifySocketName   = "notify.sock"
+)
+
+// notifyFields are the sd_notify assignments we pass on from the container.
+// Anything else, like MAINPID (which is in the container pid namespace) or fd store requests, is dropped.
+var notifyFields = []string{
+	"READY=",
+	"RELOADING=",
+	"STOPPING=",
+	"STATUS=",
+	"ERRNO=",
+	"WATCHDOG=",
+	"WATCHDOG_USEC=",
+	"EXTEND_TIMEOUT_USEC=",
+	"MONOTONIC_USEC=",
+}
+
+func (p *initProcess) notifySocketPath() string {
+	return filepath.Join(p.Bundle, "notify", notifySocketName)
+}
+
+// setupNotifySocket adds a mount for the notify socket directory to the container spec and points NOTIFY_SOCKET at it.
+// When a watchdog is configured WATCHDOG_USEC is set as well so the container knows how often to send keepalives.
+// The spec is edited as raw json so fields we don't know about are kept as is.
+func setupNotifySocket(bundle string, watchdog time.Duration) error {
+	dir := filepath.Join(bundle, "notify")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	specPath := filepath.Join(bundle, "config.json")
+	fi, err := os.Stat(specPath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(specPath)
+	if err != nil {
+		return err
+	}
+
+	var spec map[string]interface{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return fmt.Errorf("error unmarshalling spec: %w", err)
+	}
+
+	mounts, _ := spec["mounts"].([]interface{})
+	for _, m := range mounts {
+		if mm, ok := m.(map[string]interface{}); ok && mm["destination"] == containerNotifyDir {
+			// Already set up
+			return nil
+		}
+	}
+	spec["mounts"] = append(mounts, map[string]interface{}{
+		"destination": containerNotifyDir,
+		"type":        "bind",
+		"source":      dir,
+		"options":     []string{"bind", "nosuid", "nodev", "noexec"},
+	})
+
+	if proc, ok := spec["process"].(map[string]interface{}); ok {
+		env, _ := proc["env"].([]interface{})
+		filtered := make([]interface{}, 0, len(env)+1)
+		for _, e := range env {
+			if s, ok := e.(string); ok && (strings.HasPrefix(s, "NOTIFY_SOCKET=") || strings.HasPrefix(s, "WATCHDOG_")) {
+				continue
+			}
+			filtered = append(filtered, e)
+		}
+		filtered = append(filtered, "NOTIFY_SOCKET="+filepath.Join(containerNotifyDir, notifySocketName))
+		if watchdog > 0 {
+			filtered = append(filtered, "WATCHDOG_USEC="+watchdogUSec(watchdog))
+		}
+		proc["env"] = filtered
+	}
+
+	data, err = json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(specPath, data, fi.Mode())
+}
+
+// startNotifyProxy binds the notify socket for the container and starts the proxy that forwards notifications to systemd.
+// This must be called before the process is moved out of the unit cgroup, systemd only accepts notifications from
+// processes in the unit.
+func startNotifyProxy(ctx context.Context, bundle, sockPath string) (int, error) {
+	if err := os.Remove(sockPath); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sockPath, Net: "unixgram"})
+	if err != nil {
+		return 0, fmt.Errorf("error creating notify socket: %w", err)
+	}
+	defer l.Close()
+
+	// The container process may not be running as root.
+	if err := os.Chmod(sockPath, 0777); err != nil {
+		return 0, err
+	}
+
+	f, err := l.File()
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	cmd := exec.Command("/proc/self/exe", "--bundle="+bundle, "notify-proxy")
+	cmd.ExtraFiles = []*os.File{f}
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("error starting notify proxy: %w", err)
+	}
+	log.G(ctx).WithField("pid", cmd.Process.Pid).Debug("Started notify proxy")
+	return cmd.Process.Pid, nil
+}
+
+// notifyProxy forwards notifications from the container to systemd.
+// It runs until the container exits, or until the create command exits without a container having been created.
+func notifyProxy(ctx context.Context) error {
+	conn, err := net.FilePacketConn(os.NewFile(3, "notify-socket"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	parent := os.Getppid()
+	pidFile := os.Getenv("PIDFILE")
+	buf := make([]byte, 4096)
+
+	var pid int
+	for {
+		if pid == 0 {
+			pid, _ = readPidFile(pidFile)
+			if pid == 0 && os.Getppid() != parent {
+				return nil
+			}
+		}
+		if pid != 0 && unix.Kill(pid, 0) == unix.ESRCH {
+			return nil
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		if msg := filterNotify(string(buf[:n]), pid); len(msg) > 0 {
+			log.G(ctx).Debugf("Forwarding notification: %s", strings.Join(msg, ", "))
+			sdNotify(ctx, msg...)
+		}
+	}
+}
+
+// filterNotify returns the assignments from a notification that should be passed on to systemd.
+// Since the container can't know its pid on the host, MAINPID is added when the container reports it is ready.
+func filterNotify(msg string, pid int) []string {
+	var (
+		out   []string
+		ready bool
+	)
+	for _, l := range strings.Split(msg, "\n") {
+		for _, f := range notifyFields {
+			if strings.HasPrefix(l, f) {
+				out = append(out, l)
+				if l == "READY=1" {
+					ready = true
+				}
+				break
+			}
+		}
+	}
+	if ready && pid > 0 {
+		out = append(out, notifyMainPID(uint32(pid)))
+	}
+	return out
+}
+
+func readPidFile(p string) (int, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// waitCreated returns a channel that is closed once the container process has been created.
+// With sd_notify enabled the unit is not started until the container reports it is ready, which can only happen after
+// the container is started, so this is used in place of the start job.
+func (p *initProcess) waitCreated(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{})
+	go func() {
+		t := time.NewTicker(50 * time.Millisecond)
+		defer t.Stop()
+		for {
+			var st pState
+			if err := p.readExitState(&st); err == nil && st.Pid > 0 {
+				close(ch)
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return ch
+}
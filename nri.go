@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// defaultNRIConfig is where NRI plugins are configured, NRI is disabled if the file doesn't exist.
+	defaultNRIConfig = "/etc/nri/conf.json"
+	// nriPluginDir is where the plugin binaries are looked up, by the plugin type.
+	nriPluginDir = "/opt/nri/bin"
+	// nriPluginTimeout bounds each plugin invocation, the create request waits for it.
+	nriPluginTimeout = 10 * time.Second
+	// nriVersion is the version of the request format passed to plugins.
+	nriVersion = "0.1"
+	// nriStateCreate is the only stage plugins are invoked in.
+	nriStateCreate = "create"
+
+	// nriCPUsKey, nriMemsKey and nriHugepagesPrefix are the result metadata keys that replace the cpuset and the
+	// hugepage limits in the container spec, e.g. "hugepages.2MB": "1073741824". Unit properties are set with the
+	// keys of the property annotations, e.g. "systemd.property.CPUWeight": "200".
+	nriCPUsKey         = "cpus"
+	nriMemsKey         = "mems"
+	nriHugepagesPrefix = "hugepages."
+)
+
+// nriConfig is the NRI config file.
+// The types below are those of NRI v0.1 (github.com/containerd/nri/types/v1): plugins are binaries named after their
+// type, invoked in order with the request on stdin and their own conf included in it, and write a result to stdout.
+type nriConfig struct {
+	Version string      `json:"version"`
+	Plugins []nriPlugin `json:"plugins"`
+}
+
+type nriPlugin struct {
+	Type string          `json:"type"`
+	Conf json.RawMessage `json:"conf,omitempty"`
+}
+
+// nriRequest is passed to the plugins on stdin.
+// Plugins run before the container is created, so there is no pid yet.
+type nriRequest struct {
+	Version   string            `json:"version"`
+	ID        string            `json:"id"`
+	SandboxID string            `json:"sandboxID,omitempty"`
+	Pid       int               `json:"pid,omitempty"`
+	State     string            `json:"state"`
+	Spec      *nriSpec          `json:"spec"`
+	Labels    map[string]string `json:"labels"`
+	Conf      json.RawMessage   `json:"conf"`
+	// Results are the results of the plugins invoked before.
+	Results []*nriResult `json:"results,omitempty"`
+}
+
+// nriSpec is the part of the container spec passed to plugins.
+type nriSpec struct {
+	Resources   json.RawMessage   `json:"resources"`
+	Namespaces  map[string]string `json:"namespaces"`
+	CgroupsPath string            `json:"cgroupsPath"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// nriResult is what a plugin writes to stdout, the adjustments are taken from its metadata.
+type nriResult struct {
+	Version  string            `json:"version"`
+	Plugin   string            `json:"plugin"`
+	Error    string            `json:"error"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// newNRISpec returns what plugins get to see of the spec.
+func newNRISpec(spec *specs.Spec) (*nriSpec, error) {
+	s := &nriSpec{Namespaces: make(map[string]string), Annotations: spec.Annotations}
+	if spec.Linux == nil {
+		return s, nil
+	}
+	if spec.Linux.Resources != nil {
+		data, err := json.Marshal(spec.Linux.Resources)
+		if err != nil {
+			return nil, err
+		}
+		s.Resources = data
+	}
+	for _, n := range spec.Linux.Namespaces {
+		s.Namespaces[string(n.Type)] = n.Path
+	}
+	s.CgroupsPath = spec.Linux.CgroupsPath
+	return s, nil
+}
+
+// nriAdjustment holds the changes a plugin asks for in its result.
+type nriAdjustment struct {
+	// Properties are set on the container unit like the systemd.property.* annotations.
+	Properties map[string]string
+	// CPUs, Mems and HugepageLimits replace the values in the container spec.
+	CPUs           string
+	Mems           string
+	HugepageLimits []specs.LinuxHugepageLimit
+}
+
+// parseNRIResult reads the adjustments from the metadata of the result.
+func parseNRIResult(res *nriResult) (*nriAdjustment, error) {
+	props, err := propertyAnnotations(res.Metadata, unitPropertyAnnotationPrefix)
+	if err != nil {
+		return nil, err
+	}
+	adj := &nriAdjustment{Properties: props, CPUs: res.Metadata[nriCPUsKey], Mems: res.Metadata[nriMemsKey]}
+
+	var sizes []string
+	for k := range res.Metadata {
+		if strings.HasPrefix(k, nriHugepagesPrefix) {
+			sizes = append(sizes, strings.TrimPrefix(k, nriHugepagesPrefix))
+		}
+	}
+	sort.Strings(sizes)
+	for _, size := range sizes {
+		v := res.Metadata[nriHugepagesPrefix+size]
+		limit, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || size == "" {
+			return nil, fmt.Errorf("metadata %s: invalid value %q: %w", nriHugepagesPrefix+size, v, errdefs.ErrInvalidArgument)
+		}
+		adj.HugepageLimits = append(adj.HugepageLimits, specs.LinuxHugepageLimit{Pagesize: size, Limit: limit})
+	}
+	return adj, nil
+}
+
+func (a *nriAdjustment) changesSpec() bool {
+	return a.CPUs != "" || a.Mems != "" || len(a.HugepageLimits) > 0
+}
+
+// merge adds the spec adjustments of b, which replace those of a.
+func (a *nriAdjustment) merge(b *nriAdjustment) {
+	if b.CPUs != "" {
+		a.CPUs = b.CPUs
+	}
+	if b.Mems != "" {
+		a.Mems = b.Mems
+	}
+	if len(b.HugepageLimits) > 0 {
+		a.HugepageLimits = b.HugepageLimits
+	}
+}
+
+// applySpec applies the spec adjustments to s.
+func (a *nriAdjustment) applySpec(s *specs.Spec) {
+	if !a.changesSpec() {
+		return
+	}
+	if s.Linux == nil {
+		s.Linux = &specs.Linux{}
+	}
+	if s.Linux.Resources == nil {
+		s.Linux.Resources = &specs.LinuxResources{}
+	}
+	r := s.Linux.Resources
+	if a.CPUs != "" || a.Mems != "" {
+		if r.CPU == nil {
+			r.CPU = &specs.LinuxCPU{}
+		}
+		if a.CPUs != "" {
+			r.CPU.Cpus = a.CPUs
+		}
+		if a.Mems != "" {
+			r.CPU.Mems = a.Mems
+		}
+	}
+	if len(a.HugepageLimits) > 0 {
+		r.HugepageLimits = a.HugepageLimits
+	}
+}
+
+// editSpec applies the spec adjustments to the spec in the bundle, see editBundleSpec.
+func (a *nriAdjustment) editSpec(spec map[string]interface{}) error {
+	linux, err := specObject(spec, "linux")
+	if err != nil {
+		return err
+	}
+	r, err := specObject(linux, "resources")
+	if err != nil {
+		return err
+	}
+	if a.CPUs != "" || a.Mems != "" {
+		cpu, err := specObject(r, "cpu")
+		if err != nil {
+			return err
+		}
+		if a.CPUs != "" {
+			cpu["cpus"] = a.CPUs
+		}
+		if a.Mems != "" {
+			cpu["mems"] = a.Mems
+		}
+	}
+	if len(a.HugepageLimits) > 0 {
+		r["hugepageLimits"] = a.HugepageLimits
+	}
+	return nil
+}
+
+// loadNRIConfig reads the NRI config, nil is returned if it doesn't exist or no plugins are configured.
+func loadNRIConfig(p string) (*nriConfig, error) {
+	if p == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading NRI config: %w", err)
+	}
+	var cfg nriConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing NRI config %s: %v: %w", p, err, errdefs.ErrInvalidArgument)
+	}
+	for _, pl := range cfg.Plugins {
+		if pl.Type == "" || filepath.Base(pl.Type) != pl.Type {
+			return nil, fmt.Errorf("invalid NRI plugin type %q in %s: %w", pl.Type, p, errdefs.ErrInvalidArgument)
+		}
+	}
+	if len(cfg.Plugins) == 0 {
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+// nriCreate runs the NRI plugins before the container unit is created.
+// Unit properties are added to opts and spec changes are applied to spec, which has to be the spec the unit is
+// generated from. The spec changes are returned so the caller can write them to the bundle, where the runtime reads
+// the spec from, once the create can go ahead.
+// Plugins see the adjustments of the plugins before them. A failing plugin fails the create.
+func (s *Service) nriCreate(ctx context.Context, ns, id, bundle string, spec *specs.Spec, opts *CreateOptions) (*nriAdjustment, error) {
+	if s.nri == nil {
+		return nil, nil
+	}
+
+	ctx, span := StartSpan(ctx, "NRI.Create")
+	defer span.End()
+
+	full, err := readFullBundleSpec(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		results []*nriResult
+		changes nriAdjustment
+	)
+	for _, pl := range s.nri.Plugins {
+		rspec, err := newNRISpec(full)
+		if err != nil {
+			return nil, err
+		}
+		req := nriRequest{
+			Version:   nriVersion,
+			ID:        id,
+			SandboxID: opts.CRI.SandboxID,
+			State:     nriStateCreate,
+			Spec:      rspec,
+			Labels:    map[string]string{},
+			Conf:      pl.Conf,
+			Results:   results,
+		}
+		res, err := invokeNRIPlugin(ctx, pl.Type, &req)
+		if err != nil {
+			return nil, fmt.Errorf("NRI plugin %s: %w", pl.Type, err)
+		}
+		adj, err := parseNRIResult(res)
+		if err != nil {
+			return nil, fmt.Errorf("NRI plugin %s: %w", pl.Type, err)
+		}
+		results = append(results, res)
+
+		for k, v := range adj.Properties {
+			if opts.Properties == nil {
+				opts.Properties = make(map[string]string)
+			}
+			opts.Properties[k] = v
+		}
+		if adj.changesSpec() {
+			adj.applySpec(full)
+			adj.applySpec(spec)
+			changes.merge(adj)
+		}
+		log.G(ctx).WithField("plugin", pl.Type).WithField("ns", ns).Debug("Applied NRI plugin")
+	}
+
+	if !changes.changesSpec() {
+		return nil, nil
+	}
+	return &changes, nil
+}
+
+// invokeNRIPlugin runs the plugin binary with the request on stdin and decodes its result from stdout.
+func invokeNRIPlugin(ctx context.Context, typ string, req *nriRequest) (*nriResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, nriPluginTimeout)
+	defer cancel()
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, filepath.Join(nriPluginDir, typ), "invoke")
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	res := &nriResult{Plugin: typ}
+	if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+		if err := json.Unmarshal(out, res); err != nil {
+			return nil, fmt.Errorf("invalid output: %w", err)
+		}
+	}
+	if res.Error != "" {
+		return nil, errors.New(res.Error)
+	}
+	return res, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/coreos/go-systemd/unit"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+)
+
+// nullIOAnnotation runs the container without the stdio from containerd, see options.CreateOptions.
+// It takes precedence over the create option.
+const nullIOAnnotation = shimName + ".null-io"
+
+// nullIOAnnotations applies the null io setting from the container annotations to the create options.
+func nullIOAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	v := annotations[nullIOAnnotation]
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("annotation %s: invalid value %q: %w", nullIOAnnotation, v, errdefs.ErrInvalidArgument)
+	}
+	opts.NullIO = b
+	return nil
+}
+
+// noStdio returns true if the process has no stdio at all.
+func (p *process) noStdio() bool {
+	return p.Stdin == "" && p.Stdout == "" && p.Stderr == "" && !p.Terminal && !p.opts.Terminal
+}
+
+// stdioOptions sets the stdio of a unit whose process has none to null, instead of leaving it to the defaults of
+// systemd. With the journald log mode the output goes to the journal, see logOptions. The commands of the unit keep
+// logging their errors to the journal through stderr, they are needed for the start diagnostics.
+func (p *process) stdioOptions() []*unit.UnitOption {
+	const svc = "Service"
+
+	if !p.noStdio() {
+		return nil
+	}
+	opts := []*unit.UnitOption{unit.NewUnitOption(svc, "StandardInput", "null")}
+	if p.logMode() != options.LogMode_JOURNALD {
+		opts = append(opts,
+			unit.NewUnitOption(svc, "StandardOutput", "null"),
+			unit.NewUnitOption(svc, "StandardError", "journal"),
+		)
+	}
+	return opts
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/cgroups"
+	cgroupsv2 "github.com/containerd/cgroups/v2"
+	eventsapi "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/coreos/go-systemd/unit"
+)
+
+// Annotations to control how out of memory situations are handled, see options.CreateOptions.
+// They take precedence over the create options.
+const (
+	oomPolicyAnnotation                     = shimName + ".oom-policy"
+	managedOOMMemoryPressureAnnotation      = shimName + ".managed-oom-memory-pressure"
+	managedOOMMemoryPressureLimitAnnotation = shimName + ".managed-oom-memory-pressure-limit"
+	managedOOMSwapAnnotation                = shimName + ".managed-oom-swap"
+
+	// serviceResultOOMKill is the value of $SERVICE_RESULT when the unit was stopped because of an oom kill, by the
+	// kernel or by systemd-oomd.
+	serviceResultOOMKill = "oom-kill"
+)
+
+// oomAnnotations applies the oom settings from the container annotations to the create options.
+func oomAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	for _, a := range []struct {
+		key string
+		v   *string
+	}{
+		{oomPolicyAnnotation, &opts.OOMPolicy},
+		{managedOOMMemoryPressureAnnotation, &opts.ManagedOOMMemoryPressure},
+		{managedOOMMemoryPressureLimitAnnotation, &opts.ManagedOOMMemoryPressureLimit},
+		{managedOOMSwapAnnotation, &opts.ManagedOOMSwap},
+	} {
+		if v := annotations[a.key]; v != "" {
+			*a.v = v
+		}
+	}
+	return opts.validateOOM()
+}
+
+func (c *CreateOptions) validateOOM() error {
+	switch c.OOMPolicy {
+	case "", "continue", "stop", "kill":
+	default:
+		return fmt.Errorf("invalid oom policy %q: %w", c.OOMPolicy, errdefs.ErrInvalidArgument)
+	}
+	for _, v := range []string{c.ManagedOOMMemoryPressure, c.ManagedOOMSwap} {
+		switch v {
+		case "", "auto", "kill":
+		default:
+			return fmt.Errorf("invalid managed oom mode %q: %w", v, errdefs.ErrInvalidArgument)
+		}
+	}
+	if c.ManagedOOMMemoryPressureLimit != "" {
+		if _, err := parsePercent(c.ManagedOOMMemoryPressureLimit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parsePercent parses a percentage between 0% and 100%, e.g. "60%" or "12.5%".
+func parsePercent(s string) (float64, error) {
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || !strings.HasSuffix(s, "%") || pct < 0 || pct > 100 {
+		return 0, fmt.Errorf("invalid percentage %q: %w", s, errdefs.ErrInvalidArgument)
+	}
+	return pct, nil
+}
+
+// oomOptions returns the unit options that control how out of memory situations are handled.
+// The managed oom settings only tell systemd-oomd to monitor the unit, it has to be running for them to do anything.
+func (p *initProcess) oomOptions() []*unit.UnitOption {
+	const svc = "Service"
+
+	var opts []*unit.UnitOption
+	if p.opts.OOMPolicy != "" {
+		opts = append(opts, unit.NewUnitOption(svc, "OOMPolicy", p.opts.OOMPolicy))
+	}
+	if p.opts.ManagedOOMMemoryPressure != "" {
+		opts = append(opts, unit.NewUnitOption(svc, "ManagedOOMMemoryPressure", p.opts.ManagedOOMMemoryPressure))
+	}
+	if p.opts.ManagedOOMMemoryPressureLimit != "" {
+		opts = append(opts, unit.NewUnitOption(svc, "ManagedOOMMemoryPressureLimit", p.opts.ManagedOOMMemoryPressureLimit))
+	}
+	if p.opts.ManagedOOMSwap != "" {
+		opts = append(opts, unit.NewUnitOption(svc, "ManagedOOMSwap", p.opts.ManagedOOMSwap))
+	}
+	return opts
+}
+
+// memoryEventsPath returns the file the kernel reports oom kills in for the container's cgroup.
+// The path is looked up from the container pid and cached, since once the container has exited we can no longer resolve it
+// but the cgroup is still around until the container is deleted.
+func (p *initProcess) memoryEventsPath() (string, error) {
+	p.mu.Lock()
+	cached := p.oomEventsPath
+	p.mu.Unlock()
+	if cached != "" {
+		return cached, nil
+	}
+
+	pid := int(p.Pid())
+	if pid == 0 || p.ProcessState().Exited() {
+		return "", fmt.Errorf("container is not running")
+	}
+
+	var f string
+	if cgroups.Mode() == cgroups.Unified {
+		g, err := cgroupsv2.PidGroupPath(pid)
+		if err != nil {
+			return "", err
+		}
+		f = filepath.Join("/sys/fs/cgroup", g, "memory.events")
+	} else {
+		g, err := cgroups.PidPath(pid)(cgroups.Memory)
+		if err != nil {
+			return "", err
+		}
+		f = filepath.Join("/sys/fs/cgroup/memory", g, "memory.oom_control")
+	}
+
+	p.mu.Lock()
+	p.oomEventsPath = f
+	p.mu.Unlock()
+	return f, nil
+}
+
+// readOOMKills reads the "oom_kill" counter from a memory.events or memory.oom_control file.
+func readOOMKills(f string) (uint64, error) {
+	fd, err := os.Open(f)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "oom_kill" {
+			continue
+		}
+		return strconv.ParseUint(fields[1], 10, 64)
+	}
+	return 0, scanner.Err()
+}
+
+// checkOOM sends a TaskOOM event if any process in the container was killed by the oom killer since the last check.
+// Returns true if the event was sent.
+func (p *initProcess) checkOOM(ctx context.Context) bool {
+	f, err := p.memoryEventsPath()
+	if err != nil {
+		log.G(ctx).WithError(err).Debug("Could not determine container memory cgroup")
+		return false
+	}
+
+	n, err := readOOMKills(f)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.G(ctx).WithError(err).Debug("Error reading oom kill count")
+		}
+		return false
+	}
+
+	p.mu.Lock()
+	last := p.oomKills
+	p.oomKills = n
+	p.mu.Unlock()
+
+	if n > last {
+		log.G(ctx).WithField("count", n-last).Info("Container processes were oom killed")
+		p.sendEvent(ctx, p.ns, &eventsapi.TaskOOM{ContainerID: p.id})
+		return true
+	}
+	return false
+}
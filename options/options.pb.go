@@ -4,6 +4,7 @@
 package options
 
 import (
+	encoding_binary "encoding/binary"
 	fmt "fmt"
 	proto "github.com/golang/protobuf/proto"
 	io "io"
@@ -29,6 +30,8 @@ const (
 	LogMode_JOURNALD LogMode = 1
 	LogMode_STDIO    LogMode = 2
 	LogMode_NULL     LogMode = 3
+	// Container output goes to both the journal and the containerd fifos.
+	LogMode_TEE LogMode = 4
 )
 
 var LogMode_name = map[int32]string{
@@ -36,6 +39,7 @@ var LogMode_name = map[int32]string{
 	1: "JOURNALD",
 	2: "STDIO",
 	3: "NULL",
+	4: "TEE",
 }
 
 var LogMode_value = map[string]int32{
@@ -43,6 +47,7 @@ var LogMode_value = map[string]int32{
 	"JOURNALD": 1,
 	"STDIO":    2,
 	"NULL":     3,
+	"TEE":      4,
 }
 
 func (x LogMode) String() string {
@@ -53,9 +58,115 @@ func (LogMode) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor_35d5cde8839f0fbc, []int{0}
 }
 
+type UnitMode int32
+
+const (
+	UnitMode_UNIT_MODE_DEFAULT UnitMode = 0
+	// Write unit files to /run/systemd/system and reload systemd.
+	UnitMode_UNIT_MODE_FILE UnitMode = 1
+	// Create transient units over D-Bus, no unit files or reloads needed.
+	UnitMode_UNIT_MODE_TRANSIENT UnitMode = 2
+)
+
+var UnitMode_name = map[int32]string{
+	0: "UNIT_MODE_DEFAULT",
+	1: "UNIT_MODE_FILE",
+	2: "UNIT_MODE_TRANSIENT",
+}
+
+var UnitMode_value = map[string]int32{
+	"UNIT_MODE_DEFAULT":   0,
+	"UNIT_MODE_FILE":      1,
+	"UNIT_MODE_TRANSIENT": 2,
+}
+
+func (x UnitMode) String() string {
+	return proto.EnumName(UnitMode_name, int32(x))
+}
+
+func (UnitMode) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_35d5cde8839f0fbc, []int{1}
+}
+
 type CreateOptions struct {
-	LogMode              LogMode  `protobuf:"varint,1,opt,name=log_mode,json=logMode,proto3,enum=containerd.systemd.v1.LogMode" json:"log_mode,omitempty"`
-	SdNotifyEnable       bool     `protobuf:"varint,2,opt,name=sd_notify_enable,json=sdNotifyEnable,proto3" json:"sd_notify_enable,omitempty"`
+	LogMode LogMode `protobuf:"varint,1,opt,name=log_mode,json=logMode,proto3,enum=containerd.systemd.v1.LogMode" json:"log_mode,omitempty"`
+	// Let the container signal readiness with sd_notify.
+	// NOTIFY_SOCKET is set in the container and READY, STATUS and WATCHDOG messages are passed on to the container unit.
+	SdNotifyEnable bool     `protobuf:"varint,2,opt,name=sd_notify_enable,json=sdNotifyEnable,proto3" json:"sd_notify_enable,omitempty"`
+	UnitMode       UnitMode `protobuf:"varint,3,opt,name=unit_mode,json=unitMode,proto3,enum=containerd.systemd.v1.UnitMode" json:"unit_mode,omitempty"`
+	// Systemd slice to place the container units in, e.g. "kubepods-burstable.slice".
+	// The slice is created by systemd if it does not exist.
+	Slice string `protobuf:"bytes,4,opt,name=slice,proto3" json:"slice,omitempty"`
+	// Systemd watchdog timeout for the container in seconds, implies sd_notify_enable.
+	// The container must send WATCHDOG=1 within this interval or it is killed by systemd.
+	WatchdogSec uint32 `protobuf:"varint,5,opt,name=watchdog_sec,json=watchdogSec,proto3" json:"watchdog_sec,omitempty"`
+	// How the processes of the container are stopped when the unit is stopped: "control-group", "mixed" or "process".
+	KillMode string `protobuf:"bytes,6,opt,name=kill_mode,json=killMode,proto3" json:"kill_mode,omitempty"`
+	// Signal sent to stop the container, defaults to SIGTERM.
+	KillSignal int32 `protobuf:"varint,7,opt,name=kill_signal,json=killSignal,proto3" json:"kill_signal,omitempty"`
+	// Signal sent when the container has not stopped within timeout_stop_sec, defaults to SIGKILL.
+	FinalKillSignal int32 `protobuf:"varint,8,opt,name=final_kill_signal,json=finalKillSignal,proto3" json:"final_kill_signal,omitempty"`
+	// Time in seconds to wait for the container to stop before sending final_kill_signal.
+	TimeoutStopSec uint32 `protobuf:"varint,9,opt,name=timeout_stop_sec,json=timeoutStopSec,proto3" json:"timeout_stop_sec,omitempty"`
+	// User and group the container unit runs as (User= and Group=), the OCI runtime is run in rootless mode.
+	User  string `protobuf:"bytes,10,opt,name=user,proto3" json:"user,omitempty"`
+	Group string `protobuf:"bytes,11,opt,name=group,proto3" json:"group,omitempty"`
+	// Run the container unit with a dynamically allocated user (DynamicUser=), the OCI runtime is run in rootless mode.
+	DynamicUser bool `protobuf:"varint,12,opt,name=dynamic_user,json=dynamicUser,proto3" json:"dynamic_user,omitempty"`
+	// Run the container without pivot_root (--no-pivot), needed when the rootfs is on a ramdisk.
+	NoPivotRoot bool `protobuf:"varint,13,opt,name=no_pivot_root,json=noPivotRoot,proto3" json:"no_pivot_root,omitempty"`
+	// Owner of the container's stdio fifos, set this when the container runs in a user namespace.
+	IoUid uint32 `protobuf:"varint,14,opt,name=io_uid,json=ioUid,proto3" json:"io_uid,omitempty"`
+	IoGid uint32 `protobuf:"varint,15,opt,name=io_gid,json=ioGid,proto3" json:"io_gid,omitempty"`
+	// Path to the criu binary used for checkpoint and restore.
+	CriuPath string `protobuf:"bytes,16,opt,name=criu_path,json=criuPath,proto3" json:"criu_path,omitempty"`
+	// Root of the OCI runtime's state, the namespace is appended to it.
+	Root string `protobuf:"bytes,17,opt,name=root,proto3" json:"root,omitempty"`
+	// OCI runtime binary to use instead of runc, e.g. "crun".
+	BinaryName string `protobuf:"bytes,18,opt,name=binary_name,json=binaryName,proto3" json:"binary_name,omitempty"`
+	// Let the OCI runtime manage the container cgroup through systemd (--systemd-cgroup).
+	SystemdCgroup bool `protobuf:"varint,19,opt,name=systemd_cgroup,json=systemdCgroup,proto3" json:"systemd_cgroup,omitempty"`
+	// What systemd does when a process of the container is killed by the kernel oom killer (OOMPolicy=):
+	// "continue", "stop" or "kill".
+	OomPolicy string `protobuf:"bytes,20,opt,name=oom_policy,json=oomPolicy,proto3" json:"oom_policy,omitempty"`
+	// Let systemd-oomd kill the container when its memory pressure or swap use is too high
+	// (ManagedOOMMemoryPressure= and ManagedOOMSwap=): "auto" or "kill".
+	ManagedOomMemoryPressure string `protobuf:"bytes,21,opt,name=managed_oom_memory_pressure,json=managedOomMemoryPressure,proto3" json:"managed_oom_memory_pressure,omitempty"`
+	ManagedOomSwap           string `protobuf:"bytes,22,opt,name=managed_oom_swap,json=managedOomSwap,proto3" json:"managed_oom_swap,omitempty"`
+	// Memory pressure above which systemd-oomd kills the container, as a percentage, e.g. "60%".
+	ManagedOomMemoryPressureLimit string `protobuf:"bytes,23,opt,name=managed_oom_memory_pressure_limit,json=managedOomMemoryPressureLimit,proto3" json:"managed_oom_memory_pressure_limit,omitempty"`
+	// Journal rate limit of the container (LogRateLimitIntervalSec= and LogRateLimitBurst=): messages over the burst
+	// within the interval are dropped by journald. Zero keeps the journald defaults.
+	LogRateLimitIntervalSec uint32 `protobuf:"varint,24,opt,name=log_rate_limit_interval_sec,json=logRateLimitIntervalSec,proto3" json:"log_rate_limit_interval_sec,omitempty"`
+	LogRateLimitBurst       uint32 `protobuf:"varint,25,opt,name=log_rate_limit_burst,json=logRateLimitBurst,proto3" json:"log_rate_limit_burst,omitempty"`
+	// Lines of container output the shim copies to the stdio fifos (journald and tee log modes) are cut off after this
+	// many bytes. Zero means no limit.
+	MaxLogLineSize uint32 `protobuf:"varint,26,opt,name=max_log_line_size,json=maxLogLineSize,proto3" json:"max_log_line_size,omitempty"`
+	// Time in seconds the shim waits for a process to exit after sending it SIGTERM (or kill_signal) with the Kill API,
+	// after which it sends SIGKILL. Zero uses the shim's --kill-grace-period, which leaves it to the client by default.
+	KillGracePeriodSec uint32 `protobuf:"varint,27,opt,name=kill_grace_period_sec,json=killGracePeriodSec,proto3" json:"kill_grace_period_sec,omitempty"`
+	// Address (host:port) of the page server of the source of a lazy migration. When set a restored container is
+	// started with lazy pages: the criu lazy-pages daemon runs in a companion unit and fetches the memory pages from the
+	// source as the container touches them.
+	LazyPagesServer string `protobuf:"bytes,28,opt,name=lazy_pages_server,json=lazyPagesServer,proto3" json:"lazy_pages_server,omitempty"`
+	// kill_signal and final_kill_signal by name, e.g. "SIGRTMIN+3", "INT" or a number. They take precedence over the
+	// numbers, which are only right for the architecture they were written for.
+	KillSignalName      string `protobuf:"bytes,29,opt,name=kill_signal_name,json=killSignalName,proto3" json:"kill_signal_name,omitempty"`
+	FinalKillSignalName string `protobuf:"bytes,30,opt,name=final_kill_signal_name,json=finalKillSignalName,proto3" json:"final_kill_signal_name,omitempty"`
+	// Accounting systemd turns on for the container unit: "cpu", "io", "ip" or "all". The counters are added to the
+	// stats, see UnitAccounting.
+	Accounting []string `protobuf:"bytes,31,rep,name=accounting,proto3" json:"accounting,omitempty"`
+	// Mount namespace settings of the container unit (MountFlags=: "shared", "slave" or "private", PrivateTmp= and
+	// ProtectSystem=: "yes", "full" or "no"). They restrict the runtime and the shim's helpers in the unit, the
+	// container has its own mount namespace. They need the rootfs to be mounted in a private mount namespace of the unit.
+	MountFlags    string `protobuf:"bytes,32,opt,name=mount_flags,json=mountFlags,proto3" json:"mount_flags,omitempty"`
+	PrivateTmp    bool   `protobuf:"varint,33,opt,name=private_tmp,json=privateTmp,proto3" json:"private_tmp,omitempty"`
+	ProtectSystem string `protobuf:"bytes,34,opt,name=protect_system,json=protectSystem,proto3" json:"protect_system,omitempty"`
+	// Run the container without any IO, the stdio from containerd is not used. stdin is /dev/null and the output is
+	// discarded, or goes to the journal with the journald log mode. It can't be used with a terminal.
+	NullIo bool `protobuf:"varint,35,opt,name=null_io,json=nullIo,proto3" json:"null_io,omitempty"`
+	// Return Metrics from Stats instead of the cgroup metrics, see Metrics.
+	ExtendedStats        bool     `protobuf:"varint,36,opt,name=extended_stats,json=extendedStats,proto3" json:"extended_stats,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -108,167 +219,5092 @@ func (m *CreateOptions) GetSdNotifyEnable() bool {
 	return false
 }
 
-func init() {
-	proto.RegisterEnum("containerd.systemd.v1.LogMode", LogMode_name, LogMode_value)
-	proto.RegisterType((*CreateOptions)(nil), "containerd.systemd.v1.CreateOptions")
+func (m *CreateOptions) GetUnitMode() UnitMode {
+	if m != nil {
+		return m.UnitMode
+	}
+	return UnitMode_UNIT_MODE_DEFAULT
 }
 
-func init() {
-	proto.RegisterFile("github.com/cpuguy83/containerd-shim-systemd-v1/options/options.proto", fileDescriptor_35d5cde8839f0fbc)
+func (m *CreateOptions) GetSlice() string {
+	if m != nil {
+		return m.Slice
+	}
+	return ""
 }
 
-var fileDescriptor_35d5cde8839f0fbc = []byte{
-	// 283 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x72, 0x49, 0xcf, 0x2c, 0xc9,
-	0x28, 0x4d, 0xd2, 0x4b, 0xce, 0xcf, 0xd5, 0x4f, 0x2e, 0x28, 0x4d, 0x2f, 0xad, 0xb4, 0x30, 0xd6,
-	0x4f, 0xce, 0xcf, 0x2b, 0x49, 0xcc, 0xcc, 0x4b, 0x2d, 0x4a, 0xd1, 0x2d, 0xce, 0xc8, 0xcc, 0xd5,
-	0x2d, 0xae, 0x2c, 0x2e, 0x49, 0xcd, 0x4d, 0xd1, 0x2d, 0x33, 0xd4, 0xcf, 0x2f, 0x28, 0xc9, 0xcc,
-	0xcf, 0x2b, 0x86, 0xd1, 0x7a, 0x05, 0x45, 0xf9, 0x25, 0xf9, 0x42, 0xa2, 0x08, 0x1d, 0x7a, 0x50,
-	0xc5, 0x7a, 0x65, 0x86, 0x52, 0x22, 0xe9, 0xf9, 0xe9, 0xf9, 0x60, 0x15, 0xfa, 0x20, 0x16, 0x44,
-	0xb1, 0x52, 0x09, 0x17, 0xaf, 0x73, 0x51, 0x6a, 0x62, 0x49, 0xaa, 0x3f, 0xc4, 0x0c, 0x21, 0x4b,
-	0x2e, 0x8e, 0x9c, 0xfc, 0xf4, 0xf8, 0xdc, 0xfc, 0x94, 0x54, 0x09, 0x46, 0x05, 0x46, 0x0d, 0x3e,
-	0x23, 0x39, 0x3d, 0xac, 0x06, 0xea, 0xf9, 0xe4, 0xa7, 0xfb, 0xe6, 0xa7, 0xa4, 0x06, 0xb1, 0xe7,
-	0x40, 0x18, 0x42, 0x1a, 0x5c, 0x02, 0xc5, 0x29, 0xf1, 0x79, 0xf9, 0x25, 0x99, 0x69, 0x95, 0xf1,
-	0xa9, 0x79, 0x89, 0x49, 0x39, 0xa9, 0x12, 0x4c, 0x0a, 0x8c, 0x1a, 0x1c, 0x41, 0x7c, 0xc5, 0x29,
-	0x7e, 0x60, 0x61, 0x57, 0xb0, 0xa8, 0x96, 0x25, 0x17, 0x3b, 0x54, 0xb7, 0x10, 0x37, 0x17, 0xbb,
-	0x8b, 0xab, 0x9b, 0x63, 0xa8, 0x4f, 0x88, 0x00, 0x83, 0x10, 0x0f, 0x17, 0x87, 0x97, 0x7f, 0x68,
-	0x90, 0x9f, 0xa3, 0x8f, 0x8b, 0x00, 0xa3, 0x10, 0x27, 0x17, 0x6b, 0x70, 0x88, 0x8b, 0xa7, 0xbf,
-	0x00, 0x93, 0x10, 0x07, 0x17, 0x8b, 0x5f, 0xa8, 0x8f, 0x8f, 0x00, 0xb3, 0x53, 0xc0, 0x89, 0x47,
-	0x72, 0x8c, 0x17, 0x1e, 0xc9, 0x31, 0x3e, 0x78, 0x24, 0xc7, 0x38, 0xe3, 0xb1, 0x1c, 0x43, 0x94,
-	0x1d, 0x79, 0xa1, 0x66, 0x0d, 0xa5, 0x23, 0x18, 0x92, 0xd8, 0xc0, 0x61, 0x61, 0x0c, 0x08, 0x00,
-	0x00, 0xff, 0xff, 0xe4, 0x1f, 0xe4, 0x4f, 0x80, 0x01, 0x00, 0x00,
+func (m *CreateOptions) GetWatchdogSec() uint32 {
+	if m != nil {
+		return m.WatchdogSec
+	}
+	return 0
 }
 
-func (m *CreateOptions) Marshal() (dAtA []byte, err error) {
-	size := m.Size()
-	dAtA = make([]byte, size)
-	n, err := m.MarshalToSizedBuffer(dAtA[:size])
-	if err != nil {
-		return nil, err
+func (m *CreateOptions) GetKillMode() string {
+	if m != nil {
+		return m.KillMode
 	}
-	return dAtA[:n], nil
+	return ""
 }
 
-func (m *CreateOptions) MarshalTo(dAtA []byte) (int, error) {
-	size := m.Size()
-	return m.MarshalToSizedBuffer(dAtA[:size])
+func (m *CreateOptions) GetKillSignal() int32 {
+	if m != nil {
+		return m.KillSignal
+	}
+	return 0
 }
 
-func (m *CreateOptions) MarshalToSizedBuffer(dAtA []byte) (int, error) {
-	i := len(dAtA)
-	_ = i
-	var l int
-	_ = l
-	if m.XXX_unrecognized != nil {
-		i -= len(m.XXX_unrecognized)
-		copy(dAtA[i:], m.XXX_unrecognized)
+func (m *CreateOptions) GetFinalKillSignal() int32 {
+	if m != nil {
+		return m.FinalKillSignal
 	}
-	if m.SdNotifyEnable {
-		i--
-		if m.SdNotifyEnable {
-			dAtA[i] = 1
-		} else {
-			dAtA[i] = 0
-		}
-		i--
-		dAtA[i] = 0x10
+	return 0
+}
+
+func (m *CreateOptions) GetTimeoutStopSec() uint32 {
+	if m != nil {
+		return m.TimeoutStopSec
 	}
-	if m.LogMode != 0 {
-		i = encodeVarintOptions(dAtA, i, uint64(m.LogMode))
-		i--
-		dAtA[i] = 0x8
+	return 0
+}
+
+func (m *CreateOptions) GetUser() string {
+	if m != nil {
+		return m.User
 	}
-	return len(dAtA) - i, nil
+	return ""
 }
 
-func encodeVarintOptions(dAtA []byte, offset int, v uint64) int {
-	offset -= sovOptions(v)
-	base := offset
-	for v >= 1<<7 {
-		dAtA[offset] = uint8(v&0x7f | 0x80)
-		v >>= 7
-		offset++
+func (m *CreateOptions) GetGroup() string {
+	if m != nil {
+		return m.Group
 	}
-	dAtA[offset] = uint8(v)
-	return base
+	return ""
 }
-func (m *CreateOptions) Size() (n int) {
-	if m == nil {
-		return 0
+
+func (m *CreateOptions) GetDynamicUser() bool {
+	if m != nil {
+		return m.DynamicUser
 	}
-	var l int
-	_ = l
-	if m.LogMode != 0 {
-		n += 1 + sovOptions(uint64(m.LogMode))
+	return false
+}
+
+func (m *CreateOptions) GetNoPivotRoot() bool {
+	if m != nil {
+		return m.NoPivotRoot
 	}
-	if m.SdNotifyEnable {
-		n += 2
+	return false
+}
+
+func (m *CreateOptions) GetIoUid() uint32 {
+	if m != nil {
+		return m.IoUid
 	}
-	if m.XXX_unrecognized != nil {
-		n += len(m.XXX_unrecognized)
+	return 0
+}
+
+func (m *CreateOptions) GetIoGid() uint32 {
+	if m != nil {
+		return m.IoGid
 	}
-	return n
+	return 0
 }
 
-func sovOptions(x uint64) (n int) {
-	return (math_bits.Len64(x|1) + 6) / 7
+func (m *CreateOptions) GetCriuPath() string {
+	if m != nil {
+		return m.CriuPath
+	}
+	return ""
 }
-func sozOptions(x uint64) (n int) {
-	return sovOptions(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+
+func (m *CreateOptions) GetRoot() string {
+	if m != nil {
+		return m.Root
+	}
+	return ""
 }
-func (m *CreateOptions) Unmarshal(dAtA []byte) error {
-	l := len(dAtA)
-	iNdEx := 0
-	for iNdEx < l {
-		preIndex := iNdEx
-		var wire uint64
-		for shift := uint(0); ; shift += 7 {
-			if shift >= 64 {
-				return ErrIntOverflowOptions
-			}
-			if iNdEx >= l {
-				return io.ErrUnexpectedEOF
-			}
-			b := dAtA[iNdEx]
-			iNdEx++
-			wire |= uint64(b&0x7F) << shift
-			if b < 0x80 {
-				break
-			}
-		}
-		fieldNum := int32(wire >> 3)
-		wireType := int(wire & 0x7)
-		if wireType == 4 {
-			return fmt.Errorf("proto: CreateOptions: wiretype end group for non-group")
-		}
-		if fieldNum <= 0 {
-			return fmt.Errorf("proto: CreateOptions: illegal tag %d (wire type %d)", fieldNum, wire)
+
+func (m *CreateOptions) GetBinaryName() string {
+	if m != nil {
+		return m.BinaryName
+	}
+	return ""
+}
+
+func (m *CreateOptions) GetSystemdCgroup() bool {
+	if m != nil {
+		return m.SystemdCgroup
+	}
+	return false
+}
+
+func (m *CreateOptions) GetOomPolicy() string {
+	if m != nil {
+		return m.OomPolicy
+	}
+	return ""
+}
+
+func (m *CreateOptions) GetManagedOomMemoryPressure() string {
+	if m != nil {
+		return m.ManagedOomMemoryPressure
+	}
+	return ""
+}
+
+func (m *CreateOptions) GetManagedOomSwap() string {
+	if m != nil {
+		return m.ManagedOomSwap
+	}
+	return ""
+}
+
+func (m *CreateOptions) GetManagedOomMemoryPressureLimit() string {
+	if m != nil {
+		return m.ManagedOomMemoryPressureLimit
+	}
+	return ""
+}
+
+func (m *CreateOptions) GetLogRateLimitIntervalSec() uint32 {
+	if m != nil {
+		return m.LogRateLimitIntervalSec
+	}
+	return 0
+}
+
+func (m *CreateOptions) GetLogRateLimitBurst() uint32 {
+	if m != nil {
+		return m.LogRateLimitBurst
+	}
+	return 0
+}
+
+func (m *CreateOptions) GetMaxLogLineSize() uint32 {
+	if m != nil {
+		return m.MaxLogLineSize
+	}
+	return 0
+}
+
+func (m *CreateOptions) GetKillGracePeriodSec() uint32 {
+	if m != nil {
+		return m.KillGracePeriodSec
+	}
+	return 0
+}
+
+func (m *CreateOptions) GetLazyPagesServer() string {
+	if m != nil {
+		return m.LazyPagesServer
+	}
+	return ""
+}
+
+func (m *CreateOptions) GetKillSignalName() string {
+	if m != nil {
+		return m.KillSignalName
+	}
+	return ""
+}
+
+func (m *CreateOptions) GetFinalKillSignalName() string {
+	if m != nil {
+		return m.FinalKillSignalName
+	}
+	return ""
+}
+
+func (m *CreateOptions) GetAccounting() []string {
+	if m != nil {
+		return m.Accounting
+	}
+	return nil
+}
+
+func (m *CreateOptions) GetMountFlags() string {
+	if m != nil {
+		return m.MountFlags
+	}
+	return ""
+}
+
+func (m *CreateOptions) GetPrivateTmp() bool {
+	if m != nil {
+		return m.PrivateTmp
+	}
+	return false
+}
+
+func (m *CreateOptions) GetProtectSystem() string {
+	if m != nil {
+		return m.ProtectSystem
+	}
+	return ""
+}
+
+func (m *CreateOptions) GetNullIo() bool {
+	if m != nil {
+		return m.NullIo
+	}
+	return false
+}
+
+func (m *CreateOptions) GetExtendedStats() bool {
+	if m != nil {
+		return m.ExtendedStats
+	}
+	return false
+}
+
+// CheckpointOptions can be passed to checkpoint a container instead of the runc shim's checkpoint options.
+type CheckpointOptions struct {
+	// Stop the container after the checkpoint.
+	Exit                bool     `protobuf:"varint,1,opt,name=exit,proto3" json:"exit,omitempty"`
+	OpenTcp             bool     `protobuf:"varint,2,opt,name=open_tcp,json=openTcp,proto3" json:"open_tcp,omitempty"`
+	ExternalUnixSockets bool     `protobuf:"varint,3,opt,name=external_unix_sockets,json=externalUnixSockets,proto3" json:"external_unix_sockets,omitempty"`
+	Terminal            bool     `protobuf:"varint,4,opt,name=terminal,proto3" json:"terminal,omitempty"`
+	FileLocks           bool     `protobuf:"varint,5,opt,name=file_locks,json=fileLocks,proto3" json:"file_locks,omitempty"`
+	EmptyNamespaces     []string `protobuf:"bytes,6,rep,name=empty_namespaces,json=emptyNamespaces,proto3" json:"empty_namespaces,omitempty"`
+	CgroupsMode         string   `protobuf:"bytes,7,opt,name=cgroups_mode,json=cgroupsMode,proto3" json:"cgroups_mode,omitempty"`
+	ImagePath           string   `protobuf:"bytes,8,opt,name=image_path,json=imagePath,proto3" json:"image_path,omitempty"`
+	WorkPath            string   `protobuf:"bytes,9,opt,name=work_path,json=workPath,proto3" json:"work_path,omitempty"`
+	// Only dump the memory of the container and leave it running (runc checkpoint --pre-dump).
+	// For live migration pre-dumps are taken while the container keeps running, each one only with the memory changed
+	// since the one before, the final checkpoint then has little left to dump.
+	PreDump bool `protobuf:"varint,10,opt,name=pre_dump,json=preDump,proto3" json:"pre_dump,omitempty"`
+	// Image of the pre-dump the checkpoint builds on (--parent-path), the checkpoint is a full one without it.
+	ParentPath           string   `protobuf:"bytes,11,opt,name=parent_path,json=parentPath,proto3" json:"parent_path,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CheckpointOptions) Reset()         { *m = CheckpointOptions{} }
+func (m *CheckpointOptions) String() string { return proto.CompactTextString(m) }
+func (*CheckpointOptions) ProtoMessage()    {}
+func (*CheckpointOptions) Descriptor() ([]byte, []int) {
+	return fileDescriptor_35d5cde8839f0fbc, []int{1}
+}
+func (m *CheckpointOptions) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *CheckpointOptions) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_CheckpointOptions.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
 		}
-		switch fieldNum {
-		case 1:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field LogMode", wireType)
-			}
-			m.LogMode = 0
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowOptions
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				m.LogMode |= LogMode(b&0x7F) << shift
-				if b < 0x80 {
-					break
-				}
-			}
-		case 2:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field SdNotifyEnable", wireType)
+		return b[:n], nil
+	}
+}
+func (m *CheckpointOptions) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CheckpointOptions.Merge(m, src)
+}
+func (m *CheckpointOptions) XXX_Size() int {
+	return m.Size()
+}
+func (m *CheckpointOptions) XXX_DiscardUnknown() {
+	xxx_messageInfo_CheckpointOptions.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CheckpointOptions proto.InternalMessageInfo
+
+func (m *CheckpointOptions) GetExit() bool {
+	if m != nil {
+		return m.Exit
+	}
+	return false
+}
+
+func (m *CheckpointOptions) GetOpenTcp() bool {
+	if m != nil {
+		return m.OpenTcp
+	}
+	return false
+}
+
+func (m *CheckpointOptions) GetExternalUnixSockets() bool {
+	if m != nil {
+		return m.ExternalUnixSockets
+	}
+	return false
+}
+
+func (m *CheckpointOptions) GetTerminal() bool {
+	if m != nil {
+		return m.Terminal
+	}
+	return false
+}
+
+func (m *CheckpointOptions) GetFileLocks() bool {
+	if m != nil {
+		return m.FileLocks
+	}
+	return false
+}
+
+func (m *CheckpointOptions) GetEmptyNamespaces() []string {
+	if m != nil {
+		return m.EmptyNamespaces
+	}
+	return nil
+}
+
+func (m *CheckpointOptions) GetCgroupsMode() string {
+	if m != nil {
+		return m.CgroupsMode
+	}
+	return ""
+}
+
+func (m *CheckpointOptions) GetImagePath() string {
+	if m != nil {
+		return m.ImagePath
+	}
+	return ""
+}
+
+func (m *CheckpointOptions) GetWorkPath() string {
+	if m != nil {
+		return m.WorkPath
+	}
+	return ""
+}
+
+func (m *CheckpointOptions) GetPreDump() bool {
+	if m != nil {
+		return m.PreDump
+	}
+	return false
+}
+
+func (m *CheckpointOptions) GetParentPath() string {
+	if m != nil {
+		return m.ParentPath
+	}
+	return ""
+}
+
+// TaskWatchdog is published when systemd kills a container because its watchdog timed out.
+type TaskWatchdog struct {
+	ContainerId          string   `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	Pid                  uint32   `protobuf:"varint,2,opt,name=pid,proto3" json:"pid,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TaskWatchdog) Reset()         { *m = TaskWatchdog{} }
+func (m *TaskWatchdog) String() string { return proto.CompactTextString(m) }
+func (*TaskWatchdog) ProtoMessage()    {}
+func (*TaskWatchdog) Descriptor() ([]byte, []int) {
+	return fileDescriptor_35d5cde8839f0fbc, []int{2}
+}
+func (m *TaskWatchdog) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *TaskWatchdog) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_TaskWatchdog.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *TaskWatchdog) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TaskWatchdog.Merge(m, src)
+}
+func (m *TaskWatchdog) XXX_Size() int {
+	return m.Size()
+}
+func (m *TaskWatchdog) XXX_DiscardUnknown() {
+	xxx_messageInfo_TaskWatchdog.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TaskWatchdog proto.InternalMessageInfo
+
+func (m *TaskWatchdog) GetContainerId() string {
+	if m != nil {
+		return m.ContainerId
+	}
+	return ""
+}
+
+func (m *TaskWatchdog) GetPid() uint32 {
+	if m != nil {
+		return m.Pid
+	}
+	return 0
+}
+
+// TaskRestart is published when systemd restarted a container with a restart policy, after the TaskExit of the
+// process that exited and the TaskStart of the new one.
+type TaskRestart struct {
+	ContainerId string `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	Pid         uint32 `protobuf:"varint,2,opt,name=pid,proto3" json:"pid,omitempty"`
+	// Number of times the container was restarted by systemd.
+	RestartCount         uint32   `protobuf:"varint,3,opt,name=restart_count,json=restartCount,proto3" json:"restart_count,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TaskRestart) Reset()         { *m = TaskRestart{} }
+func (m *TaskRestart) String() string { return proto.CompactTextString(m) }
+func (*TaskRestart) ProtoMessage()    {}
+func (*TaskRestart) Descriptor() ([]byte, []int) {
+	return fileDescriptor_35d5cde8839f0fbc, []int{3}
+}
+func (m *TaskRestart) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *TaskRestart) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_TaskRestart.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *TaskRestart) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TaskRestart.Merge(m, src)
+}
+func (m *TaskRestart) XXX_Size() int {
+	return m.Size()
+}
+func (m *TaskRestart) XXX_DiscardUnknown() {
+	xxx_messageInfo_TaskRestart.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TaskRestart proto.InternalMessageInfo
+
+func (m *TaskRestart) GetContainerId() string {
+	if m != nil {
+		return m.ContainerId
+	}
+	return ""
+}
+
+func (m *TaskRestart) GetPid() uint32 {
+	if m != nil {
+		return m.Pid
+	}
+	return 0
+}
+
+func (m *TaskRestart) GetRestartCount() uint32 {
+	if m != nil {
+		return m.RestartCount
+	}
+	return 0
+}
+
+// TaskStopped is published before the TaskExit of a process that was signaled with the Kill API while it had a kill
+// grace period (kill_grace_period_sec), it tells if the process exited on its own or if the shim sent it SIGKILL once
+// the grace period was over.
+type TaskStopped struct {
+	ContainerId string `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	// ID of the process as in TaskExit, the container ID for the container's own process.
+	Id  string `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Pid uint32 `protobuf:"varint,3,opt,name=pid,proto3" json:"pid,omitempty"`
+	// Set when the process was sent SIGKILL after the grace period.
+	Forced               bool     `protobuf:"varint,4,opt,name=forced,proto3" json:"forced,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TaskStopped) Reset()         { *m = TaskStopped{} }
+func (m *TaskStopped) String() string { return proto.CompactTextString(m) }
+func (*TaskStopped) ProtoMessage()    {}
+func (*TaskStopped) Descriptor() ([]byte, []int) {
+	return fileDescriptor_35d5cde8839f0fbc, []int{4}
+}
+func (m *TaskStopped) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *TaskStopped) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_TaskStopped.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *TaskStopped) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TaskStopped.Merge(m, src)
+}
+func (m *TaskStopped) XXX_Size() int {
+	return m.Size()
+}
+func (m *TaskStopped) XXX_DiscardUnknown() {
+	xxx_messageInfo_TaskStopped.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TaskStopped proto.InternalMessageInfo
+
+func (m *TaskStopped) GetContainerId() string {
+	if m != nil {
+		return m.ContainerId
+	}
+	return ""
+}
+
+func (m *TaskStopped) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *TaskStopped) GetPid() uint32 {
+	if m != nil {
+		return m.Pid
+	}
+	return 0
+}
+
+func (m *TaskStopped) GetForced() bool {
+	if m != nil {
+		return m.Forced
+	}
+	return false
+}
+
+// PSIStats is one line of a cgroup v2 pressure file.
+type PSIStats struct {
+	// Share of time in percent that tasks were stalled, averaged over 10, 60 and 300 seconds.
+	Avg10  float64 `protobuf:"fixed64,1,opt,name=avg10,proto3" json:"avg10,omitempty"`
+	Avg60  float64 `protobuf:"fixed64,2,opt,name=avg60,proto3" json:"avg60,omitempty"`
+	Avg300 float64 `protobuf:"fixed64,3,opt,name=avg300,proto3" json:"avg300,omitempty"`
+	// Total stall time in microseconds.
+	Total                uint64   `protobuf:"varint,4,opt,name=total,proto3" json:"total,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PSIStats) Reset()         { *m = PSIStats{} }
+func (m *PSIStats) String() string { return proto.CompactTextString(m) }
+func (*PSIStats) ProtoMessage()    {}
+func (*PSIStats) Descriptor() ([]byte, []int) {
+	return fileDescriptor_35d5cde8839f0fbc, []int{5}
+}
+func (m *PSIStats) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *PSIStats) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_PSIStats.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *PSIStats) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PSIStats.Merge(m, src)
+}
+func (m *PSIStats) XXX_Size() int {
+	return m.Size()
+}
+func (m *PSIStats) XXX_DiscardUnknown() {
+	xxx_messageInfo_PSIStats.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PSIStats proto.InternalMessageInfo
+
+func (m *PSIStats) GetAvg10() float64 {
+	if m != nil {
+		return m.Avg10
+	}
+	return 0
+}
+
+func (m *PSIStats) GetAvg60() float64 {
+	if m != nil {
+		return m.Avg60
+	}
+	return 0
+}
+
+func (m *PSIStats) GetAvg300() float64 {
+	if m != nil {
+		return m.Avg300
+	}
+	return 0
+}
+
+func (m *PSIStats) GetTotal() uint64 {
+	if m != nil {
+		return m.Total
+	}
+	return 0
+}
+
+// PSIData is the pressure of one resource.
+type PSIData struct {
+	// Some tasks were stalled on the resource.
+	Some *PSIStats `protobuf:"bytes,1,opt,name=some,proto3" json:"some,omitempty"`
+	// All non-idle tasks were stalled on the resource at the same time.
+	Full                 *PSIStats `protobuf:"bytes,2,opt,name=full,proto3" json:"full,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *PSIData) Reset()         { *m = PSIData{} }
+func (m *PSIData) String() string { return proto.CompactTextString(m) }
+func (*PSIData) ProtoMessage()    {}
+func (*PSIData) Descriptor() ([]byte, []int) {
+	return fileDescriptor_35d5cde8839f0fbc, []int{6}
+}
+func (m *PSIData) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *PSIData) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_PSIData.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *PSIData) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PSIData.Merge(m, src)
+}
+func (m *PSIData) XXX_Size() int {
+	return m.Size()
+}
+func (m *PSIData) XXX_DiscardUnknown() {
+	xxx_messageInfo_PSIData.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PSIData proto.InternalMessageInfo
+
+func (m *PSIData) GetSome() *PSIStats {
+	if m != nil {
+		return m.Some
+	}
+	return nil
+}
+
+func (m *PSIData) GetFull() *PSIStats {
+	if m != nil {
+		return m.Full
+	}
+	return nil
+}
+
+// Pressure is the pressure stall information (PSI) of a container on cgroup v2.
+type Pressure struct {
+	Cpu                  *PSIData `protobuf:"bytes,1,opt,name=cpu,proto3" json:"cpu,omitempty"`
+	Memory               *PSIData `protobuf:"bytes,2,opt,name=memory,proto3" json:"memory,omitempty"`
+	Io                   *PSIData `protobuf:"bytes,3,opt,name=io,proto3" json:"io,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Pressure) Reset()         { *m = Pressure{} }
+func (m *Pressure) String() string { return proto.CompactTextString(m) }
+func (*Pressure) ProtoMessage()    {}
+func (*Pressure) Descriptor() ([]byte, []int) {
+	return fileDescriptor_35d5cde8839f0fbc, []int{7}
+}
+func (m *Pressure) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Pressure) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Pressure.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Pressure) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Pressure.Merge(m, src)
+}
+func (m *Pressure) XXX_Size() int {
+	return m.Size()
+}
+func (m *Pressure) XXX_DiscardUnknown() {
+	xxx_messageInfo_Pressure.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Pressure proto.InternalMessageInfo
+
+func (m *Pressure) GetCpu() *PSIData {
+	if m != nil {
+		return m.Cpu
+	}
+	return nil
+}
+
+func (m *Pressure) GetMemory() *PSIData {
+	if m != nil {
+		return m.Memory
+	}
+	return nil
+}
+
+func (m *Pressure) GetIo() *PSIData {
+	if m != nil {
+		return m.Io
+	}
+	return nil
+}
+
+// Metrics are the stats returned by Stats for containers with extended stats, the cgroup metrics together with what
+// the shim knows about the container on top of them. Clients that only decode the cgroup metrics (e.g. the CRI plugin)
+// don't understand them, so they are only returned when extended stats are turned on in the create options or with the
+// io.containerd.systemd.v1.extended-stats annotation.
+type Metrics struct {
+	// type_url and value are the cgroup metrics (io.containerd.cgroups.v1.Metrics or io.containerd.cgroups.v2.Metrics)
+	// as a google.protobuf.Any.
+	TypeUrl string `protobuf:"bytes,1,opt,name=type_url,json=typeUrl,proto3" json:"type_url,omitempty"`
+	Value   []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	// The pressure of the container, unset on cgroup v1 or when the kernel has no PSI.
+	Pressure *Pressure `protobuf:"bytes,3,opt,name=pressure,proto3" json:"pressure,omitempty"`
+	// The metrics of the execs running in a cgroup of their own, they are not part of the cgroup metrics.
+	ExecStats *ExecStats `protobuf:"bytes,4,opt,name=exec_stats,json=execStats,proto3" json:"exec_stats,omitempty"`
+	// The counters systemd keeps for the container unit, unset unless accounting is turned on.
+	Accounting           *UnitAccounting `protobuf:"bytes,5,opt,name=accounting,proto3" json:"accounting,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *Metrics) Reset()         { *m = Metrics{} }
+func (m *Metrics) String() string { return proto.CompactTextString(m) }
+func (*Metrics) ProtoMessage()    {}
+func (*Metrics) Descriptor() ([]byte, []int) {
+	return fileDescriptor_35d5cde8839f0fbc, []int{8}
+}
+func (m *Metrics) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Metrics) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Metrics.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Metrics) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Metrics.Merge(m, src)
+}
+func (m *Metrics) XXX_Size() int {
+	return m.Size()
+}
+func (m *Metrics) XXX_DiscardUnknown() {
+	xxx_messageInfo_Metrics.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Metrics proto.InternalMessageInfo
+
+func (m *Metrics) GetTypeUrl() string {
+	if m != nil {
+		return m.TypeUrl
+	}
+	return ""
+}
+
+func (m *Metrics) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *Metrics) GetPressure() *Pressure {
+	if m != nil {
+		return m.Pressure
+	}
+	return nil
+}
+
+func (m *Metrics) GetExecStats() *ExecStats {
+	if m != nil {
+		return m.ExecStats
+	}
+	return nil
+}
+
+func (m *Metrics) GetAccounting() *UnitAccounting {
+	if m != nil {
+		return m.Accounting
+	}
+	return nil
+}
+
+// ExecStats are the cgroup metrics of the exec processes that run in the cgroup of their own unit.
+type ExecStats struct {
+	Execs                []*ExecMetrics `protobuf:"bytes,1,rep,name=execs,proto3" json:"execs,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *ExecStats) Reset()         { *m = ExecStats{} }
+func (m *ExecStats) String() string { return proto.CompactTextString(m) }
+func (*ExecStats) ProtoMessage()    {}
+func (*ExecStats) Descriptor() ([]byte, []int) {
+	return fileDescriptor_35d5cde8839f0fbc, []int{9}
+}
+func (m *ExecStats) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ExecStats) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ExecStats.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *ExecStats) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ExecStats.Merge(m, src)
+}
+func (m *ExecStats) XXX_Size() int {
+	return m.Size()
+}
+func (m *ExecStats) XXX_DiscardUnknown() {
+	xxx_messageInfo_ExecStats.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ExecStats proto.InternalMessageInfo
+
+func (m *ExecStats) GetExecs() []*ExecMetrics {
+	if m != nil {
+		return m.Execs
+	}
+	return nil
+}
+
+// UnitAccounting are the counters systemd keeps for the container unit with the accounting turned on in the create
+// options or annotation. Counters of accounting that is not turned on, or that systemd doesn't have, are zero.
+type UnitAccounting struct {
+	// CPUAccounting
+	CpuUsageNsec uint64 `protobuf:"varint,1,opt,name=cpu_usage_nsec,json=cpuUsageNsec,proto3" json:"cpu_usage_nsec,omitempty"`
+	// IOAccounting
+	IoReadBytes       uint64 `protobuf:"varint,2,opt,name=io_read_bytes,json=ioReadBytes,proto3" json:"io_read_bytes,omitempty"`
+	IoWriteBytes      uint64 `protobuf:"varint,3,opt,name=io_write_bytes,json=ioWriteBytes,proto3" json:"io_write_bytes,omitempty"`
+	IoReadOperations  uint64 `protobuf:"varint,4,opt,name=io_read_operations,json=ioReadOperations,proto3" json:"io_read_operations,omitempty"`
+	IoWriteOperations uint64 `protobuf:"varint,5,opt,name=io_write_operations,json=ioWriteOperations,proto3" json:"io_write_operations,omitempty"`
+	// IPAccounting, the traffic of all sockets of the unit's processes.
+	IpIngressBytes       uint64   `protobuf:"varint,6,opt,name=ip_ingress_bytes,json=ipIngressBytes,proto3" json:"ip_ingress_bytes,omitempty"`
+	IpEgressBytes        uint64   `protobuf:"varint,7,opt,name=ip_egress_bytes,json=ipEgressBytes,proto3" json:"ip_egress_bytes,omitempty"`
+	IpIngressPackets     uint64   `protobuf:"varint,8,opt,name=ip_ingress_packets,json=ipIngressPackets,proto3" json:"ip_ingress_packets,omitempty"`
+	IpEgressPackets      uint64   `protobuf:"varint,9,opt,name=ip_egress_packets,json=ipEgressPackets,proto3" json:"ip_egress_packets,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UnitAccounting) Reset()         { *m = UnitAccounting{} }
+func (m *UnitAccounting) String() string { return proto.CompactTextString(m) }
+func (*UnitAccounting) ProtoMessage()    {}
+func (*UnitAccounting) Descriptor() ([]byte, []int) {
+	return fileDescriptor_35d5cde8839f0fbc, []int{10}
+}
+func (m *UnitAccounting) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *UnitAccounting) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_UnitAccounting.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *UnitAccounting) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UnitAccounting.Merge(m, src)
+}
+func (m *UnitAccounting) XXX_Size() int {
+	return m.Size()
+}
+func (m *UnitAccounting) XXX_DiscardUnknown() {
+	xxx_messageInfo_UnitAccounting.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UnitAccounting proto.InternalMessageInfo
+
+func (m *UnitAccounting) GetCpuUsageNsec() uint64 {
+	if m != nil {
+		return m.CpuUsageNsec
+	}
+	return 0
+}
+
+func (m *UnitAccounting) GetIoReadBytes() uint64 {
+	if m != nil {
+		return m.IoReadBytes
+	}
+	return 0
+}
+
+func (m *UnitAccounting) GetIoWriteBytes() uint64 {
+	if m != nil {
+		return m.IoWriteBytes
+	}
+	return 0
+}
+
+func (m *UnitAccounting) GetIoReadOperations() uint64 {
+	if m != nil {
+		return m.IoReadOperations
+	}
+	return 0
+}
+
+func (m *UnitAccounting) GetIoWriteOperations() uint64 {
+	if m != nil {
+		return m.IoWriteOperations
+	}
+	return 0
+}
+
+func (m *UnitAccounting) GetIpIngressBytes() uint64 {
+	if m != nil {
+		return m.IpIngressBytes
+	}
+	return 0
+}
+
+func (m *UnitAccounting) GetIpEgressBytes() uint64 {
+	if m != nil {
+		return m.IpEgressBytes
+	}
+	return 0
+}
+
+func (m *UnitAccounting) GetIpIngressPackets() uint64 {
+	if m != nil {
+		return m.IpIngressPackets
+	}
+	return 0
+}
+
+func (m *UnitAccounting) GetIpEgressPackets() uint64 {
+	if m != nil {
+		return m.IpEgressPackets
+	}
+	return 0
+}
+
+// ExecMetrics are the metrics of one exec process.
+type ExecMetrics struct {
+	ExecId string `protobuf:"bytes,1,opt,name=exec_id,json=execId,proto3" json:"exec_id,omitempty"`
+	// type_url and value are the metrics as a google.protobuf.Any, the type is the same as the container's metrics.
+	TypeUrl              string   `protobuf:"bytes,2,opt,name=type_url,json=typeUrl,proto3" json:"type_url,omitempty"`
+	Value                []byte   `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ExecMetrics) Reset()         { *m = ExecMetrics{} }
+func (m *ExecMetrics) String() string { return proto.CompactTextString(m) }
+func (*ExecMetrics) ProtoMessage()    {}
+func (*ExecMetrics) Descriptor() ([]byte, []int) {
+	return fileDescriptor_35d5cde8839f0fbc, []int{11}
+}
+func (m *ExecMetrics) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ExecMetrics) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ExecMetrics.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *ExecMetrics) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ExecMetrics.Merge(m, src)
+}
+func (m *ExecMetrics) XXX_Size() int {
+	return m.Size()
+}
+func (m *ExecMetrics) XXX_DiscardUnknown() {
+	xxx_messageInfo_ExecMetrics.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ExecMetrics proto.InternalMessageInfo
+
+func (m *ExecMetrics) GetExecId() string {
+	if m != nil {
+		return m.ExecId
+	}
+	return ""
+}
+
+func (m *ExecMetrics) GetTypeUrl() string {
+	if m != nil {
+		return m.TypeUrl
+	}
+	return ""
+}
+
+func (m *ExecMetrics) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("containerd.systemd.v1.LogMode", LogMode_name, LogMode_value)
+	proto.RegisterEnum("containerd.systemd.v1.UnitMode", UnitMode_name, UnitMode_value)
+	proto.RegisterType((*CreateOptions)(nil), "containerd.systemd.v1.CreateOptions")
+	proto.RegisterType((*CheckpointOptions)(nil), "containerd.systemd.v1.CheckpointOptions")
+	proto.RegisterType((*TaskWatchdog)(nil), "containerd.systemd.v1.TaskWatchdog")
+	proto.RegisterType((*TaskRestart)(nil), "containerd.systemd.v1.TaskRestart")
+	proto.RegisterType((*TaskStopped)(nil), "containerd.systemd.v1.TaskStopped")
+	proto.RegisterType((*PSIStats)(nil), "containerd.systemd.v1.PSIStats")
+	proto.RegisterType((*PSIData)(nil), "containerd.systemd.v1.PSIData")
+	proto.RegisterType((*Pressure)(nil), "containerd.systemd.v1.Pressure")
+	proto.RegisterType((*Metrics)(nil), "containerd.systemd.v1.Metrics")
+	proto.RegisterType((*ExecStats)(nil), "containerd.systemd.v1.ExecStats")
+	proto.RegisterType((*UnitAccounting)(nil), "containerd.systemd.v1.UnitAccounting")
+	proto.RegisterType((*ExecMetrics)(nil), "containerd.systemd.v1.ExecMetrics")
+}
+
+func init() {
+	proto.RegisterFile("github.com/cpuguy83/containerd-shim-systemd-v1/options/options.proto", fileDescriptor_35d5cde8839f0fbc)
+}
+
+var fileDescriptor_35d5cde8839f0fbc = []byte{
+	// 1726 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x57, 0xef, 0x6e, 0xdb, 0xc8,
+	0x11, 0x3f, 0x49, 0xb6, 0x45, 0x8d, 0x2c, 0x59, 0xde, 0xc4, 0x09, 0x93, 0x34, 0x8e, 0xa3, 0xbb,
+	0x2b, 0x7c, 0x41, 0xe3, 0x38, 0x31, 0x10, 0x5c, 0x71, 0xd7, 0x16, 0x89, 0xad, 0x5c, 0xd5, 0x2a,
+	0xb2, 0x40, 0x59, 0x48, 0xd1, 0x2f, 0x8b, 0x35, 0xb9, 0xa6, 0xb7, 0x26, 0xb9, 0x0b, 0x72, 0x69,
+	0x5b, 0x79, 0x84, 0x7b, 0x82, 0x3e, 0x40, 0x1f, 0xa6, 0x1f, 0xfb, 0x08, 0x45, 0xfa, 0x10, 0xfd,
+	0xd8, 0x62, 0x67, 0x57, 0x7f, 0x72, 0xad, 0x83, 0xe0, 0x3e, 0x69, 0xf7, 0x37, 0xbf, 0xdf, 0xcc,
+	0x70, 0x38, 0x3b, 0x4b, 0xc1, 0x51, 0x2c, 0xf4, 0x79, 0x79, 0xba, 0x17, 0xca, 0xf4, 0x59, 0xa8,
+	0xca, 0xb8, 0x9c, 0x7e, 0x7b, 0xf0, 0x2c, 0x94, 0x99, 0x66, 0x22, 0xe3, 0x79, 0xf4, 0xb4, 0x38,
+	0x17, 0xe9, 0xd3, 0x62, 0x5a, 0x68, 0x9e, 0x46, 0x4f, 0x2f, 0x9f, 0x3f, 0x93, 0x4a, 0x0b, 0x99,
+	0x15, 0xb3, 0xdf, 0x3d, 0x95, 0x4b, 0x2d, 0xc9, 0xd6, 0x42, 0xb1, 0xe7, 0xc8, 0x7b, 0x97, 0xcf,
+	0xef, 0xdf, 0x8e, 0x65, 0x2c, 0x91, 0xf1, 0xcc, 0xac, 0x2c, 0xb9, 0xfb, 0x63, 0x13, 0x5a, 0x87,
+	0x39, 0x67, 0x9a, 0x1f, 0x5b, 0x27, 0xe4, 0xd7, 0xe0, 0x25, 0x32, 0xa6, 0xa9, 0x8c, 0xb8, 0x5f,
+	0xd9, 0xa9, 0xec, 0xb6, 0x5f, 0x6c, 0xef, 0xfd, 0x5f, 0x8f, 0x7b, 0x03, 0x19, 0xbf, 0x95, 0x11,
+	0x0f, 0xea, 0x89, 0x5d, 0x90, 0x5d, 0xe8, 0x14, 0x11, 0xcd, 0xa4, 0x16, 0x67, 0x53, 0xca, 0x33,
+	0x76, 0x9a, 0x70, 0xbf, 0xba, 0x53, 0xd9, 0xf5, 0x82, 0x76, 0x11, 0x0d, 0x11, 0xee, 0x21, 0x4a,
+	0xbe, 0x87, 0x46, 0x99, 0x09, 0x6d, 0xa3, 0xd4, 0x30, 0xca, 0xa3, 0x1b, 0xa2, 0x4c, 0x32, 0xa1,
+	0x31, 0x8c, 0x57, 0xba, 0x15, 0xb9, 0x0d, 0xab, 0x45, 0x22, 0x42, 0xee, 0xaf, 0xec, 0x54, 0x76,
+	0x1b, 0x81, 0xdd, 0x90, 0xc7, 0xb0, 0x7e, 0xc5, 0x74, 0x78, 0x1e, 0xc9, 0x98, 0x16, 0x3c, 0xf4,
+	0x57, 0x77, 0x2a, 0xbb, 0xad, 0xa0, 0x39, 0xc3, 0xc6, 0x3c, 0x24, 0x0f, 0xa0, 0x71, 0x21, 0x92,
+	0xc4, 0x86, 0x5d, 0x43, 0xb1, 0x67, 0x00, 0xf4, 0xfa, 0x08, 0x9a, 0x68, 0x2c, 0x44, 0x9c, 0xb1,
+	0xc4, 0xaf, 0xef, 0x54, 0x76, 0x57, 0x03, 0x30, 0xd0, 0x18, 0x11, 0xf2, 0x04, 0x36, 0xcf, 0x44,
+	0xc6, 0x12, 0xba, 0x4c, 0xf3, 0x90, 0xb6, 0x81, 0x86, 0x3f, 0x2e, 0xb8, 0xbb, 0xd0, 0xd1, 0x22,
+	0xe5, 0xb2, 0xd4, 0xb4, 0xd0, 0x52, 0x61, 0x42, 0x0d, 0x4c, 0xa8, 0xed, 0xf0, 0xb1, 0x96, 0xca,
+	0xe4, 0x44, 0x60, 0xa5, 0x2c, 0x78, 0xee, 0x03, 0xa6, 0x83, 0x6b, 0xf3, 0x80, 0x71, 0x2e, 0x4b,
+	0xe5, 0x37, 0xed, 0x03, 0xe2, 0xc6, 0x3c, 0x60, 0x34, 0xcd, 0x58, 0x2a, 0x42, 0x8a, 0x8a, 0x75,
+	0x2c, 0x6d, 0xd3, 0x61, 0x13, 0x23, 0xec, 0x42, 0x2b, 0x93, 0x54, 0x89, 0x4b, 0xa9, 0x69, 0x2e,
+	0xa5, 0xf6, 0x5b, 0x96, 0x93, 0xc9, 0x91, 0xc1, 0x02, 0x29, 0x35, 0xd9, 0x82, 0x35, 0x21, 0x69,
+	0x29, 0x22, 0xbf, 0x8d, 0x09, 0xad, 0x0a, 0x39, 0x11, 0x91, 0x83, 0x63, 0x11, 0xf9, 0x1b, 0x33,
+	0xf8, 0x07, 0x11, 0x99, 0x92, 0x85, 0xb9, 0x28, 0xa9, 0x62, 0xfa, 0xdc, 0xef, 0xd8, 0x92, 0x19,
+	0x60, 0xc4, 0xf4, 0xb9, 0xc9, 0x1d, 0xa3, 0x6c, 0xda, 0xdc, 0xcd, 0xda, 0x94, 0xf1, 0x54, 0x64,
+	0x2c, 0x9f, 0xd2, 0x8c, 0xa5, 0xdc, 0x27, 0x68, 0x02, 0x0b, 0x0d, 0x59, 0xca, 0xc9, 0xd7, 0xd0,
+	0x76, 0xaf, 0x97, 0x86, 0xf6, 0x29, 0x6f, 0x61, 0x92, 0x2d, 0x87, 0x1e, 0xda, 0xa7, 0x7d, 0x08,
+	0x20, 0x65, 0x4a, 0x95, 0x4c, 0x44, 0x38, 0xf5, 0x6f, 0xa3, 0x9b, 0x86, 0x94, 0xe9, 0x08, 0x01,
+	0xf2, 0x1b, 0x78, 0x90, 0xb2, 0x8c, 0xc5, 0x3c, 0xa2, 0x86, 0x96, 0xf2, 0x54, 0xe6, 0x53, 0xaa,
+	0x72, 0x5e, 0x14, 0x65, 0xce, 0xfd, 0x2d, 0xe4, 0xfb, 0x8e, 0x72, 0x2c, 0xd3, 0xb7, 0x48, 0x18,
+	0x39, 0xbb, 0x79, 0x3f, 0xcb, 0xf2, 0xe2, 0x8a, 0x29, 0xff, 0x0e, 0x6a, 0xda, 0x0b, 0xcd, 0xf8,
+	0x8a, 0x29, 0xf2, 0x7b, 0x78, 0xfc, 0x89, 0x40, 0x34, 0x11, 0xa9, 0xd0, 0xfe, 0x5d, 0x94, 0x3e,
+	0xbc, 0x29, 0xdc, 0xc0, 0x90, 0xc8, 0xf7, 0xf0, 0xc0, 0x9c, 0xac, 0x9c, 0x69, 0x27, 0xa3, 0x22,
+	0xd3, 0x3c, 0xbf, 0x64, 0x09, 0xb6, 0x87, 0x8f, 0x65, 0xbf, 0x9b, 0xc8, 0x38, 0x60, 0xda, 0x4a,
+	0xfa, 0xce, 0x6e, 0xfa, 0xe4, 0x19, 0xdc, 0xfe, 0x89, 0xfa, 0xb4, 0xcc, 0x0b, 0xed, 0xdf, 0x43,
+	0xd9, 0xe6, 0xb2, 0xec, 0xb5, 0x31, 0x90, 0x6f, 0x60, 0x33, 0x65, 0xd7, 0xd4, 0x88, 0x12, 0x91,
+	0x71, 0x5a, 0x88, 0xf7, 0xdc, 0xbf, 0x6f, 0x7b, 0x30, 0x65, 0xd7, 0x03, 0x19, 0x0f, 0x44, 0xc6,
+	0xc7, 0xe2, 0x3d, 0x27, 0xcf, 0x61, 0x0b, 0x7b, 0x3a, 0xce, 0x59, 0xc8, 0xa9, 0xe2, 0xb9, 0x90,
+	0x11, 0xe6, 0xf4, 0x00, 0xe9, 0xc4, 0x18, 0x7f, 0x30, 0xb6, 0x11, 0x9a, 0x4c, 0x3a, 0x4f, 0x60,
+	0x33, 0x61, 0xef, 0xa7, 0x54, 0xb1, 0x98, 0x17, 0xb4, 0xe0, 0xf9, 0x25, 0xcf, 0xfd, 0x5f, 0x60,
+	0x19, 0x36, 0x8c, 0x61, 0x64, 0xf0, 0x31, 0xc2, 0xa6, 0xd8, 0x4b, 0x47, 0xc6, 0xf6, 0xc5, 0x43,
+	0x5b, 0xec, 0xc5, 0xf1, 0xc2, 0xde, 0x38, 0x80, 0x3b, 0xff, 0x73, 0xc4, 0x2c, 0x7f, 0x1b, 0xf9,
+	0xb7, 0x7e, 0x72, 0xce, 0x50, 0xb4, 0x0d, 0xc0, 0xc2, 0x50, 0x96, 0x99, 0x16, 0x59, 0xec, 0x3f,
+	0xda, 0xa9, 0x99, 0x86, 0x5b, 0x20, 0xa6, 0x23, 0x53, 0xb3, 0xa6, 0x67, 0x09, 0x8b, 0x0b, 0x7f,
+	0xc7, 0x76, 0x24, 0x42, 0x6f, 0x0c, 0x62, 0x08, 0x2a, 0x17, 0x97, 0xa6, 0xb2, 0x3a, 0x55, 0xfe,
+	0x63, 0x6c, 0x47, 0x70, 0xd0, 0x49, 0xaa, 0x4c, 0xcb, 0x9a, 0x71, 0xc9, 0x43, 0x4d, 0x6d, 0x93,
+	0xfa, 0x5d, 0x74, 0xd2, 0x72, 0xe8, 0x18, 0x41, 0x72, 0x17, 0xea, 0x59, 0x99, 0x24, 0x54, 0x48,
+	0xff, 0x4b, 0xf4, 0xb1, 0x66, 0xb6, 0x7d, 0x69, 0xf4, 0xfc, 0x5a, 0xf3, 0x2c, 0xe2, 0x11, 0x2d,
+	0x34, 0xd3, 0x85, 0xff, 0x95, 0x6d, 0xf9, 0x19, 0x3a, 0x36, 0x60, 0xf7, 0xdf, 0x55, 0xd8, 0x3c,
+	0x3c, 0xe7, 0xe1, 0x85, 0x92, 0x22, 0xd3, 0xb3, 0x81, 0x4c, 0x60, 0x85, 0x5f, 0x0b, 0x8d, 0xc3,
+	0xd8, 0x0b, 0x70, 0x4d, 0xee, 0x81, 0x27, 0x15, 0xcf, 0xa8, 0x0e, 0x95, 0x9b, 0xb0, 0x75, 0xb3,
+	0x3f, 0x09, 0x15, 0x79, 0x01, 0x5b, 0xc6, 0x6b, 0x6e, 0x2a, 0x57, 0x66, 0xe2, 0x9a, 0x16, 0x32,
+	0xbc, 0xe0, 0xba, 0xc0, 0x31, 0xeb, 0x05, 0xb7, 0x66, 0xc6, 0x49, 0x26, 0xae, 0xc7, 0xd6, 0x44,
+	0xee, 0x83, 0xa7, 0x79, 0x9e, 0x9a, 0xda, 0xe2, 0x4c, 0xf5, 0x82, 0xf9, 0xde, 0x9c, 0xc3, 0x33,
+	0x91, 0x70, 0x9a, 0xc8, 0xf0, 0xa2, 0xc0, 0xa1, 0xea, 0x05, 0x0d, 0x83, 0x0c, 0x0c, 0x40, 0xbe,
+	0x81, 0x0e, 0x4f, 0x95, 0xb6, 0xa7, 0xbd, 0x50, 0x2c, 0xe4, 0x85, 0xbf, 0x86, 0xaf, 0x60, 0x03,
+	0xf1, 0xe1, 0x1c, 0x36, 0xf3, 0xcb, 0x1e, 0xf8, 0xc2, 0x0e, 0xe0, 0x3a, 0xd6, 0xb0, 0xe9, 0x30,
+	0x9c, 0xc1, 0x0f, 0x01, 0x44, 0xca, 0x62, 0x6e, 0xc7, 0x8d, 0x67, 0x0f, 0x3d, 0x22, 0x38, 0x6f,
+	0x1e, 0x40, 0xe3, 0x4a, 0xe6, 0x17, 0xd6, 0xda, 0xb0, 0xc3, 0xc8, 0x00, 0x68, 0xbc, 0x07, 0x9e,
+	0xca, 0x39, 0x8d, 0xca, 0x54, 0xe1, 0x30, 0xf5, 0x82, 0xba, 0xca, 0xf9, 0x51, 0x99, 0x2a, 0x7c,
+	0xc1, 0x2c, 0xe7, 0x99, 0xb6, 0x4a, 0x3b, 0x55, 0xc1, 0x42, 0x46, 0xdb, 0x3d, 0x84, 0xf5, 0x13,
+	0x56, 0x5c, 0xbc, 0x73, 0x77, 0x05, 0xa6, 0x3a, 0xbb, 0x8d, 0xa8, 0x88, 0xfc, 0x8a, 0x4b, 0x75,
+	0x86, 0xf5, 0x23, 0xd2, 0x81, 0x9a, 0x12, 0x11, 0x56, 0xbf, 0x15, 0x98, 0x65, 0x37, 0x86, 0xa6,
+	0x71, 0x12, 0xf0, 0x42, 0xb3, 0x5c, 0xff, 0x2c, 0x1f, 0xe4, 0x4b, 0x68, 0xe5, 0x56, 0x4f, 0xb1,
+	0x7f, 0xf1, 0xad, 0xb5, 0x82, 0x75, 0x07, 0x1e, 0x1a, 0xac, 0xfb, 0x17, 0x1b, 0xc8, 0xdc, 0x20,
+	0x8a, 0x47, 0x9f, 0x13, 0xa8, 0x0d, 0x55, 0x17, 0xa7, 0x11, 0x54, 0xc5, 0x3c, 0x70, 0x6d, 0x11,
+	0xf8, 0x0e, 0xac, 0x9d, 0xc9, 0x3c, 0xe4, 0x91, 0x6b, 0x00, 0xb7, 0xeb, 0x46, 0xe0, 0x8d, 0xc6,
+	0x7d, 0xec, 0x4f, 0x73, 0x2d, 0xb1, 0xcb, 0xf8, 0xf9, 0x3e, 0x46, 0xa8, 0x04, 0x76, 0xe3, 0xd0,
+	0x97, 0xfb, 0x7e, 0x75, 0x8e, 0xbe, 0xdc, 0x37, 0xfe, 0xd8, 0x65, 0x7c, 0xb0, 0xbf, 0x8f, 0x41,
+	0x2a, 0x81, 0xdb, 0x19, 0xb6, 0x96, 0xda, 0xf5, 0xd9, 0x4a, 0x60, 0x37, 0xdd, 0x02, 0xea, 0xa3,
+	0x71, 0xff, 0x88, 0x69, 0x46, 0x0e, 0x60, 0xa5, 0x90, 0xa9, 0xfd, 0xf6, 0x68, 0xde, 0xf8, 0x55,
+	0x30, 0xcb, 0x29, 0x40, 0xb2, 0x11, 0x9d, 0x95, 0x49, 0xe2, 0x57, 0x3f, 0x53, 0x64, 0xc8, 0xdd,
+	0xbf, 0x55, 0xc0, 0x9b, 0x5f, 0x08, 0xfb, 0x50, 0x0b, 0x55, 0xe9, 0xa2, 0x6e, 0xdf, 0xec, 0xc0,
+	0xe4, 0x18, 0x18, 0x2a, 0x79, 0x09, 0x6b, 0xf6, 0x32, 0xf0, 0xab, 0x9f, 0x25, 0x72, 0x6c, 0xb2,
+	0x07, 0x55, 0x21, 0xfd, 0xda, 0x67, 0x69, 0xaa, 0x42, 0x76, 0xff, 0x53, 0x81, 0xfa, 0x5b, 0xae,
+	0x73, 0x11, 0x16, 0xa6, 0xc7, 0xf5, 0x54, 0x71, 0x5a, 0xe6, 0x89, 0x7b, 0xcd, 0x75, 0xb3, 0x9f,
+	0xe4, 0x89, 0x29, 0xec, 0x25, 0x4b, 0x4a, 0xfb, 0xc5, 0xb5, 0x1e, 0xd8, 0x0d, 0xf9, 0x0e, 0x0f,
+	0x85, 0xbd, 0x13, 0x6b, 0x9f, 0x2e, 0x8e, 0xa3, 0x05, 0x73, 0x01, 0xf9, 0x1d, 0x00, 0xbf, 0xe6,
+	0xa1, 0x1b, 0x59, 0x2b, 0x28, 0xdf, 0xb9, 0x41, 0xde, 0xbb, 0xe6, 0xa1, 0x2d, 0x6e, 0x83, 0xcf,
+	0x96, 0xa4, 0xf7, 0xd1, 0x64, 0x5e, 0x45, 0x07, 0x5f, 0x7f, 0xe2, 0x3b, 0xef, 0xd5, 0x9c, 0xbc,
+	0x3c, 0xc0, 0xbb, 0x3d, 0x68, 0xcc, 0xdd, 0x93, 0x6f, 0x61, 0xd5, 0x04, 0x28, 0xfc, 0xca, 0x4e,
+	0x6d, 0xb7, 0xf9, 0xa2, 0xfb, 0x89, 0x7c, 0x5c, 0xd5, 0x02, 0x2b, 0xe8, 0xfe, 0x58, 0x83, 0xf6,
+	0xc7, 0x51, 0xc8, 0x57, 0xd0, 0x0e, 0x55, 0x49, 0xcb, 0xc2, 0xcc, 0x9c, 0xcc, 0xdc, 0x78, 0x15,
+	0x6c, 0xcb, 0xf5, 0x50, 0x95, 0x13, 0x03, 0x0e, 0x0b, 0x1e, 0x9a, 0xaf, 0x2a, 0x21, 0x69, 0xce,
+	0x59, 0x44, 0x4f, 0xa7, 0x9a, 0x17, 0x58, 0xe2, 0x95, 0xa0, 0x29, 0x64, 0xc0, 0x59, 0xf4, 0xda,
+	0x40, 0xc6, 0x93, 0x90, 0xf4, 0x2a, 0x17, 0x9a, 0x3b, 0x52, 0xcd, 0x7a, 0x12, 0xf2, 0x9d, 0x01,
+	0x2d, 0xeb, 0x57, 0x40, 0x66, 0x9e, 0xa4, 0xe2, 0x39, 0xc3, 0x09, 0xef, 0x8e, 0x42, 0xc7, 0xba,
+	0x3b, 0x9e, 0xe3, 0x64, 0x0f, 0x6e, 0xcd, 0x7d, 0x2e, 0xd1, 0x57, 0x91, 0xbe, 0xe9, 0x1c, 0x2f,
+	0xf1, 0x77, 0xa1, 0x23, 0x14, 0x15, 0x59, 0x6c, 0x5e, 0xa0, 0xcb, 0x62, 0x0d, 0xc9, 0x6d, 0xa1,
+	0xfa, 0x16, 0xb6, 0x79, 0xfc, 0x12, 0x36, 0x84, 0xa2, 0x7c, 0x99, 0x58, 0x47, 0x62, 0x4b, 0xa8,
+	0xde, 0x12, 0xcf, 0xe4, 0xbb, 0xf0, 0xa8, 0x98, 0xbd, 0x49, 0x3c, 0x97, 0xef, 0xcc, 0xe7, 0xc8,
+	0xe2, 0xe6, 0x9b, 0x60, 0xe1, 0x75, 0x46, 0x6e, 0x20, 0x79, 0x63, 0xe6, 0xd7, 0x71, 0xbb, 0xef,
+	0xa0, 0xb9, 0xf4, 0x8a, 0xcc, 0xd5, 0x89, 0xad, 0x36, 0x1f, 0x5f, 0x6b, 0x66, 0xdb, 0x8f, 0x3e,
+	0xea, 0xf8, 0xea, 0x0d, 0x1d, 0x5f, 0x5b, 0xea, 0xf8, 0x27, 0xaf, 0xa1, 0xee, 0xfe, 0x98, 0x90,
+	0x26, 0xd4, 0x8f, 0x7a, 0x6f, 0x5e, 0x4d, 0x06, 0x27, 0x9d, 0x2f, 0xc8, 0x3a, 0x78, 0x7f, 0x38,
+	0x9e, 0x04, 0xc3, 0x57, 0x83, 0xa3, 0x4e, 0x85, 0x34, 0x60, 0x75, 0x7c, 0x72, 0xd4, 0x3f, 0xee,
+	0x54, 0x89, 0x07, 0x2b, 0xc3, 0xc9, 0x60, 0xd0, 0xa9, 0x91, 0x3a, 0xd4, 0x4e, 0x7a, 0xbd, 0xce,
+	0xca, 0x93, 0x21, 0x78, 0xb3, 0xbf, 0x1d, 0x64, 0x0b, 0x36, 0x27, 0xc3, 0xfe, 0x09, 0x7d, 0x7b,
+	0x7c, 0xd4, 0xa3, 0x0b, 0x77, 0x04, 0xda, 0x0b, 0xf8, 0x4d, 0x7f, 0xd0, 0xeb, 0x54, 0xc8, 0x5d,
+	0xb8, 0xb5, 0xc0, 0x4e, 0x82, 0x57, 0xc3, 0x71, 0xbf, 0x37, 0x3c, 0xe9, 0x54, 0x5f, 0x8f, 0xfe,
+	0xfe, 0x61, 0xbb, 0xf2, 0x8f, 0x0f, 0xdb, 0x95, 0x7f, 0x7e, 0xd8, 0xae, 0xfc, 0xf5, 0x5f, 0xdb,
+	0x5f, 0xfc, 0xf9, 0xb7, 0x3f, 0xef, 0xaf, 0xde, 0x77, 0xee, 0xf7, 0x4f, 0x5f, 0x9c, 0xae, 0xe1,
+	0x1f, 0xb8, 0x83, 0xff, 0x0e, 0x00, 0x9d, 0xab, 0xb0, 0xdc, 0x35, 0x0e, 0x00, 0x00,
+}
+
+func (m *CreateOptions) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *CreateOptions) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *CreateOptions) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.ExtendedStats {
+		i--
+		if m.ExtendedStats {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x2
+		i--
+		dAtA[i] = 0xa0
+	}
+	if m.NullIo {
+		i--
+		if m.NullIo {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x2
+		i--
+		dAtA[i] = 0x98
+	}
+	if len(m.ProtectSystem) > 0 {
+		i -= len(m.ProtectSystem)
+		copy(dAtA[i:], m.ProtectSystem)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.ProtectSystem)))
+		i--
+		dAtA[i] = 0x2
+		i--
+		dAtA[i] = 0x92
+	}
+	if m.PrivateTmp {
+		i--
+		if m.PrivateTmp {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x2
+		i--
+		dAtA[i] = 0x88
+	}
+	if len(m.MountFlags) > 0 {
+		i -= len(m.MountFlags)
+		copy(dAtA[i:], m.MountFlags)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.MountFlags)))
+		i--
+		dAtA[i] = 0x2
+		i--
+		dAtA[i] = 0x82
+	}
+	if len(m.Accounting) > 0 {
+		for iNdEx := len(m.Accounting) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Accounting[iNdEx])
+			copy(dAtA[i:], m.Accounting[iNdEx])
+			i = encodeVarintOptions(dAtA, i, uint64(len(m.Accounting[iNdEx])))
+			i--
+			dAtA[i] = 0x1
+			i--
+			dAtA[i] = 0xfa
+		}
+	}
+	if len(m.FinalKillSignalName) > 0 {
+		i -= len(m.FinalKillSignalName)
+		copy(dAtA[i:], m.FinalKillSignalName)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.FinalKillSignalName)))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0xf2
+	}
+	if len(m.KillSignalName) > 0 {
+		i -= len(m.KillSignalName)
+		copy(dAtA[i:], m.KillSignalName)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.KillSignalName)))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0xea
+	}
+	if len(m.LazyPagesServer) > 0 {
+		i -= len(m.LazyPagesServer)
+		copy(dAtA[i:], m.LazyPagesServer)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.LazyPagesServer)))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0xe2
+	}
+	if m.KillGracePeriodSec != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.KillGracePeriodSec))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0xd8
+	}
+	if m.MaxLogLineSize != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.MaxLogLineSize))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0xd0
+	}
+	if m.LogRateLimitBurst != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.LogRateLimitBurst))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0xc8
+	}
+	if m.LogRateLimitIntervalSec != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.LogRateLimitIntervalSec))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0xc0
+	}
+	if len(m.ManagedOomMemoryPressureLimit) > 0 {
+		i -= len(m.ManagedOomMemoryPressureLimit)
+		copy(dAtA[i:], m.ManagedOomMemoryPressureLimit)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.ManagedOomMemoryPressureLimit)))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0xba
+	}
+	if len(m.ManagedOomSwap) > 0 {
+		i -= len(m.ManagedOomSwap)
+		copy(dAtA[i:], m.ManagedOomSwap)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.ManagedOomSwap)))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0xb2
+	}
+	if len(m.ManagedOomMemoryPressure) > 0 {
+		i -= len(m.ManagedOomMemoryPressure)
+		copy(dAtA[i:], m.ManagedOomMemoryPressure)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.ManagedOomMemoryPressure)))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0xaa
+	}
+	if len(m.OomPolicy) > 0 {
+		i -= len(m.OomPolicy)
+		copy(dAtA[i:], m.OomPolicy)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.OomPolicy)))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0xa2
+	}
+	if m.SystemdCgroup {
+		i--
+		if m.SystemdCgroup {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0x98
+	}
+	if len(m.BinaryName) > 0 {
+		i -= len(m.BinaryName)
+		copy(dAtA[i:], m.BinaryName)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.BinaryName)))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0x92
+	}
+	if len(m.Root) > 0 {
+		i -= len(m.Root)
+		copy(dAtA[i:], m.Root)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.Root)))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0x8a
+	}
+	if len(m.CriuPath) > 0 {
+		i -= len(m.CriuPath)
+		copy(dAtA[i:], m.CriuPath)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.CriuPath)))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0x82
+	}
+	if m.IoGid != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.IoGid))
+		i--
+		dAtA[i] = 0x78
+	}
+	if m.IoUid != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.IoUid))
+		i--
+		dAtA[i] = 0x70
+	}
+	if m.NoPivotRoot {
+		i--
+		if m.NoPivotRoot {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x68
+	}
+	if m.DynamicUser {
+		i--
+		if m.DynamicUser {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x60
+	}
+	if len(m.Group) > 0 {
+		i -= len(m.Group)
+		copy(dAtA[i:], m.Group)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.Group)))
+		i--
+		dAtA[i] = 0x5a
+	}
+	if len(m.User) > 0 {
+		i -= len(m.User)
+		copy(dAtA[i:], m.User)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.User)))
+		i--
+		dAtA[i] = 0x52
+	}
+	if m.TimeoutStopSec != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.TimeoutStopSec))
+		i--
+		dAtA[i] = 0x48
+	}
+	if m.FinalKillSignal != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.FinalKillSignal))
+		i--
+		dAtA[i] = 0x40
+	}
+	if m.KillSignal != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.KillSignal))
+		i--
+		dAtA[i] = 0x38
+	}
+	if len(m.KillMode) > 0 {
+		i -= len(m.KillMode)
+		copy(dAtA[i:], m.KillMode)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.KillMode)))
+		i--
+		dAtA[i] = 0x32
+	}
+	if m.WatchdogSec != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.WatchdogSec))
+		i--
+		dAtA[i] = 0x28
+	}
+	if len(m.Slice) > 0 {
+		i -= len(m.Slice)
+		copy(dAtA[i:], m.Slice)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.Slice)))
+		i--
+		dAtA[i] = 0x22
+	}
+	if m.UnitMode != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.UnitMode))
+		i--
+		dAtA[i] = 0x18
+	}
+	if m.SdNotifyEnable {
+		i--
+		if m.SdNotifyEnable {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.LogMode != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.LogMode))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *CheckpointOptions) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *CheckpointOptions) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *CheckpointOptions) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if len(m.ParentPath) > 0 {
+		i -= len(m.ParentPath)
+		copy(dAtA[i:], m.ParentPath)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.ParentPath)))
+		i--
+		dAtA[i] = 0x5a
+	}
+	if m.PreDump {
+		i--
+		if m.PreDump {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x50
+	}
+	if len(m.WorkPath) > 0 {
+		i -= len(m.WorkPath)
+		copy(dAtA[i:], m.WorkPath)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.WorkPath)))
+		i--
+		dAtA[i] = 0x4a
+	}
+	if len(m.ImagePath) > 0 {
+		i -= len(m.ImagePath)
+		copy(dAtA[i:], m.ImagePath)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.ImagePath)))
+		i--
+		dAtA[i] = 0x42
+	}
+	if len(m.CgroupsMode) > 0 {
+		i -= len(m.CgroupsMode)
+		copy(dAtA[i:], m.CgroupsMode)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.CgroupsMode)))
+		i--
+		dAtA[i] = 0x3a
+	}
+	if len(m.EmptyNamespaces) > 0 {
+		for iNdEx := len(m.EmptyNamespaces) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.EmptyNamespaces[iNdEx])
+			copy(dAtA[i:], m.EmptyNamespaces[iNdEx])
+			i = encodeVarintOptions(dAtA, i, uint64(len(m.EmptyNamespaces[iNdEx])))
+			i--
+			dAtA[i] = 0x32
+		}
+	}
+	if m.FileLocks {
+		i--
+		if m.FileLocks {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x28
+	}
+	if m.Terminal {
+		i--
+		if m.Terminal {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x20
+	}
+	if m.ExternalUnixSockets {
+		i--
+		if m.ExternalUnixSockets {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x18
+	}
+	if m.OpenTcp {
+		i--
+		if m.OpenTcp {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.Exit {
+		i--
+		if m.Exit {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *TaskWatchdog) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *TaskWatchdog) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *TaskWatchdog) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.Pid != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.Pid))
+		i--
+		dAtA[i] = 0x10
+	}
+	if len(m.ContainerId) > 0 {
+		i -= len(m.ContainerId)
+		copy(dAtA[i:], m.ContainerId)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.ContainerId)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *TaskRestart) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *TaskRestart) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *TaskRestart) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.RestartCount != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.RestartCount))
+		i--
+		dAtA[i] = 0x18
+	}
+	if m.Pid != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.Pid))
+		i--
+		dAtA[i] = 0x10
+	}
+	if len(m.ContainerId) > 0 {
+		i -= len(m.ContainerId)
+		copy(dAtA[i:], m.ContainerId)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.ContainerId)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *TaskStopped) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *TaskStopped) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *TaskStopped) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.Forced {
+		i--
+		if m.Forced {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x20
+	}
+	if m.Pid != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.Pid))
+		i--
+		dAtA[i] = 0x18
+	}
+	if len(m.Id) > 0 {
+		i -= len(m.Id)
+		copy(dAtA[i:], m.Id)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.Id)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.ContainerId) > 0 {
+		i -= len(m.ContainerId)
+		copy(dAtA[i:], m.ContainerId)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.ContainerId)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *PSIStats) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *PSIStats) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *PSIStats) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.Total != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.Total))
+		i--
+		dAtA[i] = 0x20
+	}
+	if m.Avg300 != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.Avg300))))
+		i--
+		dAtA[i] = 0x19
+	}
+	if m.Avg60 != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.Avg60))))
+		i--
+		dAtA[i] = 0x11
+	}
+	if m.Avg10 != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.Avg10))))
+		i--
+		dAtA[i] = 0x9
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *PSIData) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *PSIData) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *PSIData) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.Full != nil {
+		{
+			size, err := m.Full.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintOptions(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x12
+	}
+	if m.Some != nil {
+		{
+			size, err := m.Some.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintOptions(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *Pressure) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Pressure) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Pressure) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.Io != nil {
+		{
+			size, err := m.Io.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintOptions(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x1a
+	}
+	if m.Memory != nil {
+		{
+			size, err := m.Memory.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintOptions(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x12
+	}
+	if m.Cpu != nil {
+		{
+			size, err := m.Cpu.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintOptions(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *Metrics) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Metrics) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Metrics) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.Accounting != nil {
+		{
+			size, err := m.Accounting.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintOptions(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x2a
+	}
+	if m.ExecStats != nil {
+		{
+			size, err := m.ExecStats.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintOptions(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x22
+	}
+	if m.Pressure != nil {
+		{
+			size, err := m.Pressure.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintOptions(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x1a
+	}
+	if len(m.Value) > 0 {
+		i -= len(m.Value)
+		copy(dAtA[i:], m.Value)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.Value)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.TypeUrl) > 0 {
+		i -= len(m.TypeUrl)
+		copy(dAtA[i:], m.TypeUrl)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.TypeUrl)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *ExecStats) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ExecStats) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ExecStats) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if len(m.Execs) > 0 {
+		for iNdEx := len(m.Execs) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Execs[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintOptions(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0xa
+		}
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *UnitAccounting) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *UnitAccounting) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *UnitAccounting) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.IpEgressPackets != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.IpEgressPackets))
+		i--
+		dAtA[i] = 0x48
+	}
+	if m.IpIngressPackets != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.IpIngressPackets))
+		i--
+		dAtA[i] = 0x40
+	}
+	if m.IpEgressBytes != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.IpEgressBytes))
+		i--
+		dAtA[i] = 0x38
+	}
+	if m.IpIngressBytes != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.IpIngressBytes))
+		i--
+		dAtA[i] = 0x30
+	}
+	if m.IoWriteOperations != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.IoWriteOperations))
+		i--
+		dAtA[i] = 0x28
+	}
+	if m.IoReadOperations != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.IoReadOperations))
+		i--
+		dAtA[i] = 0x20
+	}
+	if m.IoWriteBytes != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.IoWriteBytes))
+		i--
+		dAtA[i] = 0x18
+	}
+	if m.IoReadBytes != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.IoReadBytes))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.CpuUsageNsec != 0 {
+		i = encodeVarintOptions(dAtA, i, uint64(m.CpuUsageNsec))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *ExecMetrics) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ExecMetrics) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ExecMetrics) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if len(m.Value) > 0 {
+		i -= len(m.Value)
+		copy(dAtA[i:], m.Value)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.Value)))
+		i--
+		dAtA[i] = 0x1a
+	}
+	if len(m.TypeUrl) > 0 {
+		i -= len(m.TypeUrl)
+		copy(dAtA[i:], m.TypeUrl)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.TypeUrl)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.ExecId) > 0 {
+		i -= len(m.ExecId)
+		copy(dAtA[i:], m.ExecId)
+		i = encodeVarintOptions(dAtA, i, uint64(len(m.ExecId)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func encodeVarintOptions(dAtA []byte, offset int, v uint64) int {
+	offset -= sovOptions(v)
+	base := offset
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return base
+}
+func (m *CreateOptions) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.LogMode != 0 {
+		n += 1 + sovOptions(uint64(m.LogMode))
+	}
+	if m.SdNotifyEnable {
+		n += 2
+	}
+	if m.UnitMode != 0 {
+		n += 1 + sovOptions(uint64(m.UnitMode))
+	}
+	l = len(m.Slice)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.WatchdogSec != 0 {
+		n += 1 + sovOptions(uint64(m.WatchdogSec))
+	}
+	l = len(m.KillMode)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.KillSignal != 0 {
+		n += 1 + sovOptions(uint64(m.KillSignal))
+	}
+	if m.FinalKillSignal != 0 {
+		n += 1 + sovOptions(uint64(m.FinalKillSignal))
+	}
+	if m.TimeoutStopSec != 0 {
+		n += 1 + sovOptions(uint64(m.TimeoutStopSec))
+	}
+	l = len(m.User)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	l = len(m.Group)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.DynamicUser {
+		n += 2
+	}
+	if m.NoPivotRoot {
+		n += 2
+	}
+	if m.IoUid != 0 {
+		n += 1 + sovOptions(uint64(m.IoUid))
+	}
+	if m.IoGid != 0 {
+		n += 1 + sovOptions(uint64(m.IoGid))
+	}
+	l = len(m.CriuPath)
+	if l > 0 {
+		n += 2 + l + sovOptions(uint64(l))
+	}
+	l = len(m.Root)
+	if l > 0 {
+		n += 2 + l + sovOptions(uint64(l))
+	}
+	l = len(m.BinaryName)
+	if l > 0 {
+		n += 2 + l + sovOptions(uint64(l))
+	}
+	if m.SystemdCgroup {
+		n += 3
+	}
+	l = len(m.OomPolicy)
+	if l > 0 {
+		n += 2 + l + sovOptions(uint64(l))
+	}
+	l = len(m.ManagedOomMemoryPressure)
+	if l > 0 {
+		n += 2 + l + sovOptions(uint64(l))
+	}
+	l = len(m.ManagedOomSwap)
+	if l > 0 {
+		n += 2 + l + sovOptions(uint64(l))
+	}
+	l = len(m.ManagedOomMemoryPressureLimit)
+	if l > 0 {
+		n += 2 + l + sovOptions(uint64(l))
+	}
+	if m.LogRateLimitIntervalSec != 0 {
+		n += 2 + sovOptions(uint64(m.LogRateLimitIntervalSec))
+	}
+	if m.LogRateLimitBurst != 0 {
+		n += 2 + sovOptions(uint64(m.LogRateLimitBurst))
+	}
+	if m.MaxLogLineSize != 0 {
+		n += 2 + sovOptions(uint64(m.MaxLogLineSize))
+	}
+	if m.KillGracePeriodSec != 0 {
+		n += 2 + sovOptions(uint64(m.KillGracePeriodSec))
+	}
+	l = len(m.LazyPagesServer)
+	if l > 0 {
+		n += 2 + l + sovOptions(uint64(l))
+	}
+	l = len(m.KillSignalName)
+	if l > 0 {
+		n += 2 + l + sovOptions(uint64(l))
+	}
+	l = len(m.FinalKillSignalName)
+	if l > 0 {
+		n += 2 + l + sovOptions(uint64(l))
+	}
+	if len(m.Accounting) > 0 {
+		for _, s := range m.Accounting {
+			l = len(s)
+			n += 2 + l + sovOptions(uint64(l))
+		}
+	}
+	l = len(m.MountFlags)
+	if l > 0 {
+		n += 2 + l + sovOptions(uint64(l))
+	}
+	if m.PrivateTmp {
+		n += 3
+	}
+	l = len(m.ProtectSystem)
+	if l > 0 {
+		n += 2 + l + sovOptions(uint64(l))
+	}
+	if m.NullIo {
+		n += 3
+	}
+	if m.ExtendedStats {
+		n += 3
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *CheckpointOptions) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Exit {
+		n += 2
+	}
+	if m.OpenTcp {
+		n += 2
+	}
+	if m.ExternalUnixSockets {
+		n += 2
+	}
+	if m.Terminal {
+		n += 2
+	}
+	if m.FileLocks {
+		n += 2
+	}
+	if len(m.EmptyNamespaces) > 0 {
+		for _, s := range m.EmptyNamespaces {
+			l = len(s)
+			n += 1 + l + sovOptions(uint64(l))
+		}
+	}
+	l = len(m.CgroupsMode)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	l = len(m.ImagePath)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	l = len(m.WorkPath)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.PreDump {
+		n += 2
+	}
+	l = len(m.ParentPath)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *TaskWatchdog) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.ContainerId)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.Pid != 0 {
+		n += 1 + sovOptions(uint64(m.Pid))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *TaskRestart) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.ContainerId)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.Pid != 0 {
+		n += 1 + sovOptions(uint64(m.Pid))
+	}
+	if m.RestartCount != 0 {
+		n += 1 + sovOptions(uint64(m.RestartCount))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *TaskStopped) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.ContainerId)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	l = len(m.Id)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.Pid != 0 {
+		n += 1 + sovOptions(uint64(m.Pid))
+	}
+	if m.Forced {
+		n += 2
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *PSIStats) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Avg10 != 0 {
+		n += 9
+	}
+	if m.Avg60 != 0 {
+		n += 9
+	}
+	if m.Avg300 != 0 {
+		n += 9
+	}
+	if m.Total != 0 {
+		n += 1 + sovOptions(uint64(m.Total))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *PSIData) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Some != nil {
+		l = m.Some.Size()
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.Full != nil {
+		l = m.Full.Size()
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *Pressure) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Cpu != nil {
+		l = m.Cpu.Size()
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.Memory != nil {
+		l = m.Memory.Size()
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.Io != nil {
+		l = m.Io.Size()
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *Metrics) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.TypeUrl)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	l = len(m.Value)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.Pressure != nil {
+		l = m.Pressure.Size()
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.ExecStats != nil {
+		l = m.ExecStats.Size()
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.Accounting != nil {
+		l = m.Accounting.Size()
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *ExecStats) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Execs) > 0 {
+		for _, e := range m.Execs {
+			l = e.Size()
+			n += 1 + l + sovOptions(uint64(l))
+		}
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *UnitAccounting) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.CpuUsageNsec != 0 {
+		n += 1 + sovOptions(uint64(m.CpuUsageNsec))
+	}
+	if m.IoReadBytes != 0 {
+		n += 1 + sovOptions(uint64(m.IoReadBytes))
+	}
+	if m.IoWriteBytes != 0 {
+		n += 1 + sovOptions(uint64(m.IoWriteBytes))
+	}
+	if m.IoReadOperations != 0 {
+		n += 1 + sovOptions(uint64(m.IoReadOperations))
+	}
+	if m.IoWriteOperations != 0 {
+		n += 1 + sovOptions(uint64(m.IoWriteOperations))
+	}
+	if m.IpIngressBytes != 0 {
+		n += 1 + sovOptions(uint64(m.IpIngressBytes))
+	}
+	if m.IpEgressBytes != 0 {
+		n += 1 + sovOptions(uint64(m.IpEgressBytes))
+	}
+	if m.IpIngressPackets != 0 {
+		n += 1 + sovOptions(uint64(m.IpIngressPackets))
+	}
+	if m.IpEgressPackets != 0 {
+		n += 1 + sovOptions(uint64(m.IpEgressPackets))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *ExecMetrics) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.ExecId)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	l = len(m.TypeUrl)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	l = len(m.Value)
+	if l > 0 {
+		n += 1 + l + sovOptions(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func sovOptions(x uint64) (n int) {
+	return (math_bits.Len64(x|1) + 6) / 7
+}
+func sozOptions(x uint64) (n int) {
+	return sovOptions(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *CreateOptions) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowOptions
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: CreateOptions: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: CreateOptions: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LogMode", wireType)
+			}
+			m.LogMode = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.LogMode |= LogMode(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SdNotifyEnable", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.SdNotifyEnable = bool(v != 0)
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field UnitMode", wireType)
+			}
+			m.UnitMode = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.UnitMode |= UnitMode(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Slice", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Slice = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field WatchdogSec", wireType)
+			}
+			m.WatchdogSec = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.WatchdogSec |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KillMode", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.KillMode = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KillSignal", wireType)
+			}
+			m.KillSignal = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.KillSignal |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FinalKillSignal", wireType)
+			}
+			m.FinalKillSignal = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.FinalKillSignal |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TimeoutStopSec", wireType)
+			}
+			m.TimeoutStopSec = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TimeoutStopSec |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field User", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.User = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Group", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Group = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DynamicUser", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.DynamicUser = bool(v != 0)
+		case 13:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NoPivotRoot", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.NoPivotRoot = bool(v != 0)
+		case 14:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IoUid", wireType)
+			}
+			m.IoUid = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.IoUid |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 15:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IoGid", wireType)
+			}
+			m.IoGid = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.IoGid |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 16:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CriuPath", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CriuPath = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 17:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Root", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Root = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 18:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field BinaryName", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.BinaryName = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 19:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SystemdCgroup", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.SystemdCgroup = bool(v != 0)
+		case 20:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field OomPolicy", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.OomPolicy = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 21:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ManagedOomMemoryPressure", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ManagedOomMemoryPressure = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 22:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ManagedOomSwap", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ManagedOomSwap = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 23:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ManagedOomMemoryPressureLimit", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ManagedOomMemoryPressureLimit = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 24:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LogRateLimitIntervalSec", wireType)
+			}
+			m.LogRateLimitIntervalSec = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.LogRateLimitIntervalSec |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 25:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LogRateLimitBurst", wireType)
+			}
+			m.LogRateLimitBurst = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.LogRateLimitBurst |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 26:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxLogLineSize", wireType)
+			}
+			m.MaxLogLineSize = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxLogLineSize |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 27:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KillGracePeriodSec", wireType)
+			}
+			m.KillGracePeriodSec = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.KillGracePeriodSec |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 28:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LazyPagesServer", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.LazyPagesServer = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 29:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KillSignalName", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.KillSignalName = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 30:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FinalKillSignalName", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.FinalKillSignalName = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 31:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Accounting", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Accounting = append(m.Accounting, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 32:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MountFlags", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.MountFlags = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 33:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PrivateTmp", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.PrivateTmp = bool(v != 0)
+		case 34:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ProtectSystem", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ProtectSystem = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 35:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NullIo", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.NullIo = bool(v != 0)
+		case 36:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ExtendedStats", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.ExtendedStats = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipOptions(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *CheckpointOptions) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowOptions
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: CheckpointOptions: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: CheckpointOptions: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Exit", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Exit = bool(v != 0)
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field OpenTcp", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.OpenTcp = bool(v != 0)
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ExternalUnixSockets", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.ExternalUnixSockets = bool(v != 0)
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Terminal", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Terminal = bool(v != 0)
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FileLocks", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.FileLocks = bool(v != 0)
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field EmptyNamespaces", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.EmptyNamespaces = append(m.EmptyNamespaces, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CgroupsMode", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CgroupsMode = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ImagePath", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ImagePath = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field WorkPath", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.WorkPath = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PreDump", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.PreDump = bool(v != 0)
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ParentPath", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ParentPath = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipOptions(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *TaskWatchdog) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowOptions
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: TaskWatchdog: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: TaskWatchdog: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ContainerId", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ContainerId = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Pid", wireType)
+			}
+			m.Pid = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Pid |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipOptions(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *TaskRestart) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowOptions
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: TaskRestart: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: TaskRestart: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ContainerId", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ContainerId = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Pid", wireType)
+			}
+			m.Pid = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Pid |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RestartCount", wireType)
+			}
+			m.RestartCount = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RestartCount |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipOptions(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *TaskStopped) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowOptions
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: TaskStopped: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: TaskStopped: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ContainerId", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ContainerId = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Id = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Pid", wireType)
+			}
+			m.Pid = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Pid |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Forced", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Forced = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipOptions(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *PSIStats) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowOptions
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: PSIStats: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: PSIStats: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Avg10", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.Avg10 = float64(math.Float64frombits(v))
+		case 2:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Avg60", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.Avg60 = float64(math.Float64frombits(v))
+		case 3:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Avg300", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.Avg300 = float64(math.Float64frombits(v))
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Total", wireType)
+			}
+			m.Total = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Total |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipOptions(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *PSIData) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowOptions
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: PSIData: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: PSIData: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Some", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Some == nil {
+				m.Some = &PSIStats{}
+			}
+			if err := m.Some.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Full", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Full == nil {
+				m.Full = &PSIStats{}
+			}
+			if err := m.Full.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipOptions(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Pressure) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowOptions
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Pressure: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Pressure: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Cpu", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Cpu == nil {
+				m.Cpu = &PSIData{}
+			}
+			if err := m.Cpu.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Memory", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Memory == nil {
+				m.Memory = &PSIData{}
+			}
+			if err := m.Memory.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Io", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Io == nil {
+				m.Io = &PSIData{}
+			}
+			if err := m.Io.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipOptions(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Metrics) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowOptions
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Metrics: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Metrics: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TypeUrl", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.TypeUrl = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Value = append(m.Value[:0], dAtA[iNdEx:postIndex]...)
+			if m.Value == nil {
+				m.Value = []byte{}
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Pressure", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Pressure == nil {
+				m.Pressure = &Pressure{}
+			}
+			if err := m.Pressure.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ExecStats", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.ExecStats == nil {
+				m.ExecStats = &ExecStats{}
+			}
+			if err := m.ExecStats.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Accounting", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Accounting == nil {
+				m.Accounting = &UnitAccounting{}
+			}
+			if err := m.Accounting.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipOptions(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *ExecStats) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowOptions
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ExecStats: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ExecStats: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Execs", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Execs = append(m.Execs, &ExecMetrics{})
+			if err := m.Execs[len(m.Execs)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipOptions(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *UnitAccounting) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowOptions
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
 			}
-			var v int
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: UnitAccounting: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: UnitAccounting: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CpuUsageNsec", wireType)
+			}
+			m.CpuUsageNsec = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowOptions
@@ -278,12 +5314,315 @@ func (m *CreateOptions) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				v |= int(b&0x7F) << shift
+				m.CpuUsageNsec |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			m.SdNotifyEnable = bool(v != 0)
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IoReadBytes", wireType)
+			}
+			m.IoReadBytes = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.IoReadBytes |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IoWriteBytes", wireType)
+			}
+			m.IoWriteBytes = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.IoWriteBytes |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IoReadOperations", wireType)
+			}
+			m.IoReadOperations = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.IoReadOperations |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IoWriteOperations", wireType)
+			}
+			m.IoWriteOperations = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.IoWriteOperations |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IpIngressBytes", wireType)
+			}
+			m.IpIngressBytes = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.IpIngressBytes |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IpEgressBytes", wireType)
+			}
+			m.IpEgressBytes = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.IpEgressBytes |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IpIngressPackets", wireType)
+			}
+			m.IpIngressPackets = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.IpIngressPackets |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IpEgressPackets", wireType)
+			}
+			m.IpEgressPackets = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.IpEgressPackets |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipOptions(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *ExecMetrics) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowOptions
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ExecMetrics: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ExecMetrics: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ExecId", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ExecId = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TypeUrl", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.TypeUrl = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOptions
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthOptions
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthOptions
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Value = append(m.Value[:0], dAtA[iNdEx:postIndex]...)
+			if m.Value == nil {
+				m.Value = []byte{}
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipOptions(dAtA[iNdEx:])
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/log"
+)
+
+// Host admins can put drop-ins for container units in the overrides directory, to enforce policies (e.g. CPUWeight=
+// caps, IPAccounting=) on containers without changing how they are created:
+//
+//	global/*.conf             all containers
+//	per-image/<repo>/*.conf   containers of an image, e.g. per-image/docker.io/library/nginx/ for any tag of nginx
+//	per-name/<name>/*.conf    containers by name, see containerName
+//
+// When a container unit is installed the drop-ins that apply are linked into the drop-in directory of the unit
+// (<unit>.d in the runtime unit directory), so changes to them apply after a daemon-reload. systemd applies drop-ins
+// in file name order, the links are prefixed so per-name drop-ins override per-image ones, which override global ones.
+// Exec units don't get the drop-ins.
+const (
+	overridesGlobalDir = "global"
+	overridesImageDir  = "per-image"
+	overridesNameDir   = "per-name"
+)
+
+// overridesDir is the directory with the drop-ins of the host admin.
+func overridesDir() string {
+	if rootless {
+		if d := os.Getenv("XDG_CONFIG_HOME"); d != "" {
+			return filepath.Join(d, "containerd-shim-systemd/overrides")
+		}
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, ".config/containerd-shim-systemd/overrides")
+	}
+	return "/etc/containerd-shim-systemd/overrides"
+}
+
+// dropInDir is the drop-in directory of the named unit.
+func dropInDir(name string) string {
+	return filepath.Join(runtimeUnitDir(), name+".d")
+}
+
+// imageRepository returns the image reference without tag and digest.
+func imageRepository(ref string) string {
+	if i := strings.IndexByte(ref, '@'); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndexByte(ref, ':'); i > strings.LastIndexByte(ref, '/') {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+// overrideDir is a directory of drop-ins, prefix is put in front of the names of their links.
+type overrideDir struct {
+	path   string
+	prefix string
+}
+
+// overrideDirs returns the directories of the drop-ins for the container, in the order they are applied.
+func (p *initProcess) overrideDirs() []overrideDir {
+	base := overridesDir()
+	dirs := []overrideDir{{filepath.Join(base, overridesGlobalDir), "10-global-"}}
+
+	// Names and image references come from the container config, they must not get out of their directory.
+	sub := func(dir, rel string) string {
+		d := filepath.Join(dir, rel)
+		if rel == "" || !strings.HasPrefix(d, dir+string(filepath.Separator)) {
+			return ""
+		}
+		return d
+	}
+	if d := sub(filepath.Join(base, overridesImageDir), imageRepository(p.opts.CRI.Image)); d != "" {
+		dirs = append(dirs, overrideDir{d, "20-image-"})
+	}
+	if d := sub(filepath.Join(base, overridesNameDir), p.containerName()); d != "" {
+		dirs = append(dirs, overrideDir{d, "30-name-"})
+	}
+	return dirs
+}
+
+// linkOverrides links the drop-ins of the host admin that apply to the container into the drop-in directory of its
+// unit. It is done before the unit is installed so they are loaded with it.
+func (p *initProcess) linkOverrides(ctx context.Context) error {
+	dir := dropInDir(p.Name())
+	// Drop-ins of an earlier run of the container may be gone by now.
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	var links []string
+	for _, d := range p.overrideDirs() {
+		files, _ := filepath.Glob(filepath.Join(d.path, "*.conf"))
+		sort.Strings(files)
+		for _, f := range files {
+			if len(links) == 0 {
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					return err
+				}
+				if err := labelLike(dir, runtimeUnitDir()); err != nil {
+					return err
+				}
+			}
+			link := filepath.Join(dir, d.prefix+filepath.Base(f))
+			if err := os.Symlink(f, link); err != nil {
+				return fmt.Errorf("error linking drop-in %s: %w", f, err)
+			}
+			links = append(links, f)
+		}
+	}
+	if len(links) > 0 {
+		log.G(ctx).WithField("dropins", links).Debug("Linked unit drop-ins")
+	}
+	return nil
+}
+
+// removeOverrides removes the drop-in directory of the named unit.
+func removeOverrides(ctx context.Context, name string) {
+	if err := os.RemoveAll(dropInDir(name)); err != nil {
+		log.G(ctx).WithError(err).WithField("unit", name).Debug("Error removing unit drop-ins")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/containerd/cgroups"
+	cgroupsv2 "github.com/containerd/cgroups/v2"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/go-runc"
+	dbus "github.com/godbus/dbus/v5"
+)
+
+const (
+	sdBusName    = "org.freedesktop.systemd1"
+	sdBusPath    = "/org/freedesktop/systemd1"
+	sdBusManager = sdBusName + ".Manager"
+
+	statusPausing = "pausing"
+	statusPaused  = "paused"
+)
+
+// callManager calls a method on the systemd manager object.
+// This is for methods which go-systemd does not have wrappers for.
+// The methods we call are all idempotent so they are retried while systemd is unavailable.
+func callManager(ctx context.Context, conn *sdConn, method string, args ...interface{}) *dbus.Call {
+	var call *dbus.Call
+	if err := conn.retry(ctx, func(ctx context.Context, s *sdSession) error {
+		call = s.bus.Object(sdBusName, sdBusPath).CallWithContext(ctx, sdBusManager+"."+method, 0, args...)
+		return call.Err
+	}); err != nil {
+		// e.g. with the timeout
+		call.Err = err
+	}
+	if call.Err != nil {
+		metrics.dbusErrors.Inc(method)
+	}
+	return call
+}
+
+// Pause freezes all processes in the container.
+// When the container is in the cgroup of its unit (cgroup v2) this uses the systemd freezer so the frozen state is
+// visible to systemd as well, otherwise it falls back to runc.
+func (p *initProcess) Pause(ctx context.Context) error {
+	if p.ProcessState().Exited() {
+		return fmt.Errorf("container is not running: %w", errdefs.ErrFailedPrecondition)
+	}
+
+	p.setFreezeState(statusPausing)
+
+	var err error
+	if p.unitFreezer(ctx) {
+		err = callManager(ctx, p.systemd, "FreezeUnit", p.Name()).Err
+	} else {
+		err = p.runtimeError(ctx, runcCall(ctx, func(ctx context.Context) error {
+			return p.runc.Pause(ctx, p.id)
+		}))
+	}
+	if err != nil {
+		p.setFreezeState("")
+		return err
+	}
+
+	p.setFreezeState(statusPaused)
+	return nil
+}
+
+// Resume thaws a container frozen by Pause.
+func (p *initProcess) Resume(ctx context.Context) error {
+	if !p.paused(ctx) {
+		return fmt.Errorf("container is not paused: %w", errdefs.ErrFailedPrecondition)
+	}
+
+	var err error
+	if p.unitFreezer(ctx) {
+		err = callManager(ctx, p.systemd, "ThawUnit", p.Name()).Err
+	} else {
+		err = p.runtimeError(ctx, runcCall(ctx, func(ctx context.Context) error {
+			return p.runc.Resume(ctx, p.id)
+		}))
+	}
+	if err != nil {
+		return err
+	}
+
+	p.setFreezeState("")
+	return nil
+}
+
+// paused returns whether the container is paused.
+// The freeze state is not kept across shim restarts, runc tells from the freezer of the container cgroup.
+func (p *initProcess) paused(ctx context.Context) bool {
+	p.mu.Lock()
+	st := p.freezeState
+	p.mu.Unlock()
+	if st != "" {
+		return st == statusPaused
+	}
+	if p.Pid() == 0 || p.ProcessState().Exited() {
+		return false
+	}
+	var c *runc.Container
+	err := runcCall(ctx, func(ctx context.Context) (err error) {
+		c, err = p.runc.State(ctx, p.id)
+		return err
+	})
+	if err != nil {
+		log.G(ctx).WithError(err).Debug("Error getting runtime state")
+		return false
+	}
+	return c.Status == statusPaused
+}
+
+// unitFreezer returns whether the container can be frozen through its unit.
+// The systemd freezer freezes the cgroup of the unit, which is only the container's if runc didn't put the container
+// in a cgroup of its own, e.g. with the cgroupfs driver or a cgroupsPath outside of the unit.
+func (p *initProcess) unitFreezer(ctx context.Context) bool {
+	if cgroups.Mode() != cgroups.Unified {
+		return false
+	}
+	pid := int(p.Pid())
+	if pid == 0 {
+		return false
+	}
+	g, err := cgroupsv2.PidGroupPath(pid)
+	if err != nil {
+		log.G(ctx).WithError(err).Debug("Error getting container cgroup, pausing with runc")
+		return false
+	}
+	ug, err := p.unitCgroup(ctx, p.Name())
+	if err != nil {
+		log.G(ctx).WithError(err).Debug("Error getting unit cgroup, pausing with runc")
+		return false
+	}
+	return path.Clean(g) == path.Clean(ug)
+}
+
+func (p *initProcess) setFreezeState(s string) {
+	p.mu.Lock()
+	p.freezeState = s
+	p.mu.Unlock()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/cgroups"
+	cgroupsv2 "github.com/containerd/cgroups/v2"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/log"
+	v2runcopts "github.com/containerd/containerd/runtime/v2/runc/options"
+	"github.com/containerd/typeurl"
+)
+
+// unitProcess is the dbus representation of an entry returned by GetUnitProcesses.
+type unitProcess struct {
+	Cgroup  string
+	Pid     uint32
+	Cmdline string
+}
+
+// Pids lists all processes in the container.
+// Processes which belong to an exec are annotated with the exec ID.
+func (p *initProcess) Pids(ctx context.Context) ([]*task.ProcessInfo, error) {
+	pids, err := p.cgroupPids(ctx)
+	if err != nil {
+		log.G(ctx).WithError(err).Debug("Error reading cgroup procs, falling back to systemd")
+		pids, err = p.unitPids(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	execs := make(map[uint32]string)
+	p.execs.Each(func(exec Process) {
+		if pid := exec.Pid(); pid > 0 {
+			execs[pid] = exec.(*execProcess).execID
+		}
+	})
+
+	procs := make([]*task.ProcessInfo, 0, len(pids))
+	for _, pid := range pids {
+		info := &task.ProcessInfo{Pid: pid}
+		if id, ok := execs[pid]; ok {
+			a, err := typeurl.MarshalAny(&v2runcopts.ProcessDetails{ExecID: id})
+			if err != nil {
+				return nil, fmt.Errorf("error marshalling process details for exec %s: %w", id, err)
+			}
+			info.Info = a
+		}
+		procs = append(procs, info)
+	}
+	return procs, nil
+}
+
+// cgroupPids reads the pids from the container's cgroup.
+// See cgroupStats for how the cgroup is selected.
+func (p *initProcess) cgroupPids(ctx context.Context) ([]uint32, error) {
+	pid := int(p.Pid())
+	running := pid > 0 && !p.ProcessState().Exited()
+
+	if cgroups.Mode() == cgroups.Unified {
+		g, err := p.cgroupV2Path(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cg, err := cgroupsv2.LoadManager("/sys/fs/cgroup", g)
+		if err != nil {
+			return nil, err
+		}
+		ls, err := cg.Procs(true)
+		if err != nil {
+			return nil, err
+		}
+		pids := make([]uint32, 0, len(ls))
+		for _, pid := range ls {
+			pids = append(pids, uint32(pid))
+		}
+		return pids, nil
+	}
+
+	path := cgroups.PidPath(pid)
+	if !running {
+		g, err := p.unitCgroup(ctx, p.Name())
+		if err != nil {
+			return nil, err
+		}
+		path = cgroups.StaticPath(g)
+	}
+	cg, err := cgroups.Load(cgroups.V1, path)
+	if err != nil {
+		return nil, err
+	}
+	ls, err := cg.Processes(cgroups.Devices, true)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]uint32, 0, len(ls))
+	for _, proc := range ls {
+		pids = append(pids, uint32(proc.Pid))
+	}
+	return pids, nil
+}
+
+// unitPids asks systemd for all the processes in the unit.
+func (p *initProcess) unitPids(ctx context.Context) ([]uint32, error) {
+	var ls []unitProcess
+	if err := callManager(ctx, p.systemd, "GetUnitProcesses", p.Name()).Store(&ls); err != nil {
+		return nil, fmt.Errorf("error getting unit processes: %w", err)
+	}
+
+	pids := make([]uint32, 0, len(ls))
+	for _, proc := range ls {
+		pids = append(pids, proc.Pid)
+	}
+	return pids, nil
+}
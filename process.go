@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"net"
 	"os"
@@ -13,34 +14,59 @@ import (
 
 	eventsapi "github.com/containerd/containerd/api/events"
 	"github.com/containerd/containerd/api/types"
-	"github.com/containerd/containerd/api/types/task"
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/log"
 	"github.com/containerd/containerd/runtime/linux/runctypes"
 	v2runcopts "github.com/containerd/containerd/runtime/v2/runc/options"
 	"github.com/containerd/go-runc"
 	"github.com/containerd/typeurl"
+	"github.com/coreos/go-systemd/unit"
 	systemd "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
 	ptypes "github.com/gogo/protobuf/types"
 	"github.com/opencontainers/runtime-spec/specs-go"
 )
 
+// processManagerShards is the number of maps a processManager is split into.
+// Every create, exec and state lookup goes through the process maps, with one lock they serialize on dense nodes.
+const processManagerShards = 32
+
+// processManager is a concurrent map of processes.
+// It is sharded by id so unrelated containers don't contend on the same lock.
 type processManager struct {
+	shards [processManagerShards]processShard
+}
+
+type processShard struct {
 	mu sync.Mutex
 	ls map[string]Process
 }
 
-func newUnitManager(conn *systemd.Conn) *unitManager {
-	um := &unitManager{idx: make(map[string]Process), sd: conn}
+func newProcessManager() *processManager {
+	// The shard maps are allocated when used, most containers only have a few execs.
+	return &processManager{}
+}
+
+func (m *processManager) shard(id string) *processShard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &m.shards[h.Sum32()%processManagerShards]
+}
+
+func newUnitManager(conn *sdConn) *unitManager {
+	um := &unitManager{idx: make(map[string]Process), sd: conn, refresh: make(chan struct{}, 1)}
 	um.cond = sync.NewCond(&um.mu)
 	return um
 }
 
 type unitManager struct {
-	sd   *systemd.Conn
-	mu   sync.Mutex
+	sd *sdConn
+	// mu is read locked for lookups, which happen for every unit change signal.
+	mu   sync.RWMutex
 	cond *sync.Cond
 	idx  map[string]Process
+	// refresh triggers a poll of the unit states, see Refresh.
+	refresh chan struct{}
 }
 
 func (m *unitManager) Add(p Process) {
@@ -58,42 +84,75 @@ func (m *unitManager) Delete(p Process) {
 }
 
 func (m *unitManager) Get(name string) Process {
-	m.mu.Lock()
+	m.mu.RLock()
 	p := m.idx[name]
-	m.mu.Unlock()
+	m.mu.RUnlock()
 	return p
 }
 
+// Names returns the names of all the units being tracked.
+func (m *unitManager) Names() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.idx))
+	for name := range m.idx {
+		names = append(names, name)
+	}
+	return names
+}
+
 func (m *processManager) Add(id string, p Process) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	sh := m.shard(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-	if _, ok := m.ls[id]; ok {
+	if _, ok := sh.ls[id]; ok {
 		return errdefs.ErrAlreadyExists
 	}
 
-	m.ls[id] = p
+	if sh.ls == nil {
+		sh.ls = make(map[string]Process)
+	}
+	sh.ls[id] = p
 	return nil
 }
 
 func (m *processManager) Get(id string) Process {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	return m.ls[id]
+	sh := m.shard(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.ls[id]
 }
 
 func (m *processManager) Delete(id string) {
-	m.mu.Lock()
-	delete(m.ls, id)
-	m.mu.Unlock()
+	sh := m.shard(id)
+	sh.mu.Lock()
+	delete(sh.ls, id)
+	sh.mu.Unlock()
+}
+
+func (m *processManager) Len() int {
+	var n int
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.Lock()
+		n += len(sh.ls)
+		sh.mu.Unlock()
+	}
+	return n
 }
 
+// Each calls do for every process.
+// Only one shard is locked at a time, processes added or removed while iterating may or may not be seen.
 func (m *processManager) Each(do func(p Process)) {
-	m.mu.Lock()
-	for _, p := range m.ls {
-		do(p)
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.Lock()
+		for _, p := range sh.ls {
+			do(p)
+		}
+		sh.mu.Unlock()
 	}
-	m.mu.Unlock()
 }
 
 type Process interface {
@@ -113,8 +172,91 @@ type Process interface {
 
 type CreateOptions struct {
 	// Native config
-	LogMode        string
-	SdNotifyEnable bool
+	LogMode         string
+	SdNotifyEnable  bool
+	UnitMode        options.UnitMode
+	Slice           string
+	Watchdog        time.Duration
+	KillMode        string
+	KillSignal      int
+	FinalKillSignal int
+	TimeoutStop     time.Duration
+	// KillGracePeriod is how long the shim waits for the process to exit after a SIGTERM before killing it, see
+	// escalateKill.
+	KillGracePeriod time.Duration
+	// Properties are extra unit properties from the container annotations.
+	Properties map[string]string
+	// User, Group and DynamicUser run the container unit as another user.
+	User        string
+	Group       string
+	DynamicUser bool
+	// ExecCgroup moves exec processes to the cgroup of their unit, ExecProperties are extra properties for exec units.
+	ExecCgroup     bool
+	ExecProperties map[string]string
+	// ExecTimeout is the maximum lifetime of exec processes, 0 for no limit.
+	ExecTimeout time.Duration
+	// StdioNoReader is the policy for stdout and stderr fifos nobody reads, see checkStdioOutput.
+	StdioNoReader string
+	// ExecJournal sends the output of exec processes without any stdio to the journal instead of discarding it.
+	ExecJournal bool
+	// ExecInherit fills in what exec process specs leave out from the process of the container, see inheritProcess.
+	ExecInherit bool
+	// Debug enables the runtime debug log for the container, see namespaceConfig.
+	Debug bool
+	// CRI is set for containers created by the CRI plugin.
+	CRI criContainer
+	// Name is the name the client gave the container, see metadataAnnotations.
+	Name string
+	// Networkd hands the host side of the container's interfaces to systemd-networkd with the .network options in
+	// NetworkdSettings ("Section.Key"), see networkdAnnotation.
+	Networkd         bool
+	NetworkdSettings map[string]string
+	// Accounting are the kinds of accounting systemd keeps for the container unit, see accountingAnnotation.
+	Accounting []string
+	// The CNI network the shim sets up for the container, see cniConfDirAnnotation.
+	CNIConfDir string
+	CNINetwork string
+	CNIIfName  string
+	CNIBinDirs []string
+	// UnitHooks runs the poststart and poststop OCI hooks in units instead of the runtime, see hooksAnnotation.
+	UnitHooks bool
+	// UnitNameTemplate is the naming scheme of the container's units, see validateUnitNameTemplate.
+	// It is kept with the container so its units keep their names when the shim's template changes.
+	UnitNameTemplate string
+	// OOMPolicy and the managed oom settings control how out of memory situations are handled, see oomOptions.
+	OOMPolicy                     string
+	ManagedOOMMemoryPressure      string
+	ManagedOOMMemoryPressureLimit string
+	ManagedOOMSwap                string
+	// LogRateLimitInterval and LogRateLimitBurst are the journal rate limit and MaxLogLineSize the longest line of
+	// output copied to the stdio, see logLimitAnnotations.
+	LogRateLimitInterval time.Duration
+	LogRateLimitBurst    uint32
+	MaxLogLineSize       int
+	// Restart and RestartSec let systemd restart the container when it exits, see restartAnnotation.
+	Restart    string
+	RestartSec time.Duration
+	// TTYSocket and TTYSocketMode set where the console socket is created and its mode, see ttySocketAnnotation.
+	TTYSocket     string
+	TTYSocketMode uint32
+	// IOBufferSize is the copy buffer size of the tty helper, see ioBufferSizeAnnotation.
+	IOBufferSize uint32
+	// LazyPagesServer is the page server a restored container gets its memory from lazily, see lazyPagesAnnotation.
+	LazyPagesServer string
+	// VerifyRootfs are the checks of the rootfs done before the container is created, see verifyRootfsAnnotation.
+	VerifyRootfs []string
+	// BindsTo are the units holding the namespaces the container joins, see namespaceUnits.
+	BindsTo []string
+	// MountFlags, PrivateTmp and ProtectSystem are the mount namespace settings of the unit, see mountNamespaceOptions.
+	MountFlags    string
+	PrivateTmp    bool
+	ProtectSystem string
+	// NullIO runs the container without the stdio from containerd, see nullIOAnnotations.
+	NullIO bool
+	// ExtendedStats makes Stats return options.Metrics, see extendedStatsAnnotation.
+	ExtendedStats bool
+	// ConfigDefaults are the options the container did not set itself, they follow the shim config, see reloadConfig.
+	ConfigDefaults configDefaults
 
 	// From runc types
 	BinaryName          string
@@ -136,6 +278,39 @@ type CreateOptions struct {
 	ShimCgroup          string
 }
 
+// setRuncOptions sets the options of the runc shims, v is a *v2runcopts.Options or a *runctypes.CreateOptions.
+func (c *CreateOptions) setRuncOptions(v interface{}) {
+	switch vv := v.(type) {
+	case *v2runcopts.Options:
+		c.NoPivotRoot = vv.NoPivotRoot
+		c.NoNewKeyring = vv.NoNewKeyring
+		c.IoUid = vv.IoUid
+		c.IoGid = vv.IoGid
+		c.BinaryName = vv.BinaryName
+		c.Root = vv.Root
+		c.CriuPath = vv.CriuPath
+		c.SystemdCgroup = vv.SystemdCgroup
+		c.CriuImagePath = vv.CriuImagePath
+		c.CriuWorkPath = vv.CriuWorkPath
+		c.ShimCgroup = vv.ShimCgroup
+	case *runctypes.CreateOptions:
+		c.NoPivotRoot = vv.NoPivotRoot
+		c.NoNewKeyring = vv.NoNewKeyring
+		c.IoUid = vv.IoUid
+		c.IoGid = vv.IoGid
+		c.CriuImagePath = vv.CriuImagePath
+		c.CriuWorkPath = vv.CriuWorkPath
+		c.OpenTcp = vv.OpenTcp
+		c.ExternalUnixSockets = vv.ExternalUnixSockets
+		c.FileLocks = vv.FileLocks
+		c.Terminal = vv.Terminal
+		c.EmptyNamespaces = vv.EmptyNamespaces
+		c.CgroupsMode = vv.CgroupsMode
+		c.ShimCgroup = vv.ShimCgroup
+	}
+}
+
+// RestoreArgs returns the arguments of `runc restore` for the criu options the container was created with.
 func (c CreateOptions) RestoreArgs() []string {
 	var args []string
 
@@ -151,16 +326,44 @@ func (c CreateOptions) RestoreArgs() []string {
 	if c.ExternalUnixSockets {
 		args = append(args, "--ext-unix-sk")
 	}
+	if c.Terminal {
+		// The checkpoint was taken with --shell-job, see CheckpointOpts.AllowTerminal.
+		args = append(args, "--shell-job")
+	}
 	for _, ns := range c.EmptyNamespaces {
 		args = append(args, "--empty-ns="+ns)
 	}
 	if c.CgroupsMode != "" {
 		args = append(args, "--manage-cgroups-mode="+c.CgroupsMode)
 	}
+	if c.LazyPagesServer != "" {
+		args = append(args, "--lazy-pages")
+	}
 
 	return args
 }
 
+// validateCriuOptions checks the options passed on to criu for a checkpoint or restore, runc only finds out when criu
+// is already running.
+func validateCriuOptions(emptyNamespaces []string, cgroupsMode string) error {
+	for _, ns := range emptyNamespaces {
+		switch specs.LinuxNamespaceType(ns) {
+		case specs.PIDNamespace, specs.NetworkNamespace, specs.MountNamespace, specs.IPCNamespace, specs.UTSNamespace, specs.UserNamespace, specs.CgroupNamespace:
+		default:
+			return fmt.Errorf("invalid empty namespace %q: %w", ns, errdefs.ErrInvalidArgument)
+		}
+	}
+	switch runc.CgroupMode(cgroupsMode) {
+	case "", runc.Soft, runc.Full, runc.Strict, criuCgroupsIgnore:
+	default:
+		return fmt.Errorf("invalid cgroups mode %q: %w", cgroupsMode, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// criuCgroupsIgnore leaves the cgroups alone, go-runc has no constant for it.
+const criuCgroupsIgnore runc.CgroupMode = "ignore"
+
 type process struct {
 	ns   string
 	id   string
@@ -176,9 +379,32 @@ type process struct {
 
 	opts CreateOptions
 
-	systemd *systemd.Conn
-	runc    *runc.Runc
-	ttyConn net.Conn
+	systemd  *sdConn
+	reloader *reloader
+	runc     *runc.Runc
+	runtime  *ociRuntime
+	ttyConn  net.Conn
+
+	// traceID is the trace the process was created in, it is recorded in the unit so the two can be correlated.
+	traceID string
+
+	// unitProps holds the properties used to start a transient unit.
+	unitProps []systemd.Property
+
+	// stopRelay stops the journal relay, see startLogRelay.
+	stopRelay context.CancelFunc
+
+	// controlGroup is the unit's cgroup once it is known, see waitMainPID. It is protected by mu.
+	controlGroup string
+	// killEscalation is set while a kill is being escalated, see escalateKill. It is protected by mu.
+	killEscalation bool
+	// killSignaled is set once the process was signaled while it had a kill grace period, and killForced once it was
+	// sent SIGKILL at the end of the grace period. Both are reported with the exit, see killReport. They are protected
+	// by mu.
+	killSignaled bool
+	killForced   bool
+	// runtimeLog keeps the end of the runtime's log, see runtimeError.
+	runtimeLog runtimeLog
 
 	mu      sync.Mutex
 	cond    *sync.Cond
@@ -199,21 +425,25 @@ func (p *process) ProcessState() pState {
 func (p *process) SetState(ctx context.Context, state pState) pState {
 	p.mu.Lock()
 	state.CopyTo(&p.state)
-	if p.state.ExitCode > 0 && !p.state.Exited() {
+	if p.state.Exited() && !p.state.ExitedAt.After(timeZero) {
 		p.state.ExitedAt = time.Now()
 	}
 	p.state.CopyTo(&state)
 	p.cond.Broadcast()
 	p.mu.Unlock()
+
+	if state.Exited() {
+		p.stopLogRelay()
+	}
 	return state
 }
 
 func (p *execProcess) Name() string {
-	return unitName(p.ns, p.parent.id+"-"+p.id, "exec")
+	return unitName(p.parent.opts.UnitNameTemplate, p.ns, p.parent.id+"-"+p.id, "exec")
 }
 
 func (p *initProcess) Name() string {
-	return unitName(p.ns, p.id, "init")
+	return unitName(p.opts.UnitNameTemplate, p.ns, p.id, p.opts.CRI.unitMod())
 }
 
 func (p *process) Pid() uint32 {
@@ -224,7 +454,22 @@ func (p *process) Pid() uint32 {
 }
 
 func (p *execProcess) Kill(ctx context.Context, sig int, all bool) error {
-	return p.systemd.KillUnitWithTarget(ctx, p.Name(), systemd.Main, int32(sig))
+	who := systemd.Main
+	if all {
+		who = systemd.All
+	}
+	if p.Pid() == 0 {
+		return fmt.Errorf("not started: %w", errdefs.ErrFailedPrecondition)
+	}
+	if p.ProcessState().Exited() {
+		return fmt.Errorf("process already finished: %w", errdefs.ErrNotFound)
+	}
+
+	if err := p.systemd.KillUnitWithTarget(ctx, p.Name(), who, int32(sig)); err != nil {
+		return killError(err)
+	}
+	p.escalateKill(ctx, p.Name(), who, sig)
+	return nil
 }
 
 func (p *initProcess) Kill(ctx context.Context, sig int, all bool) error {
@@ -237,18 +482,22 @@ func (p *initProcess) Kill(ctx context.Context, sig int, all bool) error {
 	}
 
 	if p.ProcessState().Exited() {
-		return errdefs.ErrNotFound
+		return fmt.Errorf("process already finished: %w", errdefs.ErrNotFound)
 	}
 
 	if err := p.systemd.KillUnitWithTarget(ctx, p.Name(), who, int32(sig)); err != nil {
-		if strings.Contains(err.Error(), "no main process") {
-			return errdefs.ErrNotFound
+		if err := killError(err); errdefs.IsNotFound(err) {
+			return err
 		}
-		if _, err2 := p.runc.State(ctx, p.id); err2 != nil && strings.Contains(err2.Error(), "does not exist") {
+		err2 := runcCall(ctx, func(ctx context.Context) error {
+			_, err := p.runc.State(ctx, p.id)
+			return err
+		})
+		if err2 != nil && strings.Contains(err2.Error(), "does not exist") {
 			return fmt.Errorf("could not get runc state: %w", errdefs.ErrNotFound)
 		}
 		units, e := p.systemd.ListUnitsByNamesContext(ctx, []string{p.Name()})
-		if err != nil {
+		if e != nil {
 			log.G(ctx).WithError(e).Errorf("Failed to list units")
 		} else {
 			if len(units) == 0 {
@@ -258,7 +507,7 @@ func (p *initProcess) Kill(ctx context.Context, sig int, all bool) error {
 				if u.Name != p.Name() {
 					continue
 				}
-				if u.ActiveState != "running" {
+				if u.ActiveState != "active" {
 					return fmt.Errorf("not running: %w", errdefs.ErrFailedPrecondition)
 				}
 			}
@@ -266,6 +515,7 @@ func (p *initProcess) Kill(ctx context.Context, sig int, all bool) error {
 		return err
 	}
 
+	p.escalateKill(ctx, p.Name(), who, sig)
 	return nil
 }
 
@@ -279,11 +529,38 @@ type initProcess struct {
 	parentCheckpoint string
 
 	noNewNamespace bool
+	// runtimeArgs are the arguments of the runtime in the ExecStart of the unit, kept to render the unit again when
+	// the shim config is reloaded.
+	runtimeArgs []string
+
+	// cniState is where the CNI network of the container is recorded, see cniAdd.
+	cniState string
+
+	// resources are the unit options for the container resources in the spec, see resourceOptions.
+	resources []*unit.UnitOption
+
+	// spec caches the container spec, see bundleSpec.
+	specMu sync.Mutex
+	spec   *specs.Spec
+
+	// freezeState is set while the container is being paused or is paused.
+	freezeState string
+
+	// oomEventsPath is the cgroup file used to track oom kills, see checkOOM.
+	oomEventsPath string
+	oomKills      uint64
 
 	execs *processManager
 
 	sendEvent func(ctx context.Context, ns string, evt interface{})
-	shimLog   io.Writer
+	// saveTask persists the container state, it is needed when the state changes outside of a request.
+	saveTask func(p *initProcess) error
+	shimLog  io.Writer
+
+	// restarts is the number of restarts by systemd seen so far, see checkRestart. It is protected by mu.
+	restarts uint32
+	// runtimeLock is held while the shim manages the container, see lockRuntimeState.
+	runtimeLock *os.File
 }
 
 func (p *initProcess) LogWriter() io.Writer {
@@ -309,6 +586,19 @@ func (p *initProcess) SetState(ctx context.Context, state pState) pState {
 		p.cond.Broadcast()
 		// If the init helper process exited, this should not yield a task exit event as the task never actually started.
 		if st.Status != exitedInit {
+			// Make sure an oom kill is reported before the exit.
+			// systemd-oomd kills the whole unit, which the kernel doesn't count, systemd reports it in the unit result.
+			if !p.checkOOM(ctx) && st.Result == serviceResultOOMKill {
+				log.G(ctx).Warn("Container was killed because it was out of memory")
+				p.sendEvent(ctx, p.ns, &eventsapi.TaskOOM{ContainerID: p.id})
+			}
+			if st.Result == serviceResultWatchdog {
+				log.G(ctx).Warn("Container was killed by the systemd watchdog")
+				p.sendEvent(ctx, p.ns, &options.TaskWatchdog{ContainerId: p.id, Pid: st.Pid})
+			}
+			if signaled, forced := p.killReport(); signaled {
+				p.sendEvent(ctx, p.ns, &options.TaskStopped{ContainerId: p.id, Id: p.id, Pid: st.Pid, Forced: forced})
+			}
 			p.sendEvent(ctx, p.ns, &eventsapi.TaskExit{
 				ContainerID: p.id,
 				ID:          p.id,
@@ -321,14 +611,20 @@ func (p *initProcess) SetState(ctx context.Context, state pState) pState {
 	return st
 }
 
-func (p *initProcess) Checkpoint(ctx context.Context, r *ptypes.Any) error {
+// Checkpoint dumps the container state with criu using `runc checkpoint`.
+// imagePath is used unless the checkpoint options specify one.
+// The image path is returned.
+func (p *initProcess) Checkpoint(ctx context.Context, imagePath string, r *ptypes.Any) (string, error) {
+	if !p.runtime.supportsCheckpoint() {
+		return "", fmt.Errorf("runtime %s does not support checkpoint: %w", p.runtime.Name, errdefs.ErrNotImplemented)
+	}
 	var opts runc.CheckpointOpts
-	var exit bool
+	var exit, preDump bool
 	if r != nil {
 		v, err := typeurl.UnmarshalAny(r)
 		if err != nil {
 			log.G(ctx).WithError(err).WithField("typeurl", r.TypeUrl).Debug("error unmarshalling *Any")
-			return err
+			return "", err
 		}
 		switch vv := v.(type) {
 		case *v2runcopts.CheckpointOptions:
@@ -341,6 +637,18 @@ func (p *initProcess) Checkpoint(ctx context.Context, r *ptypes.Any) error {
 			opts.Cgroups = runc.CgroupMode(vv.CgroupsMode)
 			opts.ImagePath = vv.ImagePath
 			opts.WorkDir = vv.WorkPath
+		case *options.CheckpointOptions:
+			exit = vv.Exit
+			preDump = vv.PreDump
+			opts.ParentPath = vv.ParentPath
+			opts.AllowOpenTCP = vv.OpenTcp
+			opts.AllowExternalUnixSockets = vv.ExternalUnixSockets
+			opts.AllowTerminal = vv.Terminal
+			opts.FileLocks = vv.FileLocks
+			opts.EmptyNamespaces = vv.EmptyNamespaces
+			opts.Cgroups = runc.CgroupMode(vv.CgroupsMode)
+			opts.ImagePath = vv.ImagePath
+			opts.WorkDir = vv.WorkPath
 		case *runctypes.CheckpointOptions:
 			exit = vv.Exit
 			opts.AllowOpenTCP = vv.OpenTcp
@@ -352,58 +660,99 @@ func (p *initProcess) Checkpoint(ctx context.Context, r *ptypes.Any) error {
 			opts.ImagePath = vv.ImagePath
 			opts.WorkDir = vv.WorkPath
 		default:
-			return fmt.Errorf("unknown checkpoint options type: %w", errdefs.ErrInvalidArgument)
+			return "", fmt.Errorf("unknown checkpoint options type: %w", errdefs.ErrInvalidArgument)
 		}
 	}
 
+	if opts.ImagePath == "" {
+		opts.ImagePath = imagePath
+	}
+	if opts.ImagePath == "" {
+		return "", fmt.Errorf("checkpoint image path is required: %w", errdefs.ErrInvalidArgument)
+	}
+	if err := os.MkdirAll(opts.ImagePath, 0700); err != nil {
+		return "", fmt.Errorf("error making checkpoint image dir: %w", err)
+	}
+
 	if opts.WorkDir == "" {
 		workDir := filepath.Join(p.root, "criu-work")
 		if err := os.MkdirAll(workDir, 0700); err != nil {
-			return fmt.Errorf("error making criu work dir: %w", err)
+			return "", fmt.Errorf("error making criu work dir: %w", err)
 		}
 		opts.WorkDir = workDir
 	}
 
+	if err := validateCriuOptions(opts.EmptyNamespaces, string(opts.Cgroups)); err != nil {
+		return "", err
+	}
+	if preDump && exit {
+		return "", fmt.Errorf("a pre-dump leaves the container running, it can't exit: %w", errdefs.ErrInvalidArgument)
+	}
+	// A checkpoint only builds on a pre-dump when the caller asks for it, the shim doesn't keep track of them: pre-dumps
+	// outlive the shim and the caller knows which images it still has.
+	if opts.ParentPath != "" {
+		parent, err := criuParentPath(opts.ImagePath, opts.ParentPath)
+		if err != nil {
+			return "", err
+		}
+		opts.ParentPath = parent
+	}
+
 	var actions []runc.CheckpointAction
 	if !exit {
 		actions = append(actions, runc.LeaveRunning)
 	}
+	if preDump {
+		actions = append(actions, runc.PreDump)
+	}
 
 	if err := p.runc.Checkpoint(ctx, p.id, &opts, actions...); err != nil {
+		err = p.runtimeError(ctx, err)
 		if p.runc.Debug {
 			f, err2 := os.ReadFile(filepath.Join(opts.WorkDir, "dump.log"))
 			if err2 == nil {
 				err = fmt.Errorf("%w: %s", err, string(f))
 			}
 		}
-		return err
+		return "", err
 	}
-	return nil
-}
-
-func (p *initProcess) Pause(ctx context.Context) error {
-	return p.runc.Pause(ctx, p.id)
-}
 
-func (p *initProcess) Resume(ctx context.Context) error {
-	return p.runc.Resume(ctx, p.id)
+	return opts.ImagePath, nil
 }
 
-func (p *initProcess) Pids(ctx context.Context) ([]*task.ProcessInfo, error) {
-	ls, err := p.runc.Ps(ctx, p.id)
-	if err != nil {
-		return nil, err
+// criuParentPath returns the path of the parent image for criu, which takes it relative to the image directory.
+func criuParentPath(image, parent string) (string, error) {
+	abs := parent
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(image, parent)
 	}
-
-	procs := make([]*task.ProcessInfo, 0, len(ls))
-	for _, p := range ls {
-		procs = append(procs, &task.ProcessInfo{Pid: uint32(p)})
+	if fi, err := os.Stat(abs); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("parent checkpoint %s is not a directory: %w", parent, errdefs.ErrInvalidArgument)
 	}
-	return procs, nil
+	if !filepath.IsAbs(parent) {
+		return parent, nil
+	}
+	absImage, err := filepath.Abs(image)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(absImage, parent)
 }
 
-func (p *initProcess) Update(ctx context.Context, res specs.LinuxResources) error {
-	return p.runc.Update(ctx, p.id, &res)
+// linkParentCheckpoint points the image at its parent image, criu follows the "parent" link in the image directory
+// to the pre-dump the checkpoint was built on when restoring.
+// The link is made by criu when checkpointing, this is for images that were moved or whose parent is elsewhere.
+func linkParentCheckpoint(image, parent string) error {
+	link := filepath.Join(image, "parent")
+	if _, err := os.Stat(link); err == nil {
+		return nil
+	}
+	rel, err := criuParentPath(image, parent)
+	if err != nil {
+		return err
+	}
+	os.Remove(link) // a dangling link from where the image was taken
+	return os.Symlink(rel, link)
 }
 
 type execProcess struct {
@@ -425,14 +774,26 @@ func (p *execProcess) getPid(ctx context.Context) (uint32, error) {
 	if err := p.LoadState(ctx); err != nil {
 		return 0, err
 	}
+	if pid := p.ProcessState().Pid; pid > 0 || p.ProcessState().Exited() {
+		return pid, nil
+	}
 
-	return p.ProcessState().Pid, nil
+	// The exit state is written by the helper after the unit is started, systemd knows the pid before that.
+	main, err := waitMainPID(ctx, p.systemd, p.Name())
+	if err != nil {
+		return 0, err
+	}
+	p.setMainPID(main)
+	return main.Pid, nil
 }
 
 func (p *execProcess) SetState(ctx context.Context, state pState) pState {
 	st := p.process.SetState(ctx, state)
 	if st.Exited() {
 		p.cond.Broadcast()
+		if signaled, forced := p.killReport(); signaled {
+			p.parent.sendEvent(ctx, p.ns, &options.TaskStopped{ContainerId: p.parent.id, Id: p.execID, Pid: st.Pid, Forced: forced})
+		}
 		p.parent.sendEvent(ctx, p.ns, &eventsapi.TaskExit{
 			ContainerID: p.parent.id,
 			ID:          p.execID,
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/runtime/linux/runctypes"
+	v2runcopts "github.com/containerd/containerd/runtime/v2/runc/options"
+	runc "github.com/containerd/go-runc"
+	"github.com/containerd/typeurl"
+)
+
+func TestRestoreArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts interface{}
+		want []string
+	}{
+		{
+			name: "v2 options",
+			opts: &v2runcopts.Options{NoPivotRoot: true, CriuPath: "/usr/sbin/criu"},
+			want: []string{"--no-pivot"},
+		},
+		{
+			name: "v2 options without criu flags",
+			opts: &v2runcopts.Options{},
+		},
+		{
+			name: "runctypes",
+			opts: &runctypes.CreateOptions{
+				NoPivotRoot:         true,
+				OpenTcp:             true,
+				FileLocks:           true,
+				ExternalUnixSockets: true,
+				Terminal:            true,
+				EmptyNamespaces:     []string{"network", "ipc"},
+				CgroupsMode:         "soft",
+			},
+			want: []string{
+				"--no-pivot",
+				"--tcp-established",
+				"--file-locks",
+				"--ext-unix-sk",
+				"--shell-job",
+				"--empty-ns=network",
+				"--empty-ns=ipc",
+				"--manage-cgroups-mode=soft",
+			},
+		},
+		{
+			name: "runctypes shell job",
+			opts: &runctypes.CreateOptions{Terminal: true},
+			want: []string{"--shell-job"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts CreateOptions
+			opts.setRuncOptions(tc.opts)
+			if got := opts.RestoreArgs(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateCriuOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		emptyNS     []string
+		cgroupsMode string
+		valid       bool
+	}{
+		{name: "none", valid: true},
+		{name: "namespaces", emptyNS: []string{"pid", "network", "mount", "ipc", "uts", "user", "cgroup"}, valid: true},
+		{name: "cgroups modes", cgroupsMode: "strict", valid: true},
+		{name: "ignore cgroups", cgroupsMode: "ignore", valid: true},
+		{name: "unknown namespace", emptyNS: []string{"net"}},
+		{name: "empty namespace", emptyNS: []string{""}},
+		{name: "unknown cgroups mode", cgroupsMode: "hard"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateCriuOptions(tc.emptyNS, tc.cgroupsMode)
+			if tc.valid {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, errdefs.ErrInvalidArgument) {
+				t.Fatalf("expected invalid argument, got %v", err)
+			}
+		})
+	}
+}
+
+// fakeRunc returns a runc that writes its arguments to the returned file, one per line.
+func fakeRunc(t *testing.T) (*runc.Runc, string) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "args")
+	bin := filepath.Join(dir, "runc")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + out + "\n"
+	if err := os.WriteFile(bin, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return &runc.Runc{Command: bin}, out
+}
+
+func TestCheckpointArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    interface{}
+		want    []string
+		invalid bool
+	}{
+		{
+			name: "v2 options",
+			opts: &v2runcopts.CheckpointOptions{
+				OpenTcp:             true,
+				ExternalUnixSockets: true,
+				Terminal:            true,
+				FileLocks:           true,
+				EmptyNamespaces:     []string{"network"},
+				CgroupsMode:         "full",
+			},
+			want: []string{"--tcp-established", "--ext-unix-sk", "--shell-job", "--file-locks", "--manage-cgroups-mode", "full", "--empty-ns", "network", "--leave-running"},
+		},
+		{
+			name: "v2 options exit",
+			opts: &v2runcopts.CheckpointOptions{Exit: true},
+		},
+		{
+			name: "runctypes",
+			opts: &runctypes.CheckpointOptions{
+				OpenTcp:         true,
+				Terminal:        true,
+				EmptyNamespaces: []string{"ipc", "uts"},
+			},
+			want: []string{"--tcp-established", "--shell-job", "--empty-ns", "ipc", "--empty-ns", "uts", "--leave-running"},
+		},
+		{
+			name: "runctypes exit",
+			opts: &runctypes.CheckpointOptions{Exit: true, FileLocks: true, ExternalUnixSockets: true},
+			want: []string{"--ext-unix-sk", "--file-locks"},
+		},
+		{
+			name:    "invalid empty namespace",
+			opts:    &runctypes.CheckpointOptions{EmptyNamespaces: []string{"net"}},
+			invalid: true,
+		},
+		{
+			name:    "invalid cgroups mode",
+			opts:    &v2runcopts.CheckpointOptions{CgroupsMode: "hard"},
+			invalid: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, out := fakeRunc(t)
+			root := t.TempDir()
+			p := &initProcess{process: &process{id: "test", root: root, runc: r, runtime: &ociRuntime{Name: "runc"}}}
+
+			a, err := typeurl.MarshalAny(tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			image := filepath.Join(root, "image")
+			_, err = p.Checkpoint(context.Background(), image, a)
+			if tc.invalid {
+				if !errors.Is(err, errdefs.ErrInvalidArgument) {
+					t.Fatalf("expected invalid argument, got %v", err)
+				}
+				if _, err := os.Stat(out); err == nil {
+					t.Fatal("runc was called with invalid options")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := append([]string{"checkpoint", "--image-path", image, "--work-path", filepath.Join(root, "criu-work")}, tc.want...)
+			want = append(want, "test")
+			if got := strings.Fields(string(data)); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/coreos/go-systemd/unit"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	dbus "github.com/godbus/dbus/v5"
+)
+
+// unitPropertyAnnotationPrefix is the prefix for annotations that set arbitrary properties on the container unit,
+// e.g. "systemd.property.CPUWeight=200".
+// Resource control properties are accepted the same as they would be for a slice, they just apply to the container unit.
+const unitPropertyAnnotationPrefix = "systemd.property."
+
+// managedProperties are set by the shim itself and can't be overridden, changing them would break how we track the container.
+var managedProperties = map[string]bool{
+	"Description":     true,
+	"Documentation":   true,
+	"Type":            true,
+	"ExecStart":       true,
+	"ExecStartPre":    true,
+	"ExecStartPost":   true,
+	"ExecStop":        true,
+	"ExecStopPost":    true,
+	"ExecReload":      true,
+	"PIDFile":         true,
+	"GuessMainPID":    true,
+	"RemainAfterExit": true,
+	"Delegate":        true,
+	"PrivateMounts":   true,
+	"NotifyAccess":    true,
+	"Environment":     true,
+	"User":            true,
+	"Group":           true,
+	"DynamicUser":     true,
+	"EnvironmentFile": true,
+	"Slice":           true,
+	"StandardInput":   true,
+	"StandardOutput":  true,
+	"StandardError":   true,
+	"WatchdogSec":     true,
+	"Restart":         true,
+	"RestartSec":      true,
+}
+
+// dependencyProperties are the unit dependencies that can be set with the property annotations, e.g.
+// "systemd.property.After=network-online.target". They go in the [Unit] section and take a space separated list of
+// unit names.
+var dependencyProperties = map[string]bool{
+	"After":     true,
+	"Before":    true,
+	"Wants":     true,
+	"Requires":  true,
+	"Requisite": true,
+	"BindsTo":   true,
+	"PartOf":    true,
+	"Conflicts": true,
+}
+
+// unitPropertyAnnotations returns the unit properties requested by the container annotations.
+func unitPropertyAnnotations(annotations map[string]string) (map[string]string, error) {
+	return propertyAnnotations(annotations, unitPropertyAnnotationPrefix)
+}
+
+// propertyAnnotations returns the unit properties from the annotations with the given prefix.
+func propertyAnnotations(annotations map[string]string, prefix string) (map[string]string, error) {
+	var props map[string]string
+	for k, v := range annotations {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(k, prefix)
+		if err := validateUnitProperty(name, v); err != nil {
+			return nil, fmt.Errorf("annotation %s: %w", k, err)
+		}
+		if props == nil {
+			props = make(map[string]string)
+		}
+		props[name] = v
+	}
+	return props, nil
+}
+
+func validateUnitProperty(name, value string) error {
+	if name == "" {
+		return fmt.Errorf("missing property name: %w", errdefs.ErrInvalidArgument)
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'A' && c <= 'Z':
+		case (c >= 'a' && c <= 'z' || c >= '0' && c <= '9') && i > 0:
+		default:
+			return fmt.Errorf("invalid property name %q: %w", name, errdefs.ErrInvalidArgument)
+		}
+	}
+	if managedProperties[name] {
+		return fmt.Errorf("property %s is managed by the shim: %w", name, errdefs.ErrInvalidArgument)
+	}
+	if value == "" || strings.ContainsAny(value, "\n\r") {
+		return fmt.Errorf("invalid value for property %s: %w", name, errdefs.ErrInvalidArgument)
+	}
+	if dependencyProperties[name] {
+		for _, u := range strings.Fields(value) {
+			if err := validateDependency(u); err != nil {
+				return fmt.Errorf("property %s: %w", name, err)
+			}
+		}
+		return nil
+	}
+	// Values are checked for unit files too, a bad one fails the create instead of being ignored by systemd.
+	conv, ok := propertyTypes[name]
+	if !ok {
+		return fmt.Errorf("property %s is not supported: %w", name, errdefs.ErrInvalidArgument)
+	}
+	if _, err := conv(name, value); err != nil {
+		return fmt.Errorf("property %s: %w", name, err)
+	}
+	return nil
+}
+
+// validateDependency checks the name of a unit the container depends on.
+// The unit doesn't have to exist, systemd handles missing units according to the kind of dependency.
+func validateDependency(name string) error {
+	i := strings.LastIndexByte(name, '.')
+	if i <= 0 || i == len(name)-1 {
+		return fmt.Errorf("invalid unit name %q, it must include the unit type: %w", name, errdefs.ErrInvalidArgument)
+	}
+	for _, c := range name {
+		if !validUnitNameChar(c) && c != '@' && c != '\\' {
+			return fmt.Errorf("invalid character %q in unit name %q: %w", c, name, errdefs.ErrInvalidArgument)
+		}
+	}
+	return nil
+}
+
+// propertyOptions converts the extra unit properties to unit options.
+// They are sorted so the unit is the same every time it is generated.
+func propertyOptions(props map[string]string) []*unit.UnitOption {
+	names := make([]string, 0, len(props))
+	for k := range props {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	opts := make([]*unit.UnitOption, 0, len(names))
+	for _, k := range names {
+		section := "Service"
+		if dependencyProperties[k] {
+			section = "Unit"
+		}
+		opts = append(opts, unit.NewUnitOption(section, k, props[k]))
+	}
+	return opts
+}
+
+// propertyConverter converts the value of a property as in a unit file to the D-Bus value systemd expects for it.
+type propertyConverter func(name, v string) (systemd.Property, error)
+
+// propertyTypes are the properties that can be set on container units with their D-Bus type.
+// systemd requires the exact type of each property of a transient unit, which can't be told from the value (e.g.
+// "1" for a boolean or a negative Nice=), so properties that are not in here are rejected. The managed properties
+// are in here for the options the shim sets itself.
+var propertyTypes = map[string]propertyConverter{
+	// Service and execution
+	"Type":                  stringProperty,
+	"PIDFile":               stringProperty,
+	"NotifyAccess":          stringProperty,
+	"Restart":               stringProperty,
+	"User":                  stringProperty,
+	"Group":                 stringProperty,
+	"DynamicUser":           boolProperty,
+	"Description":           stringProperty,
+	"Slice":                 stringProperty,
+	"StandardInput":         stringProperty,
+	"StandardOutput":        stringProperty,
+	"StandardError":         stringProperty,
+	"SyslogIdentifier":      stringProperty,
+	"WorkingDirectory":      stringProperty,
+	"KillMode":              stringProperty,
+	"SendSIGKILL":           boolProperty,
+	"SendSIGHUP":            boolProperty,
+	"OOMPolicy":             stringProperty,
+	"OOMScoreAdjust":        intProperty(-1000, 1000),
+	"Nice":                  intProperty(-20, 19),
+	"CPUSchedulingPolicy":   stringProperty,
+	"CPUSchedulingPriority": intProperty(0, 99),
+	"IOSchedulingClass":     stringProperty,
+	"IOSchedulingPriority":  intProperty(0, 7),
+	"Delegate":              boolProperty,
+	"RemainAfterExit":       boolProperty,
+	"GuessMainPID":          boolProperty,
+	"PrivateMounts":         boolProperty,
+	"PrivateTmp":            boolProperty,
+	"PrivateDevices":        boolProperty,
+	"PrivateNetwork":        boolProperty,
+	"ProtectSystem":         stringProperty,
+	"ProtectHome":           stringProperty,
+	"ProtectKernelTunables": boolProperty,
+	"ProtectKernelModules":  boolProperty,
+	"ProtectControlGroups":  boolProperty,
+	"NoNewPrivileges":       boolProperty,
+	"LockPersonality":       boolProperty,
+	"RestrictRealtime":      boolProperty,
+	"MountFlags":            mountFlagsProperty,
+	// Time spans, systemd takes them in microseconds
+	"TimeoutStartSec":         timespanProperty,
+	"TimeoutStopSec":          timespanProperty,
+	"TimeoutAbortSec":         timespanProperty,
+	"WatchdogSec":             timespanProperty,
+	"RuntimeMaxSec":           timespanProperty,
+	"RestartSec":              timespanProperty,
+	"LogRateLimitIntervalSec": timespanProperty,
+	"CPUQuotaPeriodSec":       timespanProperty,
+	// Signals
+	"KillSignal":        signalProperty,
+	"FinalKillSignal":   signalProperty,
+	"RestartKillSignal": signalProperty,
+	"WatchdogSignal":    signalProperty,
+	// Logging
+	"LogRateLimitBurst": uint32Property,
+	"LogLevelMax":       stringProperty,
+	"LogExtraFields":    logExtraFieldsProperty,
+	// Resource control
+	"CPUAccounting":       boolProperty,
+	"MemoryAccounting":    boolProperty,
+	"IOAccounting":        boolProperty,
+	"IPAccounting":        boolProperty,
+	"TasksAccounting":     boolProperty,
+	"CPUWeight":           uint64Property,
+	"StartupCPUWeight":    uint64Property,
+	"CPUShares":           uint64Property,
+	"CPUQuota":            cpuQuotaProperty,
+	"AllowedCPUs":         cpuSetProperty,
+	"AllowedMemoryNodes":  cpuSetProperty,
+	"MemoryMin":           sizeProperty,
+	"MemoryLow":           sizeProperty,
+	"MemoryHigh":          sizeProperty,
+	"MemoryMax":           sizeProperty,
+	"MemorySwapMax":       sizeProperty,
+	"MemoryLimit":         sizeProperty,
+	"TasksMax":            tasksProperty,
+	"IOWeight":            uint64Property,
+	"StartupIOWeight":     uint64Property,
+	"IODeviceWeight":      ioDeviceProperty(parseUintValue),
+	"IOReadBandwidthMax":  ioDeviceProperty(parseIOLimit),
+	"IOWriteBandwidthMax": ioDeviceProperty(parseIOLimit),
+	"IOReadIOPSMax":       ioDeviceProperty(parseIOLimit),
+	"IOWriteIOPSMax":      ioDeviceProperty(parseIOLimit),
+	"DevicePolicy":        stringProperty,
+	"DeviceAllow":         deviceAllowProperty,
+	// systemd-oomd
+	"ManagedOOMMemoryPressure":      stringProperty,
+	"ManagedOOMMemoryPressureLimit": pressureLimitProperty,
+	"ManagedOOMSwap":                stringProperty,
+}
+
+// transientProperty converts a unit file property to what StartTransientUnit expects, see propertyTypes.
+func transientProperty(name, v string) (systemd.Property, error) {
+	conv, ok := propertyTypes[name]
+	if !ok {
+		return systemd.Property{}, fmt.Errorf("property %s is not supported: %w", name, errdefs.ErrInvalidArgument)
+	}
+	prop, err := conv(name, v)
+	if err != nil {
+		return systemd.Property{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return prop, nil
+}
+
+func stringProperty(name, v string) (systemd.Property, error) {
+	return systemd.Property{Name: name, Value: dbus.MakeVariant(v)}, nil
+}
+
+func boolProperty(name, v string) (systemd.Property, error) {
+	b, err := parseUnitBool(v)
+	if err != nil {
+		return systemd.Property{}, err
+	}
+	return systemd.Property{Name: name, Value: dbus.MakeVariant(b)}, nil
+}
+
+func uint64Property(name, v string) (systemd.Property, error) {
+	n, err := parseUintValue(v)
+	if err != nil {
+		return systemd.Property{}, err
+	}
+	return systemd.Property{Name: name, Value: dbus.MakeVariant(n)}, nil
+}
+
+func uint32Property(name, v string) (systemd.Property, error) {
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return systemd.Property{}, fmt.Errorf("invalid value %q: %w", v, errdefs.ErrInvalidArgument)
+	}
+	return systemd.Property{Name: name, Value: dbus.MakeVariant(uint32(n))}, nil
+}
+
+// intProperty returns the converter for a signed integer property with the given bounds.
+func intProperty(min, max int) propertyConverter {
+	return func(name, v string) (systemd.Property, error) {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min || n > max {
+			return systemd.Property{}, fmt.Errorf("invalid value %q, it must be between %d and %d: %w", v, min, max, errdefs.ErrInvalidArgument)
+		}
+		return systemd.Property{Name: name, Value: dbus.MakeVariant(int32(n))}, nil
+	}
+}
+
+func timespanProperty(name, v string) (systemd.Property, error) {
+	usec, err := parseUnitDuration(v)
+	if err != nil {
+		return systemd.Property{}, err
+	}
+	return systemd.Property{Name: strings.TrimSuffix(name, "Sec") + "USec", Value: dbus.MakeVariant(usec)}, nil
+}
+
+func signalProperty(name, v string) (systemd.Property, error) {
+	sig, err := parseSignal(v)
+	if err != nil {
+		return systemd.Property{}, err
+	}
+	return systemd.Property{Name: name, Value: dbus.MakeVariant(int32(sig))}, nil
+}
+
+func mountFlagsProperty(name, v string) (systemd.Property, error) {
+	flags, ok := mountPropagationFlags[v]
+	if !ok {
+		return systemd.Property{}, fmt.Errorf("invalid value %q: %w", v, errdefs.ErrInvalidArgument)
+	}
+	return systemd.Property{Name: name, Value: dbus.MakeVariant(flags)}, nil
+}
+
+func cpuQuotaProperty(_, v string) (systemd.Property, error) {
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+	if err != nil || !strings.HasSuffix(v, "%") || pct <= 0 {
+		return systemd.Property{}, fmt.Errorf("invalid CPUQuota %q: %w", v, errdefs.ErrInvalidArgument)
+	}
+	return systemd.Property{Name: "CPUQuotaPerSecUSec", Value: dbus.MakeVariant(uint64(pct * 10000))}, nil
+}
+
+func cpuSetProperty(name, v string) (systemd.Property, error) {
+	mask, err := parseCPUSet(v)
+	if err != nil {
+		return systemd.Property{}, err
+	}
+	return systemd.Property{Name: name, Value: dbus.MakeVariant(mask)}, nil
+}
+
+// sizeProperty converts a memory limit: a size, "infinity", or a percentage of the physical memory, which systemd
+// takes as a fraction of 2^32 in the property with the Scale suffix.
+func sizeProperty(name, v string) (systemd.Property, error) {
+	if strings.HasSuffix(v, "%") {
+		return scaleProperty(name+"Scale", v)
+	}
+	if v == "infinity" {
+		return systemd.Property{Name: name, Value: dbus.MakeVariant(uint64(math.MaxUint64))}, nil
+	}
+	n, err := parseByteSize(v)
+	if err != nil {
+		return systemd.Property{}, fmt.Errorf("invalid size %q: %w", v, errdefs.ErrInvalidArgument)
+	}
+	return systemd.Property{Name: name, Value: dbus.MakeVariant(n)}, nil
+}
+
+// tasksProperty converts TasksMax, a number, "infinity" or a percentage of the system's limit.
+func tasksProperty(name, v string) (systemd.Property, error) {
+	if strings.HasSuffix(v, "%") {
+		return scaleProperty(name+"Scale", v)
+	}
+	return uint64Property(name, v)
+}
+
+func pressureLimitProperty(name, v string) (systemd.Property, error) {
+	return scaleProperty(name, v)
+}
+
+// scaleProperty converts a percentage to a fraction of 2^32.
+func scaleProperty(name, v string) (systemd.Property, error) {
+	pct, err := parsePercent(v)
+	if err != nil {
+		return systemd.Property{}, err
+	}
+	return systemd.Property{Name: name, Value: dbus.MakeVariant(uint32(pct / 100 * math.MaxUint32))}, nil
+}
+
+// ioDeviceValue is an entry of the per device IO properties, "a(st)" over D-Bus.
+type ioDeviceValue struct {
+	Path  string
+	Value uint64
+}
+
+// ioDeviceProperty returns the converter for a per device IO property, "<device> <value>" with the value parsed by
+// parse.
+func ioDeviceProperty(parse func(string) (uint64, error)) propertyConverter {
+	return func(name, v string) (systemd.Property, error) {
+		f := strings.Fields(v)
+		if len(f) != 2 || !strings.HasPrefix(f[0], "/") {
+			return systemd.Property{}, fmt.Errorf("invalid value %q, expected a device path and a value: %w", v, errdefs.ErrInvalidArgument)
+		}
+		n, err := parse(f[1])
+		if err != nil {
+			return systemd.Property{}, err
+		}
+		return systemd.Property{Name: name, Value: dbus.MakeVariant([]ioDeviceValue{{Path: f[0], Value: n}})}, nil
+	}
+}
+
+func parseUintValue(v string) (uint64, error) {
+	if v == "infinity" {
+		return math.MaxUint64, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q: %w", v, errdefs.ErrInvalidArgument)
+	}
+	return n, nil
+}
+
+// parseIOLimit parses an IO bandwidth or IOPS limit, systemd takes the suffixes as powers of 1000 for these.
+func parseIOLimit(v string) (uint64, error) {
+	if v == "infinity" {
+		return math.MaxUint64, nil
+	}
+	n, err := parseSize(v, 1000)
+	if err != nil {
+		return 0, fmt.Errorf("invalid limit %q: %w", v, errdefs.ErrInvalidArgument)
+	}
+	return n, nil
+}
+
+func deviceAllowProperty(name, v string) (systemd.Property, error) {
+	f := strings.Fields(v)
+	if len(f) == 0 || len(f) > 2 {
+		return systemd.Property{}, fmt.Errorf("invalid DeviceAllow %q: %w", v, errdefs.ErrInvalidArgument)
+	}
+	e := deviceAllow{Path: f[0], Permissions: "rwm"}
+	if len(f) == 2 {
+		e.Permissions = f[1]
+	}
+	return systemd.Property{Name: name, Value: dbus.MakeVariant([]deviceAllow{e})}, nil
+}
+
+func logExtraFieldsProperty(name, v string) (systemd.Property, error) {
+	if uq, err := strconv.Unquote(v); err == nil {
+		v = uq
+	}
+	if !strings.Contains(v, "=") {
+		return systemd.Property{}, fmt.Errorf("invalid LogExtraFields %q, expected FIELD=value: %w", v, errdefs.ErrInvalidArgument)
+	}
+	return systemd.Property{Name: name, Value: dbus.MakeVariant([][]byte{[]byte(v)})}, nil
+}
+
+// parseByteSize parses sizes with the 1024 based suffixes systemd accepts, e.g. "512M".
+func parseByteSize(s string) (uint64, error) {
+	return parseSize(s, 1024)
+}
+
+// parseSize parses a size with the suffixes systemd accepts (K, M, G, T, P, E) as powers of base.
+func parseSize(s string, base uint64) (uint64, error) {
+	const suffixes = "KMGTPE"
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	mult := uint64(1)
+	if i := strings.IndexByte(suffixes, s[len(s)-1]); i >= 0 {
+		for ; i >= 0; i-- {
+			mult *= base
+		}
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxUint64/mult {
+		return 0, fmt.Errorf("size out of range: %s", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+func TestTransientProperty(t *testing.T) {
+	for _, tc := range []struct {
+		name, value string
+		wantName    string
+		want        interface{}
+		invalid     bool
+	}{
+		{name: "CPUWeight", value: "200", want: uint64(200)},
+		{name: "MemoryAccounting", value: "1", want: true},
+		{name: "PrivateTmp", value: "no", want: false},
+		{name: "Nice", value: "-5", want: int32(-5)},
+		{name: "OOMScoreAdjust", value: "-1000", want: int32(-1000)},
+		{name: "OOMScoreAdjust", value: "1001", invalid: true},
+		{name: "MemoryMax", value: "512M", want: uint64(512 << 20)},
+		{name: "MemoryMax", value: "infinity", want: uint64(math.MaxUint64)},
+		{name: "MemoryHigh", value: "50%", wantName: "MemoryHighScale", want: uint32(math.MaxUint32 / 2)},
+		{name: "TasksMax", value: "100", want: uint64(100)},
+		{name: "CPUQuota", value: "50%", wantName: "CPUQuotaPerSecUSec", want: uint64(500000)},
+		{name: "TimeoutStopSec", value: "10", wantName: "TimeoutStopUSec", want: uint64(10000000)},
+		{name: "KillMode", value: "mixed", want: "mixed"},
+		{name: "IOReadBandwidthMax", value: "/dev/sda 1M", want: []ioDeviceValue{{Path: "/dev/sda", Value: 1000000}}},
+		{name: "IOWriteIOPSMax", value: "/dev/sda infinity", want: []ioDeviceValue{{Path: "/dev/sda", Value: math.MaxUint64}}},
+		{name: "IOReadBandwidthMax", value: "1M", invalid: true},
+		{name: "CPUWeight", value: "yes", invalid: true},
+		{name: "SomethingNew", value: "1", invalid: true},
+	} {
+		t.Run(tc.name+"="+tc.value, func(t *testing.T) {
+			prop, err := transientProperty(tc.name, tc.value)
+			if tc.invalid {
+				if !errdefs.IsInvalidArgument(err) {
+					t.Fatalf("expected invalid argument, got %v", err)
+				}
+				if err := validateUnitProperty(tc.name, tc.value); !errdefs.IsInvalidArgument(err) {
+					t.Fatalf("expected the annotation to be rejected, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantName := tc.wantName
+			if wantName == "" {
+				wantName = tc.name
+			}
+			if prop.Name != wantName {
+				t.Errorf("got property %s, want %s", prop.Name, wantName)
+			}
+			if got := prop.Value.Value(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v, want %#v", got, tc.want)
+			}
+			if err := validateUnitProperty(tc.name, tc.value); err != nil {
+				t.Errorf("annotation rejected: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+)
+
+// cgroupPressure reads the cpu, memory and io pressure of the cgroup at dir.
+// Returns an error if the kernel doesn't have PSI (CONFIG_PSI, or disabled with psi=0).
+func cgroupPressure(dir string) (*options.Pressure, error) {
+	var (
+		pr  options.Pressure
+		err error
+	)
+	if pr.Cpu, err = readPSI(filepath.Join(dir, "cpu.pressure")); err != nil {
+		return nil, err
+	}
+	if pr.Memory, err = readPSI(filepath.Join(dir, "memory.pressure")); err != nil {
+		return nil, err
+	}
+	if pr.Io, err = readPSI(filepath.Join(dir, "io.pressure")); err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+// readPSI parses a pressure file, e.g.:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func readPSI(p string) (*options.PSIData, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var data options.PSIData
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var st options.PSIStats
+		for _, kv := range fields[1:] {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid pressure line in %s: %q", p, scanner.Text())
+			}
+			switch parts[0] {
+			case "avg10", "avg60", "avg300":
+				v, err := strconv.ParseFloat(parts[1], 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid pressure value in %s: %w", p, err)
+				}
+				switch parts[0] {
+				case "avg10":
+					st.Avg10 = v
+				case "avg60":
+					st.Avg60 = v
+				case "avg300":
+					st.Avg300 = v
+				}
+			case "total":
+				v, err := strconv.ParseUint(parts[1], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid pressure value in %s: %w", p, err)
+				}
+				st.Total = v
+			}
+		}
+
+		switch fields[0] {
+		case "some":
+			data.Some = &st
+		case "full":
+			data.Full = &st
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
@@ -1,7 +1,11 @@
+#define _GNU_SOURCE
+#include <errno.h>
 #include <stdio.h>
+#include <stddef.h>
 #include <string.h>
 #include <stdlib.h>
 #include <pthread.h>
+#include <signal.h>
 #include <unistd.h>
 #include <netdb.h>
 #include <sys/un.h>
@@ -15,124 +19,202 @@
 #include "log.h"
 
 int op_resize = 1;
+int op_ping = 2;
 int sock_fd;
 int tty_fd;
 
+// Default size of the copy buffer, set with _TTY_BUFFER_SIZE.
+#define DEFAULT_BUFFER_SIZE 32768
+
+size_t buffer_size = DEFAULT_BUFFER_SIZE;
+int use_splice = 1;
+
 struct copy_data
 {
     int w;
     int r;
 };
 
+// write_all writes the whole buffer, the fds may be pipes or ptys which take partial writes.
+int write_all(int fd, char *buf, ssize_t n)
+{
+    while (n > 0)
+    {
+        ssize_t nw = write(fd, buf, n);
+        if (nw < 0)
+        {
+            if (errno == EINTR)
+                continue;
+            return -1;
+        }
+        buf += nw;
+        n -= nw;
+    }
+    return 0;
+}
+
+// is_pipe returns 1 if fd is a pipe or fifo.
+int is_pipe(int fd)
+{
+    struct stat st;
+    if (fstat(fd, &st) < 0)
+        return 0;
+    return S_ISFIFO(st.st_mode);
+}
+
+// grow_pipe raises the capacity of a pipe to the buffer size, so a full buffer can be moved at once.
+void grow_pipe(int fd)
+{
+    if (buffer_size > 65536 && is_pipe(fd))
+        fcntl(fd, F_SETPIPE_SZ, (int)buffer_size);
+}
+
+// copy_splice moves data from the pipe r to w without copying it through user space.
+// Only pipes are spliced from: splice reading from a pty can stall with data in the pty, the pty side is copied with
+// read/write instead, see copy.
+// It returns 0 on EOF, -1 on error and 1 if splice is not supported for w (EINVAL before anything was copied), in
+// which case nothing was read from r.
+int copy_splice(int r, int w)
+{
+    int copied = 0;
+    while (1)
+    {
+        ssize_t n = splice(r, NULL, w, NULL, buffer_size, SPLICE_F_MOVE | SPLICE_F_MORE);
+        if (n < 0)
+        {
+            if (errno == EINTR)
+                continue;
+            return (errno == EINVAL && !copied) ? 1 : -1;
+        }
+        if (n == 0)
+            return 0;
+        copied = 1;
+    }
+}
+
+// copy_rw is the fallback of copy_splice, with a plain read/write loop.
+void copy_rw(int r, int w)
+{
+    char *buf = malloc(buffer_size);
+    if (buf == NULL)
+    {
+        lerror("malloc");
+        return;
+    }
+
+    while (1)
+    {
+        ssize_t n = read(r, buf, buffer_size);
+        if (n < 0 && errno == EINTR)
+            continue;
+        if (n <= 0)
+            break;
+        if (write_all(w, buf, n) < 0)
+            break;
+    }
+    free(buf);
+}
+
 void *copy(void *args)
 {
     struct copy_data *cp;
 
     cp = (struct copy_data *)args;
 
-    char buf[1024];
-    int n;
+    grow_pipe(cp->r);
+    grow_pipe(cp->w);
 
-    while ((n = read(cp->r, buf, sizeof(buf))) > 0)
-        n = write(cp->w, buf, n);
+    if (use_splice && is_pipe(cp->r))
+    {
+        int ret = copy_splice(cp->r, cp->w);
+        if (ret <= 0)
+            return 0;
+        lmsg("splice not supported, copying with read/write");
+    }
+    copy_rw(cp->r, cp->w);
+
+    return 0;
+}
 
+// reply writes a response to the client, it returns -1 if the client is gone.
+int reply(int fd, char *msg)
+{
+    if (write(fd, msg, strlen(msg)) < 0)
+    {
+        lerror("write");
+        return -1;
+    }
     return 0;
 }
 
+// handle_tty_op_conn handles the operations of a client until it disconnects.
+// Operations are "<op> [args]", the reply is "0" on success or an error message:
+//   - 1 <width> <height>: resize the pty
+//   - 2: ping, the shim checks the proxy is still there after it was restarted
 void handle_tty_op_conn(int fd)
 {
-    int nr, nw;
+    int nr;
     char buf[256];
 
     while (1)
     {
-        nr = read(fd, buf, sizeof(buf));
+        nr = read(fd, buf, sizeof(buf) - 1);
         if (nr < 0)
         {
             lerror("read");
-            close(fd);
-            return;
+            break;
         }
-
-        if (nr < 1)
+        if (nr == 0)
         {
-            char *msg = "invalid operation";
-            nw = write(fd, msg, sizeof(msg));
-            if (nw < 0)
-            {
-                lerror("write");
-                close(fd);
-                return;
-            }
+            // The shim went away, e.g. because it was restarted. The next one connects again.
+            lmsg("tty client disconnected");
+            break;
         }
+        buf[nr] = '\0';
 
         int op, w, h;
-        int err;
-
-        char op_buf[nr];
+        int n = sscanf(buf, "%d %d %d", &op, &w, &h);
+        if (n < 1)
+        {
+            lmsg("tty op parse error");
+            if (reply(fd, "parse error") < 0)
+                break;
+            continue;
+        }
 
-        // TODO: this is pretty sloppy
-        // We aren't checking that the data sent is valid and includes everything we expect.
-        memcpy(op_buf, buf, nr);
-        err = sscanf(op_buf, "%d %d %d", &op, &w, &h);
-        if (err < 0)
+        if (op == op_ping)
         {
-            char *msg = "parse error";
-            nw = write(fd, msg, sizeof(msg));
-            if (nw < 0)
-            {
-                lerror("write");
-                close(fd);
-                return;
-            }
-            lerror("parse");
-            close(fd);
-            return;
+            if (reply(fd, "0") < 0)
+                break;
+            continue;
         }
 
-        if (op != op_resize)
+        if (op != op_resize || n != 3)
         {
-            char *msg = "invalid operation";
-            nw = write(fd, msg, sizeof(msg));
-            if (nw < 0)
-            {
-                lerror("write");
-                close(fd);
-                return;
-            }
+            if (reply(fd, "invalid operation") < 0)
+                break;
+            continue;
         }
 
-        struct winsize *ws = (struct winsize *)malloc(sizeof(struct winsize));
-        ws->ws_col = w;
-        ws->ws_row = h;
+        struct winsize ws;
+        memset(&ws, 0, sizeof(ws));
+        ws.ws_col = w;
+        ws.ws_row = h;
 
-        err = ioctl(tty_fd, TIOCSWINSZ, ws);
-        free(ws);
-        if (err < 0)
+        if (ioctl(tty_fd, TIOCSWINSZ, &ws) < 0)
         {
             lerror("ioctl TIOCSWINSZ");
-            char *msg = "error setting win size";
-            nw = write(fd, msg, sizeof(msg));
-            if (nw < 0)
-            {
-                lerror("write");
-                close(fd);
-                return;
-            }
+            if (reply(fd, "error setting win size") < 0)
+                break;
+            continue;
         }
 
         // Send ack
-        nw = write(fd, "0", 1);
-        if (nw < 1)
-        {
-            lerror("write");
-            close(fd);
-            return;
-        }
+        if (reply(fd, "0") < 0)
+            break;
     }
 
     close(fd);
-    return;
 }
 
 void *handle_tty_ops(void *args)
@@ -252,6 +334,34 @@ int mkdir_all(char *path)
     return 0;
 }
 
+// set_sock_perms sets the owner and mode of the socket file passed from the shim.
+int set_sock_perms(char *sock_path)
+{
+    char *val = getenv("_TTY_SOCKET_MODE");
+    if (val != NULL)
+    {
+        mode_t mode = strtoul(val, NULL, 8);
+        if (chmod(sock_path, mode) < 0)
+        {
+            lerror("chmod tty socket");
+            return -1;
+        }
+    }
+
+    char *uid_val = getenv("_TTY_SOCKET_UID");
+    char *gid_val = getenv("_TTY_SOCKET_GID");
+    uid_t uid = uid_val == NULL ? 0 : strtoul(uid_val, NULL, 10);
+    gid_t gid = gid_val == NULL ? 0 : strtoul(gid_val, NULL, 10);
+    if (uid == 0 && gid == 0)
+        return 0;
+    if (chown(sock_path, uid, gid) < 0)
+    {
+        lerror("chown tty socket");
+        return -1;
+    }
+    return 0;
+}
+
 int tty_recv_fd(char *sock_path)
 {
     sock_fd = socket(AF_UNIX, SOCK_STREAM, 0);
@@ -261,15 +371,29 @@ int tty_recv_fd(char *sock_path)
     }
     lmsg("created socket");
 
-    unlink(sock_path);
+    // Abstract socket names start with "@", like in go.
+    int abstract = sock_path[0] == '@';
+    int err;
 
-    char *dir = strdup(sock_path);
-    int err = mkdir_all(dirname(dir));
-    free(dir);
-    if (err < 0)
+    if (!abstract)
+    {
+        unlink(sock_path);
+
+        char *dir = strdup(sock_path);
+        err = mkdir_all(dirname(dir));
+        free(dir);
+        if (err < 0)
+        {
+            close(sock_fd);
+            return err;
+        }
+    }
+
+    if (strlen(sock_path) >= sizeof(((struct sockaddr_un *)0)->sun_path))
     {
+        lmsg("tty socket path is too long");
         close(sock_fd);
-        return err;
+        return -1;
     }
 
     struct sockaddr_un addr;
@@ -277,10 +401,18 @@ int tty_recv_fd(char *sock_path)
     addr.sun_family = AF_UNIX;
     strncpy(addr.sun_path, sock_path, sizeof(addr.sun_path) - 1);
 
+    socklen_t addr_len = sizeof(struct sockaddr_un);
+    if (abstract)
+    {
+        // The name is not NUL terminated, its length is that of the address.
+        addr.sun_path[0] = '\0';
+        addr_len = offsetof(struct sockaddr_un, sun_path) + strlen(sock_path);
+    }
+
     lmsg("binding tty socket path");
     lmsg(sock_path);
 
-    err = bind(sock_fd, (struct sockaddr *)&addr, sizeof(struct sockaddr_un));
+    err = bind(sock_fd, (struct sockaddr *)&addr, addr_len);
     if (err < 0)
     {
         close(sock_fd);
@@ -289,6 +421,16 @@ int tty_recv_fd(char *sock_path)
 
     lmsg("bound socket");
 
+    if (!abstract)
+    {
+        err = set_sock_perms(sock_path);
+        if (err < 0)
+        {
+            close(sock_fd);
+            return err;
+        }
+    }
+
     err = listen(sock_fd, 1);
     if (err < 0)
     {
@@ -308,36 +450,39 @@ int tty_recv_fd(char *sock_path)
         lmsg("sd_notify READY=1");
     }
 
-    int conn = accept(sock_fd, NULL, NULL);
-    if (conn < 0)
+    // Connections that don't pass a pty are skipped: the runtime may connect and fail before sending it, e.g. a criu
+    // restore, which the shim retries, only the pty of the process that ends up running matters.
+    while (1)
     {
-        close(sock_fd);
-        return conn;
-    }
+        int conn = accept(sock_fd, NULL, NULL);
+        if (conn < 0)
+        {
+            close(sock_fd);
+            return conn;
+        }
 
-    lmsg("accepted connection");
+        lmsg("accepted connection");
 
-    char buf[512];
-    struct iovec e = {buf, 512};
-    char cmsg[CMSG_SPACE(sizeof(int))];
-    struct msghdr m = {NULL, 0, &e, 1, cmsg, sizeof(cmsg), 0};
+        char buf[512];
+        struct iovec e = {buf, 512};
+        char cmsg[CMSG_SPACE(sizeof(int))];
+        struct msghdr m = {NULL, 0, &e, 1, cmsg, sizeof(cmsg), 0};
 
-    int n = recvmsg(conn, &m, 0);
-    if (n < 0)
-    {
+        int n = recvmsg(conn, &m, 0);
         close(conn);
-        close(sock_fd);
-        return n;
-    }
 
-    lmsg("received fd");
+        struct cmsghdr *c = n > 0 ? CMSG_FIRSTHDR(&m) : NULL;
+        if (c == NULL || c->cmsg_level != SOL_SOCKET || c->cmsg_type != SCM_RIGHTS)
+        {
+            lmsg("connection did not pass a pty, waiting for the next one");
+            continue;
+        }
 
-    struct cmsghdr *c = CMSG_FIRSTHDR(&m);
-    int fd = *(int *)CMSG_DATA(c);
+        lmsg("received fd");
 
-    close(conn);
-    // We re-use sock_fd for the tty operations, so don't close that.
-    return fd;
+        // We re-use sock_fd for the tty operations, so don't close that.
+        return *(int *)CMSG_DATA(c);
+    }
 }
 
 void pty_main(void)
@@ -355,6 +500,16 @@ void pty_main(void)
         exit(1);
     }
 
+    // Clients going away must not take the proxy, which holds the pty, with them.
+    signal(SIGPIPE, SIG_IGN);
+
+    val = getenv("_TTY_BUFFER_SIZE");
+    if (val != NULL && atol(val) > 0)
+        buffer_size = atol(val);
+    val = getenv("_TTY_NO_SPLICE");
+    if (val != NULL && *val == '1')
+        use_splice = 0;
+
     setcgroup();
     lmsg("cgroup set");
 
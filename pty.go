@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -9,55 +12,161 @@ import (
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/log"
 	"github.com/containerd/containerd/namespaces"
 	taskapi "github.com/containerd/containerd/runtime/v2/task"
 	systemd "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
 	dbus "github.com/godbus/dbus/v5"
 	ptypes "github.com/gogo/protobuf/types"
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sys/unix"
 )
 
 const (
 	ttySockPathEnv  = "_TTY_SOCKET_PATH"
 	ttyHandshakeEnv = "_TTY_HANDSHAKE"
+	ttySockModeEnv  = "_TTY_SOCKET_MODE"
+	ttySockUIDEnv   = "_TTY_SOCKET_UID"
+	ttySockGIDEnv   = "_TTY_SOCKET_GID"
+
+	// ttyOpPing checks that the console proxy is there, see handle_tty_op_conn in pty.c.
+	ttyOpPing = "2"
+
+	ttyBufferSizeEnv = "_TTY_BUFFER_SIZE"
+	ttyNoSpliceEnv   = "_TTY_NO_SPLICE"
 )
 
-func (p *process) ResizePTY(ctx context.Context, width, height int, sockPath string) error {
-	if !p.Terminal {
-		// This mimics what the runc shim does, and what the containerd integration tests expect
+// ioBufferSizeAnnotation sets the size of the buffer the tty helper copies between the pty and stdio with.
+// The helper moves data with splice(2) where the kernel supports it, the buffer size is then the most moved at once
+// and the capacity of the pipes involved. Larger buffers help containers with a lot of output on the terminal.
+const ioBufferSizeAnnotation = shimName + ".io-buffer-size"
+
+const (
+	minIOBufferSize = 4096
+	maxIOBufferSize = 16 << 20
+)
+
+func ioBufferAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	v := annotations[ioBufferSizeAnnotation]
+	if v == "" {
 		return nil
 	}
+	n, err := parseByteSize(v)
+	if err != nil {
+		return fmt.Errorf("annotation %s: %v: %w", ioBufferSizeAnnotation, err, errdefs.ErrInvalidArgument)
+	}
+	if n < minIOBufferSize || n > maxIOBufferSize {
+		return fmt.Errorf("annotation %s: size must be between %d and %d: %w", ioBufferSizeAnnotation, minIOBufferSize, maxIOBufferSize, errdefs.ErrInvalidArgument)
+	}
+	opts.IOBufferSize = uint32(n)
+	return nil
+}
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
+// Annotations for the console socket the runtime sends the pty to, which is also used to resize the pty.
+//
+// ttySocketAnnotation selects where the socket is created:
+//   - "path" (the default): in a new directory in XDG_RUNTIME_DIR (or /tmp)
+//   - "private": in a new directory in the shim's own directory on the runtime tmpfs, see ttyPrivateDir. This keeps the
+//     path short, unix socket paths are limited to 108 bytes.
+//   - "abstract": an abstract socket, which has no path at all. Abstract sockets don't have permissions, any process
+//     in the network namespace of the shim can connect to them.
+//
+// ttySocketModeAnnotation is the octal mode of socket files (0600 by default). They, and the directory they are in,
+// are owned by the IoUid and IoGid of the container.
+const (
+	ttySocketAnnotation     = shimName + ".tty-socket"
+	ttySocketModeAnnotation = shimName + ".tty-socket-mode"
 
-	conn := p.ttyConn
+	ttySocketPath     = "path"
+	ttySocketPrivate  = "private"
+	ttySocketAbstract = "abstract"
 
-	var noRetry bool
-	if conn == nil {
-		noRetry = true
-		var err error
-		conn, err = net.Dial("unix", sockPath)
-		if err != nil {
-			return fmt.Errorf("could not dial tty sock: %w", err)
+	ttySocketPrefix      = "systemd-shim-pty-"
+	defaultTTYSocketMode = 0600
+)
+
+// ttyPrivateDir is where "private" console sockets are created.
+func ttyPrivateDir() string {
+	if rootless {
+		return filepath.Join(xdgRuntimeDir(), "systemd-shim", "pty")
+	}
+	return "/run/systemd-shim/pty"
+}
+
+func ttySocketAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	switch v := annotations[ttySocketAnnotation]; v {
+	case "", ttySocketPath:
+	case ttySocketPrivate, ttySocketAbstract:
+		opts.TTYSocket = v
+	default:
+		return fmt.Errorf("annotation %s: invalid value %q: %w", ttySocketAnnotation, v, errdefs.ErrInvalidArgument)
+	}
+
+	if v := annotations[ttySocketModeAnnotation]; v != "" {
+		if opts.TTYSocket == ttySocketAbstract {
+			return fmt.Errorf("annotation %s: abstract sockets don't have a mode: %w", ttySocketModeAnnotation, errdefs.ErrInvalidArgument)
 		}
-		p.ttyConn = conn
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || mode == 0 || mode > 0777 {
+			return fmt.Errorf("annotation %s: invalid mode %q: %w", ttySocketModeAnnotation, v, errdefs.ErrInvalidArgument)
+		}
+		opts.TTYSocketMode = uint32(mode)
 	}
+	return nil
+}
 
-	_, err := conn.Write([]byte("1 " + strconv.Itoa(width) + " " + strconv.Itoa(height)))
-	if err != nil {
-		if !noRetry {
-			p.ttyConn.Close()
-			p.ttyConn = nil
-			return p.ResizePTY(ctx, width, height, sockPath)
+func (p *process) ResizePTY(ctx context.Context, width, height int, sockPath string) error {
+	return p.ttyOp(sockPath, "1 "+strconv.Itoa(width)+" "+strconv.Itoa(height))
+}
+
+// ttyOp sends an operation to the console proxy and waits for its ack.
+// The connection is kept for the next operation, it is dialed again once if it broke, e.g. when the proxy was
+// restarted after a previous operation.
+func (p *process) ttyOp(sockPath, op string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for retry := p.ttyConn != nil; ; retry = false {
+		if p.ttyConn == nil {
+			conn, err := net.Dial("unix", sockPath)
+			if err != nil {
+				return fmt.Errorf("could not dial tty sock: %w", err)
+			}
+			p.ttyConn = conn
 		}
-		return fmt.Errorf("error writing winsize to the tty handler: %w", err)
+		err := ttyRequest(p.ttyConn, op)
+		if err == nil {
+			return nil
+		}
+		var respErr ttyResponseError
+		if errors.As(err, &respErr) {
+			return err
+		}
+		p.ttyConn.Close()
+		p.ttyConn = nil
+		if !retry {
+			return err
+		}
+	}
+}
+
+// ttyResponseError is an error reported by the console proxy.
+type ttyResponseError string
+
+func (e ttyResponseError) Error() string {
+	return "tty handler returned an error: " + string(e)
+}
+
+func ttyRequest(conn net.Conn, op string) error {
+	if _, err := conn.Write([]byte(op)); err != nil {
+		return fmt.Errorf("error writing to the tty handler: %w", err)
 	}
 
 	resp := make([]byte, 128)
@@ -65,37 +174,61 @@ func (p *process) ResizePTY(ctx context.Context, width, height int, sockPath str
 	if err != nil {
 		return fmt.Errorf("error reading ack from tty handler: %w", err)
 	}
-	if n > 1 {
-		return fmt.Errorf("tty handler returned an error: %s", string(resp[:n]))
-	}
 	if n == 0 {
 		return fmt.Errorf("tty handler returned no data")
 	}
-	if resp[0] != '0' {
-		return fmt.Errorf("tty handler returned unknown response code %s", string(resp[:n]))
+	if n > 1 || resp[0] != '0' {
+		return ttyResponseError(resp[:n])
 	}
 	return nil
 }
 
+// reconnectTTY connects to the console proxy of a process that was started before the shim was restarted.
+//
+// The pty is held by the tty unit (see makePty), not the shim, so terminals keep working while the shim is gone and
+// the new shim only needs to find the proxy again to resize the pty. If the proxy is gone the terminal is lost: the pty
+// went with it, it can't be handed over to a new one.
+func (p *process) reconnectTTY(ctx context.Context, sockPath string, owner ptyOwner) {
+	ctx = withLogSubsystem(ctx, logIO)
+	unit := owner.ttyUnitName()
+	units, err := p.systemd.ListUnitsByNamesContext(ctx, []string{unit})
+	if err != nil {
+		log.G(ctx).WithError(err).WithField("unit", unit).Warn("Error getting state of tty unit")
+		return
+	}
+	if len(units) == 0 || units[0].ActiveState != "active" {
+		log.G(ctx).WithField("unit", unit).Warn("Console proxy is not running, terminal is lost")
+		return
+	}
+	if err := p.ttyOp(sockPath, ttyOpPing); err != nil {
+		log.G(ctx).WithError(err).WithField("unit", unit).Warn("Error reconnecting to console proxy")
+		return
+	}
+	log.G(ctx).WithField("unit", unit).Debug("Reconnected to console proxy")
+}
+
 // ResizePty of a process
 func (s *Service) ResizePty(ctx context.Context, r *taskapi.ResizePtyRequest) (_ *ptypes.Empty, retErr error) {
 	ns, err := namespaces.NamespaceRequired(ctx)
 	if err != nil {
-		return nil, err
+		return nil, errdefs.ToGRPC(err)
 	}
 
+	ctx, span := StartSpan(ctx, "service.ResizePty", trace.WithAttributes(attribute.String(nsAttr, ns), attribute.String(cIDAttr, r.ID), attribute.String(eIDAttr, r.ExecID)))
 	ctx = log.WithLogger(ctx, log.G(ctx).WithFields(logrus.Fields{
 		"id":        r.ID,
 		"ns":        ns,
+		"execID":    r.ExecID,
 		"apiAction": "resizePty",
 	}))
 
-	log.G(ctx).Info("systemd.ResizePTY start")
 	defer func() {
-		log.G(ctx).WithError(retErr).Info("systemd.ResizePTY end")
+		log.G(ctx).WithError(retErr).Debug("systemd.ResizePTY end")
 		if retErr != nil {
-			retErr = errdefs.ToGRPC(retErr)
+			retErr = errdefs.ToGRPCf(retErr, "resize pty")
+			span.SetStatus(codes.Error, retErr.Error())
 		}
+		span.End()
 	}()
 
 	p := s.processes.Get(path.Join(ns, r.ID))
@@ -103,8 +236,13 @@ func (s *Service) ResizePty(ctx context.Context, r *taskapi.ResizePtyRequest) (_
 		return nil, fmt.Errorf("process %s: %w", r.ID, errdefs.ErrNotFound)
 	}
 
+	ctx = WithShimLog(ctx, p.LogWriter())
+
 	if r.ExecID != "" {
 		ep := p.(*initProcess).execs.Get(r.ExecID)
+		if ep == nil {
+			return nil, fmt.Errorf("exec %s: %w", r.ExecID, errdefs.ErrNotFound)
+		}
 		if err := ep.ResizePTY(ctx, int(r.Width), int(r.Height)); err != nil {
 			return nil, err
 		}
@@ -117,7 +255,15 @@ func (s *Service) ResizePty(ctx context.Context, r *taskapi.ResizePtyRequest) (_
 	return &ptypes.Empty{}, nil
 }
 
+func (p *process) hasTerminal() bool {
+	return p.Terminal || p.opts.Terminal
+}
+
 func (p *execProcess) ResizePTY(ctx context.Context, w, h int) error {
+	if !p.hasTerminal() {
+		// This mimics what the runc shim does, and what the containerd integration tests expect
+		return nil
+	}
 	ttyPath, err := p.ttySockPath()
 	if err != nil {
 		return err
@@ -126,6 +272,9 @@ func (p *execProcess) ResizePTY(ctx context.Context, w, h int) error {
 }
 
 func (p *initProcess) ResizePTY(ctx context.Context, w, h int) error {
+	if !p.hasTerminal() {
+		return nil
+	}
 	ttyPath, err := p.ttySockPath()
 	if err != nil {
 		return err
@@ -135,6 +284,13 @@ func (p *initProcess) ResizePTY(ctx context.Context, w, h int) error {
 
 // CloseIO of a process
 func (s *Service) CloseIO(ctx context.Context, r *taskapi.CloseIORequest) (_ *ptypes.Empty, retErr error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	_, span := StartSpan(ctx, "service.CloseIO", trace.WithAttributes(attribute.String(nsAttr, ns), attribute.String(cIDAttr, r.ID), attribute.String(eIDAttr, r.ExecID)))
+	defer span.End()
+
 	// TODO: I'm not sure what we should do here since we aren't really doing anything with container I/O
 	// Potentially we should signal the tty handler to stop copying stdio?
 	return &ptypes.Empty{}, nil
@@ -182,8 +338,17 @@ func recvFd(socket *net.UnixConn) (int, error) {
 }
 
 func (p *initProcess) ttySockPath() (string, error) {
-	sockInfoPath := filepath.Join(p.root, "tty.sock")
-	b, err := os.ReadFile(sockInfoPath)
+	return ttySockPath(filepath.Join(p.root, "tty.sock"), p.root, &p.opts)
+}
+
+func (p *execProcess) ttySockPath() (string, error) {
+	return ttySockPath(filepath.Join(p.stateDir(), "tty.sock"), p.root, &p.opts)
+}
+
+// ttySockPath returns the path of the console socket recorded in the info file, a new one is picked and recorded if
+// there is none yet. Abstract socket names start with "@", as understood by the Go net package (and so runc).
+func ttySockPath(infoPath, ref string, opts *CreateOptions) (string, error) {
+	b, err := os.ReadFile(infoPath)
 	if err == nil {
 		return string(b), nil
 	}
@@ -192,48 +357,82 @@ func (p *initProcess) ttySockPath() (string, error) {
 		return "", err
 	}
 
-	tmp, err := ioutil.TempDir(os.Getenv("XDG_RUNTIME_DIR"), "pty")
-	if err != nil {
-		return "", err
+	var s string
+	switch opts.TTYSocket {
+	case ttySocketAbstract:
+		var rnd [8]byte
+		if _, err := rand.Read(rnd[:]); err != nil {
+			return "", err
+		}
+		s = "@" + ttySocketPrefix + hex.EncodeToString(rnd[:])
+	default:
+		dir := os.Getenv("XDG_RUNTIME_DIR")
+		if opts.TTYSocket == ttySocketPrivate {
+			dir = ttyPrivateDir()
+			if err := os.MkdirAll(dir, 0711); err != nil {
+				return "", err
+			}
+		}
+		tmp, err := ioutil.TempDir(dir, "pty")
+		if err != nil {
+			return "", err
+		}
+		if err := labelLike(tmp, ref); err != nil {
+			os.RemoveAll(tmp)
+			return "", err
+		}
+		// The runtime connects to the socket as the io user.
+		if opts.IoUid != 0 || opts.IoGid != 0 {
+			if err := os.Chown(tmp, int(opts.IoUid), int(opts.IoGid)); err != nil {
+				os.RemoveAll(tmp)
+				return "", fmt.Errorf("error changing owner of tty socket dir: %w", err)
+			}
+		}
+		s = filepath.Join(tmp, "s")
 	}
-	s := filepath.Join(tmp, "s")
-	if err := ioutil.WriteFile(sockInfoPath, []byte(s), 0600); err != nil {
-		os.RemoveAll(tmp)
+	if err := ioutil.WriteFile(infoPath, []byte(s), 0600); err != nil {
+		if !strings.HasPrefix(s, "@") {
+			os.RemoveAll(filepath.Dir(s))
+		}
 		return "", err
 	}
 
 	return s, nil
 }
 
-func (p *execProcess) ttySockPath() (string, error) {
-	sockInfoPath := filepath.Join(p.stateDir(), "tty.sock")
-	b, err := os.ReadFile(sockInfoPath)
-	if err == nil {
-		return string(b), nil
+// ttySocketEnv returns the environment telling the tty helper the owner and mode of the console socket it binds.
+func ttySocketEnv(opts *CreateOptions) []string {
+	mode := opts.TTYSocketMode
+	if mode == 0 {
+		mode = defaultTTYSocketMode
 	}
-
-	if !os.IsNotExist(err) {
-		return "", err
+	return []string{
+		ttySockModeEnv + "=" + strconv.FormatUint(uint64(mode), 8),
+		ttySockUIDEnv + "=" + strconv.FormatUint(uint64(opts.IoUid), 10),
+		ttySockGIDEnv + "=" + strconv.FormatUint(uint64(opts.IoGid), 10),
 	}
+}
 
-	tmp, err := ioutil.TempDir(os.Getenv("XDG_RUNTIME_DIR"), "pty")
-	if err != nil {
-		return "", err
-	}
-	s := filepath.Join(tmp, "s")
-	if err := ioutil.WriteFile(sockInfoPath, []byte(s), 0600); err != nil {
-		os.RemoveAll(tmp)
-		return "", err
-	}
+func (p *process) ttyUnitName() string {
+	return unitName(p.opts.UnitNameTemplate, p.ns, p.id, "tty")
+}
 
-	return s, nil
+// ttyUnitName includes the container ID since exec IDs are only unique within a container.
+// The kind is not "tty" so the unit doesn't take the name of the tty unit of a container named "<container>-<exec>".
+func (p *execProcess) ttyUnitName() string {
+	return unitName(p.parent.opts.UnitNameTemplate, p.ns, p.parent.id+"-"+p.id, "exec-tty")
 }
 
-func (p *process) ttyUnitName() string {
-	return unitName(p.ns, p.id, "tty")
+// ptyOwner is the process a tty helper is started for.
+type ptyOwner interface {
+	Name() string
+	ttyUnitName() string
+	journalFields() map[string]string
 }
 
-func (p *process) makePty(ctx context.Context, sockPath string) (_, _ string, retErr error) {
+// makePty starts a helper unit which copies between the container pty and the stdio fifos.
+// In journald mode the output of the pty goes to the journal tagged with the name and fields of the process which owns it.
+func (p *process) makePty(ctx context.Context, sockPath string, owner ptyOwner) (_, _ string, retErr error) {
 	ctx, span := StartSpan(ctx, "process.StartTTY")
 	defer func() {
 		if retErr != nil {
@@ -261,14 +460,27 @@ func (p *process) makePty(ctx context.Context, sockPath string) (_, _ string, re
 		}
 	}
 
-	if p.Stdout != "" {
-		f, _ := os.OpenFile(p.Stdout, os.O_RDWR, 0)
+	// The output of the pty only goes to stdout.
+	stdout := p.Stdout
+	if p.logMode() != options.LogMode_JOURNALD {
+		path, probe, err := checkStdioOutput(ctx, p.opts.StdioNoReader, "stdout", stdout)
+		if err != nil {
+			return "", "", err
+		}
+		if probe != nil {
+			defer probe.Close()
+		}
+		stdout = path
+	}
+
+	if isFifo(stdout) {
+		f, _ := os.OpenFile(stdout, os.O_RDWR, 0)
 		if f != nil {
 			defer f.Close()
 		}
 	}
 
-	if p.Stderr != "" {
+	if isFifo(p.Stderr) {
 		f, _ := os.OpenFile(p.Stderr, os.O_RDWR, 0)
 		if f != nil {
 			defer f.Close()
@@ -278,6 +490,10 @@ func (p *process) makePty(ctx context.Context, sockPath string) (_, _ string, re
 		ttyHandshakeEnv + "=1",
 		ttySockPathEnv + "=" + sockPath,
 	}
+	env = append(env, ttySocketEnv(&p.opts)...)
+	if p.opts.IOBufferSize > 0 {
+		env = append(env, ttyBufferSizeEnv+"="+strconv.FormatUint(uint64(p.opts.IOBufferSize), 10))
+	}
 	if p.shimCgroup != "" {
 		env = append(env, "SHIM_CGROUP="+p.shimCgroup)
 	}
@@ -287,11 +503,36 @@ func (p *process) makePty(ctx context.Context, sockPath string) (_, _ string, re
 		systemd.PropExecStart([]string{p.exe, "tty-handshake"}, false),
 		{Name: "Environment", Value: dbus.MakeVariant(env)},
 		{Name: "StandardInputFile", Value: dbus.MakeVariant(p.Stdin)},
-		{Name: "StandardOutputFile", Value: dbus.MakeVariant(p.Stdout)},
 		{Name: "StandardErrorFile", Value: dbus.MakeVariant(logPath)},
 	}
+	if p.opts.Slice != "" {
+		properties = append(properties, systemd.Property{Name: "Slice", Value: dbus.MakeVariant(p.opts.Slice)})
+	}
+
+	if p.logMode() == options.LogMode_JOURNALD {
+		fields := owner.journalFields()
+		extra := make([][]byte, 0, len(fields))
+		for k, v := range fields {
+			extra = append(extra, []byte(k+"="+v))
+		}
+		properties = append(properties,
+			systemd.Property{Name: "StandardOutput", Value: dbus.MakeVariant("journal")},
+			systemd.Property{Name: "SyslogIdentifier", Value: dbus.MakeVariant(owner.Name())},
+			systemd.Property{Name: "LogExtraFields", Value: dbus.MakeVariant(extra)},
+		)
+	} else {
+		u, err := parseStdioURI(stdout)
+		if err != nil {
+			return "", "", err
+		}
+		if u != nil && u.Scheme == stdioFile {
+			properties = append(properties, systemd.Property{Name: "StandardOutputFileToAppend", Value: dbus.MakeVariant(u.Path)})
+		} else {
+			properties = append(properties, systemd.Property{Name: "StandardOutputFile", Value: dbus.MakeVariant(stdout)})
+		}
+	}
 
-	ttyUnit := p.ttyUnitName()
+	ttyUnit := owner.ttyUnitName()
 	defer func() {
 		if retErr != nil {
 			p.systemd.StopUnitContext(ctx, ttyUnit, "replace", nil)
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/log"
+)
+
+const (
+	bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+	// rebootExitStatus is the exit status reported for processes that were still running when the host went down.
+	// How they exited is not known, this is containerd's unknown exit status.
+	rebootExitStatus = 255
+	// resultReboot is the result of processes that were running when the host went down, see pState.Result.
+	resultReboot = "reboot"
+)
+
+// readBootID returns the boot ID of the host, it changes on every boot.
+func readBootID() (string, error) {
+	b, err := os.ReadFile(bootIDPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// bootTime returns when the host booted, or now if that is not known.
+func bootTime() time.Time {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Now()
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		v := strings.TrimPrefix(scanner.Text(), "btime ")
+		if v == scanner.Text() {
+			continue
+		}
+		if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(sec, 0)
+		}
+	}
+	return time.Now()
+}
+
+// bootedSince returns true if the task was saved during an earlier boot of the host.
+// Tasks saved before the boot ID was recorded can't be told apart and are recovered as usual.
+func (s *Service) bootedSince(rec taskRecord) bool {
+	return rec.BootID != "" && s.bootID != "" && rec.BootID != s.bootID
+}
+
+// exitAfterReboot marks the container and its running execs as exited with rebootExitStatus, so containerd gets a TaskExit
+// for each of them instead of tasks that look like they are still running, and cleans up what is left of them.
+// The container stays around until containerd deletes it.
+//
+// Nothing survived the reboot but files on disk, the state root and the runtime unit directory are on tmpfs by default.
+// Whatever is left is removed: the runtime state, the unit files and the rootfs mount records.
+func (s *Service) exitAfterReboot(ctx context.Context, p *initProcess) {
+	log.G(ctx).Info("Container was running before the host rebooted, marking it as exited")
+
+	exitedAt := bootTime()
+	exit := func(pid uint32) pState {
+		return pState{Pid: pid, ExitCode: rebootExitStatus, ExitedAt: exitedAt, Status: statusStopped, Result: resultReboot}
+	}
+
+	p.execs.Each(func(e Process) {
+		ep := e.(*execProcess)
+		if err := ep.LoadState(ctx); err != nil {
+			log.G(ctx).WithError(err).WithField("exec", ep.execID).Debug("Error loading exec state")
+		}
+		if !ep.ProcessState().Exited() {
+			ep.SetState(ctx, exit(ep.Pid()))
+			if err := writeExitStatusFile(ep.exitStatusPath(), ep.ProcessState()); err != nil {
+				log.G(ctx).WithError(err).WithField("exec", ep.execID).Debug("Error writing exec exit status file")
+			}
+		}
+		if err := ep.removeUnit(ctx, ep.Name()); err != nil {
+			log.G(ctx).WithError(err).WithField("exec", ep.execID).Warn("Error removing exec unit")
+		}
+	})
+
+	// A container that exited before the reboot keeps its exit state.
+	// Otherwise the exit state is written first so it is loaded again if the shim restarts before the delete.
+	var st pState
+	if err := p.readExitState(&st); err != nil || !st.Exited() {
+		st = exit(p.Pid())
+		if err := writeExitState(p.exitStatePath(), st); err != nil {
+			log.G(ctx).WithError(err).Warn("Error writing exit state")
+		}
+		if err := writeExitStatusFile(p.exitStatusPath(), st); err != nil {
+			log.G(ctx).WithError(err).Warn("Error writing exit status file")
+		}
+	}
+	p.SetState(ctx, st)
+
+	if err := os.RemoveAll(filepath.Join(p.runc.Root, p.id)); err != nil {
+		log.G(ctx).WithError(err).Warn("Error removing runtime state")
+	}
+	if err := p.removeUnit(ctx, p.Name()); err != nil {
+		log.G(ctx).WithError(err).Warn("Error removing container unit")
+	}
+	if err := p.cleanupRootfs(ctx); err != nil {
+		log.G(ctx).WithError(err).Warn("Error cleaning up rootfs mounts")
+	}
+
+	if err := s.saveTask(p); err != nil {
+		log.G(ctx).WithError(err).Warn("Error saving task state")
+	}
+}
+
+func writeExitState(path string, st pState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
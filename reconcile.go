@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd/log"
+)
+
+// statusStopped is the status of a process whose unit was stopped without the shim seeing the process exit.
+const statusStopped = "stopped"
+
+// reconcileUnit brings the state of the process for the unit in line with systemd after the unit became inactive or
+// was removed.
+// This is what keeps containerd's view consistent when a unit is stopped out of band, e.g. `systemctl stop`.
+// Normally the exit command (ExecStopPost) has written the exit state by the time the unit is inactive, if it couldn't
+// (e.g. the unit was removed) the process is marked as exited anyway so waiters are released and a TaskExit is sent.
+func (s *Service) reconcileUnit(ctx context.Context, name string) {
+	if name == "" {
+		return
+	}
+	p := s.units.Get(name)
+	if p == nil || p.ProcessState().Exited() {
+		return
+	}
+
+	ctx = log.WithLogger(ctx, log.G(ctx).WithField("unit", name))
+	ctx = WithShimLog(ctx, p.LogWriter())
+
+	if err := p.LoadState(ctx); err != nil {
+		log.G(ctx).WithError(err).Debug("Error loading state of stopped unit")
+	}
+
+	st := p.ProcessState()
+	if st.Exited() {
+		return
+	}
+	if st.Pid == 0 {
+		// Never started, there is nothing to report.
+		return
+	}
+
+	log.G(ctx).Info("Unit stopped outside of the shim, marking process as exited")
+	// The unit may already be gone, e.g. after UnitRemoved, the time we noticed is the best we have then.
+	st.ExitedAt = time.Now()
+	var unitSt pState
+	if err := getUnitState(ctx, s.conn, name, &unitSt); err == nil && unitSt.ExitedAt.After(timeZero) {
+		st.ExitedAt = unitSt.ExitedAt
+	}
+	st.Status = statusStopped
+	if st.ExitCode == 0 {
+		st.ExitCode = 255
+	}
+	p.SetState(ctx, st)
+}
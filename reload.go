@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// reloadDelay is how long we wait for more reload requests before reloading.
+const reloadDelay = 10 * time.Millisecond
+
+// reloader coalesces daemon-reload requests.
+// A reload is expensive and serialized by systemd, so when many containers are created at once we want to do as few as
+// possible. Callers write their unit files before requesting a reload, so any reload that starts after the request was
+// made covers it. Requests that come in while a reload is in progress are batched into the next one.
+type reloader struct {
+	conn *sdConn
+
+	mu      sync.Mutex
+	running bool
+	pending []chan error
+}
+
+func newReloader(conn *sdConn) *reloader {
+	return &reloader{conn: conn}
+}
+
+// Reload requests a daemon-reload and waits for it to complete.
+func (r *reloader) Reload(ctx context.Context) error {
+	ch := make(chan error, 1)
+
+	r.mu.Lock()
+	r.pending = append(r.pending, ch)
+	if !r.running {
+		r.running = true
+		go r.run()
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-ch:
+		return err
+	}
+}
+
+func (r *reloader) run() {
+	for {
+		time.Sleep(reloadDelay)
+
+		r.mu.Lock()
+		waiters := r.pending
+		r.pending = nil
+		if len(waiters) == 0 {
+			r.running = false
+			r.mu.Unlock()
+			return
+		}
+		r.mu.Unlock()
+
+		// Not tied to any one request, the requests may be cancelled independently.
+		start := time.Now()
+		err := r.conn.ReloadContext(context.Background())
+		metrics.reload.Observe(time.Since(start), resultLabel(err))
+		if err != nil {
+			metrics.dbusErrors.Inc("Reload")
+		}
+		for _, ch := range waiters {
+			ch <- err
+		}
+	}
+}
+
+// reload reloads systemd through the service's reloader so concurrent reloads are batched.
+func (p *process) reload(ctx context.Context) error {
+	if p.reloader == nil {
+		return p.systemd.ReloadContext(ctx)
+	}
+	return p.reloader.Reload(ctx)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	eventsapi "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/coreos/go-systemd/unit"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+)
+
+// Annotations to let systemd restart the container when it exits, see restartPolicies.
+// This is opt-in: containerd clients expect a task to stay exited, with a restart policy they see a TaskExit followed
+// by a TaskStart (and a TaskRestart) with the new pid every time systemd restarts the container.
+const (
+	restartAnnotation    = shimName + ".restart"
+	restartSecAnnotation = shimName + ".restart-sec"
+
+	// restartEventTopic is the topic TaskRestart events are published on.
+	restartEventTopic = "/tasks/restart"
+)
+
+const (
+	// lastExitStateFileName keeps the exit state of the process before the last restart, see restartPrepare.
+	lastExitStateFileName = "init_last_exit_status.json"
+	// restartMarkerFileName is created while the unit is being restarted by systemd, see restartStart.
+	restartMarkerFileName = "restarting"
+)
+
+// restartPolicies are the values of Restart= that can be used.
+var restartPolicies = map[string]bool{
+	"on-success":  true,
+	"on-failure":  true,
+	"on-abnormal": true,
+	"on-watchdog": true,
+	"on-abort":    true,
+	"always":      true,
+}
+
+// restartAnnotations applies the restart policy from the container annotations to the create options.
+func restartAnnotations(annotations map[string]string, opts *CreateOptions, terminal bool) error {
+	v := annotations[restartAnnotation]
+	if v == "" || v == "no" {
+		return nil
+	}
+	if !restartPolicies[v] {
+		return fmt.Errorf("annotation %s: invalid restart policy %q: %w", restartAnnotation, v, errdefs.ErrInvalidArgument)
+	}
+	switch {
+	case opts.CRI.Type != "":
+		// kubelet restarts containers itself and the CRI plugin cleans up containers once they exit.
+		return fmt.Errorf("annotation %s: restart policies are not supported for CRI containers: %w", restartAnnotation, errdefs.ErrInvalidArgument)
+	case opts.SdNotifyEnable:
+		// The unit would wait for the container to be ready before the container is started.
+		return fmt.Errorf("annotation %s: restart policies are not supported with sd_notify: %w", restartAnnotation, errdefs.ErrNotImplemented)
+	case terminal:
+		// The tty helper is stopped with the container and can't be brought back by systemd.
+		return fmt.Errorf("annotation %s: restart policies are not supported with a terminal: %w", restartAnnotation, errdefs.ErrNotImplemented)
+	}
+	opts.Restart = v
+
+	if v := annotations[restartSecAnnotation]; v != "" {
+		usec, err := parseUnitDuration(v)
+		if err != nil {
+			return fmt.Errorf("annotation %s: %w", restartSecAnnotation, err)
+		}
+		opts.RestartSec = time.Duration(usec) * time.Microsecond
+	}
+	return nil
+}
+
+func (p *initProcess) restartable() bool {
+	return p.opts.Restart != ""
+}
+
+// restartOptions returns the unit options that let systemd restart the container.
+//
+// systemd re-runs ExecStart on restart, which only creates the container: the old container is deleted from the
+// runtime before (ExecStartPre), the new one is started after (ExecStartPost). Both are no-ops on the first start,
+// where containerd starts the container.
+func (p *initProcess) restartOptions() ([]*unit.UnitOption, error) {
+	if !p.restartable() {
+		return nil, nil
+	}
+
+	del, err := p.runcCmd([]string{"delete", "--force", p.id})
+	if err != nil {
+		return nil, err
+	}
+	start, err := p.runcCmd([]string{"start", p.id})
+	if err != nil {
+		return nil, err
+	}
+
+	const svc = "Service"
+	opts := []*unit.UnitOption{
+		unit.NewUnitOption(svc, "Restart", p.opts.Restart),
+		unit.NewUnitOption(svc, "ExecStartPre", p.privileged(p.exe+" --bundle="+p.Bundle+" restart-prepare "+strings.Join(del, " "))),
+		unit.NewUnitOption(svc, "ExecStartPost", p.privileged(p.exe+" --bundle="+p.Bundle+" restart-start "+strings.Join(start, " "))),
+	}
+	if p.opts.RestartSec > 0 {
+		opts = append(opts, unit.NewUnitOption(svc, "RestartSec", p.opts.RestartSec.String()))
+	}
+	return opts, nil
+}
+
+// restartPrepare is run before the container is created by the unit.
+// When the unit ran before, the exit state written by the exit command is moved away so the next exit can be recorded,
+// and the exited container is deleted from the runtime so it can be created again.
+func restartPrepare(ctx context.Context, bundle string, deleteCmd []string) error {
+	exitPath := os.Getenv("EXIT_STATE_PATH")
+	if _, err := os.Stat(exitPath); err != nil {
+		if os.IsNotExist(err) {
+			// First start
+			return nil
+		}
+		return err
+	}
+
+	log.G(ctx).Info("Container unit is being restarted")
+	if err := os.Rename(exitPath, filepath.Join(bundle, lastExitStateFileName)); err != nil {
+		return fmt.Errorf("error saving last exit state: %w", err)
+	}
+	if err := os.Remove(filepath.Join(bundle, exitStatusFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(bundle, restartMarkerFileName), nil, 0600); err != nil {
+		return err
+	}
+	if out, err := exec.CommandContext(ctx, deleteCmd[0], deleteCmd[1:]...).CombinedOutput(); err != nil {
+		// It may well be gone already.
+		log.G(ctx).WithError(err).Debugf("Error deleting exited container: %s", out)
+	}
+	return nil
+}
+
+// restartStart is run once the container was created by the unit, it starts the container when the unit is being
+// restarted.
+func restartStart(ctx context.Context, bundle string, startCmd []string) error {
+	marker := filepath.Join(bundle, restartMarkerFileName)
+	if _, err := os.Stat(marker); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if out, err := exec.CommandContext(ctx, startCmd[0], startCmd[1:]...).CombinedOutput(); err != nil {
+		return fmt.Errorf("error starting restarted container: %w: %s", err, out)
+	}
+	return os.Remove(marker)
+}
+
+func (p *initProcess) readLastExitState(st *pState) error {
+	data, err := os.ReadFile(filepath.Join(p.Bundle, lastExitStateFileName))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, st)
+}
+
+// checkRestart picks up restarts of the unit by systemd.
+// The exit of the previous process is reported if it wasn't yet, then the state is reset to the new process and
+// TaskStart and TaskRestart are sent. Several restarts between two checks are reported as one.
+func (p *initProcess) checkRestart(ctx context.Context) {
+	if !p.restartable() {
+		return
+	}
+
+	props, err := p.systemd.GetUnitTypePropertiesContext(ctx, p.Name(), "Service")
+	if err != nil {
+		log.G(ctx).WithError(err).Debug("Error getting unit restarts")
+		return
+	}
+	n, _ := props["NRestarts"].(uint32)
+	pid, _ := props["MainPID"].(uint32)
+
+	p.mu.Lock()
+	restarted := n > p.restarts && !p.deleted
+	p.mu.Unlock()
+	if !restarted || pid == 0 {
+		// Not restarted, or the new process is not up yet.
+		return
+	}
+	if _, err := os.Stat(filepath.Join(p.Bundle, restartMarkerFileName)); err == nil {
+		// Created but not started yet, see restartStart.
+		return
+	}
+
+	if !p.ProcessState().Exited() {
+		var st pState
+		if err := p.readLastExitState(&st); err != nil || !st.Exited() {
+			log.G(ctx).WithError(err).Debug("Missing exit state of restarted container")
+			st = pState{Pid: p.Pid(), ExitCode: 255, ExitedAt: time.Now(), Status: statusStopped}
+		}
+		p.SetState(ctx, st)
+	}
+
+	var st pState
+	st.Reset()
+	st.Pid = pid
+	st.Status = "running"
+
+	p.mu.Lock()
+	p.state = st
+	p.restarts = n
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	log.G(ctx).WithField("pid", pid).WithField("restarts", n).Info("Container was restarted by systemd")
+	p.sendEvent(ctx, p.ns, &eventsapi.TaskStart{ContainerID: p.id, Pid: pid})
+	p.sendEvent(ctx, p.ns, &options.TaskRestart{ContainerId: p.id, Pid: pid, RestartCount: n})
+	p.checkOOM(ctx)
+
+	if err := p.saveTask(p); err != nil {
+		log.G(ctx).WithError(err).Error("Error saving task state")
+	}
+}
+
+// restartCount returns how many times the container was restarted by systemd, as far as we know.
+func (p *initProcess) restartCount() uint32 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.restarts
+}
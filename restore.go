@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/go-runc"
+	ptypes "github.com/gogo/protobuf/types"
+)
+
+const taskStateFile = "shim-state.json"
+
+// taskRecord is the persisted state of a container.
+// It holds everything that is not available from systemd or the bundle so a restarted shim can pick up where it left off.
+type taskRecord struct {
+	Namespace string
+	ID        string
+	Bundle    string
+	// StateDir is the directory the runtime state of the container is kept in, see containerStateDir.
+	// Containers created before it was recorded keep their state in the bundle.
+	StateDir string `json:",omitempty"`
+	// BootID is the boot ID of the host when the task was saved, see exitAfterReboot.
+	BootID         string `json:",omitempty"`
+	Rootfs         []*types.Mount
+	Stdin          string
+	Stdout         string
+	Stderr         string
+	Terminal       bool
+	NoNewNamespace bool
+	Options        CreateOptions
+	Runc           runcRecord
+	Unit           string
+	Pid            uint32
+	TTYSocket      string `json:",omitempty"`
+	TraceID        string `json:",omitempty"`
+	// Restarts is the number of restarts by systemd that were reported, see checkRestart.
+	Restarts uint32 `json:",omitempty"`
+	// RuntimeArgs are the arguments of the runtime in the unit, see initProcess.runtimeArgs.
+	RuntimeArgs []string `json:",omitempty"`
+	Execs       []execRecord
+}
+
+type runcRecord struct {
+	Root          string
+	SystemdCgroup bool
+	Log           string
+}
+
+type execRecord struct {
+	ID        string
+	Spec      *ptypes.Any
+	Stdin     string
+	Stdout    string
+	Stderr    string
+	Terminal  bool
+	Options   CreateOptions
+	Unit      string
+	Pid       uint32
+	TTYSocket string `json:",omitempty"`
+	// RuncLog is the runc debug log of the exec.
+	RuncLog string `json:",omitempty"`
+	TraceID string `json:",omitempty"`
+}
+
+// taskIndexPath is where we keep a link to the bundle of each container so they can be found again on startup.
+func (s *Service) taskIndexPath(ns, id string) string {
+	return filepath.Join(s.root, "tasks", ns, id)
+}
+
+func (p *initProcess) taskStatePath() string {
+	return filepath.Join(p.Bundle, taskStateFile)
+}
+
+func (p *initProcess) record() taskRecord {
+	rec := taskRecord{
+		Namespace:      p.ns,
+		ID:             p.id,
+		Bundle:         p.Bundle,
+		StateDir:       p.root,
+		Rootfs:         p.Rootfs,
+		Stdin:          p.Stdin,
+		Stdout:         p.Stdout,
+		Stderr:         p.Stderr,
+		Terminal:       p.Terminal,
+		NoNewNamespace: p.noNewNamespace,
+		Options:        p.opts,
+		Runc: runcRecord{
+			Root:          p.runc.Root,
+			SystemdCgroup: p.runc.SystemdCgroup,
+			Log:           p.runc.Log,
+		},
+		Unit:        p.Name(),
+		Pid:         p.Pid(),
+		TraceID:     p.traceID,
+		Restarts:    p.restartCount(),
+		RuntimeArgs: p.runtimeArgs,
+	}
+	if p.Terminal || p.opts.Terminal {
+		rec.TTYSocket, _ = p.ttySockPath()
+	}
+
+	p.execs.Each(func(exec Process) {
+		ep := exec.(*execProcess)
+		er := execRecord{
+			ID:       ep.execID,
+			Spec:     ep.Spec,
+			Stdin:    ep.Stdin,
+			Stdout:   ep.Stdout,
+			Stderr:   ep.Stderr,
+			Terminal: ep.Terminal,
+			Options:  ep.opts,
+			Unit:     ep.Name(),
+			Pid:      ep.Pid(),
+			RuncLog:  ep.runc.Log,
+			TraceID:  ep.traceID,
+		}
+		if ep.Terminal || ep.opts.Terminal {
+			er.TTYSocket, _ = ep.ttySockPath()
+		}
+		rec.Execs = append(rec.Execs, er)
+	})
+	return rec
+}
+
+// saveTask persists the state of the container.
+func (s *Service) saveTask(p *initProcess) error {
+	rec := p.record()
+	rec.BootID = s.bootID
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("error marshalling task state: %w", err)
+	}
+
+	tmp := p.taskStatePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing task state: %w", err)
+	}
+	if err := os.Rename(tmp, p.taskStatePath()); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing task state: %w", err)
+	}
+
+	idx := s.taskIndexPath(p.ns, p.id)
+	if err := os.MkdirAll(filepath.Dir(idx), 0700); err != nil {
+		return err
+	}
+	if target, err := os.Readlink(idx); err == nil && target == p.Bundle {
+		return nil
+	}
+	os.Remove(idx)
+	if err := os.Symlink(p.Bundle, idx); err != nil {
+		return fmt.Errorf("error indexing task state: %w", err)
+	}
+	return nil
+}
+
+// forgetTask removes all persisted state for the container.
+func (s *Service) forgetTask(p *initProcess) {
+	if err := os.Remove(s.taskIndexPath(p.ns, p.id)); err != nil && !os.IsNotExist(err) {
+		log.G(context.TODO()).WithError(err).WithField("id", p.id).Warn("Error removing task index")
+	}
+	if err := os.Remove(p.taskStatePath()); err != nil && !os.IsNotExist(err) {
+		log.G(context.TODO()).WithError(err).WithField("id", p.id).Warn("Error removing task state")
+	}
+}
+
+// Recover loads all persisted sandboxes and containers and reconciles the containers with systemd.
+// This must be called before serving requests so that containers created by a previous instance of the shim are not orphaned.
+// Processes that exited while the shim was down get their exit events sent as normal.
+func (s *Service) Recover(ctx context.Context) error {
+	if err := s.recoverSandboxes(ctx); err != nil {
+		return err
+	}
+
+	nsDirs, err := os.ReadDir(filepath.Join(s.root, "tasks"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, nsDir := range nsDirs {
+		ns := nsDir.Name()
+		entries, err := os.ReadDir(filepath.Join(s.root, "tasks", ns))
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("ns", ns).Warn("Error reading task index")
+			continue
+		}
+		for _, e := range entries {
+			idx := s.taskIndexPath(ns, e.Name())
+			ctx := log.WithLogger(ctx, log.G(ctx).WithField("id", e.Name()).WithField("ns", ns))
+
+			data, err := os.ReadFile(filepath.Join(idx, taskStateFile))
+			if err != nil {
+				if os.IsNotExist(err) {
+					// The bundle is gone, nothing left to recover.
+					log.G(ctx).Debug("Removing stale task index")
+					os.Remove(idx)
+					continue
+				}
+				log.G(ctx).WithError(err).Warn("Error reading task state")
+				continue
+			}
+
+			var rec taskRecord
+			if err := json.Unmarshal(data, &rec); err != nil {
+				log.G(ctx).WithError(err).Warn("Error unmarshalling task state")
+				continue
+			}
+
+			if err := s.recoverTask(ctx, rec); err != nil {
+				log.G(ctx).WithError(err).Error("Error recovering task")
+				continue
+			}
+			log.G(ctx).Info("Recovered task")
+		}
+	}
+	return nil
+}
+
+func (s *Service) recoverTask(ctx context.Context, rec taskRecord) error {
+	shimLog := OpenShimLog(ctx, rec.Bundle)
+	ctx = WithShimLog(ctx, shimLog)
+
+	debug := s.debug || rec.Options.Debug
+	logPath := rec.Runc.Log
+	if logPath == "" {
+		logPath = filepath.Join(rec.Bundle, initRuntimeLog)
+	}
+
+	rt := s.restoreRuntime(ctx, rec.Options.BinaryName)
+
+	p := &initProcess{
+		process: &process{
+			ns:       rec.Namespace,
+			id:       rec.ID,
+			opts:     rec.Options,
+			Stdin:    rec.Stdin,
+			Stdout:   rec.Stdout,
+			Stderr:   rec.Stderr,
+			Terminal: rec.Terminal,
+			systemd:  s.conn,
+			reloader: s.reloader,
+			runc: &runc.Runc{
+				Debug:         debug,
+				Command:       rt.Path,
+				SystemdCgroup: rec.Runc.SystemdCgroup,
+				PdeathSignal:  syscall.SIGKILL,
+				Rootless:      rt.rootless(rec.Options.unitUser()),
+				Root:          rec.Runc.Root,
+				Log:           logPath,
+				LogFormat:     rt.logFormat(),
+				Criu:          rec.Options.CriuPath,
+			},
+			runtime:    rt,
+			exe:        s.exe,
+			root:       s.recordedStateDir(ctx, rec),
+			shimCgroup: rec.Options.ShimCgroup,
+			state:      pState{Pid: rec.Pid},
+			traceID:    rec.TraceID,
+		},
+		Bundle:         rec.Bundle,
+		Rootfs:         rec.Rootfs,
+		noNewNamespace: rec.NoNewNamespace,
+		cniState:       s.cniStatePath(rec.Namespace, rec.ID),
+		sendEvent:      s.send,
+		saveTask:       s.saveTask,
+		execs:          newProcessManager(),
+		shimLog:        shimLog,
+		restarts:       rec.Restarts,
+		runtimeArgs:    rec.RuntimeArgs,
+	}
+	p.process.cond = sync.NewCond(&p.process.mu)
+
+	if rec.Unit != p.Name() {
+		return fmt.Errorf("unit name mismatch, expected %s, got %s", p.Name(), rec.Unit)
+	}
+	rebooted := s.bootedSince(rec)
+
+	for _, er := range rec.Execs {
+		ep := &execProcess{
+			Spec:   er.Spec,
+			parent: p,
+			execID: er.ID,
+			process: &process{
+				ns:       rec.Namespace,
+				root:     p.root,
+				id:       er.ID,
+				Stdin:    er.Stdin,
+				Stdout:   er.Stdout,
+				Stderr:   er.Stderr,
+				Terminal: er.Terminal,
+				systemd:  s.conn,
+				reloader: s.reloader,
+				exe:      s.exe,
+				opts:     er.Options,
+				runc: &runc.Runc{
+					Debug:         p.runc.Debug,
+					Command:       p.runc.Command,
+					SystemdCgroup: p.runc.SystemdCgroup,
+					PdeathSignal:  syscall.SIGKILL,
+					Rootless:      p.runc.Rootless,
+					Root:          p.runc.Root,
+					LogFormat:     p.runc.LogFormat,
+				},
+				runtime: p.runtime,
+				state:   pState{Pid: er.Pid},
+				traceID: er.TraceID,
+			},
+		}
+		ep.runc.Log = er.RuncLog
+		if ep.runc.Log == "" {
+			ep.runc.Log = filepath.Join(ep.stateDir(), execRuntimeLog)
+		}
+		ep.process.cond = sync.NewCond(&ep.process.mu)
+
+		if er.Unit != ep.Name() {
+			log.G(ctx).WithField("exec", er.ID).Warnf("Skipping exec with unit name mismatch, expected %s, got %s", ep.Name(), er.Unit)
+			continue
+		}
+
+		if er.Pid == 0 && !rebooted {
+			// The exec was never started, so the unit needs to be ready for a later Start call.
+			// For transient units the unit properties only existed in memory.
+			opts, err := ep.startOptions()
+			if err != nil {
+				return err
+			}
+			if err := ep.installUnit(ctx, ep.Name(), opts); err != nil {
+				return err
+			}
+		}
+
+		if err := p.execs.Add(er.ID, ep); err != nil {
+			return fmt.Errorf("exec %s: %w", er.ID, err)
+		}
+	}
+
+	if err := s.processes.Add(path.Join(rec.Namespace, rec.ID), p); err != nil {
+		return err
+	}
+	if err := p.lockRuntimeState(); err != nil {
+		log.G(ctx).WithError(err).Error("Error locking runtime state")
+	}
+	if rebooted {
+		p.execs.Each(func(ep Process) {
+			s.units.Add(ep)
+		})
+		s.units.Add(p)
+		s.exitAfterReboot(ctx, p)
+		return nil
+	}
+
+	// Load the exec states first so any exits get reported before the container exit.
+	p.execs.Each(func(ep Process) {
+		if err := ep.LoadState(ctx); err != nil {
+			log.G(ctx).WithError(err).WithField("unit", ep.Name()).Warn("Error loading exec state")
+		}
+		if ep.Pid() > 0 && !ep.ProcessState().Exited() {
+			ep := ep.(*execProcess)
+			ep.startLogRelay(ctx, ep.Name())
+			if ep.hasTerminal() {
+				if sockPath, err := ep.ttySockPath(); err == nil {
+					ep.reconnectTTY(ctx, sockPath, ep)
+				}
+			}
+		}
+		s.units.Add(ep)
+	})
+	if err := p.LoadState(ctx); err != nil {
+		log.G(ctx).WithError(err).Warn("Error loading container state")
+	}
+	if !p.ProcessState().Exited() {
+		p.startLogRelay(ctx, p.Name())
+		if p.hasTerminal() {
+			if sockPath, err := p.ttySockPath(); err == nil {
+				p.reconnectTTY(ctx, sockPath, p)
+			}
+		}
+	}
+	s.units.Add(p)
+
+	return nil
+}
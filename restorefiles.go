@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/selinux/go-selinux"
+)
+
+// A container restored from a checkpoint has the mounts it was checkpointed with, but when the checkpoint was made on
+// another host the files mounted from the host may not exist on this one. /etc/resolv.conf and /etc/hosts are such
+// files: they are generated for the container by whoever created it and often live in its state on the old host.
+// Before the restore the shim renders them again, with the settings from the restoreDNS* and restoreHosts annotations
+// or from the host, and points the mounts of the spec at the new files.
+//
+// A file is rendered when an annotation for it is set or when the source of its mount doesn't exist, files that are
+// there are otherwise kept. Only existing mounts are changed: criu restores exactly the mounts of the checkpoint, a
+// mount can't be added.
+const (
+	// restoreDNSAnnotation is the comma separated list of nameservers, e.g. "10.0.0.2,10.0.0.3".
+	restoreDNSAnnotation = shimName + ".restore.dns"
+	// restoreDNSSearchAnnotation is the comma separated list of search domains.
+	restoreDNSSearchAnnotation = shimName + ".restore.dns-search"
+	// restoreDNSOptionsAnnotation is the comma separated list of resolver options, e.g. "ndots:2,timeout:1".
+	restoreDNSOptionsAnnotation = shimName + ".restore.dns-options"
+	// restoreHostsAnnotation is the comma separated list of extra /etc/hosts entries as name:ip, e.g. "db:10.0.0.5".
+	restoreHostsAnnotation = shimName + ".restore.hosts"
+	// restoreTemplatesAnnotation names the templates in the shim config the files are rendered with, the templates
+	// named "default" are used if there are any, the built-in ones otherwise. Setting it renders both files.
+	restoreTemplatesAnnotation = shimName + ".restore.templates"
+)
+
+const (
+	defaultRestoreTemplates = "default"
+	// restoreFilesDir is where the rendered files are kept, in the bundle.
+	restoreFilesDir = "restore-files"
+
+	hostResolvConf = "/etc/resolv.conf"
+	// resolvedResolvConf lists the upstream servers of systemd-resolved, for when the host only has the stub resolver.
+	resolvedResolvConf = "/run/systemd/resolve/resolv.conf"
+)
+
+// restoreTemplates are the paths of text/template files for the files rendered on restore, a template that isn't
+// set is the built-in one. The templates are executed with etcFileData.
+type restoreTemplates struct {
+	ResolvConf string `toml:"resolv_conf" json:"resolv_conf"`
+	Hosts      string `toml:"hosts" json:"hosts"`
+}
+
+const (
+	builtinResolvConfTemplate = `{{if .Search}}search {{join .Search " "}}
+{{end}}{{range .Nameservers}}nameserver {{.}}
+{{end}}{{if .Options}}options {{join .Options " "}}
+{{end}}`
+
+	builtinHostsTemplate = `127.0.0.1	localhost
+::1	localhost ip6-localhost ip6-loopback
+{{range .Hosts}}{{.IP}}	{{join .Names " "}}
+{{end}}`
+)
+
+var etcFileFuncs = template.FuncMap{"join": strings.Join}
+
+func (t restoreTemplates) validate() error {
+	for _, p := range []string{t.ResolvConf, t.Hosts} {
+		if p == "" {
+			continue
+		}
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("template %q must be an absolute path: %w", p, errdefs.ErrInvalidArgument)
+		}
+		if _, err := loadEtcFileTemplate(p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreTemplates returns the templates named name, the built-in ones for the default name if it isn't configured.
+func (c *shimConfig) restoreTemplates(name string) (restoreTemplates, error) {
+	if c != nil {
+		if t, ok := c.RestoreTemplates[name]; ok {
+			return t, nil
+		}
+	}
+	if name == defaultRestoreTemplates {
+		return restoreTemplates{}, nil
+	}
+	return restoreTemplates{}, fmt.Errorf("annotation %s: no restore templates named %q in the shim config: %w", restoreTemplatesAnnotation, name, errdefs.ErrNotFound)
+}
+
+// loadEtcFileTemplate parses the template file at p, or builtin if p is empty.
+func loadEtcFileTemplate(p, builtin string) (*template.Template, error) {
+	name, text := "builtin", builtin
+	if p != "" {
+		name = p
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("error reading template: %w", err)
+		}
+		text = string(data)
+	}
+	t, err := template.New(name).Funcs(etcFileFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template %s: %v: %w", name, err, errdefs.ErrInvalidArgument)
+	}
+	return t, nil
+}
+
+// etcFileData is what the templates of the rendered files are executed with.
+type etcFileData struct {
+	// Hostname is the hostname of the container from the spec.
+	Hostname    string
+	Nameservers []string
+	Search      []string
+	Options     []string
+	// Hosts are the entries from restoreHostsAnnotation.
+	Hosts []hostsEntry
+}
+
+type hostsEntry struct {
+	IP    string
+	Names []string
+}
+
+// restoreStage prepares the spec of a container for its restore, it reports if it changed the spec.
+type restoreStage struct {
+	name string
+	run  func(ctx context.Context, bundle string, spec *specs.Spec) (bool, error)
+}
+
+// prepareRestore runs the restore stages on the spec in the bundle, which is written back if a stage changed it.
+func (s *Service) prepareRestore(ctx context.Context, bundle string) error {
+	ctx, span := StartSpan(ctx, "service.PrepareRestore")
+	defer span.End()
+
+	stages := []restoreStage{
+		{name: "etc-files", run: s.renderEtcFiles},
+	}
+
+	spec, err := readFullBundleSpec(bundle)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, st := range stages {
+		c, err := st.run(ctx, bundle, spec)
+		if err != nil {
+			return fmt.Errorf("error preparing restore (%s): %w", st.name, err)
+		}
+		changed = changed || c
+	}
+	if !changed {
+		return nil
+	}
+	return writeBundleSpec(bundle, spec)
+}
+
+// etcFile is a file rendered on restore.
+type etcFile struct {
+	dest     string
+	template string
+	builtin  string
+	// annotated is set when an annotation for the file is set, the file is then always rendered.
+	annotated bool
+}
+
+// renderEtcFiles is the restore stage rendering /etc/resolv.conf and /etc/hosts.
+func (s *Service) renderEtcFiles(ctx context.Context, bundle string, spec *specs.Spec) (bool, error) {
+	a := spec.Annotations
+	name := a[restoreTemplatesAnnotation]
+	chosen := name != ""
+	if !chosen {
+		name = defaultRestoreTemplates
+	}
+	templates, err := s.currentConfig().restoreTemplates(name)
+	if err != nil {
+		return false, err
+	}
+
+	data := etcFileData{Hostname: spec.Hostname}
+	if data.Nameservers, err = parseNameservers(a[restoreDNSAnnotation]); err != nil {
+		return false, err
+	}
+	data.Search = splitList(a[restoreDNSSearchAnnotation])
+	data.Options = splitList(a[restoreDNSOptionsAnnotation])
+	if data.Hosts, err = parseHostsEntries(a[restoreHostsAnnotation]); err != nil {
+		return false, err
+	}
+
+	files := []etcFile{
+		{
+			dest:      "/etc/resolv.conf",
+			template:  templates.ResolvConf,
+			builtin:   builtinResolvConfTemplate,
+			annotated: chosen || a[restoreDNSAnnotation] != "" || a[restoreDNSSearchAnnotation] != "" || a[restoreDNSOptionsAnnotation] != "",
+		},
+		{
+			dest:      "/etc/hosts",
+			template:  templates.Hosts,
+			builtin:   builtinHostsTemplate,
+			annotated: chosen || a[restoreHostsAnnotation] != "",
+		},
+	}
+
+	changed := false
+	for _, f := range files {
+		i := bindMountIndex(spec, f.dest)
+		if i < 0 {
+			if f.annotated {
+				return false, fmt.Errorf("container has no %s mount to render: %w", f.dest, errdefs.ErrInvalidArgument)
+			}
+			continue
+		}
+		src := spec.Mounts[i].Source
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(bundle, src)
+		}
+		if _, err := os.Stat(src); err == nil && !f.annotated {
+			continue
+		}
+
+		fileData := data
+		if f.dest == "/etc/resolv.conf" && len(fileData.Nameservers) == 0 {
+			// Search domains and options from annotations are used with the nameservers of the host.
+			host := hostResolvConfig(hostNetwork(spec))
+			fileData.Nameservers = host.Nameservers
+			if len(fileData.Search) == 0 {
+				fileData.Search = host.Search
+			}
+			if len(fileData.Options) == 0 {
+				fileData.Options = host.Options
+			}
+		}
+
+		p, err := renderEtcFile(bundle, f, fileData, spec)
+		if err != nil {
+			return false, err
+		}
+		log.G(ctx).WithField("mount", f.dest).WithField("source", p).Debug("Rendered file for restore")
+		spec.Mounts[i].Source = p
+		changed = true
+	}
+	return changed, nil
+}
+
+func renderEtcFile(bundle string, f etcFile, data etcFileData, spec *specs.Spec) (string, error) {
+	t, err := loadEtcFileTemplate(f.template, f.builtin)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("error rendering %s: %v: %w", f.dest, err, errdefs.ErrInvalidArgument)
+	}
+
+	dir := filepath.Join(bundle, restoreFilesDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	p := filepath.Join(dir, filepath.Base(f.dest))
+	if err := os.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	if selinuxEnabled && spec.Linux != nil && spec.Linux.MountLabel != "" {
+		if err := selinux.SetFileLabel(p, spec.Linux.MountLabel); err != nil {
+			return "", fmt.Errorf("error setting SELinux label on %s: %w", p, err)
+		}
+	}
+	return p, nil
+}
+
+// bindMountIndex returns the index of the bind mount at dest in the spec, -1 if there is none.
+func bindMountIndex(spec *specs.Spec, dest string) int {
+	for i := len(spec.Mounts) - 1; i >= 0; i-- {
+		m := spec.Mounts[i]
+		if filepath.Clean(m.Destination) != dest {
+			continue
+		}
+		if m.Type == "bind" {
+			return i
+		}
+		for _, o := range m.Options {
+			if o == "bind" || o == "rbind" {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// hostNetwork reports if the container is in the network namespace of the host.
+func hostNetwork(spec *specs.Spec) bool {
+	if spec.Linux == nil {
+		return true
+	}
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == specs.NetworkNamespace {
+			return false
+		}
+	}
+	return true
+}
+
+// hostResolvConfig returns the resolver config of the host.
+// Loopback nameservers, like the stub resolver of systemd-resolved, are not reachable from a network namespace of the
+// container's own: they are dropped, and the upstream servers of systemd-resolved are used when that leaves none.
+func hostResolvConfig(hostNet bool) etcFileData {
+	conf := parseResolvConf(hostResolvConf)
+	if hostNet {
+		return conf
+	}
+	conf.Nameservers = withoutLoopback(conf.Nameservers)
+	if len(conf.Nameservers) == 0 {
+		conf.Nameservers = withoutLoopback(parseResolvConf(resolvedResolvConf).Nameservers)
+	}
+	return conf
+}
+
+// parseResolvConf reads the nameservers, search domains and options from the resolv.conf at p.
+func parseResolvConf(p string) etcFileData {
+	var conf etcFileData
+	f, err := os.Open(p)
+	if err != nil {
+		return conf
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			conf.Nameservers = append(conf.Nameservers, fields[1])
+		case "search", "domain":
+			// The last search or domain line wins.
+			conf.Search = fields[1:]
+		case "options":
+			conf.Options = append(conf.Options, fields[1:]...)
+		}
+	}
+	return conf
+}
+
+func withoutLoopback(servers []string) []string {
+	var out []string
+	for _, s := range servers {
+		if ip := net.ParseIP(s); ip != nil && !ip.IsLoopback() {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func parseNameservers(v string) ([]string, error) {
+	servers := splitList(v)
+	for _, s := range servers {
+		if net.ParseIP(s) == nil {
+			return nil, fmt.Errorf("annotation %s: invalid nameserver %q: %w", restoreDNSAnnotation, s, errdefs.ErrInvalidArgument)
+		}
+	}
+	return servers, nil
+}
+
+// parseHostsEntries parses name:ip entries, names with the same address share an entry.
+func parseHostsEntries(v string) ([]hostsEntry, error) {
+	var entries []hostsEntry
+	index := make(map[string]int)
+	for _, e := range splitList(v) {
+		i := strings.IndexByte(e, ':')
+		if i <= 0 || net.ParseIP(e[i+1:]) == nil || strings.ContainsAny(e[:i], " \t") {
+			return nil, fmt.Errorf("annotation %s: invalid entry %q, expected name:ip: %w", restoreHostsAnnotation, e, errdefs.ErrInvalidArgument)
+		}
+		name, ip := e[:i], e[i+1:]
+		if j, ok := index[ip]; ok {
+			entries[j].Names = append(entries[j].Names, name)
+			continue
+		}
+		index[ip] = len(entries)
+		entries = append(entries, hostsEntry{IP: ip, Names: []string{name}})
+	}
+	return entries, nil
+}
+
+// splitList splits a comma separated annotation value, empty items are dropped.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
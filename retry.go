@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	dbus "github.com/godbus/dbus/v5"
+	"golang.org/x/sys/unix"
+)
+
+// retryPolicy is how failed operations are retried: the D-Bus calls to systemd, runtime commands, unmounts and the
+// start of container units. Only errors that say the operation didn't happen and may work a bit later are retried,
+// see retryReason, with exponential backoff between attempts.
+type retryPolicy struct {
+	attempts   int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{attempts: 5, minBackoff: 100 * time.Millisecond, maxBackoff: 5 * time.Second}
+
+// opRetries is the policy in use, it is set from the shim config when the service is created.
+var opRetries = defaultRetryPolicy
+
+// retryConfig is the retry section of the shim config, settings that are not set keep their default.
+type retryConfig struct {
+	// Attempts is how many times an operation is tried, 1 turns retries off.
+	Attempts int `toml:"attempts" json:"attempts"`
+	// MinBackoff and MaxBackoff bound the wait between attempts, e.g. "100ms".
+	MinBackoff string `toml:"min_backoff" json:"min_backoff"`
+	MaxBackoff string `toml:"max_backoff" json:"max_backoff"`
+}
+
+// parse returns the policy with the settings from the config.
+func (c retryConfig) parse() (retryPolicy, error) {
+	p := defaultRetryPolicy
+	if c.Attempts < 0 {
+		return p, fmt.Errorf("invalid retry attempts %d: %w", c.Attempts, errdefs.ErrInvalidArgument)
+	}
+	if c.Attempts > 0 {
+		p.attempts = c.Attempts
+	}
+	for _, v := range []struct {
+		s string
+		d *time.Duration
+	}{{c.MinBackoff, &p.minBackoff}, {c.MaxBackoff, &p.maxBackoff}} {
+		if v.s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v.s)
+		if err != nil || d <= 0 {
+			return p, fmt.Errorf("invalid retry backoff %q: %w", v.s, errdefs.ErrInvalidArgument)
+		}
+		*v.d = d
+	}
+	if p.maxBackoff < p.minBackoff {
+		return p, fmt.Errorf("retry max_backoff is less than min_backoff: %w", errdefs.ErrInvalidArgument)
+	}
+	return p, nil
+}
+
+// systemdBusyErrors are the errors systemd returns when it can't take a job right now.
+var systemdBusyErrors = map[string]bool{
+	"org.freedesktop.systemd1.TransactionIsDestructive":   true,
+	"org.freedesktop.systemd1.TransactionJobsConflicting": true,
+	"org.freedesktop.DBus.Error.LimitsExceeded":           true,
+}
+
+// retryReason returns why err is worth retrying, or an empty string if it is not:
+// "unavailable" if systemd couldn't be reached (see isUnavailable), "busy" if systemd or the kernel (EBUSY) was busy,
+// "again" for EAGAIN, e.g. from the runtime failing to fork.
+// Timeouts are not retried, they already took as long as the operation may take.
+func retryReason(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return ""
+	}
+	if isUnavailable(err) {
+		return "unavailable"
+	}
+	var dErr dbus.Error
+	if errors.As(err, &dErr) && systemdBusyErrors[dErr.Name] {
+		return "busy"
+	}
+	if isBusy(err) {
+		return "busy"
+	}
+	// The runtime only gives us its error output.
+	if errors.Is(err, unix.EAGAIN) || strings.Contains(err.Error(), "resource temporarily unavailable") {
+		return "again"
+	}
+	return ""
+}
+
+// retry calls fn until it succeeds, fails with an error that is not worth retrying, runs out of attempts or ctx is
+// done. op names the operation in logs and metrics.
+// wake, if not nil, is called after a failed attempt for a channel that ends the backoff early, e.g. when a lost
+// connection is back.
+func retry(ctx context.Context, op string, fn func(ctx context.Context) error, wake func() <-chan struct{}) error {
+	backoff := opRetries.minBackoff
+	for i := 1; ; i++ {
+		err := fn(ctx)
+		if err == nil || i >= opRetries.attempts {
+			return err
+		}
+		reason := retryReason(err)
+		if reason == "" {
+			return err
+		}
+
+		metrics.retries.Inc(op, reason)
+		log.G(ctx).WithError(err).WithField("operation", op).WithField("reason", reason).WithField("attempt", i).Debug("Retrying")
+
+		var woken <-chan struct{}
+		if wake != nil {
+			woken = wake()
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-woken:
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > opRetries.maxBackoff {
+			backoff = opRetries.maxBackoff
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	dbus "github.com/godbus/dbus/v5"
+)
+
+// rootless is set when the shim is not running as root.
+// In rootless mode containers are run as units of the user's systemd instance (systemd --user) instead of the system
+// instance. Units, state and the shim socket live under XDG_RUNTIME_DIR and runc is run in rootless mode, which uses the
+// user instance for cgroups when systemd cgroups are enabled.
+//
+// Cgroup controllers have to be delegated to the user instance (the default for memory and pids on most distros) for
+// resource limits to work.
+var rootless = os.Geteuid() != 0
+
+// xdgRuntimeDir returns the runtime dir of the user the shim is running as.
+func xdgRuntimeDir() string {
+	if d := os.Getenv("XDG_RUNTIME_DIR"); d != "" {
+		return d
+	}
+	return filepath.Join("/run/user", strconv.Itoa(os.Geteuid()))
+}
+
+// connectSystemd connects to the systemd instance the shim manages containers with.
+func connectSystemd(ctx context.Context) (*systemd.Conn, error) {
+	if rootless {
+		return systemd.NewUserConnectionContext(ctx)
+	}
+	return systemd.NewSystemdConnectionContext(ctx)
+}
+
+// connectBus connects to the bus systemd is on, for calls that go-systemd doesn't support.
+func connectBus(ctx context.Context) (*dbus.Conn, error) {
+	if rootless {
+		return dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	}
+	return dbus.ConnectSystemBus(dbus.WithContext(ctx))
+}
+
+// runtimeUnitDir is where unit files for containers are written.
+func runtimeUnitDir() string {
+	if rootless {
+		return filepath.Join(xdgRuntimeDir(), "systemd/user")
+	}
+	return "/run/systemd/system"
+}
+
+// transientUnitDir is where systemd keeps the unit files of transient units.
+func transientUnitDir() string {
+	if rootless {
+		return filepath.Join(xdgRuntimeDir(), "systemd/transient")
+	}
+	return "/run/systemd/transient"
+}
+
+// installUnitDir is where the shim's own service and socket units are installed.
+func installUnitDir() string {
+	if rootless {
+		if d := os.Getenv("XDG_CONFIG_HOME"); d != "" {
+			return filepath.Join(d, "systemd/user")
+		}
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, ".config/systemd/user")
+	}
+	return "/etc/systemd/system"
+}
+
+// defaultRoot is the default directory the shim keeps its state in.
+func defaultRoot(stateDir string) string {
+	if rootless {
+		return filepath.Join(xdgRuntimeDir(), "containerd", shimName)
+	}
+	return filepath.Join(stateDir, shimName)
+}
+
+// defaultStateRoot is the default directory the per-container state is kept in, see containerStateDir.
+func defaultStateRoot() string {
+	if rootless {
+		return xdgRuntimeDir()
+	}
+	return "/run"
+}
+
+// defaultSocket is the default path of the socket the shim api is served on.
+func defaultSocket() string {
+	if rootless {
+		return filepath.Join(xdgRuntimeDir(), "containerd/s", serviceName+".sock")
+	}
+	return defaultAddress
+}
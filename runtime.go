@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/go-runc"
+)
+
+// probeTimeout bounds how long we wait on the runtime binary when probing it.
+const probeTimeout = 10 * time.Second
+
+// runcCheckpointAnnotation is set in the output of `runc features` when runc was built with checkpoint support.
+const runcCheckpointAnnotation = "org.opencontainers.runc.checkpoint.enabled"
+
+// ociRuntime describes the OCI runtime binary used for a container.
+// runc, crun and youki take mostly the same arguments, but only runc accepts values for boolean flags
+// (e.g. --no-pivot=false), the others only understand the bare flag.
+type ociRuntime struct {
+	// Name is the runtime implementation, e.g. "runc", "crun" or "youki".
+	Name    string
+	Path    string
+	Version string
+	// Features is the output of the `features` command, nil if the runtime doesn't support it.
+	Features *runtimeFeatures
+}
+
+// runtimeFeatures is the subset of the OCI runtime features document we care about.
+type runtimeFeatures struct {
+	OCIVersionMin string            `json:"ociVersionMin,omitempty"`
+	OCIVersionMax string            `json:"ociVersionMax,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// probeRuntime figures out which runtime the binary is and what it supports.
+func probeRuntime(ctx context.Context, bin string) (*ociRuntime, error) {
+	ctx = withLogSubsystem(ctx, logRunc)
+	p, err := exec.LookPath(bin)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up runtime %s: %w", bin, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	rt := &ociRuntime{Name: filepath.Base(p), Path: p}
+
+	out, err := exec.CommandContext(ctx, p, "--version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error probing runtime %s: %w", p, err)
+	}
+	// The first line is "<name> version <version>" for all of the runtimes we know about.
+	line := strings.SplitN(string(out), "\n", 2)[0]
+	if f := strings.Fields(line); len(f) >= 3 && f[1] == "version" {
+		rt.Name = f[0]
+		rt.Version = f[2]
+	} else if len(f) == 2 {
+		// youki prints "youki <version>"
+		rt.Name = f[0]
+		rt.Version = f[1]
+	}
+
+	out, err = exec.CommandContext(ctx, p, "features").Output()
+	if err == nil {
+		var features runtimeFeatures
+		if err := json.Unmarshal(bytes.TrimSpace(out), &features); err == nil {
+			rt.Features = &features
+		}
+	}
+
+	log.G(ctx).WithField("runtime", rt.Name).WithField("version", rt.Version).WithField("path", rt.Path).WithField("features", rt.Features != nil).Debug("Probed OCI runtime")
+	return rt, nil
+}
+
+// logFormat is the --log-format for the runtime, runc and crun log JSON which keeps the level and time of entries
+// apart from the message. Others log in their default format.
+func (r *ociRuntime) logFormat() runc.Format {
+	switch r.Name {
+	case "runc", "crun":
+		return runc.JSON
+	}
+	return ""
+}
+
+// boolFlag formats a boolean flag for the runtime.
+func (r *ociRuntime) boolFlag(name string, v bool) []string {
+	if r.Name == "runc" {
+		return []string{"--" + name + "=" + strconv.FormatBool(v)}
+	}
+	if v {
+		return []string{"--" + name}
+	}
+	return nil
+}
+
+// rootless is the value for the runtime's --rootless flag, nil leaves it to the runtime.
+// The runtime is rootless when the shim is, or when the unit runs as another user (unitUser).
+// youki doesn't have the flag and detects rootless mode itself, runc and crun take it as --rootless=<bool>.
+func (r *ociRuntime) rootless(unitUser bool) *bool {
+	if !(rootless || unitUser) || r.Name == "youki" {
+		return nil
+	}
+	v := true
+	return &v
+}
+
+// supportsCheckpoint returns false if the runtime is known not to support checkpoint/restore.
+func (r *ociRuntime) supportsCheckpoint() bool {
+	switch r.Name {
+	case "youki":
+		return false
+	case "runc":
+		if r.Features != nil {
+			if v, ok := r.Features.Annotations[runcCheckpointAnnotation]; ok {
+				return v == "true"
+			}
+		}
+	}
+	return true
+}
+
+// runtimeCache keeps the probed runtimes so each binary is only probed once.
+type runtimeCache struct {
+	mu sync.Mutex
+	ls map[string]*ociRuntime
+}
+
+// runtime returns the runtime for the binary name from the create options, the default runtime is used if it is empty.
+func (s *Service) runtime(ctx context.Context, bin string) (*ociRuntime, error) {
+	if bin == "" {
+		bin = s.runcBin
+	}
+
+	s.runtimes.mu.Lock()
+	defer s.runtimes.mu.Unlock()
+
+	if rt, ok := s.runtimes.ls[bin]; ok {
+		return rt, nil
+	}
+
+	rt, err := probeRuntime(ctx, bin)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, errdefs.ErrInvalidArgument)
+	}
+	if s.runtimes.ls == nil {
+		s.runtimes.ls = make(map[string]*ociRuntime)
+	}
+	s.runtimes.ls[bin] = rt
+	return rt, nil
+}
+
+// restoreRuntime is like runtime but for containers we already have, if the runtime can't be probed anymore we still
+// want to be able to manage the container as well as we can.
+func (s *Service) restoreRuntime(ctx context.Context, bin string) *ociRuntime {
+	rt, err := s.runtime(ctx, bin)
+	if err != nil {
+		log.G(ctx).WithError(err).WithField(logSubsystemKey, logRunc).Warn("Error probing runtime")
+		if bin == "" {
+			bin = s.runcBin
+		}
+		return &ociRuntime{Name: filepath.Base(bin), Path: bin}
+	}
+	return rt
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+// The runtime root of a container (the runc root with the namespace appended) can be shared, by shim instances with
+// the same root, by a runc_root in the shim config or the root create option pointing at the same directory, or with
+// other runc users. The shim holds an advisory lock on the state of each of its containers in the runtime root for as
+// long as it manages the container, so one can't create, or clean up the state of, a container of another.
+// The lock is a file next to the runtime's state directory of the container, runc ignores files in its root.
+
+// runtimeLockPath is the lock file of the container in the runtime root.
+func runtimeLockPath(root, id string) string {
+	return filepath.Join(root, "."+id+".lock")
+}
+
+// lockRuntimeState takes the lock on the state of the container in the runtime root.
+func (p *initProcess) lockRuntimeState() error {
+	if err := os.MkdirAll(p.runc.Root, 0711); err != nil {
+		return err
+	}
+	lockPath := runtimeLockPath(p.runc.Root, p.id)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE|unix.O_CLOEXEC, 0600)
+		if err != nil {
+			return fmt.Errorf("error opening runtime lock: %w", err)
+		}
+		if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+			f.Close()
+			if err == unix.EWOULDBLOCK {
+				return fmt.Errorf("container %s is in use by another shim sharing runtime root %s: %w", p.id, p.runc.Root, errdefs.ErrAlreadyExists)
+			}
+			return fmt.Errorf("error locking runtime state: %w", err)
+		}
+
+		// The holder removes the file before it lets go, a lock on a removed file doesn't count.
+		var held, cur unix.Stat_t
+		if err := unix.Fstat(int(f.Fd()), &held); err != nil {
+			f.Close()
+			return err
+		}
+		if err := unix.Stat(lockPath, &cur); err == nil && cur.Dev == held.Dev && cur.Ino == held.Ino {
+			p.runtimeLock = f
+			return nil
+		}
+		f.Close()
+	}
+}
+
+// checkRuntimeState returns an error if the runtime already has state for a new container, which is not ours since we
+// hold the lock and don't know the container.
+func (p *initProcess) checkRuntimeState() error {
+	if _, err := os.Stat(filepath.Join(p.runc.Root, p.id, "state.json")); err == nil {
+		return fmt.Errorf("container %s already exists in runtime root %s: %w", p.id, p.runc.Root, errdefs.ErrAlreadyExists)
+	}
+	return nil
+}
+
+// unlockRuntimeState removes the lock file and releases the lock.
+func (p *initProcess) unlockRuntimeState(ctx context.Context) {
+	if p.runtimeLock == nil {
+		return
+	}
+	if err := os.Remove(runtimeLockPath(p.runc.Root, p.id)); err != nil && !os.IsNotExist(err) {
+		log.G(ctx).WithError(err).Debug("Error removing runtime lock")
+	}
+	p.runtimeLock.Close()
+	p.runtimeLock = nil
+}
+
+// idReservations are the containers being created, so a container can't be created twice at the same time.
+// Creating a container changes its bundle before it is added to the processes, which only one create may do.
+type idReservations struct {
+	mu  sync.Mutex
+	ids map[string]struct{}
+}
+
+// reserve reserves the id, it returns false if it is already reserved.
+func (r *idReservations) reserve(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.ids[id]; ok {
+		return false
+	}
+	if r.ids == nil {
+		r.ids = make(map[string]struct{})
+	}
+	r.ids[id] = struct{}{}
+	return true
+}
+
+func (r *idReservations) release(id string) {
+	r.mu.Lock()
+	delete(r.ids, id)
+	r.mu.Unlock()
+}
+
+// reserveContainer reserves the id of a new container for its create, the returned function releases it.
+func (s *Service) reserveContainer(ns, id string) (func(), error) {
+	key := ns + "/" + id
+	if !s.creating.reserve(key) {
+		return nil, fmt.Errorf("container %s is already being created: %w", id, errdefs.ErrAlreadyExists)
+	}
+	if s.processes.Get(key) != nil {
+		s.creating.release(key)
+		return nil, fmt.Errorf("container %s: %w", id, errdefs.ErrAlreadyExists)
+	}
+	return func() { s.creating.release(key) }, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The runtime always logs to a file of the process (runc.Runc.Log), not only with debug enabled, so its errors are
+// there when something fails in production. The shim keeps the last runtimeLogEntries entries of the file in memory
+// and adds them to errors from the runtime, see runtimeError and startDiagnostics.
+// The file is truncated once it grows past runtimeLogMaxSize, everything in it has been read into memory by then.
+const (
+	runtimeLogEntries = 100
+	runtimeLogMaxSize = 1 << 20
+
+	// initRuntimeLog and execRuntimeLog are the names of the runtime log files in the bundle and the exec state dir.
+	// The names are from when the runtime only logged with debug enabled.
+	initRuntimeLog = "init-runc-debug.log"
+	execRuntimeLog = "runc-debug.log"
+)
+
+// runtimeLog is the ring of the last entries of the runtime log of a process.
+// The zero value is ready to use.
+type runtimeLog struct {
+	mu sync.Mutex
+	// offset is how far the file has been read.
+	offset  int64
+	entries []string
+	// next is where the next entry goes once entries is full.
+	next int
+}
+
+// tail reads the new entries from the log file at p and returns the last n entries.
+func (l *runtimeLog) tail(p string, n int) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if p != "" {
+		l.read(p)
+	}
+
+	ordered := append(append([]string(nil), l.entries[l.next:]...), l.entries[:l.next]...)
+	if len(ordered) > n {
+		ordered = ordered[len(ordered)-n:]
+	}
+	return ordered
+}
+
+func (l *runtimeLog) read(p string) {
+	f, err := os.Open(p)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+	if fi.Size() < l.offset {
+		// Truncated or replaced by someone else.
+		l.offset = 0
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, l.offset, fi.Size()-l.offset))
+	if err != nil {
+		return
+	}
+	// A line that is still being written is read the next time.
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return
+	}
+	for _, line := range bytes.Split(data[:end], []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			l.add(formatRuntimeLogLine(line))
+		}
+	}
+	l.offset += int64(end) + 1
+
+	if l.offset > runtimeLogMaxSize {
+		// The runtime opens the file for appending, it keeps writing at the start after this.
+		if err := os.Truncate(p, 0); err == nil {
+			l.offset = 0
+		}
+	}
+}
+
+func (l *runtimeLog) add(e string) {
+	if len(l.entries) < runtimeLogEntries {
+		l.entries = append(l.entries, e)
+		return
+	}
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % runtimeLogEntries
+}
+
+// formatRuntimeLogLine formats an entry of the runtime's JSON log as "<time> <level> <msg>", lines that aren't JSON are
+// returned as they are.
+func formatRuntimeLogLine(line []byte) string {
+	var e struct {
+		Time  string `json:"time"`
+		Level string `json:"level"`
+		Msg   string `json:"msg"`
+	}
+	if err := json.Unmarshal(line, &e); err != nil || e.Msg == "" {
+		return string(bytes.TrimSpace(line))
+	}
+	return strings.TrimSpace(e.Time + " " + e.Level + " " + e.Msg)
+}
+
+// runtimeLogTail returns the last n entries of the runtime log of the process.
+func (p *process) runtimeLogTail(n int) []string {
+	if p.runc == nil {
+		return nil
+	}
+	return p.runtimeLog.tail(p.runc.Log, n)
+}
+
+// runtimeError adds the end of the runtime log to err, an error from running the runtime, and to the span in ctx.
+func (p *process) runtimeError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	lines := p.runtimeLogTail(diagnosticLines)
+	if len(lines) == 0 {
+		return err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.StringSlice("runtime.log", lines))
+	return fmt.Errorf("%w\nruntime log:\n  %s", err, strings.Join(lines, "\n  "))
+}
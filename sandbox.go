@@ -0,0 +1,701 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/cgroups"
+	"github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/typeurl"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	sandboxapi "github.com/cpuguy83/containerd-shim-systemd-v1/sandbox"
+	dbus "github.com/godbus/dbus/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sys/unix"
+)
+
+// The sandbox states containerd's CRI plugin knows.
+const (
+	sandboxReady    = "SANDBOX_READY"
+	sandboxNotReady = "SANDBOX_NOTREADY"
+)
+
+// podSandbox is a pod created through containerd's sandbox API.
+//
+// A sandbox is a slice unit. The units of the pod's containers are placed in the slice, so systemd accounts the
+// pod's resource usage on it and stopping the slice stops the whole pod. There is no process for the sandbox itself,
+// the pod's namespaces are set up by containerd and passed to the containers in their spec.
+type podSandbox struct {
+	mu  sync.Mutex
+	rec sandboxRecord
+}
+
+// sandboxRecord is the persisted state of a sandbox.
+type sandboxRecord struct {
+	Namespace   string
+	ID          string
+	Bundle      string
+	Slice       string
+	NetNSPath   string            `json:",omitempty"`
+	Annotations map[string]string `json:",omitempty"`
+	// CreatedAt is when the slice was started, zero until the sandbox is started.
+	CreatedAt time.Time
+	// ExitedAt is when the sandbox was stopped, zero while it is running.
+	ExitedAt time.Time
+}
+
+func (sb *podSandbox) record() sandboxRecord {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.rec
+}
+
+// sandboxPath is where the state of the sandbox is persisted so it can be found again on startup.
+func (s *Service) sandboxPath(ns, id string) string {
+	return filepath.Join(s.root, "sandboxes", ns, id+".json")
+}
+
+// saveSandbox persists the state of the sandbox.
+func (s *Service) saveSandbox(rec sandboxRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("error marshalling sandbox state: %w", err)
+	}
+
+	p := s.sandboxPath(rec.Namespace, rec.ID)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing sandbox state: %w", err)
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing sandbox state: %w", err)
+	}
+	return nil
+}
+
+// recoverSandboxes loads all persisted sandboxes.
+// This must be called before serving requests so containers created afterwards are placed in their sandbox's slice.
+func (s *Service) recoverSandboxes(ctx context.Context) error {
+	nsDirs, err := os.ReadDir(filepath.Join(s.root, "sandboxes"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, nsDir := range nsDirs {
+		ns := nsDir.Name()
+		entries, err := os.ReadDir(filepath.Join(s.root, "sandboxes", ns))
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("ns", ns).Warn("Error reading sandbox state")
+			continue
+		}
+		for _, e := range entries {
+			if filepath.Ext(e.Name()) != ".json" {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(s.root, "sandboxes", ns, e.Name()))
+			if err != nil {
+				log.G(ctx).WithError(err).WithField("ns", ns).Warn("Error reading sandbox state")
+				continue
+			}
+			var rec sandboxRecord
+			if err := json.Unmarshal(data, &rec); err != nil {
+				log.G(ctx).WithError(err).WithField("ns", ns).Warn("Error unmarshalling sandbox state")
+				continue
+			}
+			s.sandboxMu.Lock()
+			s.sandboxes[path.Join(rec.Namespace, rec.ID)] = &podSandbox{rec: rec}
+			s.sandboxMu.Unlock()
+			log.G(ctx).WithField("ns", ns).WithField("sandbox", rec.ID).Info("Recovered sandbox")
+		}
+	}
+	return nil
+}
+
+func (s *Service) getSandbox(ns, id string) (*podSandbox, error) {
+	s.sandboxMu.Lock()
+	defer s.sandboxMu.Unlock()
+	sb := s.sandboxes[path.Join(ns, id)]
+	if sb == nil {
+		return nil, fmt.Errorf("sandbox %s: %w", id, errdefs.ErrNotFound)
+	}
+	return sb, nil
+}
+
+// sandboxSlice returns the slice of the sandbox the container belongs to, empty if the pod was not created through
+// the sandbox API.
+func (s *Service) sandboxSlice(ns string, opts CreateOptions) string {
+	if opts.CRI.SandboxID == "" {
+		return ""
+	}
+	sb, err := s.getSandbox(ns, opts.CRI.SandboxID)
+	if err != nil {
+		return ""
+	}
+	return sb.record().Slice
+}
+
+// newSandboxSlice returns the slice of a new sandbox.
+// It is the slice annotation of the sandbox if set, otherwise the slice is named like podSlice names it for the
+// sandbox's containers, under the namespace's slice from the shim config.
+func newSandboxSlice(id string, annotations map[string]string, cfg namespaceConfig) (string, error) {
+	if slice := annotations[sliceAnnotation]; slice != "" {
+		return slice, validateSlice(slice)
+	}
+	parent := cfg.Slice
+	if parent == "" {
+		parent = defaultPodParentSlice
+	}
+	slice := strings.TrimSuffix(parent, ".slice") + "-pod" + id + ".slice"
+	return slice, validateSlice(slice)
+}
+
+// sliceState returns the active state of the slice, empty if systemd does not know it.
+func (s *Service) sliceState(ctx context.Context, slice string) (string, error) {
+	units, err := s.conn.ListUnitsByNamesContext(ctx, []string{slice})
+	if err != nil {
+		return "", fmt.Errorf("error looking up slice %s: %w", slice, err)
+	}
+	if len(units) == 0 {
+		return "", nil
+	}
+	return units[0].ActiveState, nil
+}
+
+// sliceActive reports if the slice is running.
+func sliceActive(state string) bool {
+	switch state {
+	case "", "inactive", "failed":
+		return false
+	}
+	return true
+}
+
+// CreateSandbox records a new sandbox, its slice is started by StartSandbox.
+func (s *Service) CreateSandbox(ctx context.Context, r *sandboxapi.CreateSandboxRequest) (_ *sandboxapi.CreateSandboxResponse, retErr error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+
+	ctx, span := StartSpan(ctx, "service.CreateSandbox", trace.WithAttributes(attribute.String(nsAttr, ns), attribute.String(sbIDAttr, r.SandboxId)))
+	defer func() {
+		if retErr != nil {
+			retErr = errdefs.ToGRPCf(retErr, "create sandbox")
+			span.SetStatus(codes.Error, retErr.Error())
+		}
+		span.End()
+	}()
+
+	if r.SandboxId == "" || strings.ContainsAny(r.SandboxId, "/\x00") || r.SandboxId == "." || r.SandboxId == ".." {
+		return nil, fmt.Errorf("invalid sandbox id %q: %w", r.SandboxId, errdefs.ErrInvalidArgument)
+	}
+	if len(r.Rootfs) > 0 {
+		// The sandbox has no process of its own, so there is nothing to mount the rootfs for.
+		log.G(ctx).WithField("sandbox", r.SandboxId).Debug("Ignoring sandbox rootfs")
+	}
+
+	slice, err := newSandboxSlice(r.SandboxId, r.Annotations, s.currentConfig().namespace(ns))
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.String(unitAttr, slice))
+
+	rec := sandboxRecord{
+		Namespace:   ns,
+		ID:          r.SandboxId,
+		Bundle:      r.BundlePath,
+		Slice:       slice,
+		NetNSPath:   r.NetnsPath,
+		Annotations: r.Annotations,
+	}
+
+	s.sandboxMu.Lock()
+	defer s.sandboxMu.Unlock()
+	key := path.Join(ns, r.SandboxId)
+	if _, ok := s.sandboxes[key]; ok {
+		return nil, fmt.Errorf("sandbox %s: %w", r.SandboxId, errdefs.ErrAlreadyExists)
+	}
+	if err := s.saveSandbox(rec); err != nil {
+		return nil, err
+	}
+	s.sandboxes[key] = &podSandbox{rec: rec}
+
+	log.G(ctx).WithField("sandbox", r.SandboxId).WithField("slice", slice).Debug("Created sandbox")
+	return &sandboxapi.CreateSandboxResponse{}, nil
+}
+
+// StartSandbox starts the slice of the sandbox.
+// A slice that is already running, e.g. because kubelet created it, is used as is.
+func (s *Service) StartSandbox(ctx context.Context, r *sandboxapi.StartSandboxRequest) (_ *sandboxapi.StartSandboxResponse, retErr error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+
+	ctx, span := StartSpan(ctx, "service.StartSandbox", trace.WithAttributes(attribute.String(nsAttr, ns), attribute.String(sbIDAttr, r.SandboxId)))
+	defer func() {
+		if retErr != nil {
+			retErr = errdefs.ToGRPCf(retErr, "start sandbox")
+			span.SetStatus(codes.Error, retErr.Error())
+		}
+		span.End()
+	}()
+
+	sb, err := s.getSandbox(ns, r.SandboxId)
+	if err != nil {
+		return nil, err
+	}
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if !sb.rec.CreatedAt.IsZero() {
+		return nil, fmt.Errorf("sandbox %s is already started: %w", r.SandboxId, errdefs.ErrFailedPrecondition)
+	}
+
+	if err := s.startSlice(ctx, sb.rec); err != nil {
+		return nil, err
+	}
+
+	rec := sb.rec
+	rec.CreatedAt = time.Now()
+	if err := s.saveSandbox(rec); err != nil {
+		return nil, err
+	}
+	sb.rec = rec
+
+	return &sandboxapi.StartSandboxResponse{CreatedAt: rec.CreatedAt}, nil
+}
+
+// startSlice starts the sandbox slice as a transient unit with resource accounting turned on.
+func (s *Service) startSlice(ctx context.Context, rec sandboxRecord) error {
+	state, err := s.sliceState(ctx, rec.Slice)
+	if err != nil {
+		return err
+	}
+	if sliceActive(state) {
+		return nil
+	}
+
+	properties := []systemd.Property{
+		systemd.PropDescription("containerd pod sandbox " + rec.Namespace + "/" + rec.ID),
+		{Name: "CPUAccounting", Value: dbus.MakeVariant(true)},
+		{Name: "MemoryAccounting", Value: dbus.MakeVariant(true)},
+		{Name: "IOAccounting", Value: dbus.MakeVariant(true)},
+		{Name: "TasksAccounting", Value: dbus.MakeVariant(true)},
+	}
+
+	ch := make(chan string, 1)
+	if _, err := s.conn.StartTransientUnitContext(ctx, rec.Slice, "replace", properties, ch); err != nil {
+		return fmt.Errorf("error starting sandbox slice %s: %w", rec.Slice, err)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case status := <-ch:
+		if status != "done" {
+			return fmt.Errorf("error starting sandbox slice %s: %s", rec.Slice, status)
+		}
+	}
+	return nil
+}
+
+// StopSandbox stops the slice of the sandbox and with it all units of the pod.
+// Units still running after the timeout are sent SIGKILL.
+func (s *Service) StopSandbox(ctx context.Context, r *sandboxapi.StopSandboxRequest) (_ *sandboxapi.StopSandboxResponse, retErr error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+
+	ctx, span := StartSpan(ctx, "service.StopSandbox", trace.WithAttributes(attribute.String(nsAttr, ns), attribute.String(sbIDAttr, r.SandboxId)))
+	defer func() {
+		if retErr != nil {
+			retErr = errdefs.ToGRPCf(retErr, "stop sandbox")
+			span.SetStatus(codes.Error, retErr.Error())
+		}
+		span.End()
+	}()
+
+	sb, err := s.getSandbox(ns, r.SandboxId)
+	if err != nil {
+		return nil, err
+	}
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if err := s.stopSlice(ctx, sb.rec, time.Duration(r.TimeoutSecs)*time.Second); err != nil {
+		return nil, err
+	}
+	if sb.rec.ExitedAt.IsZero() {
+		rec := sb.rec
+		rec.ExitedAt = time.Now()
+		if err := s.saveSandbox(rec); err != nil {
+			return nil, err
+		}
+		sb.rec = rec
+	}
+	return &sandboxapi.StopSandboxResponse{}, nil
+}
+
+// stopSlice stops the slice of the sandbox if it is running.
+// With a timeout, the containers in the slice are sent SIGKILL once it is over and the stop is waited for again.
+func (s *Service) stopSlice(ctx context.Context, rec sandboxRecord, timeout time.Duration) error {
+	state, err := s.sliceState(ctx, rec.Slice)
+	if err != nil {
+		return err
+	}
+	if !sliceActive(state) {
+		return nil
+	}
+
+	ch := make(chan string, 1)
+	if _, err := s.conn.StopUnitContext(ctx, rec.Slice, "replace", ch); err != nil {
+		return fmt.Errorf("error stopping sandbox slice %s: %w", rec.Slice, err)
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-expired:
+			expired = nil
+			log.G(ctx).WithField("sandbox", rec.ID).WithField("timeout", timeout).Warn("Sandbox did not stop within the timeout, sending SIGKILL")
+			s.killSandboxUnits(ctx, rec)
+		case status := <-ch:
+			if status != "done" {
+				return fmt.Errorf("error stopping sandbox slice %s: %s", rec.Slice, status)
+			}
+			return nil
+		}
+	}
+}
+
+// killSandboxUnits sends SIGKILL to the container units in the slice of the sandbox.
+func (s *Service) killSandboxUnits(ctx context.Context, rec sandboxRecord) {
+	s.processes.Each(func(p Process) {
+		ip, ok := p.(*initProcess)
+		if !ok || ip.ns != rec.Namespace {
+			return
+		}
+		ip.mu.Lock()
+		slice := ip.opts.Slice
+		ip.mu.Unlock()
+		if slice == rec.Slice {
+			s.conn.KillUnitContext(ctx, ip.Name(), int32(unix.SIGKILL))
+		}
+	})
+}
+
+// WaitSandbox blocks until the sandbox is stopped, by StopSandbox or by stopping its slice outside of the shim.
+func (s *Service) WaitSandbox(ctx context.Context, r *sandboxapi.WaitSandboxRequest) (_ *sandboxapi.WaitSandboxResponse, retErr error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+
+	ctx, span := StartSpan(ctx, "service.WaitSandbox", trace.WithAttributes(attribute.String(nsAttr, ns), attribute.String(sbIDAttr, r.SandboxId)))
+	defer func() {
+		if retErr != nil {
+			retErr = errdefs.ToGRPCf(retErr, "wait sandbox")
+			span.SetStatus(codes.Error, retErr.Error())
+		}
+		span.End()
+	}()
+
+	sb, err := s.getSandbox(ns, r.SandboxId)
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		exitedAt, err := s.sandboxExited(ctx, sb)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("sandbox", r.SandboxId).Debug("Error checking sandbox state")
+		}
+		if !exitedAt.IsZero() {
+			return &sandboxapi.WaitSandboxResponse{ExitedAt: exitedAt}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// sandboxExited returns when the sandbox was stopped, zero if it is still running.
+// A started sandbox whose slice is gone is recorded as stopped.
+func (s *Service) sandboxExited(ctx context.Context, sb *podSandbox) (time.Time, error) {
+	rec := sb.record()
+	if !rec.ExitedAt.IsZero() || rec.CreatedAt.IsZero() {
+		return rec.ExitedAt, nil
+	}
+	state, err := s.sliceState(ctx, rec.Slice)
+	if err != nil || sliceActive(state) {
+		return time.Time{}, err
+	}
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if sb.rec.ExitedAt.IsZero() {
+		rec := sb.rec
+		rec.ExitedAt = time.Now()
+		if err := s.saveSandbox(rec); err != nil {
+			return time.Time{}, err
+		}
+		sb.rec = rec
+	}
+	return sb.rec.ExitedAt, nil
+}
+
+// SandboxStatus reports the sandbox as ready while its slice is running.
+func (s *Service) SandboxStatus(ctx context.Context, r *sandboxapi.SandboxStatusRequest) (_ *sandboxapi.SandboxStatusResponse, retErr error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+
+	ctx, span := StartSpan(ctx, "service.SandboxStatus", trace.WithAttributes(attribute.String(nsAttr, ns), attribute.String(sbIDAttr, r.SandboxId)))
+	defer func() {
+		if retErr != nil {
+			retErr = errdefs.ToGRPCf(retErr, "sandbox status")
+			span.SetStatus(codes.Error, retErr.Error())
+		}
+		span.End()
+	}()
+
+	sb, err := s.getSandbox(ns, r.SandboxId)
+	if err != nil {
+		return nil, err
+	}
+	exitedAt, err := s.sandboxExited(ctx, sb)
+	if err != nil {
+		return nil, err
+	}
+	rec := sb.record()
+
+	resp := &sandboxapi.SandboxStatusResponse{
+		SandboxId: rec.ID,
+		State:     sandboxNotReady,
+		CreatedAt: rec.CreatedAt,
+		ExitedAt:  exitedAt,
+	}
+	if !rec.CreatedAt.IsZero() && exitedAt.IsZero() {
+		resp.State = sandboxReady
+	}
+	if r.Verbose {
+		resp.Info = map[string]string{"slice": rec.Slice}
+		if rec.NetNSPath != "" {
+			resp.Info["netns"] = rec.NetNSPath
+		}
+		if g, err := unitTypeCgroup(ctx, s.conn, rec.Slice, "Slice"); err == nil {
+			resp.Info["cgroup"] = g
+		}
+		if state := s.sandboxFreezerState(ctx, rec.Slice); state != "" {
+			resp.Info["freezer"] = state
+		}
+	}
+	return resp, nil
+}
+
+// PingSandbox checks that the sandbox is known to the shim.
+func (s *Service) PingSandbox(ctx context.Context, r *sandboxapi.PingRequest) (*sandboxapi.PingResponse, error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	if _, err := s.getSandbox(ns, r.SandboxId); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	return &sandboxapi.PingResponse{}, nil
+}
+
+// ShutdownSandbox stops the slice of the sandbox if it is still running and forgets the sandbox.
+// The shim serves all sandboxes and containers, so it keeps running.
+func (s *Service) ShutdownSandbox(ctx context.Context, r *sandboxapi.ShutdownSandboxRequest) (_ *sandboxapi.ShutdownSandboxResponse, retErr error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+
+	ctx, span := StartSpan(ctx, "service.ShutdownSandbox", trace.WithAttributes(attribute.String(nsAttr, ns), attribute.String(sbIDAttr, r.SandboxId)))
+	defer func() {
+		if retErr != nil {
+			retErr = errdefs.ToGRPCf(retErr, "shutdown sandbox")
+			span.SetStatus(codes.Error, retErr.Error())
+		}
+		span.End()
+	}()
+
+	sb, err := s.getSandbox(ns, r.SandboxId)
+	if err != nil {
+		return nil, err
+	}
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if err := s.stopSlice(ctx, sb.rec, 0); err != nil {
+		return nil, err
+	}
+
+	s.sandboxMu.Lock()
+	delete(s.sandboxes, path.Join(ns, r.SandboxId))
+	s.sandboxMu.Unlock()
+	if err := os.Remove(s.sandboxPath(ns, r.SandboxId)); err != nil && !os.IsNotExist(err) {
+		log.G(ctx).WithError(err).WithField("sandbox", r.SandboxId).Warn("Error removing sandbox state")
+	}
+	return &sandboxapi.ShutdownSandboxResponse{}, nil
+}
+
+// freezeSandbox freezes every unit of the pod through the systemd freezer of the sandbox slice.
+// Freezing a slice needs cgroup v2, same as pausing containers with the systemd freezer.
+func (s *Service) freezeSandbox(ctx context.Context, ns, id string) error {
+	return s.sandboxFreezer(ctx, ns, id, "FreezeUnit")
+}
+
+// thawSandbox thaws a pod frozen by freezeSandbox.
+func (s *Service) thawSandbox(ctx context.Context, ns, id string) error {
+	return s.sandboxFreezer(ctx, ns, id, "ThawUnit")
+}
+
+func (s *Service) sandboxFreezer(ctx context.Context, ns, id, method string) error {
+	if cgroups.Mode() != cgroups.Unified {
+		return fmt.Errorf("freezing a sandbox needs cgroup v2: %w", errdefs.ErrNotImplemented)
+	}
+	sb, err := s.getSandbox(ns, id)
+	if err != nil {
+		return err
+	}
+	rec := sb.record()
+	if rec.CreatedAt.IsZero() || !rec.ExitedAt.IsZero() {
+		return fmt.Errorf("sandbox %s is not running: %w", id, errdefs.ErrFailedPrecondition)
+	}
+	if err := callManager(ctx, s.conn, method, rec.Slice).Err; err != nil {
+		return fmt.Errorf("error freezing sandbox slice %s: %w", rec.Slice, err)
+	}
+	return nil
+}
+
+// sandboxFreezerState returns the freezer state systemd reports for the sandbox slice, e.g. "running" or "frozen".
+func (s *Service) sandboxFreezerState(ctx context.Context, slice string) string {
+	prop, err := s.conn.GetUnitTypePropertyContext(ctx, slice, "Unit", "FreezerState")
+	if err != nil {
+		return ""
+	}
+	v, _ := prop.Value.Value().(string)
+	return v
+}
+
+// handleSandboxFreezer serves POST /sandboxes/freeze and /sandboxes/thaw on the debug socket, the sandbox is given
+// by the namespace and id query parameters.
+func (s *Service) handleSandboxFreezer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ns, id := r.URL.Query().Get("namespace"), r.URL.Query().Get("id")
+	var err error
+	switch path.Base(r.URL.Path) {
+	case "freeze":
+		err = s.freezeSandbox(r.Context(), ns, id)
+	case "thaw":
+		err = s.thawSandbox(r.Context(), ns, id)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case errdefs.IsNotFound(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errdefs.IsFailedPrecondition(err), errdefs.IsNotImplemented(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Platform returns the platform of the host, containers run directly on it.
+func (s *Service) Platform(ctx context.Context, r *sandboxapi.PlatformRequest) (*sandboxapi.PlatformResponse, error) {
+	p := platforms.DefaultSpec()
+	return &sandboxapi.PlatformResponse{
+		Platform: &types.Platform{
+			OS:           p.OS,
+			Architecture: p.Architecture,
+			Variant:      p.Variant,
+		},
+	}, nil
+}
+
+// SandboxMetrics returns the cgroup metrics of the sandbox slice, which covers all units of the pod.
+func (s *Service) SandboxMetrics(ctx context.Context, r *sandboxapi.SandboxMetricsRequest) (_ *sandboxapi.SandboxMetricsResponse, retErr error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+
+	ctx, span := StartSpan(ctx, "service.SandboxMetrics", trace.WithAttributes(attribute.String(nsAttr, ns), attribute.String(sbIDAttr, r.SandboxId)))
+	defer func() {
+		if retErr != nil {
+			retErr = errdefs.ToGRPCf(retErr, "sandbox metrics")
+			span.SetStatus(codes.Error, retErr.Error())
+		}
+		span.End()
+	}()
+
+	sb, err := s.getSandbox(ns, r.SandboxId)
+	if err != nil {
+		return nil, err
+	}
+	rec := sb.record()
+
+	g, err := unitTypeCgroup(ctx, s.conn, rec.Slice, "Slice")
+	if err != nil {
+		return nil, fmt.Errorf("sandbox %s is not running: %w", rec.ID, errdefs.ErrFailedPrecondition)
+	}
+	stats, err := unitCgroupStats(g)
+	if err != nil {
+		return nil, fmt.Errorf("error reading sandbox metrics: %w", err)
+	}
+	data, err := typeurl.MarshalAny(stats)
+	if err != nil {
+		return nil, err
+	}
+	return &sandboxapi.SandboxMetricsResponse{
+		Metrics: &types.Metric{
+			Timestamp: time.Now(),
+			ID:        rec.ID,
+			Data:      data,
+		},
+	}, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/ttrpc"
+	sandboxapi "github.com/cpuguy83/containerd-shim-systemd-v1/sandbox"
+)
+
+func TestNewSandboxSlice(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		cfg         namespaceConfig
+		want        string
+		invalid     bool
+	}{
+		{name: "default parent", want: "kubepods-podabc.slice"},
+		{name: "namespace slice", cfg: namespaceConfig{Slice: "pods.slice"}, want: "pods-podabc.slice"},
+		{
+			name:        "annotation",
+			annotations: map[string]string{sliceAnnotation: "kubepods-burstable-pod123.slice"},
+			cfg:         namespaceConfig{Slice: "pods.slice"},
+			want:        "kubepods-burstable-pod123.slice",
+		},
+		{name: "invalid annotation", annotations: map[string]string{sliceAnnotation: "pod.service"}, invalid: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := newSandboxSlice("abc", tc.annotations, tc.cfg)
+			if tc.invalid {
+				if !errdefs.IsInvalidArgument(err) {
+					t.Fatalf("expected invalid argument, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCreateSandbox(t *testing.T) {
+	root := t.TempDir()
+	s := &Service{root: root, sandboxes: make(map[string]*podSandbox)}
+	ctx := namespaces.WithNamespace(context.Background(), "k8s.io")
+
+	req := &sandboxapi.CreateSandboxRequest{
+		SandboxId:   "abc",
+		BundlePath:  "/run/containerd/bundle",
+		NetnsPath:   "/var/run/netns/cni-abc",
+		Annotations: map[string]string{"foo": "bar"},
+	}
+	if _, err := s.CreateSandbox(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateSandbox(ctx, req); !errdefs.IsAlreadyExists(errdefs.FromGRPC(err)) {
+		t.Fatalf("expected already exists, got %v", err)
+	}
+	if _, err := s.CreateSandbox(ctx, &sandboxapi.CreateSandboxRequest{SandboxId: "../abc"}); !errdefs.IsInvalidArgument(errdefs.FromGRPC(err)) {
+		t.Fatalf("expected invalid argument, got %v", err)
+	}
+
+	var opts CreateOptions
+	opts.CRI.SandboxID = "abc"
+	if got := s.sandboxSlice("k8s.io", opts); got != "kubepods-podabc.slice" {
+		t.Errorf("got slice %q for container of the sandbox", got)
+	}
+	if got := s.sandboxSlice("default", opts); got != "" {
+		t.Errorf("got slice %q for container in another namespace", got)
+	}
+
+	// A restarted shim finds the sandbox again.
+	s2 := &Service{root: root, sandboxes: make(map[string]*podSandbox)}
+	if err := s2.recoverSandboxes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sb, err := s2.getSandbox("k8s.io", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := sb.record()
+	if rec.Slice != "kubepods-podabc.slice" || rec.NetNSPath != req.NetnsPath || rec.Bundle != req.BundlePath || rec.Annotations["foo"] != "bar" {
+		t.Errorf("unexpected recovered sandbox: %+v", rec)
+	}
+	if _, err := s2.PingSandbox(ctx, &sandboxapi.PingRequest{SandboxId: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s2.PingSandbox(ctx, &sandboxapi.PingRequest{SandboxId: "other"}); !errdefs.IsNotFound(errdefs.FromGRPC(err)) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	// The slice is not started yet, so there is nothing to freeze.
+	if err := s2.freezeSandbox(ctx, "k8s.io", "abc"); !errdefs.IsFailedPrecondition(err) && !errdefs.IsNotImplemented(err) {
+		t.Fatalf("expected failed precondition, got %v", err)
+	}
+}
+
+func TestSandboxService(t *testing.T) {
+	srv, err := ttrpc.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	sandboxapi.RegisterSandboxService(srv, &Service{sandboxes: make(map[string]*podSandbox)})
+
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(context.Background(), l)
+
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ttrpc.NewClient(conn)
+	defer client.Close()
+
+	resp, err := sandboxapi.NewSandboxClient(client).Platform(context.Background(), &sandboxapi.PlatformRequest{SandboxId: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Platform.OS != runtime.GOOS || resp.Platform.Architecture != runtime.GOARCH {
+		t.Errorf("unexpected platform %+v", resp.Platform)
+	}
+}
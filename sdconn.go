@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	dbus "github.com/godbus/dbus/v5"
+)
+
+const (
+	reconnectMinBackoff = 100 * time.Millisecond
+	reconnectMaxBackoff = 5 * time.Second
+	// jobRecoverTimeout bounds how long we wait for a unit to settle when its job was lost with the connection.
+	jobRecoverTimeout = 2 * time.Minute
+)
+
+// sdConn is a connection to systemd that survives systemd re-executing (daemon-reexec) and the bus restarting.
+//
+// It has the same methods as the go-systemd connection it wraps. When the connection is lost it is re-dialed in the
+// background with backoff and the OnReconnect hooks are run so signal subscriptions can be set up again.
+// Idempotent calls that fail because systemd is unavailable are retried, anything else (starting units, sending
+// signals) is returned to the caller.
+type sdConn struct {
+	ctx    context.Context
+	cancel func()
+
+	mu    sync.Mutex
+	cur   *sdSession
+	hooks []func()
+}
+
+// sdSession is one set of connections to systemd.
+type sdSession struct {
+	conn *systemd.Conn
+	bus  *dbus.Conn
+	// conns are the underlying connections of conn, used to detect when it is closed.
+	conns []*dbus.Conn
+	// lost is closed when any of the connections is closed.
+	lost chan struct{}
+	// next is closed once the session is replaced by a new one.
+	next chan struct{}
+}
+
+func newSDConn(ctx context.Context) (*sdConn, error) {
+	// The connection must outlive ctx, it is closed by Close.
+	cctx, cancel := context.WithCancel(context.Background())
+	c := &sdConn{ctx: withLogSubsystem(log.WithLogger(cctx, log.G(ctx)), logDBus), cancel: cancel}
+
+	s, err := c.dial()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	c.cur = s
+	go c.monitor()
+	return c, nil
+}
+
+// dial connects to systemd and the bus systemd is on, see connectSystemd and connectBus.
+func (c *sdConn) dial() (*sdSession, error) {
+	s := &sdSession{lost: make(chan struct{}), next: make(chan struct{})}
+
+	conn, err := systemd.NewConnection(func() (*dbus.Conn, error) {
+		bc, err := dialSystemd(c.ctx)
+		if err == nil {
+			s.conns = append(s.conns, bc)
+		}
+		return bc, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bus, err := connectBus(c.ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	s.conn = conn
+	s.bus = bus
+	return s, nil
+}
+
+// dialSystemd is the dialer go-systemd uses for connectSystemd.
+// We do our own dialing so we have access to the underlying connections to see when they are closed.
+func dialSystemd(ctx context.Context) (*dbus.Conn, error) {
+	var (
+		conn *dbus.Conn
+		err  error
+	)
+	if rootless {
+		conn, err = dbus.SessionBusPrivate(dbus.WithContext(ctx))
+	} else {
+		conn, err = dbus.Dial("unix:path=/run/systemd/private", dbus.WithContext(ctx))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Auth([]dbus.Auth{dbus.AuthExternal(strconv.Itoa(os.Getuid()))}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// Hello is skipped when talking to systemd directly.
+	if rootless {
+		if err := conn.Hello(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// monitor re-dials when the current session is lost.
+func (c *sdConn) monitor() {
+	for {
+		s := c.session()
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-s.conns[0].Context().Done():
+		case <-s.conns[1].Context().Done():
+		case <-s.bus.Context().Done():
+		}
+		close(s.lost)
+
+		log.G(c.ctx).Warn("Lost connection to systemd, reconnecting")
+
+		var (
+			next    *sdSession
+			err     error
+			backoff = reconnectMinBackoff
+		)
+		for {
+			next, err = c.dial()
+			if err == nil {
+				break
+			}
+			log.G(c.ctx).WithError(err).Debug("Error reconnecting to systemd")
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > reconnectMaxBackoff {
+				backoff = reconnectMaxBackoff
+			}
+		}
+
+		c.mu.Lock()
+		c.cur = next
+		hooks := c.hooks
+		c.mu.Unlock()
+		close(s.next)
+
+		s.conn.Close()
+		s.bus.Close()
+
+		metrics.reconnects.Inc()
+		log.G(c.ctx).Info("Reconnected to systemd")
+
+		for _, h := range hooks {
+			h()
+		}
+	}
+}
+
+func (c *sdConn) session() *sdSession {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cur
+}
+
+// Bus returns the current connection to the bus systemd is on.
+// It changes on reconnect, so it should not be held on to.
+func (c *sdConn) Bus() *dbus.Conn {
+	return c.session().bus
+}
+
+// OnReconnect registers fn to be called every time the connection is re-established.
+func (c *sdConn) OnReconnect(fn func()) {
+	c.mu.Lock()
+	c.hooks = append(c.hooks, fn)
+	c.mu.Unlock()
+}
+
+// Close closes the connection, it will not be re-established.
+func (c *sdConn) Close() {
+	c.cancel()
+	s := c.session()
+	s.conn.Unsubscribe()
+	s.conn.Close()
+	s.bus.Close()
+}
+
+// isUnavailable returns true if the error means the call didn't make it to systemd, e.g. because systemd is
+// re-executing or the connection was closed.
+func isUnavailable(err error) bool {
+	if errors.Is(err, dbus.ErrClosed) || errors.Is(err, io.EOF) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var dErr dbus.Error
+	if errors.As(err, &dErr) {
+		switch dErr.Name {
+		case "org.freedesktop.DBus.Error.NoReply",
+			"org.freedesktop.DBus.Error.ServiceUnknown",
+			"org.freedesktop.DBus.Error.NameHasNoOwner",
+			"org.freedesktop.DBus.Error.Disconnected":
+			return true
+		}
+	}
+	return false
+}
+
+// retry calls fn with the retry policy, see retryReason. Each attempt is bounded by the D-Bus timeout, fn must use the
+// context it is passed. If the session was lost the next attempt is made once it is back.
+func (c *sdConn) retry(ctx context.Context, fn func(ctx context.Context, s *sdSession) error) error {
+	var s *sdSession
+	return retry(withLogSubsystem(ctx, logDBus), "dbus", func(ctx context.Context) error {
+		s = c.session()
+		cctx, done := withTimeout(ctx, opDBus)
+		return done(fn(cctx, s))
+	}, func() <-chan struct{} {
+		select {
+		case <-s.lost:
+			return s.next
+		default:
+			// Still connected, systemd itself is going away or coming back.
+			return nil
+		}
+	})
+}
+
+// jobResult forwards the result of a job to ch.
+// Jobs are tracked per connection, if the connection is lost before the job completes the result is taken from the
+// state of the unit once reconnected.
+func (c *sdConn) jobResult(s *sdSession, name string, result <-chan string, ch chan<- string) {
+	select {
+	case status := <-result:
+		ch <- status
+		return
+	case <-s.lost:
+	}
+
+	select {
+	case <-c.ctx.Done():
+		ch <- "canceled"
+		return
+	case <-s.next:
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, jobRecoverTimeout)
+	defer cancel()
+	for {
+		units, err := c.ListUnitsByNamesContext(ctx, []string{name})
+		if err == nil && len(units) == 1 {
+			switch units[0].ActiveState {
+			case "active":
+				ch <- "done"
+				return
+			case "inactive", "failed":
+				ch <- "failed"
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			ch <- "timeout"
+			return
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// StartUnitContext queues a start job for the unit, the D-Bus timeout only bounds the call and not the job.
+func (c *sdConn) StartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	s := c.session()
+	ctx, done := withTimeout(ctx, opDBus)
+	if ch == nil {
+		id, err := s.conn.StartUnitContext(ctx, name, mode, nil)
+		return id, done(err)
+	}
+	result := make(chan string, 1)
+	id, err := s.conn.StartUnitContext(ctx, name, mode, result)
+	if err == nil {
+		go c.jobResult(s, name, result, ch)
+	}
+	return id, done(err)
+}
+
+func (c *sdConn) StartTransientUnitContext(ctx context.Context, name string, mode string, properties []systemd.Property, ch chan<- string) (int, error) {
+	s := c.session()
+	ctx, done := withTimeout(ctx, opDBus)
+	if ch == nil {
+		id, err := s.conn.StartTransientUnitContext(ctx, name, mode, properties, nil)
+		return id, done(err)
+	}
+	result := make(chan string, 1)
+	id, err := s.conn.StartTransientUnitContext(ctx, name, mode, properties, result)
+	if err == nil {
+		go c.jobResult(s, name, result, ch)
+	}
+	return id, done(err)
+}
+
+func (c *sdConn) StopUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	var id int
+	err := c.retry(ctx, func(ctx context.Context, s *sdSession) error {
+		if ch == nil {
+			var err error
+			id, err = s.conn.StopUnitContext(ctx, name, mode, nil)
+			return err
+		}
+		result := make(chan string, 1)
+		var err error
+		id, err = s.conn.StopUnitContext(ctx, name, mode, result)
+		if err == nil {
+			go c.jobResult(s, name, result, ch)
+		}
+		return err
+	})
+	return id, err
+}
+
+// KillUnitContext sends the signal to all processes of the unit.
+// Errors are ignored, same as go-systemd.
+func (c *sdConn) KillUnitContext(ctx context.Context, name string, signal int32) {
+	c.KillUnitWithTarget(ctx, name, systemd.All, signal)
+}
+
+// KillUnitWithTarget sends the signal to the processes of the unit, it is retried since the errors that are retried
+// mean the signal wasn't sent.
+func (c *sdConn) KillUnitWithTarget(ctx context.Context, name string, target systemd.Who, signal int32) error {
+	return c.retry(ctx, func(ctx context.Context, s *sdSession) error {
+		return s.conn.KillUnitWithTarget(ctx, name, target, signal)
+	})
+}
+
+func (c *sdConn) ResetFailedUnitContext(ctx context.Context, name string) error {
+	return c.retry(ctx, func(ctx context.Context, s *sdSession) error {
+		return s.conn.ResetFailedUnitContext(ctx, name)
+	})
+}
+
+func (c *sdConn) ReloadContext(ctx context.Context) error {
+	return c.retry(ctx, func(ctx context.Context, s *sdSession) error {
+		return s.conn.ReloadContext(ctx)
+	})
+}
+
+func (c *sdConn) ListUnitsByNamesContext(ctx context.Context, units []string) ([]systemd.UnitStatus, error) {
+	var ls []systemd.UnitStatus
+	err := c.retry(ctx, func(ctx context.Context, s *sdSession) error {
+		var err error
+		ls, err = s.conn.ListUnitsByNamesContext(ctx, units)
+		return err
+	})
+	return ls, err
+}
+
+func (c *sdConn) ListUnitsByPatternsContext(ctx context.Context, states []string, patterns []string) ([]systemd.UnitStatus, error) {
+	var ls []systemd.UnitStatus
+	err := c.retry(ctx, func(ctx context.Context, s *sdSession) error {
+		var err error
+		ls, err = s.conn.ListUnitsByPatternsContext(ctx, states, patterns)
+		return err
+	})
+	return ls, err
+}
+
+func (c *sdConn) GetAllPropertiesContext(ctx context.Context, unit string) (map[string]interface{}, error) {
+	var props map[string]interface{}
+	err := c.retry(ctx, func(ctx context.Context, s *sdSession) error {
+		var err error
+		props, err = s.conn.GetAllPropertiesContext(ctx, unit)
+		return err
+	})
+	return props, err
+}
+
+func (c *sdConn) GetUnitTypePropertyContext(ctx context.Context, unit string, unitType string, propertyName string) (*systemd.Property, error) {
+	var prop *systemd.Property
+	err := c.retry(ctx, func(ctx context.Context, s *sdSession) error {
+		var err error
+		prop, err = s.conn.GetUnitTypePropertyContext(ctx, unit, unitType, propertyName)
+		return err
+	})
+	return prop, err
+}
+
+func (c *sdConn) GetUnitTypePropertiesContext(ctx context.Context, unit string, unitType string) (map[string]interface{}, error) {
+	var props map[string]interface{}
+	err := c.retry(ctx, func(ctx context.Context, s *sdSession) error {
+		var err error
+		props, err = s.conn.GetUnitTypePropertiesContext(ctx, unit, unitType)
+		return err
+	})
+	return props, err
+}
+
+func (c *sdConn) SetUnitPropertiesContext(ctx context.Context, name string, runtime bool, properties ...systemd.Property) error {
+	return c.retry(ctx, func(ctx context.Context, s *sdSession) error {
+		return s.conn.SetUnitPropertiesContext(ctx, name, runtime, properties...)
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/selinux/go-selinux"
+)
+
+// selinuxEnabled is set with --selinux-enabled when SELinux is enabled on the host.
+// Files the shim creates for systemd and the runtime are then labeled so they can be used on enforcing hosts.
+var selinuxEnabled bool
+
+const (
+	apparmorEnabledPath  = "/sys/module/apparmor/parameters/enabled"
+	apparmorProfilesPath = "/sys/kernel/security/apparmor/profiles"
+)
+
+// labelLike gives p the SELinux label of ref.
+//
+// Files get the type of the directory they are created in by default, which is not always what the reader expects:
+// unit files need the type of the unit directory to be loaded by systemd, and the exec state directories and tty
+// socket directories (created in XDG_RUNTIME_DIR or /tmp) need the type of the bundle so the runtime can use them. pid
+// files and sockets created later in these directories inherit the label.
+func labelLike(p, ref string) error {
+	if !selinuxEnabled {
+		return nil
+	}
+	label, err := selinux.FileLabel(ref)
+	if err != nil {
+		return fmt.Errorf("error getting SELinux label of %s: %w", ref, err)
+	}
+	if label == "" {
+		return nil
+	}
+	if err := selinux.SetFileLabel(p, label); err != nil {
+		return fmt.Errorf("error setting SELinux label on %s: %w", p, err)
+	}
+	return nil
+}
+
+// checkAppArmorProfile makes sure the AppArmor profile requested in the spec is loaded.
+//
+// The runtime applies the profile to the container process when it execs it, the unit itself is not confined with it
+// (AppArmorProfile=) since the profiles meant for containers don't allow what the runtime does to set the container
+// up. Checking up front turns a failed exec deep in the runtime into a clear error on create.
+func checkAppArmorProfile(spec *specs.Spec) error {
+	if spec.Process == nil || spec.Process.ApparmorProfile == "" || spec.Process.ApparmorProfile == "unconfined" {
+		return nil
+	}
+	profile := spec.Process.ApparmorProfile
+
+	enabled, err := os.ReadFile(apparmorEnabledPath)
+	if err != nil || strings.TrimSpace(string(enabled)) != "Y" {
+		return fmt.Errorf("AppArmor profile %q requested but AppArmor is not enabled: %w", profile, errdefs.ErrFailedPrecondition)
+	}
+
+	f, err := os.Open(apparmorProfilesPath)
+	if err != nil {
+		// Not readable without CAP_MAC_ADMIN (e.g. rootless), leave it to the runtime.
+		return nil
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Each line is "<name> (<mode>)".
+		line := scanner.Text()
+		if i := strings.LastIndex(line, " ("); i > 0 && line[:i] == profile {
+			return nil
+		}
+	}
+	return fmt.Errorf("AppArmor profile %q is not loaded: %w", profile, errdefs.ErrFailedPrecondition)
+}
+
+// checkExecProcess checks the security settings of an exec process spec against the process of the container.
+//
+// The process spec is passed to the runtime as it is, which applies its SELinux label, AppArmor profile,
+// no_new_privileges and capabilities, and uses the ones of the container for those left out. A capability outside the
+// bounding set of the container can't be raised by a process in it and the runtime fails the exec, as it does for a
+// profile that is not loaded. Checking up front turns either into a clear error.
+func checkExecProcess(container, exec *specs.Process) error {
+	if err := checkAppArmorProfile(&specs.Spec{Process: exec}); err != nil {
+		return err
+	}
+	if container == nil || container.Capabilities == nil || exec.Capabilities == nil {
+		return nil
+	}
+
+	bounding := make(map[string]bool, len(container.Capabilities.Bounding))
+	for _, c := range container.Capabilities.Bounding {
+		bounding[c] = true
+	}
+	var extra []string
+	for _, set := range [][]string{
+		exec.Capabilities.Bounding,
+		exec.Capabilities.Effective,
+		exec.Capabilities.Permitted,
+		exec.Capabilities.Inheritable,
+		exec.Capabilities.Ambient,
+	} {
+		for _, c := range set {
+			if !bounding[c] {
+				// Marked so each capability is only reported once.
+				bounding[c] = true
+				extra = append(extra, c)
+			}
+		}
+	}
+	if len(extra) > 0 {
+		return fmt.Errorf("capabilities %s are not in the bounding set of the container: %w", strings.Join(extra, ", "), errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestCheckExecProcess(t *testing.T) {
+	container := &specs.Process{
+		Capabilities: &specs.LinuxCapabilities{
+			Bounding:  []string{"CAP_CHOWN", "CAP_KILL", "CAP_NET_BIND_SERVICE"},
+			Effective: []string{"CAP_CHOWN"},
+		},
+	}
+
+	for _, tc := range []struct {
+		name      string
+		container *specs.Process
+		exec      *specs.Process
+		// extra are the capabilities the error names, none if the exec is allowed.
+		extra []string
+	}{
+		{name: "no capabilities", container: container, exec: &specs.Process{}},
+		{name: "unconfined", container: container, exec: &specs.Process{ApparmorProfile: "unconfined"}},
+		{
+			name:      "within the bounding set",
+			container: container,
+			exec: &specs.Process{Capabilities: &specs.LinuxCapabilities{
+				Bounding:  []string{"CAP_CHOWN", "CAP_KILL"},
+				Effective: []string{"CAP_KILL"},
+				Ambient:   []string{"CAP_NET_BIND_SERVICE"},
+			}},
+		},
+		{
+			name:      "container without capabilities",
+			container: &specs.Process{},
+			exec:      &specs.Process{Capabilities: &specs.LinuxCapabilities{Effective: []string{"CAP_SYS_ADMIN"}}},
+		},
+		{
+			name:      "outside the bounding set",
+			container: container,
+			exec: &specs.Process{Capabilities: &specs.LinuxCapabilities{
+				Bounding:    []string{"CAP_CHOWN", "CAP_SYS_ADMIN"},
+				Effective:   []string{"CAP_SYS_ADMIN"},
+				Permitted:   []string{"CAP_SYS_ADMIN", "CAP_NET_ADMIN"},
+				Inheritable: []string{"CAP_SYS_PTRACE"},
+			}},
+			extra: []string{"CAP_SYS_ADMIN", "CAP_NET_ADMIN", "CAP_SYS_PTRACE"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkExecProcess(tc.container, tc.exec)
+			if len(tc.extra) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, errdefs.ErrInvalidArgument) {
+				t.Fatalf("expected invalid argument, got %v", err)
+			}
+			if want := strings.Join(tc.extra, ", "); !strings.Contains(err.Error(), want) {
+				t.Errorf("expected %q in the error, got %v", want, err)
+			}
+		})
+	}
+}
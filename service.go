@@ -5,15 +5,20 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/namespaces"
 	shimapi "github.com/containerd/containerd/runtime/v2/task"
 	"github.com/containerd/ttrpc"
 	"github.com/coreos/go-systemd/v22/daemon"
-	"github.com/coreos/go-systemd/v22/dbus"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
 	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+	sandboxapi "github.com/cpuguy83/containerd-shim-systemd-v1/sandbox"
 )
 
 const (
@@ -22,13 +27,20 @@ const (
 	serviceName    = "containerd-shim-systemd-v1"
 )
 
-func newService(ts shimapi.TaskService) (*service, error) {
-	s, err := ttrpc.NewServer(ttrpc.WithServerHandshaker(ttrpc.UnixSocketRequireSameUser()), ttrpc.WithUnaryServerInterceptor(UnaryServerInterceptor))
+// newService creates the ttrpc server of the shim. If ns is set the shim only serves that namespace, see
+// namespaceInterceptor.
+func newService(ts shimapi.TaskService, ss sandboxapi.SandboxService, ns string) (*service, error) {
+	interceptor := timeoutInterceptor
+	if ns != "" {
+		interceptor = namespaceInterceptor(ns, interceptor)
+	}
+	s, err := ttrpc.NewServer(ttrpc.WithServerHandshaker(ttrpc.UnixSocketRequireSameUser()), ttrpc.WithUnaryServerInterceptor(interceptor))
 	if err != nil {
 		return nil, err
 	}
 
 	shimapi.RegisterTaskService(s, ts)
+	sandboxapi.RegisterSandboxService(s, ss)
 
 	return &service{
 		srv: s,
@@ -45,19 +57,102 @@ func (s *service) Serve(ctx context.Context, l net.Listener) error {
 	return s.srv.Serve(ctx, l)
 }
 
+// Shutdown stops accepting new connections and waits for in flight requests to finish.
+func (s *service) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
 func (s *service) Close() error {
 	return s.srv.Close()
 }
 
+// namespaceInterceptor rejects requests for other namespaces than ns before passing them on to next.
+// A shim daemon of one namespace must not touch the containers of the others, each namespace has its own daemon then.
+func namespaceInterceptor(ns string, next ttrpc.UnaryServerInterceptor) ttrpc.UnaryServerInterceptor {
+	return func(ctx context.Context, u ttrpc.Unmarshaler, info *ttrpc.UnaryServerInfo, m ttrpc.Method) (interface{}, error) {
+		if got, ok := namespaces.Namespace(ctx); ok && got != ns {
+			return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "shim only serves namespace %q, not %q", ns, got)
+		}
+		return next(ctx, u, info, m)
+	}
+}
+
+// namespaceServiceName is the name of the shim daemon units of namespace ns in per-namespace mode, ns can also be a
+// specifier like "%i" for the template units.
+func namespaceServiceName(ns string) string {
+	return serviceName + "@" + ns
+}
+
+// namespaceAddr returns the socket path of namespace ns for the socket path addr, e.g.
+// /run/containerd/s/containerd-shim-systemd-v1@default.sock.
+func namespaceAddr(addr, ns string) string {
+	ext := filepath.Ext(addr)
+	return strings.TrimSuffix(addr, ext) + "@" + ns + ext
+}
+
 func serviceUnit(exe string, cfg installConfig) string {
+	// Paths and other values are taken as they are, systemd must not expand "%" in them as a specifier.
+	e := escapeSpecifiers
+	name, nsArgs := serviceName, []string(nil)
+	root, stateRoot, metricsAddr, debugAddr := e(cfg.Root), e(cfg.StateRoot), e(cfg.MetricsAddr), e(cfg.DebugAddr)
+	if cfg.PerNamespace {
+		// A template unit, %i is the namespace of the instance.
+		name, nsArgs = namespaceServiceName("%i"), []string{"--namespace=%i"}
+		root, stateRoot = filepath.Join(root, "%i"), filepath.Join(stateRoot, "%i")
+		if metricsAddr != "" {
+			metricsAddr = namespaceAddr(metricsAddr, "%i")
+		}
+		if debugAddr != "" {
+			debugAddr = namespaceAddr(debugAddr, "%i")
+		}
+	}
+
+	args := append([]string{e(exe), "--address=" + e(cfg.Addr)}, nsArgs...)
+	args = append(args,
+		"serve",
+		"--ttrpc-address="+e(cfg.TTRPCAddr),
+		"--debug="+strconv.FormatBool(cfg.Debug),
+		"--root="+root,
+		"--state-root="+stateRoot,
+		"--log-mode="+strings.ToLower(cfg.LogMode.String()),
+		"--unit-mode="+unitModeString(cfg.UnitMode),
+	)
+	for _, f := range cfg.Trace.Flags() {
+		args = append(args, e(f))
+	}
+	args = append(args,
+		"--no-new-namespace="+strconv.FormatBool(cfg.NoNewNamespace),
+		"--shutdown-policy="+e(cfg.ShutdownPolicy),
+		"--existing-units="+e(cfg.ExistingUnits),
+		"--stdio-no-reader="+e(cfg.StdioNoReader),
+		"--metrics-address="+metricsAddr,
+		"--debug-addr="+debugAddr,
+		"--exec-timeout="+cfg.ExecTimeout.String(),
+		"--exec-retention="+cfg.ExecRetention.String(),
+		"--kill-grace-period="+cfg.KillGracePeriod.String(),
+		"--event-queue-size="+strconv.Itoa(cfg.EventQueueSize),
+		"--event-queue-policy="+e(cfg.EventQueuePolicy),
+		"--event-flush-timeout="+cfg.EventFlushTimeout.String(),
+		"--config="+e(cfg.ConfigFile),
+		"--nri-config="+e(cfg.NRIConfig),
+		"--selinux-enabled="+strconv.FormatBool(cfg.SELinux),
+		"--unit-name-template="+e(cfg.UnitNameTemplate),
+		"--log-level="+e(cfg.LogLevel),
+		"--log-backend="+e(cfg.LogBackend),
+		"--log-file="+e(cfg.LogFile),
+	)
+
 	return `
 [Unit]
 Description=containerd shim service that uses systemd to manage containers
+Requires=` + name + `.socket
+After=` + name + `.socket
 
 [Service]
 Type=notify
+Restart=on-failure
 Environment=UNIT_NAME=%n
-ExecStart=` + exe + ` --address=` + cfg.Addr + ` serve` + ` --ttrpc-address=` + cfg.TTRPCAddr + ` --debug=` + strconv.FormatBool(cfg.Debug) + ` --root=` + cfg.Root + ` --log-mode=` + strings.ToLower(cfg.LogMode.String()) + ` ` + cfg.Trace.StringFlags() + ` --no-new-namespace=` + strconv.FormatBool(cfg.NoNewNamespace) + `
+ExecStart=` + execLine(args) + `
 ExecReload=kill -HUP $MAINPID
 `
 }
@@ -85,12 +180,38 @@ type installConfig struct {
 	TTRPCAddr      string
 	Debug          bool
 	LogMode        options.LogMode
+	UnitMode       options.UnitMode
 	Socket         string
 	NoNewNamespace bool
+	ShutdownPolicy string
+	MetricsAddr    string
+	DebugAddr      string
+	ExecTimeout    time.Duration
+	ExecRetention  time.Duration
+	// KillGracePeriod is the default kill grace period of containers, see killGracePeriodAnnotation.
+	KillGracePeriod time.Duration
+	// EventQueueSize, EventQueuePolicy and EventFlushTimeout configure the event queue, see eventQueue.
+	EventQueueSize    int
+	EventQueuePolicy  string
+	EventFlushTimeout time.Duration
+	ConfigFile        string
+	NRIConfig         string
+	SELinux           bool
+	UnitNameTemplate  string
+	StateRoot         string
+	ExistingUnits     string
+	StdioNoReader     string
+	// LogLevel, LogBackend and LogFile configure the shim's own log, see parseLogLevels and setupLogBackend.
+	LogLevel   string
+	LogBackend string
+	LogFile    string
+	// PerNamespace installs template units to run a shim daemon per namespace instead of one for all namespaces,
+	// see startNamespaceSocket.
+	PerNamespace bool
 }
 
 func install(ctx context.Context, cfg installConfig) error {
-	conn, err := dbus.NewSystemdConnectionContext(ctx)
+	conn, err := connectSystemd(ctx)
 	if err != nil {
 		return err
 	}
@@ -101,14 +222,27 @@ func install(ctx context.Context, cfg installConfig) error {
 		return err
 	}
 
-	os.WriteFile("/etc/systemd/system/"+serviceName+".service", []byte(serviceUnit(exe, cfg)), 0644)
+	if err := os.MkdirAll(installUnitDir(), 0755); err != nil {
+		return err
+	}
+
+	name, other, socket := serviceName, namespaceServiceName(""), escapeSpecifiers(cfg.Socket)
+	if cfg.PerNamespace {
+		name, other, socket = other, name, namespaceAddr(socket, "%i")
+	}
+	// Only the units of one mode can be installed, start looks for the template units to tell which one it is.
+	if err := removeUnits(ctx, conn, other); err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(installUnitDir(), name+".service"), []byte(serviceUnit(exe, cfg)), 0644)
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile("/etc/systemd/system/"+serviceName+".socket", []byte(socketUnit(cfg.Socket)), 0644)
+	err = os.WriteFile(filepath.Join(installUnitDir(), name+".socket"), []byte(socketUnit(socket)), 0644)
 	if err != nil {
-		os.RemoveAll("/etc/systemd/system/" + serviceName + ".service")
+		os.RemoveAll(filepath.Join(installUnitDir(), name+".service"))
 		return err
 	}
 
@@ -116,47 +250,140 @@ func install(ctx context.Context, cfg installConfig) error {
 		return err
 	}
 
-	ch := make(chan string, 1)
-	if _, err := conn.StartUnitContext(ctx, serviceName+".socket", "replace", ch); err != nil {
+	if cfg.PerNamespace {
+		// The socket of a namespace is started by start when containerd starts the first task in the namespace.
+		return nil
+	}
+
+	// Enable the socket so the shim is available after a reboot without needing to run install again.
+	if _, _, err := conn.EnableUnitFilesContext(ctx, []string{serviceName + ".socket"}, false, true); err != nil {
+		return fmt.Errorf("error enabling socket unit: %w", err)
+	}
+
+	if err := startUnit(ctx, conn, serviceName+".socket"); err != nil {
 		return fmt.Errorf("error starting socket unit: %w", err)
 	}
+	return nil
+}
+
+// validatePerNamespace checks that the metrics and debug addresses of cfg can be used by the daemons of all
+// namespaces in per-namespace mode, they get one unix socket each.
+func validatePerNamespace(cfg installConfig) error {
+	if !cfg.PerNamespace {
+		return nil
+	}
+	for flag, addr := range map[string]string{"metrics-address": cfg.MetricsAddr, "debug-addr": cfg.DebugAddr} {
+		if addr != "" && !strings.HasPrefix(addr, "/") && !strings.HasPrefix(addr, "unix://") {
+			return fmt.Errorf("--%s must be a unix socket path with --per-namespace, %q can't be shared by the namespaces: %w", flag, addr, errdefs.ErrInvalidArgument)
+		}
+	}
+	return nil
+}
+
+// startUnit starts unit and waits for the job to finish.
+func startUnit(ctx context.Context, conn *systemd.Conn, unit string) error {
+	ch := make(chan string, 1)
+	if _, err := conn.StartUnitContext(ctx, unit, "replace", ch); err != nil {
+		return err
+	}
 
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	case status := <-ch:
 		if status != "done" {
-			return fmt.Errorf("error starting socket unit: %s", status)
+			return fmt.Errorf("start job %s", status)
 		}
 	}
-
 	return nil
 }
 
-func uninstall(ctx context.Context) error {
-	conn, err := dbus.NewSystemdConnectionContext(ctx)
+// startNamespaceSocket starts the socket of the shim daemon of namespace ns when the shim is installed with
+// --per-namespace and returns its path, socket is the path the shim was installed with.
+// ok is false when the shim is installed to serve all namespaces with one daemon.
+func startNamespaceSocket(ctx context.Context, socket, ns string) (_ string, ok bool, _ error) {
+	if _, err := os.Stat(filepath.Join(installUnitDir(), namespaceServiceName("")+".socket")); err != nil {
+		return "", false, nil
+	}
+	if ns == "" {
+		return "", true, fmt.Errorf("namespace is required to start the shim daemon of a namespace")
+	}
+
+	conn, err := connectSystemd(ctx)
 	if err != nil {
-		return err
+		return "", true, err
 	}
 	defer conn.Close()
 
-	if _, err := conn.StopUnitContext(ctx, serviceName+".socket", "replace", nil); err != nil {
-		return fmt.Errorf("error stopping socket unit: %w", err)
+	// Starting a socket that is already listening is a no-op.
+	unit := namespaceServiceName(ns) + ".socket"
+	if err := startUnit(ctx, conn, unit); err != nil {
+		return "", true, fmt.Errorf("error starting socket unit %s: %w", unit, err)
+	}
+	return namespaceAddr(socket, ns), true, nil
+}
+
+// removeUnits stops, disables and removes the shim daemon units called name if they are installed.
+// If name ends with "@" they are the template units of per-namespace mode and all their instances are stopped.
+func removeUnits(ctx context.Context, conn *systemd.Conn, name string) error {
+	files := []string{name + ".socket", name + ".service"}
+	installed := false
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(installUnitDir(), f)); err == nil {
+			installed = true
+		}
+	}
+	if !installed {
+		return nil
 	}
 
-	if _, err := conn.StopUnitContext(ctx, serviceName+".service", "replace", nil); err != nil {
-		return fmt.Errorf("error stopping service unit: %w", err)
+	// Stop the sockets first so the services are not started again.
+	var sockets, services []string
+	if strings.HasSuffix(name, "@") {
+		units, err := conn.ListUnitsByPatternsContext(ctx, nil, []string{name + "*.socket", name + "*.service"})
+		if err != nil {
+			return fmt.Errorf("error listing units: %w", err)
+		}
+		for _, u := range units {
+			if strings.HasSuffix(u.Name, ".socket") {
+				sockets = append(sockets, u.Name)
+			} else {
+				services = append(services, u.Name)
+			}
+		}
+	} else {
+		sockets, services = []string{name + ".socket"}, []string{name + ".service"}
+	}
+	for _, u := range append(sockets, services...) {
+		if _, err := conn.StopUnitContext(ctx, u, "replace", nil); err != nil {
+			return fmt.Errorf("error stopping unit %s: %w", u, err)
+		}
 	}
 
-	if _, err := conn.DisableUnitFilesContext(ctx, []string{serviceName + ".service", serviceName + ".socket"}, true); err != nil {
+	if _, err := conn.DisableUnitFilesContext(ctx, files, false); err != nil {
 		return fmt.Errorf("error disabling units: %w", err)
 	}
 
-	if err := os.Remove("/etc/systemd/system/" + serviceName + ".socket"); err != nil && !os.IsNotExist(err) {
-		log.G(ctx).WithError(err).Error("failed to remove socket unit")
+	for _, f := range files {
+		if err := os.Remove(filepath.Join(installUnitDir(), f)); err != nil && !os.IsNotExist(err) {
+			log.G(ctx).WithError(err).WithField("unit", f).Error("failed to remove unit")
+		}
 	}
-	if err := os.Remove("/etc/systemd/system/" + serviceName + ".service"); err != nil && !os.IsNotExist(err) {
-		log.G(ctx).WithError(err).Error("failed to remove service unit")
+	return nil
+}
+
+func uninstall(ctx context.Context) error {
+	conn, err := connectSystemd(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := removeUnits(ctx, conn, serviceName); err != nil {
+		return err
+	}
+	if err := removeUnits(ctx, conn, namespaceServiceName("")); err != nil {
+		return err
 	}
 
 	if err := conn.ReloadContext(ctx); err != nil {
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/ttrpc"
+	"github.com/coreos/go-systemd/unit"
+)
+
+func TestNamespaceInterceptor(t *testing.T) {
+	next := func(ctx context.Context, u ttrpc.Unmarshaler, info *ttrpc.UnaryServerInfo, m ttrpc.Method) (interface{}, error) {
+		return "ok", nil
+	}
+	interceptor := namespaceInterceptor("k8s.io", next)
+	info := &ttrpc.UnaryServerInfo{FullMethod: "/containerd.task.v2.Task/Create"}
+
+	if _, err := interceptor(namespaces.WithNamespace(context.Background(), "k8s.io"), nil, info, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err := interceptor(namespaces.WithNamespace(context.Background(), "default"), nil, info, nil)
+	if !errdefs.IsFailedPrecondition(errdefs.FromGRPC(err)) {
+		t.Fatalf("expected failed precondition for another namespace, got %v", err)
+	}
+}
+
+func TestNamespaceAddr(t *testing.T) {
+	for addr, want := range map[string]string{
+		"/run/containerd/s/containerd-shim-systemd-v1.sock": "/run/containerd/s/containerd-shim-systemd-v1@k8s.io.sock",
+		"unix:///run/shim/debug":                            "unix:///run/shim/debug@k8s.io",
+	} {
+		if got := namespaceAddr(addr, "k8s.io"); got != want {
+			t.Errorf("%s: got %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestServiceUnit(t *testing.T) {
+	cfg := installConfig{Root: "/var/lib/shim 100%", Addr: "/run/containerd/containerd.sock", LogLevel: "info", PerNamespace: true}
+	opts, err := unit.Deserialize(strings.NewReader(serviceUnit("/usr/bin/containerd-shim-systemd-v1", cfg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var execStart string
+	for _, o := range opts {
+		if o.Name == "ExecStart" {
+			execStart = o.Value
+		}
+	}
+	// systemd joins the continuation lines before splitting the line.
+	args, err := splitCommandLine(strings.ReplaceAll(execStart, "\\\n", " "))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{
+		"--namespace=%i":                true,
+		"--root=/var/lib/shim 100%%/%i": true,
+		"serve":                         true,
+		"--log-level=info":              true,
+	}
+	for _, a := range args {
+		delete(want, a)
+	}
+	if len(want) > 0 {
+		t.Errorf("missing args %v in %q", want, args)
+	}
+}
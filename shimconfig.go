@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+	"github.com/pelletier/go-toml"
+)
+
+// shimConfig is the shim's own config file, passed with --config.
+// It is TOML unless the file name ends in .json.
+type shimConfig struct {
+	// Namespaces holds defaults for the containers in a containerd namespace, keyed by namespace.
+	Namespaces map[string]namespaceConfig `toml:"namespaces" json:"namespaces"`
+	// RestoreTemplates are the templates of the files rendered for restored containers, keyed by name, see
+	// restoreTemplatesAnnotation.
+	RestoreTemplates map[string]restoreTemplates `toml:"restore_templates" json:"restore_templates"`
+	// Timeouts bound how long requests and the calls to systemd and the runtime may take, see timeoutConfig.
+	Timeouts timeoutConfig `toml:"timeouts" json:"timeouts"`
+	// Retry is the policy failed operations are retried with, see retryPolicy.
+	Retry retryConfig `toml:"retry" json:"retry"`
+}
+
+// namespaceConfig holds the defaults for containers in one namespace.
+// Options set on the container (create options, annotations) take precedence.
+type namespaceConfig struct {
+	// RuncRoot is the runtime's state root, the namespace is appended to it like with the runc option.
+	RuncRoot   string `toml:"runc_root" json:"runc_root"`
+	BinaryName string `toml:"binary_name" json:"binary_name"`
+	LogMode    string `toml:"log_mode" json:"log_mode"`
+	// Debug enables runtime debug logs for the namespace's containers even if the shim is not in debug mode.
+	Debug         bool   `toml:"debug" json:"debug"`
+	Slice         string `toml:"slice" json:"slice"`
+	SystemdCgroup bool   `toml:"systemd_cgroup" json:"systemd_cgroup"`
+}
+
+// loadShimConfig reads and validates the config file at p.
+// An empty path returns an empty config.
+func loadShimConfig(p string) (*shimConfig, error) {
+	cfg := &shimConfig{}
+	if p == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("error reading shim config: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(p), ".json") {
+		err = json.Unmarshal(data, cfg)
+	} else {
+		err = toml.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing shim config %s: %v: %w", p, err, errdefs.ErrInvalidArgument)
+	}
+
+	for ns, nsCfg := range cfg.Namespaces {
+		if err := nsCfg.validate(); err != nil {
+			return nil, fmt.Errorf("namespace %q in shim config %s: %w", ns, p, err)
+		}
+	}
+	for name, t := range cfg.RestoreTemplates {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("restore templates %q in shim config %s: %w", name, p, err)
+		}
+	}
+	if _, err := cfg.Timeouts.parse(); err != nil {
+		return nil, fmt.Errorf("timeouts in shim config %s: %w", p, err)
+	}
+	if _, err := cfg.Retry.parse(); err != nil {
+		return nil, fmt.Errorf("retry in shim config %s: %w", p, err)
+	}
+	return cfg, nil
+}
+
+func (c namespaceConfig) validate() error {
+	if c.LogMode != "" {
+		if _, ok := options.LogMode_value[strings.ToUpper(c.LogMode)]; !ok {
+			return fmt.Errorf("invalid log mode %q: %w", c.LogMode, errdefs.ErrInvalidArgument)
+		}
+	}
+	if c.Slice != "" {
+		if err := validateSlice(c.Slice); err != nil {
+			return err
+		}
+	}
+	if c.RuncRoot != "" && !filepath.IsAbs(c.RuncRoot) {
+		return fmt.Errorf("runc root %q must be an absolute path: %w", c.RuncRoot, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// configDefaults tells which options of a container are taken from the shim config, or from the shim's own defaults,
+// because the container did not set them.
+type configDefaults struct {
+	LogMode bool `json:",omitempty"`
+	Slice   bool `json:",omitempty"`
+}
+
+// namespace returns the defaults for the namespace.
+func (c *shimConfig) namespace(ns string) namespaceConfig {
+	if c == nil {
+		return namespaceConfig{}
+	}
+	return c.Namespaces[ns]
+}
+
+// apply fills in the options that were not set on the container with the namespace defaults.
+func (c namespaceConfig) apply(opts *CreateOptions) {
+	if opts.Root == "" {
+		opts.Root = c.RuncRoot
+	}
+	if opts.BinaryName == "" {
+		opts.BinaryName = c.BinaryName
+	}
+	if opts.LogMode == "" && c.LogMode != "" {
+		opts.LogMode = options.LogMode(options.LogMode_value[strings.ToUpper(c.LogMode)]).String()
+	}
+	if opts.Slice == "" {
+		opts.Slice = c.Slice
+	}
+	if !opts.SystemdCgroup {
+		opts.SystemdCgroup = c.SystemdCgroup
+	}
+	if c.Debug {
+		opts.Debug = true
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+)
+
+// Shutdown policies decide what happens when containerd calls Shutdown.
+const (
+	// shutdownPolicyIgnore keeps the shim running, its lifecycle is managed by systemd.
+	shutdownPolicyIgnore = "ignore"
+	// shutdownPolicyLeave stops the shim but leaves the container units running.
+	// The next connection on the socket starts the shim again, which picks the containers back up.
+	shutdownPolicyLeave = "leave-running"
+	// shutdownPolicyStop stops all container units before the shim exits.
+	shutdownPolicyStop = "stop"
+
+	// shutdownTimeout is how long we wait for requests to finish and, with the stop policy, containers to stop.
+	shutdownTimeout = 30 * time.Second
+)
+
+func validateShutdownPolicy(s string) error {
+	switch s {
+	case shutdownPolicyIgnore, shutdownPolicyLeave, shutdownPolicyStop:
+		return nil
+	default:
+		return fmt.Errorf("invalid shutdown policy %q: %w", s, errdefs.ErrInvalidArgument)
+	}
+}
+
+// ShutdownRequested returns true if the shim is exiting because containerd asked it to.
+func (s *Service) ShutdownRequested() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdownRequested
+}
+
+// drain is called once the shim API is no longer being served.
+// It persists the state of all containers so they can be recovered and, depending on the shutdown policy, stops them.
+func (s *Service) drain(ctx context.Context) {
+	stop := s.ShutdownRequested() && s.shutdownPolicy == shutdownPolicyStop
+
+	s.processes.Each(func(p Process) {
+		pInit := p.(*initProcess)
+		ctx := log.WithLogger(ctx, log.G(ctx).WithField("id", pInit.id).WithField("ns", pInit.ns))
+
+		if stop && !p.ProcessState().Exited() {
+			log.G(ctx).Info("Stopping container")
+			ch := make(chan string, 1)
+			if _, err := pInit.systemd.StopUnitContext(ctx, pInit.Name(), "replace", ch); err != nil {
+				log.G(ctx).WithError(err).Warn("Error stopping container unit")
+			} else {
+				select {
+				case <-ctx.Done():
+				case <-ch:
+				}
+			}
+			// Pick up the exit so the event goes out before the event queue is flushed.
+			if err := p.LoadState(ctx); err != nil {
+				log.G(ctx).WithError(err).Debug("Error loading container state")
+			}
+		}
+
+		if err := s.saveTask(pInit); err != nil {
+			log.G(ctx).WithError(err).Warn("Error saving task state")
+		}
+	})
+}
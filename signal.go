@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/containerd/errdefs"
+	"golang.org/x/sys/unix"
+)
+
+// Signal numbers differ between architectures, e.g. SIGUSR1 is 10 on x86 and arm but 16 on mips, and mips has twice
+// as many real-time signals. The unix package has the numbers of the architecture the shim is built for, which is the
+// one of containerd and the container, so names are always looked up there instead of assuming the x86 numbers.
+//
+// Real-time signals have no names of their own, they are named relative to SIGRTMIN and SIGRTMAX like systemd does
+// ("SIGRTMIN+3"). sigRTMin is the first one the C library leaves to applications, the ones before it are used by glibc
+// and musl for threads. sigRTMax is in signal_*.go.
+const sigRTMin = 34
+
+// parseSignal parses a signal by name ("SIGINT", "INT", "RTMIN+3") or number.
+func parseSignal(s string) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if !validSignal(n) {
+			return 0, fmt.Errorf("invalid signal %q: %w", s, errdefs.ErrInvalidArgument)
+		}
+		return n, nil
+	}
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig := unix.SignalNum(name); sig != 0 {
+		return int(sig), nil
+	}
+	if n, ok := parseRTSignal(strings.TrimPrefix(name, "SIG")); ok {
+		return n, nil
+	}
+	return 0, fmt.Errorf("invalid signal %q: %w", s, errdefs.ErrInvalidArgument)
+}
+
+// parseRTSignal parses "RTMIN", "RTMIN+n", "RTMAX" and "RTMAX-n".
+func parseRTSignal(s string) (int, bool) {
+	var base, sign int
+	switch {
+	case strings.HasPrefix(s, "RTMIN"):
+		base, sign, s = sigRTMin, 1, strings.TrimPrefix(s, "RTMIN")
+	case strings.HasPrefix(s, "RTMAX"):
+		base, sign, s = sigRTMax, -1, strings.TrimPrefix(s, "RTMAX")
+	default:
+		return 0, false
+	}
+	if s == "" {
+		return base, true
+	}
+	if (sign > 0 && s[0] != '+') || (sign < 0 && s[0] != '-') {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[1:])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	sig := base + sign*n
+	if sig < sigRTMin || sig > sigRTMax {
+		return 0, false
+	}
+	return sig, true
+}
+
+// validSignal reports if sig is a signal number of this architecture.
+func validSignal(sig int) bool {
+	return sig > 0 && sig <= sigRTMax
+}
+
+// signalName returns the name of the signal, e.g. "SIGTERM" or "SIGRTMIN+3".
+func signalName(sig int) string {
+	if name := unix.SignalName(syscall.Signal(sig)); name != "" {
+		return name
+	}
+	if sig == sigRTMin {
+		return "SIGRTMIN"
+	}
+	if sig > sigRTMin && sig <= sigRTMax {
+		return "SIGRTMIN+" + strconv.Itoa(sig-sigRTMin)
+	}
+	return strconv.Itoa(sig)
+}
+
+// waitExitCode returns the exit code of a process the way a shell would, processes killed by a signal get 128 + the
+// signal number. This is what execMainExitCode does with the status systemd reports.
+func waitExitCode(ws unix.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
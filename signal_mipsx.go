@@ -0,0 +1,6 @@
+//go:build mips || mipsle || mips64 || mips64le
+
+package main
+
+// sigRTMax is the last real-time signal, mips has 127 signals.
+const sigRTMax = 127
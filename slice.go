@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// sliceAnnotation can be set on a container to choose the slice its units are placed in.
+// It takes precedence over the slice in the create options.
+const sliceAnnotation = shimName + ".slice"
+
+// validateSlice makes sure the slice is a valid slice unit name.
+func validateSlice(name string) error {
+	if !strings.HasSuffix(name, ".slice") || len(name) == len(".slice") {
+		return fmt.Errorf("invalid slice %q, must be a slice unit name: %w", name, errdefs.ErrInvalidArgument)
+	}
+	for _, c := range strings.TrimSuffix(name, ".slice") {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '\\':
+		default:
+			return fmt.Errorf("invalid character %q in slice %q: %w", c, name, errdefs.ErrInvalidArgument)
+		}
+	}
+	return nil
+}
+
+// defaultPodParentSlice is the parent of pod slices when no slice is configured for the container.
+const defaultPodParentSlice = "kubepods.slice"
+
+// podSlice returns the slice all units of the container's pod are placed in, empty for containers that are not in
+// a pod.
+//
+// With kubelet's systemd cgroup driver the cgroups path is "<pod slice>:<prefix>:<name>" and the pod slice is used
+// as is, otherwise it is a child of the configured slice named after the sandbox. Either way the pod's resource usage
+// is accounted on the slice by systemd and the pod can be frozen or stopped as a whole through it.
+func podSlice(spec *specs.Spec, opts CreateOptions) string {
+	if opts.CRI.Type == "" || opts.CRI.SandboxID == "" {
+		return ""
+	}
+	if spec.Linux != nil {
+		if parts := strings.Split(spec.Linux.CgroupsPath, ":"); len(parts) == 3 && validateSlice(parts[0]) == nil {
+			return parts[0]
+		}
+	}
+	parent := opts.Slice
+	if parent == "" {
+		parent = defaultPodParentSlice
+	}
+	return strings.TrimSuffix(parent, ".slice") + "-pod" + opts.CRI.SandboxID + ".slice"
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// bundleSpec is the part of the container spec (config.json) the shim uses.
+// Specs can be large (mostly the seccomp profile, env and mounts), decoding only these fields skips allocating
+// everything else.
+type bundleSpec struct {
+	Process *struct {
+		Terminal        bool                     `json:"terminal,omitempty"`
+		ApparmorProfile string                   `json:"apparmorProfile,omitempty"`
+		Capabilities    *specs.LinuxCapabilities `json:"capabilities,omitempty"`
+		NoNewPrivileges bool                     `json:"noNewPrivileges,omitempty"`
+	} `json:"process,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Linux       *struct {
+		UIDMappings       []specs.LinuxIDMapping `json:"uidMappings,omitempty"`
+		GIDMappings       []specs.LinuxIDMapping `json:"gidMappings,omitempty"`
+		Resources         *specs.LinuxResources  `json:"resources,omitempty"`
+		Namespaces        []specs.LinuxNamespace `json:"namespaces,omitempty"`
+		Devices           []specs.LinuxDevice    `json:"devices,omitempty"`
+		RootfsPropagation string                 `json:"rootfsPropagation,omitempty"`
+		CgroupsPath       string                 `json:"cgroupsPath,omitempty"`
+	} `json:"linux,omitempty"`
+}
+
+// readBundleSpec reads the container spec from the bundle.
+// Only the fields in bundleSpec are set on the returned spec.
+func readBundleSpec(bundle string) (*specs.Spec, error) {
+	f, err := os.Open(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading spec: %w", err)
+	}
+	defer f.Close()
+
+	var s bundleSpec
+	if err := json.NewDecoder(f).Decode(&s); err != nil {
+		return nil, fmt.Errorf("error unmarshalling spec: %w", err)
+	}
+
+	spec := &specs.Spec{Annotations: s.Annotations}
+	if s.Process != nil {
+		spec.Process = &specs.Process{
+			Terminal:        s.Process.Terminal,
+			ApparmorProfile: s.Process.ApparmorProfile,
+			Capabilities:    s.Process.Capabilities,
+			NoNewPrivileges: s.Process.NoNewPrivileges,
+		}
+	}
+	if s.Linux != nil {
+		spec.Linux = &specs.Linux{
+			UIDMappings:       s.Linux.UIDMappings,
+			GIDMappings:       s.Linux.GIDMappings,
+			Resources:         s.Linux.Resources,
+			Namespaces:        s.Linux.Namespaces,
+			Devices:           s.Linux.Devices,
+			RootfsPropagation: s.Linux.RootfsPropagation,
+			CgroupsPath:       s.Linux.CgroupsPath,
+		}
+	}
+	return spec, nil
+}
+
+// bundleSpec returns the container spec, it is read from the bundle the first time it is needed.
+// The fields the shim uses don't change after the container is created.
+func (p *initProcess) bundleSpec() (*specs.Spec, error) {
+	p.specMu.Lock()
+	defer p.specMu.Unlock()
+
+	if p.spec == nil {
+		spec, err := readBundleSpec(p.Bundle)
+		if err != nil {
+			return nil, err
+		}
+		p.spec = spec
+	}
+	return p.spec, nil
+}
+
+// annotations returns the annotations from the container spec, nil if it can't be read.
+func (p *initProcess) annotations() map[string]string {
+	spec, err := p.bundleSpec()
+	if err != nil {
+		return nil
+	}
+	return spec.Annotations
+}
+
+// readFullBundleSpec reads the whole container spec from the bundle, for when it has to be written back.
+func readFullBundleSpec(bundle string) (*specs.Spec, error) {
+	data, err := os.ReadFile(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading spec: %w", err)
+	}
+	var s specs.Spec
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("error unmarshalling spec: %w", err)
+	}
+	return &s, nil
+}
+
+// writeBundleSpec replaces the container spec in the bundle.
+func writeBundleSpec(bundle string, s *specs.Spec) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeBundleSpecData(bundle, data)
+}
+
+// writeBundleSpecData replaces the container spec in the bundle with data.
+// The file is replaced atomically so the runtime never reads a partially written spec.
+func writeBundleSpecData(bundle string, data []byte) (retErr error) {
+	p := filepath.Join(bundle, "config.json")
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(p); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	f, err := os.CreateTemp(bundle, ".config.json.*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		return fmt.Errorf("error writing spec: %w", err)
+	}
+	return nil
+}
+
+// specEdit changes the container spec as a JSON object.
+type specEdit func(spec map[string]interface{}) error
+
+// editBundleSpec applies the edits to the container spec in the bundle and returns the spec as it was before.
+// The spec is edited as a plain JSON object so fields the specs package doesn't know about are kept.
+func editBundleSpec(bundle string, edits ...specEdit) ([]byte, error) {
+	orig, err := os.ReadFile(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading spec: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(orig))
+	// Keep numbers as they are, e.g. uid maps and memory limits don't survive a round trip through float64.
+	dec.UseNumber()
+	var spec map[string]interface{}
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("error unmarshalling spec: %w", err)
+	}
+	for _, edit := range edits {
+		if err := edit(spec); err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBundleSpecData(bundle, data); err != nil {
+		return nil, err
+	}
+	return orig, nil
+}
+
+// specObject returns the object under the key of obj, it is added if it doesn't exist.
+func specObject(obj map[string]interface{}, key string) (map[string]interface{}, error) {
+	switch v := obj[key].(type) {
+	case map[string]interface{}:
+		return v, nil
+	case nil:
+		o := make(map[string]interface{})
+		obj[key] = o
+		return o, nil
+	default:
+		return nil, fmt.Errorf("invalid spec: %s is not an object", key)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path"
@@ -19,6 +21,9 @@ import (
 	"github.com/containerd/containerd/namespaces"
 	taskapi "github.com/containerd/containerd/runtime/v2/task"
 	"github.com/coreos/go-systemd/unit"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+	dbus "github.com/godbus/dbus/v5"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
@@ -75,35 +80,411 @@ func (s *Service) Start(ctx context.Context, r *taskapi.StartRequest) (_ *taskap
 			ContainerID: r.ID,
 			Pid:         pid,
 		})
+		// Resolve the container cgroup while we know the container is running so oom kills can be tracked.
+		p.(*initProcess).checkOOM(ctx)
+	}
+
+	if err := s.saveTask(p.(*initProcess)); err != nil {
+		log.G(ctx).WithError(err).Error("Error saving task state")
 	}
 
 	return &taskapi.StartResponse{Pid: pid}, nil
 }
 
 func (p *process) runcCmd(cmd []string) ([]string, error) {
-	root := []string{p.runc.Command, "--debug=" + strconv.FormatBool(p.runc.Debug), "--systemd-cgroup=" + strconv.FormatBool(p.opts.SystemdCgroup), "--root", p.runc.Root}
-	if p.runc.Debug {
+	root := []string{p.runc.Command}
+	root = append(root, p.runtime.boolFlag("debug", p.runc.Debug)...)
+	root = append(root, p.runtime.boolFlag("systemd-cgroup", p.opts.SystemdCgroup)...)
+	root = append(root, "--root", p.runc.Root)
+	if p.runc.Log != "" {
 		root = append(root, "--log="+p.runc.Log)
+		if p.runc.LogFormat != "" {
+			root = append(root, "--log-format="+string(p.runc.LogFormat))
+		}
+	}
+	if p.runc.Criu != "" {
+		root = append(root, "--criu", p.runc.Criu)
+	}
+	if p.runc.Rootless != nil {
+		// Both runc and crun take a value for --rootless.
+		root = append(root, "--rootless="+strconv.FormatBool(*p.runc.Rootless))
 	}
 
 	return append(root, cmd...), nil
 }
 
+// writeUnit writes the unit file for the named unit.
+// Units are written while other containers reload systemd, the file is replaced atomically so a reload never sees a
+// partially written unit. systemd ignores hidden files, so the temporary file is not picked up either.
 func writeUnit(name string, opts []*unit.UnitOption) error {
-	rdr := unit.Serialize(opts)
+	return writeUnitFile(runtimeUnitDir(), name, opts)
+}
 
-	f, err := os.Create(filepath.Join("/run/systemd/system", name))
+// writeUnitFile atomically writes a file in the unit file format to dir, see writeUnit.
+func writeUnitFile(dir, name string, opts []*unit.UnitOption) (retErr error) {
+	f, err := os.CreateTemp(dir, "."+name+".*")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer func() {
+		f.Close()
+		if retErr != nil {
+			os.Remove(f.Name())
+		}
+	}()
 
-	if _, err := io.Copy(f, rdr); err != nil {
+	if _, err := io.Copy(f, unit.Serialize(opts)); err != nil {
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := labelLike(f.Name(), dir); err != nil {
 		return err
 	}
+	return os.Rename(f.Name(), filepath.Join(dir, name))
+}
+
+func (p *process) transient() bool {
+	return p.opts.UnitMode == options.UnitMode_UNIT_MODE_TRANSIENT
+}
+
+// unitFilePath returns the path where systemd keeps the unit file for the named unit.
+func (p *process) unitFilePath(name string) string {
+	if p.transient() {
+		return filepath.Join(transientUnitDir(), name)
+	}
+	return filepath.Join(runtimeUnitDir(), name)
+}
+
+// installUnit makes the unit available to systemd so it can be started.
+// In transient mode nothing is written to disk, the properties are kept around and passed to systemd when the unit is started.
+func (p *process) installUnit(ctx context.Context, name string, opts []*unit.UnitOption) error {
+	if p.transient() {
+		props, err := unitProperties(name, opts)
+		if err != nil {
+			return err
+		}
+		p.unitProps = props
+		return nil
+	}
+
+	if err := writeUnit(name, opts); err != nil {
+		return err
+	}
+	if err := p.reload(ctx); err != nil {
+		log.G(ctx).WithError(err).Warn("Error reloading systemd")
+	}
 	return nil
 }
 
+// startUnitJob queues a start job for the unit.
+// The job result is sent on ch, which must be buffered.
+func (p *process) startUnitJob(ctx context.Context, name string, ch chan<- string) (int, error) {
+	var (
+		jobID  int
+		err    error
+		method = "StartUnit"
+		start  = time.Now()
+		result = make(chan string, 1)
+	)
+	if p.transient() {
+		method = "StartTransientUnit"
+		jobID, err = p.systemd.StartTransientUnitContext(ctx, name, "replace", p.unitProps, result)
+	} else {
+		jobID, err = p.systemd.StartUnitContext(ctx, name, "replace", result)
+	}
+	if err != nil {
+		metrics.dbusErrors.Inc(method)
+		return jobID, err
+	}
+
+	go func() {
+		status := <-result
+		metrics.unitStart.Observe(time.Since(start), status)
+		ch <- status
+	}()
+	return jobID, nil
+}
+
+// removeUnit removes the unit file written by installUnit.
+// Transient units are garbage collected by systemd once they are stopped and any failed state is reset.
+func (p *process) removeUnit(ctx context.Context, name string) error {
+	removeOverrides(ctx, name)
+	if p.transient() {
+		return nil
+	}
+	if err := os.Remove(p.unitFilePath(name)); err != nil {
+		if os.IsNotExist(err) {
+			// e.g. the runtime unit directory was cleared by a reboot
+			return nil
+		}
+		return err
+	}
+	if err := p.reload(ctx); err != nil {
+		log.G(ctx).WithError(err).Error("systemd reload failed")
+	}
+	return nil
+}
+
+type execCommand struct {
+	Path          string
+	Args          []string
+	IgnoreFailure bool
+}
+
+// unitProperties converts unit file options into properties that can be passed to StartTransientUnit.
+// Specifiers are not expanded for transient units, so "%n" is replaced with the unit name here.
+func unitProperties(name string, opts []*unit.UnitOption) ([]systemd.Property, error) {
+	var (
+		props   []systemd.Property
+		env     []string
+		docs    []string
+		deps    = make(map[string][]string)
+		devices []deviceAllow
+		fields  [][]byte
+		execs   = make(map[string][]execCommand)
+		execIdx []string
+	)
+
+	for _, o := range opts {
+		v := strings.ReplaceAll(o.Value, "%n", name)
+		switch o.Name {
+		case "ExecStart", "ExecStartPre", "ExecStartPost", "ExecStop", "ExecStopPost", "ExecReload":
+			var cmd execCommand
+			if strings.HasPrefix(v, "-") {
+				cmd.IgnoreFailure = true
+				v = v[1:]
+			}
+			args, err := splitCommandLine(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", o.Name, err)
+			}
+			cmd.Args = args
+			if len(cmd.Args) == 0 {
+				return nil, fmt.Errorf("empty command for %s: %w", o.Name, errdefs.ErrInvalidArgument)
+			}
+			cmd.Path = cmd.Args[0]
+			if _, ok := execs[o.Name]; !ok {
+				execIdx = append(execIdx, o.Name)
+			}
+			execs[o.Name] = append(execs[o.Name], cmd)
+		case "Environment":
+			env = append(env, v)
+		case "Documentation":
+			docs = append(docs, strings.Fields(v)...)
+		case "After", "Before", "Wants", "Requires", "Requisite", "BindsTo", "PartOf", "Conflicts":
+			deps[o.Name] = append(deps[o.Name], strings.Fields(v)...)
+		case "DeviceAllow", "LogExtraFields":
+			// Lists that systemd takes in one property.
+			prop, err := transientProperty(o.Name, v)
+			if err != nil {
+				return nil, err
+			}
+			switch vv := prop.Value.Value().(type) {
+			case []deviceAllow:
+				devices = append(devices, vv...)
+			case [][]byte:
+				fields = append(fields, vv...)
+			}
+		default:
+			if strings.HasPrefix(o.Name, "X-") {
+				// Extension fields can only be in unit files.
+				continue
+			}
+			prop, err := transientProperty(o.Name, v)
+			if err != nil {
+				return nil, err
+			}
+			props = append(props, prop)
+		}
+	}
+
+	if len(env) > 0 {
+		props = append(props, systemd.Property{Name: "Environment", Value: dbus.MakeVariant(env)})
+	}
+	if len(docs) > 0 {
+		props = append(props, systemd.Property{Name: "Documentation", Value: dbus.MakeVariant(docs)})
+	}
+	if len(fields) > 0 {
+		props = append(props, systemd.Property{Name: "LogExtraFields", Value: dbus.MakeVariant(fields)})
+	}
+	if len(devices) > 0 {
+		props = append(props, systemd.Property{Name: "DeviceAllow", Value: dbus.MakeVariant(devices)})
+	}
+	for _, k := range []string{"After", "Before", "Wants", "Requires", "Requisite", "BindsTo", "PartOf", "Conflicts"} {
+		if len(deps[k]) > 0 {
+			props = append(props, systemd.Property{Name: k, Value: dbus.MakeVariant(deps[k])})
+		}
+	}
+	for _, k := range execIdx {
+		props = append(props, systemd.Property{Name: k, Value: dbus.MakeVariant(execs[k])})
+	}
+	return props, nil
+}
+
+func parseUnitBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes", "true", "on", "1":
+		return true, nil
+	case "no", "false", "off", "0":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid boolean value %q: %w", s, errdefs.ErrInvalidArgument)
+	}
+}
+
+// timespanUnits are the units systemd accepts in time spans, in microseconds.
+var timespanUnits = map[string]float64{
+	"usec": 1, "us": 1, "µs": 1, "μs": 1,
+	"msec": 1e3, "ms": 1e3,
+	"seconds": 1e6, "second": 1e6, "sec": 1e6, "s": 1e6,
+	"minutes": 60e6, "minute": 60e6, "min": 60e6, "m": 60e6,
+	"hours": 3600e6, "hour": 3600e6, "hr": 3600e6, "h": 3600e6,
+	"days": 86400e6, "day": 86400e6, "d": 86400e6,
+	"weeks": 604800e6, "week": 604800e6, "w": 604800e6,
+	"months": 2629800e6, "month": 2629800e6, "M": 2629800e6,
+	"years": 31557600e6, "year": 31557600e6, "y": 31557600e6,
+}
+
+// parseUnitDuration parses a time span from a unit file into microseconds.
+// Like systemd this is a sum of numbers with units, e.g. "5min", "1h 30s" or "2.5s". Numbers without a unit are
+// seconds.
+func parseUnitDuration(s string) (uint64, error) {
+	if s == "infinity" {
+		return math.MaxUint64, nil
+	}
+	invalid := fmt.Errorf("invalid time span %q: %w", s, errdefs.ErrInvalidArgument)
+
+	var usec float64
+	rest := strings.TrimSpace(s)
+	if rest == "" {
+		return 0, invalid
+	}
+	for rest != "" {
+		i := strings.IndexFunc(rest, func(c rune) bool { return (c < '0' || c > '9') && c != '.' })
+		if i < 0 {
+			i = len(rest)
+		}
+		n, err := strconv.ParseFloat(rest[:i], 64)
+		if err != nil {
+			return 0, invalid
+		}
+		rest = strings.TrimLeft(rest[i:], " \t")
+		i = strings.IndexFunc(rest, func(c rune) bool { return c >= '0' && c <= '9' || c == '.' || c == ' ' || c == '\t' })
+		if i < 0 {
+			i = len(rest)
+		}
+		mult := timespanUnits["s"]
+		if unit := rest[:i]; unit != "" {
+			var ok bool
+			if mult, ok = timespanUnits[unit]; !ok {
+				return 0, invalid
+			}
+		}
+		usec += n * mult
+		rest = strings.TrimLeft(rest[i:], " \t")
+	}
+	if usec >= math.MaxUint64 {
+		return 0, invalid
+	}
+	return uint64(usec), nil
+}
+
+// splitCommandLine splits a command line from a unit file into its words like systemd does: words are separated by
+// whitespace, quotes group words and backslash escapes are unescaped, e.g. `sh -c "echo \"a b\""` is
+// ["sh", "-c", `echo "a b"`].
+func splitCommandLine(s string) ([]string, error) {
+	var (
+		words  []string
+		word   strings.Builder
+		inWord bool
+		quote  byte
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\':
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("trailing backslash in %q: %w", s, errdefs.ErrInvalidArgument)
+			}
+			switch e := s[i+1]; e {
+			case '"', '\'', '\\', ' ':
+				word.WriteByte(e)
+				i++
+			case 's':
+				word.WriteByte(' ')
+				i++
+			default:
+				v, multibyte, tail, err := strconv.UnquoteChar(s[i:], 0)
+				if err != nil {
+					return nil, fmt.Errorf("invalid escape in %q: %w", s, errdefs.ErrInvalidArgument)
+				}
+				if multibyte {
+					word.WriteRune(v)
+				} else {
+					word.WriteByte(byte(v))
+				}
+				i = len(s) - len(tail) - 1
+			}
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q: %w", s, errdefs.ErrInvalidArgument)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// quoteExecArg quotes an argument for an Exec line of a unit file, the reverse of splitCommandLine: arguments with
+// whitespace, quotes or backslashes are put in double quotes with those escaped, and "$" is doubled so systemd doesn't
+// substitute environment variables in it. Specifiers are left alone, see escapeSpecifiers.
+func quoteExecArg(s string) string {
+	s = strings.ReplaceAll(s, "$", "$$")
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'\\;") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(s) + `"`
+}
+
+// escapeSpecifiers escapes "%" in s so systemd doesn't expand it as a specifier in a unit file.
+func escapeSpecifiers(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// execLine joins args, which have their specifiers escaped, into an Exec line with one argument per continuation line.
+func execLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = quoteExecArg(a)
+	}
+	return strings.Join(quoted, " \\\n\t")
+}
+
 func (p *initProcess) startOptions(rcmd []string) ([]*unit.UnitOption, error) {
 	const svc = "Service"
 
@@ -113,11 +494,12 @@ func (p *initProcess) startOptions(rcmd []string) ([]*unit.UnitOption, error) {
 	}
 
 	opts := []*unit.UnitOption{
+		p.descriptionOption(p.containerDescription()),
 		unit.NewUnitOption(svc, "Type", p.unitType()),
 		unit.NewUnitOption(svc, "RemainAfterExit", "no"),
 		unit.NewUnitOption(svc, "PIDFile", p.pidFile()),
 		unit.NewUnitOption(svc, "Delegate", "yes"),
-		unit.NewUnitOption(svc, "ExecStopPost", "-"+p.exe+" --bundle="+p.Bundle+" exit "+os.Getenv("UNIT_NAME")),
+		unit.NewUnitOption(svc, "ExecStopPost", "-"+p.privileged(p.exe+" --bundle="+p.Bundle+" exit "+os.Getenv("UNIT_NAME"))),
 		// Set this as env vars here because we only want these fifos to be used for the container stdio, not the other commands we run.
 		// Otherwise we can run into interesting cases like the client has closeed the fifo and our Pre/Post commands hang
 		// We already had to open these fifos in process to prevent such hangs with `ExecStart`, now instead it'll open them just before
@@ -125,19 +507,59 @@ func (p *initProcess) startOptions(rcmd []string) ([]*unit.UnitOption, error) {
 		unit.NewUnitOption(svc, "Environment", "STDIN_FIFO="+p.Stdin),
 		unit.NewUnitOption(svc, "Environment", "STDOUT_FIFO="+p.Stdout),
 		unit.NewUnitOption(svc, "Environment", "STDERR_FIFO="+p.Stderr),
+		unit.NewUnitOption(svc, "Environment", stdioNoReaderEnv+"="+p.opts.StdioNoReader),
 		unit.NewUnitOption(svc, "Environment", "DAEMON_UNIT_NAME="+os.Getenv("UNIT_NAME")),
 		unit.NewUnitOption(svc, "Environment", "UNIT_NAME=%n"), // %n is replaced with the unit name by systemd
 		unit.NewUnitOption(svc, "Environment", "EXIT_STATE_PATH="+p.exitStatePath()),
+		unit.NewUnitOption(svc, "Environment", exitStatusFileEnv+"="+p.exitStatusPath()),
+		// Passed on to logging binaries
+		unit.NewUnitOption(svc, "Environment", "CONTAINER_ID="+p.id),
+		unit.NewUnitOption(svc, "Environment", "CONTAINER_NAMESPACE="+p.ns),
 	}
 	if p.shimCgroup != "" {
 		opts = append(opts, unit.NewUnitOption(svc, "Environment", "SHIM_CGROUP="+p.shimCgroup))
 	}
 
-	prefix := []string{p.exe, "--debug=" + strconv.FormatBool(p.runc.Debug), "--bundle=" + p.Bundle, "create"}
+	if p.opts.Slice != "" {
+		opts = append(opts, unit.NewUnitOption(svc, "Slice", p.opts.Slice))
+	}
+	if p.opts.SdNotifyEnable {
+		// The container sends its notifications through a proxy running in the unit, so it is not the main process.
+		// The unit only becomes active once the container is ready, which may be long after it was created.
+		opts = append(opts,
+			unit.NewUnitOption(svc, "NotifyAccess", "all"),
+			unit.NewUnitOption(svc, "TimeoutStartSec", "infinity"),
+			unit.NewUnitOption(svc, "Environment", notifyProxyEnv+"="+p.notifySocketPath()),
+		)
+		if p.opts.Watchdog > 0 {
+			opts = append(opts, unit.NewUnitOption(svc, "WatchdogSec", watchdogUSec(p.opts.Watchdog)+"us"))
+		}
+	}
+	opts = append(opts, p.metadataOptions(p.Bundle, p.id, "")...)
+	opts = append(opts, unit.NewUnitOption("Unit", unitNameField, p.containerName()))
+	opts = append(opts, p.logOptions(p.journalFields())...)
+	opts = append(opts, p.stdioOptions()...)
+	opts = append(opts, p.stopOptions()...)
+	opts = append(opts, p.oomOptions()...)
+	opts = append(opts, p.mountNamespaceOptions()...)
+	opts = append(opts, p.accountingOptions()...)
+	opts = append(opts, p.userOptions()...)
+	opts = append(opts, p.criOptions()...)
+	opts = append(opts, p.namespaceOptions()...)
+	opts = append(opts, p.hookOptions()...)
+	restartOpts, err := p.restartOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, restartOpts...)
+	opts = append(opts, p.resources...)
+	opts = append(opts, propertyOptions(p.opts.Properties)...)
+
+	prefix := []string{p.exe, "--debug=" + strconv.FormatBool(p.runc.Debug), "--bundle=" + p.Bundle, "create", "--log-mode=" + strings.ToLower(p.opts.LogMode)}
 	if len(p.Rootfs) > 0 {
 		if p.noNewNamespace {
-			opts = append(opts, unit.NewUnitOption(svc, "ExecStartPre", p.exe+" mount "+p.mountConfigPath()))
-			opts = append(opts, unit.NewUnitOption(svc, "ExecStopPost", "-"+p.exe+" unmount "+filepath.Join(p.Bundle, "rootfs")))
+			opts = append(opts, unit.NewUnitOption(svc, "ExecStartPre", p.privileged(p.exe+" mount "+p.mountConfigPath())))
+			opts = append(opts, unit.NewUnitOption(svc, "ExecStopPost", "-"+p.privileged(p.exe+" unmount "+filepath.Join(p.Bundle, "rootfs"))))
 		} else {
 			// Unfortunately with PrivateMounts we can't use `ExecStartPre` to mount the rootfs b/c it does not share a mount namespace
 			// with the main process. Instead we re-exec with `create` subcommand which will mount and exec the main process.
@@ -147,9 +569,12 @@ func (p *initProcess) startOptions(rcmd []string) ([]*unit.UnitOption, error) {
 	}
 
 	if p.Terminal || p.opts.Terminal {
-		opts = append(opts, unit.NewUnitOption("Service", "ExecStopPost", "-"+sysctl+" stop "+p.ttyUnitName()))
+		opts = append(opts, unit.NewUnitOption("Service", "ExecStopPost", "-"+p.privileged(sysctl+" stop "+p.ttyUnitName())))
 		prefix = append(prefix, "--tty")
 	}
+	if p.lazyPages() {
+		opts = append(opts, unit.NewUnitOption(svc, "ExecStopPost", "-"+p.privileged(sysctl+" stop "+p.lazyPagesUnitName())))
+	}
 
 	execStart, err := p.runcCmd(append(rcmd, p.id))
 	if err != nil {
@@ -169,12 +594,13 @@ func (p *execProcess) startOptions() ([]*unit.UnitOption, error) {
 	}
 
 	opts := []*unit.UnitOption{
+		p.descriptionOption("containerd exec " + p.execID + " in " + p.ns + "/" + p.parent.id),
 		unit.NewUnitOption(svc, "Type", "notify"),
 		unit.NewUnitOption(svc, "PIDFile", p.pidFile()),
 		unit.NewUnitOption(svc, "GuessMainPID", "yes"),
 		unit.NewUnitOption(svc, "Delegate", "yes"),
 		unit.NewUnitOption(svc, "RemainAfterExit", "no"),
-		unit.NewUnitOption(svc, "ExecStopPost", "-"+p.exe+" --debug="+strconv.FormatBool(p.runc.Debug)+" --id="+p.id+" --bundle="+p.parent.Bundle+" exit"),
+		unit.NewUnitOption(svc, "ExecStopPost", "-"+p.privileged(p.exe+" --debug="+strconv.FormatBool(p.runc.Debug)+" --id="+p.id+" --bundle="+p.parent.Bundle+" exit")),
 
 		// Set this as env vars here because we only want these fifos to be used for the container stdio, not the other commands we run.
 		// Otherwise we can run into interesting cases like the client has closeed the fifo and our Pre/Post commands hang
@@ -183,15 +609,32 @@ func (p *execProcess) startOptions() ([]*unit.UnitOption, error) {
 		unit.NewUnitOption(svc, "Environment", "STDIN_FIFO="+p.Stdin),
 		unit.NewUnitOption(svc, "Environment", "STDOUT_FIFO="+p.Stdout),
 		unit.NewUnitOption(svc, "Environment", "STDERR_FIFO="+p.Stderr),
+		unit.NewUnitOption(svc, "Environment", stdioNoReaderEnv+"="+p.opts.StdioNoReader),
 		unit.NewUnitOption(svc, "Environment", "DAEMON_UNIT_NAME="+os.Getenv("UNIT_NAME")),
 		unit.NewUnitOption(svc, "Environment", "UNIT_NAME=%n"), // %n is replaced with the unit name by systemd
 		unit.NewUnitOption(svc, "Environment", "EXIT_STATE_PATH="+p.exitStatePath()),
+		unit.NewUnitOption(svc, "Environment", exitStatusFileEnv+"="+p.exitStatusPath()),
+		// Passed on to logging binaries
+		unit.NewUnitOption(svc, "Environment", "CONTAINER_ID="+p.parent.id),
+		unit.NewUnitOption(svc, "Environment", "CONTAINER_NAMESPACE="+p.ns),
+		unit.NewUnitOption(svc, "Environment", containerExecIDEnv+"="+p.execID),
 	}
 	if p.shimCgroup != "" {
 		opts = append(opts, unit.NewUnitOption(svc, "Environment", "SHIM_CGROUP="+p.shimCgroup))
 	}
 
-	prefix := []string{p.exe, "--debug=" + strconv.FormatBool(p.runc.Debug), "--bundle=" + p.parent.Bundle, "create"}
+	if p.opts.Slice != "" {
+		opts = append(opts, unit.NewUnitOption(svc, "Slice", p.opts.Slice))
+	}
+	opts = append(opts, p.metadataOptions(p.parent.Bundle, p.parent.id, p.execID)...)
+	opts = append(opts, p.logOptions(p.journalFields())...)
+	opts = append(opts, p.stdioOptions()...)
+	opts = append(opts, p.userOptions()...)
+	opts = append(opts, p.timeoutOptions()...)
+	// Only set with the exec cgroup, see execAnnotations.
+	opts = append(opts, propertyOptions(p.opts.Properties)...)
+
+	prefix := []string{p.exe, "--debug=" + strconv.FormatBool(p.runc.Debug), "--bundle=" + p.parent.Bundle, "create", "--log-mode=" + strings.ToLower(p.opts.LogMode)}
 
 	cmd := []string{"exec", "--process=" + p.processFilePath(), "--pid-file=" + p.pidFile(), "--detach"}
 	if p.Terminal || p.opts.Terminal {
@@ -202,7 +645,7 @@ func (p *execProcess) startOptions() ([]*unit.UnitOption, error) {
 
 		cmd = append(cmd, "-t")
 		cmd = append(cmd, "--console-socket="+s)
-		opts = append(opts, unit.NewUnitOption(svc, "ExecStopPost", "-"+sysctl+" stop "+p.ttyUnitName()))
+		opts = append(opts, unit.NewUnitOption(svc, "ExecStopPost", "-"+p.privileged(sysctl+" stop "+p.ttyUnitName())))
 		prefix = append(prefix, "--tty")
 	}
 
@@ -216,6 +659,15 @@ func (p *execProcess) startOptions() ([]*unit.UnitOption, error) {
 	return opts, nil
 }
 
+// descriptionOption returns the unit description.
+// The trace the process was created in is included so a slow request can be matched up with the unit it started.
+func (p *process) descriptionOption(desc string) *unit.UnitOption {
+	if p.traceID != "" {
+		desc += " (trace " + p.traceID + ")"
+	}
+	return unit.NewUnitOption("Unit", "Description", desc)
+}
+
 func (p *process) unitType() string {
 	if p.opts.SdNotifyEnable {
 		return "notify"
@@ -241,7 +693,7 @@ func (p *initProcess) Start(ctx context.Context) (pid uint32, retErr error) {
 		return 0, fmt.Errorf("process has already exited: %s: %w", p.ProcessState(), errdefs.ErrFailedPrecondition)
 	}
 
-	if err := p.runc.Start(ctx, p.id); err != nil {
+	if err := runcCall(ctx, func(ctx context.Context) error { return p.runc.Start(ctx, p.id) }); err != nil {
 		log.G(ctx).WithError(err).Error("Error calling runc start")
 		ret := fmt.Errorf("failed runc start: %w", err)
 
@@ -260,49 +712,49 @@ func (p *initProcess) Start(ctx context.Context) (pid uint32, retErr error) {
 			}
 		}
 		p.cond.Broadcast()
-
-		if p.runc.Debug {
-			unitData, err := os.ReadFile("/run/systemd/system/" + p.Name())
-			if err == nil {
-				ret = fmt.Errorf("%w:\n%s\n%s", ret, p.Name(), unitData)
-			}
-
-			processData, err := os.ReadFile(filepath.Join(p.Bundle, "config.json"))
-			if err == nil {
-				ret = fmt.Errorf("%w:\nprocess.json:\n%s", ret, string(processData))
-			}
-
-			debug, err := os.ReadFile(p.runc.Log)
-			if err == nil {
-				ret = fmt.Errorf("%w:\nrunc debug:\n%s", ret, string(debug))
-			} else {
-				log.G(ctx).WithError(err).Warn("Error opening runc debug log")
-			}
-		}
-		return 0, ret
+		return 0, p.startDiagnostics(ctx, p.Name(), ret)
 	}
 
-	for p.Pid() == 0 && !p.ProcessState().Exited() {
-		select {
-		case <-ctx.Done():
-		default:
-		}
-
+	if p.Pid() == 0 && !p.ProcessState().Exited() {
 		if err := p.LoadState(ctx); err != nil {
 			log.G(ctx).WithError(err).Warn("Error loading process state")
 		}
 	}
+	if p.Pid() == 0 && !p.ProcessState().Exited() {
+		// The helper didn't record the pid yet, systemd has it as soon as the unit is up.
+		main, err := waitMainPID(ctx, p.systemd, p.Name())
+		if err != nil {
+			if err := p.LoadState(ctx); err != nil {
+				log.G(ctx).WithError(err).Warn("Error loading process state")
+			}
+			if p.ProcessState().Exited() {
+				return 0, nil
+			}
+			return 0, fmt.Errorf("error getting container pid: %w", err)
+		}
+		p.setMainPID(main)
+	}
 
-	return pid, nil
+	p.startPoststartHooks(ctx, p.Pid())
+	p.setupNetworkd(ctx, p.Pid())
+	return p.Pid(), nil
 }
 
 func (p *initProcess) restore(ctx context.Context) (pid uint32, retErr error) {
+	defer func() {
+		if retErr != nil {
+			retErr = p.restoreDiagnostics(retErr)
+		}
+	}()
+
+	var sockPath string
 	if p.Terminal || p.opts.Terminal {
-		sockPath, err := p.ttySockPath()
+		var err error
+		sockPath, err = p.ttySockPath()
 		if err != nil {
 			return 0, err
 		}
-		u, _, err := p.makePty(ctx, sockPath)
+		u, _, err := p.makePty(ctx, sockPath, p)
 		if err != nil {
 			return 0, err
 		}
@@ -312,7 +764,84 @@ func (p *initProcess) restore(ctx context.Context) (pid uint32, retErr error) {
 			}
 		}()
 	}
-	return p.startUnit(ctx)
+	if p.lazyPages() {
+		if err := p.startLazyPages(ctx); err != nil {
+			return 0, err
+		}
+		defer func() {
+			if retErr != nil {
+				p.systemd.StopUnitContext(ctx, p.lazyPagesUnitName(), "replace", nil)
+			}
+		}()
+	}
+	p.startLogRelay(ctx, p.Name())
+
+	pid, err := p.startUnit(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if retErr != nil {
+			p.systemd.KillUnitContext(ctx, p.Name(), int32(syscall.SIGKILL))
+		}
+	}()
+
+	if sockPath != "" {
+		if err := p.waitConsole(ctx, sockPath); err != nil {
+			return 0, err
+		}
+	}
+
+	// criu can fail after the unit started, the container is only reported as started once it is known to be running.
+	main, err := waitMainPID(ctx, p.systemd, p.Name())
+	if err != nil {
+		return 0, fmt.Errorf("restored container is not running: %w", err)
+	}
+	if err := unix.Kill(int(main.Pid), 0); err != nil {
+		return 0, fmt.Errorf("restored container process %d is not running: %w", main.Pid, err)
+	}
+	if main.Pid != pid {
+		log.G(ctx).WithField("pid", pid).WithField("mainPid", main.Pid).Debug("Using the main pid of the restored container")
+		p.mu.Lock()
+		p.state.Pid = main.Pid
+		p.mu.Unlock()
+	}
+	p.setMainPID(main)
+	p.setupNetworkd(ctx, main.Pid)
+	return main.Pid, nil
+}
+
+// waitConsole waits for the console proxy of a restored container to have the pty.
+// The runtime hands it over during the restore, the proxy only answers once it got it.
+func (p *initProcess) waitConsole(ctx context.Context, sockPath string) error {
+	ctx, cancel := context.WithTimeout(ctx, restoreConsoleTimeout)
+	defer cancel()
+
+	for {
+		err := p.ttyOp(sockPath, ttyOpPing)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("console of the restored container was not set up: %w", err)
+		case <-time.After(restoreConsoleInterval):
+		}
+	}
+}
+
+// restoreDiagnostics adds the end of the criu restore log to err.
+func (p *initProcess) restoreDiagnostics(err error) error {
+	lines := tailFile(filepath.Join(p.opts.CriuWorkPath, criuRestoreLog), diagnosticLines)
+	if len(lines) == 0 {
+		return err
+	}
+	var se *startError
+	if errors.As(err, &se) {
+		se.CriuLog = lines
+		return err
+	}
+	return fmt.Errorf("%w\ncriu log:\n  %s", err, strings.Join(lines, "\n  "))
 }
 
 func (p *execProcess) Start(ctx context.Context) (_ uint32, retErr error) {
@@ -328,7 +857,7 @@ func (p *execProcess) Start(ctx context.Context) (_ uint32, retErr error) {
 		if err != nil {
 			return 0, err
 		}
-		u, _, err := p.makePty(ctx, sockPath)
+		u, _, err := p.makePty(ctx, sockPath, p)
 		if err != nil {
 			return 0, err
 		}
@@ -340,8 +869,14 @@ func (p *execProcess) Start(ctx context.Context) (_ uint32, retErr error) {
 		}()
 	}
 
-	ch := make(chan string, 1)
-	if _, err := p.systemd.StartUnitContext(ctx, p.Name(), "replace", ch); err != nil {
+	p.startLogRelay(ctx, p.Name())
+
+	var ch chan string
+	if err := retry(ctx, "start-unit", func(ctx context.Context) error {
+		ch = make(chan string, 1)
+		_, err := p.startUnitJob(ctx, p.Name(), ch)
+		return err
+	}, nil); err != nil {
 		return 0, err
 	}
 
@@ -364,41 +899,14 @@ func (p *execProcess) Start(ctx context.Context) (_ uint32, retErr error) {
 				break
 			}
 
-			ret := fmt.Errorf("error starting exec process")
-			if p.runc.Debug {
-				ret = fmt.Errorf("%w:\n%s", ret, p.Name())
-				unitData, err := os.ReadFile("/run/systemd/system/" + p.Name())
-				if err == nil {
-					ret = fmt.Errorf("%w:\n%s\n%s", ret, p.Name(), unitData)
-				}
-
-				processData, err := os.ReadFile(p.processFilePath())
-				if err == nil {
-					ret = fmt.Errorf("%w:\nprocess.json:\n%s", ret, string(processData))
-				}
-
-				debug, err := os.ReadFile(p.runc.Log)
-				if err == nil {
-					ret = fmt.Errorf("%w:\nrunc debug:\n%s", ret, string(debug))
-				} else {
-					log.G(ctx).WithError(err).Warn("Error opening runc debug log")
-				}
-			}
-			return 0, ret
+			return 0, p.startDiagnostics(ctx, p.Name(), fmt.Errorf("error starting exec process: %s", status))
 		}
 	}
 
 	p.LoadState(ctx)
 
 	if p.ProcessState().Status == exitedInit || p.ProcessState().Status == "exit-code" {
-		ret := fmt.Errorf("error starting exec process")
-		if p.runc.Debug {
-			debug, err := os.ReadFile(p.runc.Log)
-			if err == nil {
-				ret = fmt.Errorf("%w:\nrunc debug:\n%s", ret, string(debug))
-			}
-		}
-		return 0, ret
+		return 0, p.startDiagnostics(ctx, p.Name(), fmt.Errorf("error starting exec process"))
 	}
 
 	pid, err := p.getPid(ctx)
@@ -406,6 +914,13 @@ func (p *execProcess) Start(ctx context.Context) (_ uint32, retErr error) {
 		return 0, err
 	}
 
+	if p.opts.ExecCgroup {
+		// The process keeps running in the container's cgroup if this fails, which is where it would be by default.
+		if err := p.attachToUnit(ctx, pid); err != nil {
+			log.G(ctx).WithError(err).Warn("Exec process is left in the container cgroup")
+		}
+	}
+
 	p.mu.Lock()
 	p.state.Pid = pid
 	p.mu.Unlock()
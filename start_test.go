@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUnitDuration(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    time.Duration
+		invalid bool
+	}{
+		{in: "10", want: 10 * time.Second},
+		{in: "5min", want: 5 * time.Minute},
+		{in: "1h 30s", want: time.Hour + 30*time.Second},
+		{in: "1h30min", want: 90 * time.Minute},
+		{in: "1m30s", want: 90 * time.Second},
+		{in: "2.5s", want: 2500 * time.Millisecond},
+		{in: "500ms", want: 500 * time.Millisecond},
+		{in: "100us", want: 100 * time.Microsecond},
+		{in: "1 day", want: 24 * time.Hour},
+		{in: "", invalid: true},
+		{in: "5 fortnights", invalid: true},
+		{in: "-5s", invalid: true},
+	} {
+		got, err := parseUnitDuration(tc.in)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %d", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if want := uint64(tc.want / time.Microsecond); got != want {
+			t.Errorf("%q: got %dus, want %dus", tc.in, got, want)
+		}
+	}
+
+	if got, err := parseUnitDuration("infinity"); err != nil || got != math.MaxUint64 {
+		t.Errorf("infinity: got %d, %v", got, err)
+	}
+}
+
+func TestSplitCommandLine(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    []string
+		invalid bool
+	}{
+		{in: "/bin/true", want: []string{"/bin/true"}},
+		{in: "  /bin/echo a   b ", want: []string{"/bin/echo", "a", "b"}},
+		{in: `/bin/sh -c "echo \"a b\""`, want: []string{"/bin/sh", "-c", `echo "a b"`}},
+		{in: `/bin/echo 'a b' c\ d`, want: []string{"/bin/echo", "a b", "c d"}},
+		{in: `/bin/echo a\x2db \n`, want: []string{"/bin/echo", "a-b", "\n"}},
+		{in: `/bin/echo ""`, want: []string{"/bin/echo", ""}},
+		{in: `/bin/echo "a`, invalid: true},
+		{in: `/bin/echo a\`, invalid: true},
+	} {
+		got, err := splitCommandLine(tc.in)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %q", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestQuoteExecArg(t *testing.T) {
+	args := []string{"/usr/bin/shim", "--root=/var/lib/my shim", `--log-level=info,"io"=debug`, `a\b`, "", "tab\there", ";"}
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = quoteExecArg(a)
+	}
+	got, err := splitCommandLine(strings.Join(quoted, " "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, args) {
+		t.Errorf("got %q, want %q", got, args)
+	}
+
+	if got := quoteExecArg("--ttrpc-address=$HOME/sock"); got != "--ttrpc-address=$$HOME/sock" {
+		t.Errorf("variables not escaped: %s", got)
+	}
+}
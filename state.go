@@ -16,7 +16,6 @@ import (
 	"github.com/containerd/containerd/log"
 	"github.com/containerd/containerd/namespaces"
 	taskapi "github.com/containerd/containerd/runtime/v2/task"
-	"github.com/coreos/go-systemd/v22/dbus"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
@@ -29,6 +28,10 @@ import (
 // Until that gets figured out, we need to keep using polling.
 func (m *unitManager) Watch(ctx context.Context) {
 	filterFn := func(p Process) bool {
+		if pInit, ok := p.(*initProcess); ok && pInit.restartable() {
+			// systemd may bring it back, see checkRestart.
+			return false
+		}
 		return p.ProcessState().ExitedAt.After(timeZero)
 	}
 
@@ -81,6 +84,10 @@ func (m *unitManager) Watch(ctx context.Context) {
 
 			ctx = WithShimLog(ctx, p.LogWriter())
 
+			if pInit, ok := p.(*initProcess); ok {
+				pInit.checkRestart(ctx)
+			}
+
 			if p.ProcessState().Exited() {
 				// Process is already exited, we don't care about state updates on this unit anymore
 				log.G(ctx).Debug("Skipped unit status update for exited process")
@@ -94,6 +101,10 @@ func (m *unitManager) Watch(ctx context.Context) {
 			}
 
 			log.G(ctx).WithField("unit", p.Name()).Debugf("Updated unit state: %s", p.ProcessState())
+
+			if pInit, ok := p.(*initProcess); ok {
+				pInit.checkOOM(ctx)
+			}
 		}
 
 		timer.Reset(time.Minute)
@@ -106,13 +117,25 @@ func (m *unitManager) Watch(ctx context.Context) {
 			if !timer.Stop() {
 				<-timer.C
 			}
+		case <-m.refresh:
+			if !timer.Stop() {
+				<-timer.C
+			}
 		case <-timer.C:
 		}
 	}
 }
 
+// Refresh triggers a poll of the unit states without waiting for the next interval.
+func (m *unitManager) Refresh() {
+	select {
+	case m.refresh <- struct{}{}:
+	default:
+	}
+}
+
 func (m *unitManager) Keys(filter func(p Process) bool) []string {
-	m.mu.Lock()
+	m.mu.RLock()
 	keys := make([]string, 0, len(m.idx))
 	for k, p := range m.idx {
 		if filter(p) {
@@ -120,12 +143,23 @@ func (m *unitManager) Keys(filter func(p Process) bool) []string {
 		}
 		keys = append(keys, k)
 	}
-	m.mu.Unlock()
+	m.mu.RUnlock()
 	return keys
 }
 
 func (s *Service) watchUnits(ctx context.Context) error {
 	go s.units.Watch(ctx)
+	if err := s.watchUnitChanges(ctx); err != nil {
+		log.G(ctx).WithError(err).Warn("Error subscribing to unit changes, exits will only be picked up by polling")
+	}
+
+	// Signal subscriptions are per connection and we may have missed exits while disconnected.
+	s.conn.OnReconnect(func() {
+		if err := s.watchUnitChanges(ctx); err != nil {
+			log.G(ctx).WithError(err).Warn("Error subscribing to unit changes after reconnecting to systemd")
+		}
+		s.units.Refresh()
+	})
 	return nil
 }
 
@@ -186,7 +220,12 @@ func (s *Service) State(ctx context.Context, r *taskapi.StateRequest) (_ *taskap
 	}, nil
 }
 
-func getUnitState(ctx context.Context, conn *dbus.Conn, unit string, st *pState) error {
+// unitPropertyGetter is implemented by both sdConn and the plain go-systemd connection used by the exit command.
+type unitPropertyGetter interface {
+	GetAllPropertiesContext(ctx context.Context, unit string) (map[string]interface{}, error)
+}
+
+func getUnitState(ctx context.Context, conn unitPropertyGetter, unit string, st *pState) error {
 	state, err := conn.GetAllPropertiesContext(ctx, unit)
 	if err != nil {
 		return err
@@ -196,26 +235,22 @@ func getUnitState(ctx context.Context, conn *dbus.Conn, unit string, st *pState)
 		st.Pid = uint32(p.(uint32))
 	}
 	if c := state["ExecMainStatus"]; c != nil {
-		st.ExitCode = uint32(c.(int32))
+		code, _ := state["ExecMainCode"].(int32)
+		st.ExitCode = execMainExitCode(code, c.(int32))
 	}
 
-	// if ts := state["ExecMainExitTimestamp"]; ts != nil {
-	// st.ExitedAt = time.UnixMicro(int64(ts.(uint64)))
-	// if !st.ExitedAt.After(timeZero) {
 	if st.ExitCode == 0 {
 		execStart := state["ExecStart"].([][]interface{})
 		if len(execStart) > 0 {
-			code, _ := readExecStatusExit(state["ExecStart"].([][]interface{})[0])
-			// if t.After(timeZero) {
-			// st.ExitedAt = t
+			code, _ := readExecStatusExit(execStart[0])
 			if code > 0 {
 				st.ExitCode = uint32(code)
 			}
 		}
-		// }
 	}
-	//}
-	// }
+	if t := unitExitTime(state); t.After(timeZero) {
+		st.ExitedAt = t
+	}
 	if status := state["SubState"]; status != nil {
 		st.Status = status.(string)
 	}
@@ -223,12 +258,34 @@ func getUnitState(ctx context.Context, conn *dbus.Conn, unit string, st *pState)
 	return nil
 }
 
+// unitExitTime returns when the unit's main process exited from the unit properties, or the zero time while it is
+// running.
+// ExecMainExitTimestamp is only set once the main process exited, a restarted unit keeps the one of the previous run
+// until its new process exits. Units that never had a main process (e.g. failed before starting it) get the time they
+// became inactive.
+func unitExitTime(props map[string]interface{}) time.Time {
+	exited, _ := props["ExecMainExitTimestamp"].(uint64)
+	started, _ := props["ExecMainStartTimestamp"].(uint64)
+	if exited > 0 && exited >= started {
+		return time.UnixMicro(int64(exited))
+	}
+	switch state, _ := props["ActiveState"].(string); state {
+	case "inactive", "failed":
+		if inactive, _ := props["InactiveEnterTimestamp"].(uint64); inactive > 0 {
+			return time.UnixMicro(int64(inactive))
+		}
+	}
+	return timeZero
+}
+
 type State struct {
 	State                 pState
 	Bundle                string
 	ID                    string
 	Stdin, Stdout, Stderr string
 	Terminal              bool
+	// Restarts is the number of times systemd restarted the container, see restartAnnotation.
+	Restarts uint32
 }
 
 func (p *initProcess) State(ctx context.Context) (*State, error) {
@@ -243,6 +300,10 @@ func (p *initProcess) State(ctx context.Context) (*State, error) {
 
 	p.mu.Lock()
 	p.state.CopyTo(&resp.State)
+	if p.freezeState != "" && !resp.State.Exited() {
+		resp.State.Status = p.freezeState
+	}
+	resp.Restarts = p.restarts
 	p.mu.Unlock()
 
 	return resp, nil
@@ -263,6 +324,11 @@ func (p *initProcess) LoadState(ctx context.Context) error {
 
 	st.Reset()
 	if err := getUnitState(ctx, p.systemd, p.Name(), &st); err != nil {
+		// The unit may be gone, with the exit recorded by it.
+		if err2 := readExitStatusFile(p.exitStatusPath(), &st); err2 == nil {
+			p.SetState(ctx, st)
+			return nil
+		}
 		return err
 	}
 	p.SetState(ctx, st)
@@ -272,6 +338,10 @@ func (p *initProcess) LoadState(ctx context.Context) error {
 func (p *execProcess) LoadState(ctx context.Context) error {
 	var st pState
 	err := p.readExitState(&st)
+	if os.IsNotExist(err) {
+		// e.g. the state root was cleared
+		err = readExitStatusFile(p.exitStatusPath(), &st)
+	}
 	if err == nil {
 		p.SetState(ctx, st)
 		return nil
@@ -350,6 +420,8 @@ type pState struct {
 	ExitCode uint32
 	Pid      uint32
 	Status   string
+	// Result is the systemd service result, e.g. "watchdog", when known.
+	Result string `json:",omitempty"`
 }
 
 func (s *pState) Reset() {
@@ -357,6 +429,7 @@ func (s *pState) Reset() {
 	s.ExitCode = 0
 	s.Pid = 0
 	s.Status = ""
+	s.Result = ""
 }
 
 func (s pState) Exited() bool {
@@ -402,6 +475,9 @@ func (s *pState) CopyTo(other *pState) {
 	if s.Status != "" {
 		other.Status = s.Status
 	}
+	if s.Result != "" {
+		other.Result = s.Result
+	}
 }
 
 type execState struct {
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/log"
+)
+
+// stateDirName is the directory in the state root the runtime state of containers is kept in.
+//
+// The runtime state is what the shim writes while a container runs: pid files, the process specs and runtime logs of
+// execs, the mount config of the rootfs, tty socket info... It is only of use as long as the container runs, so by
+// default it is kept on tmpfs (--state-root=/run) where it is gone after a reboot and doesn't wear out the disk of
+// small devices. Checkpoint images and the bundle itself stay where they are.
+//
+// Containers keep the state directory they were created with, it is recorded with the task and referenced by their
+// units. When --state-root changes only new containers use the new one.
+const stateDirName = "containerd-shim-systemd"
+
+// containerStateDir is the directory the runtime state of a new container is kept in.
+func (s *Service) containerStateDir(ns, id string) string {
+	return filepath.Join(s.stateRoot, stateDirName, ns, id)
+}
+
+// createStateDir creates the state directory of the container, labeled like its bundle.
+func createStateDir(dir, bundle string) error {
+	if dir == bundle {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0711); err != nil {
+		return err
+	}
+	if err := labelLike(filepath.Dir(dir), bundle); err != nil {
+		return err
+	}
+	return labelLike(dir, bundle)
+}
+
+// cleanupStateDir removes the state directory of the container, and the namespace directory with it if it is empty.
+// Containers created before the state root existed keep their state in the bundle, which is left alone.
+func (p *initProcess) cleanupStateDir(ctx context.Context) {
+	if p.root == p.Bundle {
+		return
+	}
+	if err := os.RemoveAll(p.root); err != nil {
+		log.G(ctx).WithError(err).WithField("path", p.root).Debug("Error removing container state directory")
+		return
+	}
+	os.Remove(filepath.Dir(p.root))
+}
+
+// collectStateDirs removes the state directories of containers that are gone, e.g. after the shim crashed in the
+// middle of a delete. This must be called after Recover.
+//
+// Shim instances with different roots must not share a state root.
+func (s *Service) collectStateDirs(ctx context.Context) {
+	base := filepath.Join(s.stateRoot, stateDirName)
+	dirs, _ := filepath.Glob(filepath.Join(base, "*", "*"))
+	for _, dir := range dirs {
+		ns, id := filepath.Base(filepath.Dir(dir)), filepath.Base(dir)
+		if s.processes.Get(ns+"/"+id) != nil {
+			continue
+		}
+		log.G(ctx).WithField("ns", ns).WithField("id", id).Info("Removing state directory of removed container")
+		if err := os.RemoveAll(dir); err != nil {
+			log.G(ctx).WithError(err).WithField("path", dir).Warn("Error removing container state directory")
+			continue
+		}
+		os.Remove(filepath.Dir(dir))
+	}
+}
+
+// recordedStateDir returns the state directory of a recovered container.
+func (s *Service) recordedStateDir(ctx context.Context, rec taskRecord) string {
+	if rec.StateDir == "" {
+		// Created before the state root existed, its state is in the bundle.
+		return rec.Bundle
+	}
+	if !strings.HasPrefix(rec.StateDir, filepath.Join(s.stateRoot, stateDirName)+string(filepath.Separator)) {
+		log.G(ctx).WithField("path", rec.StateDir).Info("Container was created with another state root, keeping its state directory")
+	}
+	return rec.StateDir
+}
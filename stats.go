@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+
+	"github.com/containerd/cgroups"
+	v1stats "github.com/containerd/cgroups/stats/v1"
+	cgroupsv2 "github.com/containerd/cgroups/v2"
+	v2stats "github.com/containerd/cgroups/v2/stats"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/typeurl"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+	"github.com/gogo/protobuf/proto"
+)
+
+// extendedStatsAnnotation makes Stats return options.Metrics instead of the cgroup metrics.
+// It takes precedence over the create option.
+const extendedStatsAnnotation = shimName + ".extended-stats"
+
+func init() {
+	// The options are generated against golang/protobuf, typeurl looks up names in the gogo registry.
+	proto.RegisterType((*options.Metrics)(nil), "containerd.systemd.v1.Metrics")
+}
+
+// extendedStatsAnnotations applies the extended stats setting from the container annotations to the create options.
+func extendedStatsAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	v := annotations[extendedStatsAnnotation]
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("annotation %s: invalid value %q: %w", extendedStatsAnnotation, v, errdefs.ErrInvalidArgument)
+	}
+	opts.ExtendedStats = b
+	return nil
+}
+
+// unitCgroup returns the cgroup systemd has for the unit.
+func unitCgroup(ctx context.Context, conn *sdConn, name string) (string, error) {
+	return unitTypeCgroup(ctx, conn, name, "Service")
+}
+
+// unitTypeCgroup returns the cgroup systemd has for a unit of the given type, e.g. "Slice".
+func unitTypeCgroup(ctx context.Context, conn *sdConn, name, unitType string) (string, error) {
+	prop, err := conn.GetUnitTypePropertyContext(ctx, name, unitType, "ControlGroup")
+	if err != nil {
+		return "", fmt.Errorf("error getting unit cgroup: %w", err)
+	}
+	g, _ := prop.Value.Value().(string)
+	if g == "" {
+		return "", fmt.Errorf("unit %s has no cgroup", name)
+	}
+	return g, nil
+}
+
+// unitCgroup returns the cgroup of the process's unit.
+// The cgroup doesn't change while the unit exists, so the one systemd reported when the unit started is used if we
+// have it.
+func (p *process) unitCgroup(ctx context.Context, name string) (string, error) {
+	p.mu.Lock()
+	g := p.controlGroup
+	p.mu.Unlock()
+	if g != "" {
+		return g, nil
+	}
+	return unitCgroup(ctx, p.systemd, name)
+}
+
+// cgroupV2Path returns the container's cgroup relative to the unified hierarchy.
+// This is the cgroup of the container pid while it is running, the unit's cgroup otherwise.
+func (p *initProcess) cgroupV2Path(ctx context.Context) (string, error) {
+	if pid := int(p.Pid()); pid > 0 && !p.ProcessState().Exited() {
+		return cgroupsv2.PidGroupPath(pid)
+	}
+	return p.unitCgroup(ctx, p.Name())
+}
+
+// Stats collects metrics for the container.
+// Metrics are read from cgroupfs, falling back to the accounting data systemd keeps for the unit.
+// Execs running in a cgroup of their own are not included, their metrics are only in the extended stats.
+func (p *initProcess) Stats(ctx context.Context) (interface{}, error) {
+	stats, err := p.cgroupStats(ctx)
+	if err != nil {
+		log.G(ctx).WithError(err).Debug("Error reading cgroup stats, falling back to systemd accounting")
+		stats, err = p.unitStats(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !p.opts.ExtendedStats {
+		return stats, nil
+	}
+	return p.extendedStats(ctx, stats)
+}
+
+// extendedStats wraps the cgroup metrics in options.Metrics together with the pressure of the container, the
+// metrics of its execs and the unit accounting.
+func (p *initProcess) extendedStats(ctx context.Context, stats interface{}) (*options.Metrics, error) {
+	msg, ok := stats.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("unexpected metrics type %T", stats)
+	}
+	url, err := typeurl.TypeURL(msg)
+	if err != nil {
+		return nil, err
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	m := &options.Metrics{TypeUrl: url, Value: data}
+
+	if cgroups.Mode() == cgroups.Unified {
+		g, err := p.cgroupV2Path(ctx)
+		if err == nil {
+			m.Pressure, err = cgroupPressure(filepath.Join("/sys/fs/cgroup", g))
+		}
+		if err != nil {
+			log.G(ctx).WithError(err).Debug("Error reading cgroup pressure")
+		}
+	}
+	if st := p.execStats(ctx); len(st.Execs) > 0 {
+		m.ExecStats = st
+	}
+	if len(p.opts.Accounting) > 0 {
+		if m.Accounting, err = p.unitAccounting(ctx); err != nil {
+			log.G(ctx).WithError(err).Debug("Error getting unit accounting")
+		}
+	}
+	return m, nil
+}
+
+// cgroupStats reads metrics from cgroupfs.
+// The container pid is the source of truth since runc may place the container in a different cgroup than the unit,
+// but if the container is not running we use the cgroup systemd has for the unit.
+func (p *initProcess) cgroupStats(ctx context.Context) (interface{}, error) {
+	pid := int(p.Pid())
+	running := pid > 0 && !p.ProcessState().Exited()
+
+	if cgroups.Mode() == cgroups.Unified {
+		g, err := p.cgroupV2Path(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cg, err := cgroupsv2.LoadManager("/sys/fs/cgroup", g)
+		if err != nil {
+			return nil, err
+		}
+		return cg.Stat()
+	}
+
+	path := cgroups.PidPath(pid)
+	if !running {
+		g, err := p.unitCgroup(ctx, p.Name())
+		if err != nil {
+			return nil, err
+		}
+		path = cgroups.StaticPath(g)
+	}
+	cg, err := cgroups.Load(cgroups.V1, path)
+	if err != nil {
+		return nil, err
+	}
+	return cg.Stat(cgroups.IgnoreNotExist)
+}
+
+func (p *initProcess) unitStats(ctx context.Context) (interface{}, error) {
+	props, err := p.systemd.GetUnitTypePropertiesContext(ctx, p.Name(), "Service")
+	if err != nil {
+		return nil, err
+	}
+
+	// systemd reports UINT64_MAX for values it doesn't have
+	get := func(name string) uint64 {
+		v, ok := props[name].(uint64)
+		if !ok || v == math.MaxUint64 {
+			return 0
+		}
+		return v
+	}
+
+	var (
+		cpu     = get("CPUUsageNSec")
+		mem     = get("MemoryCurrent")
+		tasks   = get("TasksCurrent")
+		rbytes  = get("IOReadBytes")
+		wbytes  = get("IOWriteBytes")
+		memMax  = get("MemoryMax")
+		taskMax = get("TasksMax")
+		// MemorySwapCurrent is only reported by newer versions of systemd.
+		swap    = get("MemorySwapCurrent")
+		swapMax = get("MemorySwapMax")
+	)
+
+	if cgroups.Mode() == cgroups.Unified {
+		return &v2stats.Metrics{
+			Pids:   &v2stats.PidsStat{Current: tasks, Limit: taskMax},
+			CPU:    &v2stats.CPUStat{UsageUsec: cpu / 1000},
+			Memory: &v2stats.MemoryStat{Usage: mem, UsageLimit: memMax, SwapUsage: swap, SwapLimit: swapMax},
+			Io: &v2stats.IOStat{
+				Usage: []*v2stats.IOEntry{{Rbytes: rbytes, Wbytes: wbytes}},
+			},
+		}, nil
+	}
+
+	memStat := &v1stats.MemoryStat{Usage: &v1stats.MemoryEntry{Usage: mem, Limit: memMax}}
+	if swap > 0 {
+		// memsw on cgroup v1 is memory+swap.
+		memStat.Swap = &v1stats.MemoryEntry{Usage: mem + swap}
+	}
+	return &v1stats.Metrics{
+		Pids:   &v1stats.PidsStat{Current: tasks, Limit: taskMax},
+		CPU:    &v1stats.CPUStat{Usage: &v1stats.CPUUsage{Total: cpu}},
+		Memory: memStat,
+		Blkio: &v1stats.BlkIOStat{
+			IoServiceBytesRecursive: []*v1stats.BlkIOEntry{
+				{Op: "Read", Value: rbytes},
+				{Op: "Write", Value: wbytes},
+			},
+		},
+	}, nil
+}
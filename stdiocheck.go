@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+// Stdio no reader policies decide what happens when the stdout or stderr fifo of a process is missing or nobody reads
+// from it when its unit starts, e.g. because the client that created the container went away. The fifos are opened
+// read-write so the unit never blocks opening them, but the process then fills the pipe and blocks on its next write.
+// The fifos are checked every time the unit starts, the client may be gone when a container is restarted.
+const (
+	// stdioNoReaderFail fails the start of the unit.
+	stdioNoReaderFail = "fail"
+	// stdioNoReaderWarn logs a warning and starts the process with the fifo anyway.
+	stdioNoReaderWarn = "warn"
+	// stdioNoReaderNull discards the output instead.
+	stdioNoReaderNull = "null"
+)
+
+// stdioNoReaderEnv passes the policy to the create command of the unit.
+const stdioNoReaderEnv = "STDIO_NO_READER"
+
+func validateStdioNoReaderPolicy(s string) error {
+	switch s {
+	case stdioNoReaderFail, stdioNoReaderWarn, stdioNoReaderNull:
+		return nil
+	default:
+		return fmt.Errorf("invalid stdio no reader policy %q: %w", s, errdefs.ErrInvalidArgument)
+	}
+}
+
+// probeFifo opens the fifo at path for writing without blocking, which fails if it does not exist or has no reader.
+// A reader sees EOF once the last writer goes away, so the returned file must only be closed once the fifo is opened
+// for writing again.
+func probeFifo(path string) (*os.File, error) {
+	fd, err := unix.Open(path, unix.O_WRONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	switch {
+	case err == nil:
+		return os.NewFile(uintptr(fd), path), nil
+	case errors.Is(err, unix.ENOENT):
+		return nil, fmt.Errorf("fifo %s does not exist: %w", path, errdefs.ErrNotFound)
+	case errors.Is(err, unix.ENXIO):
+		return nil, fmt.Errorf("fifo %s has no reader: %w", path, errdefs.ErrFailedPrecondition)
+	default:
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+}
+
+// checkStdioOutput applies the no reader policy to the stdout or stderr fifo at path.
+// It returns the path to use for the stream, os.DevNull when the output is discarded, and the probe of the fifo, which
+// the caller closes once the fifo is opened for writing.
+func checkStdioOutput(ctx context.Context, policy, stream, path string) (string, *os.File, error) {
+	if !isFifo(path) {
+		return path, nil, nil
+	}
+	f, err := probeFifo(path)
+	if err == nil {
+		return path, f, nil
+	}
+
+	switch policy {
+	case stdioNoReaderFail:
+		return "", nil, fmt.Errorf("%s: %w", stream, err)
+	case stdioNoReaderNull:
+		log.G(ctx).WithError(err).WithField("stream", stream).Warn("Discarding the output of the process")
+		return os.DevNull, nil, nil
+	default:
+		log.G(ctx).WithError(err).WithField("stream", stream).Warn("The process blocks once the pipe is full")
+		return path, nil, nil
+	}
+}
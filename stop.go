@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/coreos/go-systemd/unit"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/cpuguy83/containerd-shim-systemd-v1/options"
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/gogo/protobuf/proto"
+	"golang.org/x/sys/unix"
+)
+
+// Annotations to control how the container unit is stopped.
+// They take precedence over the create options.
+const (
+	killModeAnnotation        = shimName + ".kill-mode"
+	killSignalAnnotation      = shimName + ".kill-signal"
+	finalKillSignalAnnotation = shimName + ".final-kill-signal"
+	timeoutStopAnnotation     = shimName + ".timeout-stop-sec"
+	// killGracePeriodAnnotation makes the shim send SIGKILL when the process has not exited within the given time after
+	// it was sent SIGTERM (or the kill signal) with the Kill API, e.g. "10s".
+	// Unlike timeoutStopAnnotation, which is for stopping the unit, this covers clients that only send the signal.
+	killGracePeriodAnnotation = shimName + ".kill-grace-period"
+)
+
+const (
+	// stoppedEventTopic is the topic TaskStopped events are published on.
+	stoppedEventTopic = "/tasks/stopped"
+	// killEscalationSlack is how long a Delete waiting for an escalated kill gives the process to go away after the
+	// grace period, see waitKillEscalation.
+	killEscalationSlack = 10 * time.Second
+)
+
+func init() {
+	// See the registration of options.Pressure.
+	proto.RegisterType((*options.TaskStopped)(nil), "containerd.systemd.v1.TaskStopped")
+}
+
+func validateKillMode(s string) error {
+	switch s {
+	case "control-group", "mixed", "process":
+		return nil
+	default:
+		return fmt.Errorf("invalid kill mode %q: %w", s, errdefs.ErrInvalidArgument)
+	}
+}
+
+// stopAnnotations applies the stop settings from the container annotations to the create options.
+func stopAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	if v := annotations[killModeAnnotation]; v != "" {
+		opts.KillMode = v
+	}
+	if opts.KillMode != "" {
+		if err := validateKillMode(opts.KillMode); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range []struct {
+		key string
+		sig *int
+	}{
+		{killSignalAnnotation, &opts.KillSignal},
+		{finalKillSignalAnnotation, &opts.FinalKillSignal},
+	} {
+		if v := annotations[s.key]; v != "" {
+			sig, err := parseSignal(v)
+			if err != nil {
+				return fmt.Errorf("annotation %s: %w", s.key, err)
+			}
+			*s.sig = sig
+		}
+	}
+
+	for _, s := range []struct {
+		key string
+		d   *time.Duration
+	}{
+		{timeoutStopAnnotation, &opts.TimeoutStop},
+		{killGracePeriodAnnotation, &opts.KillGracePeriod},
+	} {
+		if v := annotations[s.key]; v != "" {
+			d, err := parseAnnotationDuration(s.key, v)
+			if err != nil {
+				return err
+			}
+			*s.d = d
+		}
+	}
+
+	if (opts.KillSignal != 0 && !validSignal(opts.KillSignal)) || (opts.FinalKillSignal != 0 && !validSignal(opts.FinalKillSignal)) {
+		return fmt.Errorf("invalid kill signal: %w", errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// parseAnnotationDuration parses the value of the duration annotation key, a systemd time span that must be positive.
+func parseAnnotationDuration(key, v string) (time.Duration, error) {
+	usec, err := parseUnitDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("annotation %s: %w", key, err)
+	}
+	d := time.Duration(usec) * time.Microsecond
+	if d <= 0 || d/time.Microsecond != time.Duration(usec) {
+		return 0, fmt.Errorf("annotation %s: invalid duration %q: %w", key, v, errdefs.ErrInvalidArgument)
+	}
+	return d, nil
+}
+
+// stopOptions returns the unit options that control how the container is stopped.
+func (p *process) stopOptions() []*unit.UnitOption {
+	const svc = "Service"
+
+	var opts []*unit.UnitOption
+	if p.opts.KillMode != "" {
+		opts = append(opts, unit.NewUnitOption(svc, "KillMode", p.opts.KillMode))
+	}
+	if p.opts.KillSignal > 0 {
+		opts = append(opts, unit.NewUnitOption(svc, "KillSignal", strconv.Itoa(p.opts.KillSignal)))
+	}
+	if p.opts.FinalKillSignal > 0 {
+		opts = append(opts, unit.NewUnitOption(svc, "FinalKillSignal", strconv.Itoa(p.opts.FinalKillSignal)))
+	}
+	if p.opts.TimeoutStop > 0 {
+		opts = append(opts, unit.NewUnitOption(svc, "TimeoutStopSec", strconv.FormatInt(p.opts.TimeoutStop.Microseconds(), 10)+"us"))
+	}
+	return opts
+}
+
+// killError maps the errors systemd returns when there is nothing to signal to ErrNotFound, which is what clients
+// expect when the process already exited.
+func killError(err error) error {
+	var dErr dbus.Error
+	if errors.As(err, &dErr) {
+		switch dErr.Name {
+		case sdBusName + ".NoSuchProcess", sdBusName + ".NoSuchUnit":
+			return fmt.Errorf("process already finished: %v: %w", err, errdefs.ErrNotFound)
+		}
+	}
+	if errors.Is(err, unix.ESRCH) || strings.Contains(err.Error(), "no main process") {
+		return fmt.Errorf("process already finished: %v: %w", err, errdefs.ErrNotFound)
+	}
+	return err
+}
+
+// escalateKill sends SIGKILL to the processes sig was sent to if they have not exited once the kill grace period is
+// over, see killGracePeriodAnnotation. Only SIGTERM and the kill signal of the container are escalated.
+func (p *process) escalateKill(ctx context.Context, name string, who systemd.Who, sig int) {
+	grace := p.opts.KillGracePeriod
+	if grace <= 0 || (sig != int(unix.SIGTERM) && sig != p.opts.KillSignal) {
+		return
+	}
+
+	p.mu.Lock()
+	if p.killEscalation {
+		// The earlier kill is escalated already.
+		p.mu.Unlock()
+		return
+	}
+	p.killEscalation = true
+	p.killSignaled = true
+	p.mu.Unlock()
+
+	// The kill request is done by the time the grace period is over.
+	ctx = log.WithLogger(context.Background(), log.G(ctx))
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			p.killEscalation = false
+			p.mu.Unlock()
+		}()
+
+		waitCtx, cancel := context.WithTimeout(ctx, grace)
+		_, err := p.waitForExit(waitCtx)
+		cancel()
+		if err == nil {
+			return
+		}
+
+		log.G(ctx).WithField("gracePeriod", grace).Warn("Process did not exit within the kill grace period, sending SIGKILL")
+		// Set before the kill so it is there when the exit is reported.
+		p.mu.Lock()
+		p.killForced = true
+		p.mu.Unlock()
+		if err := p.systemd.KillUnitWithTarget(ctx, name, who, int32(unix.SIGKILL)); err != nil {
+			log.G(ctx).WithError(killError(err)).Debug("Error escalating kill")
+			p.mu.Lock()
+			p.killForced = false
+			p.mu.Unlock()
+		}
+	}()
+}
+
+// waitKillEscalation waits for the process to exit while a kill is being escalated.
+// Clients stopping a container send SIGTERM with Kill and then Delete it, possibly without waiting for the exit: the
+// Delete waits out the grace period, which ends with SIGKILL, instead of failing because the process is still running.
+func (p *process) waitKillEscalation(ctx context.Context) {
+	p.mu.Lock()
+	escalating := p.killEscalation
+	p.mu.Unlock()
+	if !escalating {
+		return
+	}
+
+	log.G(ctx).WithField("gracePeriod", p.opts.KillGracePeriod).Debug("Waiting for the process to exit within its kill grace period")
+	ctx, cancel := context.WithTimeout(ctx, p.opts.KillGracePeriod+killEscalationSlack)
+	defer cancel()
+	p.waitForExit(ctx)
+}
+
+// killReport returns if the process was signaled with a kill grace period and if it had to be sent SIGKILL, and resets
+// both for the next run of the process.
+func (p *process) killReport() (signaled, forced bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	signaled, forced = p.killSignaled, p.killForced
+	p.killSignaled, p.killForced = false, false
+	return signaled, forced
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/ttrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// timeoutOp is an operation with a timeout.
+type timeoutOp string
+
+const (
+	// The Create, Start, Kill and Delete requests.
+	opCreate timeoutOp = "create"
+	opStart  timeoutOp = "start"
+	opKill   timeoutOp = "kill"
+	opDelete timeoutOp = "delete"
+	// Each D-Bus call to systemd, every attempt of a call that is retried gets the full timeout.
+	opDBus timeoutOp = "dbus"
+	// Each runtime command run by the shim, except checkpoints which take as long as the container is large.
+	opRunc timeoutOp = "runc"
+)
+
+// timeoutConfig is the timeouts section of the shim config, the timeouts are durations like "30s" and "0" disables
+// one. Timeouts that are not set keep their default, see defaultTimeouts.
+//
+// The timeouts make sure a hung runtime or systemd can't hold up requests forever, as containerd doesn't put a
+// deadline on most of them. Wait has no timeout since it lasts as long as the process.
+type timeoutConfig struct {
+	Create string `toml:"create" json:"create"`
+	Start  string `toml:"start" json:"start"`
+	Kill   string `toml:"kill" json:"kill"`
+	Delete string `toml:"delete" json:"delete"`
+	DBus   string `toml:"dbus" json:"dbus"`
+	Runc   string `toml:"runc" json:"runc"`
+}
+
+// defaultTimeouts leave plenty of room for slow hosts, a Delete may wait out the kill grace period of the container.
+var defaultTimeouts = map[timeoutOp]time.Duration{
+	opCreate: 5 * time.Minute,
+	opStart:  5 * time.Minute,
+	opKill:   time.Minute,
+	opDelete: 5 * time.Minute,
+	opDBus:   time.Minute,
+	opRunc:   2 * time.Minute,
+}
+
+// opTimeouts are the timeouts in use, they are set from the shim config when the service is created.
+var opTimeouts = defaultTimeouts
+
+// parse returns the timeouts with the ones set in the config.
+func (c timeoutConfig) parse() (map[timeoutOp]time.Duration, error) {
+	t := make(map[timeoutOp]time.Duration, len(defaultTimeouts))
+	for op, d := range defaultTimeouts {
+		t[op] = d
+	}
+	for op, v := range map[timeoutOp]string{
+		opCreate: c.Create,
+		opStart:  c.Start,
+		opKill:   c.Kill,
+		opDelete: c.Delete,
+		opDBus:   c.DBus,
+		opRunc:   c.Runc,
+	} {
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid %s timeout %q: %w", op, v, errdefs.ErrInvalidArgument)
+		}
+		t[op] = d
+	}
+	return t, nil
+}
+
+// withTimeout bounds ctx by the timeout of op. The returned function must be called with the result of the operation,
+// it releases the context and, if the operation ran into the timeout, records that and says so in the error.
+func withTimeout(ctx context.Context, op timeoutOp) (context.Context, func(error) error) {
+	d := opTimeouts[op]
+	if d <= 0 {
+		return ctx, func(err error) error { return err }
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, d)
+	return tctx, func(err error) error {
+		// Only our own deadline counts, not the one of the caller.
+		hit := err != nil && tctx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+		cancel()
+		if !hit {
+			return err
+		}
+
+		metrics.timeouts.Inc(string(op))
+		trace.SpanFromContext(ctx).AddEvent("timeout", trace.WithAttributes(
+			attribute.String("operation", string(op)),
+			attribute.Stringer("timeout", d),
+		))
+		log.G(ctx).WithError(err).WithField("operation", op).WithField("timeout", d).Warn("Operation timed out")
+		if errors.Is(errdefs.FromGRPC(err), context.DeadlineExceeded) {
+			return fmt.Errorf("%s timed out after %s: %w", op, d, context.DeadlineExceeded)
+		}
+		// e.g. the runtime was killed
+		return fmt.Errorf("%s timed out after %s: %v: %w", op, d, err, context.DeadlineExceeded)
+	}
+}
+
+// runcCall runs the runtime command fn with the runc timeout and the retry policy.
+func runcCall(ctx context.Context, fn func(ctx context.Context) error) error {
+	return retry(withLogSubsystem(ctx, logRunc), "runc", func(ctx context.Context) error {
+		ctx, done := withTimeout(ctx, opRunc)
+		return done(fn(ctx))
+	}, nil)
+}
+
+// rpcTimeoutOps are the requests with a timeout, by method name.
+var rpcTimeoutOps = map[string]timeoutOp{
+	"Create": opCreate,
+	"Start":  opStart,
+	"Kill":   opKill,
+	"Delete": opDelete,
+}
+
+// timeoutInterceptor puts the timeouts on the requests that have one, within the request span.
+func timeoutInterceptor(ctx context.Context, u ttrpc.Unmarshaler, info *ttrpc.UnaryServerInfo, m ttrpc.Method) (interface{}, error) {
+	return UnaryServerInterceptor(ctx, u, info, func(ctx context.Context, u func(interface{}) error) (interface{}, error) {
+		op, ok := rpcTimeoutOps[info.FullMethod[strings.LastIndexByte(info.FullMethod, '/')+1:]]
+		if !ok {
+			return m(ctx, u)
+		}
+		ctx, done := withTimeout(ctx, op)
+		resp, err := m(ctx, u)
+		if err = done(err); err != nil {
+			return nil, errdefs.ToGRPC(err)
+		}
+		return resp, nil
+	})
+}
+
+// detachedContext returns a context without the deadline of ctx for cleaning up after a failed request, e.g. one that
+// timed out, bounded by the timeout of op. Call cancel when done.
+func detachedContext(ctx context.Context, op timeoutOp) (context.Context, context.CancelFunc) {
+	dctx := trace.ContextWithSpan(log.WithLogger(context.Background(), log.G(ctx)), trace.SpanFromContext(ctx))
+	if d := opTimeouts[op]; d > 0 {
+		return context.WithTimeout(dctx, d)
+	}
+	return context.WithCancel(dctx)
+}
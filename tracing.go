@@ -4,7 +4,12 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/log"
 	"github.com/containerd/containerd/tracing"
 	"github.com/containerd/ttrpc"
@@ -13,6 +18,7 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
@@ -25,53 +31,100 @@ const (
 	cIDAttr = "container.id"
 	eIDAttr = "exec.id"
 	nsAttr  = "ns"
+	// sbIDAttr is the id of a sandbox created through the sandbox API.
+	sbIDAttr = "sandbox.id"
+	// unitAttr is the name of the systemd unit an operation is for.
+	unitAttr = "systemd.unit"
+)
+
+// Trace export protocols.
+const (
+	traceProtocolGRPC = "grpc"
+	traceProtocolHTTP = "http/protobuf"
+	traceProtocolNone = "none"
 )
 
 type TraceConfig struct {
 	Endpoint   string
 	SampleRate float64
 	Insecure   bool
+	// Protocol is the OTLP protocol to export with, see the traceProtocol constants.
+	Protocol    string
+	ServiceName string
+	// ResourceAttributes are extra resource attributes in the OTEL_RESOURCE_ATTRIBUTES format (key1=value1,key2=value2).
+	ResourceAttributes string
 }
 
-func (c TraceConfig) StringFlags() string {
-	return fmt.Sprintf("--trace-endpoint=%s --trace-sample-rate=%f --trace-insecure=%t", c.Endpoint, c.SampleRate, c.Insecure)
+// Flags returns the command line flags for the config, one argument each.
+func (c TraceConfig) Flags() []string {
+	return []string{
+		"--trace-endpoint=" + c.Endpoint,
+		"--trace-sample-rate=" + strconv.FormatFloat(c.SampleRate, 'f', -1, 64),
+		"--trace-insecure=" + strconv.FormatBool(c.Insecure),
+		"--trace-protocol=" + c.Protocol,
+		"--trace-service-name=" + c.ServiceName,
+		"--trace-resource-attributes=" + c.ResourceAttributes,
+	}
 }
 
+// TraceFlags adds the tracing flags to the flagset.
+// The defaults are taken from the standard OTEL_* environment variables.
 func TraceFlags(fl *flag.FlagSet) *TraceConfig {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	if protocol == "" {
+		protocol = traceProtocolGRPC
+	}
+	sampleRate := 1.0
+	if v, err := strconv.ParseFloat(os.Getenv("OTEL_TRACES_SAMPLER_ARG"), 64); err == nil {
+		sampleRate = v
+	}
+	insecure, _ := strconv.ParseBool(os.Getenv("OTEL_EXPORTER_OTLP_INSECURE"))
+
 	var cfg TraceConfig
-	fl.StringVar(&cfg.Endpoint, "trace-endpoint", "", "set the otlp endpoint for the agent to send trace data to")
-	fl.Float64Var(&cfg.SampleRate, "trace-sample-rate", 1.0, "set the sampling rate for the trace exporter")
-	fl.BoolVar(&cfg.Insecure, "trace-insecure", false, "allow traces to be sent to insecure endpoint")
+	fl.StringVar(&cfg.Endpoint, "trace-endpoint", endpoint, "set the otlp endpoint for the agent to send trace data to")
+	fl.Float64Var(&cfg.SampleRate, "trace-sample-rate", sampleRate, "set the sampling rate for the trace exporter")
+	fl.BoolVar(&cfg.Insecure, "trace-insecure", insecure, "allow traces to be sent to insecure endpoint")
+	fl.StringVar(&cfg.Protocol, "trace-protocol", protocol, "otlp protocol to export traces with (grpc, http/protobuf or none)")
+	fl.StringVar(&cfg.ServiceName, "trace-service-name", os.Getenv("OTEL_SERVICE_NAME"), "service name to report traces as (default "+shimName+")")
+	fl.StringVar(&cfg.ResourceAttributes, "trace-resource-attributes", os.Getenv("OTEL_RESOURCE_ATTRIBUTES"), "extra resource attributes for traces (key1=value1,key2=value2)")
 	return &cfg
 }
 
 func ConfigureTracing(ctx context.Context, cfg *TraceConfig) (func(context.Context), error) {
-	if cfg.Endpoint == "" {
+	if cfg.Endpoint == "" || cfg.Protocol == traceProtocolNone {
 		return func(context.Context) {}, nil
 	}
 
-	opts := []grpc.DialOption{
-		grpc.WithBlock(),
-	}
-	if cfg.Insecure {
-		opts = append(opts, grpc.WithInsecure())
+	exp, err := newTraceExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	exp, err := otlptracegrpc.New(ctx,
-		otlptracegrpc.WithEndpoint(cfg.Endpoint),
-		otlptracegrpc.WithDialOption(opts...),
-	)
+	attrs, err := parseResourceAttributes(cfg.ResourceAttributes)
 	if err != nil {
-		return nil, fmt.Errorf("error setting up otel exporter")
+		return nil, err
+	}
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = shimName
 	}
+	attrs = append(attrs, semconv.ServiceNameKey.String(serviceName), semconv.ServiceVersionKey.String(shimVersion()))
 
-	res, err := resource.New(ctx, resource.WithAttributes(semconv.ServiceNameKey.String(shimName)))
+	res, err := resource.New(ctx, resource.WithAttributes(attrs...))
 	if err != nil {
 		return nil, err
 	}
 
 	provider := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(cfg.SampleRate)),
+		// Follow the sampling decision of the caller (i.e. containerd) when there is one.
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRate))),
 		sdktrace.WithResource(res),
 		sdktrace.WithSpanProcessor(sdktrace.NewBatchSpanProcessor(exp)),
 	)
@@ -88,6 +141,88 @@ func ConfigureTracing(ctx context.Context, cfg *TraceConfig) (func(context.Conte
 	}, nil
 }
 
+// newTraceExporter creates the exporter for the configured protocol.
+// The endpoint may be a host:port or a URL like in OTEL_EXPORTER_OTLP_ENDPOINT, an http:// URL implies an insecure
+// connection.
+func newTraceExporter(ctx context.Context, cfg *TraceConfig) (sdktrace.SpanExporter, error) {
+	endpoint := cfg.Endpoint
+	insecure := cfg.Insecure
+	var path string
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" && (u.Scheme == "http" || u.Scheme == "https") {
+		endpoint = u.Host
+		path = u.Path
+		if u.Scheme == "http" {
+			insecure = true
+		}
+	}
+
+	switch cfg.Protocol {
+	case traceProtocolGRPC:
+		opts := []grpc.DialOption{
+			grpc.WithBlock(),
+		}
+		if insecure {
+			opts = append(opts, grpc.WithInsecure())
+		}
+
+		exp, err := otlptracegrpc.New(ctx,
+			otlptracegrpc.WithEndpoint(endpoint),
+			otlptracegrpc.WithDialOption(opts...),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up otel exporter: %w", err)
+		}
+		return exp, nil
+	case traceProtocolHTTP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+		if path != "" && path != "/" {
+			opts = append(opts, otlptracehttp.WithURLPath(path))
+		}
+		if insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+
+		exp, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up otel exporter: %w", err)
+		}
+		return exp, nil
+	default:
+		return nil, fmt.Errorf("unsupported trace protocol %q: %w", cfg.Protocol, errdefs.ErrInvalidArgument)
+	}
+}
+
+// parseResourceAttributes parses resource attributes in the OTEL_RESOURCE_ATTRIBUTES format.
+func parseResourceAttributes(s string) ([]attribute.KeyValue, error) {
+	var attrs []attribute.KeyValue
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid resource attribute %q: %w", kv, errdefs.ErrInvalidArgument)
+		}
+		k, v := parts[0], parts[1]
+		if uv, err := url.PathUnescape(v); err == nil {
+			v = uv
+		}
+		attrs = append(attrs, attribute.String(strings.TrimSpace(k), strings.TrimSpace(v)))
+	}
+	return attrs, nil
+}
+
+// traceIDFromContext returns the ID of the trace in ctx, which may have been propagated from containerd.
+// Returns an empty string if there is no trace.
+func traceIDFromContext(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
 func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
 	return otel.Tracer("").Start(ctx, name, opts...)
 }
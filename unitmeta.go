@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/coreos/go-systemd/unit"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+)
+
+// nerdctlNameAnnotation is the name nerdctl gave the container, containerd has no names for containers itself.
+const nerdctlNameAnnotation = "nerdctl/name"
+
+// projectURL is the first Documentation= entry of the container units, the second is the bundle of the container.
+// systemd only takes http(s), file, info and man URIs for Documentation=, so there is no containerd:// one.
+const projectURL = "https://github.com/cpuguy83/containerd-shim-systemd-v1"
+
+// Fields in the [Unit] section of container and exec unit files saying which container the unit belongs to, so tools
+// reading the unit (systemctl cat, systemctl show -p X-...) don't have to parse the unit name.
+// systemd doesn't take unknown fields over D-Bus, transient units only have the CONTAINER_* environment variables.
+const (
+	unitNamespaceField = "X-Containerd-Namespace"
+	unitIDField        = "X-Containerd-ID"
+	unitExecIDField    = "X-Containerd-Exec-ID"
+	// unitNameField is the name of the container, see containerName.
+	unitNameField = "X-Containerd-Name"
+
+	// containerExecIDEnv is set in exec units next to CONTAINER_ID and CONTAINER_NAMESPACE.
+	containerExecIDEnv = "CONTAINER_EXEC_ID"
+)
+
+func metadataAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	opts.Name = annotations[nerdctlNameAnnotation]
+	return nil
+}
+
+// containerDescription returns the unit description of the container without the trace.
+func (p *initProcess) containerDescription() string {
+	desc := "containerd container " + p.ns + "/" + p.id
+	if p.opts.Name != "" {
+		desc += " (" + p.opts.Name + ")"
+	}
+	return p.opts.CRI.description(desc)
+}
+
+// containerName returns a name for the container that doesn't change when it is recreated: the name nerdctl gave it,
+// the container name (or pod name for the sandbox) of CRI containers, the ID otherwise.
+func (p *initProcess) containerName() string {
+	switch {
+	case p.opts.Name != "":
+		return p.opts.Name
+	case p.opts.CRI.Type == criContainerTypeSandbox && p.opts.CRI.SandboxName != "":
+		return p.opts.CRI.SandboxName
+	case p.opts.CRI.Name != "":
+		return p.opts.CRI.Name
+	}
+	return p.id
+}
+
+// metadataOptions returns the Documentation= and X-Containerd-* options of the unit of the container, or of the exec
+// if execID is set.
+func (p *process) metadataOptions(bundle, id, execID string) []*unit.UnitOption {
+	const u = "Unit"
+
+	opts := []*unit.UnitOption{
+		unit.NewUnitOption(u, "Documentation", projectURL),
+		unit.NewUnitOption(u, "Documentation", "file://"+bundle),
+		unit.NewUnitOption(u, unitNamespaceField, p.ns),
+		unit.NewUnitOption(u, unitIDField, id),
+	}
+	if execID != "" {
+		opts = append(opts, unit.NewUnitOption(u, unitExecIDField, execID))
+	}
+	return opts
+}
+
+// unitOwner is the container, and exec, a unit belongs to.
+type unitOwner struct {
+	Namespace string
+	ID        string
+	ExecID    string `json:",omitempty"`
+}
+
+// unitOwnerFromOptions finds the owner of a unit in its options.
+// The X-Containerd-* fields are preferred, units created before they were added and transient units only have the
+// environment.
+func unitOwnerFromOptions(opts []*unit.UnitOption) unitOwner {
+	var fields, env unitOwner
+	for _, o := range opts {
+		switch o.Name {
+		case unitNamespaceField:
+			fields.Namespace = o.Value
+		case unitIDField:
+			fields.ID = o.Value
+		case unitExecIDField:
+			fields.ExecID = o.Value
+		case "Environment":
+			k, v := splitEnv(o.Value)
+			switch k {
+			case "CONTAINER_NAMESPACE":
+				env.Namespace = v
+			case "CONTAINER_ID":
+				env.ID = v
+			case containerExecIDEnv:
+				env.ExecID = v
+			}
+		}
+	}
+	if fields.ID != "" {
+		return fields
+	}
+	return env
+}
+
+func splitEnv(s string) (string, string) {
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// readUnitFile returns the options of the unit file of a container unit, in unit file or transient mode, and the
+// path of the file. The path is empty if there is no file.
+func readUnitFile(name string) ([]*unit.UnitOption, string, error) {
+	for _, p := range []string{filepath.Join(runtimeUnitDir(), name), filepath.Join(transientUnitDir(), name)} {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		opts, err := unit.Deserialize(f)
+		f.Close()
+		if err != nil {
+			return nil, "", err
+		}
+		return opts, p, nil
+	}
+	return nil, "", nil
+}
+
+type unitTypePropertyGetter interface {
+	GetUnitTypePropertyContext(ctx context.Context, unit string, unitType string, propertyName string) (*systemd.Property, error)
+}
+
+// lookupUnitOwner returns the container the named unit belongs to.
+// The unit file is read if there is one, otherwise the environment of the unit is asked for over D-Bus.
+func lookupUnitOwner(ctx context.Context, conn unitTypePropertyGetter, name string) (unitOwner, error) {
+	if !strings.Contains(name, ".") {
+		name += ".service"
+	}
+
+	opts, _, err := readUnitFile(name)
+	if err != nil {
+		return unitOwner{}, fmt.Errorf("error reading unit file of %s: %w", name, err)
+	}
+	if opts == nil {
+		prop, err := conn.GetUnitTypePropertyContext(ctx, name, "Service", "Environment")
+		if err != nil {
+			return unitOwner{}, fmt.Errorf("error getting environment of %s: %w", name, err)
+		}
+		env, _ := prop.Value.Value().([]string)
+		for _, e := range env {
+			opts = append(opts, unit.NewUnitOption("Service", "Environment", e))
+		}
+	}
+
+	owner := unitOwnerFromOptions(opts)
+	if owner.ID == "" || owner.Namespace == "" {
+		return unitOwner{}, fmt.Errorf("unit %s does not belong to a container: %w", name, errdefs.ErrNotFound)
+	}
+	return owner, nil
+}
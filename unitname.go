@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+const (
+	// defaultUnitNameTemplate is the unit naming scheme used when --unit-name-template is not set.
+	// Containers created before the template was configurable have no template recorded and use it too.
+	defaultUnitNameTemplate = unitPrefix + "{ns}-{id}-{mod}.service"
+
+	unitNameNS  = "{ns}"
+	unitNameID  = "{id}"
+	unitNameMod = "{mod}"
+)
+
+// validateUnitNameTemplate checks a template set with --unit-name-template.
+//
+// The template must include {ns} and {id}, so units of containers with the same id in different namespaces don't
+// collide, and end in ".service". {mod} is the kind of unit (init, exec, tty...), it is appended before ".service"
+// when it is left out and must end the name as "-{mod}.service" otherwise: the shim tells container units from the
+// others by the suffix. The template must start with a fixed prefix, which is how the shim finds its units when
+// collecting orphans, so it should not be shared with other units on the host.
+func validateUnitNameTemplate(t string) error {
+	if t == "" {
+		return nil
+	}
+	if !strings.HasSuffix(t, ".service") {
+		return fmt.Errorf("unit name template %q must end in .service: %w", t, errdefs.ErrInvalidArgument)
+	}
+	for _, v := range []string{unitNameNS, unitNameID} {
+		if strings.Count(t, v) != 1 {
+			return fmt.Errorf("unit name template %q must contain %s once: %w", t, v, errdefs.ErrInvalidArgument)
+		}
+	}
+	if n := strings.Count(t, unitNameMod); n > 1 || (n == 1 && !strings.HasSuffix(t, "-"+unitNameMod+".service")) {
+		return fmt.Errorf("unit name template %q must end in -%s.service if it contains %s: %w", t, unitNameMod, unitNameMod, errdefs.ErrInvalidArgument)
+	}
+	if unitNameTemplatePrefix(t) == "" {
+		return fmt.Errorf("unit name template %q must start with a fixed prefix: %w", t, errdefs.ErrInvalidArgument)
+	}
+
+	literal := strings.NewReplacer(unitNameNS, "", unitNameID, "", unitNameMod, "").Replace(t)
+	for _, c := range literal {
+		if !validUnitNameChar(c) {
+			return fmt.Errorf("invalid character %q in unit name template %q: %w", c, t, errdefs.ErrInvalidArgument)
+		}
+	}
+	if strings.ContainsAny(literal, "{}") {
+		return fmt.Errorf("unknown placeholder in unit name template %q: %w", t, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// unitNameTemplatePrefix returns the fixed part of the template before the first placeholder.
+func unitNameTemplatePrefix(t string) string {
+	if t == "" {
+		t = defaultUnitNameTemplate
+	}
+	if i := strings.Index(t, "{"); i >= 0 {
+		return t[:i]
+	}
+	return t
+}
+
+// unitName returns the name of a unit of the container with the template, the default template is used if it is empty.
+// mod is the kind of unit and must not be empty.
+func unitName(tmpl, ns, id, mod string) string {
+	if tmpl == "" {
+		tmpl = defaultUnitNameTemplate
+	}
+	if !strings.Contains(tmpl, unitNameMod) {
+		tmpl = strings.TrimSuffix(tmpl, ".service") + "-" + unitNameMod + ".service"
+	}
+	return strings.NewReplacer(
+		unitNameNS, escapeUnitNamePart(ns),
+		unitNameID, escapeUnitNamePart(id),
+		unitNameMod, escapeUnitNamePart(mod),
+	).Replace(tmpl)
+}
+
+func validUnitNameChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == ':', c == '_', c == '.', c == '-':
+		return true
+	}
+	return false
+}
+
+// escapeUnitNamePart escapes the characters systemd doesn't allow in unit names as \xNN.
+// containerd namespaces and ids are already restricted to valid characters, so existing names are not changed.
+// Unlike systemd-escape "-" is kept as is, it is what separates the parts of the default names.
+func escapeUnitNamePart(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x80 && validUnitNameChar(rune(c)) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, `\x%02x`, c)
+	}
+	return b.String()
+}
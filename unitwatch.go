@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	dbus "github.com/godbus/dbus/v5"
+)
+
+const (
+	sdUnitPathPrefix = sdBusPath + "/unit/"
+	sdServiceIface   = sdBusName + ".Service"
+	sdUnitIface      = sdBusName + ".Unit"
+
+	// Values of ExecMainCode, these are the CLD_* codes from waitid(2).
+	cldExited = 1
+	cldKilled = 2
+	cldDumped = 3
+)
+
+// watchUnitChanges subscribes to changes of the units so exits are picked up as soon as systemd sees them, instead of
+// waiting for the next poll of the unit states.
+//
+// Exec exits are taken straight from the service properties. Any unit that becomes inactive or is removed, including
+// when it is stopped outside of the shim with `systemctl stop`, is reconciled, see reconcileUnit.
+// The signals are matched as narrowly as we can since subscribing to all systemd events is very noisy, see
+// unitManager.Watch.
+func (s *Service) watchUnitChanges(ctx context.Context) error {
+	ctx = withLogSubsystem(ctx, logDBus)
+	// The bus changes when the connection is re-established, this is called again then, see watchUnits.
+	bus := s.conn.Bus()
+
+	if err := callManager(ctx, s.conn, "Subscribe").Err; err != nil {
+		return err
+	}
+
+	for _, iface := range []string{sdServiceIface, sdUnitIface} {
+		err := bus.AddMatchSignalContext(ctx,
+			dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+			dbus.WithMatchMember("PropertiesChanged"),
+			dbus.WithMatchPathNamespace(dbus.ObjectPath(strings.TrimSuffix(sdUnitPathPrefix, "/"))),
+			dbus.WithMatchArg(0, iface),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	err := bus.AddMatchSignalContext(ctx,
+		dbus.WithMatchObjectPath(sdBusPath),
+		dbus.WithMatchInterface(sdBusManager),
+		dbus.WithMatchMember("UnitRemoved"),
+	)
+	if err != nil {
+		return err
+	}
+	// systemd re-executing (daemon-reexec) shows up as a new owner of its bus name.
+	err = bus.AddMatchSignalContext(ctx,
+		dbus.WithMatchInterface("org.freedesktop.DBus"),
+		dbus.WithMatchMember("NameOwnerChanged"),
+		dbus.WithMatchArg(0, sdBusName),
+	)
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan *dbus.Signal, 128)
+	bus.Signal(ch)
+
+	go func() {
+		defer bus.RemoveSignal(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-bus.Context().Done():
+				return
+			case sig := <-ch:
+				s.handleUnitSignal(ctx, sig)
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *Service) handleUnitSignal(ctx context.Context, sig *dbus.Signal) {
+	switch sig.Name {
+	case "org.freedesktop.DBus.NameOwnerChanged":
+		if len(sig.Body) < 3 {
+			return
+		}
+		if owner, _ := sig.Body[2].(string); owner == "" {
+			return
+		}
+		// systemd is back after re-executing, the subscription may not have survived and we may have missed exits.
+		log.G(ctx).Info("systemd restarted, re-subscribing to unit changes")
+		var dErr dbus.Error
+		if err := callManager(ctx, s.conn, "Subscribe").Err; err != nil && !(errors.As(err, &dErr) && dErr.Name == sdBusName+".AlreadySubscribed") {
+			log.G(ctx).WithError(err).Warn("Error re-subscribing to unit changes")
+		}
+		s.units.Refresh()
+	case sdBusManager + ".UnitRemoved":
+		if len(sig.Body) < 1 {
+			return
+		}
+		name, _ := sig.Body[0].(string)
+		s.reconcileUnit(ctx, name)
+	case "org.freedesktop.DBus.Properties.PropertiesChanged":
+		if len(sig.Body) < 2 {
+			return
+		}
+		switch iface, _ := sig.Body[0].(string); iface {
+		case sdServiceIface:
+			s.handleExecSignal(ctx, sig)
+		case sdUnitIface:
+			changed, _ := sig.Body[1].(map[string]dbus.Variant)
+			v, ok := changed["ActiveState"]
+			if !ok {
+				return
+			}
+			switch state, _ := v.Value().(string); state {
+			case "inactive", "failed":
+				s.reconcileUnit(ctx, unitNameFromPath(sig.Path))
+			case "active":
+				if p, ok := s.units.Get(unitNameFromPath(sig.Path)).(*initProcess); ok {
+					p.checkRestart(ctx)
+				}
+			}
+		}
+	}
+}
+
+func (s *Service) handleExecSignal(ctx context.Context, sig *dbus.Signal) {
+
+	name := unitNameFromPath(sig.Path)
+	if !strings.HasSuffix(name, "-exec.service") {
+		return
+	}
+	p, ok := s.units.Get(name).(*execProcess)
+	if !ok || p.ProcessState().Exited() {
+		return
+	}
+
+	changed, _ := sig.Body[1].(map[string]dbus.Variant)
+	codeV, ok := changed["ExecMainCode"]
+	if !ok {
+		return
+	}
+	code, _ := codeV.Value().(int32)
+	if code == 0 {
+		// Still running
+		return
+	}
+
+	var status int32
+	if v, ok := changed["ExecMainStatus"]; ok {
+		status, _ = v.Value().(int32)
+	}
+
+	st := pState{
+		ExitCode: execMainExitCode(code, status),
+		ExitedAt: time.Now(),
+		Status:   "exited",
+	}
+	if v, ok := changed["ExecMainPID"]; ok {
+		pid, _ := v.Value().(uint32)
+		st.Pid = pid
+	}
+	if v, ok := changed["ExecMainExitTimestamp"]; ok {
+		if ts, _ := v.Value().(uint64); ts > 0 {
+			st.ExitedAt = time.UnixMicro(int64(ts))
+		}
+	}
+
+	ctx = log.WithLogger(ctx, log.G(ctx).WithField("unit", name))
+	ctx = WithShimLog(ctx, p.LogWriter())
+	l := log.G(ctx).WithField("code", st.ExitCode)
+	if code == cldKilled || code == cldDumped {
+		l = l.WithField("signal", signalName(int(status)))
+	}
+	l.Debug("Exec exited")
+	p.SetState(ctx, st)
+}
+
+// execMainExitCode converts ExecMainCode and ExecMainStatus to an exit code the way a shell would,
+// processes killed by a signal get 128 + the signal number.
+func execMainExitCode(code, status int32) uint32 {
+	switch code {
+	case cldKilled, cldDumped:
+		return uint32(128 + status)
+	default:
+		return uint32(status)
+	}
+}
+
+// unitNameFromPath reverses the escaping systemd applies to unit names in object paths.
+func unitNameFromPath(p dbus.ObjectPath) string {
+	s := string(p)
+	if !strings.HasPrefix(s, sdUnitPathPrefix) {
+		return ""
+	}
+	s = strings.TrimPrefix(s, sdUnitPathPrefix)
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '_' && i+2 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/cgroups"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/coreos/go-systemd/unit"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Update applies new resource limits to the container.
+//
+// Limits are set on the unit so they show up in systemctl and are enforced for everything in the unit.
+// runc is also given the full set of resources since it manages the container's own cgroup underneath the unit,
+// and it handles things systemd can't express (e.g. device rules, blkio throttling).
+// Huge page limits are neither on the unit nor updated by runc, see updateHugetlb.
+// Without that raising a limit would still be capped by the stale value in the container cgroup.
+func (p *initProcess) Update(ctx context.Context, res specs.LinuxResources) error {
+	if err := validateHugepageLimits(res.HugepageLimits); err != nil {
+		return err
+	}
+
+	props, err := resourceProperties(&res, cgroups.Mode() == cgroups.Unified)
+	if err != nil {
+		return err
+	}
+
+	if len(props) > 0 {
+		if err := p.systemd.SetUnitPropertiesContext(ctx, p.Name(), true, props...); err != nil {
+			return fmt.Errorf("error setting unit properties: %w", err)
+		}
+		log.G(ctx).WithField("properties", len(props)).Debug("Updated unit resources")
+	}
+
+	if err := runcCall(ctx, func(ctx context.Context) error { return p.runc.Update(ctx, p.id, &res) }); err != nil {
+		return p.runtimeError(ctx, err)
+	}
+	return p.updateHugetlb(ctx, res.HugepageLimits)
+}
+
+// resourceProperties converts the OCI resources to systemd unit properties.
+// Only resources that systemd can represent are returned.
+func resourceProperties(res *specs.LinuxResources, unified bool) ([]systemd.Property, error) {
+	var props []systemd.Property
+
+	if mem := res.Memory; mem != nil {
+		if mem.Limit != nil {
+			name := "MemoryLimit"
+			if unified {
+				name = "MemoryMax"
+			}
+			props = append(props, systemd.Property{Name: name, Value: dbus.MakeVariant(limitValue(*mem.Limit))})
+		}
+		if mem.Reservation != nil && unified {
+			props = append(props, systemd.Property{Name: "MemoryLow", Value: dbus.MakeVariant(limitValue(*mem.Reservation))})
+		}
+		if unified {
+			swap, ok, err := swapMax(mem)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				props = append(props, systemd.Property{Name: "MemorySwapMax", Value: dbus.MakeVariant(swap)})
+			}
+		}
+	}
+
+	if cpu := res.CPU; cpu != nil {
+		if cpu.Shares != nil && *cpu.Shares > 0 {
+			if unified {
+				props = append(props, systemd.Property{Name: "CPUWeight", Value: dbus.MakeVariant(sharesToWeight(*cpu.Shares))})
+			} else {
+				props = append(props, systemd.Property{Name: "CPUShares", Value: dbus.MakeVariant(*cpu.Shares)})
+			}
+		}
+
+		if cpu.Quota != nil {
+			period := uint64(100000)
+			if cpu.Period != nil && *cpu.Period > 0 {
+				period = *cpu.Period
+				props = append(props, systemd.Property{Name: "CPUQuotaPeriodUSec", Value: dbus.MakeVariant(period)})
+			}
+			quota := uint64(math.MaxUint64)
+			if *cpu.Quota > 0 {
+				// systemd wants the quota as the amount of cpu time per second
+				quota = uint64(*cpu.Quota) * 1000000 / period
+				// systemd rounds to 10ms, make sure we don't end up with less than what was asked for.
+				if quota%10000 != 0 {
+					quota = (quota/10000 + 1) * 10000
+				}
+			}
+			props = append(props, systemd.Property{Name: "CPUQuotaPerSecUSec", Value: dbus.MakeVariant(quota)})
+		}
+
+		if unified {
+			if cpu.Cpus != "" {
+				mask, err := parseCPUSet(cpu.Cpus)
+				if err != nil {
+					return nil, fmt.Errorf("invalid cpuset %q: %w", cpu.Cpus, err)
+				}
+				props = append(props, systemd.Property{Name: "AllowedCPUs", Value: dbus.MakeVariant(mask)})
+			}
+			if cpu.Mems != "" {
+				mask, err := parseCPUSet(cpu.Mems)
+				if err != nil {
+					return nil, fmt.Errorf("invalid memory nodes %q: %w", cpu.Mems, err)
+				}
+				props = append(props, systemd.Property{Name: "AllowedMemoryNodes", Value: dbus.MakeVariant(mask)})
+			}
+		}
+	}
+
+	if pids := res.Pids; pids != nil && pids.Limit != 0 {
+		props = append(props, systemd.Property{Name: "TasksMax", Value: dbus.MakeVariant(limitValue(pids.Limit))})
+	}
+
+	return props, nil
+}
+
+// resourceOptions converts the resources in the spec to unit options for the container unit.
+// Most limits are left to runc when the container is created, these are the ones that need to be on the unit.
+func resourceOptions(spec *specs.Spec, unified bool) ([]*unit.UnitOption, error) {
+	opts := deviceOptions(spec)
+	if spec.Linux == nil || spec.Linux.Resources == nil {
+		return opts, nil
+	}
+
+	if mem := spec.Linux.Resources.Memory; mem != nil && unified {
+		swap, ok, err := swapMax(mem)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			v := "infinity"
+			if swap != math.MaxUint64 {
+				v = strconv.FormatUint(swap, 10)
+			}
+			opts = append(opts, unit.NewUnitOption("Service", "MemorySwapMax", v))
+		}
+	}
+
+	// systemd only manages cpusets on cgroup v2.
+	// Pinning the unit keeps the shim's helpers on the same cpus and makes the pinning visible in systemctl.
+	if cpu := spec.Linux.Resources.CPU; cpu != nil && unified {
+		if cpu.Cpus != "" {
+			if _, err := parseCPUSet(cpu.Cpus); err != nil {
+				return nil, fmt.Errorf("invalid cpuset %q: %w", cpu.Cpus, err)
+			}
+			opts = append(opts, unit.NewUnitOption("Service", "AllowedCPUs", cpu.Cpus))
+		}
+		if cpu.Mems != "" {
+			if _, err := parseCPUSet(cpu.Mems); err != nil {
+				return nil, fmt.Errorf("invalid memory nodes %q: %w", cpu.Mems, err)
+			}
+			opts = append(opts, unit.NewUnitOption("Service", "AllowedMemoryNodes", cpu.Mems))
+		}
+	}
+	return opts, nil
+}
+
+// swapMax converts the OCI swap limit to the value for MemorySwapMax, ok is false if there is nothing to set.
+// The OCI limit is for memory+swap like memory.memsw on cgroup v1, cgroup v2 limits swap on its own.
+// This is the same conversion runc does for memory.swap.max. On cgroup v1 runc sets memsw on the container's cgroup,
+// systemd has no property for it.
+func swapMax(mem *specs.LinuxMemory) (_ uint64, ok bool, _ error) {
+	if mem.Swap == nil {
+		return 0, false, nil
+	}
+	swap := *mem.Swap
+	limit := int64(0)
+	if mem.Limit != nil {
+		limit = *mem.Limit
+	}
+
+	switch {
+	case swap == 0 && limit == -1:
+		// Unlimited memory without a swap limit means unlimited swap too.
+		return math.MaxUint64, true, nil
+	case swap == 0:
+		return 0, false, nil
+	case swap == -1:
+		return math.MaxUint64, true, nil
+	case limit <= 0:
+		return 0, false, fmt.Errorf("swap limit requires a memory limit: %w", errdefs.ErrInvalidArgument)
+	case swap < limit:
+		return 0, false, fmt.Errorf("memory+swap limit %d is lower than the memory limit %d: %w", swap, limit, errdefs.ErrInvalidArgument)
+	}
+	return uint64(swap - limit), true, nil
+}
+
+// limitValue converts an OCI limit, where a negative value means unlimited, to the systemd representation.
+func limitValue(v int64) uint64 {
+	if v < 0 {
+		return math.MaxUint64
+	}
+	return uint64(v)
+}
+
+// Bounds of cgroup v1 cpu shares and of the cgroup v2 cpu weight (CPUWeight=).
+const (
+	minCPUShares = 2
+	maxCPUShares = 262144
+	minCPUWeight = 1
+	maxCPUWeight = 10000
+)
+
+// sharesToWeight converts cgroup v1 cpu shares to a cgroup v2 cpu weight.
+// This is the same conversion runc uses, shares out of range are clamped first so the weight is always one systemd
+// accepts.
+func sharesToWeight(shares uint64) uint64 {
+	if shares == 0 {
+		return 0
+	}
+	if shares < minCPUShares {
+		shares = minCPUShares
+	}
+	if shares > maxCPUShares {
+		shares = maxCPUShares
+	}
+	weight := 1 + ((shares-2)*9999)/262142
+	if weight < minCPUWeight {
+		weight = minCPUWeight
+	}
+	if weight > maxCPUWeight {
+		weight = maxCPUWeight
+	}
+	return weight
+}
+
+// parseCPUSet converts a cpuset list (e.g. "0-3,7") into the bitmask format systemd uses for AllowedCPUs and AllowedMemoryNodes.
+func parseCPUSet(s string) ([]byte, error) {
+	var mask []byte
+	set := func(i int) {
+		for len(mask) <= i/8 {
+			mask = append(mask, 0)
+		}
+		mask[i/8] |= 1 << (i % 8)
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		start, end := part, part
+		if i := strings.IndexByte(part, '-'); i >= 0 {
+			start, end = part[:i], part[i+1:]
+		}
+		lo, err := strconv.Atoi(start)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errdefs.ErrInvalidArgument, err)
+		}
+		hi, err := strconv.Atoi(end)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errdefs.ErrInvalidArgument, err)
+		}
+		if lo < 0 || hi < lo {
+			return nil, fmt.Errorf("%w: invalid range %q", errdefs.ErrInvalidArgument, part)
+		}
+		for i := lo; i <= hi; i++ {
+			set(i)
+		}
+	}
+	return mask, nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestSharesToWeight(t *testing.T) {
+	for _, tc := range []struct {
+		shares, weight uint64
+	}{
+		{0, 0},
+		{1, 1},
+		{2, 1},
+		{1024, 39},
+		{262144, 10000},
+		{1 << 20, 10000},
+	} {
+		if got := sharesToWeight(tc.shares); got != tc.weight {
+			t.Errorf("sharesToWeight(%d) = %d, want %d", tc.shares, got, tc.weight)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/coreos/go-systemd/unit"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Annotations to run the container unit as another user.
+// They take precedence over the create options.
+const (
+	userAnnotation        = shimName + ".user"
+	groupAnnotation       = shimName + ".group"
+	dynamicUserAnnotation = shimName + ".dynamic-user"
+)
+
+// chownOption is added to the rootfs mount instead of idmapping it when the kernel does not support idmapped mounts.
+// The files in the rootfs are chowned to the host ids of the mappings the first time it is mounted.
+const chownOption = "x-shim.chown"
+
+// rootfsChownedName is the file in the bundle marking that the rootfs was chowned, so it is only done once.
+const rootfsChownedName = "rootfs.chowned"
+
+// userAnnotations applies the unit user settings from the container annotations to the create options.
+func userAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	if v := annotations[userAnnotation]; v != "" {
+		opts.User = v
+	}
+	if v := annotations[groupAnnotation]; v != "" {
+		opts.Group = v
+	}
+	if v := annotations[dynamicUserAnnotation]; v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("annotation %s: invalid value %q: %w", dynamicUserAnnotation, v, errdefs.ErrInvalidArgument)
+		}
+		opts.DynamicUser = b
+	}
+
+	for _, v := range []string{opts.User, opts.Group} {
+		if strings.ContainsAny(v, " \t\n\r") {
+			return fmt.Errorf("invalid user or group %q: %w", v, errdefs.ErrInvalidArgument)
+		}
+	}
+	if opts.DynamicUser && opts.User == "" {
+		// systemd picks a name based on the unit otherwise, which is too long for most of ours.
+		return fmt.Errorf("dynamic user requires a user name: %w", errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// unitUser returns true if the container unit runs as another user than the shim.
+func (c CreateOptions) unitUser() bool {
+	return c.User != "" || c.Group != "" || c.DynamicUser
+}
+
+// userOptions are the unit options to run the unit as the user from the create options.
+func (p *process) userOptions() []*unit.UnitOption {
+	const svc = "Service"
+
+	var opts []*unit.UnitOption
+	if p.opts.User != "" {
+		opts = append(opts, unit.NewUnitOption(svc, "User", p.opts.User))
+	}
+	if p.opts.Group != "" {
+		opts = append(opts, unit.NewUnitOption(svc, "Group", p.opts.Group))
+	}
+	if p.opts.DynamicUser {
+		opts = append(opts, unit.NewUnitOption(svc, "DynamicUser", "yes"))
+	}
+	return opts
+}
+
+// privileged prefixes the command so it runs with full privileges when the unit runs as another user.
+// Our own helpers (mounting the rootfs, writing the exit state) need to be root.
+func (p *process) privileged(cmd string) string {
+	if !p.opts.unitUser() {
+		return cmd
+	}
+	return "+" + cmd
+}
+
+// userNamespace returns the uid and gid mappings of the container's user namespace, nil if it doesn't have one.
+func userNamespace(spec *specs.Spec) (uidMap, gidMap []specs.LinuxIDMapping) {
+	if spec.Linux == nil {
+		return nil, nil
+	}
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == specs.UserNamespace && ns.Path == "" {
+			return spec.Linux.UIDMappings, spec.Linux.GIDMappings
+		}
+	}
+	return nil, nil
+}
+
+// usernsRootfs sets up the rootfs mounts so the image files are owned by the container's user namespace.
+// The rootfs is idmapped when the kernel supports it, otherwise the files are chowned to the host ids.
+func usernsRootfs(ctx context.Context, mounts []*types.Mount, uidMap, gidMap []specs.LinuxIDMapping) []*types.Mount {
+	if len(mounts) == 0 || len(uidMap) == 0 || len(gidMap) == 0 {
+		return mounts
+	}
+
+	// The idmapping is applied to the rootfs once everything is mounted, the options are on the last mount.
+	last := mounts[len(mounts)-1]
+	for _, o := range last.Options {
+		if strings.HasPrefix(o, uidMapOption) {
+			// Already requested by the client.
+			return mounts
+		}
+	}
+	m := &types.Mount{Type: last.Type, Source: last.Source, Target: last.Target, Options: append([]string(nil), last.Options...)}
+	mounts = append(append([]*types.Mount(nil), mounts[:len(mounts)-1]...), m)
+
+	m.Options = append(m.Options, uidMapOption+formatIDMap(uidMap), gidMapOption+formatIDMap(gidMap))
+	if err := idmapSupported(m.Type); err != nil {
+		log.G(ctx).WithError(err).Info("Idmapped mounts are not supported, the rootfs will be chowned instead")
+		m.Options = append(m.Options, chownOption)
+	}
+	return mounts
+}
+
+// formatIDMap formats mappings for an idmap mount option, see parseIDMap.
+func formatIDMap(ls []specs.LinuxIDMapping) string {
+	s := make([]string, 0, len(ls))
+	for _, m := range ls {
+		s = append(s, fmt.Sprintf("%d:%d:%d", m.ContainerID, m.HostID, m.Size))
+	}
+	return strings.Join(s, ";")
+}
+
+// mapID maps a container id to the host id, ok is false if the id is not mapped.
+func mapID(ls []specs.LinuxIDMapping, id uint32) (_ uint32, ok bool) {
+	for _, m := range ls {
+		if id >= m.ContainerID && id-m.ContainerID < m.Size {
+			return m.HostID + id - m.ContainerID, true
+		}
+	}
+	return 0, false
+}
+
+// chownRootfs chowns all files in the rootfs to the host ids of the mappings.
+// This is the fallback for kernels without idmapped mounts, it is slow and copies up every file with overlay, but it
+// only has to be done once per container.
+func chownRootfs(rootfs, bundle string, uidMap, gidMap []specs.LinuxIDMapping) error {
+	marker := filepath.Join(bundle, rootfsChownedName)
+	if _, err := os.Stat(marker); err == nil {
+		return nil
+	}
+
+	err := filepath.Walk(rootfs, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		uid, uidOK := mapID(uidMap, st.Uid)
+		gid, gidOK := mapID(gidMap, st.Gid)
+		if !uidOK || !gidOK {
+			// Leave files that can't be represented in the container alone, they show up as the overflow id.
+			return nil
+		}
+		// Lchown clears setuid/setgid bits, restore them.
+		if err := os.Lchown(p, int(uid), int(gid)); err != nil {
+			return err
+		}
+		if info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 && info.Mode()&os.ModeSymlink == 0 {
+			return os.Chmod(p, info.Mode())
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error chowning rootfs: %w", err)
+	}
+	return os.WriteFile(marker, nil, 0600)
+}
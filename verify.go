@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+// verifyRootfsAnnotation enables checks of the rootfs mounts before the container unit is created.
+// The value is a comma separated list of:
+//   - "mounts": the sources of the mounts (overlay layers, bind sources, devices) exist and can be read
+//   - "fs-verity": every file in the read-only layers has fs-verity enabled
+//   - "dm-verity": the mounted devices are dm-verity devices
+//
+// Without it problems with the rootfs only show up when the unit fails to start, as an error from the runtime.
+const verifyRootfsAnnotation = shimName + ".verify-rootfs"
+
+const (
+	verifyMounts   = "mounts"
+	verifyFSVerity = "fs-verity"
+	verifyDMVerity = "dm-verity"
+
+	// dmVerityUUIDPrefix is the prefix of the device mapper uuid of devices set up by veritysetup.
+	dmVerityUUIDPrefix = "CRYPT-VERITY-"
+)
+
+func verifyAnnotations(annotations map[string]string, opts *CreateOptions) error {
+	v := annotations[verifyRootfsAnnotation]
+	if v == "" {
+		return nil
+	}
+	for _, check := range strings.Split(v, ",") {
+		check = strings.TrimSpace(check)
+		switch check {
+		case verifyMounts, verifyFSVerity, verifyDMVerity:
+			opts.VerifyRootfs = append(opts.VerifyRootfs, check)
+		default:
+			return fmt.Errorf("annotation %s: unknown check %q: %w", verifyRootfsAnnotation, check, errdefs.ErrInvalidArgument)
+		}
+	}
+	return nil
+}
+
+// verifyRootfs runs the rootfs checks requested for the container, see verifyRootfsAnnotation.
+func (p *initProcess) verifyRootfs(ctx context.Context) error {
+	if len(p.opts.VerifyRootfs) == 0 {
+		return nil
+	}
+
+	ctx, span := StartSpan(ctx, "InitProcess.VerifyRootfs")
+	defer span.End()
+
+	for _, check := range p.opts.VerifyRootfs {
+		var err error
+		switch check {
+		case verifyMounts:
+			err = verifyMountSources(p.Rootfs)
+		case verifyFSVerity:
+			err = verifyFSVerityLayers(p.Rootfs)
+		case verifyDMVerity:
+			err = verifyDMVerityDevices(p.Rootfs)
+		}
+		if err != nil {
+			return fmt.Errorf("rootfs verification (%s) failed: %w", check, err)
+		}
+		log.G(ctx).WithField("check", check).Debug("Verified rootfs")
+	}
+	return nil
+}
+
+// mountSources returns the paths a mount reads from, and the ones of those that are read-only.
+func mountSources(m *types.Mount) (all, readonly []string) {
+	switch m.Type {
+	case "overlay":
+		for _, o := range m.Options {
+			switch {
+			case strings.HasPrefix(o, "lowerdir="):
+				lower := strings.Split(strings.TrimPrefix(o, "lowerdir="), ":")
+				all = append(all, lower...)
+				readonly = append(readonly, lower...)
+			case strings.HasPrefix(o, "upperdir="):
+				all = append(all, strings.TrimPrefix(o, "upperdir="))
+			case strings.HasPrefix(o, "workdir="):
+				all = append(all, strings.TrimPrefix(o, "workdir="))
+			}
+		}
+	default:
+		// Only sources that are paths, e.g. not "tmpfs".
+		if filepath.IsAbs(m.Source) {
+			all = append(all, m.Source)
+			if hasOption(m.Options, "ro") {
+				readonly = append(readonly, m.Source)
+			}
+		}
+	}
+	return all, readonly
+}
+
+func verifyMountSources(mounts []*types.Mount) error {
+	for _, m := range mounts {
+		all, _ := mountSources(m)
+		for _, src := range all {
+			if err := checkReadable(src); err != nil {
+				return fmt.Errorf("%s mount source %s: %w", m.Type, src, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkReadable opens the file or directory and reads from it, which catches a missing or broken (e.g. stale or
+// unreadable) source.
+func checkReadable(p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errdefs.ErrFailedPrecondition, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errdefs.ErrFailedPrecondition, err)
+	}
+	if fi.IsDir() {
+		_, err = f.Readdirnames(1)
+	} else {
+		_, err = f.Read(make([]byte, 1))
+	}
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("%w: %v", errdefs.ErrFailedPrecondition, err)
+	}
+	return nil
+}
+
+// verifyFSVerityLayers checks that every regular file in the read-only sources of the rootfs is protected by fs-verity.
+// The kernel then verifies the file contents when they are read, so this only needs to check that it is enabled.
+func verifyFSVerityLayers(mounts []*types.Mount) error {
+	var n int
+	for _, m := range mounts {
+		_, readonly := mountSources(m)
+		for _, dir := range readonly {
+			n++
+			err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !d.Type().IsRegular() {
+					return nil
+				}
+				var stx unix.Statx_t
+				if err := unix.Statx(unix.AT_FDCWD, p, unix.AT_SYMLINK_NOFOLLOW, 0, &stx); err != nil {
+					return &os.PathError{Op: "statx", Path: p, Err: err}
+				}
+				if stx.Attributes_mask&unix.STATX_ATTR_VERITY == 0 {
+					return fmt.Errorf("the filesystem of %s does not report fs-verity: %w", p, errdefs.ErrNotImplemented)
+				}
+				if stx.Attributes&unix.STATX_ATTR_VERITY == 0 {
+					return fmt.Errorf("fs-verity is not enabled on %s: %w", p, errdefs.ErrFailedPrecondition)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if n == 0 {
+		return fmt.Errorf("rootfs has no read-only layers: %w", errdefs.ErrFailedPrecondition)
+	}
+	return nil
+}
+
+// verifyDMVerityDevices checks that the devices mounted for the rootfs are dm-verity devices.
+func verifyDMVerityDevices(mounts []*types.Mount) error {
+	var n int
+	for _, m := range mounts {
+		if !filepath.IsAbs(m.Source) {
+			continue
+		}
+		var st unix.Stat_t
+		if err := unix.Stat(m.Source, &st); err != nil {
+			return &os.PathError{Op: "stat", Path: m.Source, Err: err}
+		}
+		if st.Mode&unix.S_IFMT != unix.S_IFBLK {
+			continue
+		}
+		n++
+		dev := fmt.Sprintf("%d:%d", unix.Major(uint64(st.Rdev)), unix.Minor(uint64(st.Rdev)))
+		uuid, err := os.ReadFile(filepath.Join("/sys/dev/block", dev, "dm", "uuid"))
+		if err != nil || !strings.HasPrefix(string(uuid), dmVerityUUIDPrefix) {
+			return fmt.Errorf("%s (%s) is not a dm-verity device: %w", m.Source, dev, errdefs.ErrFailedPrecondition)
+		}
+	}
+	if n == 0 {
+		return fmt.Errorf("rootfs is not mounted from a device: %w", errdefs.ErrFailedPrecondition)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import "runtime/debug"
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = ""
+
+// shimVersion returns the version of the shim.
+// When no version was set at build time the module version is used, which is "(devel)" for local builds.
+func shimVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
@@ -62,8 +62,12 @@ func (s *Service) Wait(ctx context.Context, r *taskapi.WaitRequest) (retResp *ta
 		}
 	}
 
-	if err := p.LoadState(ctx); err != nil {
-		log.G(ctx).WithError(err).Warning("Error loading process state")
+	// Exits are pushed to the process by the unit watch, loading the state is only needed to catch up with anything
+	// that happened before.
+	if !p.ProcessState().Exited() {
+		if err := p.LoadState(ctx); err != nil {
+			log.G(ctx).WithError(err).Warning("Error loading process state")
+		}
 	}
 
 	st, err := p.Wait(ctx)
@@ -73,7 +77,11 @@ func (s *Service) Wait(ctx context.Context, r *taskapi.WaitRequest) (retResp *ta
 	log.G(ctx).Debugf("%+v", st)
 
 	if !st.ExitedAt.After(timeZero) {
-		getUnitState(ctx, s.conn, p.Name(), &st)
+		// Only the exit time is taken from the unit, the process state knows the exit code best.
+		var unitSt pState
+		if err := getUnitState(ctx, s.conn, p.Name(), &unitSt); err == nil {
+			st.ExitedAt = unitSt.ExitedAt
+		}
 	}
 
 	if !st.ExitedAt.After(timeZero) {
@@ -86,18 +94,32 @@ func (s *Service) Wait(ctx context.Context, r *taskapi.WaitRequest) (retResp *ta
 	}, nil
 }
 
+// waitForExit blocks until the process exited or was deleted, or ctx is done.
+// Any number of callers can wait at the same time, SetState wakes all of them up once the state changes, which for
+// out-of-band unit stops happens as soon as the D-Bus signal for it comes in, see watchUnitChanges.
 func (p *process) waitForExit(ctx context.Context) (pState, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Wake up the wait loop so it can return.
+			// The lock makes sure the waiter is either in cond.Wait or has yet to check ctx, so the wakeup isn't lost.
+			p.mu.Lock()
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		case <-done:
+		}
+	}()
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	for {
-		select {
-		case <-ctx.Done():
+		if err := ctx.Err(); err != nil {
 			log.G(ctx).Debug("wait: cancelled")
-			return pState{}, ctx.Err()
-		default:
+			return pState{}, err
 		}
-
 		if p.deleted {
 			log.G(ctx).Debug("wait: deleted")
 			break
@@ -116,15 +138,5 @@ func (p *process) waitForExit(ctx context.Context) (pState, error) {
 }
 
 func (p *process) Wait(ctx context.Context) (pState, error) {
-	ctx2, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	go func() {
-		<-ctx2.Done()
-		// wake up the wait loop so it can exit
-		// This will wake up every wait, but... there generally shouldn't be a lot of them.
-		// It's more impportant to unblock API calls that are cancelled.
-		p.cond.Broadcast()
-	}()
 	return p.waitForExit(ctx)
 }
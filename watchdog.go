@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+const (
+	// watchdogAnnotation can be set on a container to enable the systemd watchdog for it.
+	// The value is a time span as accepted by parseUnitDuration. It takes precedence over the create options.
+	watchdogAnnotation = shimName + ".watchdog-sec"
+
+	// watchdogEventTopic is the topic TaskWatchdog events are published on.
+	watchdogEventTopic = "/tasks/watchdog"
+
+	// serviceResultWatchdog is the value of $SERVICE_RESULT when systemd stopped the unit because of a watchdog timeout.
+	serviceResultWatchdog = "watchdog"
+)
+
+// parseWatchdog parses the watchdog timeout from a container annotation.
+func parseWatchdog(s string) (time.Duration, error) {
+	usec, err := parseUnitDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	d := time.Duration(usec) * time.Microsecond
+	if d < time.Second || d/time.Microsecond != time.Duration(usec) {
+		return 0, fmt.Errorf("invalid watchdog timeout %q: %w", s, errdefs.ErrInvalidArgument)
+	}
+	return d, nil
+}
+
+// watchdogUSec formats the watchdog timeout the way systemd passes it to services in $WATCHDOG_USEC.
+func watchdogUSec(d time.Duration) string {
+	return strconv.FormatInt(d.Microseconds(), 10)
+}